@@ -0,0 +1,6 @@
+// Package api contains the gRPC API of the server, generated from
+// samorozvrh.proto by protoc with the protoc-gen-go and protoc-gen-go-grpc
+// plugins: run go generate after changing it.
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative samorozvrh.proto
@@ -0,0 +1,1126 @@
+// The gRPC API of the Samorozvrh server, for programs which want typed
+// clients rather than the JSON API. The server serves it on --grpc-port,
+// see server/README.md.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: samorozvrh.proto
+
+package api
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type GetTermRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTermRequest) Reset() {
+	*x = GetTermRequest{}
+	mi := &file_samorozvrh_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTermRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTermRequest) ProtoMessage() {}
+
+func (x *GetTermRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTermRequest.ProtoReflect.Descriptor instead.
+func (*GetTermRequest) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{0}
+}
+
+type Term struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Numbered by the year it starts in, as in SIS (2026 for 2026/2027)
+	AcademicYear int32 `protobuf:"varint,1,opt,name=academic_year,json=academicYear,proto3" json:"academic_year,omitempty"`
+	// 1 for winter, 2 for summer
+	Semester      int32 `protobuf:"varint,2,opt,name=semester,proto3" json:"semester,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Term) Reset() {
+	*x = Term{}
+	mi := &file_samorozvrh_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Term) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Term) ProtoMessage() {}
+
+func (x *Term) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Term.ProtoReflect.Descriptor instead.
+func (*Term) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Term) GetAcademicYear() int32 {
+	if x != nil {
+		return x.AcademicYear
+	}
+	return 0
+}
+
+func (x *Term) GetSemester() int32 {
+	if x != nil {
+		return x.Semester
+	}
+	return 0
+}
+
+type GetCourseRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Code  string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	// The current term if not given
+	Semester      int32 `protobuf:"varint,2,opt,name=semester,proto3" json:"semester,omitempty"`
+	AcademicYear  int32 `protobuf:"varint,3,opt,name=academic_year,json=academicYear,proto3" json:"academic_year,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCourseRequest) Reset() {
+	*x = GetCourseRequest{}
+	mi := &file_samorozvrh_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCourseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCourseRequest) ProtoMessage() {}
+
+func (x *GetCourseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCourseRequest.ProtoReflect.Descriptor instead.
+func (*GetCourseRequest) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetCourseRequest) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *GetCourseRequest) GetSemester() int32 {
+	if x != nil {
+		return x.Semester
+	}
+	return 0
+}
+
+func (x *GetCourseRequest) GetAcademicYear() int32 {
+	if x != nil {
+		return x.AcademicYear
+	}
+	return 0
+}
+
+type GetCoursesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Codes         []string               `protobuf:"bytes,1,rep,name=codes,proto3" json:"codes,omitempty"`
+	Semester      int32                  `protobuf:"varint,2,opt,name=semester,proto3" json:"semester,omitempty"`
+	AcademicYear  int32                  `protobuf:"varint,3,opt,name=academic_year,json=academicYear,proto3" json:"academic_year,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCoursesRequest) Reset() {
+	*x = GetCoursesRequest{}
+	mi := &file_samorozvrh_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCoursesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCoursesRequest) ProtoMessage() {}
+
+func (x *GetCoursesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCoursesRequest.ProtoReflect.Descriptor instead.
+func (*GetCoursesRequest) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetCoursesRequest) GetCodes() []string {
+	if x != nil {
+		return x.Codes
+	}
+	return nil
+}
+
+func (x *GetCoursesRequest) GetSemester() int32 {
+	if x != nil {
+		return x.Semester
+	}
+	return 0
+}
+
+func (x *GetCoursesRequest) GetAcademicYear() int32 {
+	if x != nil {
+		return x.AcademicYear
+	}
+	return 0
+}
+
+type GetCoursesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Results       []*CourseResult        `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCoursesResponse) Reset() {
+	*x = GetCoursesResponse{}
+	mi := &file_samorozvrh_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCoursesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCoursesResponse) ProtoMessage() {}
+
+func (x *GetCoursesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCoursesResponse.ProtoReflect.Descriptor instead.
+func (*GetCoursesResponse) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetCoursesResponse) GetResults() []*CourseResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type CourseResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Code  string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	// Either the course or why it couldn't be got
+	Course        *Course `protobuf:"bytes,2,opt,name=course,proto3" json:"course,omitempty"`
+	Error         string  `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CourseResult) Reset() {
+	*x = CourseResult{}
+	mi := &file_samorozvrh_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CourseResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CourseResult) ProtoMessage() {}
+
+func (x *CourseResult) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CourseResult.ProtoReflect.Descriptor instead.
+func (*CourseResult) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *CourseResult) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *CourseResult) GetCourse() *Course {
+	if x != nil {
+		return x.Course
+	}
+	return nil
+}
+
+func (x *CourseResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type Course struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Code         string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	Name         string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	AcademicYear int32                  `protobuf:"varint,3,opt,name=academic_year,json=academicYear,proto3" json:"academic_year,omitempty"`
+	Semester     int32                  `protobuf:"varint,4,opt,name=semester,proto3" json:"semester,omitempty"`
+	// The student enrolls in one lecture group and one seminar group
+	Groups []*Group `protobuf:"bytes,5,rep,name=groups,proto3" json:"groups,omitempty"`
+	// Whether the seminar may be left out
+	SeminarOptional bool `protobuf:"varint,6,opt,name=seminar_optional,json=seminarOptional,proto3" json:"seminar_optional,omitempty"`
+	// Why the data were corrected by the operator, if they were
+	OverrideReason string `protobuf:"bytes,7,opt,name=override_reason,json=overrideReason,proto3" json:"override_reason,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Course) Reset() {
+	*x = Course{}
+	mi := &file_samorozvrh_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Course) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Course) ProtoMessage() {}
+
+func (x *Course) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Course.ProtoReflect.Descriptor instead.
+func (*Course) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Course) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *Course) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Course) GetAcademicYear() int32 {
+	if x != nil {
+		return x.AcademicYear
+	}
+	return 0
+}
+
+func (x *Course) GetSemester() int32 {
+	if x != nil {
+		return x.Semester
+	}
+	return 0
+}
+
+func (x *Course) GetGroups() []*Group {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+func (x *Course) GetSeminarOptional() bool {
+	if x != nil {
+		return x.SeminarOptional
+	}
+	return false
+}
+
+func (x *Course) GetOverrideReason() string {
+	if x != nil {
+		return x.OverrideReason
+	}
+	return ""
+}
+
+type Group struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// "lecture" or "seminar"
+	Type     string `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Optional bool   `protobuf:"varint,3,opt,name=optional,proto3" json:"optional,omitempty"`
+	// Enrolled all together
+	Events        []*Event `protobuf:"bytes,4,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Group) Reset() {
+	*x = Group{}
+	mi := &file_samorozvrh_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Group) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Group) ProtoMessage() {}
+
+func (x *Group) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Group.ProtoReflect.Descriptor instead.
+func (*Group) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Group) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Group) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Group) GetOptional() bool {
+	if x != nil {
+		return x.Optional
+	}
+	return false
+}
+
+func (x *Group) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+type Event struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	CourseCode string                 `protobuf:"bytes,1,opt,name=course_code,json=courseCode,proto3" json:"course_code,omitempty"`
+	Code       string                 `protobuf:"bytes,2,opt,name=code,proto3" json:"code,omitempty"`
+	GroupId    string                 `protobuf:"bytes,3,opt,name=group_id,json=groupId,proto3" json:"group_id,omitempty"`
+	Semester   int32                  `protobuf:"varint,4,opt,name=semester,proto3" json:"semester,omitempty"`
+	Type       string                 `protobuf:"bytes,5,opt,name=type,proto3" json:"type,omitempty"`
+	Name       string                 `protobuf:"bytes,6,opt,name=name,proto3" json:"name,omitempty"`
+	Teacher    string                 `protobuf:"bytes,7,opt,name=teacher,proto3" json:"teacher,omitempty"`
+	TeacherId  string                 `protobuf:"bytes,8,opt,name=teacher_id,json=teacherId,proto3" json:"teacher_id,omitempty"`
+	// Monday = 0
+	Day int32 `protobuf:"varint,9,opt,name=day,proto3" json:"day,omitempty"`
+	// "hh:mm", the end may be "24:00"
+	TimeFrom string `protobuf:"bytes,10,opt,name=time_from,json=timeFrom,proto3" json:"time_from,omitempty"`
+	TimeTo   string `protobuf:"bytes,11,opt,name=time_to,json=timeTo,proto3" json:"time_to,omitempty"`
+	// "every", "odd" or "even"
+	WeekParity string `protobuf:"bytes,12,opt,name=week_parity,json=weekParity,proto3" json:"week_parity,omitempty"`
+	Room       string `protobuf:"bytes,13,opt,name=room,proto3" json:"room,omitempty"`
+	// 0 if unlimited or unknown
+	Capacity int32  `protobuf:"varint,14,opt,name=capacity,proto3" json:"capacity,omitempty"`
+	Enrolled int32  `protobuf:"varint,15,opt,name=enrolled,proto3" json:"enrolled,omitempty"`
+	Note     string `protobuf:"bytes,16,opt,name=note,proto3" json:"note,omitempty"`
+	// "cs" or "en", empty if unknown
+	Language string `protobuf:"bytes,17,opt,name=language,proto3" json:"language,omitempty"`
+	Optional bool   `protobuf:"varint,18,opt,name=optional,proto3" json:"optional,omitempty"`
+	Patched  bool   `protobuf:"varint,19,opt,name=patched,proto3" json:"patched,omitempty"`
+	// The teaching weeks (from 1) the event takes place in, when SIS lists
+	// them instead of a parity
+	Weeks         []int32 `protobuf:"varint,20,rep,packed,name=weeks,proto3" json:"weeks,omitempty"`
+	Cancelled     bool    `protobuf:"varint,21,opt,name=cancelled,proto3" json:"cancelled,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_samorozvrh_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Event) GetCourseCode() string {
+	if x != nil {
+		return x.CourseCode
+	}
+	return ""
+}
+
+func (x *Event) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *Event) GetGroupId() string {
+	if x != nil {
+		return x.GroupId
+	}
+	return ""
+}
+
+func (x *Event) GetSemester() int32 {
+	if x != nil {
+		return x.Semester
+	}
+	return 0
+}
+
+func (x *Event) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Event) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Event) GetTeacher() string {
+	if x != nil {
+		return x.Teacher
+	}
+	return ""
+}
+
+func (x *Event) GetTeacherId() string {
+	if x != nil {
+		return x.TeacherId
+	}
+	return ""
+}
+
+func (x *Event) GetDay() int32 {
+	if x != nil {
+		return x.Day
+	}
+	return 0
+}
+
+func (x *Event) GetTimeFrom() string {
+	if x != nil {
+		return x.TimeFrom
+	}
+	return ""
+}
+
+func (x *Event) GetTimeTo() string {
+	if x != nil {
+		return x.TimeTo
+	}
+	return ""
+}
+
+func (x *Event) GetWeekParity() string {
+	if x != nil {
+		return x.WeekParity
+	}
+	return ""
+}
+
+func (x *Event) GetRoom() string {
+	if x != nil {
+		return x.Room
+	}
+	return ""
+}
+
+func (x *Event) GetCapacity() int32 {
+	if x != nil {
+		return x.Capacity
+	}
+	return 0
+}
+
+func (x *Event) GetEnrolled() int32 {
+	if x != nil {
+		return x.Enrolled
+	}
+	return 0
+}
+
+func (x *Event) GetNote() string {
+	if x != nil {
+		return x.Note
+	}
+	return ""
+}
+
+func (x *Event) GetLanguage() string {
+	if x != nil {
+		return x.Language
+	}
+	return ""
+}
+
+func (x *Event) GetOptional() bool {
+	if x != nil {
+		return x.Optional
+	}
+	return false
+}
+
+func (x *Event) GetPatched() bool {
+	if x != nil {
+		return x.Patched
+	}
+	return false
+}
+
+func (x *Event) GetWeeks() []int32 {
+	if x != nil {
+		return x.Weeks
+	}
+	return nil
+}
+
+func (x *Event) GetCancelled() bool {
+	if x != nil {
+		return x.Cancelled
+	}
+	return false
+}
+
+type SearchCoursesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Query         string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchCoursesRequest) Reset() {
+	*x = SearchCoursesRequest{}
+	mi := &file_samorozvrh_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchCoursesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchCoursesRequest) ProtoMessage() {}
+
+func (x *SearchCoursesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchCoursesRequest.ProtoReflect.Descriptor instead.
+func (*SearchCoursesRequest) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *SearchCoursesRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+type SearchCoursesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Courses       []*CourseSummary       `protobuf:"bytes,1,rep,name=courses,proto3" json:"courses,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchCoursesResponse) Reset() {
+	*x = SearchCoursesResponse{}
+	mi := &file_samorozvrh_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchCoursesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchCoursesResponse) ProtoMessage() {}
+
+func (x *SearchCoursesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchCoursesResponse.ProtoReflect.Descriptor instead.
+func (*SearchCoursesResponse) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *SearchCoursesResponse) GetCourses() []*CourseSummary {
+	if x != nil {
+		return x.Courses
+	}
+	return nil
+}
+
+type CourseSummary struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Teachers      []string               `protobuf:"bytes,3,rep,name=teachers,proto3" json:"teachers,omitempty"`
+	TeacherIds    []string               `protobuf:"bytes,4,rep,name=teacher_ids,json=teacherIds,proto3" json:"teacher_ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CourseSummary) Reset() {
+	*x = CourseSummary{}
+	mi := &file_samorozvrh_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CourseSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CourseSummary) ProtoMessage() {}
+
+func (x *CourseSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CourseSummary.ProtoReflect.Descriptor instead.
+func (*CourseSummary) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *CourseSummary) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *CourseSummary) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CourseSummary) GetTeachers() []string {
+	if x != nil {
+		return x.Teachers
+	}
+	return nil
+}
+
+func (x *CourseSummary) GetTeacherIds() []string {
+	if x != nil {
+		return x.TeacherIds
+	}
+	return nil
+}
+
+type SolveRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The body of a /solverquery/ request (JSON), as the solver's input
+	// has too many forms to be worth typing
+	Query         string `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SolveRequest) Reset() {
+	*x = SolveRequest{}
+	mi := &file_samorozvrh_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SolveRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SolveRequest) ProtoMessage() {}
+
+func (x *SolveRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SolveRequest.ProtoReflect.Descriptor instead.
+func (*SolveRequest) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *SolveRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+type SolveResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The answer of /solverquery/ (JSON)
+	Answer        string `protobuf:"bytes,1,opt,name=answer,proto3" json:"answer,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SolveResponse) Reset() {
+	*x = SolveResponse{}
+	mi := &file_samorozvrh_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SolveResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SolveResponse) ProtoMessage() {}
+
+func (x *SolveResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_samorozvrh_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SolveResponse.ProtoReflect.Descriptor instead.
+func (*SolveResponse) Descriptor() ([]byte, []int) {
+	return file_samorozvrh_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SolveResponse) GetAnswer() string {
+	if x != nil {
+		return x.Answer
+	}
+	return ""
+}
+
+var File_samorozvrh_proto protoreflect.FileDescriptor
+
+const file_samorozvrh_proto_rawDesc = "" +
+	"\n" +
+	"\x10samorozvrh.proto\x12\rsamorozvrh.v1\"\x10\n" +
+	"\x0eGetTermRequest\"G\n" +
+	"\x04Term\x12#\n" +
+	"\racademic_year\x18\x01 \x01(\x05R\facademicYear\x12\x1a\n" +
+	"\bsemester\x18\x02 \x01(\x05R\bsemester\"g\n" +
+	"\x10GetCourseRequest\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12\x1a\n" +
+	"\bsemester\x18\x02 \x01(\x05R\bsemester\x12#\n" +
+	"\racademic_year\x18\x03 \x01(\x05R\facademicYear\"j\n" +
+	"\x11GetCoursesRequest\x12\x14\n" +
+	"\x05codes\x18\x01 \x03(\tR\x05codes\x12\x1a\n" +
+	"\bsemester\x18\x02 \x01(\x05R\bsemester\x12#\n" +
+	"\racademic_year\x18\x03 \x01(\x05R\facademicYear\"K\n" +
+	"\x12GetCoursesResponse\x125\n" +
+	"\aresults\x18\x01 \x03(\v2\x1b.samorozvrh.v1.CourseResultR\aresults\"g\n" +
+	"\fCourseResult\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12-\n" +
+	"\x06course\x18\x02 \x01(\v2\x15.samorozvrh.v1.CourseR\x06course\x12\x14\n" +
+	"\x05error\x18\x03 \x01(\tR\x05error\"\xf3\x01\n" +
+	"\x06Course\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12#\n" +
+	"\racademic_year\x18\x03 \x01(\x05R\facademicYear\x12\x1a\n" +
+	"\bsemester\x18\x04 \x01(\x05R\bsemester\x12,\n" +
+	"\x06groups\x18\x05 \x03(\v2\x14.samorozvrh.v1.GroupR\x06groups\x12)\n" +
+	"\x10seminar_optional\x18\x06 \x01(\bR\x0fseminarOptional\x12'\n" +
+	"\x0foverride_reason\x18\a \x01(\tR\x0eoverrideReason\"u\n" +
+	"\x05Group\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12\x1a\n" +
+	"\boptional\x18\x03 \x01(\bR\boptional\x12,\n" +
+	"\x06events\x18\x04 \x03(\v2\x14.samorozvrh.v1.EventR\x06events\"\xa3\x04\n" +
+	"\x05Event\x12\x1f\n" +
+	"\vcourse_code\x18\x01 \x01(\tR\n" +
+	"courseCode\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\tR\x04code\x12\x19\n" +
+	"\bgroup_id\x18\x03 \x01(\tR\agroupId\x12\x1a\n" +
+	"\bsemester\x18\x04 \x01(\x05R\bsemester\x12\x12\n" +
+	"\x04type\x18\x05 \x01(\tR\x04type\x12\x12\n" +
+	"\x04name\x18\x06 \x01(\tR\x04name\x12\x18\n" +
+	"\ateacher\x18\a \x01(\tR\ateacher\x12\x1d\n" +
+	"\n" +
+	"teacher_id\x18\b \x01(\tR\tteacherId\x12\x10\n" +
+	"\x03day\x18\t \x01(\x05R\x03day\x12\x1b\n" +
+	"\ttime_from\x18\n" +
+	" \x01(\tR\btimeFrom\x12\x17\n" +
+	"\atime_to\x18\v \x01(\tR\x06timeTo\x12\x1f\n" +
+	"\vweek_parity\x18\f \x01(\tR\n" +
+	"weekParity\x12\x12\n" +
+	"\x04room\x18\r \x01(\tR\x04room\x12\x1a\n" +
+	"\bcapacity\x18\x0e \x01(\x05R\bcapacity\x12\x1a\n" +
+	"\benrolled\x18\x0f \x01(\x05R\benrolled\x12\x12\n" +
+	"\x04note\x18\x10 \x01(\tR\x04note\x12\x1a\n" +
+	"\blanguage\x18\x11 \x01(\tR\blanguage\x12\x1a\n" +
+	"\boptional\x18\x12 \x01(\bR\boptional\x12\x18\n" +
+	"\apatched\x18\x13 \x01(\bR\apatched\x12\x14\n" +
+	"\x05weeks\x18\x14 \x03(\x05R\x05weeks\x12\x1c\n" +
+	"\tcancelled\x18\x15 \x01(\bR\tcancelled\",\n" +
+	"\x14SearchCoursesRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\"O\n" +
+	"\x15SearchCoursesResponse\x126\n" +
+	"\acourses\x18\x01 \x03(\v2\x1c.samorozvrh.v1.CourseSummaryR\acourses\"t\n" +
+	"\rCourseSummary\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x1a\n" +
+	"\bteachers\x18\x03 \x03(\tR\bteachers\x12\x1f\n" +
+	"\vteacher_ids\x18\x04 \x03(\tR\n" +
+	"teacherIds\"$\n" +
+	"\fSolveRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\"'\n" +
+	"\rSolveResponse\x12\x16\n" +
+	"\x06answer\x18\x01 \x01(\tR\x06answer2\x83\x03\n" +
+	"\n" +
+	"Samorozvrh\x12=\n" +
+	"\aGetTerm\x12\x1d.samorozvrh.v1.GetTermRequest\x1a\x13.samorozvrh.v1.Term\x12C\n" +
+	"\tGetCourse\x12\x1f.samorozvrh.v1.GetCourseRequest\x1a\x15.samorozvrh.v1.Course\x12Q\n" +
+	"\n" +
+	"GetCourses\x12 .samorozvrh.v1.GetCoursesRequest\x1a!.samorozvrh.v1.GetCoursesResponse\x12Z\n" +
+	"\rSearchCourses\x12#.samorozvrh.v1.SearchCoursesRequest\x1a$.samorozvrh.v1.SearchCoursesResponse\x12B\n" +
+	"\x05Solve\x12\x1b.samorozvrh.v1.SolveRequest\x1a\x1c.samorozvrh.v1.SolveResponseB#Z!github.com/iamwave/samorozvrh/apib\x06proto3"
+
+var (
+	file_samorozvrh_proto_rawDescOnce sync.Once
+	file_samorozvrh_proto_rawDescData []byte
+)
+
+func file_samorozvrh_proto_rawDescGZIP() []byte {
+	file_samorozvrh_proto_rawDescOnce.Do(func() {
+		file_samorozvrh_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_samorozvrh_proto_rawDesc), len(file_samorozvrh_proto_rawDesc)))
+	})
+	return file_samorozvrh_proto_rawDescData
+}
+
+var file_samorozvrh_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
+var file_samorozvrh_proto_goTypes = []any{
+	(*GetTermRequest)(nil),        // 0: samorozvrh.v1.GetTermRequest
+	(*Term)(nil),                  // 1: samorozvrh.v1.Term
+	(*GetCourseRequest)(nil),      // 2: samorozvrh.v1.GetCourseRequest
+	(*GetCoursesRequest)(nil),     // 3: samorozvrh.v1.GetCoursesRequest
+	(*GetCoursesResponse)(nil),    // 4: samorozvrh.v1.GetCoursesResponse
+	(*CourseResult)(nil),          // 5: samorozvrh.v1.CourseResult
+	(*Course)(nil),                // 6: samorozvrh.v1.Course
+	(*Group)(nil),                 // 7: samorozvrh.v1.Group
+	(*Event)(nil),                 // 8: samorozvrh.v1.Event
+	(*SearchCoursesRequest)(nil),  // 9: samorozvrh.v1.SearchCoursesRequest
+	(*SearchCoursesResponse)(nil), // 10: samorozvrh.v1.SearchCoursesResponse
+	(*CourseSummary)(nil),         // 11: samorozvrh.v1.CourseSummary
+	(*SolveRequest)(nil),          // 12: samorozvrh.v1.SolveRequest
+	(*SolveResponse)(nil),         // 13: samorozvrh.v1.SolveResponse
+}
+var file_samorozvrh_proto_depIdxs = []int32{
+	5,  // 0: samorozvrh.v1.GetCoursesResponse.results:type_name -> samorozvrh.v1.CourseResult
+	6,  // 1: samorozvrh.v1.CourseResult.course:type_name -> samorozvrh.v1.Course
+	7,  // 2: samorozvrh.v1.Course.groups:type_name -> samorozvrh.v1.Group
+	8,  // 3: samorozvrh.v1.Group.events:type_name -> samorozvrh.v1.Event
+	11, // 4: samorozvrh.v1.SearchCoursesResponse.courses:type_name -> samorozvrh.v1.CourseSummary
+	0,  // 5: samorozvrh.v1.Samorozvrh.GetTerm:input_type -> samorozvrh.v1.GetTermRequest
+	2,  // 6: samorozvrh.v1.Samorozvrh.GetCourse:input_type -> samorozvrh.v1.GetCourseRequest
+	3,  // 7: samorozvrh.v1.Samorozvrh.GetCourses:input_type -> samorozvrh.v1.GetCoursesRequest
+	9,  // 8: samorozvrh.v1.Samorozvrh.SearchCourses:input_type -> samorozvrh.v1.SearchCoursesRequest
+	12, // 9: samorozvrh.v1.Samorozvrh.Solve:input_type -> samorozvrh.v1.SolveRequest
+	1,  // 10: samorozvrh.v1.Samorozvrh.GetTerm:output_type -> samorozvrh.v1.Term
+	6,  // 11: samorozvrh.v1.Samorozvrh.GetCourse:output_type -> samorozvrh.v1.Course
+	4,  // 12: samorozvrh.v1.Samorozvrh.GetCourses:output_type -> samorozvrh.v1.GetCoursesResponse
+	10, // 13: samorozvrh.v1.Samorozvrh.SearchCourses:output_type -> samorozvrh.v1.SearchCoursesResponse
+	13, // 14: samorozvrh.v1.Samorozvrh.Solve:output_type -> samorozvrh.v1.SolveResponse
+	10, // [10:15] is the sub-list for method output_type
+	5,  // [5:10] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_samorozvrh_proto_init() }
+func file_samorozvrh_proto_init() {
+	if File_samorozvrh_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_samorozvrh_proto_rawDesc), len(file_samorozvrh_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   14,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_samorozvrh_proto_goTypes,
+		DependencyIndexes: file_samorozvrh_proto_depIdxs,
+		MessageInfos:      file_samorozvrh_proto_msgTypes,
+	}.Build()
+	File_samorozvrh_proto = out.File
+	file_samorozvrh_proto_goTypes = nil
+	file_samorozvrh_proto_depIdxs = nil
+}
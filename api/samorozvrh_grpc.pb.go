@@ -0,0 +1,287 @@
+// The gRPC API of the Samorozvrh server, for programs which want typed
+// clients rather than the JSON API. The server serves it on --grpc-port,
+// see server/README.md.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: samorozvrh.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Samorozvrh_GetTerm_FullMethodName       = "/samorozvrh.v1.Samorozvrh/GetTerm"
+	Samorozvrh_GetCourse_FullMethodName     = "/samorozvrh.v1.Samorozvrh/GetCourse"
+	Samorozvrh_GetCourses_FullMethodName    = "/samorozvrh.v1.Samorozvrh/GetCourses"
+	Samorozvrh_SearchCourses_FullMethodName = "/samorozvrh.v1.Samorozvrh/SearchCourses"
+	Samorozvrh_Solve_FullMethodName         = "/samorozvrh.v1.Samorozvrh/Solve"
+)
+
+// SamorozvrhClient is the client API for Samorozvrh service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SamorozvrhClient interface {
+	// The academic year and semester courses are queried for by default
+	GetTerm(ctx context.Context, in *GetTermRequest, opts ...grpc.CallOption) (*Term, error)
+	// Like /sisquery/<code>
+	GetCourse(ctx context.Context, in *GetCourseRequest, opts ...grpc.CallOption) (*Course, error)
+	// Like a batch request; a course which can't be found doesn't fail the others
+	GetCourses(ctx context.Context, in *GetCoursesRequest, opts ...grpc.CallOption) (*GetCoursesResponse, error)
+	// Searches the courses the server has cached, like /search
+	SearchCourses(ctx context.Context, in *SearchCoursesRequest, opts ...grpc.CallOption) (*SearchCoursesResponse, error)
+	// Like /solverquery/
+	Solve(ctx context.Context, in *SolveRequest, opts ...grpc.CallOption) (*SolveResponse, error)
+}
+
+type samorozvrhClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSamorozvrhClient(cc grpc.ClientConnInterface) SamorozvrhClient {
+	return &samorozvrhClient{cc}
+}
+
+func (c *samorozvrhClient) GetTerm(ctx context.Context, in *GetTermRequest, opts ...grpc.CallOption) (*Term, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Term)
+	err := c.cc.Invoke(ctx, Samorozvrh_GetTerm_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *samorozvrhClient) GetCourse(ctx context.Context, in *GetCourseRequest, opts ...grpc.CallOption) (*Course, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Course)
+	err := c.cc.Invoke(ctx, Samorozvrh_GetCourse_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *samorozvrhClient) GetCourses(ctx context.Context, in *GetCoursesRequest, opts ...grpc.CallOption) (*GetCoursesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetCoursesResponse)
+	err := c.cc.Invoke(ctx, Samorozvrh_GetCourses_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *samorozvrhClient) SearchCourses(ctx context.Context, in *SearchCoursesRequest, opts ...grpc.CallOption) (*SearchCoursesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchCoursesResponse)
+	err := c.cc.Invoke(ctx, Samorozvrh_SearchCourses_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *samorozvrhClient) Solve(ctx context.Context, in *SolveRequest, opts ...grpc.CallOption) (*SolveResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SolveResponse)
+	err := c.cc.Invoke(ctx, Samorozvrh_Solve_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SamorozvrhServer is the server API for Samorozvrh service.
+// All implementations must embed UnimplementedSamorozvrhServer
+// for forward compatibility.
+type SamorozvrhServer interface {
+	// The academic year and semester courses are queried for by default
+	GetTerm(context.Context, *GetTermRequest) (*Term, error)
+	// Like /sisquery/<code>
+	GetCourse(context.Context, *GetCourseRequest) (*Course, error)
+	// Like a batch request; a course which can't be found doesn't fail the others
+	GetCourses(context.Context, *GetCoursesRequest) (*GetCoursesResponse, error)
+	// Searches the courses the server has cached, like /search
+	SearchCourses(context.Context, *SearchCoursesRequest) (*SearchCoursesResponse, error)
+	// Like /solverquery/
+	Solve(context.Context, *SolveRequest) (*SolveResponse, error)
+	mustEmbedUnimplementedSamorozvrhServer()
+}
+
+// UnimplementedSamorozvrhServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSamorozvrhServer struct{}
+
+func (UnimplementedSamorozvrhServer) GetTerm(context.Context, *GetTermRequest) (*Term, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTerm not implemented")
+}
+func (UnimplementedSamorozvrhServer) GetCourse(context.Context, *GetCourseRequest) (*Course, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCourse not implemented")
+}
+func (UnimplementedSamorozvrhServer) GetCourses(context.Context, *GetCoursesRequest) (*GetCoursesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCourses not implemented")
+}
+func (UnimplementedSamorozvrhServer) SearchCourses(context.Context, *SearchCoursesRequest) (*SearchCoursesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchCourses not implemented")
+}
+func (UnimplementedSamorozvrhServer) Solve(context.Context, *SolveRequest) (*SolveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Solve not implemented")
+}
+func (UnimplementedSamorozvrhServer) mustEmbedUnimplementedSamorozvrhServer() {}
+func (UnimplementedSamorozvrhServer) testEmbeddedByValue()                    {}
+
+// UnsafeSamorozvrhServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SamorozvrhServer will
+// result in compilation errors.
+type UnsafeSamorozvrhServer interface {
+	mustEmbedUnimplementedSamorozvrhServer()
+}
+
+func RegisterSamorozvrhServer(s grpc.ServiceRegistrar, srv SamorozvrhServer) {
+	// If the following call pancis, it indicates UnimplementedSamorozvrhServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Samorozvrh_ServiceDesc, srv)
+}
+
+func _Samorozvrh_GetTerm_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTermRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SamorozvrhServer).GetTerm(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Samorozvrh_GetTerm_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SamorozvrhServer).GetTerm(ctx, req.(*GetTermRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Samorozvrh_GetCourse_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCourseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SamorozvrhServer).GetCourse(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Samorozvrh_GetCourse_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SamorozvrhServer).GetCourse(ctx, req.(*GetCourseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Samorozvrh_GetCourses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCoursesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SamorozvrhServer).GetCourses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Samorozvrh_GetCourses_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SamorozvrhServer).GetCourses(ctx, req.(*GetCoursesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Samorozvrh_SearchCourses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchCoursesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SamorozvrhServer).SearchCourses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Samorozvrh_SearchCourses_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SamorozvrhServer).SearchCourses(ctx, req.(*SearchCoursesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Samorozvrh_Solve_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SolveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SamorozvrhServer).Solve(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Samorozvrh_Solve_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SamorozvrhServer).Solve(ctx, req.(*SolveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Samorozvrh_ServiceDesc is the grpc.ServiceDesc for Samorozvrh service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Samorozvrh_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "samorozvrh.v1.Samorozvrh",
+	HandlerType: (*SamorozvrhServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetTerm",
+			Handler:    _Samorozvrh_GetTerm_Handler,
+		},
+		{
+			MethodName: "GetCourse",
+			Handler:    _Samorozvrh_GetCourse_Handler,
+		},
+		{
+			MethodName: "GetCourses",
+			Handler:    _Samorozvrh_GetCourses_Handler,
+		},
+		{
+			MethodName: "SearchCourses",
+			Handler:    _Samorozvrh_SearchCourses_Handler,
+		},
+		{
+			MethodName: "Solve",
+			Handler:    _Samorozvrh_Solve_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "samorozvrh.proto",
+}
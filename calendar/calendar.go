@@ -0,0 +1,324 @@
+// Package calendar models the faculty's academic calendar: when a semester's
+// teaching starts and ends, which days are free (public holidays, dean's
+// days) and which calendar weeks are odd or even teaching weeks.
+// It is used to turn weekly events into concrete dated occurrences.
+package calendar
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+	// The zone database built in, for hosts without one (such as scratch
+	// containers), where Prague would silently become UTC
+	_ "time/tzdata"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+const dateFormat = "2006-01-02"
+
+// The timezone all SIS times are given in.
+var Location = loadLocation("Europe/Prague")
+
+type Semester struct {
+	Name  string
+	Start time.Time // The first day of teaching
+	End   time.Time // The last day of teaching
+	// Days on which there is no teaching (holidays, dean's days, ...)
+	FreeDays map[string]string // date -> reason
+	// By default, teaching weeks are numbered consecutively from Start and
+	// odd-numbered weeks are odd. SkippedWeeks lists Mondays of calendar weeks
+	// which are not counted as teaching weeks at all (e.g. Christmas).
+	SkippedWeeks []time.Time
+}
+
+// A single dated occurrence of an event.
+type Occurrence struct {
+	Event sisparse.Event
+	From  time.Time
+	To    time.Time
+	Week  int // The teaching week the occurrence falls into
+}
+
+// A calendar week in which there is teaching.
+type Week struct {
+	Number int       // Teaching week number, starting from 1
+	Monday time.Time // The Monday of the calendar week
+}
+
+// The parity of the teaching week, by its number.
+func (w Week) Parity() sisparse.WeekParity {
+	if w.Number%2 == 1 {
+		return sisparse.OddWeeks
+	}
+	return sisparse.EvenWeeks
+}
+
+// The parity of the calendar week, by its ISO number.
+func (w Week) CalendarParity() sisparse.WeekParity {
+	if _, n := w.Monday.ISOWeek(); n%2 == 1 {
+		return sisparse.OddWeeks
+	}
+	return sisparse.EvenWeeks
+}
+
+type semesterJSON struct {
+	Name          string            `json:"name"`
+	Start         string            `json:"start"`
+	End           string            `json:"end"`
+	FreeDays      map[string]string `json:"free_days"`
+	SkippedWeeks  []string          `json:"skipped_weeks"`
+	TeachingWeeks []weekJSON        `json:"teaching_weeks,omitempty"`
+}
+
+type weekJSON struct {
+	Number int                 `json:"number"`
+	Monday string              `json:"monday"`
+	Parity sisparse.WeekParity `json:"parity"`
+	// The parity of the calendar week, which SIS gives for some events
+	CalendarParity sisparse.WeekParity `json:"calendar_parity"`
+}
+
+// Loads a semester calendar from a JSON file, see calendar.json in the
+// root directory for an example.
+func LoadSemester(filename string) (*Semester, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var sj semesterJSON
+	if err := json.Unmarshal(data, &sj); err != nil {
+		return nil, err
+	}
+
+	s := &Semester{Name: sj.Name, FreeDays: map[string]string{}}
+	if s.Start, err = parseDate(sj.Start); err != nil {
+		return nil, err
+	}
+	if s.End, err = parseDate(sj.End); err != nil {
+		return nil, err
+	}
+	if s.End.Before(s.Start) {
+		return nil, fmt.Errorf("Semester %s ends before it starts", sj.Name)
+	}
+	for d, reason := range sj.FreeDays {
+		date, err := parseDate(d)
+		if err != nil {
+			return nil, err
+		}
+		s.FreeDays[date.Format(dateFormat)] = reason
+	}
+	for _, w := range sj.SkippedWeeks {
+		date, err := parseDate(w)
+		if err != nil {
+			return nil, err
+		}
+		s.SkippedWeeks = append(s.SkippedWeeks, monday(date))
+	}
+	return s, nil
+}
+
+func (s *Semester) MarshalJSON() ([]byte, error) {
+	sj := semesterJSON{
+		Name:     s.Name,
+		Start:    s.Start.Format(dateFormat),
+		End:      s.End.Format(dateFormat),
+		FreeDays: s.FreeDays,
+	}
+	for _, w := range s.SkippedWeeks {
+		sj.SkippedWeeks = append(sj.SkippedWeeks, w.Format(dateFormat))
+	}
+	for _, w := range s.Weeks() {
+		sj.TeachingWeeks = append(sj.TeachingWeeks, weekJSON{
+			Number:         w.Number,
+			Monday:         w.Monday.Format(dateFormat),
+			Parity:         w.Parity(),
+			CalendarParity: w.CalendarParity(),
+		})
+	}
+	return json.Marshal(sj)
+}
+
+// Returns the academic year (as numbered by SIS, see sisparse.CurrentTerm)
+// and the semester (sisparse.Winter or sisparse.Summer) of the calendar,
+// judging by when its teaching starts.
+func (s *Semester) Term() (year int, semester int) {
+	if s.Start.Month() >= time.August {
+		return s.Start.Year(), sisparse.Winter
+	}
+	return s.Start.Year() - 1, sisparse.Summer
+}
+
+// Returns all teaching weeks of the semester in order.
+func (s *Semester) Weeks() []Week {
+	res := []Week{}
+	for m := monday(s.Start); !m.After(toDate(s.End)); m = m.AddDate(0, 0, 7) {
+		if !s.isSkipped(m) {
+			res = append(res, Week{Number: len(res) + 1, Monday: m})
+		}
+	}
+	return res
+}
+
+// Returns the number of the teaching week (starting from 1) the given date
+// falls into, or 0 if there is no teaching in that week.
+func (s *Semester) TeachingWeek(date time.Time) int {
+	date = toDate(date)
+	if date.Before(toDate(s.Start)) || date.After(toDate(s.End)) {
+		return 0
+	}
+	m := monday(date)
+	for _, w := range s.Weeks() {
+		if w.Monday.Equal(m) {
+			return w.Number
+		}
+	}
+	return 0
+}
+
+// Reports whether there is any teaching on the given date.
+func (s *Semester) IsTeachingDay(date time.Time) bool {
+	if s.TeachingWeek(date) == 0 {
+		return false
+	}
+	_, free := s.FreeDays[toDate(date).Format(dateFormat)]
+	return !free
+}
+
+// Reports whether the event takes place in the teaching week (free days
+// aside). An event listing its weeks takes place in just those; one with
+// a calendar parity follows it rather than the parity of teaching weeks,
+// which only agree while no week is skipped.
+func (s *Semester) TakesPlace(e sisparse.Event, w Week) bool {
+	switch {
+	case len(e.Weeks) > 0:
+		return e.TakesPlaceInWeek(w.Number)
+	case e.CalendarParity != sisparse.EveryWeek:
+		return e.CalendarParity == w.CalendarParity()
+	case e.WeekParity != sisparse.EveryWeek:
+		return e.WeekParity == w.Parity()
+	}
+	return true
+}
+
+// Returns the parity of the teaching weeks the event takes place in by
+// TakesPlace: its WeekParity, unless the calendar parity says otherwise
+// in all the weeks. Events which take place in odd teaching weeks as well
+// as in even ones (the calendar weeks of which fall differently around
+// a skipped week) keep their WeekParity.
+func (s *Semester) ResolveWeekParity(e sisparse.Event) sisparse.WeekParity {
+	if e.CalendarParity == sisparse.EveryWeek {
+		return e.WeekParity
+	}
+	var odd, even bool
+	for _, w := range s.Weeks() {
+		if s.TakesPlace(e, w) {
+			odd = odd || w.Parity() == sisparse.OddWeeks
+			even = even || w.Parity() == sisparse.EvenWeeks
+		}
+	}
+	switch {
+	case odd && !even:
+		return sisparse.OddWeeks
+	case even && !odd:
+		return sisparse.EvenWeeks
+	}
+	return e.WeekParity
+}
+
+// Expands a weekly event into all its concrete occurrences during the
+// semester, taking week parity (see TakesPlace), free days and
+// cancellations into account.
+func (s *Semester) Occurrences(e sisparse.Event) []Occurrence {
+	res := []Occurrence{}
+	if e.Cancelled {
+		return res
+	}
+	// Event.Day is 0 for Monday
+	first := monday(s.Start).AddDate(0, 0, e.Day)
+	for date := first; !date.After(toDate(s.End)); date = date.AddDate(0, 0, 7) {
+		if !s.IsTeachingDay(date) || isCancelledOn(e, date) {
+			continue
+		}
+		week := Week{Number: s.TeachingWeek(date), Monday: monday(date)}
+		if !s.TakesPlace(e, week) {
+			continue
+		}
+		res = append(res, Occurrence{
+			Event: e,
+			From:  e.TimeFrom.On(date, Location),
+			To:    e.TimeTo.On(date, Location),
+			Week:  week.Number,
+		})
+	}
+	return res
+}
+
+// Returns the first occurrence of the event, not before the day its note
+// says teaching starts (if it does), false if there is none.
+func (s *Semester) FirstOccurrence(e sisparse.Event) (Occurrence, bool) {
+	var from time.Time
+	if e.NoteInfo.StartsFrom != "" {
+		// "MM-DD", in the year of the semester the date falls into
+		year := s.Start.Year()
+		if d, err := parseDate(fmt.Sprintf("%d-%s", year, e.NoteInfo.StartsFrom)); err == nil {
+			if d.Month() < s.Start.Month() {
+				d = d.AddDate(1, 0, 0)
+			}
+			from = d
+		}
+	}
+	for _, o := range s.Occurrences(e) {
+		if !o.From.Before(from) {
+			return o, true
+		}
+	}
+	return Occurrence{}, false
+}
+
+// Reports whether the note of the event cancels it on the date.
+func isCancelledOn(e sisparse.Event, date time.Time) bool {
+	day := date.Format("01-02")
+	for _, d := range e.NoteInfo.CancelledOn {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Semester) isSkipped(m time.Time) bool {
+	for _, w := range s.SkippedWeeks {
+		if w.Equal(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseDate(s string) (time.Time, error) {
+	return time.ParseInLocation(dateFormat, s, Location)
+}
+
+func toDate(t time.Time) time.Time {
+	y, m, d := t.In(Location).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, Location)
+}
+
+// Returns the Monday of the week containing the given date.
+func monday(t time.Time) time.Time {
+	t = toDate(t)
+	offset := (int(t.Weekday()) + 6) % 7 // Monday = 0
+	return t.AddDate(0, 0, -offset)
+}
+
+// Loads the timezone of the given name. Fails rather than computing all
+// dates in another zone.
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(fmt.Sprintf("Could not load the timezone %s: %s", name, err))
+	}
+	return loc
+}
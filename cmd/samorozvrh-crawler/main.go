@@ -0,0 +1,9 @@
+// Only crawls, apart from the servers answering the API, see
+// server/crawler.go. It takes the same flags as the server.
+package main
+
+import "github.com/iamwave/samorozvrh/server"
+
+func main() {
+	server.CrawlerMain()
+}
@@ -0,0 +1,8 @@
+// The Samorozvrh server, see server/README.md.
+package main
+
+import "github.com/iamwave/samorozvrh/server"
+
+func main() {
+	server.Main()
+}
@@ -8,14 +8,17 @@ import Cookies from './lib/js-cookie';
 export var a = Cookies;
 
 var REWARDS = [1,100,10000]
+var BALANCE = 10 // The penalty for each hour between the busiest and the lightest day of a balanced week
 
 export var courses = {}
 var loadedCourseCodes = {}
 var selectedOptions = {}
+var lastSolution = null // The last query to the solver and its result
 
 loadFromCookies();
 view.initHandlebars(selectedOptions);
 view.renderCourseList(courses)
+loadBundleList()
 
 export function clearAll() {
     if(window.confirm("Opravdu smazat vše?")) {
@@ -29,28 +32,123 @@ export function clearAll() {
 }
 
 export function addCourse() {
-    var courseCode = document.getElementById("course_code").value
+    loadCourse(document.getElementById("course_code").value)
+    return false // prevents default form submission behavior (refreshing the page)
+}
+
+// Called when the "importovat plán" button is pressed
+export function importStudyPlan() {
+    var program = document.getElementById("plan_program").value
+    var year = document.getElementById("plan_year").value
+    backendQuery.getStudyPlan(program, year, function(res, err) {
+        if (err) {
+            view.setStatusMessage("Chyba: " + err)
+        } else {
+            // Required courses get the highest priority, electives the default one
+            res.courses.forEach(function(c) {
+                loadCourse(c.code, c.required ? 3 : 2)
+            })
+        }
+    })
+    view.setStatusMessage("Načítám studijní plán " + program)
+
+    return false
+}
+
+function loadBundleList() {
+    backendQuery.getBundles(null, function(res, err) {
+        if (err || res.length == 0) {
+            return
+        }
+        var select = document.getElementById("bundle")
+        res.forEach(function(b) {
+            var option = document.createElement("option")
+            option.value = b.id
+            option.text = b.name
+            option.title = b.description || ""
+            select.add(option)
+        })
+        document.getElementById("bundle_form").style.display = ""
+    })
+}
+
+// Called when the "přidat sadu" button is pressed
+export function importBundle() {
+    var id = document.getElementById("bundle").value
+    backendQuery.getBundles(id, function(res, err) {
+        if (err) {
+            view.setStatusMessage("Chyba: " + err)
+        } else {
+            res.courses.forEach(function(c) {
+                loadCourse(c.code, c.priority, null, c.optional)
+            })
+        }
+    })
+    view.setStatusMessage("Načítám sadu předmětů")
+
+    return false
+}
+
+// Called when the "importovat zápis" button is pressed
+export function importEnrollment() {
+    var login = document.getElementById("sis_login").value
+    var passwordInput = document.getElementById("sis_password")
+    backendQuery.getEnrollment(login, passwordInput.value, function(res, err) {
+        if (err) {
+            view.setStatusMessage("Chyba: " + err)
+        } else {
+            // The courses the student is enrolled in are the ones they want most
+            res.forEach(function(c) {
+                loadCourse(c.code, 3, c.enrolled_groups)
+            })
+        }
+    })
+    passwordInput.value = ""
+    view.setStatusMessage("Přihlašuji se do SISu")
+
+    return false
+}
+
+// enrolledGroups are the indices of the groups the student is enrolled in, if known;
+// optional courses may be left out by the solver altogether
+function loadCourse(courseCode, priority, enrolledGroups, optional) {
     if (loadedCourseCodes[courseCode]) {
         view.setStatusMessage("Předmět " + courseCode + " už je přidán")
-        return false
+        return
     }
-    backendQuery.addCourse(courseCode, function(res, err) {
+    backendQuery.addCourse(courseCode, function(res, err, response) {
         if (err) {
             view.setStatusMessage("Chyba: " + err)
         } else {
-            res.forEach(addGroup)
+            res.forEach(function(group, i) {
+                if (enrolledGroups && enrolledGroups.indexOf(i) >= 0) {
+                    group.enrolled = true
+                }
+                addGroup(group, priority, optional)
+            })
             view.renderCourseList(courses)
             loadedCourseCodes[courseCode] = true
             saveToCookies()
-            view.setStatusMessage("Přidán předmět " + courseCode)
+            var message = "Přidán předmět " + courseCode
+            if (response.override) {
+                // The operator has corrected what SIS says
+                message += " (data ručně opravena: " + response.override.reason + ")"
+            }
+            var provenance = response.provenance
+            if (provenance && provenance.cache == "stale") {
+                message += " (data mohou být zastaralá, ze SISu načtena " +
+                    new Date(provenance.fetched).toLocaleString("cs-CZ") + ")"
+            }
+            if (provenance && provenance.warnings && provenance.warnings.length > 0) {
+                message += " (v rozvrhu v SISu bylo " + provenance.warnings.length + " nesrozumitelných řádků)"
+            }
+            view.setStatusMessage(message)
         }
     })
     view.setStatusMessage("Hledám předmět " + courseCode)
-
-    return false // prevents default form submission behavior (refreshing the page)
 }
 
-function addGroup(group) {
+function addGroup(group, priority, optional) {
     if (group.length == 0) {
         return
     }
@@ -61,9 +159,17 @@ function addGroup(group) {
     if (courses[id] === undefined) {
         courses[id] = {
             id: id,
-            name: name + " (" + ((type==='P') ? "přednáška" : "seminář") + ")",
+            name: name + " (" + ((type==='lecture') ? "přednáška" : "seminář") + ")",
             options: [],
             allowed: true,
+            // The lecture and the seminar of a course are only scheduled together
+            courseCode: group[0].course_code,
+            component: type,
+            // Whether the student may leave it out (see handleOptionalChange)
+            optional: !!(optional || group[0].optional),
+        }
+        if (priority !== undefined) {
+            courses[id].priority = priority
         }
     }
     group.allowed = true
@@ -85,18 +191,56 @@ export function createSchedule() {
                 id: c.id,
                 name: c.name,
                 reward: REWARDS[(c.priority || 2) - 1],
+                course_code: c.courseCode,
+                component: c.component,
+                optional: c.optional,
                 options: c.options.filter(function(o) {
                     return o.allowed
                 })
             }
         })
 
-    backendQuery.createSchedule(queryArray, function(res, err) {
+    var rules = document.getElementById("rules").value.split("\n").filter(function(r) {
+        return r.trim() !== ""
+    })
+
+    // Keep the current schedule as much as possible when it's only being changed
+    var previous = queryArray.map(function(c) {
+        var index = c.options.findIndex(function(o) {return o.optionId === selectedOptions[c.id]})
+        return index >= 0 ? index : null
+    })
+    if (previous.every(function(p) {return p === null})) {
+        previous = null
+    }
+
+    var balance = document.getElementById("balance").checked ? BALANCE : null
+
+    backendQuery.createSchedule(queryArray, rules, previous, balance, function(res, err, response) {
         if (err) {
             view.setStatusMessage("Chyba: " + err)
         } else {
+            lastSolution = {query: queryArray, result: res, fallbacks: response.fallbacks}
             var nSelected = res.filter(function(x){return x !== null}).length
-            view.setStatusMessage("Rozvrh sestaven (počet předmětů: " + nSelected + ")")
+            var message = "Rozvrh sestaven (počet předmětů: " + nSelected + ", seed: " + response.seed
+            var nChanges = countEnrollmentChanges(queryArray, res)
+            if (nChanges !== null) {
+                message += ", změn oproti zápisu: " + nChanges
+            }
+            if (response.pairings && response.pairings.length > 0) {
+                message += ", sdílených slotů v lichých a sudých týdnech: " + response.pairings.length
+            }
+            message += ")"
+            if (response.omitted && response.omitted.length > 0) {
+                message += ". Vynechány nepovinné části: " + response.omitted.map(function(o) {
+                    return queryArray[o.courses[0]].name
+                }).join(", ")
+            }
+            if (response.unschedulable && response.unschedulable.length > 0) {
+                message += ". Pravidlům nevyhovuje žádná skupina předmětů: " + response.unschedulable.map(function(u) {
+                    return u.name
+                }).join(", ")
+            }
+            view.setStatusMessage(message)
             for (var course in courses) {
                 delete selectedOptions[course]
             }
@@ -114,6 +258,55 @@ export function createSchedule() {
 }
 
 
+// Called when the "plán zápisu" button is pressed. Downloads the steps
+// needed to enroll in the last schedule (with links to SIS) as JSON,
+// after checking in SIS that the chosen groups haven't got full meanwhile.
+export function exportEnrollmentPlan() {
+    if (!lastSolution) {
+        view.setStatusMessage("Nejdřív sestavte rozvrh")
+        return false
+    }
+    view.setStatusMessage("Ověřuji obsazenost skupin")
+    backendQuery.getEnrollmentPlan(lastSolution.query, lastSolution.result, lastSolution.fallbacks, true, function(res, err) {
+        if (err) {
+            view.setStatusMessage("Chyba: " + err)
+        } else {
+            var blob = new Blob([JSON.stringify(res, null, 2)], {type: "application/json"})
+            var link = document.createElement("a")
+            link.href = URL.createObjectURL(blob)
+            link.download = "plan-zapisu.json"
+            link.click()
+            URL.revokeObjectURL(link.href)
+            var message = "Plán zápisu stažen (počet kroků: " + res.length + ")"
+            var full = res.filter(function(step) {return step.full}).map(function(step) {
+                return step.course_name + (step.filled_up ? " (mezitím se zaplnil)" : "")
+            })
+            if (full.length > 0) {
+                message += ". Plné skupiny: " + full.join(", ")
+            }
+            view.setStatusMessage(message)
+        }
+    })
+    return false
+}
+
+// Returns in how many courses the schedule differs from the groups the student
+// is enrolled in, or null if no enrollment has been imported.
+function countEnrollmentChanges(queryArray, res) {
+    var nChanges = null
+    queryArray.forEach(function(c, i) {
+        var enrolled = c.options.filter(function(o) {return o.enrolled})
+        if (enrolled.length == 0) {
+            return
+        }
+        nChanges = nChanges || 0
+        if (res[i] === null || !c.options[res[i]].enrolled) {
+            nChanges++
+        }
+    })
+    return nChanges
+}
+
 export function handleCheckbox(checkboxId, courseId, index) {
     if (index === undefined) { // Checkbox for the whole course
         // If something is checked, uncheck everything, otherwise check everything
@@ -137,6 +330,12 @@ export function handlePriorityChange(courseId, priority) {
     saveToCookies()
 }
 
+// The user can override whether a component of a course (usually the seminar) may be left out
+export function handleOptionalChange(checkboxId, courseId) {
+    courses[courseId].optional = document.getElementById(checkboxId).checked
+    saveToCookies()
+}
+
 function getNonOverlappingGroups() {
     // For rendering purposes, we split the events into groups with no overlap.
     // Perhaps premature optimization, this is in case we want to allow the solver
@@ -181,12 +380,16 @@ function loadFromCookies() {
     var after = function() {
         var priorities = Cookies.getJSON("priorities")
         var allowed = Cookies.getJSON("allowed")
+        var optional = Cookies.getJSON("optional")
         if(priorities === undefined) return
         if(allowed === undefined) return
 
         var sorted = util.sortCourses(courses)
         sorted.forEach(function(c, i) {
             c.priority = priorities[i]
+            if (optional !== undefined) {
+                c.optional = optional[i]
+            }
             c.options.forEach(function(o, j) {
                 o.allowed = allowed[i][j]
             })
@@ -223,4 +426,5 @@ function saveToCookies() {
         return c.options.map(function(o) { return o.allowed })
     })
     Cookies.set("allowed", allowed, { expires: COOKIE_DAYS })
+    Cookies.set("optional", sorted.map(function(c) { return c.optional }), { expires: COOKIE_DAYS })
 }
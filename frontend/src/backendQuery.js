@@ -2,7 +2,9 @@
 
 var util = require('./util')
 
-export function createSchedule(queryArray, callback) {
+// rules are optional constraints in the language described in server/rules.go,
+// previous are the options chosen in the current schedule (to keep them if possible)
+export function createSchedule(queryArray, rules, previous, balance, callback) {
 
     var onResponse = function(responseString, error) {
         if (error) {
@@ -12,14 +14,18 @@ export function createSchedule(queryArray, callback) {
         } else {
             var response = JSON.parse(responseString)
             if (response['error']) {
-                callback(null, "Chyba při tvorbě rozvrhu: " + response['error'])
+                callback(null, "Chyba při tvorbě rozvrhu: " + util.errorMessage(response))
             } else {
-                callback(response["data"], null)
+                callback(response["data"], null, response)
             }
         }
     }
     
-    util.makeHttpRequest("POST", "solverquery/", JSON.stringify(queryArray), onResponse)
+    var query = queryArray
+    if ((rules && rules.length > 0) || previous || balance) {
+        query = {courses: queryArray, rules: rules, previous: previous, balance: balance}
+    }
+    util.makeHttpRequest("POST", "solverquery/", JSON.stringify(query), onResponse)
 }
 
 export function addCourse(courseCode, callback) {
@@ -32,11 +38,97 @@ export function addCourse(courseCode, callback) {
         } else {
             var response = JSON.parse(responseString)
             if (response['error']) {
-                callback(null, "Nepodařilo se najít předmět " + courseCode + "; je kód zadán správně?")
+                var message = "Nepodařilo se najít předmět " + courseCode + "; je kód zadán správně?"
+                if (response['suggestions'] && response['suggestions'].length > 0) {
+                    message += " Podobné předměty: " + response['suggestions'].join(", ")
+                }
+                if (response['ref']) {
+                    message += " (kód chyby: " + response['ref'] + ")"
+                }
+                callback(null, message)
             } else {
-                callback(response['data'], null)
+                callback(response['data'], null, response)
             }
         }
     }
     util.makeHttpRequest("GET", "sisquery/" + encodeURIComponent(courseCode), null, onResponse)
 }
+
+export function getStudyPlan(program, year, callback) {
+
+    var onResponse = function(responseString, error) {
+        if (error) {
+            callback(null, error)
+        } else if (!responseString) {
+            callback(null, "Server neodpovídá.")
+        } else {
+            var response = JSON.parse(responseString)
+            if (response['error']) {
+                callback(null, "Nepodařilo se načíst studijní plán " + program + ": " + util.errorMessage(response))
+            } else {
+                callback(response['data'], null)
+            }
+        }
+    }
+    util.makeHttpRequest("GET", "studyplan/" + encodeURIComponent(program) + "/" + encodeURIComponent(year),
+        null, onResponse)
+}
+
+// Without an id, lists the bundles of courses prepared by the operators
+export function getBundles(id, callback) {
+
+    var onResponse = function(responseString, error) {
+        if (error) {
+            callback(null, error)
+        } else if (!responseString) {
+            callback(null, "Server neodpovídá.")
+        } else {
+            var response = JSON.parse(responseString)
+            if (response['error']) {
+                callback(null, "Nepodařilo se načíst sady předmětů: " + util.errorMessage(response))
+            } else {
+                callback(response['data'], null)
+            }
+        }
+    }
+    util.makeHttpRequest("GET", "bundles/" + (id ? encodeURIComponent(id) : ""), null, onResponse)
+}
+
+export function getEnrollment(login, password, callback) {
+
+    var onResponse = function(responseString, error) {
+        if (error) {
+            callback(null, error)
+        } else if (!responseString) {
+            callback(null, "Server neodpovídá.")
+        } else {
+            var response = JSON.parse(responseString)
+            if (response['error']) {
+                callback(null, "Nepodařilo se načíst zapsané předměty: " + util.errorMessage(response))
+            } else {
+                callback(response['data'], null)
+            }
+        }
+    }
+    util.makeHttpRequest("POST", "enrollment/", JSON.stringify({login: login, password: password}), onResponse)
+}
+
+// With recheck, the server first checks the current occupancy of the chosen groups in SIS
+export function getEnrollmentPlan(queryArray, result, fallbacks, recheck, callback) {
+
+    var onResponse = function(responseString, error) {
+        if (error) {
+            callback(null, error)
+        } else if (!responseString) {
+            callback(null, "Server neodpovídá.")
+        } else {
+            var response = JSON.parse(responseString)
+            if (response['error']) {
+                callback(null, "Nepodařilo se vytvořit plán zápisu: " + util.errorMessage(response))
+            } else {
+                callback(response['data'], null)
+            }
+        }
+    }
+    util.makeHttpRequest("POST", "enrollmentplan/", JSON.stringify({courses: queryArray, result: result, fallbacks: fallbacks, recheck: recheck}), onResponse)
+}
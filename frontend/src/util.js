@@ -8,7 +8,13 @@ export function makeHttpRequest(method, url, body, callback) {
             if (this.status == 200) {
                 callback(request.responseText, null)
             } else {
-                callback(null, request.responseText)
+                var message = request.responseText
+                try {
+                    message = errorMessage(JSON.parse(message))
+                } catch (e) {
+                    // Not JSON, show it as it is
+                }
+                callback(null, message)
             }
         }
     }
@@ -16,13 +22,22 @@ export function makeHttpRequest(method, url, body, callback) {
     request.send(body)
 }
 
+// The error of an API response, with the reference the server logged it under
+export function errorMessage(response) {
+    var message = response['error']
+    if (response['ref']) {
+        message += " (kód chyby: " + response['ref'] + ")"
+    }
+    return message
+}
+
 // Given an event, returns a string representing the day and time of the event
 export function getTimeString(event) {
     var s = ["Po", "Út", "St", "Čt", "Pá"][event.day]
     s += " " + event.time_from + "–" + event.time_to
-    if (event.week_parity == 1) {
+    if (event.week_parity == "odd") {
         s += " (liché týdny)"
-    } else if (event.week_parity == 2) {
+    } else if (event.week_parity == "even") {
         s += " (sudé týdny)"
     }
     return s
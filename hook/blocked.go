@@ -0,0 +1,59 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The name of the hook keeping a time of the week free
+const BLOCKED = "blocked"
+
+// Keeps a time of the week free of classes for everybody, e.g. that of a
+// department colloquium. It is configured as "<day>,<from>-<to>" with the
+// day numbered from Monday = 0, e.g. "2,14:00-15:30" for Wednesday.
+type blocked struct {
+	day      int
+	from, to sisparse.ClockTime
+}
+
+func init() {
+	Register(BLOCKED, func(config string) (Hook, error) {
+		parts := strings.Split(config, ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Expected <day>,<from>-<to>, got %q", config)
+		}
+		day, err := strconv.Atoi(parts[0])
+		if err != nil || day < 0 || day > 6 {
+			return nil, fmt.Errorf("Invalid day %q, expected 0 (Monday) to 6", parts[0])
+		}
+		times := strings.Split(parts[1], "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("Expected <from>-<to>, got %q", parts[1])
+		}
+		from, err := sisparse.ParseClockTime(times[0])
+		if err != nil {
+			return nil, err
+		}
+		to, err := sisparse.ParseClockTime(times[1])
+		if err != nil {
+			return nil, err
+		}
+		if to <= from {
+			return nil, fmt.Errorf("The time %s-%s ends before it starts", from, to)
+		}
+		return blocked{day, from, to}, nil
+	})
+}
+
+func (b blocked) BeforeSolve(ctx context.Context, req *Request) error {
+	req.Rules = append(req.Rules, fmt.Sprintf("not (day = %d and time_from < %s and time_to > %s)", b.day, b.to, b.from))
+	return nil
+}
+
+func (b blocked) AfterSolve(ctx context.Context, req Request, res *Result) error {
+	return nil
+}
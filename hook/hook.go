@@ -0,0 +1,91 @@
+// Package hook lets deployments compile in their own policy for solving
+// (e.g. rules of their faculty) without changing the server. A hook is
+// chosen by name, see Register and Open; it sees each solve request before
+// it is solved, and may add rules to it or refuse it, and then the schedule
+// found, which it may change.
+package hook
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// A course of a solve request with the groups of events to choose from.
+type Course struct {
+	Name       string
+	CourseCode string // Empty if not from SIS
+	Optional   bool
+	Options    [][]sisparse.Event
+}
+
+// A solve request as hooks see it. Only Rules may be changed; the courses
+// are there to be looked at.
+type Request struct {
+	Courses []Course
+	// The rules of the request (in the language of the server's rules,
+	// e.g. `not (day = wed and time_from < 15:30 and time_to > 14:00)`),
+	// which hooks may add to
+	Rules []string
+}
+
+// The schedule found for a request.
+type Result struct {
+	// The option chosen for each course of the request, nil for none.
+	// Hooks may leave courses out or choose other of their options.
+	Chosen []*int
+	// Why each course is or isn't in the schedule, as shown to users
+	// (empty if the solver didn't say); hooks may say why they changed it
+	Explanation []string
+}
+
+type Hook interface {
+	// Called before the request is solved. An error refuses the request,
+	// and is shown to the user.
+	BeforeSolve(ctx context.Context, req *Request) error
+	// Called with the schedule found for the request, unless none was.
+	AfterSolve(ctx context.Context, req Request, res *Result) error
+}
+
+// Creates a hook from its configuration (whose meaning is up to the hook).
+type Factory func(config string) (Hook, error)
+
+var mu sync.Mutex
+var factories = map[string]Factory{}
+
+// Makes a hook available under the given name. Hooks register themselves
+// from init().
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("hook: registered twice: " + name)
+	}
+	factories[name] = f
+}
+
+// Creates the hook registered under the given name.
+func Open(name string, config string) (Hook, error) {
+	mu.Lock()
+	f, ok := factories[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown hook %s (known: %v)", name, Names())
+	}
+	return f(config)
+}
+
+// Returns the names of the registered hooks.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	res := []string{}
+	for name := range factories {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,48 @@
+package objective
+
+import (
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The name of the objective penalizing late classes
+const LATE = "late"
+
+// Penalizes the hours of classes after a time of day ("18:00" by default,
+// or as configured), e.g. for faculties whose students commute.
+type late struct {
+	after sisparse.ClockTime
+}
+
+func init() {
+	Register(LATE, func(config string) (Objective, error) {
+		if config == "" {
+			config = "18:00"
+		}
+		after, err := sisparse.ParseClockTime(config)
+		if err != nil {
+			return nil, err
+		}
+		return late{after}, nil
+	})
+}
+
+// Returns the hours of the events after the time, those of events every
+// other week counted as halves.
+func (l late) Penalty(s Schedule) float64 {
+	res := 0.0
+	for _, e := range s.Events() {
+		if e.TimeTo <= l.after {
+			continue
+		}
+		from := e.TimeFrom
+		if from < l.after {
+			from = l.after
+		}
+		hours := float64(e.TimeTo.Minutes()-from.Minutes()) / 60
+		if e.WeekParity != sisparse.EveryWeek {
+			hours /= 2
+		}
+		res += hours
+	}
+	return res
+}
@@ -0,0 +1,82 @@
+// Package objective lets deployments compile in their own criteria by which
+// schedules are judged (e.g. rules of their faculty) without changing the
+// solver. An objective is chosen by name, see Register and Open, and the
+// server turns its penalty into penalties of the options of courses.
+package objective
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// A course of a schedule with the events of its chosen option.
+type Course struct {
+	Name       string
+	CourseCode string // Empty if not from SIS
+	Events     []sisparse.Event
+}
+
+// A schedule, or a part of it, to be judged.
+type Schedule struct {
+	Courses []Course
+}
+
+// Returns all the events of the schedule.
+func (s Schedule) Events() []sisparse.Event {
+	res := []sisparse.Event{}
+	for _, c := range s.Courses {
+		res = append(res, c.Events...)
+	}
+	return res
+}
+
+type Objective interface {
+	// Returns how bad the schedule is, 0 if it's fine. The penalty of
+	// a schedule shouldn't be less than the sum of those of its courses
+	// alone, as the solver only knows the latter.
+	Penalty(s Schedule) float64
+}
+
+// Creates an objective from its configuration (whose meaning is up to the
+// objective, e.g. a time of day).
+type Factory func(config string) (Objective, error)
+
+var mu sync.Mutex
+var factories = map[string]Factory{}
+
+// Makes an objective available under the given name. Objectives register
+// themselves from init().
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("objective: registered twice: " + name)
+	}
+	factories[name] = f
+}
+
+// Creates the objective registered under the given name.
+func Open(name string, config string) (Objective, error) {
+	mu.Lock()
+	f, ok := factories[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown objective %s (known: %v)", name, Names())
+	}
+	return f(config)
+}
+
+// Returns the names of the registered objectives.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	res := []string{}
+	for name := range factories {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,230 @@
+// Access log: a JSON line for each request (or a sample of them), so that
+// abuse of the public instance can be looked into. The IPs of clients are
+// anonymized as configured, the query strings are left out and the tokens
+// in paths (of saved schedules, jobs...) are replaced, so the log keeps
+// little which could identify the students.
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How the IPs of clients are logged, see -access-log-ip
+const (
+	// As they are
+	IP_FULL = "full"
+	// Only the network: /24 of IPv4, /48 of IPv6
+	IP_TRUNCATE = "truncate"
+	// A keyed hash, the key being replaced daily and never stored, so that
+	// the requests of a client can be matched within a day but not later
+	IP_HASH = "hash"
+	// Not at all
+	IP_NONE = "none"
+)
+
+var ipModes = []string{IP_FULL, IP_TRUNCATE, IP_HASH, IP_NONE}
+
+// How long the key of IP_HASH is used
+const IP_HASH_KEY_LIFETIME = 24 * time.Hour
+
+// Path segments of at least this many hex digits are taken for tokens
+const MIN_LOGGED_TOKEN_LENGTH = 16
+
+// The longest user agent logged, in bytes
+const MAX_LOGGED_USER_AGENT = 200
+
+type accessLogConfig struct {
+	// Where the lines are written
+	Out    io.Writer
+	IpMode string
+	// By path prefix, the fraction of the requests to log; the longest
+	// matching prefix applies, and paths matching none are all logged.
+	// Failed requests (with status 400 and above) are always logged.
+	Sampling map[string]float64
+}
+
+// A line of the access log
+type accessLogEntry struct {
+	Time       string `json:"time"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	Bytes      int    `json:"bytes"`
+	DurationMs int64  `json:"duration_ms"`
+	Ip         string `json:"ip,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	TraceId    string `json:"trace_id,omitempty"`
+	RequestId  string `json:"request_id,omitempty"`
+	// Less than 1 if only a sample of such requests is logged
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+// Parses the sampling of -access-log-sample, given as comma-separated
+// <path prefix>=<rate>, e.g. "/healthz=0,/courseinfo/=0.1".
+func parseAccessLogSampling(s string) (map[string]float64, error) {
+	sampling := map[string]float64{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], "/") {
+			return nil, fmt.Errorf("Expected <path prefix>=<rate>, got %s", part)
+		}
+		rate, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("Invalid rate of %s, expected a number from 0 to 1", kv[0])
+		}
+		sampling[kv[0]] = rate
+	}
+	return sampling, nil
+}
+
+func isIpMode(mode string) bool {
+	for _, m := range ipModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+type accessLogger struct {
+	config accessLogConfig
+	mu     sync.Mutex
+	// The key of IP_HASH and when it was made
+	hashKey     []byte
+	hashKeyTime time.Time
+}
+
+// Logs the requests to the handler as configured.
+func accessLogMiddleware(config accessLogConfig, next http.Handler) http.Handler {
+	l := &accessLogger{config: config}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &countingRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		rate := l.sampleRate(r.URL.Path)
+		if rec.status < 400 && (rate <= 0 || rate < 1 && mrand.Float64() >= rate) {
+			return
+		}
+		entry := accessLogEntry{
+			Time:       start.UTC().Format(time.RFC3339),
+			Method:     r.Method,
+			Path:       redactPath(r.URL.Path),
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			DurationMs: time.Since(start).Nanoseconds() / int64(time.Millisecond),
+			Ip:         l.anonymizeIp(clientAddr(r)),
+			UserAgent:  ellipsis(r.UserAgent(), MAX_LOGGED_USER_AGENT),
+			TraceId:    traceId(r.Context()),
+			// Set by requestIdMiddleware, further in
+			RequestId: w.Header().Get("X-Request-ID"),
+		}
+		if rec.status < 400 && rate < 1 {
+			entry.SampleRate = rate
+		}
+		line, _ := json.Marshal(entry)
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if _, err := l.config.Out.Write(append(line, '\n')); err != nil {
+			log.Printf("Could not write the access log: %s", err)
+		}
+	})
+}
+
+func (l *accessLogger) sampleRate(path string) float64 {
+	rate, longest := 1.0, -1
+	for prefix, r := range l.config.Sampling {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			rate, longest = r, len(prefix)
+		}
+	}
+	return rate
+}
+
+func (l *accessLogger) anonymizeIp(ip string) string {
+	switch l.config.IpMode {
+	case IP_FULL:
+		return ip
+	case IP_TRUNCATE:
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return ""
+		}
+		if v4 := parsed.To4(); v4 != nil {
+			return v4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+		}
+		return parsed.Mask(net.CIDRMask(48, 128)).String() + "/48"
+	case IP_HASH:
+		l.mu.Lock()
+		if l.hashKey == nil || time.Since(l.hashKeyTime) > IP_HASH_KEY_LIFETIME {
+			l.hashKey = make([]byte, 32)
+			if _, err := rand.Read(l.hashKey); err != nil {
+				l.mu.Unlock()
+				return ""
+			}
+			l.hashKeyTime = time.Now()
+		}
+		mac := hmac.New(sha256.New, l.hashKey)
+		l.mu.Unlock()
+		mac.Write([]byte(ip))
+		return hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	return ""
+}
+
+// Replaces the segments of the path which look like tokens (e.g. of saved
+// schedules) by ":token", as they give access to the data of students.
+func redactPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		if len(s) >= MIN_LOGGED_TOKEN_LENGTH && isHex(s) {
+			segments[i] = ":token"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// Records the status and the size of a response.
+type countingRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *countingRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *countingRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
@@ -0,0 +1,111 @@
+// Cross-listed courses: SIS lists some courses under several codes with a
+// shared timetable, whose events then have the same SIS codes. Such courses
+// are recognized by their groups: the answers for either code name the
+// others as aliases, and the solver schedules them only once.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// Identifies the timetable of a course by the SIS codes of its groups, or
+// "" if some group has none (and so can't be told from other courses').
+func groupsKey(groups [][]sisparse.Event) string {
+	ids := []string{}
+	for _, g := range groups {
+		if len(g) == 0 || g[0].Code == "" {
+			return ""
+		}
+		ids = append(ids, g[0].GroupID)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// Returns the other codes of the course, as far as the cached courses tell.
+func (idx *searchIndex) aliases(code string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	res := []string{}
+	for c := range idx.byGroups[idx.groupKeys[code]] {
+		if c != code {
+			res = append(res, c)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Adds "aliases" (the other codes of a cross-listed course) and
+// "canonical" (the first of all its codes, by which it is best referred
+// to) to an answer made by queryCourse, if the course has any aliases.
+func withAliases(ctx context.Context, code, res string) string {
+	aliases := tenantOf(ctx).index.aliases(code)
+	if len(aliases) == 0 {
+		return res
+	}
+	var answer map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(res), &answer); err != nil {
+		return res
+	}
+	canonical := code
+	if aliases[0] < canonical {
+		canonical = aliases[0]
+	}
+	answer["aliases"], _ = json.Marshal(aliases)
+	answer["canonical"], _ = json.Marshal(canonical)
+	s, err := json.Marshal(answer)
+	if err != nil {
+		return res
+	}
+	return string(s)
+}
+
+// Leaves the courses which have the same groups as an earlier course of
+// the query (cross-listed ones, or a course given twice) out of it, so
+// that the shared timetable isn't scheduled twice. courses are those of
+// the original query.
+func (q *filteredQuery) leaveOutCrossListed(ctx context.Context, courses []solveCourse) error {
+	var kept []json.RawMessage
+	if err := json.Unmarshal(q.Query, &kept); err != nil {
+		return err
+	}
+	// The first course left in the query with each timetable
+	first := map[string]int{}
+	newKept := []json.RawMessage{}
+	for ci, k := range q.courseIndex {
+		if k == -1 {
+			continue
+		}
+		var groups [][]sisparse.Event
+		json.Unmarshal(courses[ci].Options, &groups)
+		key := groupsKey(groups)
+		if other, ok := first[key]; ok && key != "" {
+			q.courseIndex[ci] = -1
+			q.Unschedulable = append(q.Unschedulable, unschedulableCourse{
+				Index:  ci,
+				Name:   courses[ci].Name,
+				Code:   "cross_listed",
+				Reason: localize(ctx, "cross_listed", courses[other].Name),
+			})
+			continue
+		}
+		first[key] = ci
+		q.courseIndex[ci] = len(newKept)
+		newKept = append(newKept, kept[k])
+	}
+	if len(newKept) == len(kept) {
+		return nil
+	}
+	sort.Slice(q.Unschedulable, func(i, j int) bool {
+		return q.Unschedulable[i].Index < q.Unschedulable[j].Index
+	})
+	var err error
+	q.Query, err = json.Marshal(newKept)
+	return err
+}
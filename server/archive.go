@@ -0,0 +1,171 @@
+// Exporting the whole cache to a single file and importing it elsewhere,
+// so that a new deployment doesn't have to query SIS for every course again.
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Increase when the structure of the archive changes
+const ARCHIVE_VERSION = 1
+
+type cacheArchive struct {
+	Version int                 `json:"version"`
+	Created time.Time           `json:"created"`
+	Entries []cacheArchiveEntry `json:"entries"`
+}
+
+type cacheArchiveEntry struct {
+	Key     string    `json:"key"`
+	Value   string    `json:"value"`
+	Updated time.Time `json:"updated"`
+}
+
+// Returns all cache entries as an archive.
+func snapshotCache() (cacheArchive, error) {
+	archive := cacheArchive{Version: ARCHIVE_VERSION, Created: time.Now()}
+	keys, err := db.ListCache("")
+	if err != nil {
+		return archive, err
+	}
+	for _, k := range keys {
+		if strings.HasPrefix(k, NEGATIVE_CACHE_PREFIX) || strings.HasPrefix(k, SETTINGS_PREFIX) {
+			// Only worth keeping for minutes, or about this deployment
+			continue
+		}
+		e, err := db.GetCache(k)
+		if err != nil {
+			return archive, err
+		}
+		archive.Entries = append(archive.Entries, cacheArchiveEntry{e.Key, e.Value, e.Updated})
+	}
+	return archive, nil
+}
+
+// Writes all cache entries to w as gzipped JSON.
+func exportCache(w io.Writer) (int, error) {
+	archive, err := snapshotCache()
+	if err != nil {
+		return 0, err
+	}
+
+	zw := gzip.NewWriter(w)
+	if err := json.NewEncoder(zw).Encode(archive); err != nil {
+		return 0, err
+	}
+	return len(archive.Entries), zw.Close()
+}
+
+// Reads an archive made by exportCache().
+func readCacheArchive(r io.Reader) (cacheArchive, error) {
+	var archive cacheArchive
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return archive, err
+	}
+	if err := json.NewDecoder(zr).Decode(&archive); err != nil {
+		return archive, err
+	}
+	if archive.Version != ARCHIVE_VERSION {
+		return archive, fmt.Errorf("Unsupported archive version %d (expected %d)", archive.Version, ARCHIVE_VERSION)
+	}
+	return archive, nil
+}
+
+func readCacheArchiveFile(filename string) (cacheArchive, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return cacheArchive{}, err
+	}
+	defer f.Close()
+	return readCacheArchive(f)
+}
+
+// Reads an archive made by exportCache() and stores its entries,
+// overwriting the ones with the same keys.
+func importCache(r io.Reader) (int, error) {
+	archive, err := readCacheArchive(r)
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range archive.Entries {
+		if err := db.SetCache(e.Key, e.Value); err != nil {
+			return 0, err
+		}
+	}
+	memoryCache.clear()
+	return len(archive.Entries), buildSearchIndex()
+}
+
+func exportCacheToFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	n, err := exportCache(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	log.Printf("Exported %d cache entries to %s", n, filename)
+	return f.Close()
+}
+
+func importCacheFromFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := importCache(f)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %d cache entries from %s", n, filename)
+	return nil
+}
+
+// GET /admin/cache/export downloads the archive,
+// POST /admin/cache/import with the archive as the body imports it.
+func cacheArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/admin/cache/export" && r.Method == "GET":
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="samorozvrh-cache-%s.json.gz"`, time.Now().Format("2006-01-02")))
+		if _, err := exportCache(w); err != nil {
+			log.Printf("Cache export error: %s", err)
+		}
+	case r.URL.Path == "/admin/cache/import" && r.Method == "POST":
+		n, err := importCache(r.Body)
+		r.Body.Close()
+		if err != nil {
+			log.Printf("Cache import error: %s", err)
+			fmt.Fprintf(w, `{"error":"%s"}`, err)
+			return
+		}
+		fmt.Fprintf(w, `{"data":{"imported":%d}}`, n)
+	case r.URL.Path == "/admin/cache/stats":
+		s, err := json.Marshal(struct {
+			lruStats
+			Hits   int64 `json:"negative_hits"`
+			Stored int64 `json:"negative_stored"`
+		}{memoryCache.getStats(), atomic.LoadInt64(&negativeCacheStats.Hits), atomic.LoadInt64(&negativeCacheStats.Stored)})
+		if err != nil {
+			fmt.Fprintf(w, `{"error":"%s"}`, err)
+			return
+		}
+		fmt.Fprintf(w, `{"data":%s}`, string(s))
+	default:
+		http.NotFound(w, r)
+	}
+}
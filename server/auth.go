@@ -0,0 +1,140 @@
+// Token authentication of the endpoints which shouldn't be public.
+// Tokens are sent as "Authorization: Bearer <token>" and are either static
+// (given on the command line) or issued per user and kept in the store.
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// Scopes a token can be granted. The admin scope implies all the others.
+// Endpoints changing the data of users aren't behind a scope, as the
+// webapp uses them anonymously; they check the tokens of the users instead
+// (see PROFILE_TOKEN_HEADER and SCHEDULE_OWNER_HEADER).
+const (
+	SCOPE_ADMIN = "admin"
+)
+
+var knownScopes = []string{SCOPE_ADMIN}
+
+// Static tokens by the hash of the token
+var staticTokens = map[string]store.Token{}
+
+// Parses a static token given as "<token>:<scope>[,<scope>...]" and adds it
+// to the accepted ones.
+func addStaticToken(spec string) error {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return errors.New("Expected <token>:<scopes>")
+	}
+	scopes, err := parseScopes(parts[1])
+	if err != nil {
+		return err
+	}
+	hash := hashToken(parts[0])
+	staticTokens[hash] = store.Token{Hash: hash, Name: "static", Scopes: scopes}
+	return nil
+}
+
+func parseScopes(s string) ([]string, error) {
+	scopes := []string{}
+	for _, scope := range strings.Split(s, ",") {
+		scope = strings.TrimSpace(scope)
+		if !isKnownScope(scope) {
+			return nil, fmt.Errorf("Unknown scope \"%s\", expected one of %s", scope, strings.Join(knownScopes, ", "))
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+func isKnownScope(scope string) bool {
+	for _, s := range knownScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Issues a new token with the given scopes and saves it to the store.
+// The token itself is returned only here, the store keeps just its hash.
+func createToken(name string, scopes []string) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	err := db.SaveToken(store.Token{Hash: hashToken(token), Name: name, Scopes: scopes})
+	return token, err
+}
+
+// Deletes the token from the store, given either as it was printed by
+// createToken or by its hash (e.g. when the token itself is lost).
+func revokeToken(tokenOrHash string) error {
+	err := db.DeleteToken(hashToken(tokenOrHash))
+	if err == store.ErrNotFound {
+		err = db.DeleteToken(tokenOrHash)
+	}
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hasScope(t store.Token, scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == SCOPE_ADMIN {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the token the request was made with.
+func getRequestToken(r *http.Request) (store.Token, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return store.Token{}, store.ErrNotFound
+	}
+	hash := hashToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer ")))
+	if t, ok := staticTokens[hash]; ok {
+		return t, nil
+	}
+	return db.GetToken(hash)
+}
+
+// Lets the request through only if it carries a token with the scope.
+func requireScope(scope string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, err := getRequestToken(r)
+		if err == store.ErrNotFound {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="samorozvrh"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"A valid API token is required"}`)
+			return
+		} else if err != nil {
+			log.Printf("Token lookup error: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"error":"Could not verify the API token"}`)
+			return
+		}
+		if !hasScope(t, scope) {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"error":"The API token lacks the %s scope"}`, scope)
+			return
+		}
+		handler(w, r)
+	}
+}
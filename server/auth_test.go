@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+func TestRevokeToken(t *testing.T) {
+	useSqliteStore(t)
+	byToken, err := createToken("alice", []string{SCOPE_ADMIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byHash, err := createToken("bob", []string{SCOPE_ADMIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := revokeToken(byToken); err != nil {
+		t.Errorf("Revoking by the token: %s", err)
+	}
+	if err := revokeToken(hashToken(byHash)); err != nil {
+		t.Errorf("Revoking by the hash: %s", err)
+	}
+	for _, token := range []string{byToken, byHash} {
+		if _, err := db.GetToken(hashToken(token)); err != store.ErrNotFound {
+			t.Errorf("Revoked token: %v, want %v", err, store.ErrNotFound)
+		}
+	}
+	if err := revokeToken(byToken); err != store.ErrNotFound {
+		t.Errorf("Revoking a revoked token: %v, want %v", err, store.ErrNotFound)
+	}
+}
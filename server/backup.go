@@ -0,0 +1,240 @@
+// Backups of the data store: the cache (as exported by archive.go), the
+// saved schedules and the preference profiles in a single file, which can
+// be restored on another host or after the database got corrupted. Each
+// part is checksummed, and a backup is checked whole before anything of it
+// is restored.
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// Increase when the structure of the backups changes
+const BACKUP_VERSION = 1
+
+// The first file of a backup, describing the others
+const BACKUP_MANIFEST = "manifest.json"
+
+type backupManifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// By the name of the file in the backup
+	Parts map[string]backupPart `json:"parts"`
+}
+
+type backupPart struct {
+	Sha256 string `json:"sha256"`
+	Items  int    `json:"items"`
+}
+
+// The data of a backup, a file of the backup each
+type backupData struct {
+	Cache     cacheArchive
+	Schedules []store.Schedule
+	Profiles  []store.Profile
+}
+
+// Returns the data of the store to back up.
+func snapshotStore() (backupData, error) {
+	var data backupData
+	var err error
+	if data.Cache, err = snapshotCache(); err != nil {
+		return data, err
+	}
+	if data.Schedules, err = db.ListSchedules(); err != nil {
+		return data, err
+	}
+	data.Profiles, err = db.ListAllProfiles()
+	return data, err
+}
+
+// Writes the data as a gzipped tar with the manifest first.
+func writeBackup(w io.Writer, data backupData) (backupManifest, error) {
+	manifest := backupManifest{Version: BACKUP_VERSION, Created: time.Now().UTC(), Parts: map[string]backupPart{}}
+	parts := map[string][]byte{}
+	add := func(name string, v interface{}, items int) error {
+		s, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(s)
+		parts[name] = s
+		manifest.Parts[name] = backupPart{Sha256: hex.EncodeToString(sum[:]), Items: items}
+		return nil
+	}
+	if err := add("cache.json", data.Cache, len(data.Cache.Entries)); err != nil {
+		return manifest, err
+	}
+	if err := add("schedules.json", data.Schedules, len(data.Schedules)); err != nil {
+		return manifest, err
+	}
+	if err := add("profiles.json", data.Profiles, len(data.Profiles)); err != nil {
+		return manifest, err
+	}
+	m, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, name := range []string{BACKUP_MANIFEST, "cache.json", "schedules.json", "profiles.json"} {
+		content := parts[name]
+		if name == BACKUP_MANIFEST {
+			content = m
+		}
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), ModTime: manifest.Created}
+		if err := tw.WriteHeader(header); err != nil {
+			return manifest, err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return manifest, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, zw.Close()
+}
+
+// Reads a backup made by writeBackup, failing if any of its parts is
+// missing or doesn't match its checksum.
+func readBackup(r io.Reader) (backupData, backupManifest, error) {
+	var data backupData
+	var manifest backupManifest
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return data, manifest, err
+	}
+	tr := tar.NewReader(zr)
+	parts := map[string][]byte{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return data, manifest, fmt.Errorf("Damaged backup: %s", err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return data, manifest, fmt.Errorf("Damaged backup: %s", err)
+		}
+		parts[header.Name] = content
+	}
+	if err := json.Unmarshal(parts[BACKUP_MANIFEST], &manifest); err != nil {
+		return data, manifest, fmt.Errorf("The backup has no valid %s", BACKUP_MANIFEST)
+	}
+	if manifest.Version != BACKUP_VERSION {
+		return data, manifest, fmt.Errorf("Unsupported backup version %d (expected %d)", manifest.Version, BACKUP_VERSION)
+	}
+	targets := map[string]interface{}{
+		"cache.json":     &data.Cache,
+		"schedules.json": &data.Schedules,
+		"profiles.json":  &data.Profiles,
+	}
+	for name, target := range targets {
+		part, ok := manifest.Parts[name]
+		content, found := parts[name]
+		if !ok || !found {
+			return data, manifest, fmt.Errorf("The backup lacks %s", name)
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != part.Sha256 {
+			return data, manifest, fmt.Errorf("The checksum of %s doesn't match, the backup is damaged", name)
+		}
+		if err := json.Unmarshal(content, target); err != nil {
+			return data, manifest, fmt.Errorf("Invalid %s: %s", name, err)
+		}
+	}
+	if data.Cache.Version != ARCHIVE_VERSION {
+		return data, manifest, fmt.Errorf("Unsupported cache archive version %d (expected %d)", data.Cache.Version, ARCHIVE_VERSION)
+	}
+	return data, manifest, nil
+}
+
+// Stores the data of a backup, overwriting the entries, schedules and
+// profiles with the same keys (and leaving the others as they are).
+func restoreBackup(data backupData) error {
+	for _, e := range data.Cache.Entries {
+		if err := db.SetCache(e.Key, e.Value); err != nil {
+			return err
+		}
+	}
+	memoryCache.clear()
+	for _, s := range data.Schedules {
+		if err := db.SaveSchedule(s); err != nil {
+			return err
+		}
+	}
+	for _, p := range data.Profiles {
+		if err := db.SaveProfile(p); err != nil {
+			return err
+		}
+	}
+	return buildSearchIndex()
+}
+
+// Backs the store up to a new file in the directory, named by the time,
+// e.g. "samorozvrh-backup-20241007-153000.tar.gz", and reads it back to
+// check it.
+func backupToDir(dir string) error {
+	data, err := snapshotStore()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	filename := filepath.Join(dir, "samorozvrh-backup-"+time.Now().Format("20060102-150405")+".tar.gz")
+	var buf bytes.Buffer
+	manifest, err := writeBackup(&buf, data)
+	if err != nil {
+		return err
+	}
+	if _, _, err := readBackup(bytes.NewReader(buf.Bytes())); err != nil {
+		return fmt.Errorf("The backup doesn't read back: %s", err)
+	}
+	// Written under another name first, so that a failed backup never
+	// looks complete
+	if err := ioutil.WriteFile(filename+".part", buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(filename+".part", filename); err != nil {
+		return err
+	}
+	log.Printf("Backed up %d cache entries, %d schedules and %d profiles to %s",
+		manifest.Parts["cache.json"].Items, manifest.Parts["schedules.json"].Items, manifest.Parts["profiles.json"].Items, filename)
+	return nil
+}
+
+func restoreFromFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, manifest, err := readBackup(f)
+	if err != nil {
+		return err
+	}
+	if err := restoreBackup(data); err != nil {
+		return err
+	}
+	log.Printf("Restored %d cache entries, %d schedules and %d profiles from the backup of %s",
+		len(data.Cache.Entries), len(data.Schedules), len(data.Profiles), manifest.Created.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,146 @@
+// Resolving many course codes in one request.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// How many courses of a batch are fetched from SIS at the same time
+const BATCH_CONCURRENCY = 4
+
+// The largest number of course codes accepted in one batch
+const MAX_BATCH_SIZE = 50
+
+type batchRequest struct {
+	Codes []string `json:"codes"`
+	// sisparse.Winter or sisparse.Summer, the current semester by default
+	Semester int `json:"semester"`
+	// The academic year (as in ?year= of /sisquery/), the current one by default
+	Year int `json:"year"`
+}
+
+// Answers POST requests with a body such as {"codes":["NPRG030","NTIN061"]}
+// with {"data":{"NPRG030":{"data":[...]},"NTIN061":{"error":"..."}}},
+// i.e. the same answer /sisquery/ would give, for each of the codes.
+// Courses over the fetch budget (see fetchbudget.go) are listed in
+// "pending" with "retry_after" (in seconds, also in the Retry-After header).
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use POST"}`)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		log.Printf("Batch error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	var req batchRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		fmt.Fprint(w, `{"error":"Expected {\"codes\":[...]}"}`)
+		return
+	}
+	if len(req.Codes) > MAX_BATCH_SIZE {
+		fmt.Fprintf(w, `{"error":"At most %d courses can be requested at once"}`, MAX_BATCH_SIZE)
+		return
+	}
+
+	log.Printf("Batch: %s", ellipsis(strings.Join(req.Codes, ","), 30))
+	current := currentTerm(r.Context())
+	if req.Semester != sisparse.Winter && req.Semester != sisparse.Summer {
+		req.Semester = current.Semester
+	}
+	if req.Year == 0 {
+		req.Year = current.Year
+	} else if !isValidYear(r.Context(), req.Year) {
+		fmt.Fprintf(w, `{"error":"Invalid year: %d"}`, req.Year)
+		return
+	}
+	ctx := withFetchBudget(sisparse.WithAcademicYear(r.Context(), req.Year))
+	results := queryCourses(ctx, req.Codes, req.Semester)
+	res, err := json.Marshal(results)
+	if err != nil {
+		log.Printf("Batch error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	if pending := pendingCourses(results); len(pending) > 0 {
+		retryAfter := int(fetches.retryAfter() / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		p, _ := json.Marshal(pending)
+		fmt.Fprintf(w, `{"data":%s,"pending":%s,"retry_after":%d}`, string(res), string(p), retryAfter)
+		return
+	}
+	fmt.Fprintf(w, `{"data":%s}`, string(res))
+}
+
+// Queries the given courses concurrently; each code is queried only once
+// even if it is listed multiple times.
+func queryCourses(ctx context.Context, codes []string, semester int) map[string]json.RawMessage {
+	results := map[string]json.RawMessage{}
+	seen := map[string]bool{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, BATCH_CONCURRENCY)
+
+	for _, code := range codes {
+		code = sisparse.NormalizeCourseCode(code)
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+
+		wg.Add(1)
+		go func(code string) {
+			defer wg.Done()
+			var res string
+			var err error
+			if !sisparse.IsCourseCode(code) {
+				err = fmt.Errorf("Invalid course code")
+			} else {
+				sem <- struct{}{}
+				res, err = queryCourse(ctx, code, semester)
+				<-sem
+			}
+			if err != nil {
+				logf(ctx, "Batch error for %s: %s", code, err)
+				res = courseErrorJSON(ctx, code, err)
+			}
+			mu.Lock()
+			results[code] = json.RawMessage(res)
+			mu.Unlock()
+		}(code)
+	}
+	wg.Wait()
+	return results
+}
+
+// Returns the codes of the courses which queryCourses queued, see
+// ErrFetchQueued.
+func pendingCourses(results map[string]json.RawMessage) []string {
+	pending := []string{}
+	for code, res := range results {
+		var answer struct {
+			ErrorCode string `json:"error_code"`
+		}
+		if json.Unmarshal(res, &answer) == nil && answer.ErrorCode == errorCode(ErrFetchQueued) {
+			pending = append(pending, code)
+		}
+	}
+	sort.Strings(pending)
+	return pending
+}
@@ -0,0 +1,159 @@
+// A benchmark of the solver and the parser on a corpus of course sets, run
+// by -benchmark, so that changes affecting their speed can be measured.
+// Each set is a solver query in its own file; budgets.json in the same
+// directory says how long each may take.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The corpus, relative to rootdir
+const BENCHMARK_DIR = "solver/fixtures/bench"
+
+// The seed of the solver, so that the runs are comparable
+const BENCHMARK_SEED = 1
+
+// The budgets of a course set, in milliseconds of the median run; 0 for
+// no budget
+type benchmarkBudget struct {
+	Solve int `json:"solve_ms"`
+	Parse int `json:"parse_ms"`
+}
+
+type benchmarkResult struct {
+	Name            string
+	Courses, Groups int
+	Solve, Parse    time.Duration // The median runs
+	Budget          benchmarkBudget
+}
+
+func (r benchmarkResult) overBudget() bool {
+	return (r.Budget.Solve > 0 && r.Solve > time.Duration(r.Budget.Solve)*time.Millisecond) ||
+		(r.Budget.Parse > 0 && r.Parse > time.Duration(r.Budget.Parse)*time.Millisecond)
+}
+
+// Runs the solver and the parser on each course set of the directory runs
+// times and writes the median times to w. Returns false if any of them is
+// over its budget.
+func runBenchmark(w io.Writer, dir string, runs int) (bool, error) {
+	budgets, files, err := loadBenchmark(dir)
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	fmt.Fprintf(w, "%-16s %7s %6s %10s %10s %10s %10s\n", "set", "courses", "groups", "solve", "budget", "parse", "budget")
+	for _, file := range files {
+		name := filepath.Base(file)
+		query, err := ioutil.ReadFile(file)
+		if err != nil {
+			return false, err
+		}
+		res, err := benchmarkCourseSet(query, runs)
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", name, err)
+		}
+		res.Name, res.Budget = name, budgets[name]
+		mark := ""
+		if res.overBudget() {
+			ok, mark = false, "  OVER BUDGET"
+		}
+		fmt.Fprintf(w, "%-16s %7d %6d %10s %10s %10s %10s%s\n", res.Name, res.Courses, res.Groups,
+			res.Solve.Round(time.Millisecond), formatBudget(res.Budget.Solve),
+			res.Parse.Round(time.Microsecond), formatBudget(res.Budget.Parse), mark)
+	}
+	return ok, nil
+}
+
+// Returns the budgets of the course sets of the directory by file name,
+// and the files of the sets, sorted.
+func loadBenchmark(dir string) (map[string]benchmarkBudget, []string, error) {
+	budgets := map[string]benchmarkBudget{}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "budgets.json")); err == nil {
+		if err := json.Unmarshal(data, &budgets); err != nil {
+			return nil, nil, fmt.Errorf("Invalid budgets: %s", err)
+		}
+	}
+	all, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	files := []string{}
+	for _, file := range all {
+		if filepath.Base(file) != "budgets.json" {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+	return budgets, files, nil
+}
+
+func formatBudget(ms int) string {
+	if ms == 0 {
+		return "-"
+	}
+	return (time.Duration(ms) * time.Millisecond).String()
+}
+
+func benchmarkCourseSet(query []byte, runs int) (benchmarkResult, error) {
+	var res benchmarkResult
+	var courses []struct {
+		Name    string             `json:"name"`
+		Options [][]sisparse.Event `json:"options"`
+	}
+	if err := json.Unmarshal(query, &courses); err != nil {
+		return res, err
+	}
+	res.Courses = len(courses)
+	pages := [][]byte{}
+	for _, c := range courses {
+		res.Groups += len(c.Options)
+		pages = append(pages, sisparse.RenderSchedulePage(c.Options))
+	}
+
+	seed := int64(BENCHMARK_SEED)
+	solveTimes, parseTimes := []time.Duration{}, []time.Duration{}
+	for i := 0; i < runs; i++ {
+		start := time.Now()
+		answer, err := Solve(context.Background(), query, solverOptions{Seed: &seed})
+		if err != nil {
+			return res, fmt.Errorf("Solver failed: %s", err)
+		}
+		solveTimes = append(solveTimes, time.Since(start))
+		var solved struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(answer, &solved); err != nil || solved.Error != "" {
+			return res, fmt.Errorf("Solver failed: %s%s", solved.Error, err)
+		}
+
+		start = time.Now()
+		for _, page := range pages {
+			if _, err := sisparse.ParseSchedule(bytes.NewReader(page), "", 0); err != nil {
+				return res, fmt.Errorf("Parser failed: %s", err)
+			}
+		}
+		parseTimes = append(parseTimes, time.Since(start))
+	}
+	res.Solve, res.Parse = medianDuration(solveTimes), medianDuration(parseTimes)
+	return res, nil
+}
+
+func medianDuration(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[len(ds)/2]
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Solves each course set of BENCHMARK_DIR as -benchmark does, failing if
+// it takes longer than the budget of the set. Needs the solver with its
+// dependencies, as the server does.
+func BenchmarkSolve(b *testing.B) {
+	rootDir = ".."
+	budgets, files, err := loadBenchmark(filepath.Join(rootDir, BENCHMARK_DIR))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		query, err := ioutil.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		budget := time.Duration(budgets[name].Solve) * time.Millisecond
+		b.Run(name, func(b *testing.B) {
+			seed := int64(BENCHMARK_SEED)
+			for i := 0; i < b.N; i++ {
+				answer, err := Solve(context.Background(), query, solverOptions{Seed: &seed})
+				if err != nil {
+					b.Fatalf("Solver failed: %s", err)
+				}
+				var solved struct {
+					Error string `json:"error"`
+				}
+				if err := json.Unmarshal(answer, &solved); err != nil || solved.Error != "" {
+					b.Fatalf("Solver failed: %s%v", solved.Error, err)
+				}
+			}
+			if per := b.Elapsed() / time.Duration(b.N); budget > 0 && per > budget {
+				b.Errorf("%s per run, over the budget of %s", per, budget)
+			}
+		})
+	}
+}
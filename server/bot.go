@@ -0,0 +1,242 @@
+// Chat bots: students can look up courses, find schedules and follow the
+// changes of courses from Telegram (telegram.go) or Discord (discord.go).
+// The commands are the same on each platform and go through the same
+// functions as the API does.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// The longest a solve from a chat may run, in seconds; chats wait for the
+// answer
+const BOT_SOLVE_TIMEOUT = 30
+
+// The most courses a solve from a chat may have
+const MAX_BOT_SOLVE_COURSES = 15
+
+// Runs the command of a chat (without the slash, e.g. "course") with its
+// arguments and returns the answer, in the language of ctx. The chat is
+// identified by target, as the target of its subscriptions, and the user
+// sending the command by user (e.g. "telegram:<id>"), whose quotas (see
+// quota.go) the command uses.
+func runBotCommand(ctx context.Context, target, user, command string, args []string) string {
+	ctx, _ = withQuotaClient(ctx, "bot:"+user)
+	for i := range args {
+		args[i] = strings.ToUpper(args[i])
+	}
+	switch command {
+	case "start", "help":
+		return localize(ctx, "bot_help")
+	case "course":
+		if len(args) != 1 {
+			return localize(ctx, "bot_usage", "/course <code>")
+		}
+		return botCourse(ctx, args[0])
+	case "solve":
+		if len(args) == 0 || len(args) > MAX_BOT_SOLVE_COURSES {
+			return localize(ctx, "bot_usage", "/solve <code> <code> ...")
+		}
+		return botSolve(ctx, args)
+	case "follow", "unfollow":
+		if len(args) != 1 || !sisparse.IsCourseCode(args[0]) {
+			return localize(ctx, "bot_usage", "/"+command+" <code>")
+		}
+		return botFollow(ctx, target, args[0], command == "follow")
+	case "following":
+		subs, err := db.ListTargetSubscriptions(target)
+		if err != nil {
+			logf(ctx, "Bot error: %s", err)
+			return localize(ctx, "bot_error")
+		}
+		if len(subs) == 0 {
+			return localize(ctx, "bot_following_none")
+		}
+		codes := []string{}
+		for _, sub := range subs {
+			codes = append(codes, sub.CourseCode)
+		}
+		return localize(ctx, "bot_following", strings.Join(codes, ", "))
+	case "link", "unlink":
+		if len(args) != 1 {
+			return localize(ctx, "bot_usage", "/"+command+" <code>")
+		}
+		return botLink(ctx, target, strings.ToLower(args[0]), command == "link")
+	}
+	return localize(ctx, "bot_unknown_command")
+}
+
+// Lists the groups of the course in the current semester.
+func botCourse(ctx context.Context, code string) string {
+	groups, err := getCourseGroups(ctx, code)
+	if err != nil {
+		if errorCode(err) != "" {
+			return localize(ctx, errorCode(err))
+		}
+		logf(ctx, "Bot error for %s: %s", code, err)
+		return localize(ctx, "bot_error")
+	}
+	if len(groups) == 0 {
+		return localize(ctx, "bot_no_groups", code)
+	}
+	lines := []string{code + ":"}
+	for i, g := range groups {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, describeGroup(ctx, g)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Finds a schedule of the courses (in the current semester) with the
+// default settings, as a solve request with just the courses would.
+func botSolve(ctx context.Context, codes []string) string {
+	timeout := BOT_SOLVE_TIMEOUT
+	req := solveRequest{Version: SOLVE_SCHEMA_VERSION, Timeout: &timeout}
+	ctx = withFetchBudget(ctx)
+	pending := []string{}
+	for _, code := range codes {
+		groups, err := getCourseGroups(ctx, code)
+		if err == ErrFetchQueued {
+			pending = append(pending, code)
+			continue
+		}
+		if err != nil {
+			if errorCode(err) != "" {
+				return code + ": " + localize(ctx, errorCode(err))
+			}
+			logf(ctx, "Bot error for %s: %s", code, err)
+			return localize(ctx, "bot_error")
+		}
+		options, _ := json.Marshal(groups)
+		req.Courses = append(req.Courses, solveCourse{Name: code, CourseCode: code, Options: options})
+	}
+	if len(pending) > 0 {
+		return localize(ctx, "bot_fetch_queued", strings.Join(pending, ", "), int(fetches.retryAfter()/time.Second))
+	}
+	body, _ := json.Marshal(req)
+	job := startJob(body, "")
+	res, err := solveWithRules(ctx, body)
+	if solverContext.Err() != nil {
+		return localize(ctx, "bot_error")
+	}
+	finishJob(job, res, err)
+	var answer solveResponse
+	if err == nil {
+		err = json.Unmarshal(res, &answer)
+	}
+	if errorCode(err) != "" {
+		return localize(ctx, errorCode(err))
+	}
+	if err != nil {
+		logf(ctx, "Bot solve error: %s", err)
+		return localize(ctx, "bot_error")
+	}
+	if answer.Error != "" || len(answer.Data) != len(req.Courses) {
+		return localize(ctx, "bot_no_schedule")
+	}
+	lines := []string{}
+	for i, c := range req.Courses {
+		var groups [][]sisparse.Event
+		json.Unmarshal(c.Options, &groups)
+		if o := answer.Data[i]; o != nil && *o >= 0 && *o < len(groups) {
+			lines = append(lines, fmt.Sprintf("%s: %s", c.Name, describeGroup(ctx, groups[*o])))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: %s", c.Name, localize(ctx, "bot_not_scheduled")))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func botFollow(ctx context.Context, target, code string, follow bool) string {
+	subs, err := db.ListTargetSubscriptions(target)
+	if err != nil {
+		logf(ctx, "Bot error: %s", err)
+		return localize(ctx, "bot_error")
+	}
+	for _, sub := range subs {
+		if sub.CourseCode != code {
+			continue
+		}
+		if follow {
+			return localize(ctx, "bot_followed", code)
+		}
+		if err := db.DeleteSubscription(sub.Id); err != nil {
+			logf(ctx, "Bot error: %s", err)
+			return localize(ctx, "bot_error")
+		}
+		return localize(ctx, "bot_unfollowed", code)
+	}
+	if !follow {
+		return localize(ctx, "bot_unfollowed", code)
+	}
+	if len(subs) >= MAX_FOLLOWED_COURSES {
+		return localize(ctx, "bot_too_many_followed", MAX_FOLLOWED_COURSES)
+	}
+	if _, err := db.AddSubscription(store.Subscription{CourseCode: code, Target: target}); err != nil {
+		logf(ctx, "Bot error: %s", err)
+		return localize(ctx, "bot_error")
+	}
+	return localize(ctx, "bot_followed", code)
+}
+
+// Adds the chat to the channels of the user whose notification settings
+// have the code (see notifySettingsJSON.LinkCode), or removes it.
+func botLink(ctx context.Context, target, code string, link bool) string {
+	n, err := db.FindNotifySettings(code)
+	if err == store.ErrNotFound {
+		return localize(ctx, "bot_link_unknown")
+	}
+	if err != nil {
+		logf(ctx, "Bot error: %s", err)
+		return localize(ctx, "bot_error")
+	}
+	channels := []string{}
+	for _, c := range n.Channels {
+		if c != target {
+			channels = append(channels, c)
+		}
+	}
+	if link {
+		channels = append(channels, target)
+	}
+	n.Channels = channels
+	if err := db.SaveNotifySettings(n); err != nil {
+		logf(ctx, "Bot error: %s", err)
+		return localize(ctx, "bot_error")
+	}
+	if link {
+		return localize(ctx, "bot_linked")
+	}
+	return localize(ctx, "bot_unlinked")
+}
+
+// Splits a chat message such as "/course@SamorozvrhBot NPRG030" into the
+// command and its arguments; ok is false if it isn't a command.
+func parseBotCommand(text string) (command string, args []string, ok bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return "", nil, false
+	}
+	command = strings.ToLower(strings.TrimPrefix(fields[0], "/"))
+	// In groups, commands are addressed to a bot
+	if i := strings.Index(command, "@"); i >= 0 {
+		command = command[:i]
+	}
+	return command, fields[1:], true
+}
+
+// Cuts the text to the given number of runes, as chats limit the length
+// of messages.
+func truncateMessage(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
@@ -0,0 +1,290 @@
+// Course bundles: named lists of courses prepared by the operators (e.g.
+// the first year of a study programme), which students choose to prefill
+// their list of courses. Bundles come from the file given by -bundles (or
+// "bundles" of a tenant) and from /admin/bundles/, which keeps them in the
+// database; the bundles of the file can't be changed there.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// The most courses in a bundle
+const MAX_BUNDLE_COURSES = 60
+
+// A bundle, e.g.
+//
+//	{"id": "ips-1-winter",
+//	 "name": "IPS, 1st year, winter semester",
+//	 "description": "The general Computer Science track",
+//	 "semester": 1,
+//	 "priority": 3,
+//	 "courses": [{"code": "NPRG030"}, {"code": "NDMI002", "priority": 2},
+//	             {"code": "NTVY014", "optional": true}]}
+type courseBundle struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// sisparse.Winter or sisparse.Summer, 0 for any
+	Semester int `json:"semester,omitempty"`
+	// The default priority of the courses, from 1 (lowest) to 3 as in the
+	// webapp; 2 if not given
+	Priority int            `json:"priority,omitempty"`
+	Courses  []bundleCourse `json:"courses"`
+	// Whether the bundle is in the file of -bundles, rather than saved by
+	// /admin/bundles/
+	Configured bool `json:"configured,omitempty"`
+}
+
+type bundleCourse struct {
+	Code string `json:"code"`
+	// Filled in from the cached courses when the bundle is answered
+	Name     string `json:"name,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	// Electives, which the solver may leave out of the schedule (as
+	// "optional" of solve requests)
+	Optional bool `json:"optional,omitempty"`
+}
+
+func (b *courseBundle) validate() error {
+	if b.Id == "" || strings.ContainsAny(b.Id, "/?#") {
+		return fmt.Errorf("Invalid bundle id %q", b.Id)
+	}
+	if b.Name == "" {
+		return fmt.Errorf("The bundle %s has no name", b.Id)
+	}
+	if b.Semester != 0 && b.Semester != sisparse.Winter && b.Semester != sisparse.Summer {
+		return fmt.Errorf("Invalid semester %d of the bundle %s", b.Semester, b.Id)
+	}
+	if b.Priority == 0 {
+		b.Priority = 2
+	} else if b.Priority < 1 || b.Priority > 3 {
+		return fmt.Errorf("Invalid priority %d of the bundle %s", b.Priority, b.Id)
+	}
+	if len(b.Courses) == 0 || len(b.Courses) > MAX_BUNDLE_COURSES {
+		return fmt.Errorf("The bundle %s must have 1 to %d courses", b.Id, MAX_BUNDLE_COURSES)
+	}
+	for i, c := range b.Courses {
+		code := sisparse.NormalizeCourseCode(c.Code)
+		if !sisparse.IsCourseCode(code) {
+			return fmt.Errorf("Invalid course code %q in the bundle %s", c.Code, b.Id)
+		}
+		b.Courses[i].Code = code
+		b.Courses[i].Name = ""
+		if c.Priority == 0 {
+			b.Courses[i].Priority = b.Priority
+		} else if c.Priority < 1 || c.Priority > 3 {
+			return fmt.Errorf("Invalid priority %d of %s in the bundle %s", c.Priority, code, b.Id)
+		}
+	}
+	return nil
+}
+
+// Loads the bundles of a file (relative to rootdir) with a JSON array of
+// bundles.
+func loadBundles(filename string) ([]courseBundle, error) {
+	data, err := ioutil.ReadFile(path.Join(rootDir, filename))
+	if err != nil {
+		return nil, err
+	}
+	var bundles []courseBundle
+	if err := json.Unmarshal(data, &bundles); err != nil {
+		return nil, fmt.Errorf("Invalid bundles in %s: %s", filename, err)
+	}
+	ids := map[string]bool{}
+	for i := range bundles {
+		if err := bundles[i].validate(); err != nil {
+			return nil, err
+		}
+		if ids[bundles[i].Id] {
+			return nil, fmt.Errorf("Duplicate bundle id %s in %s", bundles[i].Id, filename)
+		}
+		ids[bundles[i].Id] = true
+		bundles[i].Configured = true
+	}
+	return bundles, nil
+}
+
+// Serializes the changes of the saved bundles
+var bundlesMu sync.Mutex
+
+// The cache key of the bundles saved by /admin/bundles/ for the tenant
+func bundlesKey(t *tenant) string {
+	if t == defaultTenant {
+		return SETTINGS_PREFIX + "bundles"
+	}
+	return SETTINGS_PREFIX + "bundles/" + t.Id
+}
+
+func savedBundles(t *tenant) ([]courseBundle, error) {
+	e, err := db.GetCache(bundlesKey(t))
+	if err == store.ErrNotFound {
+		return []courseBundle{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var bundles []courseBundle
+	if err := json.Unmarshal([]byte(e.Value), &bundles); err != nil {
+		return nil, err
+	}
+	return bundles, nil
+}
+
+// Returns the bundles of the tenant, those of its file first, then the
+// saved ones by id.
+func listBundles(t *tenant) ([]courseBundle, error) {
+	saved, err := savedBundles(t)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(saved, func(i, j int) bool {
+		return saved[i].Id < saved[j].Id
+	})
+	return append(append([]courseBundle{}, t.bundles...), saved...), nil
+}
+
+// Answers
+//   - GET /bundles/ with the bundles of the tenant, without their courses,
+//   - GET /bundles/<id> with the bundle, its courses named as far as they
+//     are cached.
+func bundlesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use GET"}`)
+		return
+	}
+	t := tenantOf(r.Context())
+	bundles, err := listBundles(t)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/bundles/")
+	if id == "" {
+		type bundleSummary struct {
+			Id          string `json:"id"`
+			Name        string `json:"name"`
+			Description string `json:"description,omitempty"`
+			Semester    int    `json:"semester,omitempty"`
+			Courses     int    `json:"courses"`
+		}
+		res := []bundleSummary{}
+		for _, b := range bundles {
+			res = append(res, bundleSummary{b.Id, b.Name, b.Description, b.Semester, len(b.Courses)})
+		}
+		s, _ := json.Marshal(res)
+		fmt.Fprintf(w, `{"data":%s}`, string(s))
+		return
+	}
+	for _, b := range bundles {
+		if b.Id != id {
+			continue
+		}
+		b.Courses = append([]bundleCourse{}, b.Courses...)
+		for i, c := range b.Courses {
+			b.Courses[i].Name = t.index.courseName(c.Code)
+		}
+		s, _ := json.Marshal(b)
+		fmt.Fprintf(w, `{"data":%s}`, string(s))
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, `{"error":"No such bundle"}`)
+}
+
+// Answers
+//   - GET /admin/bundles/ with all the bundles of the tenant,
+//   - POST /admin/bundles/ with a bundle by saving it (replacing the saved
+//     bundle with the same id),
+//   - DELETE /admin/bundles/<id> by deleting the saved bundle.
+func adminBundlesHandler(w http.ResponseWriter, r *http.Request) {
+	t := tenantOf(r.Context())
+	id := strings.TrimPrefix(r.URL.Path, "/admin/bundles/")
+	var err error
+	switch {
+	case r.Method == "GET" && id == "":
+	case r.Method == "POST" && id == "":
+		var b courseBundle
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			fmt.Fprint(w, `{"error":"Expected a bundle, {\"id\":\"...\",\"name\":\"...\",\"courses\":[...]}"}`)
+			return
+		}
+		err = saveBundle(t, b)
+	case r.Method == "DELETE" && id != "":
+		err = deleteBundle(t, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Expected GET or POST of /admin/bundles/ or DELETE of /admin/bundles/<id>"}`)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	bundles, err := listBundles(t)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	s, _ := json.Marshal(bundles)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func saveBundle(t *tenant, b courseBundle) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+	for _, c := range t.bundles {
+		if c.Id == b.Id {
+			return fmt.Errorf("The bundle %s is configured in the bundles file", b.Id)
+		}
+	}
+	b.Configured = false
+	bundlesMu.Lock()
+	defer bundlesMu.Unlock()
+	saved, err := savedBundles(t)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i := range saved {
+		if saved[i].Id == b.Id {
+			saved[i], replaced = b, true
+		}
+	}
+	if !replaced {
+		saved = append(saved, b)
+	}
+	s, _ := json.Marshal(saved)
+	return db.SetCache(bundlesKey(t), string(s))
+}
+
+func deleteBundle(t *tenant, id string) error {
+	bundlesMu.Lock()
+	defer bundlesMu.Unlock()
+	saved, err := savedBundles(t)
+	if err != nil {
+		return err
+	}
+	kept := []courseBundle{}
+	for _, b := range saved {
+		if b.Id != id {
+			kept = append(kept, b)
+		}
+	}
+	if len(kept) == len(saved) {
+		return fmt.Errorf("No saved bundle %s", id)
+	}
+	s, _ := json.Marshal(kept)
+	return db.SetCache(bundlesKey(t), string(s))
+}
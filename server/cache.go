@@ -1,35 +1,143 @@
-package main
+package server
 
 import (
-	"io/ioutil"
-	"os"
-	"path"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
 )
 
+var db store.Store
+
+// Recently used entries of db, see -memory-cache
+var memoryCache = newLruCache(DEFAULT_MEMORY_CACHE_SIZE, DEFAULT_MEMORY_CACHE_TTL)
+
+// At which fraction of -cache-ttl a cached course which is served gets
+// fetched again in the background
+const DEFAULT_REFRESH_AHEAD = 0.8
+
+// How long cached courses are served before they are fetched again (0 for
+// as long as they are of the current academic year), and refreshAhead as
+// DEFAULT_REFRESH_AHEAD; see -cache-ttl and -refresh-ahead
+var courseCacheTtl time.Duration
+var refreshAhead = DEFAULT_REFRESH_AHEAD
+
+// Reports whether the entry is cached. Unlike getCache, it doesn't count
+// in the stats of the memory cache or load the entry into it. An entry
+// which can't be read (e.g. when the database is down) isn't cached, so
+// that callers fetch it rather than serve nothing.
 func isCached(name string) bool {
-	if _, err := os.Stat(getCacheFilename(name)); err != nil {
-		if os.IsNotExist(err) {
-			return false
-		}
+	key := getCacheKey(name)
+	if memoryCache.has(key) {
+		return true
 	}
-	return true
+	_, err := db.GetCache(key)
+	if err != nil && err != store.ErrNotFound {
+		log.Printf("Could not read the cache entry %s: %s", name, err)
+	}
+	return err == nil
 }
 
 func setCache(name string, data string) error {
-	err := os.MkdirAll(path.Join(rootDir, "cache"), 0755)
-	if err != nil {
+	if err := db.SetCache(getCacheKey(name), data); err != nil {
 		return err
 	}
-	filename := getCacheFilename(name)
-	return ioutil.WriteFile(filename, []byte(data), 0644)
+	memoryCache.set(store.CacheEntry{Key: getCacheKey(name), Value: data, Updated: time.Now()})
+	return nil
 }
 
 func getCache(name string) (string, error) {
-	filename := getCacheFilename(name)
-	res, err := ioutil.ReadFile(filename)
-	return string(res), err
+	e, err := getCacheEntry(name)
+	return e.Value, err
+}
+
+// Like getCache, but also tells when the entry was stored.
+func getCacheEntry(name string) (store.CacheEntry, error) {
+	key := getCacheKey(name)
+	if e, ok := memoryCache.get(key); ok {
+		return e, nil
+	}
+	e, err := db.GetCache(key)
+	if err == nil {
+		memoryCache.set(e)
+	}
+	return e, err
+}
+
+// Returns the names of all cached entries.
+func listCache() ([]string, error) {
+	keys, err := db.ListCache(getCacheKey(""))
+	if err != nil {
+		return nil, err
+	}
+	res := []string{}
+	for _, k := range keys {
+		res = append(res, strings.TrimPrefix(k, getCacheKey("")))
+	}
+	return res, nil
 }
 
-func getCacheFilename(name string) string {
-	return path.Join(rootDir, "cache", name)
+// Cached answers contain serialized events, so entries made with a different
+// version of the event schema are kept apart (and ignored).
+func getCacheKey(name string) string {
+	return fmt.Sprintf("v%d/%s", sisparse.EventSchemaVersion, name)
+}
+
+// Courses which SIS doesn't know (mostly typos) are remembered for a while,
+// so that asking for them again doesn't go to SIS. Their entries are kept
+// under keys of their own, apart from the answers (see listCache).
+const NEGATIVE_CACHE_PREFIX = "missing/"
+
+// Settings of the deployment (see sispause.go, bundles.go) are kept in the
+// cache table too, under keys with this prefix.
+const SETTINGS_PREFIX = "settings/"
+
+// How long a course stays known to be missing, see -negative-cache-ttl
+const DEFAULT_NEGATIVE_CACHE_TTL = 10 * time.Minute
+
+var negativeCacheTtl = DEFAULT_NEGATIVE_CACHE_TTL
+
+// Counters of the negative cache, shown at /admin/cache/stats
+var negativeCacheStats struct {
+	Hits   int64 `json:"negative_hits"`
+	Stored int64 `json:"negative_stored"`
+}
+
+// Remembers that the entry (of a course) is missing in SIS.
+func setMissing(name string) error {
+	if negativeCacheTtl <= 0 {
+		return nil
+	}
+	key := NEGATIVE_CACHE_PREFIX + getCacheKey(name)
+	if err := db.SetCache(key, ""); err != nil {
+		return err
+	}
+	memoryCache.set(store.CacheEntry{Key: key, Updated: time.Now()})
+	atomic.AddInt64(&negativeCacheStats.Stored, 1)
+	return nil
+}
+
+// Whether the entry was found missing in SIS within -negative-cache-ttl.
+func isMissing(name string) bool {
+	if negativeCacheTtl <= 0 {
+		return false
+	}
+	key := NEGATIVE_CACHE_PREFIX + getCacheKey(name)
+	e, ok := memoryCache.get(key)
+	if !ok {
+		var err error
+		if e, err = db.GetCache(key); err != nil {
+			return false
+		}
+		memoryCache.set(e)
+	}
+	if time.Since(e.Updated) > negativeCacheTtl {
+		return false
+	}
+	atomic.AddInt64(&negativeCacheStats.Hits, 1)
+	return true
 }
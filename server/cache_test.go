@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// A store whose cache answers with err, and which has nothing else
+type failingCacheStore struct {
+	store.Store
+	err error
+}
+
+func (s failingCacheStore) GetCache(key string) (store.CacheEntry, error) {
+	return store.CacheEntry{}, s.err
+}
+
+func TestIsCached(t *testing.T) {
+	defer func(old store.Store, oldMemory *lruCache) { db, memoryCache = old, oldMemory }(db, memoryCache)
+	memoryCache = newLruCache(10, DEFAULT_MEMORY_CACHE_TTL)
+
+	for _, tt := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"stored", nil, true},
+		{"missing", store.ErrNotFound, false},
+		{"database down", errors.New("connection refused"), false},
+	} {
+		db = failingCacheStore{err: tt.err}
+		if got := isCached("NPRG030"); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	db = failingCacheStore{err: errors.New("connection refused")}
+	memoryCache.set(store.CacheEntry{Key: getCacheKey("NPRG030"), Value: "{}"})
+	if !isCached("NPRG030") {
+		t.Errorf("An entry of the memory cache isn't cached")
+	}
+	if s := memoryCache.getStats(); s.Hits != 0 || s.Misses != 0 {
+		t.Errorf("isCached counted in the stats: %+v", s)
+	}
+}
+
+// A store whose cache is a map, and which has nothing else
+type mapCacheStore struct {
+	store.Store
+	mu      sync.Mutex
+	entries map[string]store.CacheEntry
+}
+
+func (s *mapCacheStore) GetCache(key string) (store.CacheEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return e, store.ErrNotFound
+	}
+	return e, nil
+}
+
+func (s *mapCacheStore) ListCache(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for k := range s.entries {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *mapCacheStore) SetCache(key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = store.CacheEntry{Key: key, Value: value, Updated: time.Now()}
+	return nil
+}
+
+func TestNegativeCache(t *testing.T) {
+	defer func(old store.Store, oldMemory *lruCache, oldTtl time.Duration) {
+		db, memoryCache, negativeCacheTtl = old, oldMemory, oldTtl
+	}(db, memoryCache, negativeCacheTtl)
+	s := &mapCacheStore{entries: map[string]store.CacheEntry{}}
+	db = s
+	memoryCache = newLruCache(10, DEFAULT_MEMORY_CACHE_TTL)
+	negativeCacheTtl = time.Minute
+	hits, stored := negativeCacheStats.Hits, negativeCacheStats.Stored
+
+	if isMissing("NXXX001") {
+		t.Error("A course is missing before it was looked for")
+	}
+	if err := setMissing("NXXX001"); err != nil {
+		t.Fatal(err)
+	}
+	if !isMissing("NXXX001") {
+		t.Error("A missing course isn't remembered")
+	}
+	if isCached("NXXX001") {
+		t.Error("A missing course is cached")
+	}
+	// Other instances find it in the database
+	memoryCache = newLruCache(10, DEFAULT_MEMORY_CACHE_TTL)
+	if !isMissing("NXXX001") {
+		t.Error("A missing course isn't remembered in the database")
+	}
+	if got := negativeCacheStats.Hits - hits; got != 2 {
+		t.Errorf("%d hits counted, want 2", got)
+	}
+	if got := negativeCacheStats.Stored - stored; got != 1 {
+		t.Errorf("%d stored counted, want 1", got)
+	}
+
+	// It is forgotten after the TTL
+	key := NEGATIVE_CACHE_PREFIX + getCacheKey("NXXX002")
+	s.entries[key] = store.CacheEntry{Key: key, Updated: time.Now().Add(-2 * time.Minute)}
+	if isMissing("NXXX002") {
+		t.Error("A course is missing after the TTL")
+	}
+}
+
+func TestNegativeCacheDisabled(t *testing.T) {
+	defer func(old store.Store, oldMemory *lruCache, oldTtl time.Duration) {
+		db, memoryCache, negativeCacheTtl = old, oldMemory, oldTtl
+	}(db, memoryCache, negativeCacheTtl)
+	s := &mapCacheStore{entries: map[string]store.CacheEntry{}}
+	db = s
+	memoryCache = newLruCache(10, DEFAULT_MEMORY_CACHE_TTL)
+	negativeCacheTtl = 0
+
+	if err := setMissing("NXXX001"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.entries) != 0 {
+		t.Errorf("Missing courses are stored with the TTL of 0: %+v", s.entries)
+	}
+	key := NEGATIVE_CACHE_PREFIX + getCacheKey("NXXX001")
+	s.entries[key] = store.CacheEntry{Key: key, Updated: time.Now()}
+	if isMissing("NXXX001") {
+		t.Error("A course is missing with the TTL of 0")
+	}
+}
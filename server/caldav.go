@@ -0,0 +1,316 @@
+// Pushing saved schedules to CalDAV calendars (Nextcloud, SOGo, Exchange
+// with a CalDAV gateway, ...), for calendars which can't subscribe to the
+// feed. Each occurrence is a resource of its own, named by its UID, so a
+// push only adds, changes and removes what differs from the last one and
+// leaves the other events in the calendar alone.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// Whether students may push schedules to CalDAV servers. Off by default,
+// as the server then makes requests to any public HTTPS URL it is given.
+var caldavEnabled = false
+
+// Returned when a CalDAV URL leads to an address which isn't public
+var errCaldavAddressNotPublic = errors.New("The calendar isn't at a public address")
+
+const CALDAV_TIMEOUT = 20 * time.Second
+
+// The names of the resources of the events of a schedule start with this
+// and the token; other resources of the calendar are never touched
+const CALDAV_RESOURCE_PREFIX = "samorozvrh-"
+
+type caldavRequest struct {
+	// The URL of the calendar collection
+	Url      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type caldavResult struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Removed   int `json:"removed"`
+	Unchanged int `json:"unchanged"`
+}
+
+// Answers POST /schedules/<token>/caldav with {"url":...,"username":...,
+// "password":...} by pushing the events of the calendar feed of the saved
+// schedule to the calendar, with {"data":{"added":...,"updated":...,
+// "removed":...,"unchanged":...}}. The credentials are neither logged nor
+// stored.
+func caldavHandler(w http.ResponseWriter, r *http.Request, token string) {
+	if !caldavEnabled {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"Pushing to CalDAV is not enabled on this server"}`)
+		return
+	}
+	var req caldavRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fmt.Fprintf(w, `{"error":"Invalid request: %s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	u, err := url.Parse(req.Url)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		// The password mustn't go in cleartext
+		fmt.Fprint(w, `{"error":"Expected the https URL of a calendar"}`)
+		return
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	ctx := r.Context()
+	events, err := feedEvents(ctx, token)
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	c := &caldavClient{
+		client:   newCaldavHttpClient(),
+		base:     u,
+		username: req.Username,
+		password: req.Password,
+	}
+	res, err := c.push(ctx, CALDAV_RESOURCE_PREFIX+token+"-", events)
+	if err != nil {
+		// The details are only logged, so that the server can't be used
+		// to probe other hosts
+		logf(ctx, "CalDAV error for %s: %s", u.Host, err)
+		fmt.Fprint(w, `{"error":"Could not push the schedule to the calendar, check its URL and the credentials"}`)
+		return
+	}
+	s, _ := json.Marshal(res)
+	fmt.Fprintf(w, `{"data":%s}`, s)
+}
+
+// Returns a client which only connects to public addresses over https, so
+// that students can't make the server reach hosts of its own network.
+func newCaldavHttpClient() *http.Client {
+	dialer := &net.Dialer{Timeout: CALDAV_TIMEOUT, Control: dialPublicOnly}
+	return &http.Client{
+		Timeout: CALDAV_TIMEOUT,
+		// No proxy from the environment, which would be dialed instead
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: CALDAV_TIMEOUT,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return errors.New("Redirected to a URL which isn't https")
+			}
+			if len(via) >= 10 {
+				return errors.New("Too many redirects")
+			}
+			return nil
+		},
+	}
+}
+
+// Refuses connections to loopback, private, link-local and other
+// addresses which aren't public, checked after the host is resolved.
+func dialPublicOnly(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() || sharedAddressSpace.Contains(ip) {
+		return errCaldavAddressNotPublic
+	}
+	return nil
+}
+
+// Addresses of carrier-grade NAT (RFC 6598), which aren't public either
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+type caldavClient struct {
+	client             *http.Client
+	base               *url.URL // Of the collection, ending with a slash
+	username, password string
+}
+
+// A resource of the collection, as listed by PROPFIND
+type caldavResource struct {
+	Href string `xml:"href"`
+	ETag string `xml:"propstat>prop>getetag"`
+}
+
+const caldavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:getetag/></d:prop></d:propfind>`
+
+// Makes the resources whose names start with the prefix match the events:
+// puts the new and changed ones and deletes those not among the events.
+func (c *caldavClient) push(ctx context.Context, prefix string, events []icalEvent) (caldavResult, error) {
+	var res caldavResult
+	existing, err := c.list(ctx)
+	if err != nil {
+		return res, err
+	}
+	etags := map[string]string{}
+	for _, r := range existing {
+		href, err := url.PathUnescape(r.Href)
+		if err != nil {
+			continue
+		}
+		if name := path.Base(href); strings.HasPrefix(name, prefix) {
+			etags[name] = r.ETag
+		}
+	}
+	for _, e := range events {
+		name := CALDAV_RESOURCE_PREFIX + strings.TrimSuffix(e.UID, "@samorozvrh") + ".ics"
+		body := caldavEventBody(e)
+		etag, ok := etags[name]
+		delete(etags, name)
+		if ok {
+			old, err := c.do(ctx, "GET", name, nil, nil)
+			if err != nil {
+				return res, err
+			}
+			if sameICalEvent(old, body) {
+				res.Unchanged++
+				continue
+			}
+			res.Updated++
+		} else {
+			res.Added++
+		}
+		header := http.Header{"Content-Type": {"text/calendar; charset=utf-8"}}
+		if !ok {
+			header.Set("If-None-Match", "*")
+		} else if etag != "" {
+			header.Set("If-Match", etag)
+		}
+		if _, err := c.do(ctx, "PUT", name, header, body); err != nil {
+			return res, err
+		}
+	}
+	// What's left are the events which are no longer in the schedule
+	for name, etag := range etags {
+		header := http.Header{}
+		if etag != "" {
+			header.Set("If-Match", etag)
+		}
+		if _, err := c.do(ctx, "DELETE", name, header, nil); err != nil {
+			return res, err
+		}
+		res.Removed++
+	}
+	return res, nil
+}
+
+// Returns the resources in the collection.
+func (c *caldavClient) list(ctx context.Context) ([]caldavResource, error) {
+	header := http.Header{
+		"Content-Type": {"application/xml; charset=utf-8"},
+		"Depth":        {"1"},
+	}
+	body, err := c.do(ctx, "PROPFIND", "", header, []byte(caldavPropfind))
+	if err != nil {
+		return nil, err
+	}
+	var multistatus struct {
+		Responses []caldavResource `xml:"response"`
+	}
+	if err := xml.Unmarshal(body, &multistatus); err != nil {
+		return nil, fmt.Errorf("Invalid answer of the CalDAV server: %s", err)
+	}
+	return multistatus.Responses, nil
+}
+
+func (c *caldavClient) do(ctx context.Context, method, name string, header http.Header, body []byte) ([]byte, error) {
+	u := *c.base
+	u.Path += name
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("User-Agent", sisparse.UserAgent)
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, errors.New("The CalDAV server refused the credentials")
+	case resp.StatusCode == http.StatusPreconditionFailed:
+		return nil, errors.New("The calendar changed during the push, try again")
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("The CalDAV server returned %s for %s", resp.Status, method)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func caldavEventBody(e icalEvent) []byte {
+	var b strings.Builder
+	writeICalLine(&b, "BEGIN:VCALENDAR")
+	writeICalLine(&b, "VERSION:2.0")
+	writeICalLine(&b, "PRODID:"+ICAL_PRODID)
+	writeICalEvent(&b, e)
+	writeICalLine(&b, "END:VCALENDAR")
+	return []byte(b.String())
+}
+
+// Whether two iCalendar objects have the same content lines, apart from
+// DTSTAMP (which is the time of the push) and how they are folded.
+func sameICalEvent(a, b []byte) bool {
+	unfold := func(s []byte) []string {
+		var lines []string
+		for _, line := range strings.Split(strings.Replace(string(s), "\r\n", "\n", -1), "\n") {
+			switch {
+			case line == "":
+			case (line[0] == ' ' || line[0] == '\t') && len(lines) > 0:
+				lines[len(lines)-1] += line[1:]
+			default:
+				lines = append(lines, line)
+			}
+		}
+		res := lines[:0]
+		for _, line := range lines {
+			if !strings.HasPrefix(line, "DTSTAMP") {
+				res = append(res, line)
+			}
+		}
+		return res
+	}
+	la, lb := unfold(a), unfold(b)
+	if len(la) != len(lb) {
+		return false
+	}
+	for i := range la {
+		if la[i] != lb[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDialPublicOnly(t *testing.T) {
+	for _, tt := range []struct {
+		address string
+		public  bool
+	}{
+		{"195.113.89.35:443", true},
+		{"[2001:718:1e03:801::7]:443", true},
+		{"127.0.0.1:443", false},
+		{"[::1]:443", false},
+		{"[::ffff:127.0.0.1]:443", false},
+		{"10.0.0.1:443", false},
+		{"172.16.5.4:443", false},
+		{"192.168.1.1:443", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:443", false},
+		{"[fd00::1]:443", false},
+		{"100.64.0.1:443", false},
+		{"0.0.0.0:443", false},
+		{"224.0.0.1:443", false},
+	} {
+		err := dialPublicOnly("tcp", tt.address, nil)
+		if got := err == nil; got != tt.public {
+			t.Errorf("dialPublicOnly(%s): %v, want public %v", tt.address, err, tt.public)
+		}
+	}
+}
+
+func TestCaldavPushToPrivateAddress(t *testing.T) {
+	defer func(old bool) { caldavEnabled = old }(caldavEnabled)
+	caldavEnabled = true
+	calendar := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("The calendar at a loopback address got %s %s", r.Method, r.URL)
+	}))
+	defer calendar.Close()
+
+	c := newCaldavHttpClient()
+	if _, err := c.Get(calendar.URL); err == nil || !strings.Contains(err.Error(), errCaldavAddressNotPublic.Error()) {
+		t.Errorf("Request to a loopback address: %v, want %v", err, errCaldavAddressNotPublic)
+	}
+
+	for _, u := range []string{"http://calendar.example/dav/", "ftp://calendar.example/", "calendar.example"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/schedules/abc/caldav", strings.NewReader(`{"url":"`+u+`"}`))
+		caldavHandler(w, r, "abc")
+		if !strings.Contains(w.Body.String(), "Expected the https URL") {
+			t.Errorf("Push to %s: %s", u, w.Body.String())
+		}
+	}
+}
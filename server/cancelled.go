@@ -0,0 +1,130 @@
+// Events which SIS marks as cancelled (see sisparse.Event.Cancelled). Groups
+// all of whose events are cancelled are left out of solving, and cancelled
+// events of other groups don't have to fit into the schedule. When a group
+// gets cancelled, the users whose last schedule chose it are told, as they
+// will have to enroll in another one.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// How old the solver runs may be whose users are told about cancelled
+// groups they chose
+const CANCELLED_NOTIFY_DAYS = 180
+
+// Reports whether any event of the options of the course is cancelled.
+func hasCancelledEvents(c solveCourse) bool {
+	var options [][]struct {
+		Cancelled bool `json:"cancelled"`
+	}
+	if err := json.Unmarshal(c.Options, &options); err != nil {
+		return false
+	}
+	for _, option := range options {
+		for _, e := range option {
+			if e.Cancelled {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the events of the option which aren't cancelled.
+func withoutCancelled(option []sisparse.Event) []sisparse.Event {
+	res := []sisparse.Event{}
+	for _, e := range option {
+		if !e.Cancelled {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Returns the ids of the groups which are cancelled after, but weren't
+// before.
+func newlyCancelledGroups(before, after map[string][]sisparse.Event) []string {
+	res := []string{}
+	for id, g := range after {
+		if old, ok := before[id]; ok && sisparse.GroupCancelled(g) && !sisparse.GroupCancelled(old) {
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// Tells the users whose last schedule (of the solver runs of the last
+// CANCELLED_NOTIFY_DAYS) chose one of the groups of the course which got
+// cancelled, if they want to know about changes of courses.
+func notifyCancelledGroups(code string, ids []string, groups map[string][]sisparse.Event) {
+	jobs, err := db.ListJobs(JOB_DONE)
+	if err != nil {
+		log.Printf("Could not tell about the cancelled groups of %s: %s", code, err)
+		return
+	}
+	cancelled := map[string]bool{}
+	for _, id := range ids {
+		cancelled[id] = true
+	}
+	latest := map[string]int{}
+	for i, job := range jobs {
+		if job.UserHash != "" {
+			latest[job.UserHash] = i
+		}
+	}
+	since := time.Now().AddDate(0, 0, -CANCELLED_NOTIFY_DAYS)
+	for user, i := range latest {
+		job := jobs[i]
+		if job.Created.Before(since) {
+			continue
+		}
+		chosen := chosenCancelledGroups(job.Request, job.Result, code, cancelled)
+		if len(chosen) == 0 {
+			continue
+		}
+		n, err := db.GetNotifySettings(user)
+		if err != nil || !n.CourseChanges {
+			continue
+		}
+		ctx := withLanguage(context.Background(), n.Language)
+		changes := []string{}
+		for _, id := range chosen {
+			changes = append(changes, describeGroup(ctx, groups[id]))
+		}
+		notifyUser(n, "group_cancelled", emailData{Course: code, Changes: changes}, code)
+	}
+}
+
+// Returns the ids of the groups of the course among cancelled which the
+// solver run chose.
+func chosenCancelledGroups(request, result, code string, cancelled map[string]bool) []string {
+	req, err := parseSolveRequest([]byte(request))
+	if err != nil {
+		return nil
+	}
+	var res solveResponse
+	if err := json.Unmarshal([]byte(result), &res); err != nil || res.Error != "" || len(res.Data) != len(req.Courses) {
+		return nil
+	}
+	ids := []string{}
+	for k, c := range req.Courses {
+		var options [][]sisparse.Event
+		if res.Data[k] == nil || json.Unmarshal(c.Options, &options) != nil || *res.Data[k] >= len(options) {
+			continue
+		}
+		option := options[*res.Data[k]]
+		if len(option) == 0 || (option[0].CourseCode != code && c.CourseCode != code) {
+			continue
+		}
+		if cancelled[option[0].GroupID] {
+			ids = append(ids, option[0].GroupID)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,154 @@
+// Comparing two snapshots of the cached catalog (archives made by
+// -export-cache, or the current cache), e.g. of yesterday and today, to
+// find the courses whose schedules changed.
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+type catalogDiff struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// The cache names of the courses (e.g. "NPRG030@2") only in the newer
+	// or only in the older snapshot
+	Added     []string       `json:"added"`
+	Removed   []string       `json:"removed"`
+	Changed   []courseChange `json:"changed"`
+	Unchanged int            `json:"unchanged"`
+}
+
+// The groups of a course which changed between the snapshots, by their ids
+type courseChange struct {
+	Course        string   `json:"course"`
+	AddedGroups   []string `json:"added_groups,omitempty"`
+	RemovedGroups []string `json:"removed_groups,omitempty"`
+	ChangedGroups []string `json:"changed_groups,omitempty"`
+}
+
+// Reports whether the cache entry is a course, i.e. named
+// "[<tenant>/]<code>[@<semester>][~<year>]".
+func isCourseCacheName(name string) bool {
+	if i := strings.Index(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexAny(name, "@~"); i >= 0 {
+		name = name[:i]
+	}
+	return sisparse.IsCourseCode(name)
+}
+
+// Returns the groups of the cached courses of the archive by their ids,
+// by cache name.
+func archiveCourses(archive cacheArchive) map[string]map[string][]sisparse.Event {
+	res := map[string]map[string][]sisparse.Event{}
+	for _, e := range archive.Entries {
+		if !isCourseCacheName(e.Key) {
+			continue
+		}
+		var cached struct {
+			Data [][]sisparse.Event `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(e.Value), &cached); err != nil {
+			log.Printf("Catalog diff: skipping %s: %s", e.Key, err)
+			continue
+		}
+		res[e.Key] = groupsById(cached.Data)
+	}
+	return res
+}
+
+func groupsById(groups [][]sisparse.Event) map[string][]sisparse.Event {
+	res := map[string][]sisparse.Event{}
+	for _, g := range groups {
+		if len(g) > 0 {
+			res[g[0].GroupID] = g
+		}
+	}
+	return res
+}
+
+func diffCatalogs(older, newer cacheArchive) catalogDiff {
+	res := catalogDiff{
+		From:    older.Created,
+		To:      newer.Created,
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []courseChange{},
+	}
+	before, after := archiveCourses(older), archiveCourses(newer)
+	for name, groups := range after {
+		old, ok := before[name]
+		if !ok {
+			res.Added = append(res.Added, name)
+			continue
+		}
+		change := diffCourseGroups(name, old, groups)
+		if change.empty() {
+			res.Unchanged++
+			continue
+		}
+		res.Changed = append(res.Changed, change)
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			res.Removed = append(res.Removed, name)
+		}
+	}
+	sort.Strings(res.Added)
+	sort.Strings(res.Removed)
+	sort.Slice(res.Changed, func(i, j int) bool {
+		return res.Changed[i].Course < res.Changed[j].Course
+	})
+	return res
+}
+
+// Compares the groups of the course (by their ids) before and after.
+func diffCourseGroups(name string, old, groups map[string][]sisparse.Event) courseChange {
+	change := courseChange{Course: name}
+	for id, g := range groups {
+		if oldGroup, ok := old[id]; !ok {
+			change.AddedGroups = append(change.AddedGroups, id)
+		} else if !sisparse.GroupsEqual(oldGroup, g) {
+			change.ChangedGroups = append(change.ChangedGroups, id)
+		}
+	}
+	for id := range old {
+		if _, ok := groups[id]; !ok {
+			change.RemovedGroups = append(change.RemovedGroups, id)
+		}
+	}
+	sort.Strings(change.AddedGroups)
+	sort.Strings(change.RemovedGroups)
+	sort.Strings(change.ChangedGroups)
+	return change
+}
+
+func (c courseChange) empty() bool {
+	return len(c.AddedGroups)+len(c.RemovedGroups)+len(c.ChangedGroups) == 0
+}
+
+// Compares the archive in the file with the one in newerFile, or with the
+// current cache if newerFile is empty.
+func diffCatalogFiles(olderFile, newerFile string) (catalogDiff, error) {
+	older, err := readCacheArchiveFile(olderFile)
+	if err != nil {
+		return catalogDiff{}, err
+	}
+	var newer cacheArchive
+	if newerFile != "" {
+		newer, err = readCacheArchiveFile(newerFile)
+	} else {
+		newer, err = snapshotCache()
+	}
+	if err != nil {
+		return catalogDiff{}, err
+	}
+	return diffCatalogs(older, newer), nil
+}
@@ -0,0 +1,114 @@
+// The colors and categories of courses for rendering, assigned here rather
+// than by each client, so that a course has the same color in the webapp,
+// the images and the calendar feeds.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image/color"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The CSS names of the colors of pngPalette (or the closest ones), for
+// the COLOR of calendar events, which RFC 7986 wants by name
+var pngPaletteNames = []string{
+	"steelblue", "darkorange", "mediumseagreen", "indianred", "cadetblue",
+	"goldenrod", "orchid", "lightpink", "sienna", "silver",
+}
+
+// Returns the color of pngPalette of the course, the same for the course
+// code whatever else is in the schedule.
+func courseColor(code string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(sisparse.NormalizeCourseCode(code)))
+	return pngPalette[h.Sum32()%uint32(len(pngPalette))]
+}
+
+// Returns the color of the i-th course of a schedule: custom ("#rrggbb") if
+// the user chose one, else that of the course, or with the code unknown,
+// the i-th of pngPalette.
+func scheduleCourseColor(i int, code, custom string) (color.RGBA, bool) {
+	if custom != "" {
+		return parseHexColor(custom)
+	}
+	if code != "" {
+		return courseColor(code), true
+	}
+	return pngPalette[i%len(pngPalette)], true
+}
+
+// Returns the name of the color of pngPalette closest to c, see
+// pngPaletteNames.
+func colorName(c color.RGBA) string {
+	best, bestDistance := 0, -1
+	for i, p := range pngPalette {
+		dr, dg, db := int(c.R)-int(p.R), int(c.G)-int(p.G), int(c.B)-int(p.B)
+		if d := dr*dr + dg*dg + db*db; bestDistance < 0 || d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	return pngPaletteNames[best]
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// Returns the code of the course of the events, empty if unknown.
+func groupCourseCode(events []sisparse.Event) string {
+	for _, e := range events {
+		if e.CourseCode != "" {
+			return e.CourseCode
+		}
+	}
+	return ""
+}
+
+// The categories of an event, for CATEGORIES of calendar events: the type
+// (lecture or seminar) in the language of ctx and the course code.
+func eventCategories(ctx context.Context, e sisparse.Event) []string {
+	res := []string{}
+	if e.Type != "" {
+		res = append(res, localize(ctx, string(e.Type)))
+	}
+	if e.CourseCode != "" {
+		res = append(res, e.CourseCode)
+	}
+	return res
+}
+
+// Adds the color of the course ("color", as "#rrggbb") and the types of its
+// events with their names in the language of ctx ("categories") to an
+// answer of queryCourse.
+func withRenderHints(ctx context.Context, code, res string) string {
+	var answer map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(res), &answer); err != nil {
+		return res
+	}
+	// Only the types are needed of the events
+	var groups [][]struct {
+		Type sisparse.EventType `json:"type"`
+	}
+	if err := json.Unmarshal(answer["data"], &groups); err != nil {
+		return res
+	}
+	categories := map[sisparse.EventType]string{}
+	for _, group := range groups {
+		for _, e := range group {
+			if e.Type == sisparse.Lecture || e.Type == sisparse.Seminar {
+				categories[e.Type] = localize(ctx, string(e.Type))
+			}
+		}
+	}
+	answer["color"], _ = json.Marshal(hexColor(courseColor(code)))
+	answer["categories"], _ = json.Marshal(categories)
+	s, err := json.Marshal(answer)
+	if err != nil {
+		return res
+	}
+	return string(s)
+}
@@ -0,0 +1,87 @@
+// Conditional requests of course data: answers carry an ETag (a hash of
+// their content) and, for courses, Last-Modified (when the events were
+// fetched from SIS), so that the webapp and other clients can ask again
+// with If-None-Match or If-Modified-Since and get a 304 without the body
+// while the course hasn't changed.
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Answers must be revalidated, as a course may change at any time
+const COURSE_CACHE_CONTROL = "no-cache"
+
+// Returns the ETag of an answer of queryCourse and the time its events
+// were fetched (zero if it doesn't say). The cache status in its
+// provenance is left out of the hash, so that an answer from the cache
+// has the ETag it had when it was fetched; the ETag is weak for that.
+func courseValidators(res string) (string, time.Time) {
+	var answer map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(res), &answer); err != nil {
+		return contentETag(res), time.Time{}
+	}
+	var p provenance
+	if err := json.Unmarshal(answer["provenance"], &p); err != nil {
+		return contentETag(res), time.Time{}
+	}
+	p.Cache = ""
+	answer["provenance"], _ = json.Marshal(p)
+	s, err := json.Marshal(answer)
+	if err != nil {
+		return contentETag(res), p.Fetched
+	}
+	return contentETag(string(s)), p.Fetched
+}
+
+func contentETag(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Writes the answer with its ETag and Last-Modified (unless modified is
+// zero), or just 304 Not Modified if the request's If-None-Match has the
+// ETag or, without If-None-Match, If-Modified-Since isn't before modified.
+func writeConditional(w http.ResponseWriter, r *http.Request, body, etag string, modified time.Time) {
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", COURSE_CACHE_CONTROL)
+	// Course answers name the types of events in the language of the request
+	header.Add("Vary", "Accept-Language")
+	if !modified.IsZero() {
+		header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	fmt.Fprint(w, body)
+}
+
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		// Compared weakly, as RFC 7232 wants for If-None-Match
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		// HTTP dates are in whole seconds
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
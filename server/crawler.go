@@ -0,0 +1,63 @@
+// The crawler (see sampleFillRates) can run on its own, apart from the
+// servers answering the API: the server started with -crawler (or the
+// samorozvrh-crawler command, see CrawlerMain) does nothing but
+// crawling, sending the notifications it causes and answering /healthz.
+// It shares the store with the API servers, which then don't need
+// -fill-sample-interval. Instances crawling the same store take turns by
+// a lease per tenant, and skip the courses another one has just crawled.
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// How long a crawl lease lasts unless renewed. It is renewed before each
+// course, so this only needs to be longer than fetching a course takes.
+const CRAWL_LEASE_TTL = 10 * time.Minute
+
+// The name of the lease of crawling the tenant of ctx.
+func crawlLeaseName(ctx context.Context) string {
+	return "crawl:" + tenantCacheName(ctx, "")
+}
+
+// Crawls every interval until interrupted, answering /healthz on the port.
+func runCrawler(port int, interval time.Duration) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthzHandler)
+	server := &http.Server{
+		Addr:    ":" + strconv.Itoa(port),
+		Handler: mux,
+	}
+	go func() {
+		log.Printf("Crawling as %s every %s, health checks on: %d", instanceId, interval, port)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Could not start server: %s\n", err)
+		}
+	}()
+	ctx, stop := context.WithCancel(context.Background())
+	go fetches.run(ctx)
+	go watchSisPause(ctx)
+	go sampleFillRates(ctx, interval)
+	if notificationsEnabled() {
+		go dispatcher.run(ctx)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	stop()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	if err := db.Close(); err != nil {
+		log.Printf("Could not close the database: %s", err)
+	}
+}
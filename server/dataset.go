@@ -0,0 +1,320 @@
+// Datasets of the whole cached catalog of a semester (courses, their
+// groups and events with capacities), for scheduling research and
+// visualisations of others, so that they needn't scrape SIS themselves.
+// They are served at /api/v1/dataset (built at most once an hour, see
+// DATASET_CACHE_TTL) and written by -export-dataset, as JSON or as CSV with
+// a row per event. Teachers are anonymized: instead of
+// their names, events have pseudonyms which are the same for a teacher in
+// all courses (keyed by -dataset-salt, or by a random key for the run of
+// the server). Notes of events are left out, as they may name people.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"golang.org/x/sync/singleflight"
+)
+
+// The version of the format of datasets. Increase it whenever the format
+// changes in a way their users need to know about.
+const DATASET_VERSION = 1
+
+type dataset struct {
+	Version      int             `json:"version"`
+	Created      time.Time       `json:"created"`
+	AcademicYear int             `json:"academic_year"`
+	Semester     int             `json:"semester"`
+	Courses      []datasetCourse `json:"courses"`
+}
+
+type datasetCourse struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// When the events were fetched from SIS, zero if the answer doesn't say
+	Fetched time.Time      `json:"fetched"`
+	Groups  []datasetGroup `json:"groups"`
+}
+
+type datasetGroup struct {
+	Id       string         `json:"id"`
+	Optional bool           `json:"optional"`
+	Events   []datasetEvent `json:"events"`
+}
+
+type datasetEvent struct {
+	Code       string              `json:"code,omitempty"`
+	Type       sisparse.EventType  `json:"type"`
+	Day        int                 `json:"day"`
+	TimeFrom   sisparse.ClockTime  `json:"time_from"`
+	TimeTo     sisparse.ClockTime  `json:"time_to"`
+	WeekParity sisparse.WeekParity `json:"week_parity"`
+	Weeks      []int               `json:"weeks,omitempty"`
+	Room       string              `json:"room,omitempty"`
+	// The pseudonym of the teacher (see teacherPseudonym), empty if unknown
+	Teacher  string `json:"teacher,omitempty"`
+	Capacity int    `json:"capacity"`
+	Enrolled int    `json:"enrolled"`
+	Language string `json:"language,omitempty"`
+}
+
+// How long a dataset built for /api/v1/dataset is served before it is built
+// again, as building it reads the whole cache
+const DATASET_CACHE_TTL = time.Hour
+
+// A dataset as served, in both formats
+type builtDataset struct {
+	json, csv         string
+	jsonETag, csvETag string
+	built             time.Time
+}
+
+// The datasets built for /api/v1/dataset, by tenant and term
+var datasetCache = struct {
+	sync.Mutex
+	built map[string]builtDataset
+}{built: map[string]builtDataset{}}
+
+var datasetBuilds singleflight.Group
+
+// The key of the teachers' pseudonyms, see -dataset-salt
+var datasetSalt string
+
+var datasetKey struct {
+	sync.Mutex
+	key []byte
+}
+
+// Returns the pseudonym of the teacher of the event, a keyed hash of their
+// SIS ID (or of their name, if the ID is unknown).
+func teacherPseudonym(e sisparse.Event) string {
+	id := e.TeacherID
+	if id == "" {
+		if e.Teacher == "" {
+			return ""
+		}
+		id = "name:" + e.Teacher
+	}
+	datasetKey.Lock()
+	if datasetKey.key == nil {
+		if datasetSalt != "" {
+			datasetKey.key = []byte(datasetSalt)
+		} else {
+			datasetKey.key = make([]byte, 32)
+			if _, err := rand.Read(datasetKey.key); err != nil {
+				datasetKey.key = nil
+				datasetKey.Unlock()
+				return ""
+			}
+		}
+	}
+	mac := hmac.New(sha256.New, datasetKey.key)
+	datasetKey.Unlock()
+	mac.Write([]byte(id))
+	return "t" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+func newDatasetEvent(e sisparse.Event) datasetEvent {
+	return datasetEvent{
+		Code:       e.Code,
+		Type:       e.Type,
+		Day:        e.Day,
+		TimeFrom:   e.TimeFrom,
+		TimeTo:     e.TimeTo,
+		WeekParity: e.WeekParity,
+		Weeks:      e.Weeks,
+		Room:       e.Room,
+		Teacher:    teacherPseudonym(e),
+		Capacity:   e.Capacity,
+		Enrolled:   e.Enrolled,
+		Language:   e.Language,
+	}
+}
+
+// Collects the cached courses of the tenant of ctx in the semester (of the
+// academic year of ctx), sorted by code. Answers are taken however old
+// they are, as the dataset is of what we know.
+func buildDataset(ctx context.Context, sem int) (dataset, error) {
+	d := dataset{
+		Version:      DATASET_VERSION,
+		Created:      time.Now().UTC().Truncate(time.Second),
+		AcademicYear: sisparse.AcademicYear(ctx),
+		Semester:     sem,
+		Courses:      []datasetCourse{},
+	}
+	names, err := listTenantCache(ctx)
+	if err != nil {
+		return d, err
+	}
+	prefix := tenantCacheName(ctx, "")
+	for _, name := range names {
+		if !isCourseCacheName(name) {
+			continue
+		}
+		code := name
+		if i := strings.IndexAny(code, "@~"); i >= 0 {
+			code = code[:i]
+		}
+		if prefix+name != courseCacheName(ctx, code, sem) {
+			// Of another semester or academic year
+			continue
+		}
+		e, err := getCacheEntry(prefix + name)
+		if err != nil {
+			return d, err
+		}
+		var cached struct {
+			Data       [][]sisparse.Event `json:"data"`
+			Provenance provenance         `json:"provenance"`
+		}
+		if err := json.Unmarshal([]byte(e.Value), &cached); err != nil {
+			log.Printf("Dataset: skipping %s: %s", name, err)
+			continue
+		}
+		course := datasetCourse{Code: code, Fetched: cached.Provenance.Fetched, Groups: []datasetGroup{}}
+		for _, g := range cached.Data {
+			if len(g) == 0 {
+				continue
+			}
+			group := datasetGroup{Id: g[0].GroupID, Optional: g[0].Optional, Events: []datasetEvent{}}
+			for _, e := range g {
+				if course.Name == "" {
+					course.Name = e.Name
+				}
+				group.Events = append(group.Events, newDatasetEvent(e))
+			}
+			course.Groups = append(course.Groups, group)
+		}
+		d.Courses = append(d.Courses, course)
+	}
+	sort.Slice(d.Courses, func(i, j int) bool {
+		return d.Courses[i].Code < d.Courses[j].Code
+	})
+	return d, nil
+}
+
+// Writes the dataset as CSV, with a row per event.
+func writeDatasetCSV(w io.Writer, d dataset) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"course", "course_name", "group", "optional", "event", "type", "day", "time_from", "time_to",
+		"week_parity", "weeks", "room", "teacher", "capacity", "enrolled", "language"})
+	for _, course := range d.Courses {
+		for _, g := range course.Groups {
+			for _, e := range g.Events {
+				c.Write([]string{course.Code, course.Name, g.Id, strconv.FormatBool(g.Optional), e.Code, string(e.Type),
+					strconv.Itoa(e.Day), e.TimeFrom.String(), e.TimeTo.String(), e.WeekParity.String(),
+					sisparse.Event{Weeks: e.Weeks}.WeekList(), e.Room, e.Teacher, strconv.Itoa(e.Capacity), strconv.Itoa(e.Enrolled), e.Language})
+			}
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// Returns the dataset of the semester (of the academic year and tenant of
+// ctx), built at most DATASET_CACHE_TTL ago. Concurrent requests wait for
+// the same build.
+func getBuiltDataset(ctx context.Context, sem int) (builtDataset, error) {
+	key := fmt.Sprintf("%s%d/%d", tenantCacheName(ctx, ""), sisparse.AcademicYear(ctx), sem)
+	datasetCache.Lock()
+	b, ok := datasetCache.built[key]
+	datasetCache.Unlock()
+	if ok && time.Since(b.built) < DATASET_CACHE_TTL {
+		return b, nil
+	}
+	res, err, _ := datasetBuilds.Do(key, func() (interface{}, error) {
+		d, err := buildDataset(ctx, sem)
+		if err != nil {
+			return builtDataset{}, err
+		}
+		s, err := json.Marshal(d)
+		if err != nil {
+			return builtDataset{}, err
+		}
+		var csv bytes.Buffer
+		if err := writeDatasetCSV(&csv, d); err != nil {
+			return builtDataset{}, err
+		}
+		b := builtDataset{json: fmt.Sprintf(`{"data":%s}`, s), csv: csv.String(), built: d.Created}
+		b.jsonETag, b.csvETag = contentETag(b.json), contentETag(b.csv)
+		datasetCache.Lock()
+		datasetCache.built[key] = b
+		datasetCache.Unlock()
+		return b, nil
+	})
+	return res.(builtDataset), err
+}
+
+// Answers GET /api/v1/dataset with the dataset of the semester of the
+// request (see requestTerm), as CSV with ?format=csv. The dataset is built
+// at most once every DATASET_CACHE_TTL, and answered with its ETag.
+func datasetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use GET"}`)
+		return
+	}
+	t, err := requestTerm(r)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	b, err := getBuiltDataset(sisparse.WithAcademicYear(r.Context(), t.Year), t.Semester)
+	if err != nil {
+		log.Printf("Dataset error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	filename := fmt.Sprintf("samorozvrh-%d-%d", t.Year, t.Semester)
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		writeConditional(w, r, b.csv, b.csvETag, b.built)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+	writeConditional(w, r, b.json, b.jsonETag, b.built)
+}
+
+// Writes the dataset of the current semester to the file, as CSV if its
+// name ends in ".csv" and else as JSON.
+func exportDatasetToFile(filename string) error {
+	ctx := context.Background()
+	t := currentTerm(ctx)
+	d, err := buildDataset(sisparse.WithAcademicYear(ctx, t.Year), t.Semester)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(filename, ".csv") {
+		err = writeDatasetCSV(f, d)
+	} else {
+		err = json.NewEncoder(f).Encode(d)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	log.Printf("Exported %d courses of %d/%d to %s", len(d.Courses), t.Year, t.Semester, filename)
+	return f.Close()
+}
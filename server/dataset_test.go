@@ -0,0 +1,68 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+func TestDatasetHandlerCaches(t *testing.T) {
+	defer func(old store.Store) { db = old }(db)
+	s := &mapCacheStore{entries: map[string]store.CacheEntry{}}
+	db = s
+	datasetCache.built = map[string]builtDataset{}
+	t.Cleanup(func() { datasetCache.built = map[string]builtDataset{} })
+	cache := func(code string) {
+		data, err := json.Marshal(searchCourse(code, "Programování", "Tomáš Holan"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetCache(getCacheKey(code), fmt.Sprintf(`{"data":%s}`, data))
+	}
+	get := func(query, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/dataset"+query, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		datasetHandler(w, r)
+		return w
+	}
+
+	cache("NPRG030")
+	first := get("", "")
+	if !strings.Contains(first.Body.String(), `"NPRG030"`) {
+		t.Fatalf("Dataset %s", first.Body.String())
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("The dataset has no ETag")
+	}
+
+	// Not built again for each request
+	cache("NPRG031")
+	if w := get("", ""); w.Body.String() != first.Body.String() {
+		t.Errorf("The dataset was built again: %s", w.Body.String())
+	}
+	if w := get("", etag); w.Code != http.StatusNotModified {
+		t.Errorf("Request with the ETag: status %d, want 304", w.Code)
+	}
+	if w := get("?format=csv", ""); !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") || !strings.Contains(w.Body.String(), "NPRG030,") {
+		t.Errorf("CSV dataset %q", w.Body.String())
+	}
+
+	// Built again once it is too old
+	for k, b := range datasetCache.built {
+		b.built = b.built.Add(-DATASET_CACHE_TTL - time.Second)
+		datasetCache.built[k] = b
+	}
+	if w := get("", ""); !strings.Contains(w.Body.String(), `"NPRG031"`) {
+		t.Errorf("The dataset wasn't built again after %s: %s", DATASET_CACHE_TTL, w.Body.String())
+	}
+}
@@ -0,0 +1,221 @@
+// Demand for the parallel groups of courses: how many schedules chose
+// each group, next to its capacity, so that departments can see which
+// groups are too small, and which to add or move. Counted from the
+// schedules of -mass and /admin/mass, or from the solver runs of the last
+// days (see jobs.go).
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The most days of solver runs /admin/demand looks at
+const MAX_DEMAND_DAYS = 365
+
+type groupDemand struct {
+	Course   string `json:"course"`
+	Group    string `json:"group"`
+	Semester int    `json:"semester"`
+	// When and where its events are, see groupSlots
+	Slots []string `json:"slots"`
+	// As SIS says when the group was fetched, 0 if unlimited or unknown
+	Capacity int `json:"capacity"`
+	Enrolled int `json:"enrolled"`
+	// How many schedules chose it
+	Demand int `json:"demand"`
+}
+
+// Counts the groups chosen by schedules.
+type demandCounter map[string]*groupDemand
+
+func (d demandCounter) add(course string, group []sisparse.Event) {
+	if len(group) == 0 {
+		return
+	}
+	first := group[0]
+	if first.CourseCode != "" {
+		course = first.CourseCode
+	}
+	slots := groupSlots(group)
+	id := first.GroupID
+	if id == "" {
+		// Queries made by hand may not have them
+		id = strings.Join(slots, "; ")
+	}
+	key := course + "\x00" + id
+	g, ok := d[key]
+	if !ok {
+		g = &groupDemand{Course: course, Group: first.GroupID, Semester: first.Semester, Slots: slots}
+		d[key] = g
+	}
+	for _, e := range group {
+		if e.Capacity > 0 && (g.Capacity == 0 || e.Capacity < g.Capacity) {
+			// The group is as big as its smallest event
+			g.Capacity, g.Enrolled = e.Capacity, e.Enrolled
+		}
+	}
+	g.Demand++
+}
+
+// Returns the groups, those chosen the most over their capacity first.
+func (d demandCounter) list() []groupDemand {
+	res := []groupDemand{}
+	for _, g := range d {
+		res = append(res, *g)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.overflow() != b.overflow() {
+			return a.overflow() > b.overflow()
+		}
+		if a.Demand != b.Demand {
+			return a.Demand > b.Demand
+		}
+		return a.Course+a.Group < b.Course+b.Group
+	})
+	return res
+}
+
+// How many schedules chose the group over its capacity (0 for unlimited
+// groups).
+func (g groupDemand) overflow() int {
+	if g.Capacity == 0 || g.Demand <= g.Capacity {
+		return 0
+	}
+	return g.Demand - g.Capacity
+}
+
+func writeDemandCSV(w io.Writer, groups []groupDemand) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"course", "group", "semester", "slots", "capacity", "enrolled", "demand", "over_capacity"})
+	for _, g := range groups {
+		c.Write([]string{g.Course, g.Group, strconv.Itoa(g.Semester), strings.Join(g.Slots, "; "),
+			strconv.Itoa(g.Capacity), strconv.Itoa(g.Enrolled), strconv.Itoa(g.Demand), strconv.Itoa(g.overflow())})
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// Counts the groups chosen by the solver runs done in the last days. Of
+// the runs of a signed-in user, only the last one counts, as students
+// solve again and again until they like the schedule.
+func demandFromJobs(days int) (demandCounter, int, error) {
+	jobs, err := db.ListJobs(JOB_DONE)
+	if err != nil {
+		return nil, 0, err
+	}
+	since := time.Now().AddDate(0, 0, -days)
+	latest := map[string]int{}
+	for i, job := range jobs {
+		if job.UserHash != "" && job.Created.After(since) {
+			latest[job.UserHash] = i
+		}
+	}
+	d := demandCounter{}
+	counted := 0
+	for i, job := range jobs {
+		if job.Created.Before(since) || (job.UserHash != "" && latest[job.UserHash] != i) {
+			continue
+		}
+		req, err := parseSolveRequest([]byte(job.Request))
+		if err != nil {
+			continue
+		}
+		var res solveResponse
+		if err := json.Unmarshal([]byte(job.Result), &res); err != nil || res.Error != "" || len(res.Data) != len(req.Courses) {
+			continue
+		}
+		for k, c := range req.Courses {
+			var options [][]sisparse.Event
+			if res.Data[k] == nil || json.Unmarshal(c.Options, &options) != nil || *res.Data[k] >= len(options) {
+				continue
+			}
+			course := c.CourseCode
+			if course == "" {
+				course = c.Name
+			}
+			d.add(course, options[*res.Data[k]])
+		}
+		counted++
+	}
+	return d, counted, nil
+}
+
+// The sorts of pages of groups (see parsePageRequest): those most over
+// their capacity first (as list returns them), those chosen the most first,
+// or by the course
+var demandSorts = []string{"overflow", "demand", "course"}
+
+// Returns the page of the groups in the order of the sort.
+func pageDemand(groups []groupDemand, p pageRequest) ([]groupDemand, string) {
+	keys := sortByKeys(len(groups), func(i int) string {
+		g := groups[i]
+		id := g.Course + "\x00" + g.Group + "\x00" + strings.Join(g.Slots, "; ")
+		switch p.Sort {
+		case "demand":
+			return descending(g.Demand) + "\x00" + id
+		case "course":
+			return id
+		}
+		return descending(g.overflow()) + "\x00" + descending(g.Demand) + "\x00" + id
+	}, func(i, j int) {
+		groups[i], groups[j] = groups[j], groups[i]
+	})
+	from, to, next := p.page(keys)
+	return groups[from:to], next
+}
+
+// Answers GET /admin/demand?days=30 with {"data":{"schedules":n,"groups":[groupDemand]}},
+// the groups paged (see paging.go), or with &format=csv, all the groups as CSV.
+func demandHandler(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePageRequest(r, demandSorts...)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	days := 30
+	if s := r.URL.Query().Get("days"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MAX_DEMAND_DAYS {
+			fmt.Fprintf(w, `{"error":"The days must be between 1 and %d"}`, MAX_DEMAND_DAYS)
+			return
+		}
+		days = n
+	}
+	d, counted, err := demandFromJobs(days)
+	if err != nil {
+		log.Printf("Demand error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	if r.URL.Query().Get("format") == "csv" {
+		writeDemand(w, r, counted, d.list())
+		return
+	}
+	groups, next := pageDemand(d.list(), p)
+	res, _ := pageJSON(map[string]interface{}{"schedules": counted, "groups": groups}, next)
+	fmt.Fprint(w, res)
+}
+
+// Writes the groups as JSON, or as CSV with ?format=csv.
+func writeDemand(w http.ResponseWriter, r *http.Request, schedules int, groups []groupDemand) {
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="demand.csv"`)
+		writeDemandCSV(w, groups)
+		return
+	}
+	s, _ := json.Marshal(map[string]interface{}{"schedules": schedules, "groups": groups})
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
@@ -0,0 +1,208 @@
+// The Discord bot, see bot.go. Discord sends the slash commands of the
+// application to /bots/discord as interactions; they are answered at once
+// as "thinking" and the answer follows when it's ready. Notifications of
+// changes are sent to channels with the token of the bot user.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DISCORD_API = "https://discord.com/api/v10"
+	// The longest message Discord takes, in characters
+	DISCORD_MESSAGE_LIMIT = 2000
+)
+
+// Interaction types and the types of the answers to them
+const (
+	DISCORD_PING                = 1
+	DISCORD_APPLICATION_COMMAND = 2
+	DISCORD_PONG                = 1
+	DISCORD_DEFERRED_MESSAGE    = 5
+)
+
+type discordBot struct {
+	// The public key of the application, by which interactions are signed
+	publicKey ed25519.PublicKey
+	// Of the bot user, to send notifications and register the commands;
+	// may be empty
+	token  string
+	client *http.Client
+}
+
+var discord *discordBot
+
+type discordInteraction struct {
+	Type          int    `json:"type"`
+	Token         string `json:"token"`
+	ApplicationId string `json:"application_id"`
+	ChannelId     string `json:"channel_id"`
+	// Who sent the command: the member in a server, the user in a DM
+	Member *struct {
+		User discordUser `json:"user"`
+	} `json:"member"`
+	User   *discordUser `json:"user"`
+	Locale string       `json:"locale"`
+	Data   struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string      `json:"name"`
+			Value interface{} `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+type discordUser struct {
+	Id string `json:"id"`
+}
+
+// Returns the id of the user who sent the command.
+func (in discordInteraction) userId() string {
+	if in.Member != nil {
+		return in.Member.User.Id
+	}
+	if in.User != nil {
+		return in.User.Id
+	}
+	return ""
+}
+
+func newDiscordBot(publicKey, token string) (*discordBot, error) {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Invalid public key of the Discord application")
+	}
+	b := &discordBot{publicKey: key, token: token, client: &http.Client{Timeout: 10 * time.Second}}
+	if token != "" {
+		registerNotifier("discord", chatNotifier(b.send))
+	}
+	return b, nil
+}
+
+// The slash commands of the bot, as Discord registers them; each has one
+// string option at most
+var discordCommands = []struct {
+	Name, Description, Option, OptionDescription string
+}{
+	{"help", "What the bot can do", "", ""},
+	{"course", "The groups of a course in this semester", "code", "The code of the course, e.g. NPRG030"},
+	{"solve", "A schedule of the courses", "codes", "The codes of the courses separated by spaces"},
+	{"follow", "Tell this channel when the schedule of a course changes", "code", "The code of the course"},
+	{"unfollow", "Stop telling this channel about a course", "code", "The code of the course"},
+	{"following", "The courses this channel follows", "", ""},
+	{"link", "Send your notifications to this channel", "code", "The link code from your notification settings"},
+	{"unlink", "Stop sending your notifications to this channel", "code", "The link code from your notification settings"},
+}
+
+// Registers the slash commands of the application (replacing those it had),
+// which needs the token of the bot.
+func (b *discordBot) registerCommands(applicationId string) error {
+	commands := []map[string]interface{}{}
+	for _, c := range discordCommands {
+		command := map[string]interface{}{"name": c.Name, "description": c.Description}
+		if c.Option != "" {
+			command["options"] = []map[string]interface{}{{
+				"type":        3, // A string
+				"name":        c.Option,
+				"description": c.OptionDescription,
+				"required":    true,
+			}}
+		}
+		commands = append(commands, command)
+	}
+	body, _ := json.Marshal(commands)
+	return b.call("PUT", "/applications/"+applicationId+"/commands", body)
+}
+
+// Answers the interactions which Discord sends to /bots/discord.
+func discordHandler(w http.ResponseWriter, r *http.Request) {
+	if discord == nil {
+		http.NotFound(w, r)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Discord checks that requests which aren't signed are refused
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	message := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	if err != nil || !ed25519.Verify(discord.publicKey, message, sig) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var in discordInteraction
+	if err := json.Unmarshal(body, &in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch in.Type {
+	case DISCORD_PING:
+		fmt.Fprintf(w, `{"type":%d}`, DISCORD_PONG)
+	case DISCORD_APPLICATION_COMMAND:
+		fmt.Fprintf(w, `{"type":%d}`, DISCORD_DEFERRED_MESSAGE)
+		args := []string{}
+		for _, o := range in.Data.Options {
+			if s, ok := o.Value.(string); ok {
+				args = append(args, strings.Fields(s)...)
+			}
+		}
+		ctx := withLanguage(context.Background(), DEFAULT_LANGUAGE)
+		// Discord's locales are e.g. "cs" or "en-US"
+		if lang := strings.Split(in.Locale, "-")[0]; isLanguage(lang) {
+			ctx = withLanguage(ctx, lang)
+		}
+		go func() {
+			answer := runBotCommand(ctx, "discord:"+in.ChannelId, "discord:"+in.userId(), in.Data.Name, args)
+			if err := discord.answer(in, answer); err != nil {
+				log.Printf("Discord error: %s", err)
+			}
+		}()
+	default:
+		http.Error(w, "Unsupported interaction", http.StatusBadRequest)
+	}
+}
+
+// Replaces the "thinking" answer to the interaction with the text.
+func (b *discordBot) answer(in discordInteraction, text string) error {
+	body, _ := json.Marshal(map[string]string{"content": truncateMessage(text, DISCORD_MESSAGE_LIMIT)})
+	return b.call("PATCH", "/webhooks/"+in.ApplicationId+"/"+in.Token+"/messages/@original", body)
+}
+
+func (b *discordBot) send(channel, text string) error {
+	body, _ := json.Marshal(map[string]string{"content": truncateMessage(text, DISCORD_MESSAGE_LIMIT)})
+	return b.call("POST", "/channels/"+channel+"/messages", body)
+}
+
+func (b *discordBot) call(method, path string, body []byte) error {
+	req, err := http.NewRequest(method, DISCORD_API+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if b.token != "" && !strings.HasPrefix(path, "/webhooks/") {
+		req.Header.Set("Authorization", "Bot "+b.token)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Discord returned %s for %s %s", resp.Status, method, strings.SplitN(path, "/", 3)[1])
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// Sending notifications: each channel (email, the chats of the bots) is a
+// notifier, and the dispatcher queues the notifications for them, retries
+// those which fail and limits how many each target gets. A new channel
+// (Matrix, push) is a notifier registered by registerNotifier when it is
+// configured; users then choose it among their channels.
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Notifications waiting to be sent beyond this are dropped
+	MAX_NOTIFY_QUEUE = 10000
+	// How many times a notification is tried, and how long to wait before
+	// the first retry (doubled for each next one)
+	NOTIFY_ATTEMPTS    = 5
+	NOTIFY_RETRY_DELAY = time.Minute
+	// The most notifications a target gets in an hour, counted over all the
+	// instances; the rest wait for the next hour
+	NOTIFY_HOURLY_LIMIT = 20
+)
+
+// What to tell a target: the name of the template (see emailTemplates) and
+// what to fill into it, in the given language.
+type notification struct {
+	Kind        string
+	Lang        string
+	Data        emailData
+	SubjectArgs []interface{}
+}
+
+func (n notification) subject() string {
+	return localize(withLanguage(context.Background(), n.Lang), "email_"+n.Kind+"_subject", n.SubjectArgs...)
+}
+
+func (n notification) text() (string, error) {
+	data := n.Data
+	data.Lang = n.Lang
+	var b strings.Builder
+	err := emailTemplates[n.Kind].Execute(&b, data)
+	return b.String(), err
+}
+
+// A channel notifications are sent through.
+type notifier interface {
+	// Sends the notification to the address (the target without the
+	// channel, e.g. the chat ID)
+	Send(address string, n notification) error
+}
+
+// Sends the text of notifications to chats by a function of the bot.
+type chatNotifier func(chat, text string) error
+
+func (send chatNotifier) Send(chat string, n notification) error {
+	text, err := n.text()
+	if err != nil {
+		return err
+	}
+	return send(chat, strings.TrimSpace(text))
+}
+
+// By channel, e.g. "email" or "telegram"
+var notifiers = map[string]notifier{}
+
+func registerNotifier(channel string, n notifier) {
+	notifiers[channel] = n
+}
+
+// Whether anybody can be told about changes of courses, so that they are
+// worth looking for.
+func notificationsEnabled() bool {
+	return len(notifiers) > 0
+}
+
+// Splits a target such as "telegram:123" into the channel and the address;
+// ok is false if there is no such channel.
+func splitTarget(target string) (channel, address string, ok bool) {
+	i := strings.Index(target, ":")
+	if i < 0 {
+		return "", "", false
+	}
+	channel, address = target[:i], target[i+1:]
+	_, ok = notifiers[channel]
+	return channel, address, ok
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+type queuedNotification struct {
+	target       string
+	notification notification
+	attempts     int
+	notBefore    time.Time
+}
+
+type notifyDispatcher struct {
+	mu    sync.Mutex
+	queue []*queuedNotification
+	// Signalled when a notification is queued
+	wake chan struct{}
+}
+
+var dispatcher = &notifyDispatcher{wake: make(chan struct{}, 1)}
+
+// Queues the notification for the target, e.g. "telegram:123".
+func (d *notifyDispatcher) enqueue(target string, n notification) {
+	if _, _, ok := splitTarget(target); !ok {
+		return
+	}
+	d.mu.Lock()
+	if len(d.queue) >= MAX_NOTIFY_QUEUE {
+		d.mu.Unlock()
+		log.Printf("Notification queue is full, dropping a %s notification", n.Kind)
+		return
+	}
+	d.queue = append(d.queue, &queuedNotification{target: target, notification: n})
+	d.mu.Unlock()
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Sends the queued notifications until ctx is done. The notifications still
+// queued then are lost.
+func (d *notifyDispatcher) run(ctx context.Context) {
+	for {
+		next := d.sendDue()
+		wait := time.Minute
+		if !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Sends the notifications which are due and returns when the next one of
+// the rest is (zero if none is left).
+func (d *notifyDispatcher) sendDue() time.Time {
+	now := time.Now()
+	d.mu.Lock()
+	due, rest := []*queuedNotification{}, []*queuedNotification{}
+	for _, q := range d.queue {
+		if q.notBefore.After(now) {
+			rest = append(rest, q)
+		} else {
+			due = append(due, q)
+		}
+	}
+	d.queue = rest
+	d.mu.Unlock()
+
+	for _, q := range due {
+		if next, ok := d.allow(q.target); !ok {
+			q.notBefore = next
+			d.requeue(q)
+			continue
+		}
+		channel, address, _ := splitTarget(q.target)
+		err := notifiers[channel].Send(address, q.notification)
+		if err == nil {
+			continue
+		}
+		q.attempts++
+		if q.attempts >= NOTIFY_ATTEMPTS {
+			log.Printf("Giving up a %s notification through %s: %s", q.notification.Kind, channel, err)
+			continue
+		}
+		log.Printf("Could not send a %s notification through %s (will retry): %s", q.notification.Kind, channel, err)
+		q.notBefore = time.Now().Add(NOTIFY_RETRY_DELAY << uint(q.attempts-1))
+		d.requeue(q)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var next time.Time
+	for _, q := range d.queue {
+		if next.IsZero() || q.notBefore.Before(next) {
+			next = q.notBefore
+		}
+	}
+	return next
+}
+
+func (d *notifyDispatcher) requeue(q *queuedNotification) {
+	d.mu.Lock()
+	d.queue = append(d.queue, q)
+	d.mu.Unlock()
+}
+
+// Counts a notification to the target against NOTIFY_HOURLY_LIMIT. If it
+// is over, returns when the next hour starts.
+func (d *notifyDispatcher) allow(target string) (time.Time, bool) {
+	hour := time.Now().Unix() / 3600
+	// Counters are kept in the database, so by the hash of the target
+	n, err := db.IncrementCounter(fmt.Sprintf("notify/%s", hashToken(target)[:16]), hour)
+	if err != nil {
+		log.Printf("Could not check the notification limit: %s", err)
+		return time.Time{}, true
+	}
+	return time.Unix((hour+1)*3600, 0), n <= NOTIFY_HOURLY_LIMIT
+}
@@ -0,0 +1,157 @@
+// A self-test against the live SIS, run by `server doctor` (or -doctor):
+// known courses are fetched from SIS (bypassing the cache), parsed and
+// solved, and what we rely on is checked on the way, e.g. that they have
+// groups with events at sensible times. The report is red and green, one
+// line per check, so that when SIS changes something, the operator sees
+// what broke.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// Courses which have been taught for years, as <code>@<semester>. Replaced
+// by -doctor-course.
+var doctorCourses = []string{"NPRG030@1", "NMAI054@1", "NDMI002@1", "NTIN061@2", "NTVY014@1"}
+
+type doctorCheck struct {
+	Name  string
+	Error string
+}
+
+// Checks each course and writes the report to w, in colors if color is
+// set. Returns false if any check failed.
+func runDoctor(ctx context.Context, w io.Writer, courses []string, color bool) bool {
+	ok := true
+	for _, course := range courses {
+		code, sem, err := parseCourseTerm(ctx, course)
+		if err != nil {
+			ok = false
+			fmt.Fprintf(w, "%s %s\n", doctorMark(false, color), err)
+			continue
+		}
+		fmt.Fprintf(w, "%s (semester %d)\n", code, sem)
+		for _, c := range doctorCourse(ctx, code, sem) {
+			mark := doctorMark(c.Error == "", color)
+			if c.Error == "" {
+				fmt.Fprintf(w, "  %s %s\n", mark, c.Name)
+			} else {
+				ok = false
+				fmt.Fprintf(w, "  %s %s: %s\n", mark, c.Name, c.Error)
+			}
+		}
+	}
+	fmt.Fprintln(w, doctorMark(ok, color), "overall")
+	return ok
+}
+
+func doctorMark(ok bool, color bool) string {
+	switch {
+	case ok && color:
+		return "\x1b[32mOK  \x1b[0m"
+	case ok:
+		return "OK  "
+	case color:
+		return "\x1b[31mFAIL\x1b[0m"
+	}
+	return "FAIL"
+}
+
+// Runs the course through fetching, parsing and solving. The checks after
+// one which failed are left out, as they would fail too.
+func doctorCourse(ctx context.Context, code string, sem int) (checks []doctorCheck) {
+	stage := "fetch and parse"
+	defer func() {
+		if r := recover(); r != nil {
+			checks = append(checks, doctorCheck{stage, fmt.Sprintf("Panicked: %v", r)})
+		}
+	}()
+	check := func(name string, err error) bool {
+		c := doctorCheck{Name: name}
+		if err != nil {
+			c.Error = err.Error()
+		}
+		checks = append(checks, c)
+		return err == nil
+	}
+
+	groups, diag, err := sisparse.DiagnoseCourseEvents(ctx, code, sem)
+	if !check(stage, err) {
+		return
+	}
+	if diag.LayoutError != "" {
+		check("table header", fmt.Errorf("%s (columns: %s)", diag.LayoutError, diag.Layout))
+	}
+	if !check("groups", doctorGroups(groups)) {
+		return
+	}
+	check("times", doctorTimes(groups))
+
+	stage = "solve"
+	options, err := json.Marshal(groups)
+	if !check("encode", err) {
+		return
+	}
+	query, _ := json.Marshal([]solveCourse{{Name: code, CourseCode: code, Options: options}})
+	answer, err := Solve(ctx, query, solverOptions{})
+	if err == nil {
+		var solved struct {
+			Data  []*int `json:"data"`
+			Error string `json:"error"`
+		}
+		if err = json.Unmarshal(answer, &solved); err == nil && solved.Error != "" {
+			err = fmt.Errorf("%s", solved.Error)
+		} else if err == nil && (len(solved.Data) != 1 || solved.Data[0] == nil) {
+			err = fmt.Errorf("No group was chosen")
+		}
+	}
+	check(stage, err)
+	return
+}
+
+// Checks that there are groups and each of them has events.
+func doctorGroups(groups [][]sisparse.Event) error {
+	if len(groups) == 0 {
+		return fmt.Errorf("No groups")
+	}
+	for i, g := range groups {
+		if len(g) == 0 {
+			return fmt.Errorf("Group %d has no events", i+1)
+		}
+	}
+	return nil
+}
+
+// Checks that the events are on a day of the week, end after they begin
+// and that their times survive being written and read again.
+func doctorTimes(groups [][]sisparse.Event) error {
+	for _, g := range groups {
+		for _, e := range g {
+			if e.Day < 0 || e.Day > 6 {
+				return fmt.Errorf("%s: invalid day %d", e.GroupID, e.Day)
+			}
+			if e.TimeTo <= e.TimeFrom {
+				return fmt.Errorf("%s: ends at %s, before it begins at %s", e.GroupID, e.TimeTo, e.TimeFrom)
+			}
+			for _, t := range []sisparse.ClockTime{e.TimeFrom, e.TimeTo} {
+				if parsed, err := sisparse.ParseClockTime(t.String()); err != nil || parsed != t {
+					return fmt.Errorf("%s: time %s doesn't read back", e.GroupID, t)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Reports whether the file is a terminal, which the report can be colored
+// for.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
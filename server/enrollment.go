@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// Whether students may log in to SIS through us to import their enrollment.
+// Off by default, as it means handling their passwords.
+var sisLoginEnabled = false
+
+type enrollmentRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type enrolledCourse struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+	// Indices of the groups (as returned by /sisquery/) the student
+	// is enrolled in
+	EnrolledGroups []int `json:"enrolled_groups"`
+}
+
+// Logs the student in to SIS and returns the courses they are enrolled in,
+// as {"data":[{"code":...,"name":...,"enrolled_groups":[...]}, ...]}.
+// Expects a POST with {"login":...,"password":...}; the credentials are
+// passed on to CAS and neither logged nor stored.
+func enrollmentHandler(w http.ResponseWriter, r *http.Request) {
+	if !sisLoginEnabled {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"Logging in to SIS is not enabled on this server"}`)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Expected a POST request"}`)
+		return
+	}
+	var req enrollmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fmt.Fprintf(w, `{"error":"Invalid request: %s"}`, err)
+		return
+	}
+
+	ctx := r.Context()
+	session, err := sisparse.Login(ctx, req.Login, req.Password)
+	if err != nil {
+		logf(ctx, "SIS login error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	enrolled, err := session.GetEnrolledCourses(ctx)
+	if err != nil {
+		logf(ctx, "Enrollment error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+
+	res := []enrolledCourse{}
+	for _, c := range enrolled {
+		groups, err := getCourseGroups(ctx, c.Code)
+		if err != nil {
+			logf(ctx, "Enrollment error for %s: %s", c.Code, err)
+		}
+		res = append(res, enrolledCourse{
+			Code:           c.Code,
+			Name:           c.Name,
+			EnrolledGroups: matchEnrolledGroups(groups, c.Events),
+		})
+	}
+	s, _ := json.Marshal(res)
+	fmt.Fprintf(w, `{"data":%s}`, s)
+}
+
+// Returns the groups of events of a course, going through the cache.
+func getCourseGroups(ctx context.Context, code string) ([][]sisparse.Event, error) {
+	// The enrollment module shows the current semester
+	res, err := queryCourse(ctx, code, currentTerm(ctx).Semester)
+	if err != nil {
+		return nil, err
+	}
+	return parseCourseResponse(res)
+}
+
+// Decodes the events from a response made by queryCourse.
+func parseCourseResponse(res string) ([][]sisparse.Event, error) {
+	var parsed struct {
+		Data [][]sisparse.Event `json:"data"`
+	}
+	err := json.Unmarshal([]byte(res), &parsed)
+	return parsed.Data, err
+}
+
+// Returns the indices of the groups which contain an event the student
+// is enrolled in. Events are identified by their type, day and start.
+func matchEnrolledGroups(groups [][]sisparse.Event, events []sisparse.EnrolledEvent) []int {
+	res := []int{}
+	for i, group := range groups {
+	events:
+		for _, e := range group {
+			for _, en := range events {
+				if e.Type == en.Type && e.Day == en.Day && e.TimeFrom == en.TimeFrom {
+					res = append(res, i)
+					break events
+				}
+			}
+		}
+	}
+	return res
+}
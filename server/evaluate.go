@@ -0,0 +1,255 @@
+// Scoring a schedule made elsewhere (by hand, or by the solver earlier) by
+// the same criteria as the solver scores its own, so that students can
+// compare their plan with the solver's.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// A schedule to score, e.g.
+//
+//	{"courses": [...],           // As in solve requests
+//	 "selection": [0, null, 1],  // The option chosen for each course, as "data" of solve answers
+//	 "previous": [0, null, 0],   // For the stability bonus
+//	 "weights": {"stability": 50, "balance": 10},
+//	 "commute": {"minutes": 40}} // As in solve requests
+type evaluateRequest struct {
+	Courses   []solveCourse `json:"courses"`
+	Selection []*int        `json:"selection"`
+	Previous  []*int        `json:"previous,omitempty"`
+	Weights   solveWeights  `json:"weights"`
+	Commute   solveCommute  `json:"commute"`
+}
+
+// The score of a schedule, as in solveResponse, and the moves between
+// buildings there isn't enough time for.
+type evaluation struct {
+	Score       map[string]float64 `json:"score"`
+	Explanation []string           `json:"explanation"`
+	Semesters   json.RawMessage    `json:"semesters"`
+	Pairings    json.RawMessage    `json:"pairings"`
+	Overlaps    json.RawMessage    `json:"overlaps"`
+	Travel      []travelIssue      `json:"travel"`
+}
+
+// Two events following each other on a day in rooms further apart than
+// the break between them allows, by the travel times of the tenant.
+type travelIssue struct {
+	// The indices of the courses, the one of the earlier event first
+	Courses  [2]int    `json:"courses"`
+	Semester int       `json:"semester"`
+	Day      int       `json:"day"`
+	Rooms    [2]string `json:"rooms"`
+	// The break between the events and the time it takes to walk
+	GapMinutes    int `json:"gap_minutes"`
+	NeededMinutes int `json:"needed_minutes"`
+}
+
+// Answers POST /api/v1/evaluate (see evaluateRequest) with {"data":evaluation}.
+func evaluateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use POST"}`)
+		return
+	}
+	ctx := r.Context()
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		logf(ctx, "Evaluate error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	var req evaluateRequest
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&req); err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	res, err := evaluateSchedule(r, req)
+	if err == ErrQuotaExceeded {
+		writeQuotaExceeded(w, ctx)
+		return
+	} else if err != nil {
+		logf(ctx, "Evaluate error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	s, _ := json.Marshal(res)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func evaluateSchedule(r *http.Request, req evaluateRequest) (evaluation, error) {
+	var res evaluation
+	if len(req.Selection) != len(req.Courses) {
+		return res, fmt.Errorf("Expected %d options in the selection, got %d", len(req.Courses), len(req.Selection))
+	}
+	if req.Previous != nil && len(req.Previous) != len(req.Courses) {
+		return res, fmt.Errorf("Expected %d previous options, got %d", len(req.Courses), len(req.Previous))
+	}
+	if err := req.Commute.normalize(); err != nil {
+		return res, err
+	}
+	chosen := make([][]sisparse.Event, len(req.Courses))
+	for i := range req.Courses {
+		c := &req.Courses[i]
+		if req.Previous != nil {
+			c.PreviousOption = req.Previous[i]
+		}
+		if req.Selection[i] == nil {
+			continue
+		}
+		var options [][]sisparse.Event
+		if err := json.Unmarshal(c.Options, &options); err != nil {
+			return res, fmt.Errorf("Invalid options of %s: %s", c.Name, err)
+		}
+		option := *req.Selection[i]
+		if option < 0 || option >= len(options) {
+			return res, fmt.Errorf("Invalid option %d of %s", option, c.Name)
+		}
+		chosen[i] = options[option]
+		for j := range chosen[i] {
+			chosen[i][j].WeekParity = resolveWeekParity(r.Context(), chosen[i][j])
+		}
+	}
+
+	courses, err := json.Marshal(req.Courses)
+	if err != nil {
+		return res, err
+	}
+	selection, err := json.Marshal(req.Selection)
+	if err != nil {
+		return res, err
+	}
+	answer, err := Solve(r.Context(), courses, solverOptions{
+		Stability:      req.Weights.Stability,
+		Balance:        req.Weights.Balance,
+		BackToBack:     req.Weights.BackToBack,
+		CommuteMinutes: req.Commute.Minutes,
+		LeaveAfter:     req.Commute.LeaveAfter,
+		HomeBy:         req.Commute.HomeBy,
+		CommutePenalty: req.Weights.Commute,
+		Evaluate:       selection,
+	})
+	if err != nil {
+		return res, err
+	}
+	var solved solveResponse
+	if err := json.Unmarshal(answer, &solved); err != nil {
+		return res, err
+	}
+	if solved.Error != "" {
+		return res, fmt.Errorf("%s", solved.Error)
+	}
+	if solved.Score == nil {
+		solved.Score = map[string]float64{}
+	}
+	for name, score := range objectiveScores(req.Courses, req.Selection) {
+		solved.Score[name] = score
+	}
+	res = evaluation{
+		Score:       solved.Score,
+		Explanation: solved.Explanation,
+		Semesters:   solved.Semesters,
+		Pairings:    solved.Pairings,
+		Overlaps:    solved.Overlaps,
+		Travel:      findTravelIssues(tenantOf(r.Context()), chosen),
+	}
+	return res, nil
+}
+
+// Returns the events following each other (in the same weeks) without
+// enough time to get from one room to the other. The events are those of
+// the chosen option of each course.
+func findTravelIssues(t *tenant, chosen [][]sisparse.Event) []travelIssue {
+	type courseEvent struct {
+		sisparse.Event
+		course int
+	}
+	byDay := map[[2]int][]courseEvent{}
+	for i, events := range chosen {
+		for _, e := range events {
+			key := [2]int{e.Semester, e.Day}
+			byDay[key] = append(byDay[key], courseEvent{e, i})
+		}
+	}
+	res := []travelIssue{}
+	for key, events := range byDay {
+		sort.Slice(events, func(i, j int) bool {
+			return events[i].TimeFrom < events[j].TimeFrom
+		})
+		for i, a := range events {
+			for _, b := range events[i+1:] {
+				if !a.SharesWeek(b.Event) {
+					continue
+				}
+				gap := b.TimeFrom.Minutes() - a.TimeTo.Minutes()
+				if gap < 0 {
+					// An overlap, which the solver reports
+					continue
+				}
+				needed, ok := travelMinutes(t, a.Room, b.Room)
+				if ok && needed > gap {
+					res = append(res, travelIssue{
+						Courses:       [2]int{a.course, b.course},
+						Semester:      key[0],
+						Day:           key[1],
+						Rooms:         [2]string{a.Room, b.Room},
+						GapMinutes:    gap,
+						NeededMinutes: needed,
+					})
+				}
+				// Only the next event in the same weeks matters
+				break
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Semester != b.Semester {
+			return a.Semester < b.Semester
+		}
+		return a.Day < b.Day || (a.Day == b.Day && a.Courses[0] < b.Courses[0])
+	})
+	return res
+}
+
+// Reports whether events of the week parities ever take place in the same week.
+func sameWeeks(a, b sisparse.WeekParity) bool {
+	return a == sisparse.EveryWeek || b == sisparse.EveryWeek || a == b
+}
+
+// Returns the minutes it takes to get between the rooms by the travel times
+// of the tenant, false if they aren't known. A room is in the building
+// with the longest name it starts with (e.g. "S" for "S5").
+func travelMinutes(t *tenant, fromRoom, toRoom string) (int, bool) {
+	from, to := roomBuilding(t, fromRoom), roomBuilding(t, toRoom)
+	if from == "" || to == "" || from == to {
+		return 0, false
+	}
+	if m, ok := t.travel[from][to]; ok {
+		return m, true
+	}
+	m, ok := t.travel[to][from]
+	return m, ok
+}
+
+func roomBuilding(t *tenant, room string) string {
+	res := ""
+	for building := range t.travel {
+		if strings.HasPrefix(room, building) && len(building) > len(res) {
+			res = building
+		}
+	}
+	return res
+}
@@ -0,0 +1,199 @@
+// Exam dates of courses and checking them for clashes.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The largest number of courses whose exams can be checked at once
+const MAX_EXAM_CHECK_COURSES = 20
+
+// Two exam dates of different courses on the same day or on consecutive
+// days, which a student had better not both sign up for.
+type examClash struct {
+	Courses [2]string `json:"courses"`
+	Dates   [2]string `json:"dates"`
+	// "same_day" or "back_to_back"
+	Kind string `json:"kind"`
+}
+
+type examCheck struct {
+	Exams   map[string][]sisparse.Exam `json:"exams"`
+	Clashes []examClash                `json:"clashes"`
+	// For each course, how many of its dates clash with no date of the
+	// other courses; a course with none needs an exam next to another one
+	Free map[string]int `json:"free"`
+}
+
+// Exam dates are cached under "exams-<name>~<year>", where <name> is given
+// by getCourseCacheName.
+func examsCacheName(ctx context.Context, code string, sem int) string {
+	return tenantCacheName(ctx, fmt.Sprintf("exams-%s~%d", getCourseCacheName(code, sem), sisparse.AcademicYear(ctx)))
+}
+
+// Returns the exam dates of the course in the semester of the academic
+// year of ctx, from the cache if possible.
+func getExams(ctx context.Context, code string, sem int) ([]sisparse.Exam, error) {
+	name := examsCacheName(ctx, code, sem)
+	if cached, err := getCache(name); err == nil {
+		var exams []sisparse.Exam
+		if err := json.Unmarshal([]byte(cached), &exams); err == nil {
+			return exams, nil
+		}
+	}
+	exams, err := sisparse.GetExams(ctx, code, sem)
+	if err != nil {
+		return nil, err
+	}
+	s, err := json.Marshal(exams)
+	if err != nil {
+		return nil, err
+	}
+	return exams, setCache(name, string(s))
+}
+
+// Answers /exams/<code> with {"data":[exam, ...]}, the exam dates of the
+// course in the term given as in /sisquery/.
+func examsHandler(w http.ResponseWriter, r *http.Request) {
+	code := sisparse.NormalizeCourseCode(strings.TrimPrefix(r.URL.Path, "/exams/"))
+	if !sisparse.IsCourseCode(code) {
+		fmt.Fprint(w, `{"error":"Expected /exams/<code>"}`)
+		return
+	}
+	t, err := requestTerm(r)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	ctx := sisparse.WithAcademicYear(r.Context(), t.Year)
+	logf(ctx, "Exams: %s", code)
+	exams, err := getExams(ctx, code, t.Semester)
+	if err != nil {
+		logf(ctx, "Exams error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	s, _ := json.Marshal(exams)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+// Answers POST /api/v1/exams:check with a body such as
+// {"courses":["NPRG030","NTIN061"]} (the term given as in /sisquery/) with
+// {"data":examCheck}.
+func examCheckHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use POST"}`)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	var req struct {
+		Courses []string `json:"courses"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || len(req.Courses) == 0 {
+		fmt.Fprint(w, `{"error":"Expected {\"courses\":[...]}"}`)
+		return
+	}
+	if len(req.Courses) > MAX_EXAM_CHECK_COURSES {
+		fmt.Fprintf(w, `{"error":"At most %d courses can be checked at once"}`, MAX_EXAM_CHECK_COURSES)
+		return
+	}
+	t, err := requestTerm(r)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	ctx := sisparse.WithAcademicYear(r.Context(), t.Year)
+	logf(ctx, "Exam check: %s", ellipsis(strings.Join(req.Courses, ","), 30))
+
+	exams := map[string][]sisparse.Exam{}
+	for _, code := range req.Courses {
+		code = sisparse.NormalizeCourseCode(code)
+		if _, ok := exams[code]; ok {
+			continue
+		}
+		if exams[code], err = getExams(ctx, code, t.Semester); err != nil {
+			logf(ctx, "Exam check error: %s", err)
+			fmt.Fprintf(w, `{"error":"%s: %s"}`, code, err)
+			return
+		}
+	}
+	clashes, free := checkExamClashes(exams)
+	s, _ := json.Marshal(examCheck{Exams: exams, Clashes: clashes, Free: free})
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+// Returns the clashes between the exam dates of different courses, and
+// for each course the number of its dates without any clash.
+func checkExamClashes(exams map[string][]sisparse.Exam) ([]examClash, map[string]int) {
+	codes := []string{}
+	for code := range exams {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	clashes := []examClash{}
+	clashing := map[string]map[string]bool{} // By course, the dates which clash
+	for _, code := range codes {
+		clashing[code] = map[string]bool{}
+	}
+	for i, a := range codes {
+		for _, b := range codes[i+1:] {
+			for _, ea := range exams[a] {
+				for _, eb := range exams[b] {
+					kind := examClashKind(ea, eb)
+					if kind == "" {
+						continue
+					}
+					clashes = append(clashes, examClash{
+						Courses: [2]string{a, b},
+						Dates:   [2]string{ea.Date, eb.Date},
+						Kind:    kind,
+					})
+					clashing[a][ea.Date] = true
+					clashing[b][eb.Date] = true
+				}
+			}
+		}
+	}
+
+	free := map[string]int{}
+	for _, code := range codes {
+		free[code] = 0
+		for _, e := range exams[code] {
+			if !clashing[code][e.Date] {
+				free[code]++
+			}
+		}
+	}
+	return clashes, free
+}
+
+// Returns "same_day", "back_to_back" (for consecutive days) or "".
+func examClashKind(a, b sisparse.Exam) string {
+	dayA, errA := a.Day()
+	dayB, errB := b.Day()
+	if errA != nil || errB != nil {
+		return ""
+	}
+	switch days := dayB.Sub(dayA).Hours() / 24; {
+	case days == 0:
+		return "same_day"
+	case days == 1 || days == -1:
+		return "back_to_back"
+	}
+	return ""
+}
@@ -0,0 +1,223 @@
+// Calendar feeds of saved schedules: /schedules/<token>.ics is an iCalendar
+// feed which calendar apps subscribe to (as webcal://). It is made anew
+// from the current course data on each fetch, so that changes of rooms and
+// times in SIS get to the students' calendars.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/calendar"
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// How often calendar apps should fetch the feed again
+const FEED_REFRESH_INTERVAL = 6 * time.Hour
+
+const ICAL_PRODID = "-//Samorozvrh//Schedule//EN"
+
+var errNoCalendar = errors.New("No academic calendar is configured")
+
+// An event of a calendar feed, as its content lines (without BEGIN:VEVENT
+// and END:VEVENT)
+type icalEvent struct {
+	UID   string
+	Lines []string
+}
+
+// Answers GET /schedules/<token>.ics with the occurrences of the events
+// of the saved schedule in the semester of the academic calendar.
+func calendarFeedHandler(w http.ResponseWriter, r *http.Request, token string) {
+	events, err := feedEvents(r.Context(), token)
+	if err != nil {
+		switch err {
+		case errNoCalendar:
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case store.ErrNotFound:
+			http.NotFound(w, r)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	var b strings.Builder
+	writeICalLine(&b, "BEGIN:VCALENDAR")
+	writeICalLine(&b, "VERSION:2.0")
+	writeICalLine(&b, "PRODID:"+ICAL_PRODID)
+	writeICalLine(&b, "CALSCALE:GREGORIAN")
+	writeICalLine(&b, "X-WR-CALNAME:"+escapeICalText("Samorozvrh"))
+	writeICalLine(&b, "REFRESH-INTERVAL;VALUE=DURATION:"+icalDuration(FEED_REFRESH_INTERVAL))
+	writeICalLine(&b, "X-PUBLISHED-TTL:"+icalDuration(FEED_REFRESH_INTERVAL))
+	for _, e := range events {
+		writeICalEvent(&b, e)
+	}
+	writeICalLine(&b, "END:VCALENDAR")
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="schedule.ics"`)
+	fmt.Fprint(w, b.String())
+}
+
+// Returns the occurrences of the events of the saved schedule in the
+// semester of the academic calendar, made from the current course data.
+func feedEvents(ctx context.Context, token string) ([]icalEvent, error) {
+	semester := tenantOf(ctx).semester
+	if semester == nil {
+		return nil, errNoCalendar
+	}
+	s, err := db.GetSchedule(token)
+	if err != nil {
+		return nil, err
+	}
+	var req pngRequest
+	if err := json.Unmarshal([]byte(s.Data), &req); err != nil {
+		return nil, err
+	}
+	if len(req.Result) != len(req.Courses) {
+		return nil, errors.New("Invalid saved schedule")
+	}
+	_, sem := semester.Term()
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	var res []icalEvent
+	for i, c := range req.Courses {
+		if req.Result[i] == nil || *req.Result[i] < 0 || *req.Result[i] >= len(c.Options) {
+			continue
+		}
+		group := currentGroup(ctx, c.Options[*req.Result[i]])
+		col, ok := scheduleCourseColor(i, groupCourseCode(group), c.Color)
+		if !ok {
+			col = pngPalette[i%len(pngPalette)]
+		}
+		for j, e := range group {
+			if e.Semester != 0 && e.Semester != sem {
+				continue
+			}
+			e.WeekParity = resolveWeekParity(ctx, e)
+			summary := c.Name
+			if summary == "" {
+				summary = e.Name
+			}
+			if e.Type != "" {
+				summary += " (" + localize(ctx, string(e.Type)) + ")"
+			}
+			for _, o := range semester.Occurrences(e) {
+				// By the course, the event of its group and the date, so
+				// that apps update the events changed since instead of
+				// adding them again
+				uid := fmt.Sprintf("%s-%d-%d-%s@samorozvrh", token, i, j, o.From.In(calendar.Location).Format("20060102"))
+				lines := []string{
+					"UID:" + uid,
+					"DTSTAMP:" + stamp,
+					"DTSTART:" + o.From.UTC().Format("20060102T150405Z"),
+					"DTEND:" + o.To.UTC().Format("20060102T150405Z"),
+					"SUMMARY:" + escapeICalText(summary),
+				}
+				lines = append(lines, "COLOR:"+colorName(col))
+				if categories := eventCategories(ctx, e); len(categories) > 0 {
+					for k := range categories {
+						categories[k] = escapeICalText(categories[k])
+					}
+					lines = append(lines, "CATEGORIES:"+strings.Join(categories, ","))
+				}
+				if e.Room != "" {
+					lines = append(lines, "LOCATION:"+escapeICalText(e.Room))
+				}
+				if e.Teacher != "" {
+					lines = append(lines, "DESCRIPTION:"+escapeICalText(e.Teacher))
+				}
+				res = append(res, icalEvent{UID: uid, Lines: lines})
+			}
+		}
+	}
+	for i, b := range req.Reserved {
+		e, err := b.event()
+		if err != nil || (e.Semester != 0 && e.Semester != sem) {
+			continue
+		}
+		for _, o := range semester.Occurrences(e) {
+			uid := fmt.Sprintf("%s-reserved-%d-%s@samorozvrh", token, i, o.From.In(calendar.Location).Format("20060102"))
+			res = append(res, icalEvent{UID: uid, Lines: []string{
+				"UID:" + uid,
+				"DTSTAMP:" + stamp,
+				"DTSTART:" + o.From.UTC().Format("20060102T150405Z"),
+				"DTEND:" + o.To.UTC().Format("20060102T150405Z"),
+				"SUMMARY:" + escapeICalText(e.Name),
+				"COLOR:" + colorName(reservedColor),
+			}})
+		}
+	}
+	return res, nil
+}
+
+// Returns the current events of the group, as the cache (or SIS) has them
+// now, or the group as it was saved if it can't be found anymore.
+func currentGroup(ctx context.Context, saved []sisparse.Event) []sisparse.Event {
+	if len(saved) == 0 || saved[0].CourseCode == "" {
+		return saved
+	}
+	sem := saved[0].Semester
+	if sem == 0 {
+		sem = sisparse.Winter
+	}
+	res, err := queryCourse(ctx, saved[0].CourseCode, sem)
+	if err != nil {
+		logf(ctx, "Feed error for %s: %s", saved[0].CourseCode, err)
+		return saved
+	}
+	groups, err := parseCourseResponse(res)
+	if err != nil {
+		return saved
+	}
+	for _, group := range groups {
+		if len(group) > 0 && saved[0].GroupID != "" && group[0].GroupID == saved[0].GroupID {
+			return group
+		}
+	}
+	// Groups without an ID are found by their events
+	for _, group := range groups {
+		if sisparse.GroupsEqual(group, saved) {
+			return group
+		}
+	}
+	return saved
+}
+
+func writeICalEvent(b *strings.Builder, e icalEvent) {
+	writeICalLine(b, "BEGIN:VEVENT")
+	for _, line := range e.Lines {
+		writeICalLine(b, line)
+	}
+	writeICalLine(b, "END:VEVENT")
+}
+
+// Writes the content line, folded into lines of at most 75 octets, as
+// RFC 5545 requires.
+func writeICalLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// Not in the middle of a UTF-8 sequence
+		for cut > 0 && line[cut]&0xc0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// The space starting the next line counts too
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+func escapeICalText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+func icalDuration(d time.Duration) string {
+	return fmt.Sprintf("PT%dH", int(d.Hours()))
+}
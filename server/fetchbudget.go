@@ -0,0 +1,192 @@
+// Budgets of fetches from SIS. A request which resolves many courses (a
+// batch, a solve from a chat) may only fetch a few uncached ones from SIS
+// itself; the rest are queued to be fetched in the background, and the
+// request answers at once with the courses which are ready and when to
+// retry for the others. A global budget, counted over all the instances,
+// does the same when many such requests come at once.
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// Returned for courses which were queued to be fetched later
+var ErrFetchQueued = errors.New("The course is being fetched from SIS, try again later")
+
+const (
+	// How many uncached courses a request may fetch itself, see -fetch-budget
+	DEFAULT_REQUEST_FETCH_BUDGET = 8
+	// How many queued courses are fetched at the same time
+	FETCH_QUEUE_WORKERS = 2
+	// Courses queued beyond this are not fetched (and not cached) at all
+	MAX_FETCH_QUEUE = 500
+	// About how long a fetch takes, to tell clients when to retry
+	FETCH_DURATION_ESTIMATE = 2 * time.Second
+)
+
+// The fetch budget of each request which has one, and how many fetches
+// all the budgeted requests may make in a minute (0 for no limit); see
+// -fetch-budget and -global-fetch-budget
+var requestFetchBudget = DEFAULT_REQUEST_FETCH_BUDGET
+var globalFetchBudget = 0
+
+type fetchBudgetKey struct{}
+
+type fetchBudget struct {
+	mu   sync.Mutex
+	left int
+}
+
+// Gives the request of ctx the budget of -fetch-budget fetches from SIS;
+// without one, requests fetch all the courses they need.
+func withFetchBudget(ctx context.Context) context.Context {
+	if requestFetchBudget <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, fetchBudgetKey{}, &fetchBudget{left: requestFetchBudget})
+}
+
+// Takes a fetch out of the budget of the request and the global one, and
+// returns whether the request may fetch a course.
+func takeFetch(ctx context.Context) bool {
+	b, ok := ctx.Value(fetchBudgetKey{}).(*fetchBudget)
+	if !ok {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.left <= 0 {
+		return false
+	}
+	if globalFetchBudget > 0 {
+		minute := time.Now().Unix() / 60
+		n, err := db.IncrementCounter("fetch-budget", minute)
+		if err != nil {
+			log.Printf("Could not check the fetch budget: %s", err)
+		} else if n > globalFetchBudget {
+			return false
+		}
+	}
+	b.left--
+	return true
+}
+
+// Whether the request of ctx has a fetch budget, so that it may get
+// ErrFetchQueued.
+func hasFetchBudget(ctx context.Context) bool {
+	_, ok := ctx.Value(fetchBudgetKey{}).(*fetchBudget)
+	return ok
+}
+
+type queuedFetch struct {
+	ctx  context.Context
+	code string
+	sem  int
+	name string
+}
+
+// Courses waiting to be fetched, in the order they were queued: those over
+// the fetch budgets, and cached ones refreshed ahead (see getCachedCourse).
+type fetchQueue struct {
+	mu      sync.Mutex
+	queue   []queuedFetch
+	pending map[string]bool
+	wake    chan struct{}
+}
+
+var fetches = &fetchQueue{pending: map[string]bool{}, wake: make(chan struct{}, 1)}
+
+// Queues the course for fetching (once, however many requests want it) in
+// the tenant and academic year of ctx.
+func (q *fetchQueue) add(ctx context.Context, code string, sem int) {
+	name := courseCacheName(ctx, code, sem)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending[name] || len(q.queue) >= MAX_FETCH_QUEUE {
+		return
+	}
+	fetchCtx := withTenant(context.Background(), tenantOf(ctx))
+	fetchCtx = sisparse.WithAcademicYear(fetchCtx, sisparse.AcademicYear(ctx))
+	q.queue = append(q.queue, queuedFetch{ctx: fetchCtx, code: code, sem: sem, name: name})
+	q.pending[name] = true
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *fetchQueue) isPending(ctx context.Context, code string, sem int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending[courseCacheName(ctx, code, sem)]
+}
+
+// About when all the queued courses will have been fetched.
+func (q *fetchQueue) retryAfter() time.Duration {
+	q.mu.Lock()
+	n := len(q.pending)
+	q.mu.Unlock()
+	rounds := (n + FETCH_QUEUE_WORKERS - 1) / FETCH_QUEUE_WORKERS
+	if rounds < 1 {
+		rounds = 1
+	}
+	return time.Duration(rounds) * FETCH_DURATION_ESTIMATE
+}
+
+// Fetches the queued courses until ctx is done.
+func (q *fetchQueue) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < FETCH_QUEUE_WORKERS; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				f, ok := q.next()
+				if !ok {
+					select {
+					case <-ctx.Done():
+						return
+					case <-q.wake:
+						continue
+					case <-time.After(time.Minute):
+						continue
+					}
+				}
+				if _, err := fetchCourse(f.ctx, f.code, f.sem); err != nil {
+					log.Printf("Could not fetch queued %s: %s", f.code, err)
+				}
+				q.mu.Lock()
+				delete(q.pending, f.name)
+				q.mu.Unlock()
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *fetchQueue) next() (queuedFetch, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.queue) == 0 {
+		return queuedFetch{}, false
+	}
+	f := q.queue[0]
+	q.queue = q.queue[1:]
+	if len(q.queue) > 0 {
+		// For the other workers
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return f, true
+}
@@ -0,0 +1,222 @@
+// How full the groups of courses get over time: a background job samples
+// the enrollment of the cached courses now and then, so that students can
+// see which parallels fill up (and the solver can avoid them).
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// How full a group of a course has been over the samples taken of it.
+type groupFill struct {
+	Samples int `json:"samples"`
+	// Enrolled / capacity, the average one and the last one sampled
+	AverageFill float64 `json:"average_fill"`
+	LastFill    float64 `json:"last_fill"`
+	// The share of the samples in which the group was full
+	FullShare float64 `json:"full_share"`
+	// When the group was first sampled full, if ever
+	FirstFull *time.Time `json:"first_full,omitempty"`
+}
+
+// Samples the courses of the current academic year cached for each tenant
+// every interval, until ctx is done.
+func sampleFillRates(ctx context.Context, interval time.Duration) {
+	for {
+		for _, t := range append([]*tenant{defaultTenant}, tenants...) {
+			if err := sampleTenantFillRates(withTenant(ctx, t), interval); err != nil {
+				log.Printf("Fill sampling stopped: %s", err)
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Fetches the cached courses of the tenant of ctx from SIS again (updating
+// the cache) and records how full their groups are. Courses crawled by any
+// instance in the last half of the interval are skipped, and the tenant is
+// skipped while another instance holds its crawl lease (see crawler.go).
+// Returns an error if sampling can't go on now, e.g. outside the crawl
+// window.
+func sampleTenantFillRates(ctx context.Context, interval time.Duration) error {
+	lease := crawlLeaseName(ctx)
+	if ok, err := db.AcquireLease(lease, instanceId, CRAWL_LEASE_TTL); err != nil || !ok {
+		return err
+	}
+	defer db.ReleaseLease(lease, instanceId)
+	names, err := listTenantCache(ctx)
+	if err != nil {
+		return err
+	}
+	crawled, err := db.ListCrawled(tenantCacheName(ctx, ""))
+	if err != nil {
+		return err
+	}
+	ctx = sisparse.WithBackground(sisparse.WithAcademicYear(ctx, currentTerm(ctx).Year))
+	for _, name := range names {
+		// Renewed for each course, even one skipped or failed, so that it
+		// expires soon if we die
+		if ok, err := db.AcquireLease(lease, instanceId, CRAWL_LEASE_TTL); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("Another instance took over crawling at %s", name)
+		}
+		if !isCourseCacheName(name) || strings.Contains(name, "~") {
+			continue
+		}
+		if time.Since(crawled[tenantCacheName(ctx, name)]) < interval/2 {
+			continue
+		}
+		code, sem := splitCourseCacheName(name)
+		res, err := doFetchCourse(ctx, code, sem)
+		if errors.Is(err, ErrOutsideCrawlWindow) || errors.Is(err, sisparse.ErrCircuitOpen) ||
+			errors.Is(err, sisparse.ErrPaused) || ctx.Err() != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if err != nil {
+			log.Printf("Fill sampling: skipping %s: %s", name, err)
+			continue
+		}
+		if err := db.SetCrawled(tenantCacheName(ctx, name), time.Now()); err != nil {
+			return err
+		}
+		var fetched struct {
+			Data [][]sisparse.Event `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(res), &fetched); err != nil {
+			continue
+		}
+		if err := db.AddFillSamples(fillSamples(tenantCacheName(ctx, name), fetched.Data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns a sample of each group with a known capacity. A group is as full
+// as its fullest event.
+func fillSamples(course string, groups [][]sisparse.Event) []store.FillSample {
+	res := []store.FillSample{}
+	for _, g := range groups {
+		if len(g) == 0 {
+			continue
+		}
+		var fullest *sisparse.Event
+		for i := range g {
+			e := &g[i]
+			if e.Capacity > 0 && (fullest == nil || e.Enrolled*fullest.Capacity > fullest.Enrolled*e.Capacity) {
+				fullest = e
+			}
+		}
+		if fullest != nil {
+			res = append(res, store.FillSample{Course: course, GroupID: g[0].GroupID, Enrolled: fullest.Enrolled, Capacity: fullest.Capacity})
+		}
+	}
+	return res
+}
+
+// Returns how full the groups of the course have been, by group id.
+func groupFills(ctx context.Context, code string, sem int) (map[string]*groupFill, error) {
+	samples, err := db.ListFillSamples(tenantCacheName(ctx, getCourseCacheName(code, sem)))
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]*groupFill{}
+	for _, s := range samples {
+		f, ok := res[s.GroupID]
+		if !ok {
+			f = &groupFill{}
+			res[s.GroupID] = f
+		}
+		fill := float64(s.Enrolled) / float64(s.Capacity)
+		f.AverageFill = (f.AverageFill*float64(f.Samples) + fill) / float64(f.Samples+1)
+		f.FullShare = f.FullShare * float64(f.Samples)
+		if s.Enrolled >= s.Capacity {
+			f.FullShare++
+			if f.FirstFull == nil {
+				sampled := s.Sampled
+				f.FirstFull = &sampled
+			}
+		}
+		f.Samples++
+		f.FullShare /= float64(f.Samples)
+		f.LastFill = fill
+	}
+	return res, nil
+}
+
+// Adds weight times the share of the samples in which the option's group
+// was full to the penalty of each option of the courses.
+func addFillPenalties(ctx context.Context, courses []solveCourse, weight int) error {
+	for i := range courses {
+		c := &courses[i]
+		if c.CourseCode == "" {
+			continue
+		}
+		sem := c.Semester
+		if sem == 0 {
+			sem = currentTerm(ctx).Semester
+		}
+		fills, err := groupFills(ctx, c.CourseCode, sem)
+		if err != nil {
+			return err
+		}
+		if len(fills) == 0 {
+			continue
+		}
+		var options [][]sisparse.Event
+		if err := json.Unmarshal(c.Options, &options); err != nil {
+			return fmt.Errorf("Invalid options of %s: %s", c.Name, err)
+		}
+		penalties := make([]int, len(options))
+		copy(penalties, c.OptionPenalties)
+		for j, option := range options {
+			if len(option) == 0 {
+				continue
+			}
+			if f, ok := fills[option[0].GroupID]; ok {
+				penalties[j] += int(float64(weight)*f.FullShare + 0.5)
+			}
+		}
+		c.OptionPenalties = penalties
+	}
+	return nil
+}
+
+// Answers /fillrates/<code> (with the term given as in /sisquery/) with
+// {"data":{"<group id>":groupFill, ...}}.
+func fillRatesHandler(w http.ResponseWriter, r *http.Request) {
+	code := sisparse.NormalizeCourseCode(strings.TrimPrefix(r.URL.Path, "/fillrates/"))
+	if !sisparse.IsCourseCode(code) {
+		fmt.Fprint(w, `{"error":"Expected /fillrates/<code>"}`)
+		return
+	}
+	t, err := requestTerm(r)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	fills, err := groupFills(r.Context(), code, t.Semester)
+	if err != nil {
+		logf(r.Context(), "Fill rates error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	s, _ := json.Marshal(fills)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
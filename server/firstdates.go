@@ -0,0 +1,56 @@
+// The dates on which the events of a schedule first take place, so that
+// students know when each of their classes starts meeting.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/iamwave/samorozvrh/calendar"
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The first occurrence of an event by the academic calendar
+type firstOccurrence struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	From string `json:"from"` // RFC 3339, in Prague time
+	Week int    `json:"week"` // The teaching week
+}
+
+// Returns the first occurrences of the events of the option selected for
+// each course (null for courses without one), nil if the tenant of ctx
+// has no academic calendar. Events of another semester than that of the
+// calendar, or which never take place, have null.
+func firstOccurrences(ctx context.Context, courses []solveCourse, data []*int) [][]*firstOccurrence {
+	semester := tenantOf(ctx).semester
+	if semester == nil || len(data) != len(courses) {
+		return nil
+	}
+	_, sem := semester.Term()
+	res := make([][]*firstOccurrence, len(courses))
+	for i, c := range courses {
+		if data[i] == nil {
+			continue
+		}
+		var options [][]sisparse.Event
+		if err := json.Unmarshal(c.Options, &options); err != nil || *data[i] < 0 || *data[i] >= len(options) {
+			continue
+		}
+		res[i] = []*firstOccurrence{}
+		for _, e := range options[*data[i]] {
+			var first *firstOccurrence
+			if e.Semester == 0 || e.Semester == sem {
+				if o, ok := semester.FirstOccurrence(e); ok {
+					first = &firstOccurrence{
+						Date: o.From.Format("2006-01-02"),
+						From: o.From.In(calendar.Location).Format(time.RFC3339),
+						Week: o.Week,
+					}
+				}
+			}
+			res[i] = append(res[i], first)
+		}
+	}
+	return res
+}
@@ -0,0 +1,283 @@
+// Finding when several people are free at the same time every week, e.g.
+// for a study group or thesis consultations, from their saved schedules
+// (see schedules.go) or from calendars exported from elsewhere.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/calendar"
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// The most schedules and calendars of one request
+const MAX_FREE_TIME_PARTICIPANTS = 20
+
+// The largest request, with the calendars
+const MAX_FREE_TIME_REQUEST_SIZE = 4 << 20
+
+// E.g. {"schedules": ["3f2a..."], "calendars": ["BEGIN:VCALENDAR..."],
+// "min_minutes": 60, "from": "08:00", "to": "20:00", "days": [0, 1, 2, 3, 4]}
+type freeTimeRequest struct {
+	// Tokens of saved schedules
+	Schedules []string `json:"schedules"`
+	// iCalendar files, see icalWeeklyEvents
+	Calendars []string `json:"calendars"`
+	// The shortest window, 60 minutes by default
+	MinMinutes int `json:"min_minutes"`
+	// The part of the day to look in, 08:00 to 20:00 by default
+	From *sisparse.ClockTime `json:"from"`
+	To   *sisparse.ClockTime `json:"to"`
+	// Monday = 0, Monday to Friday by default
+	Days []int `json:"days"`
+	// Only events of the semester count, the current one by default
+	Semester int `json:"semester"`
+}
+
+// A time when everyone is free, every week, or only in odd or even
+// (teaching) weeks when someone has a biweekly event
+type freeWindow struct {
+	Day        int                `json:"day"`
+	TimeFrom   sisparse.ClockTime `json:"time_from"`
+	TimeTo     sisparse.ClockTime `json:"time_to"`
+	Minutes    int                `json:"minutes"`
+	WeekParity string             `json:"week_parity"`
+}
+
+// Answers POST /api/v1/schedules:free with a freeTimeRequest with
+// {"data":{"windows":[freeWindow]}}.
+func freeTimeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use POST"}`)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAX_FREE_TIME_REQUEST_SIZE))
+	r.Body.Close()
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	req := freeTimeRequest{MinMinutes: 60, Days: []int{0, 1, 2, 3, 4}}
+	if err := json.Unmarshal(body, &req); err != nil {
+		fmt.Fprint(w, `{"error":"Expected {\"schedules\":[...],\"calendars\":[...],\"min_minutes\":60}"}`)
+		return
+	}
+	windows, err := findFreeTime(r, req)
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	s, _ := json.Marshal(map[string]interface{}{"windows": windows})
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func findFreeTime(r *http.Request, req freeTimeRequest) ([]freeWindow, error) {
+	from, to := sisparse.NewClockTime(8, 0), sisparse.NewClockTime(20, 0)
+	if req.From != nil {
+		from = *req.From
+	}
+	if req.To != nil {
+		to = *req.To
+	}
+	switch {
+	case len(req.Schedules)+len(req.Calendars) == 0:
+		return nil, fmt.Errorf("Expected some schedules or calendars")
+	case len(req.Schedules)+len(req.Calendars) > MAX_FREE_TIME_PARTICIPANTS:
+		return nil, fmt.Errorf("At most %d schedules and calendars can be compared", MAX_FREE_TIME_PARTICIPANTS)
+	case req.MinMinutes < 1:
+		return nil, fmt.Errorf("The windows must be at least a minute long")
+	case from >= to || to.Minutes() > 24*60:
+		return nil, fmt.Errorf("Expected from before to")
+	}
+	for _, day := range req.Days {
+		if day < 0 || day > 6 {
+			return nil, fmt.Errorf("Invalid day %d, expected 0 (Monday) to 6", day)
+		}
+	}
+	if req.Semester == 0 {
+		req.Semester = currentTerm(r.Context()).Semester
+	}
+
+	events := []sisparse.Event{}
+	for _, token := range req.Schedules {
+		s, err := db.GetSchedule(token)
+		if err == store.ErrNotFound {
+			return nil, fmt.Errorf("No schedule %s", token)
+		} else if err != nil {
+			return nil, err
+		}
+		var saved pngRequest
+		if err := json.Unmarshal([]byte(s.Data), &saved); err != nil {
+			return nil, err
+		}
+		for i, c := range saved.Courses {
+			if i >= len(saved.Result) || saved.Result[i] == nil || *saved.Result[i] < 0 || *saved.Result[i] >= len(c.Options) {
+				continue
+			}
+			for _, e := range c.Options[*saved.Result[i]] {
+				if e.Semester == 0 || e.Semester == req.Semester {
+					e.WeekParity = resolveWeekParity(r.Context(), e)
+					events = append(events, e)
+				}
+			}
+		}
+	}
+	for i, ics := range req.Calendars {
+		e, err := icalWeeklyEvents(ics)
+		if err != nil {
+			return nil, fmt.Errorf("Calendar %d: %s", i+1, err)
+		}
+		events = append(events, e...)
+	}
+	return freeWindows(events, req.Days, from, to, req.MinMinutes), nil
+}
+
+// Returns the windows of at least minMinutes between from and to on the
+// days when none of the events takes place: first those free every week,
+// then those free only in odd or only in even weeks.
+func freeWindows(events []sisparse.Event, days []int, from, to sisparse.ClockTime, minMinutes int) []freeWindow {
+	// For each day, the minutes taken in odd and in even weeks
+	var busy [7][2][24 * 60]bool
+	for _, e := range events {
+		for parity, weeks := range []sisparse.WeekParity{sisparse.OddWeeks, sisparse.EvenWeeks} {
+			if !sameWeeks(e.WeekParity, weeks) || e.Day < 0 || e.Day > 6 {
+				continue
+			}
+			for m := e.TimeFrom.Minutes(); m < e.TimeTo.Minutes() && m < 24*60; m++ {
+				busy[e.Day][parity][m] = true
+			}
+		}
+	}
+	windows := []freeWindow{}
+	for _, parity := range []string{"every", "odd", "even"} {
+		for _, day := range days {
+			free := func(m int) bool {
+				odd, even := !busy[day][0][m], !busy[day][1][m]
+				switch parity {
+				case "odd":
+					return odd
+				case "even":
+					return even
+				}
+				return odd && even
+			}
+			start := -1
+			for m := from.Minutes(); m <= to.Minutes(); m++ {
+				if m < to.Minutes() && free(m) {
+					if start < 0 {
+						start = m
+					}
+					continue
+				}
+				if start >= 0 && m-start >= minMinutes && (parity == "every" || !freeEveryWeek(busy[day], start, m)) {
+					windows = append(windows, freeWindow{Day: day, TimeFrom: sisparse.ClockTime(start),
+						TimeTo: sisparse.ClockTime(m), Minutes: m - start, WeekParity: parity})
+				}
+				start = -1
+			}
+		}
+	}
+	return windows
+}
+
+// Whether the minutes from start to end are free in all weeks, so that a
+// window of odd or even weeks would only repeat one of every week.
+func freeEveryWeek(busy [2][24 * 60]bool, start, end int) bool {
+	for m := start; m < end; m++ {
+		if busy[0][m] || busy[1][m] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the events of an iCalendar file which repeat every week: those
+// with a weekly RRULE, and those taking place at the same time of the
+// same weekday in at least two weeks (as in the feeds of saved schedules,
+// see feed.go). One-off events don't take up a weekly slot. Biweekly
+// events are taken as taking place every week.
+func icalWeeklyEvents(ics string) ([]sisparse.Event, error) {
+	ics = strings.NewReplacer("\r\n ", "", "\r\n\t", "", "\n ", "", "\n\t", "").Replace(ics)
+	if !strings.Contains(ics, "BEGIN:VCALENDAR") {
+		return nil, fmt.Errorf("Not an iCalendar file")
+	}
+	type slot struct {
+		day      int
+		from, to sisparse.ClockTime
+	}
+	weeks := map[slot]map[string]bool{}
+	added := map[slot]bool{}
+	res := []sisparse.Event{}
+	var start, end time.Time
+	weekly, inEvent := false, false
+	for _, line := range strings.Split(ics, "\n") {
+		line = strings.TrimRight(line, "\r")
+		name, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			name, value = line[:i], line[i+1:]
+		}
+		params := ""
+		if i := strings.Index(name, ";"); i >= 0 {
+			name, params = name[:i], name[i+1:]
+		}
+		switch {
+		case line == "BEGIN:VEVENT":
+			inEvent, weekly, start, end = true, false, time.Time{}, time.Time{}
+		case !inEvent:
+		case name == "DTSTART":
+			start = parseICalTime(value, params)
+		case name == "DTEND":
+			end = parseICalTime(value, params)
+		case name == "RRULE":
+			weekly = strings.Contains(value, "FREQ=WEEKLY")
+		case line == "END:VEVENT":
+			inEvent = false
+			if start.IsZero() || end.IsZero() || !end.After(start) {
+				continue
+			}
+			start, end = start.In(calendar.Location), end.In(calendar.Location)
+			s := slot{day: (int(start.Weekday()) + 6) % 7, from: sisparse.NewClockTime(start.Hour(), start.Minute())}
+			s.to = sisparse.NewClockTime(end.Hour(), end.Minute())
+			if end.YearDay() != start.YearDay() || end.Year() != start.Year() {
+				s.to = sisparse.NewClockTime(24, 0)
+			}
+			year, week := start.ISOWeek()
+			if weeks[s] == nil {
+				weeks[s] = map[string]bool{}
+			}
+			weeks[s][fmt.Sprintf("%d-%d", year, week)] = true
+			if (weekly || len(weeks[s]) >= 2) && !added[s] {
+				added[s] = true
+				res = append(res, sisparse.Event{Day: s.day, TimeFrom: s.from, TimeTo: s.to})
+			}
+		}
+	}
+	return res, nil
+}
+
+// Parses a DATE-TIME of iCalendar, in UTC ("...Z"), in the time zone of
+// TZID or in local time (taken as that of the academic calendar); zero for
+// dates, which are of whole-day events.
+func parseICalTime(value, params string) time.Time {
+	loc := calendar.Location
+	for _, p := range strings.Split(params, ";") {
+		if strings.HasPrefix(p, "TZID=") {
+			if l, err := time.LoadLocation(strings.Trim(p[len("TZID="):], `"`)); err == nil {
+				loc = l
+			}
+		}
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, _ := time.Parse("20060102T150405Z", value)
+		return t
+	}
+	t, _ := time.ParseInLocation("20060102T150405", value, loc)
+	return t
+}
@@ -0,0 +1,108 @@
+// Scheduling with friends: a solve request may keep some courses in the
+// groups chosen in a friend's saved schedule (see schedules.go), so that
+// friends get into the same seminars while the rest of each schedule is
+// solved on its own.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// The most courses which can follow a friend's schedule
+const MAX_FRIEND_COURSES = 20
+
+// "friend" of solveRequest, e.g. {"schedule": "3f2a...", "courses":
+// ["NPRG030", "NMAI054"]}
+type solveFriend struct {
+	// The token of the friend's saved schedule
+	Schedule string `json:"schedule"`
+	// The codes of the courses to take in the friend's groups
+	Courses []string `json:"courses"`
+}
+
+// Locks the courses of the friend's schedule to the options with the
+// groups the friend has, as if the request had such "locks".
+func (r *solveRequest) applyFriend() error {
+	if len(r.Friend.Courses) > MAX_FRIEND_COURSES {
+		return fmt.Errorf("At most %d courses can follow a friend's schedule", MAX_FRIEND_COURSES)
+	}
+	s, err := db.GetSchedule(r.Friend.Schedule)
+	if err == store.ErrNotFound {
+		return fmt.Errorf("No schedule %s", r.Friend.Schedule)
+	} else if err != nil {
+		return err
+	}
+	var friend pngRequest
+	if err := json.Unmarshal([]byte(s.Data), &friend); err != nil {
+		return err
+	}
+	if r.Locks == nil {
+		r.Locks = make([]*int, len(r.Courses))
+	}
+	for _, code := range r.Friend.Courses {
+		// The groups of the course the friend is in
+		groups := map[string]bool{}
+		for i, c := range friend.Courses {
+			if i >= len(friend.Result) || friend.Result[i] == nil || *friend.Result[i] < 0 || *friend.Result[i] >= len(c.Options) {
+				continue
+			}
+			for _, e := range c.Options[*friend.Result[i]] {
+				if e.CourseCode == code && e.GroupID != "" {
+					groups[e.GroupID] = true
+				}
+			}
+		}
+		if len(groups) == 0 {
+			return fmt.Errorf("The friend's schedule has no groups of %s", code)
+		}
+		found := false
+		for i, c := range r.Courses {
+			var options [][]sisparse.Event
+			if err := json.Unmarshal(c.Options, &options); err != nil {
+				return err
+			}
+			if !hasCourseCode(c, options, code) {
+				continue
+			}
+			found = true
+			option := -1
+			for j, o := range options {
+				if len(o) > 0 && groups[o[0].GroupID] {
+					option = j
+					break
+				}
+			}
+			if option == -1 {
+				return fmt.Errorf("%s has none of the groups of the friend's schedule", c.Name)
+			}
+			if r.Locks[i] != nil && *r.Locks[i] != option {
+				return fmt.Errorf("%s is locked to another group than the friend's", c.Name)
+			}
+			r.Locks[i] = &option
+		}
+		if !found {
+			return fmt.Errorf("%s is not among the courses", code)
+		}
+	}
+	return nil
+}
+
+// Whether the course of a solve request has the code, either as its
+// "course_code" or in its events.
+func hasCourseCode(c solveCourse, options [][]sisparse.Event, code string) bool {
+	if c.CourseCode != "" {
+		return c.CourseCode == code
+	}
+	for _, o := range options {
+		for _, e := range o {
+			if e.CourseCode == code {
+				return true
+			}
+		}
+	}
+	return false
+}
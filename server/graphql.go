@@ -0,0 +1,484 @@
+// A GraphQL endpoint over the same data as the REST API, so that clients
+// can get courses with just the parts of them they need, and solve their
+// schedules, in a single request.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The names of the fields are those of the JSON of the REST API
+// (course_code, time_from, ...), so that clients can handle the data
+// of both the same way.
+
+// A course as resolved by the schema
+type gqlCourse struct {
+	Code   string
+	Term   term
+	Groups [][]sisparse.Event
+}
+
+type gqlTeacher struct {
+	ID   string
+	Name string
+}
+
+// A room of a course, with the course's events in it
+type gqlRoom struct {
+	Name   string
+	Events []sisparse.Event
+}
+
+// Fields of the other types are resolved by graphql-go from the Go fields
+// of the same names (or JSON names)
+
+func eventField(t graphql.Output, f func(sisparse.Event) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: t,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return f(p.Source.(sisparse.Event)), nil
+		},
+	}
+}
+
+func groupField(t graphql.Output, f func([]sisparse.Event) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: t,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return f(p.Source.([]sisparse.Event)), nil
+		},
+	}
+}
+
+func courseField(t graphql.Output, f func(*gqlCourse) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Type: t,
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return f(p.Source.(*gqlCourse)), nil
+		},
+	}
+}
+
+var gqlTeacherType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Teacher",
+	Fields: graphql.Fields{
+		"id":   {Type: graphql.String},
+		"name": {Type: graphql.String},
+		// Only those known from the cached courses
+		"events": {
+			Type: graphql.NewList(gqlEventType),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return teacherEvents(p.Context, p.Source.(gqlTeacher).ID), nil
+			},
+		},
+		"courses": {
+			Type: graphql.NewList(graphql.String),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return tenantOf(p.Context).index.coursesOfTeacher(p.Source.(gqlTeacher).ID), nil
+			},
+		},
+	},
+})
+
+var gqlEventType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Event",
+	Fields: graphql.Fields{
+		"course_code":     eventField(graphql.String, func(e sisparse.Event) interface{} { return e.CourseCode }),
+		"code":            eventField(graphql.String, func(e sisparse.Event) interface{} { return e.Code }),
+		"group_id":        eventField(graphql.String, func(e sisparse.Event) interface{} { return e.GroupID }),
+		"semester":        eventField(graphql.Int, func(e sisparse.Event) interface{} { return e.Semester }),
+		"type":            eventField(graphql.String, func(e sisparse.Event) interface{} { return string(e.Type) }),
+		"name":            eventField(graphql.String, func(e sisparse.Event) interface{} { return e.Name }),
+		"teacher":         eventField(graphql.String, func(e sisparse.Event) interface{} { return e.Teacher }),
+		"teacher_id":      eventField(graphql.String, func(e sisparse.Event) interface{} { return e.TeacherID }),
+		"day":             eventField(graphql.Int, func(e sisparse.Event) interface{} { return e.Day }),
+		"time_from":       eventField(graphql.String, func(e sisparse.Event) interface{} { return e.TimeFrom.String() }),
+		"time_to":         eventField(graphql.String, func(e sisparse.Event) interface{} { return e.TimeTo.String() }),
+		"week_parity":     eventField(graphql.String, func(e sisparse.Event) interface{} { return e.WeekParity.String() }),
+		"calendar_parity": eventField(graphql.String, func(e sisparse.Event) interface{} { return e.CalendarParity.String() }),
+		"weeks":           eventField(graphql.NewList(graphql.Int), func(e sisparse.Event) interface{} { return e.Weeks }),
+		"room":            eventField(graphql.String, func(e sisparse.Event) interface{} { return e.Room }),
+		"capacity":        eventField(graphql.Int, func(e sisparse.Event) interface{} { return e.Capacity }),
+		"enrolled":        eventField(graphql.Int, func(e sisparse.Event) interface{} { return e.Enrolled }),
+		"full":            eventField(graphql.Boolean, func(e sisparse.Event) interface{} { return e.IsFull() }),
+		"note":            eventField(graphql.String, func(e sisparse.Event) interface{} { return e.Note }),
+		"language":        eventField(graphql.String, func(e sisparse.Event) interface{} { return e.Language }),
+		"optional":        eventField(graphql.Boolean, func(e sisparse.Event) interface{} { return e.Optional }),
+		"patched":         eventField(graphql.Boolean, func(e sisparse.Event) interface{} { return e.Patched }),
+		"hash":            eventField(graphql.String, func(e sisparse.Event) interface{} { return e.Hash() }),
+	},
+})
+
+var gqlGroupType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Group",
+	Fields: graphql.Fields{
+		"id":       groupField(graphql.String, func(g []sisparse.Event) interface{} { return g[0].GroupID }),
+		"type":     groupField(graphql.String, func(g []sisparse.Event) interface{} { return string(g[0].Type) }),
+		"optional": groupField(graphql.Boolean, func(g []sisparse.Event) interface{} { return g[0].Optional }),
+		"full": groupField(graphql.Boolean, func(g []sisparse.Event) interface{} {
+			for _, e := range g {
+				if e.IsFull() {
+					return true
+				}
+			}
+			return false
+		}),
+		"events": groupField(graphql.NewList(gqlEventType), func(g []sisparse.Event) interface{} { return g }),
+	},
+})
+
+var gqlRoomType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Room",
+	Fields: graphql.Fields{
+		"name":   {Type: graphql.String},
+		"events": {Type: graphql.NewList(gqlEventType)},
+	},
+})
+
+var gqlCourseType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Course",
+	Fields: graphql.Fields{
+		"code":          courseField(graphql.String, func(c *gqlCourse) interface{} { return c.Code }),
+		"academic_year": courseField(graphql.Int, func(c *gqlCourse) interface{} { return c.Term.Year }),
+		"semester":      courseField(graphql.Int, func(c *gqlCourse) interface{} { return c.Term.Semester }),
+		"name": courseField(graphql.String, func(c *gqlCourse) interface{} {
+			return sisparse.NewCourse(c.Code, c.Term.Semester, c.Groups).Name
+		}),
+		"components": courseField(graphql.NewList(graphql.String), func(c *gqlCourse) interface{} {
+			res := []string{}
+			for _, t := range sisparse.NewCourse(c.Code, c.Term.Semester, c.Groups).Components() {
+				res = append(res, string(t))
+			}
+			return res
+		}),
+		"seminar_optional": courseField(graphql.Boolean, func(c *gqlCourse) interface{} {
+			return sisparse.NewCourse(c.Code, c.Term.Semester, c.Groups).SeminarOptional
+		}),
+		"groups": {
+			Type: graphql.NewList(gqlGroupType),
+			Args: graphql.FieldConfigArgument{
+				"type": &graphql.ArgumentConfig{Type: graphql.String, Description: `Only the groups of the type, "lecture" or "seminar"`},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				t, _ := p.Args["type"].(string)
+				res := [][]sisparse.Event{}
+				for _, g := range p.Source.(*gqlCourse).Groups {
+					if len(g) > 0 && (t == "" || string(g[0].Type) == t) {
+						res = append(res, g)
+					}
+				}
+				return res, nil
+			},
+		},
+		"teachers": courseField(graphql.NewList(gqlTeacherType), func(c *gqlCourse) interface{} {
+			res := []gqlTeacher{}
+			seen := map[gqlTeacher]bool{}
+			for _, g := range c.Groups {
+				for _, e := range g {
+					t := gqlTeacher{ID: e.TeacherID, Name: e.Teacher}
+					if t.Name != "" && !seen[t] {
+						seen[t] = true
+						res = append(res, t)
+					}
+				}
+			}
+			return res
+		}),
+		"rooms": courseField(graphql.NewList(gqlRoomType), func(c *gqlCourse) interface{} {
+			index := map[string]int{}
+			res := []gqlRoom{}
+			for _, g := range c.Groups {
+				for _, e := range g {
+					if e.Room == "" {
+						continue
+					}
+					i, ok := index[e.Room]
+					if !ok {
+						i = len(res)
+						index[e.Room] = i
+						res = append(res, gqlRoom{Name: e.Room})
+					}
+					res[i].Events = append(res[i].Events, e)
+				}
+			}
+			sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+			return res
+		}),
+	},
+})
+
+var gqlCourseSummaryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "CourseSummary",
+	Fields: graphql.Fields{
+		"code":        {Type: graphql.String},
+		"name":        {Type: graphql.String},
+		"teachers":    {Type: graphql.NewList(graphql.String)},
+		"teacher_ids": {Type: graphql.NewList(graphql.String)},
+	},
+})
+
+var gqlTermType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Term",
+	Fields: graphql.Fields{
+		"academic_year": {Type: graphql.Int},
+		"semester":      {Type: graphql.Int},
+	},
+})
+
+// The arguments selecting the term of courses, as ?year= and ?semester=
+var gqlTermArgs = graphql.FieldConfigArgument{
+	"semester": &graphql.ArgumentConfig{Type: graphql.Int, Description: "1 for winter, 2 for summer; the current semester by default"},
+	"year":     &graphql.ArgumentConfig{Type: graphql.Int, Description: "The academic year; the current one by default"},
+}
+
+// Returns the term given by the arguments (see gqlTermArgs).
+func gqlTerm(ctx context.Context, args map[string]interface{}) (term, error) {
+	t := currentTerm(ctx)
+	if sem, ok := args["semester"].(int); ok {
+		if sem != sisparse.Winter && sem != sisparse.Summer {
+			return t, fmt.Errorf("Invalid semester: %d", sem)
+		}
+		t.Semester = sem
+	}
+	if year, ok := args["year"].(int); ok {
+		if !isValidYear(ctx, year) {
+			return t, fmt.Errorf("Invalid year: %d", year)
+		}
+		t.Year = year
+	}
+	return t, nil
+}
+
+// Parses an answer made by queryCourse (the error of an erroneous one
+// is returned as such).
+func gqlCourseFromAnswer(code string, t term, res string) (*gqlCourse, error) {
+	var parsed struct {
+		Data  [][]sisparse.Event `json:"data"`
+		Error string             `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(res), &parsed); err != nil {
+		return nil, err
+	}
+	if parsed.Error != "" {
+		return nil, fmt.Errorf("%s: %s", code, parsed.Error)
+	}
+	return &gqlCourse{Code: code, Term: t, Groups: parsed.Data}, nil
+}
+
+var gqlQueryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"term": {
+			Type: gqlTermType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return currentTerm(p.Context), nil
+			},
+		},
+		"course": {
+			Type: gqlCourseType,
+			Args: withArgs(gqlTermArgs, graphql.FieldConfigArgument{
+				"code": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			}),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				t, err := gqlTerm(p.Context, p.Args)
+				if err != nil {
+					return nil, err
+				}
+				code := sisparse.NormalizeCourseCode(p.Args["code"].(string))
+				if !sisparse.IsCourseCode(code) {
+					return nil, fmt.Errorf("Invalid course code")
+				}
+				ctx := sisparse.WithAcademicYear(p.Context, t.Year)
+				res, err := queryCourse(ctx, code, t.Semester)
+				if err != nil {
+					return nil, err
+				}
+				return gqlCourseFromAnswer(code, t, res)
+			},
+		},
+		// Like course, but for several courses queried concurrently
+		"courses": {
+			Type: graphql.NewList(gqlCourseType),
+			Args: withArgs(gqlTermArgs, graphql.FieldConfigArgument{
+				"codes": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+			}),
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				t, err := gqlTerm(p.Context, p.Args)
+				if err != nil {
+					return nil, err
+				}
+				codes := []string{}
+				for _, c := range p.Args["codes"].([]interface{}) {
+					codes = append(codes, sisparse.NormalizeCourseCode(c.(string)))
+				}
+				if len(codes) > MAX_BATCH_SIZE {
+					return nil, fmt.Errorf("At most %d courses can be requested at once", MAX_BATCH_SIZE)
+				}
+				results := queryCourses(sisparse.WithAcademicYear(p.Context, t.Year), codes, t.Semester)
+				res := []*gqlCourse{}
+				for _, code := range codes {
+					c, err := gqlCourseFromAnswer(code, t, string(results[code]))
+					if err != nil {
+						return nil, err
+					}
+					res = append(res, c)
+				}
+				return res, nil
+			},
+		},
+		// Of the cached courses, see /search
+		"search": {
+			Type: graphql.NewList(gqlCourseSummaryType),
+			Args: graphql.FieldConfigArgument{
+				"query": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return tenantOf(p.Context).index.search(p.Args["query"].(string)), nil
+			},
+		},
+		"teacher": {
+			Type: gqlTeacherType,
+			Args: graphql.FieldConfigArgument{
+				"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String), Description: "The ID of the teacher in SIS"},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				t := gqlTeacher{ID: p.Args["id"].(string)}
+				for _, e := range teacherEvents(p.Context, t.ID) {
+					t.Name = e.Teacher
+					break
+				}
+				return t, nil
+			},
+		},
+	},
+})
+
+var gqlMutationType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Mutation",
+	Fields: graphql.Fields{
+		// Takes the body of a /solverquery/ request and returns its answer,
+		// both as JSON strings
+		"solve": {
+			Type: graphql.String,
+			Args: graphql.FieldConfigArgument{
+				"request": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				body := []byte(p.Args["request"].(string))
+				if len(body) == 0 {
+					return nil, fmt.Errorf("Request must be non-empty")
+				}
+				return gqlSolve(p.Context, body)
+			},
+		},
+	},
+})
+
+func gqlSolve(ctx context.Context, body []byte) (string, error) {
+	job := startJob(body, "")
+	res, err := solveWithRules(ctx, body)
+	if solverContext.Err() != nil {
+		return "", fmt.Errorf("The server is shutting down, try again later")
+	}
+	finishJob(job, res, err)
+	if err != nil {
+		return "", err
+	}
+	return string(res), nil
+}
+
+// Returns the union of the argument sets.
+func withArgs(sets ...graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	res := graphql.FieldConfigArgument{}
+	for _, set := range sets {
+		for k, v := range set {
+			res[k] = v
+		}
+	}
+	return res
+}
+
+var gqlSchema graphql.Schema
+
+func init() {
+	var err error
+	gqlSchema, err = graphql.NewSchema(graphql.SchemaConfig{
+		Query:    gqlQueryType,
+		Mutation: gqlMutationType,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Answers GraphQL requests, either POSTed as {"query": "...", "variables":
+// {...}, "operationName": "..."} or as ?query= of a GET request (which can't
+// contain mutations).
+func graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables"`
+		OperationName string                 `json:"operationName"`
+	}
+	switch r.Method {
+	case "GET":
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				fmt.Fprint(w, `{"errors":[{"message":"Invalid variables"}]}`)
+				return
+			}
+		}
+		if strings.HasPrefix(strings.TrimSpace(req.Query), "mutation") {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprint(w, `{"errors":[{"message":"Use POST for mutations"}]}`)
+			return
+		}
+	case "POST":
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err == nil {
+			err = json.Unmarshal(body, &req)
+		}
+		if err != nil {
+			fmt.Fprint(w, `{"errors":[{"message":"Expected {\"query\":\"...\"}"}]}`)
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"errors":[{"message":"Use GET or POST"}]}`)
+		return
+	}
+
+	// One budget for the whole document, however many fields query courses
+	ctx := withFetchBudget(r.Context())
+	logf(ctx, "GraphQL: %s", ellipsis(strings.Join(strings.Fields(req.Query), " "), 50))
+	result := graphql.Do(graphql.Params{
+		Schema:         gqlSchema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        ctx,
+	})
+	if result.HasErrors() {
+		logf(ctx, "GraphQL errors: %v", result.Errors)
+	}
+	s, err := json.Marshal(result)
+	if err != nil {
+		logf(ctx, "GraphQL error: %s", err)
+		fmt.Fprintf(w, `{"errors":[{"message":"%s"}]}`, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, string(s))
+}
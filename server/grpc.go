@@ -0,0 +1,255 @@
+// The gRPC API (see api/samorozvrh.proto), for programs which want typed
+// clients rather than the JSON API. It answers as the JSON API does, for
+// the default tenant, and its clients are counted in the quotas by their
+// addresses.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/api"
+	"github.com/iamwave/samorozvrh/sisparse"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Serves the gRPC API on the port (unless it is 0) and returns a function
+// which stops it, letting the running calls finish until ctx is done and
+// then cancelling them.
+func startGrpc(port int) (stop func(ctx context.Context) error) {
+	if port == 0 {
+		return func(context.Context) error { return nil }
+	}
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		log.Fatalf("Could not start gRPC server: %s\n", err)
+	}
+	s := grpc.NewServer(grpc.UnaryInterceptor(grpcQuotaInterceptor))
+	api.RegisterSamorozvrhServer(s, grpcServer{})
+	go func() {
+		log.Printf("Serving gRPC on: %d", port)
+		if err := s.Serve(lis); err != nil {
+			log.Printf("gRPC server error: %s", err)
+		}
+	}()
+	return func(ctx context.Context) error {
+		stopped := make(chan struct{})
+		go func() {
+			s.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			s.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Counts the calls in the quotas of their clients' addresses, as
+// quotaMiddleware does for HTTP requests without a token.
+func grpcQuotaInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if solveQuota.Limit <= 0 && fetchQuota.Limit <= 0 {
+		return handler(ctx, req)
+	}
+	addr := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+	}
+	ctx, _ = withQuotaClient(ctx, "addr:"+addr)
+	return handler(ctx, req)
+}
+
+type grpcServer struct {
+	api.UnimplementedSamorozvrhServer
+}
+
+func (grpcServer) GetTerm(ctx context.Context, req *api.GetTermRequest) (*api.Term, error) {
+	t := currentTerm(ctx)
+	return &api.Term{AcademicYear: int32(t.Year), Semester: int32(t.Semester)}, nil
+}
+
+func (grpcServer) GetCourse(ctx context.Context, req *api.GetCourseRequest) (*api.Course, error) {
+	t, err := grpcTerm(ctx, req.Semester, req.AcademicYear)
+	if err != nil {
+		return nil, err
+	}
+	code := sisparse.NormalizeCourseCode(req.Code)
+	if !sisparse.IsCourseCode(code) {
+		return nil, status.Error(codes.InvalidArgument, "Invalid course code")
+	}
+	logf(ctx, "gRPC course: %s", ellipsis(code, 10))
+	res, err := queryCourse(sisparse.WithAcademicYear(ctx, t.Year), code, t.Semester)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return courseToProto(code, t, res)
+}
+
+func (grpcServer) GetCourses(ctx context.Context, req *api.GetCoursesRequest) (*api.GetCoursesResponse, error) {
+	t, err := grpcTerm(ctx, req.Semester, req.AcademicYear)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Codes) > MAX_BATCH_SIZE {
+		return nil, status.Errorf(codes.InvalidArgument, "At most %d courses can be requested at once", MAX_BATCH_SIZE)
+	}
+	courseCodes := []string{}
+	for _, code := range req.Codes {
+		courseCodes = append(courseCodes, sisparse.NormalizeCourseCode(code))
+	}
+	logf(ctx, "gRPC courses: %s", ellipsis(strings.Join(courseCodes, ","), 30))
+	results := queryCourses(withFetchBudget(sisparse.WithAcademicYear(ctx, t.Year)), courseCodes, t.Semester)
+	res := &api.GetCoursesResponse{}
+	for _, code := range courseCodes {
+		r := &api.CourseResult{Code: code}
+		if c, err := courseToProto(code, t, string(results[code])); err != nil {
+			r.Error = status.Convert(err).Message()
+		} else {
+			r.Course = c
+		}
+		res.Results = append(res.Results, r)
+	}
+	return res, nil
+}
+
+func (grpcServer) SearchCourses(ctx context.Context, req *api.SearchCoursesRequest) (*api.SearchCoursesResponse, error) {
+	res := &api.SearchCoursesResponse{}
+	for _, s := range tenantOf(ctx).index.search(req.Query) {
+		res.Courses = append(res.Courses, &api.CourseSummary{
+			Code:       s.Code,
+			Name:       s.Name,
+			Teachers:   s.Teachers,
+			TeacherIds: s.TeacherIds,
+		})
+	}
+	return res, nil
+}
+
+func (grpcServer) Solve(ctx context.Context, req *api.SolveRequest) (*api.SolveResponse, error) {
+	if req.Query == "" {
+		return nil, status.Error(codes.InvalidArgument, "Query must be non-empty")
+	}
+	logf(ctx, "gRPC solve: %s", ellipsis(req.Query, 30))
+	job := startJob([]byte(req.Query), "")
+	res, err := solveWithRules(ctx, []byte(req.Query))
+	if solverContext.Err() != nil {
+		// Killed because of a shutdown; the job stays marked as interrupted
+		return nil, status.Error(codes.Unavailable, "The server is shutting down, try again later")
+	}
+	finishJob(job, res, err)
+	if err == ErrQuotaExceeded {
+		return nil, grpcError(err)
+	} else if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &api.SolveResponse{Answer: string(res)}, nil
+}
+
+// Returns the status of an error of queryCourse or of the solver.
+func grpcError(err error) error {
+	switch err {
+	case sisparse.ErrScheduleNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case ErrQuotaExceeded:
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// Returns the term given by a request, where zeros mean the current one.
+func grpcTerm(ctx context.Context, semester, year int32) (term, error) {
+	t := currentTerm(ctx)
+	if semester != 0 {
+		if semester != sisparse.Winter && semester != sisparse.Summer {
+			return t, status.Errorf(codes.InvalidArgument, "Invalid semester: %d", semester)
+		}
+		t.Semester = int(semester)
+	}
+	if year != 0 {
+		if !isValidYear(ctx, int(year)) {
+			return t, status.Errorf(codes.InvalidArgument, "Invalid year: %d", year)
+		}
+		t.Year = int(year)
+	}
+	return t, nil
+}
+
+// Converts an answer made by queryCourse.
+func courseToProto(code string, t term, res string) (*api.Course, error) {
+	var parsed struct {
+		Data     [][]sisparse.Event `json:"data"`
+		Error    string             `json:"error"`
+		Override *overrideSummary   `json:"override"`
+	}
+	if err := json.Unmarshal([]byte(res), &parsed); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if parsed.Error != "" {
+		return nil, status.Error(codes.NotFound, parsed.Error)
+	}
+	c := sisparse.NewCourse(code, t.Semester, parsed.Data)
+	course := &api.Course{
+		Code:            code,
+		Name:            c.Name,
+		AcademicYear:    int32(t.Year),
+		Semester:        int32(t.Semester),
+		SeminarOptional: c.SeminarOptional,
+	}
+	if parsed.Override != nil {
+		course.OverrideReason = parsed.Override.Reason
+	}
+	for _, g := range parsed.Data {
+		if len(g) == 0 {
+			continue
+		}
+		group := &api.Group{Id: g[0].GroupID, Type: string(g[0].Type), Optional: g[0].Optional}
+		for _, e := range g {
+			group.Events = append(group.Events, eventToProto(e))
+		}
+		course.Groups = append(course.Groups, group)
+	}
+	return course, nil
+}
+
+func eventToProto(e sisparse.Event) *api.Event {
+	weeks := []int32{}
+	for _, w := range e.Weeks {
+		weeks = append(weeks, int32(w))
+	}
+	return &api.Event{
+		CourseCode: e.CourseCode,
+		Code:       e.Code,
+		GroupId:    e.GroupID,
+		Semester:   int32(e.Semester),
+		Type:       string(e.Type),
+		Name:       e.Name,
+		Teacher:    e.Teacher,
+		TeacherId:  e.TeacherID,
+		Day:        int32(e.Day),
+		TimeFrom:   e.TimeFrom.String(),
+		TimeTo:     e.TimeTo.String(),
+		WeekParity: e.WeekParity.String(),
+		Room:       e.Room,
+		Capacity:   int32(e.Capacity),
+		Enrolled:   int32(e.Enrolled),
+		Note:       e.Note,
+		Language:   e.Language,
+		Optional:   e.Optional,
+		Patched:    e.Patched,
+		Weeks:      weeks,
+		Cancelled:  e.Cancelled,
+	}
+}
@@ -0,0 +1,85 @@
+// Health checks for running the server under a supervisor
+// (systemd, Kubernetes, ...).
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+var startTime = time.Now()
+
+type checkResult struct {
+	Ok      bool        `json:"ok"`
+	Error   string      `json:"error,omitempty"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// /healthz: the server is running and able to answer.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, true, map[string]interface{}{
+		"uptime_seconds": int(time.Since(startTime).Seconds()),
+	})
+}
+
+// /readyz: the server is able to serve requests, i.e. its dependencies work.
+// SIS being down doesn't make us unready, as we can still serve the cache,
+// but the time of the last successful request to it is reported.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]checkResult{}
+
+	if err := db.Ping(); err != nil {
+		checks["store"] = checkResult{Ok: false, Error: err.Error()}
+	} else {
+		checks["store"] = checkResult{Ok: true}
+	}
+
+	lastSis := sisparse.GetLastSuccess()
+	sisDetails := map[string]interface{}{
+		"mirrors": sisparse.GetMirrorStatus(),
+		"breaker": sisparse.GetBreakerStatus(),
+		"paused":  sisparse.IsPaused(),
+	}
+	if !lastSis.IsZero() {
+		sisDetails["last_success"] = lastSis
+	}
+	if chaos := sisparse.GetChaos(); chaos.Enabled() {
+		// Not to be mistaken for SIS failing
+		sisDetails["chaos"] = chaos.String()
+	}
+	checks["sis"] = checkResult{Ok: true, Details: sisDetails}
+
+	// The solver is started anew for each request, so we can only check that
+	// it is present. A failed run may be caused by the user's input, so it
+	// is just reported.
+	status := getSolverStatus()
+	if _, err := os.Stat(path.Join(rootDir, "solver")); err != nil {
+		checks["solver"] = checkResult{Ok: false, Error: err.Error(), Details: status}
+	} else {
+		checks["solver"] = checkResult{Ok: true, Details: status}
+	}
+
+	ok := true
+	for _, c := range checks {
+		ok = ok && c.Ok
+	}
+	writeHealth(w, ok, checks)
+}
+
+func writeHealth(w http.ResponseWriter, ok bool, details interface{}) {
+	status := "ok"
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		status = "fail"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"checks": details,
+	})
+}
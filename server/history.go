@@ -0,0 +1,275 @@
+// The history of the solve requests of a user, and comparison of two of
+// the schedules found, to see what changed since the user planned last.
+// Users are identified by the token of their preference profiles (see
+// profiles.go), which they send with solve requests to have them kept.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// How many of the last solve requests of a user are listed
+const MAX_HISTORY = 50
+
+// A solve request in the history of a user
+type historyEntry struct {
+	Id      string             `json:"id"`
+	Status  string             `json:"status"`
+	Created time.Time          `json:"created"`
+	Courses []string           `json:"courses"`
+	Score   map[string]float64 `json:"score,omitempty"`
+}
+
+// What changed between two schedules
+type scheduleDiff struct {
+	From historyEntry `json:"from"`
+	To   historyEntry `json:"to"`
+	// The courses whose groups differ, see courseDiff
+	Courses []courseDiff `json:"courses"`
+	// The number of courses with the same groups in both
+	Unchanged int                   `json:"unchanged"`
+	Score     map[string]scoreDelta `json:"score"`
+}
+
+// A course of the schedules compared. Before or After is null when the course
+// isn't in that schedule (it wasn't requested or couldn't be scheduled).
+type courseDiff struct {
+	Course string        `json:"course"`
+	Before *chosenGroups `json:"before"`
+	After  *chosenGroups `json:"after"`
+	Change string        `json:"change"` // "added", "removed" or "changed"
+}
+
+type chosenGroups struct {
+	Option int              `json:"option"`
+	Events []sisparse.Event `json:"events"`
+}
+
+type scoreDelta struct {
+	Before float64 `json:"before"`
+	After  float64 `json:"after"`
+	Delta  float64 `json:"delta"`
+}
+
+// Returns the hash of the user who sent the request, "" if it doesn't say.
+func requestUserHash(r *http.Request) string {
+	if token := r.Header.Get(PROFILE_TOKEN_HEADER); token != "" {
+		return hashToken(token)
+	}
+	return ""
+}
+
+// Answers
+//   - GET /history/ with the last solve requests of the user (given by
+//     PROFILE_TOKEN_HEADER), the newest first,
+//   - GET /history/<id> with the request and its answer,
+//   - GET /history/diff?from=<id>&to=<id> with what changed in the schedule
+//     between the two requests, see scheduleDiff.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	user := requestUserHash(r)
+	if user == "" {
+		fmt.Fprintf(w, `{"error":"Send the token of your profiles in %s"}`, PROFILE_TOKEN_HEADER)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/history/")
+	var data interface{}
+	var err error
+	switch id {
+	case "":
+		data, err = listHistory(user)
+	case "diff":
+		data, err = diffHistory(user, r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+	default:
+		var job store.Job
+		if job, err = getUserJob(user, id); err == nil {
+			data = map[string]interface{}{
+				"id":      job.Id,
+				"status":  job.Status,
+				"created": job.Created,
+				"request": json.RawMessage(job.Request),
+				"answer":  jobAnswer(job),
+			}
+		}
+	}
+	if err == store.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"No such solve request"}`)
+		return
+	} else if err != nil {
+		s, _ := json.Marshal(map[string]string{"error": err.Error()})
+		fmt.Fprint(w, string(s))
+		return
+	}
+	s, _ := json.Marshal(data)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func listHistory(user string) ([]historyEntry, error) {
+	jobs, err := db.ListUserJobs(user, MAX_HISTORY)
+	if err != nil {
+		return nil, err
+	}
+	res := []historyEntry{}
+	for _, job := range jobs {
+		entry, _, _ := parseHistoryEntry(job)
+		res = append(res, entry)
+	}
+	return res, nil
+}
+
+// Returns the job, unless it belongs to another user.
+func getUserJob(user, id string) (store.Job, error) {
+	job, err := db.GetJob(id)
+	if err == nil && job.UserHash != user {
+		err = store.ErrNotFound
+	}
+	return job, err
+}
+
+// The answer of a finished job; failed jobs only have the error message,
+// interrupted ones ask to be sent again.
+func jobAnswer(job store.Job) interface{} {
+	switch job.Status {
+	case JOB_FAILED:
+		return map[string]string{"error": job.Result}
+	case JOB_INTERRUPTED:
+		return map[string]string{"error": JOB_INTERRUPTED_ERROR}
+	}
+	if job.Status != JOB_DONE {
+		return nil
+	}
+	return json.RawMessage(job.Result)
+}
+
+// Parses the request and the answer of a job. The answer is empty unless
+// the job is done.
+func parseHistoryEntry(job store.Job) (historyEntry, solveRequest, solveResponse) {
+	entry := historyEntry{Id: job.Id, Status: job.Status, Created: job.Created, Courses: []string{}}
+	req, err := parseSolveRequest([]byte(job.Request))
+	if err != nil {
+		return entry, req, solveResponse{}
+	}
+	for _, c := range req.Courses {
+		entry.Courses = append(entry.Courses, historyCourseName(c))
+	}
+	var res solveResponse
+	if job.Status == JOB_DONE {
+		json.Unmarshal([]byte(job.Result), &res)
+		entry.Score = res.Score
+	}
+	return entry, req, res
+}
+
+func historyCourseName(c solveCourse) string {
+	if c.CourseCode != "" {
+		return c.CourseCode
+	}
+	return c.Name
+}
+
+func diffHistory(user, fromId, toId string) (scheduleDiff, error) {
+	if fromId == "" || toId == "" {
+		return scheduleDiff{}, fmt.Errorf("Expected ?from=<id>&to=<id>")
+	}
+	var diff scheduleDiff
+	var courses [2]map[string]*chosenGroups
+	var order []string
+	for i, id := range []string{fromId, toId} {
+		job, err := getUserJob(user, id)
+		if err != nil {
+			return scheduleDiff{}, err
+		}
+		if job.Status != JOB_DONE {
+			return scheduleDiff{}, fmt.Errorf("Solve request %s is %s, only finished ones can be compared", id, job.Status)
+		}
+		entry, req, res := parseHistoryEntry(job)
+		if i == 0 {
+			diff.From = entry
+		} else {
+			diff.To = entry
+		}
+		if courses[i], err = chosenCourseGroups(req, res); err != nil {
+			return scheduleDiff{}, fmt.Errorf("Solve request %s: %s", id, err)
+		}
+		order = append(order, entry.Courses...)
+	}
+
+	seen := map[string]bool{}
+	diff.Courses = []courseDiff{}
+	for _, name := range order {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		before, after := courses[0][name], courses[1][name]
+		d := courseDiff{Course: name, Before: before, After: after}
+		switch {
+		case before == nil && after == nil:
+			continue
+		case before == nil:
+			d.Change = "added"
+		case after == nil:
+			d.Change = "removed"
+		case sameEvents(before.Events, after.Events):
+			diff.Unchanged++
+			continue
+		default:
+			d.Change = "changed"
+		}
+		diff.Courses = append(diff.Courses, d)
+	}
+
+	diff.Score = map[string]scoreDelta{}
+	for key, after := range diff.To.Score {
+		if before, ok := diff.From.Score[key]; ok {
+			diff.Score[key] = scoreDelta{Before: before, After: after, Delta: after - before}
+		}
+	}
+	return diff, nil
+}
+
+// Returns the groups chosen for each scheduled course, by historyCourseName.
+func chosenCourseGroups(req solveRequest, res solveResponse) (map[string]*chosenGroups, error) {
+	chosen := map[string]*chosenGroups{}
+	for i, c := range req.Courses {
+		if i >= len(res.Data) || res.Data[i] == nil {
+			continue
+		}
+		var options [][]sisparse.Event
+		if err := json.Unmarshal(c.Options, &options); err != nil {
+			return nil, err
+		}
+		option := *res.Data[i]
+		if option < 0 || option >= len(options) {
+			return nil, fmt.Errorf("Invalid option %d of %s", option, c.Name)
+		}
+		chosen[historyCourseName(c)] = &chosenGroups{Option: option, Events: options[option]}
+	}
+	return chosen, nil
+}
+
+// Whether the events are at the same times in the same groups. Options are
+// not compared by their index, as the options of a course change when SIS does.
+func sameEvents(a, b []sisparse.Event) bool {
+	keys := func(events []sisparse.Event) string {
+		res := []string{}
+		for _, e := range events {
+			group := e.GroupID
+			if group == "" {
+				group = e.Code
+			}
+			res = append(res, fmt.Sprintf("%s %d %s-%s %s", group, e.Day, e.TimeFrom, e.TimeTo, e.WeekParity))
+		}
+		sort.Strings(res)
+		return strings.Join(res, "\n")
+	}
+	return keys(a) == keys(b)
+}
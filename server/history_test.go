@@ -0,0 +1,30 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+func TestJobAnswer(t *testing.T) {
+	tests := []struct {
+		job  store.Job
+		want string
+	}{
+		{store.Job{Status: JOB_DONE, Result: `{"data":[]}`}, `{"data":[]}`},
+		{store.Job{Status: JOB_FAILED, Result: "No such course"}, `{"error":"No such course"}`},
+		// Never run again, so the client has to send it again
+		{store.Job{Status: JOB_INTERRUPTED}, `{"error":"` + JOB_INTERRUPTED_ERROR + `"}`},
+		{store.Job{Status: JOB_RUNNING}, `null`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(jobAnswer(tt.job))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Answer of a %s job: got %s, want %s", tt.job.Status, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Hooks (see the hook package) configured by -hook, which apply the policy
+// of the deployment to solve requests and to the schedules found.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/hook"
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+type configuredHook struct {
+	name string
+	hook.Hook
+}
+
+var hooks []configuredHook
+
+// Adds a hook given as <name>[:<config>], e.g. blocked:2,14:00-15:30.
+func addHook(spec string) error {
+	parts := strings.SplitN(spec, ":", 2)
+	config := ""
+	if len(parts) == 2 {
+		config = parts[1]
+	}
+	h, err := hook.Open(parts[0], config)
+	if err != nil {
+		return err
+	}
+	hooks = append(hooks, configuredHook{parts[0], h})
+	return nil
+}
+
+// Returns the request as hooks see it.
+func newHookRequest(req solveRequest) (hook.Request, error) {
+	res := hook.Request{Rules: append([]string{}, req.Rules...)}
+	for _, c := range req.Courses {
+		var options [][]sisparse.Event
+		if err := json.Unmarshal(c.Options, &options); err != nil {
+			return res, fmt.Errorf("Invalid options of %s: %s", c.Name, err)
+		}
+		res.Courses = append(res.Courses, hook.Course{
+			Name:       c.Name,
+			CourseCode: c.CourseCode,
+			Optional:   c.Optional,
+			Options:    options,
+		})
+	}
+	return res, nil
+}
+
+// Lets the hooks see the request and add rules to it, in the order they
+// were configured. Returns the request as the hooks saw it, for
+// afterSolveHooks.
+func beforeSolveHooks(ctx context.Context, req *solveRequest) (hook.Request, error) {
+	if len(hooks) == 0 {
+		return hook.Request{}, nil
+	}
+	hreq, err := newHookRequest(*req)
+	if err != nil {
+		return hreq, err
+	}
+	for _, h := range hooks {
+		if err := h.BeforeSolve(ctx, &hreq); err != nil {
+			return hreq, err
+		}
+	}
+	req.Rules = hreq.Rules
+	return hreq, nil
+}
+
+// Lets the hooks change the schedule of the answer of the solver (as
+// translated by filteredQuery), unless it has none.
+func afterSolveHooks(ctx context.Context, hreq hook.Request, answer []byte) ([]byte, error) {
+	if len(hooks) == 0 {
+		return answer, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(answer, &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["error"]; ok {
+		return answer, nil
+	}
+	var res hook.Result
+	if err := json.Unmarshal(fields["data"], &res.Chosen); err != nil {
+		return nil, err
+	}
+	if e, ok := fields["explanation"]; ok {
+		if err := json.Unmarshal(e, &res.Explanation); err != nil {
+			return nil, err
+		}
+	}
+	if res.Explanation == nil {
+		res.Explanation = make([]string, len(res.Chosen))
+	}
+	for _, h := range hooks {
+		if err := h.AfterSolve(ctx, hreq, &res); err != nil {
+			return nil, err
+		}
+	}
+	if len(res.Chosen) != len(hreq.Courses) {
+		return nil, fmt.Errorf("A hook chose options for %d courses instead of %d", len(res.Chosen), len(hreq.Courses))
+	}
+	for i, o := range res.Chosen {
+		if o != nil && (*o < 0 || *o >= len(hreq.Courses[i].Options)) {
+			return nil, fmt.Errorf("A hook chose a nonexistent option of %s", hreq.Courses[i].Name)
+		}
+	}
+	fields["data"], _ = json.Marshal(res.Chosen)
+	if len(res.Explanation) == len(res.Chosen) {
+		fields["explanation"], _ = json.Marshal(res.Explanation)
+	}
+	return json.Marshal(fields)
+}
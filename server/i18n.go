@@ -0,0 +1,324 @@
+// Localization of the texts meant for people: errors and warnings are
+// answered with a machine code and a text in the language of the request,
+// chosen by ?lang= or else by the Accept-Language header.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// The language of requests which ask for none we know
+const DEFAULT_LANGUAGE = "en"
+
+// By code and language, the texts (fmt formats where they take arguments).
+// Each code must have a text in each of the languages.
+var messages = map[string]map[string]string{
+	// Errors
+	"course_not_found": {
+		"en": "Couldn't find the schedule of the course",
+		"cs": "Rozvrh předmětu se nepodařilo najít",
+	},
+	"sis_unavailable": {
+		"en": "SIS is unavailable, not querying it for a while",
+		"cs": "SIS je nedostupný, chvíli se na něj nebudeme ptát",
+	},
+	"sis_paused": {
+		"en": "Requests to SIS are paused, only cached courses are available",
+		"cs": "Dotazy do SISu jsou pozastavené, dostupné jsou jen předměty z cache",
+	},
+	"layout_changed": {
+		"en": "The layout of the SIS schedule page has changed",
+		"cs": "Stránka s rozvrhem v SIS se změnila",
+	},
+	"outside_crawl_window": {
+		"en": "Background requests to SIS are only made within the crawl window",
+		"cs": "Na pozadí se do SIS chodí jen v povolenou dobu",
+	},
+	"fetch_queued": {
+		"en": "The course is being fetched from SIS, try again later",
+		"cs": "Předmět se načítá ze SISu, zkuste to později",
+	},
+	"quota_exceeded": {
+		"en": "You have used up your quota, try again later",
+		"cs": "Vyčerpali jste svůj limit, zkuste to později",
+	},
+	"login_failed": {
+		"en": "Login failed, check the login and password",
+		"cs": "Přihlášení selhalo, zkontrolujte login a heslo",
+	},
+	"not_found": {
+		"en": "Not found",
+		"cs": "Nenalezeno",
+	},
+	// Warnings about courses which can't be scheduled
+	"no_group_satisfies": {
+		"en": "No group satisfies: %s",
+		"cs": "Žádná skupina nesplňuje: %s",
+	},
+	"taken_together": {
+		"en": "It is only taken together with %s, which can't be scheduled",
+		"cs": "Zapisuje se jen spolu s %s, který nelze rozvrhnout",
+	},
+	"cross_listed": {
+		"en": "It has the same groups as %s (it is cross-listed), which is scheduled instead",
+		"cs": "Má stejné skupiny jako %s (jde o týž předmět), který se rozvrhuje místo něj",
+	},
+	// Emails, see notify.go
+	"email_confirm_subject": {
+		"en": "Confirm your address for Samorozvrh notifications",
+		"cs": "Potvrďte adresu pro upozornění Samorozvrhu",
+	},
+	"email_confirm_intro": {
+		"en": "To get notifications from Samorozvrh at this address, confirm it by opening:",
+		"cs": "Chcete-li na tuto adresu dostávat upozornění Samorozvrhu, potvrďte ji otevřením odkazu:",
+	},
+	"email_course_changed_subject": {
+		"en": "The schedule of %s changed",
+		"cs": "Rozvrh předmětu %s se změnil",
+	},
+	"email_course_changed_intro": {
+		"en": "The schedule of %s changed in SIS:",
+		"cs": "Rozvrh předmětu %s se v SIS změnil:",
+	},
+	"email_group_cancelled_subject": {
+		"en": "A group of %s you chose was cancelled",
+		"cs": "Skupina předmětu %s, kterou jste si vybrali, byla zrušena",
+	},
+	"email_group_cancelled_intro": {
+		"en": "SIS now says that this group of %s from your last schedule is cancelled:",
+		"cs": "Podle SISu je tato skupina předmětu %s z vašeho posledního rozvrhu zrušená:",
+	},
+	"email_group_cancelled_outro": {
+		"en": "Find a new schedule with another group of the course.",
+		"cs": "Najděte si nový rozvrh s jinou skupinou předmětu.",
+	},
+	"email_job_done_subject": {
+		"en": "Your schedule is ready",
+		"cs": "Váš rozvrh je hotový",
+	},
+	"email_job_done_intro": {
+		"en": "The solver has finished your request, see the schedule at:",
+		"cs": "Řešič dokončil váš požadavek, rozvrh najdete na:",
+	},
+	"email_job_failed_subject": {
+		"en": "Your schedule couldn't be made",
+		"cs": "Váš rozvrh se nepodařilo sestavit",
+	},
+	"email_job_failed_intro": {
+		"en": "The solver couldn't finish your request, try again at:",
+		"cs": "Řešič váš požadavek nedokončil, zkuste to znovu na:",
+	},
+	"email_digest_subject": {
+		"en": "This week's changes of your courses",
+		"cs": "Změny vašich předmětů za tento týden",
+	},
+	"email_digest_intro": {
+		"en": "The schedules of these courses you follow changed in SIS this week:",
+		"cs": "Rozvrhy těchto sledovaných předmětů se tento týden v SIS změnily:",
+	},
+	"email_unsubscribe": {
+		"en": "To get no more emails from Samorozvrh, open:",
+		"cs": "Pokud už od Samorozvrhu nechcete dostávat e-maily, otevřete:",
+	},
+	"group_added":   {"en": "new group: %s", "cs": "nová skupina: %s"},
+	"group_removed": {"en": "cancelled group: %s", "cs": "zrušená skupina: %s"},
+	"group_changed": {"en": "%s is now %s", "cs": "%s je nyní %s"},
+	"notifications_confirmed": {
+		"en": "Your address is confirmed, you will get the notifications you chose.",
+		"cs": "Adresa je potvrzena, budete dostávat zvolená upozornění.",
+	},
+	"notifications_unsubscribed": {
+		"en": "You will get no more emails from Samorozvrh.",
+		"cs": "Od Samorozvrhu už nebudete dostávat žádné e-maily.",
+	},
+	// Chat bots, see bot.go
+	"bot_help": {
+		"en": "Commands:\n/course <code> - the groups of a course in this semester\n/solve <code> <code> ... - a schedule of the courses\n/follow <code> - be told here when the schedule of a course changes\n/unfollow <code> - stop following a course\n/following - the followed courses\n/link <code> - send your notifications here (the code is in your notification settings)\n/unlink <code> - stop sending them here",
+		"cs": "Příkazy:\n/course <kód> - skupiny předmětu v tomto semestru\n/solve <kód> <kód> ... - rozvrh předmětů\n/follow <kód> - dostávat sem změny rozvrhu předmětu\n/unfollow <kód> - přestat sledovat předmět\n/following - sledované předměty\n/link <kód> - posílat sem vaše upozornění (kód je v nastavení upozornění)\n/unlink <kód> - přestat je sem posílat",
+	},
+	"bot_usage":             {"en": "Usage: %s", "cs": "Použití: %s"},
+	"bot_unknown_command":   {"en": "Unknown command, see /help", "cs": "Neznámý příkaz, viz /help"},
+	"bot_error":             {"en": "Something went wrong, try again later", "cs": "Něco se pokazilo, zkuste to později"},
+	"bot_no_groups":         {"en": "%s has no groups this semester", "cs": "%s nemá v tomto semestru žádné skupiny"},
+	"bot_no_schedule":       {"en": "No schedule fits these courses", "cs": "Tyto předměty nejde rozvrhnout"},
+	"bot_not_scheduled":     {"en": "not scheduled", "cs": "nezařazen"},
+	"bot_followed":          {"en": "You'll be told here when the schedule of %s changes", "cs": "Změny rozvrhu %s vám budou chodit sem"},
+	"bot_unfollowed":        {"en": "%s is not followed here anymore", "cs": "%s se tu už nesleduje"},
+	"bot_following":         {"en": "Followed courses: %s", "cs": "Sledované předměty: %s"},
+	"bot_following_none":    {"en": "No courses are followed here", "cs": "Tady se nesledují žádné předměty"},
+	"bot_fetch_queued":      {"en": "Fetching %s from SIS, try again in %d seconds", "cs": "Načítám %s ze SISu, zkuste to znovu za %d sekund"},
+	"bot_too_many_followed": {"en": "At most %d courses can be followed", "cs": "Sledovat lze nejvýše %d předmětů"},
+	"bot_link_unknown":      {"en": "Unknown code, copy it from your notification settings", "cs": "Neznámý kód, zkopírujte ho z nastavení upozornění"},
+	"bot_linked":            {"en": "Your notifications will be sent here", "cs": "Vaše upozornění budou chodit sem"},
+	"bot_unlinked":          {"en": "Your notifications won't be sent here anymore", "cs": "Vaše upozornění sem už chodit nebudou"},
+	// Names of the machine codes of events
+	"day_0":        {"en": "Mon", "cs": "Po"},
+	"day_1":        {"en": "Tue", "cs": "Út"},
+	"day_2":        {"en": "Wed", "cs": "St"},
+	"day_3":        {"en": "Thu", "cs": "Čt"},
+	"day_4":        {"en": "Fri", "cs": "Pá"},
+	"day_5":        {"en": "Sat", "cs": "So"},
+	"day_6":        {"en": "Sun", "cs": "Ne"},
+	"lecture":      {"en": "lecture", "cs": "přednáška"},
+	"seminar":      {"en": "seminar", "cs": "cvičení"},
+	"odd_weeks":    {"en": "odd weeks", "cs": "liché týdny"},
+	"even_weeks":   {"en": "even weeks", "cs": "sudé týdny"},
+	"weeks":        {"en": "weeks", "cs": "týdny"},
+	"same_day":     {"en": "on the same day", "cs": "ve stejný den"},
+	"back_to_back": {"en": "on consecutive days", "cs": "v po sobě jdoucích dnech"},
+}
+
+// The codes of the errors which we know how to put into words
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{sisparse.ErrScheduleNotFound, "course_not_found"},
+	{sisparse.ErrCircuitOpen, "sis_unavailable"},
+	{sisparse.ErrPaused, "sis_paused"},
+	{sisparse.ErrLayoutChanged, "layout_changed"},
+	{sisparse.ErrLoginFailed, "login_failed"},
+	{ErrOutsideCrawlWindow, "outside_crawl_window"},
+	{ErrFetchQueued, "fetch_queued"},
+	{ErrQuotaExceeded, "quota_exceeded"},
+	{store.ErrNotFound, "not_found"},
+}
+
+type languageKey struct{}
+
+func withLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey{}, lang)
+}
+
+// Returns the language set by withLanguage, or DEFAULT_LANGUAGE.
+func languageOf(ctx context.Context) string {
+	if lang, ok := ctx.Value(languageKey{}).(string); ok {
+		return lang
+	}
+	return DEFAULT_LANGUAGE
+}
+
+func isLanguage(lang string) bool {
+	_, ok := messages["not_found"][lang]
+	return ok
+}
+
+// Chooses the language of the request: ?lang= if it's one we know, else
+// the one most preferred by Accept-Language, else DEFAULT_LANGUAGE.
+func requestLanguage(r *http.Request) string {
+	if lang := strings.ToLower(r.URL.Query().Get("lang")); isLanguage(lang) {
+		return lang
+	}
+	type preference struct {
+		lang string
+		q    float64
+	}
+	prefs := []preference{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		fields := strings.Split(part, ";")
+		// Only the primary subtag matters, "cs-CZ" is "cs"
+		lang := strings.ToLower(strings.TrimSpace(strings.Split(fields[0], "-")[0]))
+		q := 1.0
+		for _, f := range fields[1:] {
+			if f = strings.TrimSpace(f); strings.HasPrefix(f, "q=") {
+				q, _ = strconv.ParseFloat(f[2:], 64)
+			}
+		}
+		if isLanguage(lang) && q > 0 {
+			prefs = append(prefs, preference{lang, q})
+		}
+	}
+	sort.SliceStable(prefs, func(i, j int) bool { return prefs[i].q > prefs[j].q })
+	if len(prefs) > 0 {
+		return prefs[0].lang
+	}
+	return DEFAULT_LANGUAGE
+}
+
+// Sets the language of each request, see requestLanguage.
+func languageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := requestLanguage(r)
+		w.Header().Add("Vary", "Accept-Language")
+		w.Header().Set("Content-Language", lang)
+		next.ServeHTTP(w, r.WithContext(withLanguage(r.Context(), lang)))
+	})
+}
+
+// Returns the text of the message in the language of ctx, or the code
+// itself if there is no such message.
+func localize(ctx context.Context, code string, args ...interface{}) string {
+	texts, ok := messages[code]
+	if !ok {
+		return code
+	}
+	if len(args) == 0 {
+		return texts[languageOf(ctx)]
+	}
+	return fmt.Sprintf(texts[languageOf(ctx)], args...)
+}
+
+// Returns the code of the error, or "" if we can't put it into words.
+func errorCode(err error) string {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return e.code
+		}
+	}
+	return ""
+}
+
+// Returns {"error":"...","error_code":"..."} with the text of the error in
+// the language of ctx. Errors without a code are left as they are.
+func localizedError(ctx context.Context, err error) map[string]interface{} {
+	code := errorCode(err)
+	if code == "" {
+		return map[string]interface{}{"error": err.Error(), "error_code": "other"}
+	}
+	return map[string]interface{}{"error": localize(ctx, code), "error_code": code}
+}
+
+// Answers /api/v1/messages with {"data":{"<code>":"<text>", ...}}, all the
+// messages in the language of the request, for clients which show machine
+// codes (e.g. the types of events) to people.
+func messagesHandler(w http.ResponseWriter, r *http.Request) {
+	res := map[string]string{}
+	for code := range messages {
+		res[code] = localize(r.Context(), code)
+	}
+	s, _ := json.Marshal(res)
+	fmt.Fprintf(w, `{"data":%s,"language":"%s"}`, string(s), languageOf(r.Context()))
+}
+
+func dayName(ctx context.Context, day int) string {
+	return localize(ctx, "day_"+strconv.Itoa(day))
+}
+
+func weekParityLabel(ctx context.Context, p sisparse.WeekParity) string {
+	if p == sisparse.OddWeeks {
+		return localize(ctx, "odd_weeks")
+	}
+	return localize(ctx, "even_weeks")
+}
+
+// Returns which weeks the event takes place in, e.g. "odd weeks" or
+// "weeks 2, 4, 8", empty if every week.
+func eventWeeksLabel(ctx context.Context, e sisparse.Event) string {
+	if len(e.Weeks) > 0 {
+		return localize(ctx, "weeks") + " " + e.WeekList()
+	}
+	if e.WeekParity == sisparse.EveryWeek {
+		return ""
+	}
+	return weekParityLabel(ctx, e.WeekParity)
+}
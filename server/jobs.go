@@ -0,0 +1,105 @@
+// Bookkeeping of solver runs, so that runs interrupted by a shutdown
+// are recorded. They aren't run again by the server; their clients are told
+// to send the request again, see jobAnswer.
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+const (
+	JOB_RUNNING     = "running"
+	JOB_DONE        = "done"
+	JOB_FAILED      = "failed"
+	JOB_INTERRUPTED = "interrupted" // To be sent again by the client
+)
+
+// The answer of interrupted jobs
+const JOB_INTERRUPTED_ERROR = "The solver was interrupted by a restart of the server, send the request again"
+
+// Jobs of other instances which have been running for longer than this
+// are assumed to have been left behind by an instance which crashed.
+const ABANDONED_JOB_AGE = time.Hour
+
+// Identifies this instance of the server in the jobs it runs, see -instance-id
+var instanceId string
+
+var runningJobsMu sync.Mutex
+var runningJobs = map[string]store.Job{}
+
+// Records the start of a solver run and returns its job. The run is a part
+// of the history of the user given by userHash, if it isn't empty.
+func startJob(request []byte, userHash string) store.Job {
+	job := store.Job{Id: newJobId(), Status: JOB_RUNNING, Request: string(request), Owner: instanceId, UserHash: userHash,
+		Created: time.Now().UTC()}
+	saveJob(job)
+	runningJobsMu.Lock()
+	runningJobs[job.Id] = job
+	runningJobsMu.Unlock()
+	return job
+}
+
+func finishJob(job store.Job, result []byte, err error) {
+	runningJobsMu.Lock()
+	delete(runningJobs, job.Id)
+	runningJobsMu.Unlock()
+
+	job.Status = JOB_DONE
+	job.Result = string(result)
+	if err != nil {
+		job.Status = JOB_FAILED
+		job.Result = err.Error()
+	}
+	saveJob(job)
+	go notifyJobDone(job)
+}
+
+// Marks the jobs which are still running as interrupted. Also takes care
+// of jobs left running when the previous instance of the server crashed.
+// Jobs of other instances sharing the database are left alone, unless
+// they look abandoned.
+func interruptRunningJobs() {
+	runningJobsMu.Lock()
+	defer runningJobsMu.Unlock()
+	running, err := db.ListJobs(JOB_RUNNING)
+	if err != nil {
+		log.Printf("Could not list running jobs: %s", err)
+	}
+	jobs := []store.Job{}
+	for _, job := range running {
+		if job.Owner == instanceId || job.Owner == "" || time.Since(job.Updated) > ABANDONED_JOB_AGE {
+			jobs = append(jobs, job)
+		}
+	}
+	for _, job := range runningJobs {
+		jobs = append(jobs, job)
+	}
+	for _, job := range jobs {
+		job.Status = JOB_INTERRUPTED
+		saveJob(job)
+		delete(runningJobs, job.Id)
+	}
+	if len(jobs) > 0 {
+		log.Printf("Marked %d solver jobs as interrupted", len(jobs))
+	}
+}
+
+func saveJob(job store.Job) {
+	if err := db.SaveJob(job); err != nil {
+		log.Printf("Could not save job %s: %s", job.Id, err)
+	}
+}
+
+func newJobId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,117 @@
+// Courses whose lectures the student skips (e.g. as they are recorded),
+// marked "ignore_lectures" in solve requests. Their lectures are left out
+// of what the solver gets, so they may overlap with anything and only the
+// other events decide which group is chosen; the options keep them though,
+// and the answer lists them in "ignored_lectures" as the lectures the
+// student would go to.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// A lecture of the option chosen for a course with "ignore_lectures", e.g.
+// {"course": 0, "semester": 1, "day": 1, "time_from": "09:00",
+// "time_to": "10:30", "overlaps": true}; the day is 0 for Monday.
+type ignoredLecture struct {
+	Course   int    `json:"course"`
+	Semester int    `json:"semester,omitempty"`
+	Day      int    `json:"day"`
+	TimeFrom string `json:"time_from"`
+	TimeTo   string `json:"time_to"`
+	Room     string `json:"room,omitempty"`
+	// Whether it collides with other events of the schedule
+	Overlaps bool `json:"overlaps"`
+}
+
+// Returns the courses as the solver is to get them: those with
+// IgnoreLectures without their lectures. Their semester is set from the
+// lectures if not given, as the solver would take it from the events.
+func withoutIgnoredLectures(courses []solveCourse) ([]solveCourse, error) {
+	res := make([]solveCourse, len(courses))
+	for i, c := range courses {
+		res[i] = c
+		if !c.IgnoreLectures {
+			continue
+		}
+		var options []json.RawMessage
+		if err := json.Unmarshal(c.Options, &options); err != nil {
+			return nil, fmt.Errorf("Invalid options of %s: %s", c.Name, err)
+		}
+		semester := 0
+		for j, option := range options {
+			// Kept as they are, as events may have fields (e.g.
+			// "skippable") which sisparse.Event doesn't know
+			var raw []json.RawMessage
+			var events []sisparse.Event
+			if err := json.Unmarshal(option, &raw); err != nil {
+				return nil, fmt.Errorf("Invalid options of %s: %s", c.Name, err)
+			}
+			if err := json.Unmarshal(option, &events); err != nil {
+				return nil, fmt.Errorf("Invalid options of %s: %s", c.Name, err)
+			}
+			kept := []json.RawMessage{}
+			for k, e := range events {
+				if e.Semester != 0 && (semester == 0 || e.Semester < semester) {
+					semester = e.Semester
+				}
+				if e.Type != sisparse.Lecture {
+					kept = append(kept, raw[k])
+				}
+			}
+			options[j], _ = json.Marshal(kept)
+		}
+		res[i].Options, _ = json.Marshal(options)
+		if res[i].Semester == 0 {
+			res[i].Semester = semester
+		}
+	}
+	return res, nil
+}
+
+// Returns the lectures of the options chosen for the courses with
+// IgnoreLectures, and whether each collides with the other events chosen.
+func ignoredLectures(courses []solveCourse, data []*int) []ignoredLecture {
+	if len(data) != len(courses) {
+		return nil
+	}
+	var res []ignoredLecture
+	chosen := make([][]sisparse.Event, len(courses))
+	for i, c := range courses {
+		var options [][]sisparse.Event
+		if data[i] == nil || json.Unmarshal(c.Options, &options) != nil || *data[i] < 0 || *data[i] >= len(options) {
+			continue
+		}
+		chosen[i] = options[*data[i]]
+	}
+	for i, c := range courses {
+		if !c.IgnoreLectures {
+			continue
+		}
+		for k, e := range chosen[i] {
+			if e.Type != sisparse.Lecture {
+				continue
+			}
+			l := ignoredLecture{
+				Course:   i,
+				Semester: e.Semester,
+				Day:      e.Day,
+				TimeFrom: e.TimeFrom.String(),
+				TimeTo:   e.TimeTo.String(),
+				Room:     e.Room,
+			}
+			for j, events := range chosen {
+				for m, f := range events {
+					if (j != i || m != k) && eventsCollide(e, f) {
+						l.Overlaps = true
+					}
+				}
+			}
+			res = append(res, l)
+		}
+	}
+	return res
+}
@@ -0,0 +1,120 @@
+// A bounded in-memory cache in front of the database, for hot courses.
+package server
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+const DEFAULT_MEMORY_CACHE_SIZE = 1000
+const DEFAULT_MEMORY_CACHE_TTL = 10 * time.Minute
+
+// Counters of the memory cache, shown at /admin/cache/stats
+type lruStats struct {
+	Size      int   `json:"size"`
+	Capacity  int   `json:"capacity"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Expired   int64 `json:"expired"`
+}
+
+type lruEntry struct {
+	entry   store.CacheEntry
+	expires time.Time
+}
+
+// A cache of at most capacity entries, each kept for at most ttl; when it
+// is full, the least recently used entry is dropped. A cache with zero
+// capacity keeps nothing.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List // Of *lruEntry, the most recently used first
+	entries  map[string]*list.Element
+	stats    lruStats
+}
+
+func newLruCache(capacity int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (c *lruCache) get(key string) (store.CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return store.CacheEntry{}, false
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expires) {
+		c.removeElement(el)
+		c.stats.Expired++
+		c.stats.Misses++
+		return store.CacheEntry{}, false
+	}
+	c.order.MoveToFront(el)
+	c.stats.Hits++
+	return e.entry, true
+}
+
+// Reports whether the key is kept and hasn't expired, without counting it
+// as a use.
+func (c *lruCache) has(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	return ok && !time.Now().After(el.Value.(*lruEntry).expires)
+}
+
+func (c *lruCache) set(entry store.CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capacity <= 0 {
+		return
+	}
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.entries[entry.Key]; ok {
+		e := el.Value.(*lruEntry)
+		e.entry, e.expires = entry, expires
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[entry.Key] = c.order.PushFront(&lruEntry{entry, expires})
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Drops all entries, e.g. after the database has been changed directly.
+func (c *lruCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+}
+
+func (c *lruCache) getStats() lruStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Size = c.order.Len()
+	s.Capacity = c.capacity
+	return s
+}
+
+func (c *lruCache) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).entry.Key)
+}
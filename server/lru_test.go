@@ -0,0 +1,107 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+func lruEntryOf(key string) store.CacheEntry {
+	return store.CacheEntry{Key: key, Value: "value of " + key}
+}
+
+func TestLruCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLruCache(2, time.Hour)
+	c.set(lruEntryOf("a"))
+	c.set(lruEntryOf("b"))
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a isn't kept")
+	}
+	c.set(lruEntryOf("c")) // b is the least recently used now
+	if _, ok := c.get("b"); ok {
+		t.Error("b is kept over capacity")
+	}
+	for _, key := range []string{"a", "c"} {
+		if e, ok := c.get(key); !ok || e.Value != "value of "+key {
+			t.Errorf("get(%q) = %+v, %v", key, e, ok)
+		}
+	}
+
+	s := c.getStats()
+	want := lruStats{Size: 2, Capacity: 2, Hits: 3, Misses: 1, Evictions: 1}
+	if s != want {
+		t.Errorf("Stats %+v, want %+v", s, want)
+	}
+}
+
+func TestLruCacheUpdatesEntries(t *testing.T) {
+	c := newLruCache(2, time.Hour)
+	c.set(lruEntryOf("a"))
+	c.set(lruEntryOf("b"))
+	c.set(store.CacheEntry{Key: "a", Value: "new"}) // a is the most recently used now
+	c.set(lruEntryOf("c"))
+	if e, ok := c.get("a"); !ok || e.Value != "new" {
+		t.Errorf("get(a) = %+v, %v, want the new value", e, ok)
+	}
+	if _, ok := c.get("b"); ok {
+		t.Error("b is kept over capacity")
+	}
+	if s := c.getStats(); s.Size != 2 || s.Evictions != 1 {
+		t.Errorf("Stats %+v after updating an entry", s)
+	}
+}
+
+func TestLruCacheExpires(t *testing.T) {
+	c := newLruCache(10, time.Millisecond)
+	c.set(lruEntryOf("a"))
+	time.Sleep(5 * time.Millisecond)
+	if c.has("a") {
+		t.Error("has(a) after it expired")
+	}
+	if _, ok := c.get("a"); ok {
+		t.Error("get(a) after it expired")
+	}
+	if s := c.getStats(); s.Size != 0 || s.Expired != 1 || s.Misses != 1 {
+		t.Errorf("Stats %+v after an entry expired", s)
+	}
+}
+
+func TestLruCacheHasDoesNotCount(t *testing.T) {
+	c := newLruCache(2, time.Hour)
+	c.set(lruEntryOf("a"))
+	c.set(lruEntryOf("b"))
+	if !c.has("a") || c.has("x") {
+		t.Error("has() doesn't tell the kept entries")
+	}
+	// has() didn't make a recently used, so it is evicted first
+	c.set(lruEntryOf("c"))
+	if c.has("a") {
+		t.Error("has() counted as a use")
+	}
+	if s := c.getStats(); s.Hits != 0 || s.Misses != 0 {
+		t.Errorf("has() counted in the stats: %+v", s)
+	}
+}
+
+func TestLruCacheWithoutCapacity(t *testing.T) {
+	c := newLruCache(0, time.Hour)
+	c.set(lruEntryOf("a"))
+	if _, ok := c.get("a"); ok {
+		t.Error("A cache without capacity keeps entries")
+	}
+}
+
+func TestLruCacheClear(t *testing.T) {
+	c := newLruCache(10, time.Hour)
+	c.set(lruEntryOf("a"))
+	c.set(lruEntryOf("b"))
+	c.clear()
+	if c.has("a") || c.has("b") || c.getStats().Size != 0 {
+		t.Error("Entries are kept after clear()")
+	}
+	c.set(lruEntryOf("a"))
+	if !c.has("a") {
+		t.Error("The cache doesn't keep entries after clear()")
+	}
+}
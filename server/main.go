@@ -1,102 +1,1136 @@
 // Prg1: NPRG030
 // ADS2: NTIN061
 // Haskell: NAIL097
-package main
+package server
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/iamwave/samorozvrh/calendar"
+	"github.com/iamwave/samorozvrh/hook"
+	"github.com/iamwave/samorozvrh/objective"
 	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/source"
+	"github.com/iamwave/samorozvrh/store"
+	"github.com/iamwave/samorozvrh/travel"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"path"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
 const FRONTEND_DIR = "frontend/dist"
 
+// How long to wait for running requests when shutting down
+const SHUTDOWN_TIMEOUT = 30 * time.Second
+
 var rootDir string
 
+// Where course data come from, SIS by default (see -source)
+var courseSource source.Source
+var courseSourceName string
+
 func sisQueryHandler(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Path[len("/sisquery/"):]
-	if strings.Contains(query, "/") {
-		fmt.Fprintf(w, `{"error":"Query should not contain slashes"}`)
+	query := sisparse.NormalizeCourseCode(r.URL.Path[len("/sisquery/"):])
+	if !sisparse.IsCourseCode(query) {
+		fmt.Fprintf(w, `{"error":"Invalid course code"}`)
+		return
+	}
+
+	t, err := requestTerm(r)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	sem := t.Semester
+
+	ctx := sisparse.WithAcademicYear(r.Context(), t.Year)
+	if dryRun := r.URL.Query().Get("dry_run"); dryRun == "1" || dryRun == "true" {
+		logf(ctx, "Sisquery dry run: %s", ellipsis(query, 10))
+		fmt.Fprint(w, diagnoseCourse(ctx, query, sem))
+		return
+	}
+	logf(ctx, "Sisquery: %s", ellipsis(query, 10))
+	res, err := queryCourse(ctx, query, sem)
+	if err != nil {
+		logf(ctx, "Sisquery error: %s", err)
+		if err == ErrQuotaExceeded {
+			writeQuotaExceeded(w, ctx)
+			return
+		}
+		fmt.Fprint(w, courseErrorJSON(ctx, query, err))
+	} else {
+		logf(ctx, `Sisquery answer: %s`, ellipsis(res, 30))
+		res = withRenderHints(ctx, query, res)
+		etag, fetched := courseValidators(res)
+		writeConditional(w, r, res, etag, fetched)
+	}
+}
+
+// Answers /courseinfo/<code> with what is known about the course besides
+// its schedule, see source.CourseInfo.
+func courseInfoHandler(w http.ResponseWriter, r *http.Request) {
+	code := sisparse.NormalizeCourseCode(strings.TrimPrefix(r.URL.Path, "/courseinfo/"))
+	if code == "" || strings.Contains(code, "/") {
+		fmt.Fprint(w, `{"error":"Expected /courseinfo/<code>"}`)
 		return
 	}
+	t, err := requestTerm(r)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	sem := t.Semester
+
+	ctx := sisparse.WithAcademicYear(r.Context(), t.Year)
+	logf(ctx, "Course info: %s", ellipsis(code, 10))
+	// Everything we show can be made out of the events, which come from
+	// the cache or are fetched (and cached) as for /sisquery/
+	res, err := queryCourse(ctx, code, sem)
+	var course struct {
+		Data [][]sisparse.Event `json:"data"`
+	}
+	if err == nil {
+		err = json.Unmarshal([]byte(res), &course)
+	}
+	if err != nil {
+		logf(ctx, "Course info error: %s", err)
+		fmt.Fprint(w, courseErrorJSON(ctx, code, err))
+		return
+	}
+	s, _ := json.Marshal(source.InfoFromGroups(code, sem, course.Data))
+	res = fmt.Sprintf(`{"data":%s,"academic_year":%d,"semester":%d}`, string(s), t.Year, sem)
+	writeConditional(w, r, res, contentETag(res), time.Time{})
+}
+
+// Parses the course in SIS without touching the cache and returns the events
+// along with what the parser did with each row of the schedule, see
+// sisparse.Diagnostics.
+func diagnoseCourse(ctx context.Context, code string, sem int) string {
+	events, diag, err := sisparse.DiagnoseCourseEvents(ctx, code, sem)
+	res := map[string]interface{}{
+		"data":          events,
+		"diagnostics":   diag,
+		"academic_year": sisparse.AcademicYear(ctx),
+		"semester":      sem,
+	}
+	if err != nil {
+		res["error"] = err.Error()
+	}
+	s, err := json.Marshal(res)
+	if err != nil {
+		return fmt.Sprintf(`{"error":"%s"}`, err)
+	}
+	return string(s)
+}
+
+// Returns {"error":"...","error_code":"..."} (see localizedError) for an error
+// which occurred when querying a course; if the course wasn't found, similar codes are suggested in "suggestions".
+func courseErrorJSON(ctx context.Context, code string, err error) string {
+	res := localizedError(ctx, err)
+	if err == sisparse.ErrScheduleNotFound {
+		res["suggestions"] = suggestCourseCodes(ctx, code)
+	}
+	s, _ := json.Marshal(res)
+	return string(s)
+}
+
+// Returns the events of a course in the semester of the academic year of
+// ctx (see sisparse.AcademicYear) as a JSON response of the form
+// {"data":[[event, ...], ...],"academic_year":...,"semester":...,
+// "provenance":...} (see provenance), from the cache if possible. The override file of the course, if any, is
+// applied to it in the current academic year, and its aliases (see
+// withAliases) are added. Over the fetch budget of the
+// request (see withFetchBudget), the course is queued and ErrFetchQueued
+// returned.
+func queryCourse(ctx context.Context, code string, sem int) (string, error) {
 	var res string
 	var err error
+	countCourseRequest(ctx, code)
+	if cached, ok := getCachedCourse(ctx, code, sem); ok {
+		logf(ctx, "  %s (using cache)", code)
+		res = cached
+	} else if isMissing(courseCacheName(ctx, code, sem)) {
+		logf(ctx, "  %s (known to be missing)", code)
+		return "", sisparse.ErrScheduleNotFound
+	} else if hasFetchBudget(ctx) && !sisparse.IsPaused() && (fetches.isPending(ctx, code, sem) || !takeFetch(ctx)) {
+		logf(ctx, "  %s (queued)", code)
+		fetches.add(ctx, code, sem)
+		return "", ErrFetchQueued
+	} else if err = fetchQuota.use(ctx, 1); err == nil {
+		logf(ctx, "  %s (querying)", code)
+		res, err = fetchCourse(ctx, code, sem)
+	}
+	if err != nil {
+		return res, err
+	}
+	if sisparse.AcademicYear(ctx) != currentTerm(ctx).Year {
+		// Overrides are about what is taught now
+		return res, nil
+	}
+	return withAliases(ctx, code, applyOverride(ctx, code, sem, res)), nil
+}
+
+// Winter semester courses are cached under their code (as they were before
+// summer semester courses were supported), the others under "<code>@<semester>".
+func getCourseCacheName(code string, sem int) string {
+	if sem == sisparse.Winter {
+		return code
+	}
+	return fmt.Sprintf("%s@%d", code, sem)
+}
+
+// Courses of other academic years than the current one are cached under
+// "<name>~<year>", where <name> is given by getCourseCacheName, and those
+// of tenants apart (see tenantCacheName).
+func courseCacheName(ctx context.Context, code string, sem int) string {
+	name := getCourseCacheName(code, sem)
+	if year := sisparse.AcademicYear(ctx); year != currentTerm(ctx).Year {
+		name = fmt.Sprintf("%s~%d", name, year)
+	}
+	return tenantCacheName(ctx, name)
+}
+
+// Returns the cached answer for the course, unless it is of another
+// academic year than that of ctx (which happens when it was cached before
+// the academic year rolled over) or older than -cache-ttl. An answer which
+// is about to get too old is fetched again in the background, see
+// -refresh-ahead. Past academic years don't change, so their answers
+// don't get old. The answer's provenance tells whether it is due to be
+// fetched again.
+func getCachedCourse(ctx context.Context, code string, sem int) (string, bool) {
+	e, err := getCacheEntry(courseCacheName(ctx, code, sem))
+	if err != nil {
+		if err != store.ErrNotFound {
+			logf(ctx, "Cache error: %s", err)
+		}
+		return "", false
+	}
+	res := e.Value
+	status := CACHE_FRESH
+	if courseCacheTtl > 0 && sisparse.AcademicYear(ctx) >= currentTerm(ctx).Year {
+		age := time.Since(e.Updated)
+		if age > courseCacheTtl {
+			return "", false
+		}
+		if age > time.Duration(refreshAhead*float64(courseCacheTtl)) {
+			status = CACHE_STALE
+			if !sisparse.IsPaused() {
+				logf(ctx, "  %s (refreshing ahead)", code)
+				fetches.add(ctx, code, sem)
+			}
+		}
+	}
+	var cached struct {
+		Year int `json:"academic_year"`
+	}
+	if json.Unmarshal([]byte(res), &cached) != nil || cached.Year != sisparse.AcademicYear(ctx) {
+		return "", false
+	}
+	return withCacheStatus(res, status, e.Updated), true
+}
+
+// Concurrent fetches of the same course, keyed by courseCacheName
+var courseFetches singleflight.Group
+
+// Like queryCourse, but always asks SIS (and updates the cache).
+// Concurrent calls for the same course share a single request to SIS.
+func fetchCourse(ctx context.Context, code string, sem int) (string, error) {
+	// The fetch is shared, so it mustn't be canceled with the request which
+	// started it; it is still traced as a part of it
+	fetchCtx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+	fetchCtx = withTenant(fetchCtx, tenantOf(ctx))
+	fetchCtx = sisparse.WithAcademicYear(fetchCtx, sisparse.AcademicYear(ctx))
+	name := courseCacheName(ctx, code, sem)
+	res, err, shared := courseFetches.Do(name, func() (interface{}, error) {
+		return doFetchCourse(fetchCtx, code, sem)
+	})
+	if shared {
+		logf(ctx, "  %s (shared a concurrent query)", code)
+	}
+	if err == sisparse.ErrCircuitOpen || err == sisparse.ErrPaused {
+		if e, cacheErr := getCacheEntry(name); cacheErr == nil {
+			logf(ctx, "  %s (SIS is down or paused, using cache)", code)
+			return withCacheStatus(e.Value, CACHE_STALE, e.Updated), nil
+		}
+	}
+	if err != nil {
+		return res.(string), err
+	}
+	return withCacheStatus(res.(string), CACHE_MISS, time.Now()), nil
+}
+
+func doFetchCourse(ctx context.Context, code string, sem int) (string, error) {
+	ctx, record := sisparse.WithFetchRecord(ctx)
+	events, err := courseSource.GetCourseEvents(ctx, code, sem)
+	if err == sisparse.ErrScheduleNotFound {
+		if err := setMissing(courseCacheName(ctx, code, sem)); err != nil {
+			logf(ctx, "Cache error: %s", err)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	s, err := json.Marshal(events)
+	if err != nil {
+		return "", err
+	}
+	p, _ := json.Marshal(newProvenance(record))
+	year := sisparse.AcademicYear(ctx)
+	res := fmt.Sprintf(`{"data":%s,"academic_year":%d,"semester":%d,"provenance":%s}`, string(s), year, sem, string(p))
+	name := courseCacheName(ctx, code, sem)
+	if year == currentTerm(ctx).Year {
+		keepPastYear(ctx, code, sem)
+		tenantOf(ctx).index.add(code, events)
+		// Subscriptions are by course code, which only the default
+		// tenant's courses are known by
+		if notificationsEnabled() && tenantOf(ctx) == defaultTenant {
+			if cached, err := getCache(name); err == nil {
+				noticeCourseChange(code, cached, events)
+			}
+		}
+	}
+	return res, setCache(name, res)
+}
+
+// The answer of the current academic year is cached under the name of the
+// course alone, so after the year rolls over, the answer of the past year
+// is moved to its own name (see courseCacheName) before being replaced.
+func keepPastYear(ctx context.Context, code string, sem int) {
+	cached, err := getCache(courseCacheName(ctx, code, sem))
+	if err != nil {
+		return
+	}
+	var old struct {
+		Year int `json:"academic_year"`
+	}
+	if json.Unmarshal([]byte(cached), &old) != nil || old.Year == 0 || old.Year >= sisparse.AcademicYear(ctx) {
+		return
+	}
+	name := courseCacheName(sisparse.WithAcademicYear(ctx, old.Year), code, sem)
+	if isCached(name) {
+		return
+	}
+	if err := setCache(name, cached); err != nil {
+		logf(ctx, "Cache error: %s", err)
+	}
+}
 
-	log.Printf("Sisquery: %s", ellipsis(query, 10))
-	if isCached(query) {
+func studyPlanHandler(w http.ResponseWriter, r *http.Request) {
+	// URLs of the form /studyplan/<program>/<year>
+	parts := strings.Split(r.URL.Path[len("/studyplan/"):], "/")
+	if len(parts) != 2 {
+		fmt.Fprintf(w, `{"error":"Expected /studyplan/<program>/<year>"}`)
+		return
+	}
+	program := parts[0]
+	year, err := strconv.Atoi(parts[1])
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"Invalid year: %s"}`, parts[1])
+		return
+	}
+	cacheName := tenantCacheName(r.Context(), fmt.Sprintf("studyplan-%s-%d", program, year))
+	var res string
+
+	log.Printf("Studyplan: %s %d", ellipsis(program, 10), year)
+	if isCached(cacheName) {
 		log.Println("  (using cache)")
-		res, err = getCache(query)
+		res, err = getCache(cacheName)
 	} else {
 		log.Println("  (querying)")
-		var events [][]sisparse.Event
-		events, err = sisparse.GetCourseEvents(query)
+		var plan sisparse.StudyPlan
+		plan, err = sisparse.GetStudyPlanContext(r.Context(), program, year)
 		if err == nil {
 			var s []byte
-			s, err = json.Marshal(events)
+			s, err = json.Marshal(plan)
 			if err == nil {
 				res = fmt.Sprintf(`{"data":%s}`, string(s))
-				err = setCache(query, res)
+				err = setCache(cacheName, res)
 			}
 		}
 	}
 
 	if err != nil {
-		log.Printf("Sisquery error: %s", err)
+		log.Printf("Studyplan error: %s", err)
 		fmt.Fprintf(w, `{"error":"%s"}`, err)
 	} else {
-		log.Printf(`Sisquery answer: %s`, ellipsis(res, 30))
+		log.Printf(`Studyplan answer: %s`, ellipsis(res, 30))
 		fmt.Fprint(w, res)
 	}
 }
 
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	semester := tenantOf(r.Context()).semester
+	if semester == nil {
+		fmt.Fprint(w, `{"error":"No academic calendar is configured"}`)
+		return
+	}
+	s, err := json.Marshal(semester)
+	if err != nil {
+		log.Printf("Calendar error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	// Either /admin/snapshots/<code> to list the snapshots of a course,
+	// or /admin/snapshots/<code>/<name> to get the HTML of one of them
+	parts := strings.Split(r.URL.Path[len("/admin/snapshots/"):], "/")
+	if len(parts) == 1 {
+		snapshots, err := listSnapshots(parts[0])
+		if err == nil {
+			var s []byte
+			s, err = json.Marshal(snapshots)
+			if err == nil {
+				fmt.Fprintf(w, `{"data":%s}`, string(s))
+				return
+			}
+		}
+		log.Printf("Snapshot error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	_, body, err := readSnapshot(parts[0], parts[1])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(body)
+}
+
+func mirrorsHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := json.Marshal(sisparse.GetMirrorStatus())
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func breakerHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := json.Marshal(sisparse.GetBreakerStatus())
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
 func solverQueryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	body, err := ioutil.ReadAll(r.Body)
 	r.Body.Close()
 	if err != nil {
-		log.Printf("Solverquery error: %s", err)
+		logf(ctx, "Solverquery error: %s", err)
 		fmt.Fprintf(w, `{"error":"%s"}`, err)
 		return
 	}
 	if len(body) == 0 {
-		log.Printf("Solverquery error: %s", err)
+		logf(ctx, "Solverquery error: %s", err)
 		fmt.Fprint(w, `{"error":"Request body must be non-empty"}`)
 		return
 	}
 
-	log.Printf("Solverquery: %s\n", ellipsis(string(body), 30))
-	res, err := Solve(body)
-	if err != nil {
-		log.Printf("Solverquery error: %s", err)
+	logf(ctx, "Solverquery: %s\n", ellipsis(string(body), 30))
+	job := startJob(body, requestUserHash(r))
+	res, err := solveWithRules(ctx, body)
+	if solverContext.Err() != nil {
+		// Killed because of a shutdown; the job stays marked as interrupted
+		fmt.Fprint(w, `{"error":"The server is shutting down, try again later"}`)
+		return
+	}
+	finishJob(job, res, err)
+	if err == ErrQuotaExceeded {
+		writeQuotaExceeded(w, ctx)
+	} else if err != nil {
+		logf(ctx, "Solverquery error: %s", err)
 		fmt.Fprintf(w, `{"error":"%s"}`, err)
 	} else {
-		log.Printf("Solverquery answer: %s", ellipsis(string(res), 30))
+		logf(ctx, "Solverquery answer: %s", ellipsis(string(res), 30))
 		fmt.Fprint(w, string(res))
 	}
 }
 
-func main() {
+// Runs the server as the flags say. cmd/server is only this.
+func Main() {
+	run(false)
+}
+
+// Runs only the crawler (see crawler.go), as Main does with -crawler.
+// cmd/samorozvrh-crawler is only this.
+func CrawlerMain() {
+	run(true)
+}
+
+func run(crawler bool) {
 	rdir := flag.String("rootdir", ".", "path to Samorozvrh root directory")
 	port := flag.Int("port", 8080, "port on which to start the server")
+	grpcPort := flag.Int("grpc-port", 0, "port on which to also serve the gRPC API (see api/samorozvrh.proto), 0 not to serve it")
+	layoutFallback := flag.Bool("layout-fallback", false, "try to parse SIS pages whose layout has changed by guessing the columns")
+	strictParsing := flag.Bool("strict-parsing", false, "fail courses with a malformed row in their schedule instead of skipping the row")
+	snapshots := flag.Bool("snapshots", false, "keep the HTML of fetched SIS pages for debugging")
+	dumpSnapshotsOf := flag.String("dump-snapshots", "", "print the kept SIS pages of the given course and exit")
+	massFile := flag.String("mass", "", "find schedules for the courses of each student in the given file (CSV or JSON, relative to rootdir, see mass.go) in the current semester, print the statistics as JSON and exit")
+	massDemandCsv := flag.String("mass-demand-csv", "", "with -mass, also write the demand for each group (see demand.go) as CSV to this file (relative to rootdir)")
+	diagnose := flag.String("diagnose", "", "parse the given course (<code> or <code>@<semester>) in SIS, print what the parser did with each row and exit")
+	var sisUrls stringList
+	flag.Var(&sisUrls, "sis-url", "base URL of SIS; repeat to add mirrors to fall back to, the primary one first")
+	proxy := flag.String("proxy", "", "HTTP or SOCKS5 proxy for requests to SIS (e.g. socks5://localhost:1080)")
+	dbDriver := flag.String("db-driver", store.SQLITE, "database to store data in: sqlite3 or postgres")
+	dbDsn := flag.String("db", "samorozvrh.db", "database file (relative to rootdir) for sqlite3, connection string for postgres")
+	exportDatasetTo := flag.String("export-dataset", "", "write the cached courses of the current semester as a dataset (see dataset.go) to the given file, as CSV if it ends in .csv and else as JSON, and exit")
+	datasetSaltFlag := flag.String("dataset-salt", "", "the key of the pseudonyms of teachers in datasets, so that they stay the same between runs (a random key by default)")
+	exportCacheTo := flag.String("export-cache", "", "export the cache to the given file and exit")
+	importCacheFrom := flag.String("import-cache", "", "import the cache from the given file (made by -export-cache) and exit")
+	backupDir := flag.String("backup", "", "back up the cache, saved schedules and profiles to a new file in the given directory and exit")
+	restoreFrom := flag.String("restore", "", "restore the cache, saved schedules and profiles from the given backup (made by -backup) and exit")
+	diffCatalogFrom := flag.String("diff-catalog", "", "print the courses whose schedules changed since the cache was exported to the given file and exit")
+	diffCatalogTo := flag.String("diff-catalog-to", "", "compare -diff-catalog with this exported cache instead of the current one")
+	benchmark := flag.Bool("benchmark", false, "time the solver and the parser on the course sets in "+BENCHMARK_DIR+", print the times and exit, failing if any is over its budget")
+	doctor := flag.Bool("doctor", false, "fetch, parse and solve known courses from the live SIS, print which checks passed (see doctor.go) and exit, failing if any didn't; the same as the command doctor")
+	var doctorCourseFlags stringList
+	flag.Var(&doctorCourseFlags, "doctor-course", "a course (<code> or <code>@<semester>) for -doctor to check instead of the default ones; may be repeated")
+	benchmarkRuns := flag.Int("benchmark-runs", 5, "how many times -benchmark runs each course set (the median run counts)")
+	migrateDryRun := flag.Bool("migrate-dry-run", false, "list the database migrations which would be run and exit")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "send traces over OTLP/HTTP to this host:port (e.g. localhost:4318)")
+	var corsOrigins stringList
+	flag.Var(&corsOrigins, "cors-origin", "allow browsers to call the API from this origin (e.g. https://example.com, or * for any); repeat for more origins")
+	corsMethods := flag.String("cors-methods", "GET,POST,OPTIONS", "comma-separated HTTP methods allowed for cross-origin requests")
+	frontendDir := flag.String("frontend", FRONTEND_DIR, "directory with the webapp to serve (relative to rootdir)")
+	spaFallback := flag.Bool("spa-fallback", false, "serve index.html of -frontend for unknown paths without an extension, for webapps routing by the history API")
+	gzipResponses := flag.Bool("gzip", false, "compress JSON responses for clients which accept it")
+	var apiKeys stringList
+	flag.Var(&apiKeys, "api-key", "accept the static API token given as <token>:<scope>[,<scope>...]; repeat for more tokens")
+	createTokenFor := flag.String("create-token", "", "issue an API token with the given name, print it and exit")
+	tokenScopes := flag.String("token-scopes", SCOPE_ADMIN, "comma-separated scopes of the token made by -create-token")
+	revokeTokenFlag := flag.String("revoke-token", "", "delete the given API token (or the token with the given hash) from the database and exit")
+	sisLogin := flag.Bool("sis-login", false, "let students log in to SIS to import their enrolled courses")
+	caldav := flag.Bool("caldav", false, "let students push saved schedules to CalDAV calendars")
+	smtpAddr := flag.String("smtp", "", "send email notifications through the SMTP server at this host:port")
+	smtpFrom := flag.String("smtp-from", "samorozvrh@localhost", "sender of the email notifications")
+	smtpUser := flag.String("smtp-user", "", "user to log in to the SMTP server as, if it needs a login")
+	smtpPasswordFile := flag.String("smtp-password-file", "", "file with the password of -smtp-user (relative to rootdir)")
+	publicUrlFlag := flag.String("public-url", "", "URL users reach the server at (e.g. https://samorozvrh.example), for the links in emails")
+	telegramTokenFile := flag.String("telegram-token-file", "", "file with the token of the Telegram bot to run (relative to rootdir); only one instance may run it")
+	discordPublicKey := flag.String("discord-public-key", "", "public key of the Discord application whose commands to answer at /bots/discord")
+	discordTokenFile := flag.String("discord-token-file", "", "file with the token of the Discord bot user, to notify channels (relative to rootdir)")
+	discordAppId := flag.String("discord-app-id", "", "ID of the Discord application, to register its commands at start (needs -discord-token-file)")
+	memoryCacheSize := flag.Int("memory-cache", DEFAULT_MEMORY_CACHE_SIZE, "number of recently used cache entries to also keep in memory (0 to disable)")
+	memoryCacheTtl := flag.Duration("memory-cache-ttl", DEFAULT_MEMORY_CACHE_TTL, "how long entries are kept in the memory cache")
+	cacheTtl := flag.Duration("cache-ttl", 0, "how long cached courses are served before they are fetched from SIS again (0 for as long as they are of the current academic year)")
+	refreshAheadFlag := flag.Float64("refresh-ahead", DEFAULT_REFRESH_AHEAD, "fraction of -cache-ttl after which a cached course which is served is fetched again in the background")
+	negativeCacheTtlFlag := flag.Duration("negative-cache-ttl", DEFAULT_NEGATIVE_CACHE_TTL, "how long a course which SIS doesn't know is answered as not found without asking SIS (0 to always ask)")
+	instance := flag.String("instance-id", "", "name of this instance among the servers sharing the database (the hostname by default)")
+	breakerThreshold := flag.Int("breaker-threshold", sisparse.DefaultBreakerThreshold, "stop querying SIS for a while after this many consecutive failures (0 to never stop)")
+	breakerCooldown := flag.Duration("breaker-cooldown", sisparse.DefaultBreakerCooldown, "how long to stop querying SIS after the failures")
+	sisPaused := flag.Bool("sis-paused", false, "make no requests to SIS at all and serve only cached courses (pausing is also possible at /admin/sis)")
+	sisRecord := flag.String("sis-record", "", "save every response of SIS to this directory (relative to rootdir), to be served by -sis-replay later")
+	sisReplay := flag.String("sis-replay", "", "answer requests to SIS by the responses saved by -sis-record in this directory (relative to rootdir) instead of asking SIS")
+	parserShadow := flag.String("parser-shadow", "", "also parse every schedule page fetched from SIS by this parser (strict or layout-fallback) and log and count how it differs from ours, see /admin/shadow")
+	chaos := flag.String("chaos", "", "inject faults into the requests to SIS to test how failures are handled, e.g. delay=0.2:3s,error=0.05,truncate=0.02 (the rates of delays up to 3s, of 503 errors and of truncated pages); never in production")
+	sisRate := flag.Float64("sis-rate", DEFAULT_SIS_RATE, "the most requests per second to SIS, counted over all servers sharing the database, e.g. 0.5 for one every two seconds (0 for no limit)")
+	sisBurst := flag.Int("sis-burst", 0, "how many requests to SIS may be made at once within the -sis-rate (as many as the rate by default)")
+	fetchBudget := flag.Int("fetch-budget", DEFAULT_REQUEST_FETCH_BUDGET, "how many uncached courses a request for several courses may fetch from SIS itself; the rest are fetched in the background and the request is told to retry (0 for no limit)")
+	solveQuotaFlag := flag.Int("solve-quota", 0, "how many CPU seconds of the solver each API token (or client address, without a token) may use in an hour (0 for no limit)")
+	fetchQuotaFlag := flag.Int("fetch-quota", 0, "how many courses each API token (or client address, without a token) may fetch from SIS in a day (0 for no limit)")
+	globalFetchBudgetFlag := flag.Int("global-fetch-budget", 0, "how many courses all the requests for several courses may fetch from SIS in a minute, counted over all servers sharing the database (0 for no limit)")
+	crawlWindowFlag := flag.String("crawl-window", "", "only make background requests to SIS (crawling, watching courses) at these times in Prague, e.g. 22:00-06:00")
+	var hookSpecs stringList
+	flag.Var(&hookSpecs, "hook", "apply the compiled-in hook given as <name>[:<config>] to solve requests and their schedules, one of "+strings.Join(hook.Names(), ", ")+"; repeat for more")
+	var objectiveSpecs stringList
+	flag.Var(&objectiveSpecs, "objective", "also judge schedules by the compiled-in objective given as <name>:<weight>[:<config>], one of "+strings.Join(objective.Names(), ", ")+"; repeat for more")
+	rolloverFlag := flag.String("rollover-dates", "", "days from which the winter and the summer semester are current when the academic calendar doesn't say, as MM-DD,MM-DD (08-01,02-01 by default)")
+	prewarmFlag := flag.Duration("prewarm", 0, "how long before the semester changes to fetch its popular and cached courses in the background (0 to never)")
+	fillSampleInterval := flag.Duration("fill-sample-interval", 0, "how often to record how full the groups of the cached courses are, fetching them again (0 to never)")
+	searchReindex := flag.Duration("search-reindex", DEFAULT_SEARCH_REINDEX_INTERVAL, "how often to bring the search index up to date with the courses cached by the crawler and the other instances (0 to never)")
+	crawlerMode := flag.Bool("crawler", false, "only crawl the cached courses every -fill-sample-interval (see crawler.go), coordinating with the other instances sharing the database, instead of serving the API")
+	sourceName := flag.String("source", source.SIS, "where to get course data from, one of "+strings.Join(source.Names(), ", "))
+	sourceConfig := flag.String("source-config", "", "configuration of the source (e.g. a directory), if it needs any")
+	overrides := flag.String("overrides", "overrides", "directory with corrections of course data (relative to rootdir)")
+	calendarFile := flag.String("calendar", "calendar.json", "academic calendar of the current semester (relative to rootdir)")
+	travelFile := flag.String("travel", "", "travel times between buildings in minutes and where the buildings are (relative to rootdir), see travel.json")
+	travelRouterUrl := flag.String("travel-router", "", "URL of the table service of an OSRM router to compute the travel times between buildings which -travel lacks by their coordinates, e.g. https://routing.openstreetmap.de/routed-foot/table/v1/foot")
+	stats := flag.Bool("stats", false, "count anonymously which courses are requested and how long the solver runs, see /admin/stats")
+	solverMemory := flag.Int("solver-memory", 0, "megabytes a solver run may take; queries which would take more are solved by a beam search, which finds good but not always the best schedules (0 for no limit)")
+	beamWidth := flag.Int("solver-beam-width", 0, "how many partial schedules the beam search keeps (the solver's default of 64 if 0)")
+	slotMinutes := flag.Int("solver-slot-minutes", 0, "compare the times of options on a grid of this many minutes first, which speeds up the solver's conflict matrix of big queries (e.g. 5; 0 compares all events)")
+	bundlesFile := flag.String("bundles", "", "course bundles students can prefill their courses with (relative to rootdir), see bundles.go")
+	tenantsFile := flag.String("tenants", "", "configuration of other faculties served by this instance (relative to rootdir), see tenants.go")
+	accessLog := flag.String("access-log", "", "write a JSON line for each request to this file (relative to rootdir), - for stderr")
+	accessLogIp := flag.String("access-log-ip", IP_TRUNCATE, "how to log the IPs of clients: "+strings.Join(ipModes, ", "))
+	trustedProxiesFlag := flag.Int("trusted-proxies", 0, "how many proxies in front of the server append the client's address to X-Forwarded-For; the address appended by the outermost one is taken as the client's in the access log and the quotas (0 takes the address of the connection)")
+	accessLogForwarded := flag.Bool("access-log-forwarded", false, "deprecated, the same as -trusted-proxies 1")
+	retainAccessLog := flag.Duration("retain-access-log", 0, "start a new -access-log file each day and remove those older than this (0 to write one file for ever)")
+	retainSchedules := flag.Duration("retain-schedules", 0, "drop saved schedules this long after they were saved, e.g. 8760h (0 to keep them for ever)")
+	retainJobs := flag.Duration("retain-jobs", 0, "drop the requests and results of finished or interrupted solver runs (the solve history of users) this long after they finished (0 to keep them for ever)")
+	accessLogSample := flag.String("access-log-sample", "", "fractions of the requests to log by path prefix, e.g. /healthz=0,/courseinfo/=0.1 (failed requests are always logged)")
 	flag.Parse()
 	rootDir = *rdir
+	overridesDir = path.Join(rootDir, *overrides)
+	sisLoginEnabled = *sisLogin
+	caldavEnabled = *caldav
+	smtpServer.Addr, smtpServer.From, smtpServer.User = *smtpAddr, *smtpFrom, *smtpUser
+	publicUrl = *publicUrlFlag
+	statsEnabled = *stats
+	if *rolloverFlag != "" {
+		var err error
+		if rollover, err = parseRolloverDates(*rolloverFlag); err != nil {
+			log.Fatalf("Invalid -rollover-dates: %s", err)
+		}
+	}
+	prewarmAhead = *prewarmFlag
+	solverMemoryLimit, solverBeamWidth = *solverMemory, *beamWidth
+	if *slotMinutes < 0 || *slotMinutes > 0 && 24*60%*slotMinutes != 0 {
+		log.Fatalf("The slots of -solver-slot-minutes must divide a day, got %d minutes", *slotMinutes)
+	}
+	solverSlotMinutes = *slotMinutes
+	memoryCache = newLruCache(*memoryCacheSize, *memoryCacheTtl)
+	requestFetchBudget, globalFetchBudget = *fetchBudget, *globalFetchBudgetFlag
+	solveQuota.Limit, fetchQuota.Limit = *solveQuotaFlag, *fetchQuotaFlag
+	if *refreshAheadFlag <= 0 || *refreshAheadFlag > 1 {
+		log.Fatal("-refresh-ahead must be more than 0 and at most 1")
+	}
+	courseCacheTtl, refreshAhead = *cacheTtl, *refreshAheadFlag
+	negativeCacheTtl = *negativeCacheTtlFlag
+	instanceId = *instance
+	if instanceId == "" {
+		instanceId, _ = os.Hostname()
+	}
+	var err error
+	var parser *sisparse.Parser
+	sisOptions := []sisparse.Option{
+		sisparse.Proxy(*proxy),
+		sisparse.Breaker(*breakerThreshold, *breakerCooldown),
+		sisparse.LayoutFallback(*layoutFallback),
+		sisparse.TermDetector(detectTerm),
+	}
+	if len(sisUrls) > 0 {
+		sisOptions = append(sisOptions, sisparse.BaseUrls(sisUrls...))
+	}
+	if *strictParsing {
+		sisOptions = append(sisOptions, sisparse.DefaultMode(sisparse.Strict))
+	}
+	if *snapshots {
+		sisOptions = append(sisOptions, sisparse.Snapshots(saveSnapshot))
+	}
+	if *sisRecord != "" && *sisReplay != "" {
+		log.Fatal("Either -sis-record or -sis-replay can be given, not both")
+	} else if *sisRecord != "" {
+		sisOptions = append(sisOptions, sisparse.Record(path.Join(rootDir, *sisRecord)))
+	} else if *sisReplay != "" {
+		log.Printf("Answering requests to SIS by the recordings in %s", *sisReplay)
+		sisOptions = append(sisOptions, sisparse.Replay(path.Join(rootDir, *sisReplay)))
+	}
+	if *chaos != "" {
+		faults, err := sisparse.ParseChaos(*chaos)
+		if err != nil {
+			log.Fatalf("Invalid -chaos: %s", err)
+		}
+		log.Printf("Injecting faults into the requests to SIS: %s", faults)
+		sisOptions = append(sisOptions, sisparse.Chaos(faults))
+	}
+	if *parserShadow != "" {
+		option, err := shadowOption(*parserShadow)
+		if err != nil {
+			log.Fatalf("Invalid -parser-shadow: %s", err)
+		}
+		log.Printf("Comparing parser %s with ours", *parserShadow)
+		sisOptions = append(sisOptions, option)
+	}
+	if *sisRate > 0 || *crawlWindowFlag != "" {
+		// Counted in the database, which is connected below before any
+		// request to SIS is made
+		limiter := sharedRateLimiter{key: "sis-requests", perSecond: *sisRate, burst: *sisBurst}
+		if *crawlWindowFlag != "" {
+			if limiter.crawlWindow, err = parseCrawlWindow(*crawlWindowFlag); err != nil {
+				log.Fatal(err)
+			}
+		}
+		sisOptions = append(sisOptions, sisparse.RateLimit(limiter))
+	}
+	parser, err = sisparse.NewParser(sisOptions...)
+	if err != nil {
+		log.Fatalf("Invalid SIS settings: %s", err)
+	}
+	sisparse.SetDefault(parser)
+	if courseSource, err = source.Open(*sourceName, *sourceConfig); err != nil {
+		log.Fatalf("Could not open course source: %s", err)
+	}
+	courseSourceName = *sourceName
+	for _, spec := range objectiveSpecs {
+		if err := addObjective(spec); err != nil {
+			log.Fatalf("Invalid objective: %s", err)
+		}
+	}
+	for _, spec := range hookSpecs {
+		if err := addHook(spec); err != nil {
+			log.Fatalf("Invalid hook: %s", err)
+		}
+	}
+	for _, key := range apiKeys {
+		if err := addStaticToken(key); err != nil {
+			log.Fatalf("Invalid API key: %s", err)
+		}
+	}
+
+	if *benchmark {
+		ok, err := runBenchmark(os.Stdout, path.Join(rootDir, BENCHMARK_DIR), *benchmarkRuns)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !ok {
+			log.Fatal("Over the performance budget")
+		}
+		return
+	}
+
+	if *dumpSnapshotsOf != "" {
+		if err := dumpSnapshots(os.Stdout, *dumpSnapshotsOf); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	flushTraces, err := setupTracing(*otlpEndpoint)
+	if err != nil {
+		log.Fatalf("Could not set up tracing: %s", err)
+	}
+	defer flushTraces()
+
+	dsn := *dbDsn
+	if *dbDriver == store.SQLITE {
+		dsn = path.Join(rootDir, dsn)
+	}
+	sqlStore, err := store.Connect(*dbDriver, dsn)
+	if err != nil {
+		log.Fatalf("Could not open database: %s", err)
+	}
+	applied, err := sqlStore.Migrate(*migrateDryRun)
+	for _, m := range applied {
+		if *migrateDryRun {
+			log.Printf("Would apply migration %d: %s", m.Version, m.Name)
+		} else {
+			log.Printf("Applied migration %d: %s", m.Version, m.Name)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Could not migrate database: %s", err)
+	}
+	if *migrateDryRun {
+		sqlStore.Close()
+		return
+	}
+	db = sqlStore
+	sisPausedByConfig = *sisPaused
+	if p, err := loadSisPause(); err != nil {
+		log.Printf("Could not check whether SIS is paused: %s", err)
+	} else if p.Paused {
+		log.Printf("Requests to SIS are paused (%s)", p.Reason)
+	}
+
+	if *doctor || flag.Arg(0) == "doctor" {
+		courses := doctorCourses
+		if len(doctorCourseFlags) > 0 {
+			courses = doctorCourseFlags
+		}
+		ok := runDoctor(context.Background(), os.Stdout, courses, isTerminal(os.Stdout))
+		db.Close()
+		if !ok {
+			log.Fatal("Some checks failed")
+		}
+		return
+	}
+
+	if *diagnose != "" {
+		code, sem, err := parseCourseTerm(context.Background(), *diagnose)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(diagnoseCourse(context.Background(), code, sem))
+		db.Close()
+		return
+	}
+
+	if *massFile != "" {
+		data, err := ioutil.ReadFile(path.Join(rootDir, *massFile))
+		var students []massStudent
+		if err == nil {
+			students, err = parseMassStudents(data)
+		}
+		if err != nil {
+			log.Fatalf("Could not read students: %s", err)
+		}
+		ctx := context.Background()
+		report := runMass(ctx, students, currentTerm(ctx).Semester)
+		if *massDemandCsv != "" {
+			f, err := os.Create(path.Join(rootDir, *massDemandCsv))
+			if err == nil {
+				err = writeDemandCSV(f, report.Groups)
+				f.Close()
+			}
+			if err != nil {
+				log.Fatalf("Could not write the demand: %s", err)
+			}
+		}
+		res, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(res))
+		db.Close()
+		return
+	}
+
+	datasetSalt = *datasetSaltFlag
+	if *exportDatasetTo != "" {
+		err := exportDatasetToFile(*exportDatasetTo)
+		db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *createTokenFor != "" {
+		scopes, err := parseScopes(*tokenScopes)
+		if err == nil {
+			var token string
+			token, err = createToken(*createTokenFor, scopes)
+			if err == nil {
+				fmt.Println(token)
+			}
+		}
+		db.Close()
+		if err != nil {
+			log.Fatalf("Could not create token: %s", err)
+		}
+		return
+	}
+
+	if *revokeTokenFlag != "" {
+		err := revokeToken(*revokeTokenFlag)
+		db.Close()
+		if err == store.ErrNotFound {
+			log.Fatal("No such token")
+		} else if err != nil {
+			log.Fatalf("Could not revoke token: %s", err)
+		}
+		log.Print("Token revoked")
+		return
+	}
+
+	if *diffCatalogFrom != "" {
+		diff, err := diffCatalogFiles(*diffCatalogFrom, *diffCatalogTo)
+		db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%d courses changed, %d added, %d removed, %d unchanged",
+			len(diff.Changed), len(diff.Added), len(diff.Removed), diff.Unchanged)
+		s, _ := json.MarshalIndent(diff, "", "  ")
+		fmt.Println(string(s))
+		return
+	}
+
+	if *backupDir != "" || *restoreFrom != "" {
+		if *backupDir != "" {
+			err = backupToDir(*backupDir)
+		} else {
+			err = restoreFromFile(*restoreFrom)
+		}
+		db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *exportCacheTo != "" || *importCacheFrom != "" {
+		if *exportCacheTo != "" {
+			err = exportCacheToFile(*exportCacheTo)
+		} else {
+			err = importCacheFromFile(*importCacheFrom)
+		}
+		db.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	defaultTenant.semester, err = calendar.LoadSemester(path.Join(rootDir, *calendarFile))
+	if err != nil {
+		log.Printf("Could not load academic calendar: %s", err)
+	}
+	if *travelRouterUrl != "" {
+		travelRouter = travel.NewOSRM(*travelRouterUrl)
+	}
+	if *travelFile != "" {
+		d, err := loadTravelTimes(*travelFile)
+		if err != nil {
+			log.Fatalf("Could not load travel times: %s", err)
+		}
+		defaultTenant.travel, defaultTenant.buildings = d.Minutes, d.Buildings
+	}
+	if *bundlesFile != "" {
+		if defaultTenant.bundles, err = loadBundles(*bundlesFile); err != nil {
+			log.Fatalf("Could not load bundles: %s", err)
+		}
+	}
+	if *smtpPasswordFile != "" {
+		password, err := ioutil.ReadFile(path.Join(rootDir, *smtpPasswordFile))
+		if err != nil {
+			log.Fatalf("Could not read the SMTP password: %s", err)
+		}
+		smtpServer.Password = strings.TrimSpace(string(password))
+	}
+	var telegram *telegramBot
+	if *telegramTokenFile != "" {
+		token, err := ioutil.ReadFile(path.Join(rootDir, *telegramTokenFile))
+		if err != nil {
+			log.Fatalf("Could not read the Telegram token: %s", err)
+		}
+		telegram = newTelegramBot(strings.TrimSpace(string(token)))
+	}
+	if *discordPublicKey != "" {
+		var token []byte
+		if *discordTokenFile != "" {
+			if token, err = ioutil.ReadFile(path.Join(rootDir, *discordTokenFile)); err != nil {
+				log.Fatalf("Could not read the Discord token: %s", err)
+			}
+		}
+		if discord, err = newDiscordBot(*discordPublicKey, strings.TrimSpace(string(token))); err != nil {
+			log.Fatal(err)
+		}
+		if *discordAppId != "" {
+			if err := discord.registerCommands(*discordAppId); err != nil {
+				log.Printf("Could not register the Discord commands: %s", err)
+			}
+		}
+	}
+	if emailEnabled() {
+		if publicUrl == "" {
+			log.Fatal("Email notifications (-smtp) need -public-url for the links in the emails")
+		}
+		registerNotifier("email", emailNotifier{})
+	}
+	if *tenantsFile != "" {
+		if err := loadTenants(*tenantsFile); err != nil {
+			log.Fatalf("Could not load tenants: %s", err)
+		}
+		log.Printf("Serving %d tenants besides the default one", len(tenants))
+	}
+
+	if *crawlerMode || crawler {
+		if *fillSampleInterval <= 0 {
+			log.Fatal("The crawler needs -fill-sample-interval")
+		}
+		runCrawler(*port, *fillSampleInterval)
+		return
+	}
+
+	if err := buildSearchIndex(); err != nil {
+		log.Printf("Could not build the search index: %s", err)
+	}
+	current := currentTerm(context.Background())
+	log.Printf("Current term: %d/%d, semester %d", current.Year, current.Year+1, current.Semester)
 
 	http.HandleFunc("/sisquery/", sisQueryHandler)
 	http.HandleFunc("/solverquery/", solverQueryHandler)
+	http.HandleFunc("/studyplan/", studyPlanHandler)
+	http.HandleFunc("/exams/", examsHandler)
+	http.HandleFunc("/api/v1/exams:check", examCheckHandler)
+	http.HandleFunc("/fillrates/", fillRatesHandler)
+	http.HandleFunc("/api/v1/courses:batch", batchHandler)
+	http.HandleFunc("/api/v1/evaluate", evaluateHandler)
+	http.HandleFunc("/api/v1/validate", validateHandler)
+	http.HandleFunc("/api/v1/whatif", whatIfHandler)
+	http.HandleFunc("/api/v1/schedules:free", freeTimeHandler)
+	http.HandleFunc("/api/v1/schedules:overlay", overlayHandler)
+	http.HandleFunc("/api/v1/userdata", userDataHandler)
+	http.HandleFunc("/enrollment/", enrollmentHandler)
+	http.HandleFunc("/enrollmentplan/", enrollmentPlanHandler)
+	http.HandleFunc("/export/png", pngExportHandler)
+	http.HandleFunc("/schedules/", schedulesHandler)
+	http.HandleFunc("/embed/", embedHandler)
+	http.HandleFunc("/api/v1/messages", messagesHandler)
+	http.HandleFunc("/calendar/", calendarHandler)
+	http.HandleFunc("/tenant", tenantHandler)
+	http.HandleFunc("/search", searchHandler)
+	http.HandleFunc("/api/v1/courses", coursesHandler)
+	http.HandleFunc("/api/v1/dataset", datasetHandler)
+	http.HandleFunc("/bundles/", bundlesHandler)
+	http.HandleFunc("/teacher/", teacherHandler)
+	http.HandleFunc("/courseinfo/", courseInfoHandler)
+	http.HandleFunc("/timeline/", timelineHandler)
+	http.HandleFunc("/rooms/occupancy", roomOccupancyHandler)
+	http.HandleFunc("/graphql", graphqlHandler)
+	http.HandleFunc("/openapi.json", openAPIHandler)
+	http.HandleFunc("/profiles/", profilesHandler)
+	http.HandleFunc("/notifications/", notificationsHandler)
+	http.HandleFunc("/bots/discord", discordHandler)
+	http.HandleFunc("/history/", historyHandler)
+	http.HandleFunc("/admin/snapshots/", requireScope(SCOPE_ADMIN, snapshotHandler))
+	http.HandleFunc("/admin/mirrors", requireScope(SCOPE_ADMIN, mirrorsHandler))
+	http.HandleFunc("/admin/breaker", requireScope(SCOPE_ADMIN, breakerHandler))
+	http.HandleFunc("/admin/shadow", requireScope(SCOPE_ADMIN, shadowHandler))
+	http.HandleFunc("/admin/sis", requireScope(SCOPE_ADMIN, sisPauseHandler))
+	http.HandleFunc("/admin/stats", requireScope(SCOPE_ADMIN, statsHandler))
+	http.HandleFunc("/admin/bundles/", requireScope(SCOPE_ADMIN, adminBundlesHandler))
+	http.HandleFunc("/admin/mass", requireScope(SCOPE_ADMIN, massHandler))
+	http.HandleFunc("/admin/demand", requireScope(SCOPE_ADMIN, demandHandler))
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/admin/cache/", requireScope(SCOPE_ADMIN, cacheArchiveHandler))
 
-	fs := http.FileServer(http.Dir(path.Join(rootDir, FRONTEND_DIR)))
-	http.Handle("/", fs)
+	http.Handle("/", newStaticHandler(path.Join(rootDir, *frontendDir), *spaFallback))
 
-	log.Printf("Listening on: %d", *port)
-	err := http.ListenAndServe(":"+strconv.Itoa(*port), nil)
+	var handler http.Handler = validationMiddleware(http.DefaultServeMux)
+	handler = quotaMiddleware(handler)
+	// Inside the compression, so that error answers can be told
+	handler = requestIdMiddleware(handler)
+	if *gzipResponses {
+		handler = gzipMiddleware(handler)
+	}
+	handler = corsMiddleware(corsConfig{
+		Origins: corsOrigins,
+		Methods: strings.Split(*corsMethods, ","),
+	}, handler)
+	handler = languageMiddleware(handler)
+	handler = tenantMiddleware(handler)
+	handler = securityHeadersMiddleware(handler)
+	if *trustedProxiesFlag < 0 {
+		log.Fatal("-trusted-proxies must not be negative")
+	}
+	trustedProxies = *trustedProxiesFlag
+	if trustedProxies == 0 && *accessLogForwarded {
+		trustedProxies = 1
+	}
+	if *accessLog != "" {
+		if !isIpMode(*accessLogIp) {
+			log.Fatalf("Unknown -access-log-ip %s, expected one of %s", *accessLogIp, strings.Join(ipModes, ", "))
+		}
+		sampling, err := parseAccessLogSampling(*accessLogSample)
+		if err != nil {
+			log.Fatalf("Invalid -access-log-sample: %s", err)
+		}
+		var out io.Writer = os.Stderr
+		if *accessLog != "-" && *retainAccessLog > 0 {
+			l, err := openDailyLog(path.Join(rootDir, *accessLog), *retainAccessLog)
+			if err != nil {
+				log.Fatalf("Could not open the access log: %s", err)
+			}
+			defer l.Close()
+			out = l
+		} else if *accessLog != "-" {
+			f, err := os.OpenFile(path.Join(rootDir, *accessLog), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+			if err != nil {
+				log.Fatalf("Could not open the access log: %s", err)
+			}
+			defer f.Close()
+			out = f
+		}
+		handler = accessLogMiddleware(accessLogConfig{
+			Out:      out,
+			IpMode:   *accessLogIp,
+			Sampling: sampling,
+		}, handler)
+	}
+	handler = tracingMiddleware(handler)
+
+	// Before listening, so that jobs started by the first requests aren't
+	// taken for ones left over
+	interruptRunningJobs() // Left over from a previous run
+	server := &http.Server{
+		Addr:    ":" + strconv.Itoa(*port),
+		Handler: handler,
+	}
+	go func() {
+		log.Printf("Listening on: %d", *port)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Could not start server: %s\n", err)
+		}
+	}()
+	stopGrpc := startGrpc(*grpcPort)
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	go fetches.run(backgroundCtx)
+	go watchSisPause(backgroundCtx)
+	go watchRollover(backgroundCtx)
+	if *searchReindex > 0 {
+		go reindexSearch(backgroundCtx, *searchReindex)
+	}
+	if retention := (retentionPolicy{Schedules: *retainSchedules, Jobs: *retainJobs}); retention.enabled() {
+		go enforceRetention(backgroundCtx, retention)
+	}
+	stopSampling := func() {}
+	if *fillSampleInterval > 0 {
+		var samplingCtx context.Context
+		samplingCtx, stopSampling = context.WithCancel(context.Background())
+		go sampleFillRates(samplingCtx, *fillSampleInterval)
+	}
+	stopTelegram := func() {}
+	if telegram != nil {
+		var telegramCtx context.Context
+		telegramCtx, stopTelegram = context.WithCancel(context.Background())
+		go telegram.run(telegramCtx)
+	}
+	stopDigests, stopDispatcher := func() {}, func() {}
+	if notificationsEnabled() {
+		var digestCtx, dispatcherCtx context.Context
+		digestCtx, stopDigests = context.WithCancel(context.Background())
+		go sendDigests(digestCtx)
+		dispatcherCtx, stopDispatcher = context.WithCancel(context.Background())
+		go dispatcher.run(dispatcherCtx)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	stopSampling()
+	stopBackground()
+	stopDigests()
+	stopDispatcher()
+	stopTelegram()
+	shutdown(server, stopGrpc)
+}
+
+// Stops accepting new requests (and gRPC calls) and waits for the running
+// ones to finish. Solver runs which don't finish in time are killed and
+// their jobs marked as interrupted, whose clients are told to send them again.
+func shutdown(server *http.Server, stopGrpc func(ctx context.Context) error) {
+	log.Printf("Shutting down (waiting at most %s for running requests)", SHUTDOWN_TIMEOUT)
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	grpcErr := make(chan error, 1)
+	go func() { grpcErr <- stopGrpc(ctx) }()
+	err := server.Shutdown(ctx)
+	if e := <-grpcErr; err == nil {
+		err = e
+	}
 	if err != nil {
-		log.Fatalf("Could not start server: %s\n", err)
+		log.Printf("Some requests didn't finish in time: %s", err)
+		cancelSolvers()
+	}
+	interruptRunningJobs()
+	if err := db.Close(); err != nil {
+		log.Printf("Could not close the database: %s", err)
 	}
 }
 
@@ -107,3 +1141,15 @@ func ellipsis(s string, n int) string {
 		return s[:n] + "..."
 	}
 }
+
+// A command-line flag which may be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
@@ -0,0 +1,328 @@
+// Scheduling many students at once, e.g. for a student council wanting to
+// know how many students can't fit their courses together and which
+// courses collide for the most of them. Each course is fetched once for
+// all students; each student's courses are then solved as a solve request
+// with just the courses would be (as /solve of the bots does).
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The most students of one POST /admin/mass, which answers when all are
+// solved (-mass has no limit)
+const MAX_MASS_STUDENTS = 500
+
+// How many students are solved at the same time
+const MASS_CONCURRENCY = 2
+
+// The longest the solver may take for a student, in seconds
+const MASS_SOLVE_TIMEOUT = 10
+
+// The seed of the solver, so that runs over the same courses are comparable
+const MASS_SEED = 1
+
+// The courses a student wants, as a line of a CSV such as
+// "student,NPRG030,NTIN061" or in JSON as
+// {"student":"s1","courses":["NPRG030","NTIN061"]}
+type massStudent struct {
+	Student string   `json:"student"`
+	Courses []string `json:"courses"`
+}
+
+type massReport struct {
+	Year     int `json:"academic_year"`
+	Semester int `json:"semester"`
+	Students int `json:"students"`
+	// Students with all their courses in a schedule without collisions,
+	// with some left out, and those the solver failed for
+	ConflictFree int `json:"conflict_free"`
+	Incomplete   int `json:"incomplete"`
+	Failed       int `json:"failed"`
+	// The courses, those most often left out first
+	Courses []massCourse `json:"courses"`
+	// Which courses collided with a course left out, for how many students,
+	// the most frequent first
+	Conflicts []massConflict `json:"conflicts"`
+	// The groups chosen by the schedules, see demand.go
+	Groups  []groupDemand `json:"groups"`
+	Results []massResult  `json:"results"`
+}
+
+type massCourse struct {
+	Code      string `json:"code"`
+	Requested int    `json:"requested"`
+	Dropped   int    `json:"dropped"`
+	// Why the course couldn't be found, e.g. not taught in the semester
+	Error string `json:"error,omitempty"`
+}
+
+type massConflict struct {
+	// The course left out, and a course of the schedule with a group
+	// colliding with one of its groups
+	Dropped  string `json:"dropped"`
+	With     string `json:"with"`
+	Students int    `json:"students"`
+}
+
+type massResult struct {
+	Student   string   `json:"student"`
+	Scheduled []string `json:"scheduled"`
+	Dropped   []string `json:"dropped"`
+	// Courses which couldn't be found, see massCourse.Error
+	Unknown []string `json:"unknown,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	// The group of each scheduled course
+	chosen map[string][]sisparse.Event
+}
+
+// Reads the students from a JSON array of massStudent or from a CSV with
+// a line for each student, the student first and the course codes after
+// (in fields of their own or separated by spaces); a line starting with
+// "student" is a header.
+func parseMassStudents(data []byte) ([]massStudent, error) {
+	var students []massStudent
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		if err := json.Unmarshal(data, &students); err != nil {
+			return nil, fmt.Errorf("Invalid students: %s", err)
+		}
+	} else {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		r.Comment = '#'
+		records, err := r.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CSV: %s", err)
+		}
+		for i, record := range records {
+			if i == 0 && strings.EqualFold(record[0], "student") {
+				continue
+			}
+			s := massStudent{Student: record[0]}
+			for _, field := range record[1:] {
+				s.Courses = append(s.Courses, strings.Fields(field)...)
+			}
+			students = append(students, s)
+		}
+	}
+	for i, s := range students {
+		if s.Student == "" {
+			return nil, fmt.Errorf("Student %d has no name", i+1)
+		}
+		for j := range s.Courses {
+			s.Courses[j] = sisparse.NormalizeCourseCode(s.Courses[j])
+		}
+	}
+	return students, nil
+}
+
+// Solves the courses of each student in the semester (and the academic
+// year of ctx).
+func runMass(ctx context.Context, students []massStudent, semester int) massReport {
+	codes := []string{}
+	for _, s := range students {
+		codes = append(codes, s.Courses...)
+	}
+	groups := map[string][][]sisparse.Event{}
+	courses := map[string]*massCourse{}
+	for code, res := range queryCourses(ctx, codes, semester) {
+		courses[code] = &massCourse{Code: code}
+		var answer struct {
+			Data  [][]sisparse.Event `json:"data"`
+			Error string             `json:"error"`
+		}
+		if err := json.Unmarshal(res, &answer); err != nil {
+			courses[code].Error = err.Error()
+		} else if answer.Error != "" || len(answer.Data) == 0 {
+			courses[code].Error = answer.Error
+			if courses[code].Error == "" {
+				courses[code].Error = localize(ctx, "bot_no_groups", code)
+			}
+		} else {
+			groups[code] = answer.Data
+		}
+	}
+
+	report := massReport{Year: sisparse.AcademicYear(ctx), Semester: semester, Students: len(students),
+		Courses: []massCourse{}, Conflicts: []massConflict{}, Results: make([]massResult, len(students))}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, MASS_CONCURRENCY)
+	for i := range students {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			report.Results[i] = solveMassStudent(ctx, students[i], groups)
+		}(i)
+	}
+	wg.Wait()
+
+	conflicts := map[[2]string]int{}
+	demand := demandCounter{}
+	for _, res := range report.Results {
+		switch {
+		case res.Error != "":
+			report.Failed++
+		case len(res.Dropped) > 0:
+			report.Incomplete++
+		default:
+			report.ConflictFree++
+		}
+		for _, code := range append(append(append([]string{}, res.Scheduled...), res.Dropped...), res.Unknown...) {
+			courses[code].Requested++
+		}
+		for code, group := range res.chosen {
+			demand.add(code, group)
+		}
+		for _, code := range res.Dropped {
+			courses[code].Dropped++
+			for _, with := range res.Scheduled {
+				if collidesWithAny(res.chosen[with], groups[code]) {
+					conflicts[[2]string{code, with}]++
+				}
+			}
+		}
+	}
+
+	report.Groups = demand.list()
+	for _, c := range courses {
+		report.Courses = append(report.Courses, *c)
+	}
+	sort.Slice(report.Courses, func(i, j int) bool {
+		a, b := report.Courses[i], report.Courses[j]
+		if a.Dropped != b.Dropped {
+			return a.Dropped > b.Dropped
+		}
+		return a.Code < b.Code
+	})
+	for pair, n := range conflicts {
+		report.Conflicts = append(report.Conflicts, massConflict{Dropped: pair[0], With: pair[1], Students: n})
+	}
+	sort.Slice(report.Conflicts, func(i, j int) bool {
+		a, b := report.Conflicts[i], report.Conflicts[j]
+		if a.Students != b.Students {
+			return a.Students > b.Students
+		}
+		return a.Dropped+" "+a.With < b.Dropped+" "+b.With
+	})
+	return report
+}
+
+func solveMassStudent(ctx context.Context, s massStudent, groups map[string][][]sisparse.Event) massResult {
+	res := massResult{Student: s.Student, Scheduled: []string{}, Dropped: []string{}}
+	timeout := MASS_SOLVE_TIMEOUT
+	seed := int64(MASS_SEED)
+	req := solveRequest{Version: SOLVE_SCHEMA_VERSION, Timeout: &timeout, Seed: &seed}
+	seen := map[string]bool{}
+	for _, code := range s.Courses {
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+		if groups[code] == nil {
+			res.Unknown = append(res.Unknown, code)
+			continue
+		}
+		options, _ := json.Marshal(groups[code])
+		req.Courses = append(req.Courses, solveCourse{Name: code, CourseCode: code, Options: options})
+	}
+	if len(req.Courses) == 0 {
+		return res
+	}
+	body, _ := json.Marshal(req)
+	answer, err := solveWithRules(ctx, body)
+	var solved solveResponse
+	if err == nil {
+		err = json.Unmarshal(answer, &solved)
+	}
+	if err == nil && solved.Error != "" {
+		err = fmt.Errorf("%s", solved.Error)
+	}
+	if err != nil {
+		logf(ctx, "Mass error for %s: %s", s.Student, err)
+		res.Error = err.Error()
+		return res
+	}
+	res.chosen = map[string][]sisparse.Event{}
+	for i, c := range req.Courses {
+		if i < len(solved.Data) && solved.Data[i] != nil && *solved.Data[i] < len(groups[c.Name]) {
+			res.Scheduled = append(res.Scheduled, c.Name)
+			res.chosen[c.Name] = groups[c.Name][*solved.Data[i]]
+		} else {
+			res.Dropped = append(res.Dropped, c.Name)
+		}
+	}
+	return res
+}
+
+// Whether any of the groups has an event colliding with an event of the
+// group (in the same weeks, as the solver judges it).
+func collidesWithAny(group []sisparse.Event, groups [][]sisparse.Event) bool {
+	for _, g := range groups {
+		for _, e := range g {
+			for _, f := range group {
+				if eventsCollide(e, f) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func eventsCollide(e, f sisparse.Event) bool {
+	return e.Semester == f.Semester && e.Day == f.Day && e.SharesWeek(f) &&
+		e.TimeFrom.Minutes() < f.TimeTo.Minutes() && f.TimeFrom.Minutes() < e.TimeTo.Minutes()
+}
+
+// Answers POST /admin/mass with the students (see parseMassStudents) in
+// the body, and ?semester= and ?year= as /sisquery/, with {"data":massReport},
+// or with ?format=csv, the groups chosen as CSV (see demand.go).
+func massHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use POST"}`)
+		return
+	}
+	t, err := requestTerm(r)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	students, err := parseMassStudents(body)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	if len(students) > MAX_MASS_STUDENTS {
+		fmt.Fprintf(w, `{"error":"At most %d students can be scheduled at once, use -mass for more"}`, MAX_MASS_STUDENTS)
+		return
+	}
+	logf(r.Context(), "Mass: %d students", len(students))
+	report := runMass(sisparse.WithAcademicYear(r.Context(), t.Year), students, t.Semester)
+	if r.URL.Query().Get("format") == "csv" {
+		writeDemand(w, r, report.Students, report.Groups)
+		return
+	}
+	res, _ := json.Marshal(report)
+	fmt.Fprintf(w, `{"data":%s}`, string(res))
+}
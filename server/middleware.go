@@ -0,0 +1,192 @@
+package server
+
+import (
+	"compress/gzip"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Which cross-origin requests are allowed, see corsMiddleware.
+type corsConfig struct {
+	// Origins allowed to use the API, "*" allows any
+	Origins []string
+	Methods []string
+}
+
+// Headers which cross-origin clients may send and read.
+const (
+	CORS_ALLOWED_HEADERS = "Content-Type, Authorization, traceparent, X-Profile-Token, X-Schedule-Owner, X-Request-ID"
+	CORS_EXPOSED_HEADERS = "X-Trace-Id, X-Request-ID, Retry-After, " +
+		"X-Quota-Solve-Limit, X-Quota-Solve-Remaining, X-Quota-Solve-Reset, " +
+		"X-Quota-Fetch-Limit, X-Quota-Fetch-Remaining, X-Quota-Fetch-Reset"
+	CORS_MAX_AGE = "600"
+)
+
+// How many proxies in front of the server append the address they were
+// reached from to X-Forwarded-For, see -trusted-proxies
+var trustedProxies = 0
+
+// Returns the address of the client of the request. Behind trusted
+// proxies, it is the address which the outermost of them appended to
+// X-Forwarded-For; the entries before it are whatever the client sent, so
+// they can't be trusted.
+func clientAddr(r *http.Request) string {
+	if trustedProxies > 0 {
+		forwarded := []string{}
+		for _, h := range r.Header.Values("X-Forwarded-For") {
+			for _, a := range strings.Split(h, ",") {
+				if a = strings.TrimSpace(a); a != "" {
+					forwarded = append(forwarded, a)
+				}
+			}
+		}
+		if len(forwarded) > 0 {
+			i := len(forwarded) - trustedProxies
+			if i < 0 {
+				i = 0
+			}
+			return forwarded[i]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (c corsConfig) allowsOrigin(origin string) bool {
+	for _, o := range c.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lets browsers call the API from the configured origins. Preflight requests
+// are answered directly; requests from other origins are passed through
+// without the CORS headers, so the browser refuses to expose the response.
+func corsMiddleware(config corsConfig, next http.Handler) http.Handler {
+	if len(config.Origins) == 0 {
+		return next
+	}
+	methods := strings.Join(config.Methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !config.allowsOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", CORS_EXPOSED_HEADERS)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", CORS_ALLOWED_HEADERS)
+			w.Header().Set("Access-Control-Max-Age", CORS_MAX_AGE)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Adds the usual headers which make browsers more careful with our responses.
+func securityHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "SAMEORIGIN")
+		h.Set("Referrer-Policy", "same-origin")
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			h.Set("Strict-Transport-Security", "max-age=31536000")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Compresses JSON responses for clients which accept gzip. Whether
+// a response is JSON is decided when its first byte is written: either
+// the handler has set the Content-Type, or the body starts like JSON.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsEncoding(r, "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// Whether the client accepts the content encoding (e.g. "gzip").
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	status  int
+	decided bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	// Postponed until we know whether to compress
+	w.status = status
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.decide(b)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipResponseWriter) decide(body []byte) {
+	w.decided = true
+	h := w.Header()
+	if h.Get("Content-Type") == "" && looksLikeJSON(body) {
+		h.Set("Content-Type", "application/json")
+	}
+	if strings.HasPrefix(h.Get("Content-Type"), "application/json") && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if !w.decided {
+		// Nothing was written, only pass the status on
+		w.decided = true
+		if w.status != 0 {
+			w.ResponseWriter.WriteHeader(w.status)
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+func looksLikeJSON(b []byte) bool {
+	s := strings.TrimLeft(string(b), " \t\r\n")
+	return strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[")
+}
@@ -0,0 +1,622 @@
+// Notifications of users: those who opt in get alerts when the schedule of
+// a course they follow changes in SIS, when a group their last schedule
+// chose gets cancelled (see cancelled.go), when a long solve request of theirs
+// finishes and weekly digests of the changes, by email (once they confirm
+// their address) or in the chats they link (see dispatch.go). Changes are
+// noticed when courses are fetched again (by the fill sampling, or when the
+// cache is refreshed).
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// The SMTP server to send the emails through, see -smtp. Without one, no
+// emails are sent.
+var smtpServer struct {
+	Addr           string // host:port
+	From           string
+	User, Password string
+}
+
+// Where users reach the server (e.g. https://samorozvrh.example), for the
+// links in the emails
+var publicUrl string
+
+const (
+	DIGEST_INTERVAL       = 7 * 24 * time.Hour
+	DIGEST_CHECK_INTERVAL = time.Hour
+	// Changes are kept for a while longer than a digest covers
+	COURSE_CHANGES_KEPT = 2 * DIGEST_INTERVAL
+	// Users are only emailed about solve requests which took longer than
+	// this; they are still waiting for the others
+	JOB_NOTIFY_MIN_DURATION = time.Minute
+	MAX_FOLLOWED_COURSES    = 50
+)
+
+// Users follow courses by subscriptions whose target is this and the hash of
+// their token
+const USER_TARGET_PREFIX = "user:"
+
+func emailEnabled() bool {
+	return smtpServer.Addr != ""
+}
+
+// What the email templates are executed with
+type emailData struct {
+	Lang    string
+	Course  string
+	Changes []string
+	// Of the digests
+	Courses []digestCourse
+	// Where to go from the email (to confirm the address, to see the
+	// schedule), and where to unsubscribe
+	Link            string
+	UnsubscribeLink string
+}
+
+type digestCourse struct {
+	Course  string
+	Changes []string
+}
+
+var emailFuncs = template.FuncMap{
+	"t": func(lang, code string, args ...interface{}) string {
+		return localize(withLanguage(context.Background(), lang), code, args...)
+	},
+}
+
+// By name, the texts of the notifications (in chats too); their subjects
+// are the messages "email_<name>_subject"
+var emailTemplates = map[string]*template.Template{
+	"confirm": newEmailTemplate("confirm", `{{t .Lang "email_confirm_intro"}}
+
+{{.Link}}
+`),
+	"course_changed": newEmailTemplate("course_changed", `{{t .Lang "email_course_changed_intro" .Course}}
+{{range .Changes}}
+  - {{.}}{{end}}
+{{with .UnsubscribeLink}}
+{{t $.Lang "email_unsubscribe"}}
+{{.}}{{end}}
+`),
+	"group_cancelled": newEmailTemplate("group_cancelled", `{{t .Lang "email_group_cancelled_intro" .Course}}
+{{range .Changes}}
+  - {{.}}{{end}}
+
+{{t .Lang "email_group_cancelled_outro"}}
+{{with .UnsubscribeLink}}
+{{t $.Lang "email_unsubscribe"}}
+{{.}}{{end}}
+`),
+	"job_done": newEmailTemplate("job_done", `{{t .Lang "email_job_done_intro"}}
+
+{{.Link}}
+{{with .UnsubscribeLink}}
+{{t $.Lang "email_unsubscribe"}}
+{{.}}{{end}}
+`),
+	"job_failed": newEmailTemplate("job_failed", `{{t .Lang "email_job_failed_intro"}}
+
+{{.Link}}
+{{with .UnsubscribeLink}}
+{{t $.Lang "email_unsubscribe"}}
+{{.}}{{end}}
+`),
+	"digest": newEmailTemplate("digest", `{{t .Lang "email_digest_intro"}}
+{{range .Courses}}
+{{.Course}}:{{range .Changes}}
+  - {{.}}{{end}}
+{{end}}{{with .UnsubscribeLink}}
+{{t $.Lang "email_unsubscribe"}}
+{{.}}{{end}}
+`),
+}
+
+func newEmailTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).Funcs(emailFuncs).Parse(text))
+}
+
+// Sends the notification of the given template to the channels of the user,
+// with the subject args filled into its subject. Emails are only sent once
+// the address is confirmed.
+func notifyUser(n store.NotifySettings, kind string, data emailData, subjectArgs ...interface{}) {
+	for _, channel := range n.Channels {
+		target, d := channel, data
+		if channel == "email" {
+			if !n.Confirmed {
+				continue
+			}
+			target = "email:" + n.Email
+			d.UnsubscribeLink = notifyLink("unsubscribe", n)
+		}
+		dispatcher.enqueue(target, notification{Kind: kind, Lang: n.Language, Data: d, SubjectArgs: subjectArgs})
+	}
+}
+
+// Sends notifications by email, through smtpServer.
+type emailNotifier struct{}
+
+func (emailNotifier) Send(address string, n notification) error {
+	text, err := n.text()
+	if err != nil {
+		return err
+	}
+	return sendEmail(address, n.subject(), text)
+}
+
+func sendEmail(to, subject, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", smtpServer.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprint(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprint(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprint(&msg, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(&msg)
+	if _, err := w.Write([]byte(strings.Replace(body, "\n", "\r\n", -1))); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if smtpServer.User != "" {
+		host, _, _ := net.SplitHostPort(smtpServer.Addr)
+		auth = smtp.PlainAuth("", smtpServer.User, smtpServer.Password, host)
+	}
+	return smtp.SendMail(smtpServer.Addr, auth, smtpServer.From, []string{to}, msg.Bytes())
+}
+
+func notifyLink(action string, n store.NotifySettings) string {
+	return fmt.Sprintf("%s/notifications/%s?user=%s&code=%s",
+		strings.TrimSuffix(publicUrl, "/"), action, url.QueryEscape(n.UserHash), url.QueryEscape(n.Code))
+}
+
+// A group of a course before and after a change; Before is empty for added
+// groups, After for removed ones. Course changes are stored as lists of these
+// and put into words when they are sent, in the language of the user.
+type groupChange struct {
+	Before []sisparse.Event `json:"before,omitempty"`
+	After  []sisparse.Event `json:"after,omitempty"`
+}
+
+// Compares the cached answer for the course with its newly fetched groups
+// and, if somebody follows the course and it changed, records the change and
+// alerts the users who want to know at once and the chats following it.
+func noticeCourseChange(code string, cached string, groups [][]sisparse.Event) {
+	var old struct {
+		Data [][]sisparse.Event `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(cached), &old); err != nil {
+		return
+	}
+	before, after := groupsById(old.Data), groupsById(groups)
+	diff := diffCourseGroups(code, before, after)
+	if diff.empty() {
+		return
+	}
+	if ids := newlyCancelledGroups(before, after); len(ids) > 0 && notificationsEnabled() {
+		go notifyCancelledGroups(code, ids, after)
+	}
+	subs, err := db.ListSubscriptions(code)
+	if err != nil || len(subs) == 0 {
+		return
+	}
+	changes := []groupChange{}
+	for _, id := range diff.AddedGroups {
+		changes = append(changes, groupChange{After: after[id]})
+	}
+	for _, id := range diff.RemovedGroups {
+		changes = append(changes, groupChange{Before: before[id]})
+	}
+	for _, id := range diff.ChangedGroups {
+		changes = append(changes, groupChange{Before: before[id], After: after[id]})
+	}
+	summary, _ := json.Marshal(changes)
+	if err := db.AddCourseChange(store.CourseChange{CourseCode: code, Summary: string(summary)}); err != nil {
+		log.Printf("Could not record the change of %s: %s", code, err)
+	}
+	go func() {
+		for _, sub := range subs {
+			if !strings.HasPrefix(sub.Target, USER_TARGET_PREFIX) {
+				// A chat following the course
+				data := emailData{Course: code, Changes: describeChanges(DEFAULT_LANGUAGE, changes)}
+				dispatcher.enqueue(sub.Target, notification{Kind: "course_changed", Lang: DEFAULT_LANGUAGE,
+					Data: data, SubjectArgs: []interface{}{code}})
+				continue
+			}
+			n, err := db.GetNotifySettings(strings.TrimPrefix(sub.Target, USER_TARGET_PREFIX))
+			if err != nil || !n.CourseChanges {
+				continue
+			}
+			notifyUser(n, "course_changed", emailData{Course: code, Changes: describeChanges(n.Language, changes)}, code)
+		}
+	}()
+}
+
+// Puts the changes into words, one line per changed group.
+func describeChanges(lang string, changes []groupChange) []string {
+	ctx := withLanguage(context.Background(), lang)
+	res := []string{}
+	for _, c := range changes {
+		switch {
+		case len(c.Before) == 0:
+			res = append(res, localize(ctx, "group_added", describeGroup(ctx, c.After)))
+		case len(c.After) == 0:
+			res = append(res, localize(ctx, "group_removed", describeGroup(ctx, c.Before)))
+		default:
+			res = append(res, localize(ctx, "group_changed", describeGroup(ctx, c.Before), describeGroup(ctx, c.After)))
+		}
+	}
+	return res
+}
+
+// E.g. "lecture Tue 10:40–12:10 S3 (odd weeks), Novák"
+func describeGroup(ctx context.Context, group []sisparse.Event) string {
+	parts := []string{}
+	for _, e := range group {
+		s := fmt.Sprintf("%s %s–%s", dayName(ctx, e.Day), e.TimeFrom, e.TimeTo)
+		if e.Type != "" {
+			s = localize(ctx, string(e.Type)) + " " + s
+		}
+		if e.Room != "" {
+			s += " " + e.Room
+		}
+		if weeks := eventWeeksLabel(ctx, e); weeks != "" {
+			s += " (" + weeks + ")"
+		}
+		if e.Teacher != "" {
+			s += ", " + e.Teacher
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Tells the user who sent the solve request that it finished, if they want
+// to know and it took long enough for them to have stopped waiting.
+func notifyJobDone(job store.Job) {
+	if !notificationsEnabled() || job.UserHash == "" || time.Since(job.Created) < JOB_NOTIFY_MIN_DURATION {
+		return
+	}
+	n, err := db.GetNotifySettings(job.UserHash)
+	if err != nil || !n.JobDone {
+		return
+	}
+	kind := "job_done"
+	if job.Status != JOB_DONE {
+		kind = "job_failed"
+	}
+	notifyUser(n, kind, emailData{Link: strings.TrimSuffix(publicUrl, "/") + "/"})
+}
+
+// Sends the weekly digests which are due every DIGEST_CHECK_INTERVAL,
+// until ctx is done.
+func sendDigests(ctx context.Context) {
+	for {
+		if err := sendDueDigests(); err != nil {
+			log.Printf("Could not send digests: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(DIGEST_CHECK_INTERVAL):
+		}
+	}
+}
+
+func sendDueDigests() error {
+	now := time.Now().UTC()
+	if err := db.DeleteCourseChanges(now.Add(-COURSE_CHANGES_KEPT)); err != nil {
+		return err
+	}
+	all, err := db.ListNotifySettings()
+	if err != nil {
+		return err
+	}
+	for _, n := range all {
+		if !n.Digest || now.Sub(n.LastDigest) < DIGEST_INTERVAL {
+			continue
+		}
+		since := n.LastDigest
+		if now.Sub(since) > COURSE_CHANGES_KEPT {
+			since = now.Add(-DIGEST_INTERVAL)
+		}
+		subs, err := db.ListTargetSubscriptions(USER_TARGET_PREFIX + n.UserHash)
+		if err != nil {
+			return err
+		}
+		data := emailData{}
+		for _, sub := range subs {
+			changes, err := db.ListCourseChanges(sub.CourseCode, since)
+			if err != nil {
+				return err
+			}
+			course := digestCourse{Course: sub.CourseCode}
+			for _, c := range changes {
+				var groups []groupChange
+				if json.Unmarshal([]byte(c.Summary), &groups) == nil {
+					course.Changes = append(course.Changes, describeChanges(n.Language, groups)...)
+				}
+			}
+			if len(course.Changes) > 0 {
+				data.Courses = append(data.Courses, course)
+			}
+		}
+		n.LastDigest = now
+		if err := db.SaveNotifySettings(n); err != nil {
+			return err
+		}
+		if len(data.Courses) > 0 {
+			notifyUser(n, "digest", data)
+		}
+	}
+	return nil
+}
+
+// The notification settings of a user as the API shows them
+type notifySettingsJSON struct {
+	Email         string `json:"email"`
+	Language      string `json:"language"`
+	Confirmed     bool   `json:"confirmed"`
+	CourseChanges bool   `json:"course_changes"`
+	JobDone       bool   `json:"job_done"`
+	Digest        bool   `json:"digest"`
+	// Where to send the notifications, see store.NotifySettings
+	Channels []string `json:"channels"`
+	// The codes of the courses the user follows
+	Courses []string `json:"courses"`
+	// To send to the bots as "/link <code>" to link their chats, as a
+	// channel; only in answers
+	LinkCode string `json:"link_code,omitempty"`
+}
+
+// Answers
+//   - GET /notifications/ with the settings of the user (given by
+//     PROFILE_TOKEN_HEADER), as notifySettingsJSON,
+//   - POST /notifications/ with notifySettingsJSON by saving them and
+//     returning them back; a new address is sent a link to confirm it, and
+//     channels of chats can only be kept or removed (they are added by
+//     linking the chats),
+//   - DELETE /notifications/ by forgetting the settings and the courses,
+//   - GET /notifications/confirm and /notifications/unsubscribe (with the
+//     user and code the links in the emails carry) with a page saying the
+//     address was confirmed, or that no more emails will be sent.
+func notificationsHandler(w http.ResponseWriter, r *http.Request) {
+	if !notificationsEnabled() {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"Notifications are not enabled on this server"}`)
+		return
+	}
+	action := strings.TrimPrefix(r.URL.Path, "/notifications/")
+	if r.Method == "GET" && (action == "confirm" || action == "unsubscribe") {
+		notifyLinkHandler(w, r, action)
+		return
+	}
+	user := requestUserHash(r)
+	if user == "" {
+		fmt.Fprintf(w, `{"error":"Send the token of your profiles in %s"}`, PROFILE_TOKEN_HEADER)
+		return
+	}
+	var err error
+	switch {
+	case r.Method == "GET" && action == "":
+		err = writeNotifySettings(w, user)
+	case r.Method == "POST" && action == "":
+		err = saveNotifySettings(w, r, user)
+	case r.Method == "DELETE" && action == "":
+		err = forgetNotifySettings(user)
+		if err == nil {
+			fmt.Fprint(w, `{"data":null}`)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Expected GET, POST or DELETE of /notifications/"}`)
+	}
+	if err == store.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"No notification settings"}`)
+	} else if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+	}
+}
+
+func writeNotifySettings(w http.ResponseWriter, user string) error {
+	n, err := db.GetNotifySettings(user)
+	if err != nil {
+		return err
+	}
+	subs, err := db.ListTargetSubscriptions(USER_TARGET_PREFIX + user)
+	if err != nil {
+		return err
+	}
+	res := notifySettingsJSON{
+		Email:         n.Email,
+		Language:      n.Language,
+		Confirmed:     n.Confirmed,
+		CourseChanges: n.CourseChanges,
+		JobDone:       n.JobDone,
+		Digest:        n.Digest,
+		Channels:      n.Channels,
+		Courses:       []string{},
+		LinkCode:      n.Code,
+	}
+	for _, sub := range subs {
+		res.Courses = append(res.Courses, sub.CourseCode)
+	}
+	s, _ := json.Marshal(res)
+	fmt.Fprintf(w, `{"data":%s}`, s)
+	return nil
+}
+
+func saveNotifySettings(w http.ResponseWriter, r *http.Request, user string) error {
+	var req notifySettingsJSON
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return fmt.Errorf("Invalid request: %s", err)
+	}
+	if req.Channels == nil {
+		req.Channels = []string{"email"}
+	}
+	email := ""
+	for _, channel := range req.Channels {
+		if channel != "email" {
+			continue
+		}
+		if !emailEnabled() {
+			return fmt.Errorf("Email is not enabled on this server")
+		}
+		addr, err := mail.ParseAddress(req.Email)
+		if err != nil {
+			return fmt.Errorf("Invalid email address %s", req.Email)
+		}
+		email = addr.Address
+	}
+	if !isLanguage(req.Language) {
+		req.Language = languageOf(r.Context())
+	}
+	if len(req.Courses) > MAX_FOLLOWED_COURSES {
+		return fmt.Errorf("Can't follow more than %d courses", MAX_FOLLOWED_COURSES)
+	}
+	courses := map[string]bool{}
+	for _, code := range req.Courses {
+		if !sisparse.IsCourseCode(code) {
+			return fmt.Errorf("Invalid course code %s", code)
+		}
+		courses[code] = true
+	}
+
+	n, err := db.GetNotifySettings(user)
+	if err != nil && err != store.ErrNotFound {
+		return err
+	}
+	if err == store.ErrNotFound {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		n = store.NotifySettings{UserHash: user, Code: hex.EncodeToString(b)}
+	}
+	for _, channel := range req.Channels {
+		if channel != "email" && !containsString(n.Channels, channel) {
+			return fmt.Errorf("Unknown channel %s, chats are added by sending /link to the bots", channel)
+		}
+	}
+	newAddress := email != "" && email != n.Email
+	if newAddress {
+		n.Confirmed = false
+	}
+	if req.Digest && !n.Digest {
+		// The first digest is of the week to come
+		n.LastDigest = time.Now().UTC()
+	}
+	if email != "" {
+		n.Email = email
+	}
+	n.Language, n.Channels = req.Language, req.Channels
+	n.CourseChanges, n.JobDone, n.Digest = req.CourseChanges, req.JobDone, req.Digest
+	if err := db.SaveNotifySettings(n); err != nil {
+		return err
+	}
+
+	subs, err := db.ListTargetSubscriptions(USER_TARGET_PREFIX + user)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		if courses[sub.CourseCode] {
+			delete(courses, sub.CourseCode)
+		} else if err := db.DeleteSubscription(sub.Id); err != nil {
+			return err
+		}
+	}
+	for code := range courses {
+		if _, err := db.AddSubscription(store.Subscription{CourseCode: code, Target: USER_TARGET_PREFIX + user}); err != nil {
+			return err
+		}
+	}
+
+	if newAddress {
+		dispatcher.enqueue("email:"+n.Email, notification{Kind: "confirm", Lang: n.Language,
+			Data: emailData{Link: notifyLink("confirm", n)}})
+	}
+	return writeNotifySettings(w, user)
+}
+
+// Forgets the notification settings of the user and the courses they follow.
+func forgetNotifySettings(user string) error {
+	subs, err := db.ListTargetSubscriptions(USER_TARGET_PREFIX + user)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		if err := db.DeleteSubscription(sub.Id); err != nil {
+			return err
+		}
+	}
+	return db.DeleteNotifySettings(user)
+}
+
+// Answers the links of the emails with a plain page in the language of
+// the user.
+func notifyLinkHandler(w http.ResponseWriter, r *http.Request, action string) {
+	q := r.URL.Query()
+	n, err := db.GetNotifySettings(q.Get("user"))
+	if err == nil && subtle.ConstantTimeCompare([]byte(n.Code), []byte(q.Get("code"))) != 1 {
+		err = store.ErrNotFound
+	}
+	if err == store.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	message := "notifications_unsubscribed"
+	if action == "confirm" {
+		n.Confirmed = true
+		err = db.SaveNotifySettings(n)
+		message = "notifications_confirmed"
+	} else {
+		// Linked chats keep getting the notifications
+		channels := []string{}
+		for _, c := range n.Channels {
+			if c != "email" {
+				channels = append(channels, c)
+			}
+		}
+		if len(channels) == 0 {
+			err = forgetNotifySettings(n.UserHash)
+		} else {
+			n.Channels = channels
+			err = db.SaveNotifySettings(n)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, localize(withLanguage(r.Context(), n.Language), message))
+}
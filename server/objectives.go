@@ -0,0 +1,106 @@
+// Custom objectives (see the objective package) configured by -objective,
+// which judge schedules by criteria the solver doesn't know.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/objective"
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+type configuredObjective struct {
+	name string
+	// What a penalty of 1 costs, in the units of option_penalties
+	weight int
+	objective.Objective
+}
+
+var objectives []configuredObjective
+
+// Adds an objective given as <name>:<weight>[:<config>], e.g. late:20:17:20.
+func addObjective(spec string) error {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) < 2 {
+		return fmt.Errorf("Expected <name>:<weight>[:<config>], got %s", spec)
+	}
+	weight, err := strconv.Atoi(parts[1])
+	if err != nil || weight < 0 {
+		return fmt.Errorf("Invalid weight of %s: %s", parts[0], parts[1])
+	}
+	config := ""
+	if len(parts) == 3 {
+		config = parts[2]
+	}
+	o, err := objective.Open(parts[0], config)
+	if err != nil {
+		return err
+	}
+	objectives = append(objectives, configuredObjective{parts[0], weight, o})
+	return nil
+}
+
+// Returns the weight of the objective, unless the request overrides it.
+func (o configuredObjective) weightFor(weights solveWeights) int {
+	if w, ok := weights.Objectives[o.name]; ok {
+		return w
+	}
+	return o.weight
+}
+
+// Adds the weighted penalty of each option of the courses alone (over that
+// of an empty schedule) to its option_penalties. The solver adds them up,
+// which is exact for objectives summing over courses and a lower bound of
+// the penalty for the others.
+func addObjectivePenalties(courses []solveCourse, weights solveWeights) error {
+	if len(objectives) == 0 {
+		return nil
+	}
+	for i := range courses {
+		c := &courses[i]
+		var options [][]sisparse.Event
+		if err := json.Unmarshal(c.Options, &options); err != nil {
+			return fmt.Errorf("Invalid options of %s: %s", c.Name, err)
+		}
+		penalties := make([]int, len(options))
+		copy(penalties, c.OptionPenalties)
+		for _, o := range objectives {
+			weight := o.weightFor(weights)
+			if weight == 0 {
+				continue
+			}
+			base := o.Penalty(objective.Schedule{})
+			for j, option := range options {
+				s := objective.Schedule{Courses: []objective.Course{{Name: c.Name, CourseCode: c.CourseCode, Events: option}}}
+				penalties[j] += int(math.Round(float64(weight) * (o.Penalty(s) - base)))
+			}
+		}
+		c.OptionPenalties = penalties
+	}
+	return nil
+}
+
+// Returns the penalty of the whole schedule (the option selected for each
+// course, nil for none) by each objective, as "objective_<name>".
+func objectiveScores(courses []solveCourse, data []*int) map[string]float64 {
+	if len(objectives) == 0 || len(data) != len(courses) {
+		return nil
+	}
+	var s objective.Schedule
+	for i, c := range courses {
+		var options [][]sisparse.Event
+		if data[i] == nil || json.Unmarshal(c.Options, &options) != nil || *data[i] < 0 || *data[i] >= len(options) {
+			continue
+		}
+		s.Courses = append(s.Courses, objective.Course{Name: c.Name, CourseCode: c.CourseCode, Events: options[*data[i]]})
+	}
+	res := map[string]float64{}
+	for _, o := range objectives {
+		res["objective_"+o.name] = o.Penalty(s)
+	}
+	return res
+}
@@ -0,0 +1,976 @@
+// An OpenAPI description of the JSON API, served as /openapi.json and used
+// to check requests before they reach the handlers, so that malformed ones
+// (a misspelled constraint, a string instead of a number) are refused with
+// the place of the mistake rather than silently ignored.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// A JSON Schema, as far as OpenAPI 3.0 uses it and we need it.
+type apiSchema struct {
+	Type        string                `json:"type,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Properties  map[string]*apiSchema `json:"properties,omitempty"`
+	Required    []string              `json:"required,omitempty"`
+	// Properties which aren't listed are allowed unless this is false
+	AdditionalProperties *bool         `json:"additionalProperties,omitempty"`
+	Items                *apiSchema    `json:"items,omitempty"`
+	Enum                 []interface{} `json:"enum,omitempty"`
+	Pattern              string        `json:"pattern,omitempty"`
+	patternRegexp        *regexp.Regexp
+	Minimum              *float64 `json:"minimum,omitempty"`
+	Maximum              *float64 `json:"maximum,omitempty"`
+	MaxItems             *int     `json:"maxItems,omitempty"`
+	Nullable             bool     `json:"nullable,omitempty"`
+	// The alternative is chosen by the JSON type of the value
+	OneOf []*apiSchema `json:"oneOf,omitempty"`
+}
+
+type apiParameter struct {
+	Name        string     `json:"name"`
+	In          string     `json:"in"` // "path" or "query"
+	Required    bool       `json:"required,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Schema      *apiSchema `json:"schema"`
+}
+
+type apiOperation struct {
+	Method     string
+	Path       string // With parameters in braces, e.g. "/sisquery/{code}"
+	Summary    string
+	Parameters []apiParameter
+	// The JSON body, if the operation takes one
+	Body *apiSchema
+	// The largest body, in bytes; 0 for MAX_VALIDATED_BODY_SIZE
+	MaxBodySize int64
+}
+
+// The largest body of an operation which doesn't set its own limit. Bodies
+// are read whole to be validated, before the handlers limit them.
+const MAX_VALIDATED_BODY_SIZE = 8 << 20
+
+// Where a request doesn't match the description
+type validationError struct {
+	In      string `json:"in"`   // "body", "query" or "path"
+	Path    string `json:"path"` // e.g. "$.courses[0].reward" or "semester"
+	Message string `json:"message"`
+}
+
+func (e validationError) String() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+func number(x float64) *float64 {
+	return &x
+}
+
+func closed(s *apiSchema) *apiSchema {
+	no := false
+	s.AdditionalProperties = &no
+	return s
+}
+
+func arrayOf(items *apiSchema) *apiSchema {
+	return &apiSchema{Type: "array", Items: items}
+}
+
+var (
+	apiString  = &apiSchema{Type: "string"}
+	apiBoolean = &apiSchema{Type: "boolean"}
+	apiClock   = apiPattern(`^([01]?[0-9]|2[0-4]):[0-5][0-9]$`, "A time of day, hh:mm")
+)
+
+func apiPattern(pattern string, description string) *apiSchema {
+	return &apiSchema{Type: "string", Pattern: pattern, patternRegexp: regexp.MustCompile(pattern), Description: description}
+}
+
+func apiInteger(min, max *float64, description string) *apiSchema {
+	return &apiSchema{Type: "integer", Minimum: min, Maximum: max, Description: description}
+}
+
+func apiNullableInteger(min *float64, description string) *apiSchema {
+	return &apiSchema{Type: "integer", Minimum: min, Nullable: true, Description: description}
+}
+
+var apiSemester = &apiSchema{Type: "integer", Enum: []interface{}{1, 2}, Description: "1 for winter, 2 for summer; the current semester by default"}
+
+var apiYear = apiInteger(number(2000), nil, "The academic year, as in SIS (2026 for 2026/2027); the current one by default")
+
+// An event of an option, as /sisquery/ returns them; only what the solver
+// needs is checked
+var apiEvent = &apiSchema{
+	Type:     "object",
+	Required: []string{"day", "time_from", "time_to"},
+	Properties: map[string]*apiSchema{
+		"day":       apiInteger(number(0), number(6), "Monday = 0"),
+		"time_from": apiClock,
+		"time_to":   apiClock,
+		"semester":  &apiSchema{Type: "integer", Enum: []interface{}{1, 2}},
+		"week_parity": {OneOf: []*apiSchema{
+			{Type: "string", Enum: []interface{}{"every", "odd", "even"}},
+			apiInteger(number(0), number(2), ""),
+		}},
+		// Of the calendar weeks, see sisparse.Event.CalendarParity
+		"calendar_parity": {Type: "string", Enum: []interface{}{"every", "odd", "even"}},
+		// The teaching weeks, when SIS lists them, see sisparse.Event.Weeks
+		"weeks":     &apiSchema{Type: "array", Items: apiInteger(number(1), number(sisparse.TeachingWeeks), "")},
+		"capacity":  apiInteger(number(0), nil, ""),
+		"enrolled":  apiInteger(number(0), nil, ""),
+		"skippable": apiBoolean,
+		// Cancelled in SIS, see sisparse.Event.Cancelled
+		"cancelled": apiBoolean,
+	},
+}
+
+var apiFilters = closed(&apiSchema{
+	Type:        "object",
+	Description: "Restrictions of the options of the course",
+	Properties: map[string]*apiSchema{
+		"banned_teachers":    arrayOf(apiString),
+		"banned_teacher_ids": arrayOf(apiString),
+		"banned_days":        arrayOf(apiString),
+		"earliest_start":     apiClock,
+		"latest_end":         apiClock,
+		"languages":          arrayOf(&apiSchema{Type: "string", Enum: []interface{}{"cs", "en"}}),
+		"option":             apiInteger(number(0), nil, "The only option allowed"),
+	},
+})
+
+// A course of a solver query, see solver/README.md
+var apiSolverCourse = &apiSchema{
+	Type:     "object",
+	Required: []string{"name", "options"},
+	Properties: map[string]*apiSchema{
+		"name":             apiString,
+		"reward":           &apiSchema{Type: "number", Minimum: number(0)},
+		"credits":          apiInteger(number(0), nil, ""),
+		"semester":         &apiSchema{Type: "integer", Enum: []interface{}{1, 2}},
+		"course_code":      apiString,
+		"component":        apiString,
+		"optional":         apiBoolean,
+		"workload":         apiInteger(number(1), number(5), "How demanding the course is, 3 by default"),
+		"option_penalties": arrayOf(&apiSchema{Type: "number"}),
+		"previous_option":  apiNullableInteger(number(0), ""),
+		"start_option":     apiNullableInteger(number(0), ""),
+		"filters":          apiFilters,
+		"ignore_lectures":  apiBoolean,
+		"options":          arrayOf(arrayOf(apiEvent)),
+	},
+}
+
+var apiWeights = closed(&apiSchema{Type: "object", Properties: map[string]*apiSchema{
+	"stability":    apiNullableInteger(number(0), ""),
+	"balance":      apiNullableInteger(number(0), ""),
+	"fill":         apiNullableInteger(number(0), ""),
+	"back_to_back": apiNullableInteger(number(0), ""),
+	"commute":      apiNullableInteger(number(0), ""),
+	// By the name of the objective, see objectives.go
+	"objectives": &apiSchema{Type: "object"},
+}})
+
+var apiCredits = closed(&apiSchema{Type: "object", Properties: map[string]*apiSchema{
+	"min":           apiNullableInteger(number(0), ""),
+	"max_imbalance": apiNullableInteger(number(0), ""),
+}})
+
+var apiWorkload = closed(&apiSchema{Type: "object", Properties: map[string]*apiSchema{
+	"heavy":           apiNullableInteger(number(1), "Courses of at least this workload are heavy, 4 by default"),
+	"max_heavy_hours": apiNullableInteger(number(0), "The most hours of events of heavy courses in a day"),
+}})
+
+var apiCommute = closed(&apiSchema{Type: "object", Properties: map[string]*apiSchema{
+	"minutes":     apiNullableInteger(number(0), "How long the way between home and school takes"),
+	"leave_after": &apiSchema{Type: "string", Description: "Leaving home earlier is penalized, e.g. 07:30"},
+	"home_by":     &apiSchema{Type: "string", Description: "Getting home later is penalized, e.g. 19:00"},
+}})
+
+var apiReserve = &apiSchema{Type: "array", MaxItems: maxItems(MAX_RESERVED_BLOCKS), Items: closed(&apiSchema{
+	Type:     "object",
+	Required: []string{"count", "minutes"},
+	Properties: map[string]*apiSchema{
+		"name":     apiString,
+		"count":    apiInteger(number(1), number(MAX_RESERVED_BLOCKS), "Blocks each week"),
+		"minutes":  apiInteger(number(15), number(24*60), "How long each block is"),
+		"earliest": &apiSchema{Type: "string", Description: "Blocks start at this time or later, e.g. 09:00"},
+		"latest":   &apiSchema{Type: "string", Description: "Blocks end at this time or earlier, e.g. 18:00"},
+	},
+})}
+
+var apiLanguages = closed(&apiSchema{Type: "object", Properties: map[string]*apiSchema{
+	"require": apiString,
+	"prefer":  apiString,
+}})
+
+var apiSolverQuery = &apiSchema{OneOf: []*apiSchema{
+	arrayOf(apiSolverCourse),
+	closed(&apiSchema{
+		Type:     "object",
+		Required: []string{"courses"},
+		Properties: map[string]*apiSchema{
+			"courses":              arrayOf(apiSolverCourse),
+			"rules":                arrayOf(apiString),
+			"seed":                 &apiSchema{Type: "integer", Nullable: true},
+			"previous":             &apiSchema{Type: "array", Nullable: true, Items: apiNullableInteger(number(0), "")},
+			"stability":            apiNullableInteger(number(0), ""),
+			"min_credits":          apiNullableInteger(number(0), ""),
+			"max_credit_imbalance": apiNullableInteger(number(0), ""),
+			"balance":              apiNullableInteger(number(0), ""),
+			"overlap_budget":       apiNullableInteger(number(0), ""),
+			"skippable_types":      arrayOf(&apiSchema{Type: "string", Enum: []interface{}{"lecture", "seminar"}}),
+			"require_language":     apiString,
+			"prefer_language":      apiString,
+			// Version 2, see solveRequest
+			"version": apiInteger(number(1), number(SOLVE_SCHEMA_VERSION), "The version of the format, 1 if not given"),
+			"locks":   &apiSchema{Type: "array", Items: apiNullableInteger(number(0), "")},
+			"start":   &apiSchema{Type: "array", Items: apiNullableInteger(number(0), "")},
+			"weights": apiWeights,
+			"credits": apiCredits,
+			"overlap": closed(&apiSchema{Type: "object", Properties: map[string]*apiSchema{
+				"budget":          apiNullableInteger(number(0), ""),
+				"skippable_types": arrayOf(&apiSchema{Type: "string", Enum: []interface{}{"lecture", "seminar"}}),
+			}}),
+			"workload":  apiWorkload,
+			"commute":   apiCommute,
+			"languages": apiLanguages,
+			"reserve":   apiReserve,
+			"profile":   apiString,
+			"friend": closed(&apiSchema{Type: "object", Required: []string{"schedule", "courses"}, Properties: map[string]*apiSchema{
+				"schedule": apiString,
+				"courses":  &apiSchema{Type: "array", Items: apiString, MaxItems: maxItems(MAX_FRIEND_COURSES)},
+			}}),
+			"timeout": apiInteger(number(1), number(MAX_SOLVE_TIMEOUT.Seconds()), "Seconds the solver may run"),
+		},
+	}),
+}}
+
+func maxItems(n int) *int {
+	return &n
+}
+
+var apiTermParameters = []apiParameter{
+	{Name: "semester", In: "query", Schema: apiSemester},
+	{Name: "year", In: "query", Schema: apiYear},
+}
+
+// The parameters of the answers in pages, see paging.go
+func apiPageParameters(sorts ...string) []apiParameter {
+	sortEnum := []interface{}{}
+	for _, s := range sorts {
+		sortEnum = append(sortEnum, s)
+	}
+	return []apiParameter{
+		{Name: "limit", In: "query", Schema: apiInteger(number(1), number(MAX_PAGE_SIZE), "The most items of the page, 50 by default")},
+		{Name: "sort", In: "query", Schema: &apiSchema{Type: "string", Enum: sortEnum, Description: "The order of the items, the first one by default"}},
+		{Name: "cursor", In: "query", Schema: &apiSchema{Type: "string", Description: "The next_cursor of the previous page"}},
+	}
+}
+
+// The operations of the API which are checked; requests for other paths
+// are passed as they are.
+var apiOperations = []apiOperation{
+	{
+		Method:  "GET",
+		Path:    "/sisquery/{code}",
+		Summary: "The groups of events of a course",
+		Parameters: append([]apiParameter{
+			{Name: "code", In: "path", Required: true, Schema: apiString},
+			{Name: "dry_run", In: "query", Schema: &apiSchema{Type: "string", Enum: []interface{}{"1", "true"},
+				Description: "Parse the course in SIS without the cache and say what was done with each row"}},
+		}, apiTermParameters...),
+	},
+	{
+		Method:  "GET",
+		Path:    "/courseinfo/{code}",
+		Summary: "What is known about a course besides its schedule",
+		Parameters: append([]apiParameter{
+			{Name: "code", In: "path", Required: true, Schema: apiString},
+		}, apiTermParameters...),
+	},
+	{
+		Method:  "GET",
+		Path:    "/timeline/{code}",
+		Summary: "The schedules of a course over several academic years and how they changed",
+		Parameters: []apiParameter{
+			{Name: "code", In: "path", Required: true, Schema: apiString},
+			{Name: "from", In: "query", Schema: apiYear},
+			{Name: "to", In: "query", Schema: apiYear},
+			{Name: "semester", In: "query", Schema: apiSemester},
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/rooms/occupancy",
+		Summary: "How busy the rooms of each building are in each hour of the week, by the cached courses",
+		Parameters: append([]apiParameter{
+			{Name: "building", In: "query", Schema: apiString},
+		}, apiTermParameters...),
+	},
+	{
+		Method:  "GET",
+		Path:    "/exams/{code}",
+		Summary: "The exam dates of a course",
+		Parameters: append([]apiParameter{
+			{Name: "code", In: "path", Required: true, Schema: apiString},
+		}, apiTermParameters...),
+	},
+	{
+		Method:  "GET",
+		Path:    "/fillrates/{code}",
+		Summary: "How full the groups of a course have been, by group id",
+		Parameters: append([]apiParameter{
+			{Name: "code", In: "path", Required: true, Schema: apiString},
+		}, apiTermParameters...),
+	},
+	{
+		Method:     "POST",
+		Path:       "/api/v1/exams:check",
+		Summary:    "The exam dates of several courses on the same or consecutive days",
+		Parameters: apiTermParameters,
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"courses"},
+			Properties: map[string]*apiSchema{
+				"courses": &apiSchema{Type: "array", Items: apiString, MaxItems: maxItems(MAX_EXAM_CHECK_COURSES)},
+			},
+		}),
+	},
+	{
+		Method:  "POST",
+		Path:    "/api/v1/courses:batch",
+		Summary: "The groups of events of several courses; those over the fetch budget are fetched in the background and listed in pending, with retry_after",
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"codes"},
+			Properties: map[string]*apiSchema{
+				"codes":    &apiSchema{Type: "array", Items: apiString, MaxItems: maxItems(MAX_BATCH_SIZE)},
+				"semester": apiSemester,
+				"year":     apiYear,
+			},
+		}),
+	},
+	{
+		Method:  "POST",
+		Path:    "/solverquery/",
+		Summary: "Finds the best schedule of the courses",
+		Body:    apiSolverQuery,
+	},
+	{
+		Method:  "POST",
+		Path:    "/api/v1/evaluate",
+		Summary: "Scores a schedule by the criteria of the solver, see evaluateRequest",
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"courses", "selection"},
+			Properties: map[string]*apiSchema{
+				"courses":   arrayOf(apiSolverCourse),
+				"selection": &apiSchema{Type: "array", Items: apiNullableInteger(number(0), "")},
+				"previous":  &apiSchema{Type: "array", Items: apiNullableInteger(number(0), "")},
+				"weights":   apiWeights,
+				"commute":   apiCommute,
+			},
+		}),
+	},
+	{
+		Method:  "POST",
+		Path:    "/api/v1/validate",
+		Summary: "Compares the chosen groups of a schedule with SIS now, see validateRequest",
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"courses", "selection"},
+			Properties: map[string]*apiSchema{
+				"courses":   arrayOf(apiSolverCourse),
+				"selection": &apiSchema{Type: "array", Items: apiNullableInteger(number(0), "")},
+				"year":      apiYear,
+			},
+		}),
+	},
+	{
+		Method:  "POST",
+		Path:    "/api/v1/whatif",
+		Summary: "Tells whether a course can be added to (or removed from) a schedule, see whatIfRequest",
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"courses", "selection"},
+			Properties: map[string]*apiSchema{
+				"courses":   arrayOf(apiSolverCourse),
+				"selection": &apiSchema{Type: "array", Items: apiNullableInteger(number(0), "")},
+				"add":       apiSolverCourse,
+				"remove":    &apiSchema{Type: "array", Items: &apiSchema{Type: "integer", Minimum: number(0)}},
+				"weights":   apiWeights,
+			},
+		}),
+	},
+	{
+		Method:      "POST",
+		Path:        "/api/v1/schedules:free",
+		MaxBodySize: MAX_FREE_TIME_REQUEST_SIZE,
+		Summary:     "Finds the weekly windows when all of the saved schedules and calendars are free, see freeTimeRequest",
+		Body: closed(&apiSchema{
+			Type: "object",
+			Properties: map[string]*apiSchema{
+				"schedules":   &apiSchema{Type: "array", Items: apiString, MaxItems: maxItems(MAX_FREE_TIME_PARTICIPANTS)},
+				"calendars":   &apiSchema{Type: "array", Items: &apiSchema{Type: "string", Description: "An iCalendar file"}, MaxItems: maxItems(MAX_FREE_TIME_PARTICIPANTS)},
+				"min_minutes": &apiSchema{Type: "integer", Minimum: number(1)},
+				"from":        apiClock,
+				"to":          apiClock,
+				"days":        &apiSchema{Type: "array", Items: &apiSchema{Type: "integer", Minimum: number(0), Maximum: number(6)}},
+				"semester":    apiSemester,
+			},
+		}),
+	},
+	{
+		Method:  "POST",
+		Path:    "/profiles/",
+		Summary: "Saves a preference profile, see profilePreferences",
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"name", "preferences"},
+			Properties: map[string]*apiSchema{
+				"name": apiString,
+				"preferences": closed(&apiSchema{
+					Type: "object",
+					Properties: map[string]*apiSchema{
+						"weights":   apiWeights,
+						"credits":   apiCredits,
+						"languages": apiLanguages,
+						"blocked_times": arrayOf(closed(&apiSchema{
+							Type:     "object",
+							Required: []string{"day", "from", "to"},
+							Properties: map[string]*apiSchema{
+								"day":  apiString,
+								"from": apiClock,
+								"to":   apiClock,
+							},
+						})),
+						"banned_teachers":    arrayOf(apiString),
+						"banned_teacher_ids": arrayOf(apiString),
+						"rules":              arrayOf(apiString),
+					},
+				}),
+			},
+		}),
+	},
+	{
+		Method:  "GET",
+		Path:    "/notifications/",
+		Summary: "The notification settings of the user (with the code to link chats) and the courses they follow",
+	},
+	{
+		Method:  "POST",
+		Path:    "/notifications/",
+		Summary: "Saves the notification settings of the user; a new address is sent a link to confirm it",
+		Body: closed(&apiSchema{
+			Type: "object",
+			Properties: map[string]*apiSchema{
+				"email":          apiString,
+				"language":       &apiSchema{Type: "string", Enum: []interface{}{"cs", "en"}},
+				"course_changes": apiBoolean,
+				"job_done":       apiBoolean,
+				"digest":         apiBoolean,
+				"channels":       arrayOf(apiString),
+				"courses":        arrayOf(apiString),
+				"confirmed":      apiBoolean,
+				"link_code":      apiString,
+			},
+		}),
+	},
+	{
+		Method:  "DELETE",
+		Path:    "/notifications/",
+		Summary: "Forgets the notification settings of the user",
+	},
+	{
+		Method:  "DELETE",
+		Path:    "/api/v1/userdata",
+		Summary: "Deletes all data of the user and the given saved schedules",
+		Parameters: []apiParameter{
+			{Name: "schedule", In: "query", Description: "A saved schedule as <token>:<owner token> (or its token, if the user saved it), repeated for more", Schema: apiString},
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/notifications/confirm",
+		Summary: "Confirms the address of the user, linked from the confirmation email",
+		Parameters: []apiParameter{
+			{Name: "user", In: "query", Required: true, Schema: apiString},
+			{Name: "code", In: "query", Required: true, Schema: apiString},
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/notifications/unsubscribe",
+		Summary: "Stops the emails to the user, linked from each email",
+		Parameters: []apiParameter{
+			{Name: "user", In: "query", Required: true, Schema: apiString},
+			{Name: "code", In: "query", Required: true, Schema: apiString},
+		},
+	},
+	{
+		Method:  "POST",
+		Path:    "/export/png",
+		Summary: "A PNG image of the weekly grid of a schedule",
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"courses", "result"},
+			Properties: map[string]*apiSchema{
+				"courses":  arrayOf(apiSolverCourse),
+				"result":   arrayOf(apiNullableInteger(number(0), "")),
+				"semester": apiSemester,
+			},
+		}),
+	},
+	{
+		Method:      "POST",
+		Path:        "/api/v1/schedules:overlay",
+		MaxBodySize: MAX_OVERLAY_SCHEDULES * MAX_SAVED_SCHEDULE_SIZE,
+		Summary:     "Overlays several schedules, with the events they share and their conflicts, see overlayRequest",
+		Parameters: []apiParameter{
+			{Name: "format", In: "query", Schema: &apiSchema{Type: "string", Enum: []interface{}{"json", "png"}}},
+		},
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"schedules"},
+			Properties: map[string]*apiSchema{
+				"schedules": &apiSchema{Type: "array", MaxItems: maxItems(MAX_OVERLAY_SCHEDULES), Items: closed(&apiSchema{
+					Type: "object",
+					Properties: map[string]*apiSchema{
+						"label":    apiString,
+						"color":    apiString,
+						"token":    apiString,
+						"courses":  arrayOf(apiSolverCourse),
+						"result":   arrayOf(apiNullableInteger(number(0), "")),
+						"semester": apiSemester,
+					},
+				})},
+				"semester": apiSemester,
+			},
+		}),
+	},
+	{
+		Method:      "POST",
+		Path:        "/schedules/",
+		MaxBodySize: MAX_SAVED_SCHEDULE_SIZE,
+		Summary:     "Saves a schedule, to be looked at or embedded by its token",
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"courses", "result"},
+			Properties: map[string]*apiSchema{
+				"courses":  arrayOf(apiSolverCourse),
+				"result":   arrayOf(apiNullableInteger(number(0), "")),
+				"semester": apiSemester,
+			},
+		}),
+	},
+	{
+		Method:  "GET",
+		Path:    "/schedules/{token}",
+		Summary: "A saved schedule",
+	},
+	{
+		Method:  "DELETE",
+		Path:    "/schedules/{token}",
+		Summary: "Deletes a saved schedule, given its owner token in X-Schedule-Owner (or the X-Profile-Token of whoever saved it)",
+	},
+	{
+		Method:  "GET",
+		Path:    "/schedules/{token}.ics",
+		Summary: "An iCalendar feed of a saved schedule, made from the current course data on each fetch",
+	},
+	{
+		Method:  "POST",
+		Path:    "/schedules/{token}/caldav",
+		Summary: "Pushes the events of the calendar feed of a saved schedule to a CalDAV calendar, changing only what differs",
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"url"},
+			Properties: map[string]*apiSchema{
+				"url":      apiString,
+				"username": apiString,
+				"password": apiString,
+			},
+		}),
+	},
+	{
+		Method:  "GET",
+		Path:    "/embed/{token}",
+		Summary: "An HTML page of the weekly grid of a saved schedule, to be shown in an iframe",
+	},
+	{
+		Method:     "GET",
+		Path:       "/api/v1/messages",
+		Summary:    "The texts of the machine codes of errors, warnings and events in a language",
+		Parameters: []apiParameter{{Name: "lang", In: "query", Schema: &apiSchema{Type: "string", Enum: []interface{}{"cs", "en"}}}},
+	},
+	{
+		Method:  "POST",
+		Path:    "/enrollmentplan/",
+		Summary: "The order in which to enroll in the groups of a schedule",
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"courses", "result"},
+			Properties: map[string]*apiSchema{
+				"courses":   arrayOf(apiSolverCourse),
+				"result":    arrayOf(apiNullableInteger(number(0), "")),
+				"fallbacks": &apiSchema{Type: "array", Nullable: true},
+				"recheck":   apiBoolean,
+			},
+		}),
+	},
+	{
+		Method:  "GET",
+		Path:    "/search",
+		Summary: "Searches the cached courses",
+		Parameters: append([]apiParameter{
+			{Name: "q", In: "query", Required: true, Schema: apiString},
+		}, apiPageParameters(courseSorts...)...),
+	},
+	{
+		Method:     "GET",
+		Path:       "/api/v1/courses",
+		Summary:    "All the cached courses, in pages",
+		Parameters: apiPageParameters(courseSorts...),
+	},
+	{
+		Method:  "GET",
+		Path:    "/api/v1/dataset",
+		Summary: "All the cached courses of a semester with their groups and events, teachers anonymized, as JSON or CSV",
+		Parameters: append([]apiParameter{
+			{Name: "format", In: "query", Schema: &apiSchema{Type: "string", Enum: []interface{}{"json", "csv"}}},
+		}, apiTermParameters...),
+	},
+	{
+		Method:  "GET",
+		Path:    "/bundles/",
+		Summary: "The course bundles students can prefill their courses with",
+	},
+	{
+		Method:  "GET",
+		Path:    "/bundles/{id}",
+		Summary: "The courses of a bundle, with their default priorities and whether they are optional",
+		Parameters: []apiParameter{
+			{Name: "id", In: "path", Required: true, Schema: apiString},
+		},
+	},
+	{
+		Method:  "GET",
+		Path:    "/history/diff",
+		Summary: "What changed between two schedules found for the user",
+		Parameters: []apiParameter{
+			{Name: "from", In: "query", Required: true, Schema: apiString},
+			{Name: "to", In: "query", Required: true, Schema: apiString},
+		},
+	},
+	{
+		Method:  "POST",
+		Path:    "/graphql",
+		Summary: "The GraphQL API",
+		Body: closed(&apiSchema{
+			Type:     "object",
+			Required: []string{"query"},
+			Properties: map[string]*apiSchema{
+				"query":         apiString,
+				"variables":     &apiSchema{Type: "object", Nullable: true},
+				"operationName": &apiSchema{Type: "string", Nullable: true},
+			},
+		}),
+	},
+}
+
+// Returns the OpenAPI document describing apiOperations.
+func openAPIDocument() map[string]interface{} {
+	paths := map[string]map[string]interface{}{}
+	for _, op := range apiOperations {
+		o := map[string]interface{}{
+			"summary": op.Summary,
+			"responses": map[string]interface{}{
+				"200": map[string]string{"description": `{"data": ...}, or {"error": "..."} if the request failed`},
+				"400": map[string]string{"description": `{"error": "...", "errors": [{"in", "path", "message"}]} if the request doesn't match this description`},
+			},
+		}
+		if len(op.Parameters) > 0 {
+			o["parameters"] = op.Parameters
+		}
+		if op.Body != nil {
+			o["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": op.Body},
+				},
+			}
+		}
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = o
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "Samorozvrh",
+			"version": "1",
+		},
+		"paths": paths,
+	}
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := json.MarshalIndent(openAPIDocument(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(s)
+}
+
+// Returns the operation of the request and the values of its path
+// parameters, or nil if the request isn't described.
+func findOperation(r *http.Request) (*apiOperation, map[string]string) {
+	for i, op := range apiOperations {
+		if op.Method != r.Method {
+			continue
+		}
+		if params, ok := matchPath(op.Path, r.URL.Path); ok {
+			return &apiOperations[i], params
+		}
+	}
+	return nil, nil
+}
+
+// Matches a path against a pattern such as "/sisquery/{code}", where
+// a parameter stands for the rest of the segment.
+func matchPath(pattern, path string) (map[string]string, bool) {
+	pp := strings.Split(pattern, "/")
+	p := strings.Split(path, "/")
+	if len(pp) != len(p) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i := range pp {
+		if strings.HasPrefix(pp[i], "{") && strings.HasSuffix(pp[i], "}") {
+			params[pp[i][1:len(pp[i])-1]] = p[i]
+		} else if pp[i] != p[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// Refuses requests which don't match their operation in apiOperations with
+// the status 400 and {"error": "...", "errors": [validationError, ...]}.
+func validationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, pathParams := findOperation(r)
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var errs []validationError
+		for _, p := range op.Parameters {
+			var value string
+			var present bool
+			if p.In == "path" {
+				value = pathParams[p.Name]
+				present = value != ""
+			} else {
+				_, present = r.URL.Query()[p.Name]
+				value = r.URL.Query().Get(p.Name)
+			}
+			if !present {
+				if p.Required {
+					errs = append(errs, validationError{In: p.In, Path: p.Name, Message: "is required"})
+				}
+				continue
+			}
+			p.Schema.validateParameter(value, p.In, p.Name, &errs)
+		}
+		if op.Body != nil {
+			limit := op.MaxBodySize
+			if limit == 0 {
+				limit = MAX_VALIDATED_BODY_SIZE
+			}
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+			r.Body.Close()
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				fmt.Fprintf(w, `{"error":"The request body is over %d bytes"}`, limit)
+				return
+			} else if err != nil {
+				fmt.Fprintf(w, `{"error":"%s"}`, err)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			var v interface{}
+			if err := json.Unmarshal(body, &v); err != nil {
+				errs = append(errs, validationError{In: "body", Path: "$", Message: "is not valid JSON: " + err.Error()})
+			} else {
+				op.Body.validate(v, "$", &errs)
+			}
+		}
+		if len(errs) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		logf(r.Context(), "Invalid request to %s: %s", op.Path, errs[0])
+		messages := []string{}
+		for _, e := range errs {
+			messages = append(messages, e.String())
+		}
+		s, _ := json.Marshal(map[string]interface{}{
+			"error":  "Invalid request: " + strings.Join(messages, "; "),
+			"errors": errs,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(s)
+	})
+}
+
+// Checks a path or query parameter, which is a string even if its schema
+// says it is a number.
+func (s *apiSchema) validateParameter(value string, in string, path string, errs *[]validationError) {
+	var v interface{} = value
+	if s.Type == "integer" || s.Type == "number" {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			*errs = append(*errs, validationError{In: in, Path: path, Message: "must be a number"})
+			return
+		}
+		v = f
+	}
+	before := len(*errs)
+	s.validate(v, path, errs)
+	for i := before; i < len(*errs); i++ {
+		(*errs)[i].In = in
+	}
+}
+
+// Returns the JSON type of a value decoded by encoding/json.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// Reports whether a value of the JSON type t has the type of the schema.
+func (s *apiSchema) accepts(t string) bool {
+	return s.Type == "" || s.Type == t || (s.Type == "number" && t == "integer")
+}
+
+// Appends the places where v doesn't match the schema to errs.
+func (s *apiSchema) validate(v interface{}, path string, errs *[]validationError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, validationError{In: "body", Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	t := jsonType(v)
+	if t == "null" {
+		if !s.Nullable && s.Type != "" {
+			fail("must not be null")
+		}
+		return
+	}
+	if len(s.OneOf) > 0 {
+		types := []string{}
+		for _, alt := range s.OneOf {
+			if alt.accepts(t) {
+				alt.validate(v, path, errs)
+				return
+			}
+			types = append(types, alt.Type)
+		}
+		fail("must be of type %s", strings.Join(types, " or "))
+		return
+	}
+	if !s.accepts(t) {
+		fail("must be of type %s", s.Type)
+		return
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		allowed := []string{}
+		for _, e := range s.Enum {
+			allowed = append(allowed, fmt.Sprint(e))
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+			}
+		}
+		if !found {
+			fail("must be one of %s", strings.Join(allowed, ", "))
+		}
+	}
+	switch v := v.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	case string:
+		if s.patternRegexp != nil && !s.patternRegexp.MatchString(v) {
+			if s.Description != "" {
+				fail("must be %s", strings.ToLower(s.Description[:1])+s.Description[1:])
+			} else {
+				fail("must match %s", s.Pattern)
+			}
+		}
+	case []interface{}:
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, validationError{In: "body", Path: path + "." + name, Message: "is required"})
+			}
+		}
+		names := []string{}
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if p, ok := s.Properties[name]; ok {
+				p.validate(v[name], path+"."+name, errs)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*errs = append(*errs, validationError{In: "body", Path: path + "." + name, Message: "is not a known property"})
+			}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Overlaying several schedules, e.g. of a couple or of roommates, to see
+// their commitments of the week together: events which the schedules have
+// in common (the same group of the same course) are shown once, and events
+// of different schedules at the same time are marked as conflicts.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// The most schedules of one overlay, as each takes a lane of the days
+// in the image
+const MAX_OVERLAY_SCHEDULES = 6
+
+// The color of events of several schedules in the image
+var pngShared = color.RGBA{0x8c, 0x8c, 0x8c, 0xff}
+
+// E.g. {"schedules": [{"label": "Anna", "token": "3f2a..."},
+// {"label": "Ben", "courses": [...], "result": [...]}], "semester": 1}
+type overlayRequest struct {
+	Schedules []overlaySchedule `json:"schedules"`
+	// Only the events of the semester, if given
+	Semester int `json:"semester"`
+}
+
+// A saved schedule by its token, or a schedule as for /export/png
+type overlaySchedule struct {
+	Label string `json:"label"`
+	// "#rrggbb" of its events in the image, one of pngPalette by default
+	Color string `json:"color"`
+	Token string `json:"token"`
+	pngRequest
+}
+
+type overlay struct {
+	Schedules []overlayPerson `json:"schedules"`
+	Events    []overlayEvent  `json:"events"`
+	// How many pairs of events of different schedules collide
+	Conflicts int `json:"conflicts"`
+}
+
+type overlayPerson struct {
+	Label string `json:"label"`
+	Color string `json:"color"`
+}
+
+type overlayEvent struct {
+	Event sisparse.Event `json:"event"`
+	// The name of the course
+	Label string `json:"label"`
+	// The schedules (by their index) with the event, several if shared
+	Schedules []int `json:"schedules"`
+	Shared    bool  `json:"shared"`
+	// The events (by their index) of other schedules at the same time
+	Conflicts []int `json:"conflicts"`
+}
+
+// Answers POST /api/v1/schedules:overlay with an overlayRequest with
+// {"data":overlay}, or with ?format=png, with the image of the overlay,
+// the schedules side by side in each day.
+func overlayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use POST"}`)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAX_OVERLAY_SCHEDULES*MAX_SAVED_SCHEDULE_SIZE))
+	r.Body.Close()
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	var req overlayRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		fmt.Fprint(w, `{"error":"Expected {\"schedules\":[{\"token\":\"...\"},...]}"}`)
+		return
+	}
+	ov, err := buildOverlay(r.Context(), req)
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	if r.URL.Query().Get("format") == "png" {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, renderTimetable(r.Context(), overlayPNGEvents(ov))); err != nil {
+			logf(r.Context(), "PNG export error: %s", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, `{"error":"%s"}`, err)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Disposition", `inline; filename="rozvrhy.png"`)
+		w.Write(buf.Bytes())
+		return
+	}
+	s, _ := json.Marshal(ov)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func buildOverlay(ctx context.Context, req overlayRequest) (overlay, error) {
+	ov := overlay{Schedules: []overlayPerson{}, Events: []overlayEvent{}}
+	if len(req.Schedules) < 2 || len(req.Schedules) > MAX_OVERLAY_SCHEDULES {
+		return ov, fmt.Errorf("Expected 2 to %d schedules", MAX_OVERLAY_SCHEDULES)
+	}
+	// Shared events by what makes them the same
+	shared := map[string]int{}
+	for i, s := range req.Schedules {
+		if s.Token != "" {
+			saved, err := db.GetSchedule(s.Token)
+			if err == store.ErrNotFound {
+				return ov, fmt.Errorf("No schedule %s", s.Token)
+			} else if err != nil {
+				return ov, err
+			}
+			if err := json.Unmarshal([]byte(saved.Data), &s.pngRequest); err != nil {
+				return ov, err
+			}
+		}
+		if req.Semester != 0 {
+			s.pngRequest.Semester = req.Semester
+		}
+		events, err := pngEvents(s.pngRequest)
+		if err != nil {
+			return ov, fmt.Errorf("Schedule %d: %s", i+1, err)
+		}
+		p := overlayPerson{Label: s.Label, Color: s.Color}
+		if p.Label == "" {
+			p.Label = strconv.Itoa(i + 1)
+		}
+		if p.Color == "" {
+			c := pngPalette[i%len(pngPalette)]
+			p.Color = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+		} else if _, ok := parseHexColor(p.Color); !ok {
+			return ov, fmt.Errorf("Invalid color %s of %s", p.Color, p.Label)
+		}
+		ov.Schedules = append(ov.Schedules, p)
+
+		for _, e := range events {
+			e.WeekParity = resolveWeekParity(ctx, e.Event)
+			group := e.GroupID
+			if group == "" {
+				group = e.Label
+			}
+			key := fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%d-%d\x00%d\x00%s",
+				e.CourseCode, group, e.Semester, e.Day, e.TimeFrom, e.TimeTo, e.WeekParity, e.Room)
+			if k, ok := shared[key]; ok {
+				if last := ov.Events[k].Schedules; last[len(last)-1] != i {
+					ov.Events[k].Schedules = append(last, i)
+					ov.Events[k].Shared = true
+				}
+				continue
+			}
+			shared[key] = len(ov.Events)
+			ov.Events = append(ov.Events, overlayEvent{Event: e.Event, Label: e.Label, Schedules: []int{i}, Conflicts: []int{}})
+		}
+	}
+
+	sort.SliceStable(ov.Events, func(i, j int) bool {
+		a, b := ov.Events[i], ov.Events[j]
+		if a.Event.Day != b.Event.Day {
+			return a.Event.Day < b.Event.Day
+		}
+		return a.Event.TimeFrom < b.Event.TimeFrom
+	})
+	for i := range ov.Events {
+		for j := i + 1; j < len(ov.Events); j++ {
+			a, b := &ov.Events[i], &ov.Events[j]
+			if !inCommon(a.Schedules, b.Schedules) && eventsCollide(a.Event, b.Event) {
+				a.Conflicts = append(a.Conflicts, j)
+				b.Conflicts = append(b.Conflicts, i)
+				ov.Conflicts++
+			}
+		}
+	}
+	return ov, nil
+}
+
+// Whether the lists of schedules have one in common; collisions within a
+// schedule are its own business, not a conflict between people.
+func inCommon(a, b []int) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// The events to draw, each schedule in a lane of its own and shared events
+// over the lanes of all, labelled with the schedules.
+func overlayPNGEvents(ov overlay) []pngEvent {
+	res := []pngEvent{}
+	for _, e := range ov.Events {
+		p := pngEvent{Event: e.Event, Label: e.Label, Lanes: len(ov.Schedules), Conflict: len(e.Conflicts) > 0}
+		if e.Shared {
+			labels := []string{}
+			for _, i := range e.Schedules {
+				labels = append(labels, ov.Schedules[i].Label)
+			}
+			p.Label += " (" + strings.Join(labels, ", ") + ")"
+			p.Color, p.Lanes = pngShared, 0
+		} else {
+			p.Lane = e.Schedules[0]
+			p.Color, _ = parseHexColor(ov.Schedules[p.Lane].Color)
+		}
+		res = append(res, p)
+	}
+	return res
+}
@@ -0,0 +1,204 @@
+// Corrections of course data by the operator, merged over what SIS says.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// Where override files are looked for, see -overrides
+var overridesDir string
+
+// The contents of an override file, <code>.json (<code>@2.json for the
+// summer semester) in overridesDir.
+type courseOverride struct {
+	// Why the data were corrected, shown to users
+	Reason string `json:"reason"`
+	// The GroupIDs of groups which won't take place
+	RemoveGroups []string      `json:"remove_groups"`
+	Change       []eventChange `json:"change"`
+	// Groups missing in SIS
+	AddGroups [][]sisparse.Event `json:"add_groups"`
+}
+
+// A correction of the events of a group: the fields given in Set (as in
+// the JSON of events, e.g. {"room": "S3"}) replace those of the events.
+type eventChange struct {
+	GroupID string `json:"group_id"`
+	// The index of the event within the group to change; all of them if not given
+	Event *int                       `json:"event"`
+	Set   map[string]json.RawMessage `json:"set"`
+}
+
+// What was changed, added to the answer as "override" so that users
+// can see the data were patched.
+type overrideSummary struct {
+	Reason        string    `json:"reason"`
+	Updated       time.Time `json:"updated"` // When the override file was changed
+	RemovedGroups int       `json:"removed_groups"`
+	ChangedEvents int       `json:"changed_events"`
+	AddedGroups   int       `json:"added_groups"`
+}
+
+// Applies the override file of the course (if there is one) to an answer
+// of the form {"data":[[event, ...], ...]}. A broken override file is
+// reported in the log and the answer is left as it is.
+func applyOverride(ctx context.Context, code string, sem int, res string) string {
+	if overridesDir == "" {
+		return res
+	}
+	path := filepath.Join(overridesDir, getCourseCacheName(code, sem)+".json")
+	info, err := os.Stat(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logf(ctx, "Override error: %s", err)
+		}
+		return res
+	}
+	patched, err := patchAnswer(res, path, info.ModTime(), code, sem)
+	if err != nil {
+		logf(ctx, "Override error in %s: %s", path, err)
+		return res
+	}
+	logf(ctx, "  %s (patched by %s from %s)", code, filepath.Base(path), info.ModTime().Format(time.RFC3339))
+	return patched
+}
+
+func patchAnswer(res string, path string, updated time.Time, code string, sem int) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var override courseOverride
+	if err := json.Unmarshal(data, &override); err != nil {
+		return "", err
+	}
+
+	var answer map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(res), &answer); err != nil {
+		return "", err
+	}
+	if _, ok := answer["data"]; !ok {
+		// An error, nothing to patch
+		return res, nil
+	}
+	var groups [][]sisparse.Event
+	if err := json.Unmarshal(answer["data"], &groups); err != nil {
+		return "", err
+	}
+
+	groups, summary, err := override.apply(groups, code, sem)
+	if err != nil {
+		return "", err
+	}
+	summary.Updated = updated
+	if answer["data"], err = json.Marshal(groups); err != nil {
+		return "", err
+	}
+	if answer["override"], err = json.Marshal(summary); err != nil {
+		return "", err
+	}
+	patched, err := json.Marshal(answer)
+	return string(patched), err
+}
+
+func (o courseOverride) apply(groups [][]sisparse.Event, code string, sem int) ([][]sisparse.Event, overrideSummary, error) {
+	summary := overrideSummary{Reason: o.Reason}
+
+	removed := map[string]bool{}
+	for _, id := range o.RemoveGroups {
+		removed[id] = true
+	}
+	kept := [][]sisparse.Event{}
+	found := map[string]bool{}
+	for _, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		found[group[0].GroupID] = true
+		if removed[group[0].GroupID] {
+			summary.RemovedGroups++
+			continue
+		}
+		kept = append(kept, group)
+	}
+	for _, id := range o.RemoveGroups {
+		if !found[id] {
+			return nil, summary, fmt.Errorf("No group %s to remove", id)
+		}
+	}
+
+	for _, c := range o.Change {
+		if !found[c.GroupID] {
+			return nil, summary, fmt.Errorf("No group %s to change", c.GroupID)
+		}
+		for _, group := range kept {
+			if group[0].GroupID != c.GroupID {
+				continue
+			}
+			for i := range group {
+				if c.Event != nil && *c.Event != i {
+					continue
+				}
+				if err := setEventFields(&group[i], c.Set); err != nil {
+					return nil, summary, fmt.Errorf("Invalid change of %s: %s", c.GroupID, err)
+				}
+				summary.ChangedEvents++
+			}
+		}
+	}
+
+	for gi, group := range o.AddGroups {
+		if len(group) == 0 {
+			continue
+		}
+		groupId := group[0].GroupID
+		if groupId == "" {
+			groupId = fmt.Sprintf("%s-%d-override-%d", code, sem, gi+1)
+		}
+		for i := range group {
+			group[i].CourseCode = code
+			group[i].Semester = sem
+			group[i].GroupID = groupId
+			group[i].Patched = true
+		}
+		kept = append(kept, group)
+		summary.AddedGroups++
+	}
+	return kept, summary, nil
+}
+
+// Replaces the fields of the event by those given in JSON.
+func setEventFields(e *sisparse.Event, fields map[string]json.RawMessage) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for k, v := range fields {
+		if k == "schema_version" {
+			continue
+		}
+		m[k] = v
+	}
+	if data, err = json.Marshal(m); err != nil {
+		return err
+	}
+	var changed sisparse.Event
+	if err := json.Unmarshal(data, &changed); err != nil {
+		return err
+	}
+	changed.Patched = true
+	*e = changed
+	return nil
+}
@@ -0,0 +1,119 @@
+// Paging of the answers which may list thousands of items (the search, the
+// catalog of courses, the demand for groups): ?limit= items at most (capped
+// at MAX_PAGE_SIZE) in the order of ?sort=, and the answer's "next_cursor"
+// given as ?cursor= for the items after them. A cursor holds the sort key
+// of the last item of its page rather than an offset, so that pages don't
+// skip or repeat items when courses are added in between.
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const DEFAULT_PAGE_SIZE = 50
+const MAX_PAGE_SIZE = 500
+
+type pageRequest struct {
+	Limit int
+	// One of the sorts the answer offers
+	Sort string
+	// The sort key of the last item of the previous page, empty for the
+	// first page
+	After string
+}
+
+// Reads ?limit=, ?sort= (one of sorts, the first by default) and ?cursor=.
+func parsePageRequest(r *http.Request, sorts ...string) (pageRequest, error) {
+	q := r.URL.Query()
+	p := pageRequest{Limit: DEFAULT_PAGE_SIZE, Sort: sorts[0]}
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MAX_PAGE_SIZE {
+			return p, fmt.Errorf("The limit must be between 1 and %d", MAX_PAGE_SIZE)
+		}
+		p.Limit = n
+	}
+	if s := q.Get("sort"); s != "" {
+		known := false
+		for _, sort := range sorts {
+			known = known || s == sort
+		}
+		if !known {
+			return p, fmt.Errorf("Unknown sort %s, expected one of %s", s, strings.Join(sorts, ", "))
+		}
+		p.Sort = s
+	}
+	if s := q.Get("cursor"); s != "" {
+		cursor, err := base64.RawURLEncoding.DecodeString(s)
+		parts := strings.SplitN(string(cursor), "\x00", 2)
+		if err != nil || len(parts) != 2 {
+			return p, fmt.Errorf("Invalid cursor")
+		}
+		if parts[0] != p.Sort {
+			return p, fmt.Errorf("The cursor is of another sort (%s)", parts[0])
+		}
+		p.After = parts[1]
+	}
+	return p, nil
+}
+
+// Returns the items [from, to) of the page of items with the sort keys,
+// which must be sorted and unique, and the cursor of the next page (empty
+// if this is the last one).
+func (p pageRequest) page(keys []string) (from int, to int, next string) {
+	if p.After != "" {
+		from = sort.Search(len(keys), func(i int) bool { return keys[i] > p.After })
+	}
+	to = from + p.Limit
+	if to >= len(keys) {
+		return from, len(keys), ""
+	}
+	return from, to, base64.RawURLEncoding.EncodeToString([]byte(p.Sort + "\x00" + keys[to-1]))
+}
+
+// Returns {"data":data,"next_cursor":"..."}, without the cursor on the last
+// page.
+func pageJSON(data interface{}, next string) (string, error) {
+	s, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	if next == "" {
+		return fmt.Sprintf(`{"data":%s}`, string(s)), nil
+	}
+	return fmt.Sprintf(`{"data":%s,"next_cursor":"%s"}`, string(s), next), nil
+}
+
+// Sorts the items by their keys, which keys(i) gives, and returns the keys
+// in the same order; swap must swap two items.
+func sortByKeys(n int, key func(i int) string, swap func(i, j int)) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = key(i)
+	}
+	sort.Sort(keySorter{keys, swap})
+	return keys
+}
+
+type keySorter struct {
+	keys []string
+	swap func(i, j int)
+}
+
+func (s keySorter) Len() int           { return len(s.keys) }
+func (s keySorter) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s keySorter) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.swap(i, j)
+}
+
+// A sort key part for a number sorted from the highest
+func descending(n int) string {
+	return fmt.Sprintf("%010d", 1<<31-1-n)
+}
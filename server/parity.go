@@ -0,0 +1,75 @@
+// Events which SIS gives a calendar week parity are resolved against the
+// academic calendar of the tenant before overlaps are looked for, so that
+// the solver compares the weeks the events really take place in.
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// Returns the parity of the teaching weeks the event takes place in, by
+// the calendar of the tenant of ctx if it has one and the event is of its
+// semester.
+func resolveWeekParity(ctx context.Context, e sisparse.Event) sisparse.WeekParity {
+	semester := tenantOf(ctx).semester
+	if semester == nil || e.CalendarParity == sisparse.EveryWeek {
+		return e.WeekParity
+	}
+	if _, sem := semester.Term(); e.Semester != 0 && e.Semester != sem {
+		return e.WeekParity
+	}
+	return semester.ResolveWeekParity(e)
+}
+
+// Sets the "week_parity" of the events with a "calendar_parity" in the
+// solver query (a JSON array of courses with options) by resolveWeekParity.
+// Other events, and the rest of the query, are left as they are. A query
+// which can't be read is returned as it is, for the solver to complain.
+func resolveQueryWeekParities(ctx context.Context, query []byte) []byte {
+	if tenantOf(ctx).semester == nil {
+		return query
+	}
+	var courses []map[string]json.RawMessage
+	if err := json.Unmarshal(query, &courses); err != nil {
+		return query
+	}
+	changed := false
+	for _, c := range courses {
+		var options [][]map[string]json.RawMessage
+		if err := json.Unmarshal(c["options"], &options); err != nil {
+			return query
+		}
+		optionsChanged := false
+		for _, option := range options {
+			for _, raw := range option {
+				if _, ok := raw["calendar_parity"]; !ok {
+					continue
+				}
+				s, _ := json.Marshal(raw)
+				var e sisparse.Event
+				if err := json.Unmarshal(s, &e); err != nil {
+					return query
+				}
+				if p := resolveWeekParity(ctx, e); p != e.WeekParity {
+					raw["week_parity"], _ = json.Marshal(p)
+					optionsChanged = true
+				}
+			}
+		}
+		if optionsChanged {
+			c["options"], _ = json.Marshal(options)
+			changed = true
+		}
+	}
+	if !changed {
+		return query
+	}
+	res, err := json.Marshal(courses)
+	if err != nil {
+		return query
+	}
+	return res
+}
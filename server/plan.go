@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// What the client sends to /enrollmentplan/: the solver query and the
+// solver's answer to it (the index of the chosen option of each course,
+// or null).
+type planRequest struct {
+	Courses []struct {
+		Name    string             `json:"name"`
+		Options [][]sisparse.Event `json:"options"`
+	} `json:"courses"`
+	Result []*int `json:"result"`
+	// The fallbacks computed by the solver, if any
+	Fallbacks []*solverFallback `json:"fallbacks"`
+	// Whether to fetch the current occupancy of the chosen groups from SIS
+	Recheck bool `json:"recheck"`
+}
+
+// What to do if the chosen option of a course is full, as computed by the solver.
+type solverFallback struct {
+	Option *int `json:"option"`
+	// Other courses which have to change, as [course index, option or null]
+	Changes [][2]*int `json:"changes"`
+}
+
+// The group to enroll in if the one chosen in a step is full.
+type planFallback struct {
+	// Whether the course has to be dropped instead
+	Drop       bool             `json:"drop"`
+	GroupCodes []string         `json:"group_codes,omitempty"`
+	Events     []sisparse.Event `json:"events,omitempty"`
+	// Other courses whose groups have to change, as steps of their own
+	Changes []planStep `json:"changes"`
+}
+
+// One step of enrolling in a schedule: enrolling in one group of a course.
+type planStep struct {
+	Step       int    `json:"step"`
+	CourseCode string `json:"course_code"`
+	CourseName string `json:"course_name"`
+	Type       string `json:"type"`
+	// The codes of the events of the group, by which they are listed in SIS
+	GroupCodes []string         `json:"group_codes"`
+	Events     []sisparse.Event `json:"events"`
+	Url        string           `json:"url"`
+	// Whether some event of the group has no free places left
+	Full bool `json:"full"`
+	// With recheck: whether the group got full since the data were cached
+	FilledUp bool `json:"filled_up,omitempty"`
+	// What to do if the group is full, if the solver has computed it
+	Fallback *planFallback `json:"fallback,omitempty"`
+}
+
+// Turns a solved schedule into a list of enrollment steps with links to SIS,
+// as {"data":[step, ...]}. Expects a POST with a planRequest.
+func enrollmentPlanHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Expected a POST request"}`)
+		return
+	}
+	var req planRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fmt.Fprintf(w, `{"error":"Invalid request: %s"}`, err)
+		return
+	}
+	steps, err := makeEnrollmentPlan(req)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	if req.Recheck {
+		recheckOccupancy(r.Context(), steps)
+	}
+	s, _ := json.Marshal(steps)
+	fmt.Fprintf(w, `{"data":%s}`, s)
+}
+
+func makeEnrollmentPlan(req planRequest) ([]planStep, error) {
+	if len(req.Result) != len(req.Courses) {
+		return nil, fmt.Errorf("Expected %d results, got %d", len(req.Courses), len(req.Result))
+	}
+	if req.Fallbacks != nil && len(req.Fallbacks) != len(req.Courses) {
+		return nil, fmt.Errorf("Expected %d fallbacks, got %d", len(req.Courses), len(req.Fallbacks))
+	}
+	steps := []planStep{}
+	for i := range req.Courses {
+		step, ok, err := makePlanStep(req, i, req.Result[i])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if req.Fallbacks != nil && req.Fallbacks[i] != nil {
+			if step.Fallback, err = makePlanFallback(req, i, req.Fallbacks[i]); err != nil {
+				return nil, err
+			}
+		}
+		steps = append(steps, step)
+	}
+
+	// Keep the steps of a course together, lectures first, as that's
+	// the order in which SIS lets students enroll
+	sort.SliceStable(steps, func(i, j int) bool {
+		if steps[i].CourseCode != steps[j].CourseCode {
+			return steps[i].CourseCode < steps[j].CourseCode
+		}
+		return steps[i].Type == string(sisparse.Lecture) && steps[j].Type != string(sisparse.Lecture)
+	})
+	for i := range steps {
+		steps[i].Step = i + 1
+	}
+	return steps, nil
+}
+
+// Returns the step of enrolling in the given option of the i-th course,
+// or false if there is nothing to enroll in.
+func makePlanStep(req planRequest, i int, option *int) (planStep, bool, error) {
+	c := req.Courses[i]
+	if option == nil {
+		return planStep{}, false, nil
+	}
+	if *option < 0 || *option >= len(c.Options) {
+		return planStep{}, false, fmt.Errorf("Invalid option %d of %s", *option, c.Name)
+	}
+	group := c.Options[*option]
+	if len(group) == 0 {
+		return planStep{}, false, nil
+	}
+	step := planStep{
+		CourseCode: group[0].CourseCode,
+		CourseName: group[0].Name,
+		Type:       string(group[0].Type),
+		GroupCodes: getGroupCodes(group),
+		Events:     group,
+		Full:       isGroupFull(group),
+	}
+	if step.CourseCode != "" {
+		step.Url = sisparse.GetEnrollmentUrl(step.CourseCode)
+	}
+	return step, true, nil
+}
+
+func makePlanFallback(req planRequest, i int, f *solverFallback) (*planFallback, error) {
+	res := &planFallback{Drop: f.Option == nil, Changes: []planStep{}}
+	if f.Option != nil {
+		step, ok, err := makePlanStep(req, i, f.Option)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			res.GroupCodes = step.GroupCodes
+			res.Events = step.Events
+		}
+	}
+	for _, change := range f.Changes {
+		if change[0] == nil || *change[0] < 0 || *change[0] >= len(req.Courses) {
+			return nil, fmt.Errorf("Invalid fallback of %s", req.Courses[i].Name)
+		}
+		step, ok, err := makePlanStep(req, *change[0], change[1])
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			// The course is dropped
+			step = planStep{CourseName: req.Courses[*change[0]].Name, GroupCodes: []string{}}
+		}
+		res.Changes = append(res.Changes, step)
+	}
+	return res, nil
+}
+
+// Returns the distinct SIS codes of the events of the group.
+func getGroupCodes(group []sisparse.Event) []string {
+	res := []string{}
+	seen := map[string]bool{}
+	for _, e := range group {
+		if e.Code != "" && !seen[e.Code] {
+			seen[e.Code] = true
+			res = append(res, e.Code)
+		}
+	}
+	return res
+}
+
+func isGroupFull(group []sisparse.Event) bool {
+	for _, e := range group {
+		if e.IsFull() {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetches the courses of the plan from SIS again (refreshing the cache)
+// and updates the occupancy of the chosen groups, so that groups which
+// got full since the schedule was made can be flagged.
+func recheckOccupancy(ctx context.Context, steps []planStep) {
+	fresh := map[string][][]sisparse.Event{}
+	for i := range steps {
+		step := &steps[i]
+		if step.CourseCode == "" {
+			continue
+		}
+		groups, ok := fresh[step.CourseCode]
+		if !ok {
+			sem := step.Events[0].Semester
+			if sem == 0 {
+				sem = sisparse.Winter
+			}
+			res, err := fetchCourse(ctx, step.CourseCode, sem)
+			if err == nil {
+				groups, err = parseCourseResponse(res)
+			}
+			if err != nil {
+				logf(ctx, "Recheck error for %s: %s", step.CourseCode, err)
+			}
+			fresh[step.CourseCode] = groups
+		}
+
+		wasFull := step.Full
+		for j, e := range step.Events {
+			if current, ok := findEvent(groups, e); ok {
+				step.Events[j].Capacity = current.Capacity
+				step.Events[j].Enrolled = current.Enrolled
+			}
+		}
+		step.Full = isGroupFull(step.Events)
+		step.FilledUp = step.Full && !wasFull
+	}
+}
+
+// Finds the current version of the event by its type and time (and its
+// SIS code, if known).
+func findEvent(groups [][]sisparse.Event, e sisparse.Event) (sisparse.Event, bool) {
+	for _, group := range groups {
+		for _, g := range group {
+			if g.SameSlot(e) && (e.Code == "" || g.Code == e.Code) {
+				return g, true
+			}
+		}
+	}
+	return sisparse.Event{}, false
+}
@@ -0,0 +1,273 @@
+// Rendering a schedule as a PNG image of the weekly grid, to be shared
+// where a link to the webapp won't do (e.g. in group chats).
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The dimensions of the grid, in pixels
+const (
+	PNG_HOUR_HEIGHT  = 48
+	PNG_DAY_WIDTH    = 180
+	PNG_HEADER       = 20 // The height of the row with the names of the days
+	PNG_HOURS_COLUMN = 40 // The width of the column with the hours
+	PNG_PADDING      = 3  // Between the border of an event and its text
+)
+
+// The colors of the courses which don't say their own, chosen by the code
+// of the course (see courseColor)
+var pngPalette = []color.RGBA{
+	{0x4e, 0x79, 0xa7, 0xff}, {0xf2, 0x8e, 0x2b, 0xff}, {0x59, 0xa1, 0x4f, 0xff},
+	{0xe1, 0x57, 0x59, 0xff}, {0x76, 0xb7, 0xb2, 0xff}, {0xed, 0xc9, 0x48, 0xff},
+	{0xb0, 0x7a, 0xa1, 0xff}, {0xff, 0x9d, 0xa7, 0xff}, {0x9c, 0x75, 0x5f, 0xff},
+	{0xba, 0xb0, 0xac, 0xff},
+}
+
+var (
+	pngBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	pngGridLine   = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	pngText       = color.RGBA{0x22, 0x22, 0x22, 0xff}
+	pngConflict   = color.RGBA{0xd6, 0x27, 0x28, 0xff}
+)
+
+// What the client sends to /export/png: the courses of a solver query
+// and the option chosen for each of them, as for /enrollmentplan/.
+type pngRequest struct {
+	Courses []struct {
+		Name string `json:"name"`
+		// "#rrggbb", that of the course by default (see courseColor)
+		Color   string             `json:"color,omitempty"`
+		Options [][]sisparse.Event `json:"options"`
+	} `json:"courses"`
+	Result []*int `json:"result"`
+	// The blocks the solver reserved, drawn (and put in calendar feeds)
+	// as events of their own
+	Reserved []reservedBlock `json:"reserved,omitempty"`
+	// Only the events of the semester are drawn, if given
+	Semester int `json:"semester,omitempty"`
+}
+
+// The color of reserved blocks, apart from those of the courses
+var reservedColor = color.RGBA{0xc0, 0xc0, 0xc0, 0xff}
+
+// An event to draw
+type pngEvent struct {
+	sisparse.Event
+	Label string
+	Color color.RGBA
+	// Of several schedules drawn side by side (see overlay.go), the one
+	// the event is of, and how many there are (0 or 1 for one schedule)
+	Lane, Lanes int
+	// Outlined, e.g. when colliding with another schedule
+	Conflict bool
+}
+
+// Answers a POST with a pngRequest with the image/png of the schedule.
+func pngExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Expected a POST request"}`)
+		return
+	}
+	var req pngRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"Invalid request: %s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	events, err := pngEvents(req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, renderTimetable(r.Context(), events)); err != nil {
+		logf(r.Context(), "PNG export error: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", `inline; filename="rozvrh.png"`)
+	w.Write(buf.Bytes())
+}
+
+func pngEvents(req pngRequest) ([]pngEvent, error) {
+	if len(req.Result) != len(req.Courses) {
+		return nil, fmt.Errorf("Expected %d results, got %d", len(req.Courses), len(req.Result))
+	}
+	res := []pngEvent{}
+	for i, c := range req.Courses {
+		if req.Result[i] == nil {
+			continue
+		}
+		option := *req.Result[i]
+		if option < 0 || option >= len(c.Options) {
+			return nil, fmt.Errorf("Invalid option %d of %s", option, c.Name)
+		}
+		col, ok := scheduleCourseColor(i, groupCourseCode(c.Options[option]), c.Color)
+		if !ok {
+			return nil, fmt.Errorf("Invalid color %s of %s", c.Color, c.Name)
+		}
+		for _, e := range c.Options[option] {
+			if req.Semester != 0 && e.Semester != 0 && e.Semester != req.Semester {
+				continue
+			}
+			if e.Day < 0 || e.Day >= 7 || e.TimeFrom < 0 || e.TimeTo > 24*60 || e.TimeTo < e.TimeFrom {
+				return nil, fmt.Errorf("Invalid event of %s", c.Name)
+			}
+			label := c.Name
+			if label == "" {
+				label = e.Name
+			}
+			res = append(res, pngEvent{Event: e, Label: label, Color: col})
+		}
+	}
+	for _, b := range req.Reserved {
+		e, err := b.event()
+		if err != nil {
+			return nil, err
+		}
+		if req.Semester != 0 && e.Semester != 0 && e.Semester != req.Semester {
+			continue
+		}
+		res = append(res, pngEvent{Event: e, Label: e.Name, Color: reservedColor})
+	}
+	return res, nil
+}
+
+// Returns the reserved block as an event of every week.
+func (b reservedBlock) event() (sisparse.Event, error) {
+	from, err := sisparse.ParseClockTime(b.TimeFrom)
+	if err != nil {
+		return sisparse.Event{}, fmt.Errorf("Invalid reserved block %s", b.Name)
+	}
+	to, err := sisparse.ParseClockTime(b.TimeTo)
+	if err != nil || b.Day < 0 || b.Day >= 7 || to.Minutes() > 24*60 || to < from {
+		return sisparse.Event{}, fmt.Errorf("Invalid reserved block %s", b.Name)
+	}
+	name := b.Name
+	if name == "" {
+		name = "Reserved"
+	}
+	return sisparse.Event{Name: name, Semester: b.Semester, Day: b.Day, TimeFrom: from, TimeTo: to}, nil
+}
+
+// Parses a color of the form "#rrggbb".
+func parseHexColor(s string) (color.RGBA, bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return color.RGBA{}, false
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, true
+}
+
+// Draws (with labels in the language of ctx) the week from Monday to Friday (or to the last day with an event)
+// and from 8:00 (or the earliest event) to 18:00 (or the latest one).
+// Events of odd weeks take the left half of their day (or of their lane),
+// those of even weeks the right one.
+func renderTimetable(ctx context.Context, events []pngEvent) *image.RGBA {
+	days, fromHour, toHour := 5, 8, 18
+	for _, e := range events {
+		if e.Day+1 > days {
+			days = e.Day + 1
+		}
+		if e.TimeFrom.Hour() < fromHour {
+			fromHour = e.TimeFrom.Hour()
+		}
+		if h := (e.TimeTo.Minutes() + 59) / 60; h > toHour {
+			toHour = h
+		}
+	}
+
+	width := PNG_HOURS_COLUMN + days*PNG_DAY_WIDTH + 1
+	height := PNG_HEADER + (toHour-fromHour)*PNG_HOUR_HEIGHT + 1
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{pngBackground}, image.Point{}, draw.Src)
+
+	for h := fromHour; h <= toHour; h++ {
+		y := PNG_HEADER + (h-fromHour)*PNG_HOUR_HEIGHT
+		fillRect(img, image.Rect(0, y, width, y+1), pngGridLine)
+		if h < toHour {
+			drawText(img, 4, y+3, PNG_HOURS_COLUMN, fmt.Sprintf("%d:00", h), pngText)
+		}
+	}
+	for d := 0; d <= days; d++ {
+		x := PNG_HOURS_COLUMN + d*PNG_DAY_WIDTH
+		fillRect(img, image.Rect(x, 0, x+1, height), pngGridLine)
+		if d < days {
+			drawText(img, x+PNG_DAY_WIDTH/2-GLYPH_ADVANCE, (PNG_HEADER-GLYPH_HEIGHT)/2, x+PNG_DAY_WIDTH, dayName(ctx, d), pngText)
+		}
+	}
+
+	minuteY := func(t sisparse.ClockTime) int {
+		return PNG_HEADER + (t.Minutes()-fromHour*60)*PNG_HOUR_HEIGHT/60
+	}
+	for _, e := range events {
+		left, span := PNG_HOURS_COLUMN+e.Day*PNG_DAY_WIDTH, PNG_DAY_WIDTH
+		if e.Lanes > 1 {
+			left += e.Lane * PNG_DAY_WIDTH / e.Lanes
+			span = PNG_DAY_WIDTH / e.Lanes
+		}
+		x0 := left + 2
+		x1 := x0 + span - 3
+		switch e.WeekParity {
+		case sisparse.OddWeeks:
+			x1 = x0 + span/2 - 2
+		case sisparse.EvenWeeks:
+			x0 += span / 2
+		}
+		box := image.Rect(x0, minuteY(e.TimeFrom)+1, x1, minuteY(e.TimeTo)-1)
+		fillRect(img, box, e.Color)
+		if e.Conflict {
+			for _, edge := range []image.Rectangle{
+				image.Rect(box.Min.X, box.Min.Y, box.Max.X, box.Min.Y+2), image.Rect(box.Min.X, box.Max.Y-2, box.Max.X, box.Max.Y),
+				image.Rect(box.Min.X, box.Min.Y, box.Min.X+2, box.Max.Y), image.Rect(box.Max.X-2, box.Min.Y, box.Max.X, box.Max.Y),
+			} {
+				fillRect(img, edge.Intersect(box), pngConflict)
+			}
+		}
+		textColor := pngText
+		if luminance(e.Color) < 128 {
+			textColor = pngBackground
+		}
+		lines := []string{e.Label, e.TimeFrom.String() + "-" + e.TimeTo.String(), e.Room}
+		if weeks := eventWeeksLabel(ctx, e.Event); weeks != "" {
+			lines[1] += " (" + weeks + ")"
+		}
+		y := box.Min.Y + PNG_PADDING
+		for _, line := range lines {
+			if y+GLYPH_HEIGHT > box.Max.Y-PNG_PADDING {
+				break
+			}
+			drawText(img, box.Min.X+PNG_PADDING, y, box.Max.X-PNG_PADDING, line, textColor)
+			y += GLYPH_HEIGHT + 3
+		}
+	}
+	return img
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.Color) {
+	draw.Draw(img, r, &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+func luminance(c color.RGBA) int {
+	return (299*int(c.R) + 587*int(c.G) + 114*int(c.B)) / 1000
+}
@@ -0,0 +1,190 @@
+// Presolving: before the solver searches, options which can't be better
+// than another option of the same course are left out. Courses often have
+// many parallel groups at the same time with the same teacher (e.g. split
+// by study programme), which would only multiply the search space.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// An option left out of the query, and the option kept in its place
+type prunedOption struct {
+	Course int `json:"course"` // In the original query
+	Option int `json:"option"`
+	By     int `json:"by"`
+	// "duplicate" if the options are the same for the solver, "dominated"
+	// if the kept one is better by some criterion and no worse by any
+	Reason string `json:"reason"`
+}
+
+// Returns the query as a filteredQuery which leaves nothing out.
+func unfilteredQuery(query []byte) (filteredQuery, error) {
+	var courses []struct {
+		Options []json.RawMessage `json:"options"`
+	}
+	if err := json.Unmarshal(query, &courses); err != nil {
+		return filteredQuery{}, err
+	}
+	res := filteredQuery{Query: query, Unschedulable: []unschedulableCourse{}}
+	for i, c := range courses {
+		res.courseIndex = append(res.courseIndex, i)
+		indices := make([]int, len(c.Options))
+		for j := range indices {
+			indices[j] = j
+		}
+		res.optionIndices = append(res.optionIndices, indices)
+	}
+	return res, nil
+}
+
+// The events of an option as the solver sees them, in a canonical order:
+// options with the same key take place at the same times. The teacher is
+// a part of it too, as students tell groups apart by their teachers.
+// Returns false for events which aren't as /sisquery/ returns them.
+func optionKey(option []map[string]json.RawMessage) (string, bool) {
+	keys := []string{}
+	for _, raw := range option {
+		s, _ := json.Marshal(raw)
+		var e sisparse.Event
+		if err := json.Unmarshal(s, &e); err != nil {
+			return "", false
+		}
+		keys = append(keys, fmt.Sprintf("%d %d %s %s %s %s [%s] %s %s %s", e.Semester, e.Day, e.TimeFrom, e.TimeTo,
+			e.WeekParity, e.CalendarParity, e.WeekList(), e.Type, e.Teacher, raw["skippable"]))
+	}
+	sort.Strings(keys)
+	s, _ := json.Marshal(keys)
+	return string(s), true
+}
+
+// Leaves out the options which another option of the same course with the
+// same key (see optionKey) dominates: its penalty is no higher, it isn't
+// full unless the other one is, and the other one isn't the previous
+// option. Of options equal by all of these, the first one is kept.
+// Returns the options left out, which are also kept in q.Pruned.
+func (q *filteredQuery) pruneDominated() ([]prunedOption, error) {
+	var courses []map[string]json.RawMessage
+	if err := json.Unmarshal(q.Query, &courses); err != nil {
+		return nil, err
+	}
+	originalCourse := map[int]int{}
+	for i, fi := range q.courseIndex {
+		if fi >= 0 {
+			originalCourse[fi] = i
+		}
+	}
+
+	pruned := []prunedOption{}
+	for fi, c := range courses {
+		var options [][]map[string]json.RawMessage
+		if err := json.Unmarshal(c["options"], &options); err != nil {
+			return nil, err
+		}
+		var penalties []int
+		json.Unmarshal(c["option_penalties"], &penalties)
+		previous, start := -1, -1
+		if raw, ok := c["previous_option"]; ok {
+			json.Unmarshal(raw, &previous)
+		}
+		if raw, ok := c["start_option"]; ok {
+			json.Unmarshal(raw, &start)
+		}
+		penalty := func(i int) int {
+			if i < len(penalties) {
+				return penalties[i]
+			}
+			return 0
+		}
+		full := make([]bool, len(options))
+		keys := make([]string, len(options))
+		for i, option := range options {
+			var ok bool
+			if keys[i], ok = optionKey(option); !ok {
+				// Unique, so that the option is kept
+				keys[i] = fmt.Sprintf("option %d", i)
+			}
+			var events []sisparse.Event
+			s, _ := json.Marshal(option)
+			json.Unmarshal(s, &events)
+			for _, e := range events {
+				full[i] = full[i] || e.IsFull()
+			}
+		}
+
+		// The better options first, so that each is compared with all the
+		// options which may dominate it
+		order := make([]int, len(options))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			i, j := order[a], order[b]
+			return penalty(i) < penalty(j) || (penalty(i) == penalty(j) && !full[i] && full[j])
+		})
+		kept := []int{}
+		oi := originalCourse[fi]
+		for _, i := range order {
+			by, reason := -1, ""
+			for _, k := range kept {
+				if keys[k] != keys[i] || i == previous || penalty(k) > penalty(i) || (full[k] && !full[i]) {
+					continue
+				}
+				by, reason = k, "duplicate"
+				if penalty(k) < penalty(i) || full[i] != full[k] {
+					reason = "dominated"
+				}
+				break
+			}
+			if by < 0 {
+				kept = append(kept, i)
+				continue
+			}
+			if i == start {
+				// The solver starts from the option kept in its place
+				start = by
+			}
+			pruned = append(pruned, prunedOption{
+				Course: oi,
+				Option: q.optionIndices[oi][i],
+				By:     q.optionIndices[oi][by],
+				Reason: reason,
+			})
+		}
+		if len(kept) == len(options) {
+			continue
+		}
+		sort.Ints(kept)
+
+		newOptions := [][]map[string]json.RawMessage{}
+		newPenalties := []int{}
+		indices := []int{}
+		for newIndex, i := range kept {
+			newOptions = append(newOptions, options[i])
+			newPenalties = append(newPenalties, penalty(i))
+			indices = append(indices, q.optionIndices[oi][i])
+			if i == previous {
+				c["previous_option"], _ = json.Marshal(newIndex)
+			}
+			if i == start {
+				c["start_option"], _ = json.Marshal(newIndex)
+			}
+		}
+		q.optionIndices[oi] = indices
+		c["options"], _ = json.Marshal(newOptions)
+		if penalties != nil {
+			c["option_penalties"], _ = json.Marshal(newPenalties)
+		}
+	}
+	q.Pruned = pruned
+	if len(pruned) == 0 {
+		return pruned, nil
+	}
+	var err error
+	q.Query, err = json.Marshal(courses)
+	return pruned, err
+}
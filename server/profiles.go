@@ -0,0 +1,289 @@
+// Preference profiles: solver settings users save under a name, so that
+// they don't have to enter them again, and refer to in solve requests.
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// The header with the token of the user whose profiles are listed or changed.
+// The server gives the token out when the user saves their first profile.
+const PROFILE_TOKEN_HEADER = "X-Profile-Token"
+
+// The most profiles a user can have, and the longest name of one
+const (
+	MAX_PROFILES          = 20
+	MAX_PROFILE_NAME_SIZE = 100
+)
+
+// What a profile contains. The settings of a solve request which refers to
+// the profile take precedence over those of the profile.
+type profilePreferences struct {
+	Weights   solveWeights   `json:"weights"`
+	Credits   solveCredits   `json:"credits"`
+	Languages solveLanguages `json:"languages"`
+	// Times when the student has something else to do
+	BlockedTimes     []blockedTime `json:"blocked_times,omitempty"`
+	BannedTeachers   []string      `json:"banned_teachers,omitempty"`
+	BannedTeacherIds []string      `json:"banned_teacher_ids,omitempty"`
+	// Further rules, added to those of the request
+	Rules []string `json:"rules,omitempty"`
+}
+
+// E.g. {"day": "Friday", "from": "12:00", "to": "24:00"}; days are as in rules
+type blockedTime struct {
+	Day  string             `json:"day"`
+	From sisparse.ClockTime `json:"from"`
+	To   sisparse.ClockTime `json:"to"`
+}
+
+// A profile as the API shows it
+type profileJSON struct {
+	Id          string             `json:"id"`
+	Name        string             `json:"name"`
+	Preferences profilePreferences `json:"preferences"`
+	Updated     time.Time          `json:"updated"`
+}
+
+// Returns the rules which the preferences stand for.
+func (p profilePreferences) rules() ([]rule, error) {
+	sources := []string{}
+	for _, b := range p.BlockedTimes {
+		day, ok := parseRuleDay(strings.ToLower(b.Day))
+		if !ok {
+			return nil, fmt.Errorf("Unknown day %q", b.Day)
+		}
+		if b.To <= b.From {
+			return nil, fmt.Errorf("Blocked time %s %s-%s ends before it starts", b.Day, b.From, b.To)
+		}
+		// Rules don't know 24:00, but every event starts before it
+		until := ""
+		if b.To.Hour() < 24 {
+			until = fmt.Sprintf(" and time_from < %s", b.To)
+		}
+		sources = append(sources, fmt.Sprintf("not(day = %d%s and time_to > %s)", day, until, b.From))
+	}
+	for _, t := range p.BannedTeachers {
+		sources = append(sources, fmt.Sprintf(`teacher != "%s"`, strings.Replace(t, `"`, "", -1)))
+	}
+	for _, id := range p.BannedTeacherIds {
+		sources = append(sources, fmt.Sprintf(`teacher_id != "%s"`, strings.Replace(id, `"`, "", -1)))
+	}
+	sources = append(sources, p.Rules...)
+	res := []rule{}
+	for _, s := range sources {
+		r, err := parseRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid preference %s: %s", s, err)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// Fills the settings the request doesn't have from the profile it refers to
+// and returns the rules of the profile.
+func (r *solveRequest) applyProfile() ([]rule, error) {
+	p, err := db.GetProfile(r.Profile)
+	if err == store.ErrNotFound {
+		return nil, fmt.Errorf("No profile %s", r.Profile)
+	} else if err != nil {
+		return nil, err
+	}
+	var prefs profilePreferences
+	if err := json.Unmarshal([]byte(p.Data), &prefs); err != nil {
+		return nil, err
+	}
+	defaultInt := func(value **int, profile *int) {
+		if *value == nil {
+			*value = profile
+		}
+	}
+	defaultInt(&r.Weights.Stability, prefs.Weights.Stability)
+	defaultInt(&r.Weights.Balance, prefs.Weights.Balance)
+	defaultInt(&r.Weights.Fill, prefs.Weights.Fill)
+	defaultInt(&r.Weights.BackToBack, prefs.Weights.BackToBack)
+	defaultInt(&r.Weights.Commute, prefs.Weights.Commute)
+	defaultInt(&r.Credits.Min, prefs.Credits.Min)
+	defaultInt(&r.Credits.MaxImbalance, prefs.Credits.MaxImbalance)
+	if r.Languages.Require == "" {
+		r.Languages.Require = prefs.Languages.Require
+	}
+	if r.Languages.Prefer == "" {
+		r.Languages.Prefer = prefs.Languages.Prefer
+	}
+	return prefs.rules()
+}
+
+func newProfileId() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Answers
+//   - GET /profiles/ with the profiles of the user (given by PROFILE_TOKEN_HEADER),
+//   - GET /profiles/<id> with the profile,
+//   - POST /profiles/ {"name": "...", "preferences": {...}} by saving the profile
+//     of the user (replacing their profile of the same name); without a token,
+//     a new user is created and their "token" is a part of the answer,
+//   - DELETE /profiles/<id> by deleting the profile of the user.
+func profilesHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/profiles/")
+	token := r.Header.Get(PROFILE_TOKEN_HEADER)
+	switch {
+	case r.Method == "GET" && id != "":
+		p, err := db.GetProfile(id)
+		if err != nil {
+			writeProfileError(w, err)
+			return
+		}
+		writeProfiles(w, p)
+	case r.Method == "GET":
+		if token == "" {
+			fmt.Fprintf(w, `{"error":"Send the token of your profiles in %s"}`, PROFILE_TOKEN_HEADER)
+			return
+		}
+		profiles, err := db.ListProfiles(hashToken(token))
+		if err != nil {
+			writeProfileError(w, err)
+			return
+		}
+		writeProfiles(w, profiles...)
+	case r.Method == "POST" && id == "":
+		saveProfile(w, r, token)
+	case r.Method == "DELETE" && id != "":
+		p, err := db.GetProfile(id)
+		if err == nil && (token == "" || p.OwnerHash != hashToken(token)) {
+			err = store.ErrNotFound
+		}
+		if err == nil {
+			err = db.DeleteProfile(id)
+		}
+		if err != nil {
+			writeProfileError(w, err)
+			return
+		}
+		fmt.Fprint(w, `{"data":null}`)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Expected GET, POST or DELETE of /profiles/ or /profiles/<id>"}`)
+	}
+}
+
+func saveProfile(w http.ResponseWriter, r *http.Request, token string) {
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		writeProfileError(w, err)
+		return
+	}
+	var req struct {
+		Name        string             `json:"name"`
+		Preferences profilePreferences `json:"preferences"`
+	}
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&req); err != nil {
+		writeProfileError(w, err)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > MAX_PROFILE_NAME_SIZE {
+		fmt.Fprintf(w, `{"error":"The name of a profile must have 1 to %d characters"}`, MAX_PROFILE_NAME_SIZE)
+		return
+	}
+	if _, err := req.Preferences.rules(); err != nil {
+		writeProfileError(w, err)
+		return
+	}
+
+	newToken := ""
+	if token == "" {
+		b := make([]byte, 24)
+		if _, err := rand.Read(b); err != nil {
+			writeProfileError(w, err)
+			return
+		}
+		token = hex.EncodeToString(b)
+		newToken = token
+	}
+	owner := hashToken(token)
+	existing, err := db.ListProfiles(owner)
+	if err != nil {
+		writeProfileError(w, err)
+		return
+	}
+	p := store.Profile{OwnerHash: owner, Name: req.Name}
+	for _, e := range existing {
+		if e.Name == req.Name {
+			p = e
+		}
+	}
+	if p.Id == "" {
+		if len(existing) >= MAX_PROFILES {
+			fmt.Fprintf(w, `{"error":"You can have at most %d profiles"}`, MAX_PROFILES)
+			return
+		}
+		if p.Id, err = newProfileId(); err != nil {
+			writeProfileError(w, err)
+			return
+		}
+	}
+	data, _ := json.Marshal(req.Preferences)
+	p.Data = string(data)
+	if err := db.SaveProfile(p); err != nil {
+		writeProfileError(w, err)
+		return
+	}
+	s, _ := json.Marshal(map[string]interface{}{
+		"id":    p.Id,
+		"token": newToken,
+	})
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+// Writes one profile as {"data": profile}, or more as {"data": [profile, ...]}.
+func writeProfiles(w http.ResponseWriter, profiles ...store.Profile) {
+	res := []profileJSON{}
+	for _, p := range profiles {
+		pj := profileJSON{Id: p.Id, Name: p.Name, Updated: p.Updated}
+		if err := json.Unmarshal([]byte(p.Data), &pj.Preferences); err != nil {
+			writeProfileError(w, err)
+			return
+		}
+		res = append(res, pj)
+	}
+	var data interface{} = res
+	if len(profiles) == 1 {
+		data = res[0]
+	}
+	s, _ := json.Marshal(data)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func writeProfileError(w http.ResponseWriter, err error) {
+	if err == store.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"No such profile"}`)
+		return
+	}
+	log.Printf("Profile error: %s", err)
+	s, _ := json.Marshal(map[string]string{"error": err.Error()})
+	fmt.Fprint(w, string(s))
+}
@@ -0,0 +1,66 @@
+// Provenance of course answers: where the events came from, when, by which
+// version of the parser, what the parser had to skip, and whether the
+// answer came from the cache, so that the webapp can show how far to trust
+// a schedule and operators can find out why it is wrong.
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// "provenance" of course answers. Everything but Cache is stored with the
+// answer when the course is fetched; answers cached before provenance was
+// recorded only have Fetched and Cache.
+type provenance struct {
+	// The name of the source of course data, see -source
+	Source string `json:"source,omitempty"`
+	// The page the events were parsed from, as the source says
+	Url           string             `json:"url,omitempty"`
+	Fetched       time.Time          `json:"fetched"`
+	ParserVersion int                `json:"parser_version,omitempty"`
+	Warnings      []sisparse.Warning `json:"warnings,omitempty"`
+	// "miss" (fetched for this request), "fresh" (from the cache) or
+	// "stale" (from the cache, but due to be fetched again, or SIS is down)
+	Cache string `json:"cache,omitempty"`
+}
+
+const (
+	CACHE_MISS  = "miss"
+	CACHE_FRESH = "fresh"
+	CACHE_STALE = "stale"
+)
+
+func newProvenance(record *sisparse.FetchRecord) provenance {
+	return provenance{
+		Source:        courseSourceName,
+		Url:           record.Url,
+		Fetched:       time.Now().UTC().Truncate(time.Second),
+		ParserVersion: sisparse.ParserVersion,
+		Warnings:      record.Warnings,
+	}
+}
+
+// Sets the cache status in the provenance of the course answer; answers
+// without provenance get one fetched at the given time.
+func withCacheStatus(res string, status string, fetched time.Time) string {
+	var answer map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(res), &answer); err != nil {
+		return res
+	}
+	p := provenance{Fetched: fetched.UTC().Truncate(time.Second)}
+	if raw, ok := answer["provenance"]; ok {
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return res
+		}
+	}
+	p.Cache = status
+	answer["provenance"], _ = json.Marshal(p)
+	s, err := json.Marshal(answer)
+	if err != nil {
+		return res
+	}
+	return string(s)
+}
@@ -0,0 +1,569 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The solver query with the rules and filters applied, and what is needed
+// to translate the solver's answer back to the original query.
+type filteredQuery struct {
+	Query []byte
+	// The courses whose every option was removed, and why
+	Unschedulable []unschedulableCourse
+	// For each course of the original query, its index in Query (-1 if
+	// it was left out) and the original indices of its remaining options
+	courseIndex   []int
+	optionIndices [][]int
+	// The options left out by presolving, see pruneDominated
+	Pruned []prunedOption
+}
+
+type unschedulableCourse struct {
+	Index int    `json:"index"` // In the query
+	Name  string `json:"name"`
+	// The reason, as a message code and in the language of the request
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+// Simple per-course restrictions, given in "filters" of a course.
+type courseFilters struct {
+	BannedTeachers []string `json:"banned_teachers"`
+	// SIS IDs of teachers (see sisparse.Event.TeacherID), which, unlike
+	// names, are written the same in all courses
+	BannedTeacherIds []string `json:"banned_teacher_ids"`
+	// Days as in rules, e.g. "Friday" or "pá"
+	BannedDays    []string `json:"banned_days"`
+	EarliestStart string   `json:"earliest_start"` // "hh:mm"
+	LatestEnd     string   `json:"latest_end"`     // "hh:mm"
+	// Languages of instruction allowed ("cs", "en")
+	Languages []string `json:"languages"`
+	// The only option allowed (see "locks" of solveRequest)
+	Option *int `json:"option,omitempty"`
+}
+
+// Converts the filters to hard rules.
+func (f courseFilters) rules() ([]rule, error) {
+	var sources []string
+	for _, t := range f.BannedTeachers {
+		sources = append(sources, fmt.Sprintf(`teacher != "%s"`, strings.Replace(t, `"`, "", -1)))
+	}
+	for _, id := range f.BannedTeacherIds {
+		sources = append(sources, fmt.Sprintf(`teacher_id != "%s"`, strings.Replace(id, `"`, "", -1)))
+	}
+	for _, d := range f.BannedDays {
+		sources = append(sources, fmt.Sprintf(`day != "%s"`, d))
+	}
+	if f.EarliestStart != "" {
+		sources = append(sources, "starts_after "+f.EarliestStart)
+	}
+	if f.LatestEnd != "" {
+		sources = append(sources, "ends_before "+f.LatestEnd)
+	}
+	if len(f.Languages) > 0 {
+		var alternatives []string
+		for _, l := range f.Languages {
+			alternatives = append(alternatives, fmt.Sprintf(`language = "%s"`, strings.Replace(l, `"`, "", -1)))
+		}
+		sources = append(sources, strings.Join(alternatives, " or "))
+	}
+	res := []rule{}
+	for _, s := range sources {
+		r, err := parseRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter %s: %s", s, err)
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// Applies the rules, and the filters of each course, to a solver query
+// (a JSON array of courses with options). Options breaking a hard rule
+// or a filter are removed, those breaking soft rules get a penalty for
+// each of them added to their "option_penalties". Why courses can't be
+// scheduled is told in the language of ctx.
+func applyRules(ctx context.Context, query []byte, rules []rule) (filteredQuery, error) {
+	var courses []map[string]json.RawMessage
+	if err := json.Unmarshal(query, &courses); err != nil {
+		return filteredQuery{}, err
+	}
+
+	res := filteredQuery{Unschedulable: []unschedulableCourse{}}
+	kept := []map[string]json.RawMessage{}
+	names := make([]string, len(courses))
+	codes := make([]string, len(courses))
+	// The courses with a (mandatory) component, e.g. the seminar, left without options
+	failedCodes := map[string]string{}
+	for ci, c := range courses {
+		var options [][]sisparse.Event
+		if err := json.Unmarshal(c["options"], &options); err != nil {
+			return filteredQuery{}, err
+		}
+		var name string
+		json.Unmarshal(c["name"], &name)
+		var givenPenalties []int
+		json.Unmarshal(c["option_penalties"], &givenPenalties)
+		names[ci] = name
+		json.Unmarshal(c["course_code"], &codes[ci])
+
+		courseRules := rules
+		var filters courseFilters
+		if raw, ok := c["filters"]; ok {
+			if err := json.Unmarshal(raw, &filters); err != nil {
+				return filteredQuery{}, fmt.Errorf("Invalid filters of %s: %s", name, err)
+			}
+			filterRules, err := filters.rules()
+			if err != nil {
+				return filteredQuery{}, err
+			}
+			courseRules = append(filterRules, rules...)
+			delete(c, "filters")
+		}
+
+		newOptions := [][]sisparse.Event{}
+		penalties := []int{}
+		indices := []int{}
+		broken := map[string]bool{}
+		if filters.Option != nil && (*filters.Option < 0 || *filters.Option >= len(options)) {
+			return filteredQuery{}, fmt.Errorf("%s has no option %d to lock", name, *filters.Option)
+		}
+		for i, opt := range options {
+			if filters.Option != nil && i != *filters.Option {
+				broken[fmt.Sprintf("locked to group %d", *filters.Option+1)] = true
+				continue
+			}
+			if sisparse.GroupCancelled(opt) {
+				broken["cancelled in SIS"] = true
+				continue
+			}
+			opt = withoutCancelled(opt)
+			ok, penalty, brokenRule := checkRules(opt, courseRules)
+			if i < len(givenPenalties) {
+				penalty += givenPenalties[i]
+			}
+			if ok {
+				newOptions = append(newOptions, opt)
+				penalties = append(penalties, penalty)
+				indices = append(indices, i)
+			} else {
+				broken[brokenRule] = true
+			}
+		}
+		res.optionIndices = append(res.optionIndices, indices)
+		if len(newOptions) == 0 {
+			res.courseIndex = append(res.courseIndex, -1)
+			reasons := []string{}
+			for r := range broken {
+				reasons = append(reasons, r)
+			}
+			sort.Strings(reasons)
+			res.Unschedulable = append(res.Unschedulable, unschedulableCourse{
+				Index:  ci,
+				Name:   name,
+				Code:   "no_group_satisfies",
+				Reason: localize(ctx, "no_group_satisfies", strings.Join(reasons, "; ")),
+			})
+			var optional bool
+			json.Unmarshal(c["optional"], &optional)
+			if codes[ci] != "" && !optional {
+				failedCodes[codes[ci]] = name
+			}
+			continue
+		}
+		res.courseIndex = append(res.courseIndex, len(kept))
+		for _, key := range []string{"previous_option", "start_option"} {
+			raw, ok := c[key]
+			if !ok {
+				continue
+			}
+			// The index changes with the options removed before it
+			var previous int
+			json.Unmarshal(raw, &previous)
+			delete(c, key)
+			for newIndex, i := range indices {
+				if i == previous {
+					c[key], _ = json.Marshal(newIndex)
+				}
+			}
+		}
+		c["options"], _ = json.Marshal(newOptions)
+		c["option_penalties"], _ = json.Marshal(penalties)
+		kept = append(kept, c)
+	}
+
+	if len(failedCodes) > 0 {
+		// The components of a course are only taken together, so
+		// neither can the other components be scheduled
+		newKept := []map[string]json.RawMessage{}
+		for ci, k := range res.courseIndex {
+			if k == -1 {
+				continue
+			}
+			if failed, ok := failedCodes[codes[ci]]; ok {
+				res.courseIndex[ci] = -1
+				res.Unschedulable = append(res.Unschedulable, unschedulableCourse{
+					Index:  ci,
+					Name:   names[ci],
+					Code:   "taken_together",
+					Reason: localize(ctx, "taken_together", failed),
+				})
+				continue
+			}
+			res.courseIndex[ci] = len(newKept)
+			newKept = append(newKept, kept[k])
+		}
+		kept = newKept
+		sort.Slice(res.Unschedulable, func(i, j int) bool {
+			return res.Unschedulable[i].Index < res.Unschedulable[j].Index
+		})
+	}
+
+	var err error
+	res.Query, err = json.Marshal(kept)
+	return res, err
+}
+
+// Reports whether the option satisfies all hard rules (if not, returns
+// the first one it breaks), and how many soft rules it breaks.
+func checkRules(option []sisparse.Event, rules []rule) (bool, int, string) {
+	penalty := 0
+	for _, r := range rules {
+		for _, e := range option {
+			if !r.Cond(e) {
+				if !r.Soft {
+					return false, 0, r.Source
+				}
+				penalty++
+				break
+			}
+		}
+	}
+	return true, penalty, ""
+}
+
+// Translates the solver's answer to a filtered query back to the original
+// query: option indices are mapped back and left out courses are unselected.
+func (q filteredQuery) translateAnswer(answer []byte) ([]byte, error) {
+	// Fields which don't refer to courses are passed on as they are
+	var rest map[string]json.RawMessage
+	if err := json.Unmarshal(answer, &rest); err != nil {
+		return nil, err
+	}
+	if _, ok := rest["error"]; ok {
+		return answer, nil
+	}
+	var res struct {
+		Data        []*int            `json:"data"`
+		Fallbacks   []*solverFallback `json:"fallbacks"`
+		Explanation []string          `json:"explanation"`
+		Semesters   []struct {
+			Semester int   `json:"semester"`
+			Courses  []int `json:"courses"`
+			Credits  int   `json:"credits"`
+		} `json:"semesters"`
+		Pairings []map[string]interface{} `json:"pairings"`
+		Overlaps []map[string]interface{} `json:"overlaps"`
+		Omitted  []map[string]interface{} `json:"omitted"`
+	}
+	if err := json.Unmarshal(answer, &res); err != nil {
+		return nil, err
+	}
+
+	// Maps a course of the filtered query and its option to the original ones
+	originalCourse := map[int]int{}
+	for i, fi := range q.courseIndex {
+		if fi >= 0 {
+			originalCourse[fi] = i
+		}
+	}
+	// The solver referring to a course or an option the query doesn't have
+	// would be its bug, which is reported rather than trusted
+	mapCourse := func(course int) (int, error) {
+		i, ok := originalCourse[course]
+		if !ok {
+			return 0, fmt.Errorf("The solver answered with unknown course %d", course)
+		}
+		return i, nil
+	}
+	mapOption := func(course int, option *int) (*int, error) {
+		if option == nil {
+			return nil, nil
+		}
+		if *option < 0 || *option >= len(q.optionIndices[course]) {
+			return nil, fmt.Errorf("The solver answered with unknown option %d of course %d", *option, course)
+		}
+		o := q.optionIndices[course][*option]
+		return &o, nil
+	}
+
+	data := make([]*int, len(q.courseIndex))
+	fallbacks := make([]*solverFallback, len(q.courseIndex))
+	explanation := make([]string, len(q.courseIndex))
+	for _, u := range q.Unschedulable {
+		explanation[u.Index] = u.Reason
+	}
+	for i, fi := range q.courseIndex {
+		if fi < 0 || fi >= len(res.Data) {
+			continue
+		}
+		option, err := mapOption(i, res.Data[fi])
+		if err != nil {
+			return nil, err
+		}
+		data[i] = option
+		if fi < len(res.Explanation) {
+			explanation[i] = res.Explanation[fi]
+		}
+		if fi >= len(res.Fallbacks) || res.Fallbacks[fi] == nil {
+			continue
+		}
+		f := res.Fallbacks[fi]
+		option, err = mapOption(i, f.Option)
+		if err != nil {
+			return nil, err
+		}
+		fallback := &solverFallback{Option: option, Changes: [][2]*int{}}
+		for _, change := range f.Changes {
+			if change[0] == nil {
+				continue
+			}
+			course, err := mapCourse(*change[0])
+			if err != nil {
+				return nil, err
+			}
+			changed, err := mapOption(course, change[1])
+			if err != nil {
+				return nil, err
+			}
+			fallback.Changes = append(fallback.Changes, [2]*int{&course, changed})
+		}
+		fallbacks[i] = fallback
+	}
+	for i := range res.Semesters {
+		for j, fi := range res.Semesters[i].Courses {
+			course, err := mapCourse(fi)
+			if err != nil {
+				return nil, err
+			}
+			res.Semesters[i].Courses[j] = course
+		}
+	}
+
+	for _, items := range [][]map[string]interface{}{res.Pairings, res.Overlaps, res.Omitted} {
+		if err := remapCourses(items, mapCourse); err != nil {
+			return nil, err
+		}
+	}
+
+	translated := map[string]interface{}{}
+	for k, v := range rest {
+		translated[k] = v
+	}
+	translated["data"] = data
+	translated["fallbacks"] = fallbacks
+	translated["explanation"] = explanation
+	translated["unschedulable"] = q.Unschedulable
+	if len(q.Pruned) > 0 {
+		translated["pruned"] = q.Pruned
+	}
+	if res.Semesters != nil {
+		translated["semesters"] = res.Semesters
+	}
+	if res.Pairings != nil {
+		translated["pairings"] = res.Pairings
+	}
+	if res.Overlaps != nil {
+		translated["overlaps"] = res.Overlaps
+	}
+	if res.Omitted != nil {
+		translated["omitted"] = res.Omitted
+	}
+	return json.Marshal(translated)
+}
+
+// Maps the "courses" (indices to the filtered query) of items of the answer
+// such as "pairings" to the original courses.
+func remapCourses(items []map[string]interface{}, mapCourse func(int) (int, error)) error {
+	for _, item := range items {
+		courses, ok := item["courses"].([]interface{})
+		if !ok {
+			continue
+		}
+		for j, fi := range courses {
+			if f, ok := fi.(float64); ok {
+				course, err := mapCourse(int(f))
+				if err != nil {
+					return err
+				}
+				courses[j] = course
+			}
+		}
+	}
+	return nil
+}
+
+// Solves a solve request of any version (see solveRequest) and returns
+// the answer as solveResponse. Courses may also have "filters", see
+// courseFilters.
+func solveWithRules(ctx context.Context, body []byte) ([]byte, error) {
+	req, err := parseSolveRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	if req.Previous != nil && len(req.Previous) != len(req.Courses) {
+		return nil, fmt.Errorf("Expected %d previous options, got %d", len(req.Courses), len(req.Previous))
+	}
+	if req.Locks != nil && len(req.Locks) != len(req.Courses) {
+		return nil, fmt.Errorf("Expected %d locks, got %d", len(req.Courses), len(req.Locks))
+	}
+	if req.Start != nil && len(req.Start) != len(req.Courses) {
+		return nil, fmt.Errorf("Expected %d start options, got %d", len(req.Courses), len(req.Start))
+	}
+	if req.Friend != nil {
+		if err := req.applyFriend(); err != nil {
+			return nil, err
+		}
+	}
+	profileRules := []rule{}
+	if req.Profile != "" {
+		if profileRules, err = req.applyProfile(); err != nil {
+			return nil, err
+		}
+	}
+	hasFilters := false
+	for i := range req.Courses {
+		c := &req.Courses[i]
+		if req.Previous != nil && req.Previous[i] != nil {
+			c.PreviousOption = req.Previous[i]
+		}
+		if c.Workload != nil && (*c.Workload < 1 || *c.Workload > 5) {
+			return nil, fmt.Errorf("The workload of %s must be between 1 and 5", c.Name)
+		}
+		if req.Start != nil && req.Start[i] != nil {
+			c.StartOption = req.Start[i]
+		}
+		if req.Locks != nil && req.Locks[i] != nil {
+			// Locks are filters which leave just the one option
+			if c.Filters == nil {
+				c.Filters = &courseFilters{}
+			}
+			c.Filters.Option = req.Locks[i]
+		}
+		// Cancelled events are left out like filtered ones
+		hasFilters = hasFilters || c.Filters != nil || hasCancelledEvents(*c)
+	}
+	if err := req.Commute.normalize(); err != nil {
+		return nil, err
+	}
+	for _, t := range req.Overlap.SkippableTypes {
+		if t != string(sisparse.Lecture) && t != string(sisparse.Seminar) {
+			return nil, fmt.Errorf("Unknown event type %q", t)
+		}
+	}
+	// Languages are only shorthands for rules
+	if req.Languages.Require != "" {
+		req.Rules = append(req.Rules, fmt.Sprintf(`language = "%s"`, strings.Replace(req.Languages.Require, `"`, "", -1)))
+	}
+	if req.Languages.Prefer != "" {
+		req.Rules = append(req.Rules, fmt.Sprintf(`prefer language = "%s"`, strings.Replace(req.Languages.Prefer, `"`, "", -1)))
+	}
+	hookRequest, err := beforeSolveHooks(ctx, &req)
+	if err != nil {
+		return nil, err
+	}
+	opts := solverOptions{
+		Seed:               req.Seed,
+		Stability:          req.Weights.Stability,
+		MinCredits:         req.Credits.Min,
+		MaxCreditImbalance: req.Credits.MaxImbalance,
+		Balance:            req.Weights.Balance,
+		OverlapBudget:      req.Overlap.Budget,
+		SkippableTypes:     req.Overlap.SkippableTypes,
+		HeavyWorkload:      req.Workload.Heavy,
+		BackToBack:         req.Weights.BackToBack,
+		MaxHeavyHours:      req.Workload.MaxHeavyHours,
+		CommuteMinutes:     req.Commute.Minutes,
+		LeaveAfter:         req.Commute.LeaveAfter,
+		HomeBy:             req.Commute.HomeBy,
+		CommutePenalty:     req.Weights.Commute,
+	}
+	if req.Timeout != nil {
+		opts.Timeout = time.Duration(*req.Timeout) * time.Second
+	}
+	for i, r := range req.Reserve {
+		arg, err := r.solverArg()
+		if err != nil {
+			return nil, fmt.Errorf("Reservation %d: %s", i+1, err)
+		}
+		opts.Reserve = append(opts.Reserve, arg)
+	}
+	rules := []rule{}
+	for i, s := range req.Rules {
+		r, err := parseRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("Rule %d: %s", i+1, err)
+		}
+		rules = append(rules, r)
+	}
+	rules = append(rules, profileRules...)
+	if req.Weights.Fill != nil && *req.Weights.Fill > 0 {
+		if err := addFillPenalties(ctx, req.Courses, *req.Weights.Fill); err != nil {
+			return nil, err
+		}
+	}
+	if err := addObjectivePenalties(req.Courses, req.Weights); err != nil {
+		return nil, err
+	}
+	solved, err := withoutIgnoredLectures(req.Courses)
+	if err != nil {
+		return nil, err
+	}
+	courses, err := json.Marshal(solved)
+	if err != nil {
+		return nil, err
+	}
+
+	var q filteredQuery
+	if len(rules) == 0 && !hasFilters {
+		q, err = unfilteredQuery(courses)
+	} else {
+		q, err = applyRules(ctx, courses, rules)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := q.leaveOutCrossListed(ctx, req.Courses); err != nil {
+		return nil, err
+	}
+	if _, err := q.pruneDominated(); err != nil {
+		return nil, err
+	}
+	answer := []byte(`{"data":[],"fallbacks":[]}`)
+	if req.Seed != nil {
+		answer = []byte(fmt.Sprintf(`{"data":[],"fallbacks":[],"seed":%d}`, *req.Seed))
+	}
+	if string(q.Query) != "[]" {
+		if answer, err = Solve(ctx, q.Query, opts); err != nil {
+			return nil, err
+		}
+	}
+	if answer, err = q.translateAnswer(answer); err != nil {
+		return nil, err
+	}
+	if answer, err = afterSolveHooks(ctx, hookRequest, answer); err != nil {
+		return nil, err
+	}
+	return newSolveResponse(ctx, answer, req.Courses, req.Reserve)
+}
+
+func looksLikeObject(body []byte) bool {
+	return strings.HasPrefix(strings.TrimSpace(string(body)), "{")
+}
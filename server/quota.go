@@ -0,0 +1,185 @@
+// Quotas of the expensive operations per client, so that a single client's
+// scripts can't take the shared instance for themselves: CPU seconds of the
+// solver per hour (-solve-quota) and courses fetched from SIS per day
+// (-fetch-quota). A client is the API token of the request or, without one,
+// its address; in chats (see bot.go), it is the user sending the command.
+// The usage is counted in the store, so that the quotas hold across
+// instances. Requests over a quota get 429 Too Many Requests, and answers
+// of requests which used a quota say what is left of it in
+// X-Quota-<Solve|Fetch>-<Limit|Remaining|Reset> headers.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Returned when the client of the request has used up a quota
+var ErrQuotaExceeded = errors.New("The quota of the client is used up, try again later")
+
+type quota struct {
+	// As in the headers
+	Name string
+	// How much may be used in each Period, 0 for no limit
+	Limit  int
+	Period time.Duration
+}
+
+var solveQuota = &quota{Name: "Solve", Period: time.Hour}
+var fetchQuota = &quota{Name: "Fetch", Period: 24 * time.Hour}
+
+// The slot of the period now, and when it ends.
+func (q *quota) slot() (int64, time.Time) {
+	seconds := int64(q.Period / time.Second)
+	slot := time.Now().Unix() / seconds
+	return slot, time.Unix((slot+1)*seconds, 0)
+}
+
+type quotaClientKey struct{}
+
+// The client of a request, as its quotas are counted
+type quotaClient struct {
+	key string
+	mu  sync.Mutex
+	// The usage of the quotas the request touched, as last counted
+	used map[*quota]int
+}
+
+// Counts the request's use of the quotas by its client; requests with an
+// admin token have no quotas.
+func quotaMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if solveQuota.Limit <= 0 && fetchQuota.Limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := "addr:" + clientAddr(r)
+		if t, err := getRequestToken(r); err == nil {
+			if hasScope(t, SCOPE_ADMIN) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key = "token:" + t.Hash
+		}
+		ctx, c := withQuotaClient(r.Context(), key)
+		next.ServeHTTP(&quotaWriter{ResponseWriter: w, client: c}, r.WithContext(ctx))
+	})
+}
+
+// Returns ctx with the client whose quotas its work is counted in.
+func withQuotaClient(ctx context.Context, key string) (context.Context, *quotaClient) {
+	c := &quotaClient{key: key, used: map[*quota]int{}}
+	return context.WithValue(ctx, quotaClientKey{}, c), c
+}
+
+func quotaClientOf(ctx context.Context) *quotaClient {
+	c, _ := ctx.Value(quotaClientKey{}).(*quotaClient)
+	return c
+}
+
+func (q *quota) counterKey(c *quotaClient) string {
+	return "quota-" + strings.ToLower(q.Name) + ":" + c.key
+}
+
+// Adds n to the client's usage of the quota and returns ErrQuotaExceeded
+// if it was used up already (n may be 0 to just check it). Requests
+// without a client, such as background work, are not counted.
+func (q *quota) use(ctx context.Context, n int) error {
+	c := quotaClientOf(ctx)
+	if c == nil || q.Limit <= 0 {
+		return nil
+	}
+	slot, _ := q.slot()
+	used, err := db.AddToCounter(q.counterKey(c), slot, n)
+	if err != nil {
+		// Rather than failing all requests when the database is down
+		log.Printf("Could not count the %s quota: %s", strings.ToLower(q.Name), err)
+		return nil
+	}
+	c.mu.Lock()
+	c.used[q] = used
+	c.mu.Unlock()
+	if used-n >= q.Limit {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Charges the CPU time of a finished solver process to the solve quota,
+// in whole seconds rounded up.
+func chargeSolveQuota(ctx context.Context, state *os.ProcessState) {
+	if state == nil {
+		return
+	}
+	seconds := int(math.Ceil((state.UserTime() + state.SystemTime()).Seconds()))
+	if seconds > 0 {
+		solveQuota.use(ctx, seconds)
+	}
+}
+
+// Answers 429 Too Many Requests with when to try again, in the
+// "retry_after" (in seconds) and in Retry-After.
+func writeQuotaExceeded(w http.ResponseWriter, ctx context.Context) {
+	retryAfter := 1
+	if c := quotaClientOf(ctx); c != nil {
+		c.mu.Lock()
+		for q := range c.used {
+			if _, reset := q.slot(); c.used[q] >= q.Limit {
+				if s := int(math.Ceil(time.Until(reset).Seconds())); s > retryAfter {
+					retryAfter = s
+				}
+			}
+		}
+		c.mu.Unlock()
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, `{"error":"%s","retry_after":%d}`, ErrQuotaExceeded, retryAfter)
+}
+
+// Adds the quota headers before the answer is written.
+type quotaWriter struct {
+	http.ResponseWriter
+	client  *quotaClient
+	written bool
+}
+
+func (w *quotaWriter) writeHeaders() {
+	if w.written {
+		return
+	}
+	w.written = true
+	w.client.mu.Lock()
+	defer w.client.mu.Unlock()
+	h := w.Header()
+	for q, used := range w.client.used {
+		_, reset := q.slot()
+		prefix := "X-Quota-" + q.Name + "-"
+		h.Set(prefix+"Limit", strconv.Itoa(q.Limit))
+		remaining := q.Limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+		h.Set(prefix+"Remaining", strconv.Itoa(remaining))
+		h.Set(prefix+"Reset", strconv.Itoa(int(math.Ceil(time.Until(reset).Seconds()))))
+	}
+}
+
+func (w *quotaWriter) WriteHeader(status int) {
+	w.writeHeaders()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *quotaWriter) Write(b []byte) (int, error) {
+	w.writeHeaders()
+	return w.ResponseWriter.Write(b)
+}
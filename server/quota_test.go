@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Sets the limits of the quotas for the test.
+func useQuotaLimits(t *testing.T, solve, fetch int) {
+	oldSolve, oldFetch := solveQuota.Limit, fetchQuota.Limit
+	solveQuota.Limit, fetchQuota.Limit = solve, fetch
+	t.Cleanup(func() { solveQuota.Limit, fetchQuota.Limit = oldSolve, oldFetch })
+}
+
+func TestQuotaUse(t *testing.T) {
+	s := newCounterStore()
+	useStore(t, s)
+	q := &quota{Name: "Fetch", Limit: 3, Period: time.Hour}
+	ctx, c := withQuotaClient(context.Background(), "addr:10.0.0.1")
+
+	for i := 0; i < 3; i++ {
+		if err := q.use(ctx, 1); err != nil {
+			t.Fatalf("Use %d within the quota: %s", i+1, err)
+		}
+	}
+	if c.used[q] != 3 {
+		t.Errorf("%d used, want 3", c.used[q])
+	}
+	if err := q.use(ctx, 0); err != ErrQuotaExceeded {
+		t.Errorf("Check of a used up quota: %v, want %v", err, ErrQuotaExceeded)
+	}
+	if err := q.use(ctx, 1); err != ErrQuotaExceeded {
+		t.Errorf("Use over the quota: %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// The last use may go over the limit, as the solver's seconds do
+	other, _ := withQuotaClient(context.Background(), "addr:10.0.0.2")
+	if err := q.use(other, 2); err != nil {
+		t.Errorf("Use of another client: %s", err)
+	}
+	if err := q.use(other, 5); err != nil {
+		t.Errorf("Use crossing the limit: %s", err)
+	}
+	if err := q.use(other, 0); err != ErrQuotaExceeded {
+		t.Errorf("Check after crossing the limit: %v, want %v", err, ErrQuotaExceeded)
+	}
+	if s.counts["quota-fetch:addr:10.0.0.2"] != 7 {
+		t.Errorf("Counters %v", s.counts)
+	}
+}
+
+func TestQuotaNotCounted(t *testing.T) {
+	s := newCounterStore()
+	useStore(t, s)
+	ctx, _ := withQuotaClient(context.Background(), "addr:10.0.0.1")
+
+	unlimited := &quota{Name: "Fetch", Period: time.Hour}
+	if err := unlimited.use(ctx, 100); err != nil {
+		t.Errorf("Use of a quota without a limit: %s", err)
+	}
+	q := &quota{Name: "Fetch", Limit: 1, Period: time.Hour}
+	for i := 0; i < 3; i++ {
+		if err := q.use(context.Background(), 1); err != nil {
+			t.Errorf("Use without a client: %s", err)
+		}
+	}
+	if len(s.counts) != 0 {
+		t.Errorf("Uncounted uses are counted: %v", s.counts)
+	}
+
+	s.err = errors.New("connection refused")
+	if err := q.use(ctx, 5); err != nil {
+		t.Errorf("Use when the database is down: %s", err)
+	}
+}
+
+func TestQuotaMiddleware(t *testing.T) {
+	s := newCounterStore()
+	useStore(t, s)
+	useQuotaLimits(t, 0, 2)
+	handler := quotaMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := fetchQuota.use(r.Context(), 1); err != nil {
+			writeQuotaExceeded(w, r.Context())
+			return
+		}
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/sisquery/NPRG030", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		w := get("10.0.0.1:1234")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Request %d: status %d", i+1, w.Code)
+		}
+		h := w.Header()
+		if h.Get("X-Quota-Fetch-Limit") != "2" || h.Get("X-Quota-Fetch-Remaining") != remaining {
+			t.Errorf("Request %d: headers %v, want %s remaining", i+1, h, remaining)
+		}
+		if reset, err := strconv.Atoi(h.Get("X-Quota-Fetch-Reset")); err != nil || reset <= 0 || reset > 24*60*60 {
+			t.Errorf("Request %d: X-Quota-Fetch-Reset %q", i+1, h.Get("X-Quota-Fetch-Reset"))
+		}
+	}
+
+	// Counted by the address, whatever the port
+	w := get("10.0.0.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Request over the quota: status %d", w.Code)
+	}
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter <= 1 || retryAfter > 24*60*60 {
+		t.Errorf("Retry-After %q, want the end of the day's slot", w.Header().Get("Retry-After"))
+	}
+	if w := get("10.0.0.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("Request of another client: status %d", w.Code)
+	}
+}
+
+func TestQuotaMiddlewareAdmin(t *testing.T) {
+	s := newCounterStore()
+	useStore(t, s)
+	useQuotaLimits(t, 0, 1)
+	if err := addStaticToken("secret:admin"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(staticTokens, hashToken("secret")) })
+	handler := quotaMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if quotaClientOf(r.Context()) != nil {
+			t.Error("Requests with an admin token have a quota client")
+		}
+	}))
+	r := httptest.NewRequest("GET", "/sisquery/NPRG030", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func TestClientAddr(t *testing.T) {
+	defer func(old int) { trustedProxies = old }(trustedProxies)
+	tests := []struct {
+		proxies   int
+		forwarded []string
+		want      string
+	}{
+		{0, nil, "10.0.0.1"},
+		{0, []string{"192.0.2.7"}, "10.0.0.1"},
+		{1, nil, "10.0.0.1"},
+		{1, []string{"192.0.2.7"}, "192.0.2.7"},
+		// What the client sent before the proxy's address is ignored
+		{1, []string{" 198.51.100.1 , 192.0.2.7"}, "192.0.2.7"},
+		{1, []string{"198.51.100.1", "192.0.2.7"}, "192.0.2.7"},
+		{2, []string{"198.51.100.1, 192.0.2.7, 10.0.0.9"}, "192.0.2.7"},
+		{3, []string{"192.0.2.7, 10.0.0.9"}, "192.0.2.7"},
+	}
+	for _, tt := range tests {
+		trustedProxies = tt.proxies
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "10.0.0.1:1234"
+		for _, f := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		if got := clientAddr(r); got != tt.want {
+			t.Errorf("Client behind %d proxies with X-Forwarded-For %q: got %q, want %q", tt.proxies, tt.forwarded, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Limiting the rate of requests to SIS across all instances of the server
+// which share the database. All the requests go through the limiter, be
+// they of live queries or of background work (see sisparse.WithBackground),
+// which may moreover be restricted to a window at night.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/calendar"
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// Returned to background requests to SIS outside the crawl window
+var ErrOutsideCrawlWindow = errors.New("Background requests to SIS are only made within the crawl window")
+
+// How many requests per second are made to SIS by default, over all the
+// instances; SIS is shared by the whole university and is slow enough as it is
+const DEFAULT_SIS_RATE = 2
+
+// A sisparse.RateLimiter allowing perSecond requests per second on average,
+// counted in the database so that the limit holds for all the instances.
+// Up to burst requests may be made at once (rounded down to what the rate
+// allows in whole seconds), after which the rest of the burst's time slot is
+// waited out. Rates below 1 make slots of several seconds with a single
+// request each. 0 for perSecond means no limit.
+type sharedRateLimiter struct {
+	key       string
+	perSecond float64
+	burst     int
+	// Background requests outside it fail with ErrOutsideCrawlWindow
+	crawlWindow *crawlWindow
+}
+
+// Returns the length of the time slots in which requests are counted, and
+// how many may be made in each.
+func (l sharedRateLimiter) slot() (time.Duration, int) {
+	burst := l.burst
+	if burst <= 0 {
+		burst = int(math.Max(1, l.perSecond))
+	}
+	seconds := math.Max(1, math.Floor(float64(burst)/l.perSecond))
+	if seconds*l.perSecond < 1 {
+		// At least one request in every slot
+		seconds = math.Ceil(1 / l.perSecond)
+	}
+	// The small amount is for the rounding errors of, e.g., 0.1 * 10
+	limit := int(math.Floor(seconds*l.perSecond + 1e-9))
+	return time.Duration(seconds) * time.Second, limit
+}
+
+func (l sharedRateLimiter) Wait(ctx context.Context) error {
+	if sisparse.IsBackground(ctx) && l.crawlWindow != nil && !l.crawlWindow.contains(time.Now()) {
+		return ErrOutsideCrawlWindow
+	}
+	if l.perSecond <= 0 {
+		return nil
+	}
+	length, limit := l.slot()
+	for {
+		now := time.Now()
+		slot := now.Unix() / int64(length/time.Second)
+		n, err := db.IncrementCounter(l.key, slot)
+		if err != nil {
+			// Rather than failing all queries when the database is down
+			log.Printf("Could not check the rate limit: %s", err)
+			return nil
+		}
+		if n <= limit {
+			return nil
+		}
+		nextSlot := time.Unix((slot+1)*int64(length/time.Second), 0)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(nextSlot.Sub(now)):
+		}
+	}
+}
+
+// A time of day range in Prague, such as 22:00-06:00 (over midnight).
+type crawlWindow struct {
+	from, to sisparse.ClockTime
+}
+
+// Parses a window given as "<hh:mm>-<hh:mm>".
+func parseCrawlWindow(s string) (*crawlWindow, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid crawl window %q, expected hh:mm-hh:mm", s)
+	}
+	from, err := sisparse.ParseClockTime(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, err
+	}
+	to, err := sisparse.ParseClockTime(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, err
+	}
+	return &crawlWindow{from, to}, nil
+}
+
+func (w *crawlWindow) contains(t time.Time) bool {
+	t = t.In(calendar.Location)
+	now := sisparse.NewClockTime(t.Hour(), t.Minute())
+	if w.from <= w.to {
+		return w.from <= now && now < w.to
+	}
+	return now >= w.from || now < w.to
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iamwave/samorozvrh/calendar"
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// A store whose counters are in memory, or which fails to count with err
+type counterStore struct {
+	store.Store
+	mu     sync.Mutex
+	counts map[string]int
+	err    error
+}
+
+func newCounterStore() *counterStore {
+	return &counterStore{counts: map[string]int{}}
+}
+
+func (s *counterStore) IncrementCounter(key string, slot int64) (int, error) {
+	return s.AddToCounter(key, slot, 1)
+}
+
+func (s *counterStore) AddToCounter(key string, slot int64, n int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	s.counts[key] += n
+	return s.counts[key], nil
+}
+
+// Replaces db for the test.
+func useStore(t *testing.T, s store.Store) {
+	old := db
+	db = s
+	t.Cleanup(func() { db = old })
+}
+
+func TestSharedRateLimiterSlot(t *testing.T) {
+	tests := []struct {
+		perSecond float64
+		burst     int
+		length    time.Duration
+		limit     int
+	}{
+		{5, 0, time.Second, 5},
+		{5, 5, time.Second, 5},
+		{5, 12, 2 * time.Second, 10},
+		{2, 20, 10 * time.Second, 20},
+		{2.5, 0, time.Second, 2},
+		{2.5, 5, 2 * time.Second, 5},
+		{0.5, 0, 2 * time.Second, 1},
+		{0.1, 0, 10 * time.Second, 1},
+		{0.3, 0, 4 * time.Second, 1},
+		{0.5, 3, 6 * time.Second, 3},
+	}
+	for _, tt := range tests {
+		l := sharedRateLimiter{perSecond: tt.perSecond, burst: tt.burst}
+		if length, limit := l.slot(); length != tt.length || limit != tt.limit {
+			t.Errorf("slot() of %g/s with a burst of %d: %s, %d, want %s, %d",
+				tt.perSecond, tt.burst, length, limit, tt.length, tt.limit)
+		}
+	}
+}
+
+func TestSharedRateLimiterWait(t *testing.T) {
+	s := newCounterStore()
+	useStore(t, s)
+	l := sharedRateLimiter{key: "sis", perSecond: 1000}
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait %d: %s", i, err)
+		}
+	}
+	if s.counts["sis"] != 3 {
+		t.Errorf("%d requests counted, want 3", s.counts["sis"])
+	}
+
+	// Over the limit of every slot, it waits until the context is done
+	s.counts["sis"] = 1000
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait over the limit: %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSharedRateLimiterWithoutLimit(t *testing.T) {
+	s := newCounterStore()
+	useStore(t, s)
+	if err := (sharedRateLimiter{key: "sis"}).Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.counts) != 0 {
+		t.Errorf("Requests without a limit are counted: %v", s.counts)
+	}
+}
+
+func TestSharedRateLimiterDatabaseDown(t *testing.T) {
+	s := newCounterStore()
+	s.err = errors.New("connection refused")
+	useStore(t, s)
+	if err := (sharedRateLimiter{key: "sis", perSecond: 1}).Wait(context.Background()); err != nil {
+		t.Errorf("Wait when the database is down: %s", err)
+	}
+}
+
+func TestSharedRateLimiterBurst(t *testing.T) {
+	s := newCounterStore()
+	useStore(t, s)
+	// A burst of 4 at 2 per second: 4 requests at once, then waiting
+	l := sharedRateLimiter{key: "sis", perSecond: 2, burst: 4}
+	for i := 0; i < 4; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait %d within the burst: %s", i, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait after the burst: %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestParseCrawlWindow(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 10, 7, hour, min, 0, 0, calendar.Location)
+	}
+	tests := []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"1:00-5:00", at(3, 0), true},
+		{"1:00-5:00", at(1, 0), true},
+		{"1:00-5:00", at(5, 0), false},
+		{"1:00-5:00", at(0, 59), false},
+		{"1:00-5:00", at(12, 0), false},
+		{" 22:30 - 4:00 ", at(23, 0), true},
+		{"22:30-4:00", at(22, 30), true},
+		{"22:30-4:00", at(3, 59), true},
+		{"22:30-4:00", at(4, 0), false},
+		{"22:30-4:00", at(12, 0), false},
+		// The times are in Prague whatever the zone of t
+		{"1:00-5:00", at(3, 0).UTC(), true},
+		{"1:00-5:00", time.Date(2024, 10, 7, 3, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		w, err := parseCrawlWindow(tt.window)
+		if err != nil {
+			t.Errorf("parseCrawlWindow(%q): %s", tt.window, err)
+			continue
+		}
+		if got := w.contains(tt.t); got != tt.want {
+			t.Errorf("%q contains %s: got %v, want %v", tt.window, tt.t, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "1:00", "1:00-2:00-3:00", "x-5:00", "1:60-5:00", "1-5"} {
+		if _, err := parseCrawlWindow(s); err == nil {
+			t.Errorf("parseCrawlWindow(%q) succeeded", s)
+		}
+	}
+}
+
+func TestSharedRateLimiterCrawlWindow(t *testing.T) {
+	s := newCounterStore()
+	useStore(t, s)
+	// A window which is never open
+	w, err := parseCrawlWindow("0:00-0:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := sharedRateLimiter{key: "sis", perSecond: 1000, crawlWindow: w}
+	if err := l.Wait(sisparse.WithBackground(context.Background())); err != ErrOutsideCrawlWindow {
+		t.Errorf("Background Wait outside the window: %v, want %v", err, ErrOutsideCrawlWindow)
+	}
+	if s.counts["sis"] != 0 {
+		t.Error("A refused background request is counted")
+	}
+	// Live queries aren't restricted to the window
+	if err := l.Wait(context.Background()); err != nil {
+		t.Errorf("Live Wait outside the window: %s", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Request IDs: each request gets a short ID, or keeps the one its client
+// (or a proxy in front of us) sent in X-Request-ID. The ID is echoed in
+// X-Request-ID, prefixed to the log lines of the request, recorded in its
+// trace and the access log, and added as "ref" to JSON error answers, so
+// that the reference in a user's bug report leads to the logs.
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// IDs sent by clients which we keep, others are replaced
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestIdKey struct{}
+
+// Returns the ID of the request ctx belongs to, "" if there is none.
+func requestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+func newRequestId() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func requestIdMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestId.MatchString(id) {
+			id = newRequestId()
+		}
+		ctx := context.WithValue(r.Context(), requestIdKey{}, id)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", id))
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(&errorRefWriter{ResponseWriter: w, id: id}, r.WithContext(ctx))
+	})
+}
+
+// Adds "ref" with the request ID to answers which are JSON objects with
+// an "error" (and no "data"). Handlers write their answers in one piece,
+// so the first write tells.
+type errorRefWriter struct {
+	http.ResponseWriter
+	id      string
+	decided bool
+}
+
+func (w *errorRefWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		return w.ResponseWriter.Write(b)
+	}
+	w.decided = true
+	var answer map[string]json.RawMessage
+	if len(b) == 0 || b[0] != '{' || json.Unmarshal(b, &answer) != nil {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, ok := answer["error"]; !ok {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, ok := answer["data"]; ok {
+		return w.ResponseWriter.Write(b)
+	}
+	if _, ok := answer["ref"]; ok {
+		return w.ResponseWriter.Write(b)
+	}
+	ref, _ := json.Marshal(w.id)
+	withRef := bytes.Join([][]byte{[]byte(`{"ref":`), ref, []byte(","), b[1:]}, nil)
+	if _, err := w.ResponseWriter.Write(withRef); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
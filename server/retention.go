@@ -0,0 +1,229 @@
+// Retention of the data users leave behind: saved schedules, the requests
+// and results of solver runs and the access log are dropped once they are
+// older than configured (-retain-schedules, -retain-jobs, -retain-access-log),
+// and users can have all their data deleted at once (DELETE /api/v1/userdata).
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// How often old data is looked for
+const RETENTION_CHECK_INTERVAL = time.Hour
+
+// The most saved schedules one request can delete
+const MAX_DELETED_SCHEDULES = 50
+
+// How long the data is kept, 0 for ever
+type retentionPolicy struct {
+	Schedules time.Duration
+	Jobs      time.Duration
+}
+
+func (p retentionPolicy) enabled() bool {
+	return p.Schedules > 0 || p.Jobs > 0
+}
+
+// Drops the data older than the policy allows every RETENTION_CHECK_INTERVAL,
+// until ctx is done.
+func enforceRetention(ctx context.Context, p retentionPolicy) {
+	for {
+		if err := dropOldData(p, time.Now().UTC()); err != nil {
+			log.Printf("Could not drop old data: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(RETENTION_CHECK_INTERVAL):
+		}
+	}
+}
+
+func dropOldData(p retentionPolicy, now time.Time) error {
+	if p.Schedules > 0 {
+		n, err := db.DeleteSchedules(now.Add(-p.Schedules))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("Dropped %d saved schedules older than %s", n, p.Schedules)
+		}
+	}
+	if p.Jobs > 0 {
+		// Running jobs are left alone
+		for _, status := range []string{JOB_DONE, JOB_FAILED, JOB_INTERRUPTED} {
+			n, err := db.DeleteJobs(status, now.Add(-p.Jobs))
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				log.Printf("Dropped %d %s jobs older than %s", n, status, p.Jobs)
+			}
+		}
+	}
+	return nil
+}
+
+// What DELETE /api/v1/userdata deleted
+type deletedUserData struct {
+	Profiles      int  `json:"profiles"`
+	Jobs          int  `json:"jobs"`
+	Notifications bool `json:"notifications"`
+	Schedules     int  `json:"schedules"`
+	// The tokens of the given schedules which weren't deleted, as neither
+	// the owner token nor the user fit them
+	NotOwned []string `json:"not_owned"`
+}
+
+// Answers DELETE /api/v1/userdata by deleting everything of the user
+// (given by PROFILE_TOKEN_HEADER): their profiles, solve history,
+// notification settings and subscriptions, and with ?schedule=<token>
+// (repeated), the saved schedules, which aren't tied to users otherwise.
+// A schedule is given as <token>:<owner token>, or just by its token if
+// the user saved it. Either the header or a schedule is needed. Answers
+// with {"data":deletedUserData}; schedules the user may not delete are
+// skipped and listed in it.
+func userDataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use DELETE"}`)
+		return
+	}
+	user := requestUserHash(r)
+	schedules := r.URL.Query()["schedule"]
+	if user == "" && len(schedules) == 0 {
+		fmt.Fprintf(w, `{"error":"Send the token of your profiles in %s or the tokens of saved schedules in ?schedule="}`, PROFILE_TOKEN_HEADER)
+		return
+	}
+	if len(schedules) > MAX_DELETED_SCHEDULES {
+		fmt.Fprintf(w, `{"error":"At most %d schedules can be deleted at once"}`, MAX_DELETED_SCHEDULES)
+		return
+	}
+	res, err := deleteUserData(user, schedules)
+	if err != nil {
+		logf(r.Context(), "User data deletion error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	s, _ := json.Marshal(res)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func deleteUserData(user string, schedules []string) (deletedUserData, error) {
+	res := deletedUserData{NotOwned: []string{}}
+	var err error
+	for _, schedule := range schedules {
+		token, owner := schedule, ""
+		if i := strings.Index(schedule, ":"); i >= 0 {
+			token, owner = schedule[:i], schedule[i+1:]
+		}
+		err = deleteOwnedSchedule(token, owner, user)
+		if err == nil {
+			res.Schedules++
+		} else if err == ErrNotScheduleOwner {
+			res.NotOwned = append(res.NotOwned, token)
+		} else if err != store.ErrNotFound {
+			return res, err
+		}
+	}
+	if user == "" {
+		return res, nil
+	}
+	if res.Profiles, err = db.DeleteProfiles(user); err != nil {
+		return res, err
+	}
+	if res.Jobs, err = db.DeleteUserJobs(user); err != nil {
+		return res, err
+	}
+	err = forgetNotifySettings(user)
+	if err == nil {
+		res.Notifications = true
+	} else if err != store.ErrNotFound {
+		return res, err
+	}
+	return res, nil
+}
+
+// An access log written to a file of its own each day: the file of the day
+// is the configured path, and those of past days have the date appended
+// (e.g. "access.log.2024-10-07"). Files older than the retention are
+// removed.
+type dailyLog struct {
+	path   string
+	retain time.Duration
+	mu     sync.Mutex
+	file   *os.File
+	day    string
+}
+
+func openDailyLog(path string, retain time.Duration) (*dailyLog, error) {
+	l := &dailyLog{path: path, retain: retain}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	l.file = f
+	l.day = time.Now().Format("2006-01-02")
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		// Written on an earlier day, before a restart
+		l.day = info.ModTime().Format("2006-01-02")
+	}
+	l.removeOld()
+	return l, nil
+}
+
+func (l *dailyLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if today := time.Now().Format("2006-01-02"); today != l.day {
+		if err := l.rotate(today); err != nil {
+			log.Printf("Could not rotate the access log: %s", err)
+		}
+	}
+	return l.file.Write(p)
+}
+
+func (l *dailyLog) rotate(today string) error {
+	l.file.Close()
+	err := os.Rename(l.path, l.path+"."+l.day)
+	f, openErr := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if openErr != nil {
+		return openErr
+	}
+	l.file, l.day = f, today
+	go l.removeOld()
+	return err
+}
+
+// Removes the files of the days past the retention.
+func (l *dailyLog) removeOld() {
+	old, err := filepath.Glob(l.path + ".????-??-??")
+	if err != nil {
+		return
+	}
+	for _, name := range old {
+		day, err := time.ParseInLocation("2006-01-02", name[len(l.path)+1:], time.Local)
+		if err == nil && time.Since(day.AddDate(0, 0, 1)) > l.retain {
+			if err := os.Remove(name); err != nil {
+				log.Printf("Could not remove an old access log: %s", err)
+			}
+		}
+	}
+}
+
+func (l *dailyLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// Replaces db by a new SQLite store for the test.
+func useSqliteStore(t *testing.T) *store.SQLStore {
+	s, err := store.Open(store.SQLITE, filepath.Join(t.TempDir(), "samorozvrh.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	useStore(t, s)
+	return s
+}
+
+func TestDropOldJobs(t *testing.T) {
+	s := useSqliteStore(t)
+	for _, status := range []string{JOB_DONE, JOB_FAILED, JOB_INTERRUPTED, JOB_RUNNING} {
+		if err := s.SaveJob(store.Job{Id: status, Status: status, Request: "{}", UserHash: "user"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dropOldData(retentionPolicy{Jobs: time.Hour}, time.Now().Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range []string{JOB_DONE, JOB_FAILED, JOB_INTERRUPTED} {
+		if _, err := s.GetJob(status); err != store.ErrNotFound {
+			t.Errorf("Old %s job: %v, want it dropped", status, err)
+		}
+	}
+	if _, err := s.GetJob(JOB_RUNNING); err != nil {
+		t.Errorf("Running job: %v, want it kept", err)
+	}
+}
+
+func TestDeleteUserData(t *testing.T) {
+	s := useSqliteStore(t)
+	for _, sch := range []store.Schedule{
+		{Token: "owned", Data: "{}", OwnerHash: hashToken("owner")},
+		{Token: "saved", Data: "{}", UserHash: "user"},
+		{Token: "other", Data: "{}", OwnerHash: hashToken("someone"), UserHash: "someone"},
+	} {
+		if err := s.SaveSchedule(sch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveProfile(store.Profile{Id: "p", OwnerHash: "user", Name: "Zima", Data: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveJob(store.Job{Id: "j", Status: JOB_DONE, Request: "{}", UserHash: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := deleteUserData("user", []string{"owned:owner", "other:owner", "saved", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The schedule of someone else is skipped, the rest deleted anyway
+	want := deletedUserData{Profiles: 1, Jobs: 1, Schedules: 2, NotOwned: []string{"other"}}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("Deleted %+v, want %+v", res, want)
+	}
+	if _, err := s.GetSchedule("other"); err != nil {
+		t.Errorf("The schedule of someone else: %v, want it kept", err)
+	}
+	for _, token := range []string{"owned", "saved"} {
+		if _, err := s.GetSchedule(token); err != store.ErrNotFound {
+			t.Errorf("Schedule %s: %v, want it deleted", token, err)
+		}
+	}
+}
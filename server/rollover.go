@@ -0,0 +1,277 @@
+// Rolling over to the next semester without a redeploy: the current term
+// changes by the academic calendar or at the dates of -rollover-dates, a
+// while before that (see -prewarm) the courses likely to be asked for are
+// fetched for the next term, and when the academic year changes, those
+// fetched in advance become the current ones. Past terms stay queryable
+// with ?year= and ?semester=.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// How often the background job looks whether the term has changed
+const ROLLOVER_CHECK_INTERVAL = time.Hour
+
+// The most courses fetched in advance for a tenant
+const MAX_PREWARM_COURSES = 500
+
+// How many days of the statistics decide which courses are popular
+const PREWARM_STATS_DAYS = 30
+
+// The days (month and day) from which the winter and the summer semester
+// are the current ones, when the academic calendar doesn't say.
+type rolloverDates struct {
+	Winter, Summer monthDay
+}
+
+type monthDay struct {
+	Month time.Month
+	Day   int
+}
+
+func (d monthDay) less(e monthDay) bool {
+	return d.Month < e.Month || d.Month == e.Month && d.Day < e.Day
+}
+
+// Whether the day has come in the year of t.
+func (d monthDay) reached(t time.Time) bool {
+	return !(monthDay{t.Month(), t.Day()}).less(d)
+}
+
+// See -rollover-dates; nil for the dates of sisparse.CurrentTerm
+var rollover *rolloverDates
+
+// How long before the term changes its courses are fetched, see -prewarm
+var prewarmAhead time.Duration
+
+// Parses -rollover-dates, e.g. "08-15,02-01": the winter semester is the
+// current one from 15 August, the summer one from 1 February.
+func parseRolloverDates(s string) (*rolloverDates, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Expected <winter MM-DD>,<summer MM-DD>, got %q", s)
+	}
+	var dates [2]monthDay
+	for i, p := range parts {
+		t, err := time.Parse("01-02", strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid date %q, expected MM-DD", p)
+		}
+		dates[i] = monthDay{t.Month(), t.Day()}
+	}
+	if !dates[1].less(dates[0]) {
+		return nil, fmt.Errorf("The summer semester must start earlier in the year than the winter one")
+	}
+	return &rolloverDates{Winter: dates[0], Summer: dates[1]}, nil
+}
+
+func (r *rolloverDates) term(now time.Time) (year int, sem int) {
+	switch {
+	case r.Winter.reached(now):
+		return now.Year(), sisparse.Winter
+	case r.Summer.reached(now):
+		return now.Year() - 1, sisparse.Summer
+	default:
+		return now.Year() - 1, sisparse.Winter
+	}
+}
+
+// Returns the term of the tenant at the given time, see currentTerm.
+func termAt(t *tenant, at time.Time) term {
+	year, sem := detectTermWith(t.semester, at)
+	return term{Year: year, Semester: sem}
+}
+
+// The settings keys of the term the tenant was last seen in, and of the
+// term its courses were last fetched in advance for
+func termKey(t *tenant, name string) string {
+	if t == defaultTenant {
+		return SETTINGS_PREFIX + name
+	}
+	return SETTINGS_PREFIX + name + "/" + t.Id
+}
+
+func getTermSetting(key string) (term, bool) {
+	var res term
+	e, err := db.GetCache(key)
+	if err != nil {
+		if err != store.ErrNotFound {
+			log.Printf("Rollover: %s", err)
+		}
+		return res, false
+	}
+	return res, json.Unmarshal([]byte(e.Value), &res) == nil
+}
+
+func setTermSetting(key string, t term) {
+	s, _ := json.Marshal(t)
+	if err := db.SetCache(key, string(s)); err != nil {
+		log.Printf("Rollover: %s", err)
+	}
+}
+
+// Looks every ROLLOVER_CHECK_INTERVAL whether a tenant's term has changed
+// or is about to, until ctx is done.
+func watchRollover(ctx context.Context) {
+	for {
+		for _, t := range append([]*tenant{defaultTenant}, tenants...) {
+			checkRollover(withTenant(ctx, t), t)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ROLLOVER_CHECK_INTERVAL):
+		}
+	}
+}
+
+func checkRollover(ctx context.Context, t *tenant) {
+	now := time.Now()
+	current := termAt(t, now)
+	seenKey := termKey(t, "term")
+	if seen, ok := getTermSetting(seenKey); !ok || seen != current {
+		log.Printf("Rollover: tenant %q is in %d/%d, semester %d", t.Id, current.Year, current.Year+1, current.Semester)
+		if ok && current.Year > seen.Year {
+			promotePrewarmed(ctx, current.Year)
+		}
+		setTermSetting(seenKey, current)
+	}
+
+	if prewarmAhead <= 0 {
+		return
+	}
+	next := termAt(t, now.Add(prewarmAhead))
+	prewarmedKey := termKey(t, "prewarmed")
+	if prewarmed, ok := getTermSetting(prewarmedKey); next == current || ok && prewarmed == next {
+		return
+	}
+	if err := prewarm(ctx, next); err != nil {
+		// Tried again at the next check
+		log.Printf("Rollover: prewarming stopped: %s", err)
+		return
+	}
+	setTermSetting(prewarmedKey, next)
+}
+
+// Returns the codes of the courses worth fetching for the term of the
+// tenant of ctx: the most requested ones (see -stats), then those cached
+// for the same semester, at most MAX_PREWARM_COURSES.
+func prewarmCourses(ctx context.Context, next term) ([]string, error) {
+	res := []string{}
+	seen := map[string]bool{}
+	add := func(code string) {
+		if !seen[code] && len(res) < MAX_PREWARM_COURSES {
+			seen[code] = true
+			res = append(res, code)
+		}
+	}
+	if statsEnabled {
+		stats, err := getUsageStats(ctx, PREWARM_STATS_DAYS)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range stats.Courses {
+			add(c.Code)
+		}
+	}
+	names, err := listTenantCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !isCourseCacheName(name) || strings.Contains(name, "~") {
+			continue
+		}
+		code, sem := splitCourseCacheName(name)
+		if sem == next.Semester {
+			add(code)
+		}
+	}
+	return res, nil
+}
+
+// Splits "<code>[@<semester>]" (see getCourseCacheName).
+func splitCourseCacheName(name string) (code string, sem int) {
+	code, sem = name, sisparse.Winter
+	if i := strings.Index(name, "@"); i >= 0 {
+		code = name[:i]
+		sem, _ = strconv.Atoi(name[i+1:])
+	}
+	return code, sem
+}
+
+// Fetches the courses worth fetching (see prewarmCourses) in the term, for
+// the tenant of ctx. Returns an error if it can't go on now, e.g. outside
+// the crawl window.
+func prewarm(ctx context.Context, next term) error {
+	codes, err := prewarmCourses(ctx, next)
+	if err != nil {
+		return err
+	}
+	log.Printf("Rollover: prewarming %d courses of %d/%d, semester %d", len(codes), next.Year, next.Year+1, next.Semester)
+	ctx = sisparse.WithBackground(sisparse.WithAcademicYear(ctx, next.Year))
+	for _, code := range codes {
+		if _, ok := getCachedCourse(ctx, code, next.Semester); ok {
+			continue
+		}
+		_, err := doFetchCourse(ctx, code, next.Semester)
+		if errors.Is(err, ErrOutsideCrawlWindow) || errors.Is(err, sisparse.ErrCircuitOpen) ||
+			errors.Is(err, sisparse.ErrPaused) || ctx.Err() != nil {
+			return fmt.Errorf("%s: %s", code, err)
+		}
+		if err != nil && err != sisparse.ErrScheduleNotFound {
+			log.Printf("Rollover: skipping %s: %s", code, err)
+		}
+	}
+	return nil
+}
+
+// Makes the courses fetched in advance for the academic year, which is
+// now the current one, the current answers of the tenant of ctx (they were
+// cached under "<name>~<year>", see courseCacheName).
+func promotePrewarmed(ctx context.Context, year int) {
+	names, err := listTenantCache(ctx)
+	if err != nil {
+		log.Printf("Rollover: %s", err)
+		return
+	}
+	suffix := fmt.Sprintf("~%d", year)
+	promoted := 0
+	for _, name := range names {
+		if !isCourseCacheName(name) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		res, err := getCache(tenantCacheName(ctx, name))
+		if err != nil {
+			continue
+		}
+		code, sem := splitCourseCacheName(strings.TrimSuffix(name, suffix))
+		yearCtx := sisparse.WithAcademicYear(ctx, year)
+		keepPastYear(yearCtx, code, sem)
+		if err := setCache(courseCacheName(yearCtx, code, sem), res); err != nil {
+			log.Printf("Rollover: %s", err)
+			continue
+		}
+		var cached struct {
+			Data [][]sisparse.Event `json:"data"`
+		}
+		if json.Unmarshal([]byte(res), &cached) == nil {
+			tenantOf(ctx).index.add(code, cached.Data)
+		}
+		promoted++
+	}
+	log.Printf("Rollover: %d courses fetched in advance are now current", promoted)
+}
@@ -0,0 +1,217 @@
+// How busy the rooms of each building are over a week, from the events of
+// the cached courses, e.g. to show when a building is quiet or to find a
+// free room. Only cached courses count, so the busier a deployment, the
+// closer to SIS this is.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// How long occupancies are kept before they are computed again
+const ROOM_OCCUPANCY_TTL = 10 * time.Minute
+
+type buildingOccupancy struct {
+	Building string   `json:"building"`
+	Rooms    []string `json:"rooms"`
+	// For each day (Monday = 0) and hour, the share of the time the rooms
+	// of the building are in use, from 0 to 1 (events in odd or even weeks
+	// count half)
+	Hours [7][24]float64 `json:"hours"`
+	// The same for each room, only for ?building=
+	ByRoom map[string]*[7][24]float64 `json:"by_room,omitempty"`
+}
+
+type roomOccupancy struct {
+	Year     int `json:"academic_year"`
+	Semester int `json:"semester"`
+	// The cached courses the occupancy is made from
+	Courses   int                 `json:"courses"`
+	Buildings []buildingOccupancy `json:"buildings"`
+}
+
+var roomOccupanciesMu sync.Mutex
+var roomOccupancies = map[string]struct {
+	res     roomOccupancy
+	expires time.Time
+}{}
+
+// Answers GET /rooms/occupancy?semester=&year=&building= with
+// {"data":roomOccupancy}; with ?building=, only that building, room by room.
+func roomOccupancyHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := requestTerm(r)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	res, err := getRoomOccupancy(r.Context(), t)
+	if err != nil {
+		logf(r.Context(), "Room occupancy error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	building := r.URL.Query().Get("building")
+	buildings := []buildingOccupancy{}
+	for _, b := range res.Buildings {
+		if building == "" {
+			b.ByRoom = nil
+			buildings = append(buildings, b)
+		} else if b.Building == building {
+			buildings = append(buildings, b)
+		}
+	}
+	res.Buildings = buildings
+	s, _ := json.Marshal(res)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func getRoomOccupancy(ctx context.Context, t term) (roomOccupancy, error) {
+	key := fmt.Sprintf("%s/%d/%d", tenantOf(ctx).Id, t.Year, t.Semester)
+	roomOccupanciesMu.Lock()
+	cached, ok := roomOccupancies[key]
+	roomOccupanciesMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.res, nil
+	}
+	res, err := computeRoomOccupancy(ctx, t)
+	if err != nil {
+		return res, err
+	}
+	roomOccupanciesMu.Lock()
+	cached.res, cached.expires = res, time.Now().Add(ROOM_OCCUPANCY_TTL)
+	roomOccupancies[key] = cached
+	roomOccupanciesMu.Unlock()
+	return res, nil
+}
+
+func computeRoomOccupancy(ctx context.Context, t term) (roomOccupancy, error) {
+	res := roomOccupancy{Year: t.Year, Semester: t.Semester, Buildings: []buildingOccupancy{}}
+	names, err := listTenantCache(ctx)
+	if err != nil {
+		return res, err
+	}
+	// For each room, day and week parity (odd, even), the minutes in use;
+	// cross-listed courses share events, which count once this way
+	busy := map[string]*[7][2][24 * 60]bool{}
+	for _, name := range names {
+		if !isCourseCacheName(name) {
+			continue
+		}
+		base := name
+		if i := strings.Index(name, "~"); i >= 0 {
+			base = name[:i]
+		}
+		if _, sem := splitCourseCacheName(base); sem != t.Semester {
+			continue
+		}
+		s, err := getCache(tenantCacheName(ctx, name))
+		if err != nil {
+			continue
+		}
+		var cached struct {
+			Data [][]sisparse.Event `json:"data"`
+			Year int                `json:"academic_year"`
+		}
+		if json.Unmarshal([]byte(s), &cached) != nil {
+			continue
+		}
+		if cached.Year == 0 && base == name {
+			// Cached before answers said their year
+			cached.Year = currentTerm(ctx).Year
+		}
+		if cached.Year != t.Year {
+			continue
+		}
+		res.Courses++
+		for _, group := range cached.Data {
+			for _, e := range group {
+				if e.Room == "" || e.Day < 0 || e.Day > 6 {
+					continue
+				}
+				if busy[e.Room] == nil {
+					busy[e.Room] = &[7][2][24 * 60]bool{}
+				}
+				for parity, weeks := range []sisparse.WeekParity{sisparse.OddWeeks, sisparse.EvenWeeks} {
+					if !sameWeeks(e.WeekParity, weeks) {
+						continue
+					}
+					for m := e.TimeFrom.Minutes(); m < e.TimeTo.Minutes() && m < 24*60; m++ {
+						busy[e.Room][e.Day][parity][m] = true
+					}
+				}
+			}
+		}
+	}
+
+	buildings := map[string]*buildingOccupancy{}
+	for room, minutes := range busy {
+		name := occupancyBuilding(tenantOf(ctx), room)
+		b := buildings[name]
+		if b == nil {
+			b = &buildingOccupancy{Building: name, Rooms: []string{}, ByRoom: map[string]*[7][24]float64{}}
+			buildings[name] = b
+		}
+		b.Rooms = append(b.Rooms, room)
+		hours := &[7][24]float64{}
+		for day := range minutes {
+			for hour := 0; hour < 24; hour++ {
+				used := 0
+				for parity := range minutes[day] {
+					for m := hour * 60; m < (hour+1)*60; m++ {
+						if minutes[day][parity][m] {
+							used++
+						}
+					}
+				}
+				hours[day][hour] = float64(used) / 120
+				b.Hours[day][hour] += hours[day][hour]
+			}
+		}
+		b.ByRoom[room] = roundOccupancy(hours)
+	}
+	for _, b := range buildings {
+		sort.Strings(b.Rooms)
+		for day := range b.Hours {
+			for hour := range b.Hours[day] {
+				b.Hours[day][hour] /= float64(len(b.Rooms))
+			}
+		}
+		b.Hours = *roundOccupancy(&b.Hours)
+		res.Buildings = append(res.Buildings, *b)
+	}
+	sort.Slice(res.Buildings, func(i, j int) bool { return res.Buildings[i].Building < res.Buildings[j].Building })
+	return res, nil
+}
+
+// The building of the room by the travel times of the tenant (see
+// roomBuilding), otherwise the letters the name of the room starts with
+// (e.g. "S" for "S5"), or the room itself.
+func occupancyBuilding(t *tenant, room string) string {
+	if b := roomBuilding(t, room); b != "" {
+		return b
+	}
+	if i := strings.IndexFunc(room, func(r rune) bool { return !unicode.IsLetter(r) }); i > 0 {
+		return room[:i]
+	}
+	return room
+}
+
+func roundOccupancy(hours *[7][24]float64) *[7][24]float64 {
+	for day := range hours {
+		for hour := range hours[day] {
+			hours[day][hour] = math.Round(hours[day][hour]*100) / 100
+		}
+	}
+	return hours
+}
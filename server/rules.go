@@ -0,0 +1,464 @@
+// A small language for constraints on the events of a schedule, such as
+//
+//	not(teacher = "Dr. X")
+//	group.day != Friday
+//	course("NMAI054").type == "přednáška" starts_after 10:00
+//	prefer ends_before 17:00
+//
+// A rule is a condition every event of a chosen group must satisfy; groups
+// which don't are removed before solving. Rules starting with "prefer" are
+// soft: the solver only avoids the groups which break them if it can.
+//
+// Conditions compare a field of an event (teacher, teacher_id, name, code, course,
+// type, language, day, time_from, time_to; optionally prefixed with "group.") with a value
+// using =, ==, !=, ~ (contains), <, <=, >, >=. They can be combined with
+// and, or, not and parentheses. course("X").cond applies cond to the events
+// of the course X only: for the events of other courses, it is left out of
+// whatever it is part of, so that course("X").day != Friday holds for all of
+// them. "sel starts_after 10:00" means that events matching sel start at
+// 10:00 or later (without sel, all events do); similarly for starts_before,
+// ends_after and ends_before.
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+type rule struct {
+	Source string
+	Soft   bool
+	Cond   condition
+}
+
+type condition func(e sisparse.Event) bool
+
+// A condition as parsed, which may not apply to some events (those of the
+// other courses in course("X").cond).
+type scopedCondition func(e sisparse.Event) (holds, applies bool)
+
+func unscoped(c condition) scopedCondition {
+	return func(e sisparse.Event) (bool, bool) { return c(e), true }
+}
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokString
+	tokTime
+	tokNumber
+	tokOp
+	tokEOF
+)
+
+type token struct {
+	Kind tokenKind
+	Text string
+	Pos  int
+}
+
+func tokenizeRule(s string) ([]token, error) {
+	res := []token{}
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				j++
+			}
+			if j == len(runes) {
+				return nil, fmt.Errorf("Unterminated string at %d", i)
+			}
+			res = append(res, token{tokString, string(runes[i+1 : j]), i})
+			i = j + 1
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == ':') {
+				j++
+			}
+			kind := tokNumber
+			if strings.ContainsRune(string(runes[i:j]), ':') {
+				kind = tokTime
+			}
+			res = append(res, token{kind, string(runes[i:j]), i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			res = append(res, token{tokIdent, string(runes[i:j]), i})
+			i = j
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "==", "!=", "<=", ">=":
+					op = two
+				}
+			}
+			if !ruleOps[op] {
+				return nil, fmt.Errorf("Unexpected %q at %d", op, i)
+			}
+			res = append(res, token{tokOp, op, i})
+			i += len([]rune(op))
+		}
+	}
+	return append(res, token{tokEOF, "", len(runes)}), nil
+}
+
+var ruleOps = map[string]bool{
+	"==": true, "!=": true, "<=": true, ">=": true, "=": true, "<": true, ">": true,
+	"~": true, "(": true, ")": true, ".": true,
+}
+
+type ruleParser struct {
+	tokens []token
+	pos    int
+}
+
+// Parses a rule, see the description at the top of the file.
+func parseRule(s string) (rule, error) {
+	tokens, err := tokenizeRule(s)
+	if err != nil {
+		return rule{}, err
+	}
+	p := &ruleParser{tokens: tokens}
+	r := rule{Source: s}
+	if p.peekIdent("prefer") {
+		p.pos++
+		r.Soft = true
+	}
+	c, err := p.parseOr()
+	if err != nil {
+		return rule{}, err
+	}
+	if t := p.peek(); t.Kind != tokEOF {
+		return rule{}, fmt.Errorf("Unexpected %q at %d", t.Text, t.Pos)
+	}
+	r.Cond = func(e sisparse.Event) bool {
+		holds, applies := c(e)
+		return holds || !applies
+	}
+	return r, nil
+}
+
+func (p *ruleParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *ruleParser) next() token {
+	t := p.tokens[p.pos]
+	if t.Kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *ruleParser) peekIdent(name string) bool {
+	t := p.peek()
+	return t.Kind == tokIdent && strings.EqualFold(t.Text, name)
+}
+
+func (p *ruleParser) peekOp(op string) bool {
+	t := p.peek()
+	return t.Kind == tokOp && t.Text == op
+}
+
+func (p *ruleParser) expectOp(op string) error {
+	if t := p.next(); t.Kind != tokOp || t.Text != op {
+		return fmt.Errorf("Expected %q at %d", op, t.Pos)
+	}
+	return nil
+}
+
+func (p *ruleParser) parseOr() (scopedCondition, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekIdent("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = combine(left, right, func(a, b bool) bool { return a || b })
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (scopedCondition, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekIdent("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = combine(left, right, func(a, b bool) bool { return a && b })
+	}
+	return left, nil
+}
+
+// Combines two conditions by op where both apply, and else takes the one
+// which applies.
+func combine(left, right scopedCondition, op func(a, b bool) bool) scopedCondition {
+	return func(e sisparse.Event) (bool, bool) {
+		l, lApplies := left(e)
+		r, rApplies := right(e)
+		switch {
+		case !lApplies:
+			return r, rApplies
+		case !rApplies:
+			return l, lApplies
+		}
+		return op(l, r), true
+	}
+}
+
+func (p *ruleParser) parseUnary() (scopedCondition, error) {
+	if p.peekIdent("not") {
+		p.pos++
+		c, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(e sisparse.Event) (bool, bool) {
+			holds, applies := c(e)
+			return !holds, applies
+		}, nil
+	}
+	if timeCond, ok := p.parseTimeOp(); ok {
+		c, err := timeCond()
+		return unscoped(c), err
+	}
+	selector, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if timeCond, ok := p.parseTimeOp(); ok {
+		// "sel starts_after t": the events matching sel have to satisfy it
+		c, err := timeCond()
+		if err != nil {
+			return nil, err
+		}
+		return func(e sisparse.Event) (bool, bool) {
+			selected, applies := selector(e)
+			return !selected || c(e), applies
+		}, nil
+	}
+	return selector, nil
+}
+
+// If a time operator (such as "starts_after 10:00") follows, returns
+// a function parsing it.
+func (p *ruleParser) parseTimeOp() (func() (condition, error), bool) {
+	t := p.peek()
+	if t.Kind != tokIdent {
+		return nil, false
+	}
+	var field, op string
+	switch strings.ToLower(t.Text) {
+	case "starts_after":
+		field, op = "time_from", ">="
+	case "starts_before":
+		field, op = "time_from", "<"
+	case "ends_after":
+		field, op = "time_to", ">"
+	case "ends_before":
+		field, op = "time_to", "<="
+	default:
+		return nil, false
+	}
+	return func() (condition, error) {
+		p.pos++
+		return p.parseComparison(field, op, p.next())
+	}, true
+}
+
+func (p *ruleParser) parsePrimary() (scopedCondition, error) {
+	if p.peekOp("(") {
+		p.pos++
+		c, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expectOp(")")
+	}
+
+	t := p.next()
+	if t.Kind != tokIdent {
+		return nil, fmt.Errorf("Expected a field at %d", t.Pos)
+	}
+	field := strings.ToLower(t.Text)
+
+	if field == "course" && p.peekOp("(") {
+		p.pos++
+		code := p.next()
+		if code.Kind != tokString && code.Kind != tokIdent {
+			return nil, fmt.Errorf("Expected a course code at %d", code.Pos)
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		courseCode := sisparse.NormalizeCourseCode(code.Text)
+		inCourse := func(e sisparse.Event) bool { return e.CourseCode == courseCode }
+		if !p.peekOp(".") {
+			return unscoped(inCourse), nil
+		}
+		p.pos++
+		c, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return func(e sisparse.Event) (bool, bool) {
+			if !inCourse(e) {
+				return false, false
+			}
+			return c(e)
+		}, nil
+	}
+
+	if field == "group" && p.peekOp(".") {
+		p.pos++
+		return p.parsePrimary()
+	}
+
+	op := p.next()
+	if op.Kind != tokOp || op.Text == "(" || op.Text == ")" || op.Text == "." {
+		return nil, fmt.Errorf("Expected a comparison at %d", op.Pos)
+	}
+	c, err := p.parseComparison(field, op.Text, p.next())
+	return unscoped(c), err
+}
+
+func (p *ruleParser) parseComparison(field, op string, value token) (condition, error) {
+	if value.Kind == tokEOF || value.Kind == tokOp {
+		return nil, fmt.Errorf("Expected a value at %d", value.Pos)
+	}
+
+	switch field {
+	case "teacher", "teacher_id", "name", "code", "course", "language":
+		if op != "=" && op != "==" && op != "!=" && op != "~" {
+			return nil, fmt.Errorf("Can't use %s with %s", op, field)
+		}
+		get := map[string]func(sisparse.Event) string{
+			"teacher":    func(e sisparse.Event) string { return e.Teacher },
+			"teacher_id": func(e sisparse.Event) string { return e.TeacherID },
+			"name":       func(e sisparse.Event) string { return e.Name },
+			"code":       func(e sisparse.Event) string { return e.Code },
+			"course":     func(e sisparse.Event) string { return e.CourseCode },
+			"language":   func(e sisparse.Event) string { return e.Language },
+		}[field]
+		want := strings.ToLower(value.Text)
+		return func(e sisparse.Event) bool {
+			got := strings.ToLower(get(e))
+			switch op {
+			case "~":
+				return strings.Contains(got, want)
+			case "!=":
+				return got != want
+			}
+			return got == want
+		}, nil
+
+	case "type":
+		t, ok := parseRuleEventType(value.Text)
+		if !ok {
+			return nil, fmt.Errorf("Unknown event type %q", value.Text)
+		}
+		switch op {
+		case "=", "==":
+			return func(e sisparse.Event) bool { return e.Type == t }, nil
+		case "!=":
+			return func(e sisparse.Event) bool { return e.Type != t }, nil
+		}
+		return nil, fmt.Errorf("Can't use %s with type", op)
+
+	case "day":
+		day, ok := parseRuleDay(value.Text)
+		if !ok {
+			return nil, fmt.Errorf("Unknown day %q", value.Text)
+		}
+		return compareInts(op, func(e sisparse.Event) int { return e.Day }, day)
+
+	case "time_from", "start", "time_to", "end":
+		t, err := time.Parse("15:04", value.Text)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid time %q, expected hh:mm", value.Text)
+		}
+		minutes := t.Hour()*60 + t.Minute()
+		get := func(e sisparse.Event) int { return e.TimeFrom.Minutes() }
+		if field == "time_to" || field == "end" {
+			get = func(e sisparse.Event) int { return e.TimeTo.Minutes() }
+		}
+		return compareInts(op, get, minutes)
+	}
+	return nil, fmt.Errorf("Unknown field %q", field)
+}
+
+func compareInts(op string, get func(sisparse.Event) int, want int) (condition, error) {
+	cmp := map[string]func(a, b int) bool{
+		"=":  func(a, b int) bool { return a == b },
+		"==": func(a, b int) bool { return a == b },
+		"!=": func(a, b int) bool { return a != b },
+		"<":  func(a, b int) bool { return a < b },
+		"<=": func(a, b int) bool { return a <= b },
+		">":  func(a, b int) bool { return a > b },
+		">=": func(a, b int) bool { return a >= b },
+	}[op]
+	if cmp == nil {
+		return nil, fmt.Errorf("Can't use %s here", op)
+	}
+	return func(e sisparse.Event) bool { return cmp(get(e), want) }, nil
+}
+
+var ruleDays = [][]string{
+	{"po", "pondělí", "mon", "monday"},
+	{"út", "úterý", "tue", "tuesday"},
+	{"st", "středa", "wed", "wednesday"},
+	{"čt", "čtvrtek", "thu", "thursday"},
+	{"pá", "pátek", "fri", "friday"},
+	{"so", "sobota", "sat", "saturday"},
+	{"ne", "neděle", "sun", "sunday"},
+}
+
+// Accepts Czech and English names of days, as well as their numbers (Monday = 0).
+func parseRuleDay(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, n >= 0 && n < len(ruleDays)
+	}
+	s = strings.ToLower(s)
+	for i, names := range ruleDays {
+		for _, name := range names {
+			if s == name {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func parseRuleEventType(s string) (sisparse.EventType, bool) {
+	switch strings.ToLower(s) {
+	case "lecture", "přednáška", "p":
+		return sisparse.Lecture, true
+	case "seminar", "seminář", "cvičení", "x":
+		return sisparse.Seminar, true
+	}
+	return "", false
+}
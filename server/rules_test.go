@@ -0,0 +1,148 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+func ruleEvent(course string, typ sisparse.EventType, day, fromHour, toHour int) sisparse.Event {
+	return sisparse.Event{
+		CourseCode: course,
+		Type:       typ,
+		Day:        day,
+		Teacher:    "Jan Novák",
+		TimeFrom:   sisparse.NewClockTime(fromHour, 0),
+		TimeTo:     sisparse.NewClockTime(toHour, 0),
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	mondayLecture := ruleEvent("NMAI054", sisparse.Lecture, 0, 9, 11)
+	fridaySeminar := ruleEvent("NMAI054", sisparse.Seminar, 4, 15, 17)
+	otherFriday := ruleEvent("NPRG030", sisparse.Lecture, 4, 8, 10)
+	otherTuesday := ruleEvent("NPRG030", sisparse.Seminar, 1, 12, 14)
+
+	tests := []struct {
+		rule  string
+		event sisparse.Event
+		want  bool
+	}{
+		{`teacher = "jan novák"`, mondayLecture, true},
+		{`teacher ~ "Novák"`, mondayLecture, true},
+		{`not(teacher = "Jan Novák")`, mondayLecture, false},
+		{`group.day != Friday`, fridaySeminar, false},
+		{`day != pá`, mondayLecture, true},
+		{`day < 2`, otherTuesday, true},
+		{`type == "přednáška"`, mondayLecture, true},
+		{`type = x`, mondayLecture, false},
+		{`time_from >= 10:00`, mondayLecture, false},
+		{`starts_after 8:00`, otherFriday, true},
+		{`ends_before 16:00`, fridaySeminar, false},
+		{`type = seminar ends_before 16:00`, mondayLecture, true},
+		{`type = seminar ends_before 16:00`, fridaySeminar, false},
+
+		// "and" binds tighter than "or", "not" tighter than both
+		{`day = 0 or day = 1 and type = lecture`, mondayLecture, true},
+		{`day = 0 or day = 1 and type = lecture`, otherTuesday, false},
+		{`(day = 0 or day = 1) and type = lecture`, otherTuesday, false},
+		{`not day = 0 and type = lecture`, mondayLecture, false},
+		{`not (day = 0 and type = seminar)`, mondayLecture, true},
+
+		// course("X").cond only applies to the events of X
+		{`course("NMAI054").day != Friday`, fridaySeminar, false},
+		{`course("NMAI054").day != Friday`, mondayLecture, true},
+		{`course("NMAI054").day != Friday`, otherFriday, true},
+		{`course("NMAI054").day != Friday`, otherTuesday, true},
+		{`not(course("NMAI054").day = Friday)`, otherFriday, true},
+		{`not(course("NMAI054").day = Friday)`, fridaySeminar, false},
+		{`course("nmai054").day != Friday and day != Tuesday`, otherTuesday, false},
+		{`course("NMAI054").day != Friday and day != Tuesday`, otherFriday, true},
+		{`course("NMAI054").day = Monday or course("NPRG030").day = Friday`, otherFriday, true},
+		{`course("NMAI054").day = Monday or course("NPRG030").day = Friday`, otherTuesday, false},
+		{`course("NMAI054").type == "přednáška" starts_after 10:00`, mondayLecture, false},
+		{`course("NMAI054").type == "přednáška" starts_after 10:00`, fridaySeminar, true},
+		{`course("NMAI054").type == "přednáška" starts_after 10:00`, otherFriday, true},
+		{`course("NMAI054") starts_after 10:00`, otherFriday, true},
+		{`course = NPRG030`, otherFriday, true},
+	}
+	for _, tt := range tests {
+		r, err := parseRule(tt.rule)
+		if err != nil {
+			t.Errorf("parseRule(%q): %s", tt.rule, err)
+			continue
+		}
+		if got := r.Cond(tt.event); got != tt.want {
+			t.Errorf("%q for %s on day %d at %s: got %v, want %v", tt.rule, tt.event.CourseCode, tt.event.Day, tt.event.TimeFrom, got, tt.want)
+		}
+	}
+}
+
+func TestParseRuleErrors(t *testing.T) {
+	for _, s := range []string{
+		``,
+		`teacher = "unterminated`,
+		`teacher < "X"`,
+		`day = Someday`,
+		`type = exam`,
+		`time_from > 25:00`,
+		`room = S3`,
+		`(day = 0`,
+		`day = 0)`,
+		`day = 0 day = 1`,
+		`course(.day = 0`,
+		`day $ 0`,
+		`starts_after`,
+	} {
+		if _, err := parseRule(s); err == nil {
+			t.Errorf("parseRule(%q) succeeded", s)
+		}
+	}
+}
+
+func TestCheckRules(t *testing.T) {
+	option := []sisparse.Event{
+		ruleEvent("NMAI054", sisparse.Seminar, 0, 9, 11),
+		ruleEvent("NMAI054", sisparse.Seminar, 4, 15, 17),
+	}
+	parse := func(sources ...string) []rule {
+		res := []rule{}
+		for _, s := range sources {
+			r, err := parseRule(s)
+			if err != nil {
+				t.Fatalf("parseRule(%q): %s", s, err)
+			}
+			res = append(res, r)
+		}
+		return res
+	}
+
+	tests := []struct {
+		name       string
+		rules      []rule
+		ok         bool
+		penalty    int
+		brokenRule string
+	}{
+		{"none", nil, true, 0, ""},
+		{"hard kept", parse(`starts_after 8:00`), true, 0, ""},
+		{"hard broken", parse(`starts_after 8:00`, `day != Friday`), false, 0, `day != Friday`},
+		{"soft broken", parse(`prefer day != Friday`), true, 1, ""},
+		{"soft broken by both events once", parse(`prefer ends_before 10:00`), true, 1, ""},
+		{"soft rules counted apart", parse(`prefer day != Friday`, `prefer starts_after 10:00`, `PREFER day != Monday`), true, 3, ""},
+		{"hard wins over soft", parse(`prefer day != Friday`, `day != Monday`), false, 0, `day != Monday`},
+		{"other course", parse(`course("NPRG030").day != Friday`), true, 0, ""},
+	}
+	for _, tt := range tests {
+		ok, penalty, broken := checkRules(option, tt.rules)
+		if ok != tt.ok || penalty != tt.penalty || broken != tt.brokenRule {
+			t.Errorf("%s: got (%v, %d, %q), want (%v, %d, %q)", tt.name, ok, penalty, broken, tt.ok, tt.penalty, tt.brokenRule)
+		}
+	}
+	if r := parse(`prefer day = 0`)[0]; !r.Soft {
+		t.Errorf("prefer rule isn't soft")
+	}
+	if r := parse(`day = 0`)[0]; r.Soft {
+		t.Errorf("rule without prefer is soft")
+	}
+}
@@ -0,0 +1,251 @@
+// Saved schedules: a schedule is saved under a random token, by which it
+// can be looked at (and embedded in other websites) without logging in.
+// As the token is shared, deleting the schedule needs another one, given
+// only to whoever saved it.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// The largest saved schedule, in bytes
+const MAX_SAVED_SCHEDULE_SIZE = 1 << 20
+
+// The header with the owner token of a saved schedule, needed to delete it
+const SCHEDULE_OWNER_HEADER = "X-Schedule-Owner"
+
+var ErrNotScheduleOwner = errors.New("Only whoever saved the schedule can delete it")
+
+// Answers
+//   - POST /schedules/ with a schedule (as pngRequest) with
+//     {"data":{"token":"...","owner_token":"...",...}},
+//   - GET /schedules/<token> with {"data":<the schedule>},
+//   - GET /schedules/<token>.ics with its calendar feed (see feed.go),
+//   - POST /schedules/<token>/caldav by pushing it to a calendar (see caldav.go),
+//   - DELETE /schedules/<token> by deleting it, if the request has the owner
+//     token in SCHEDULE_OWNER_HEADER or comes from the user who saved it.
+func schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/schedules/")
+	switch {
+	case r.Method == "GET" && strings.HasSuffix(token, ".ics"):
+		calendarFeedHandler(w, r, strings.TrimSuffix(token, ".ics"))
+	case r.Method == "POST" && strings.HasSuffix(token, "/caldav"):
+		caldavHandler(w, r, strings.TrimSuffix(token, "/caldav"))
+	case r.Method == "GET" && token != "":
+		s, err := db.GetSchedule(token)
+		if err != nil {
+			writeScheduleError(w, err)
+			return
+		}
+		fmt.Fprintf(w, `{"data":%s}`, s.Data)
+	case r.Method == "DELETE" && token != "":
+		if err := deleteOwnedSchedule(token, r.Header.Get(SCHEDULE_OWNER_HEADER), requestUserHash(r)); err != nil {
+			writeScheduleError(w, err)
+			return
+		}
+		fmt.Fprint(w, `{"data":null}`)
+	case r.Method == "POST" && token == "":
+		saveSchedule(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Expected POST of /schedules/ or GET of /schedules/<token>"}`)
+	}
+}
+
+func saveSchedule(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAX_SAVED_SCHEDULE_SIZE))
+	r.Body.Close()
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	var req pngRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		fmt.Fprint(w, `{"error":"Expected {\"courses\":[...],\"result\":[...]}"}`)
+		return
+	}
+	if _, err := pngEvents(req); err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	// Saved as it was validated, without any fields we don't know
+	data, err := json.Marshal(req)
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	token, owner := hex.EncodeToString(b[:12]), hex.EncodeToString(b[12:])
+	schedule := store.Schedule{Token: token, Data: string(data), OwnerHash: hashToken(owner), UserHash: requestUserHash(r)}
+	if err := db.SaveSchedule(schedule); err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	// webcal:// makes browsers open the feed in a calendar app
+	fmt.Fprintf(w, `{"data":{"token":"%s","owner_token":"%s","calendar_url":"webcal://%s/schedules/%s.ics"}}`, token, owner, r.Host, token)
+}
+
+// Deletes the saved schedule if the owner token is its own or the user
+// (may be "") saved it, and returns ErrNotScheduleOwner if neither.
+func deleteOwnedSchedule(token, owner, user string) error {
+	s, err := db.GetSchedule(token)
+	if err != nil {
+		return err
+	}
+	ownerOk := owner != "" && s.OwnerHash != "" &&
+		subtle.ConstantTimeCompare([]byte(hashToken(owner)), []byte(s.OwnerHash)) == 1
+	if !ownerOk && (user == "" || user != s.UserHash) {
+		return ErrNotScheduleOwner
+	}
+	return db.DeleteSchedule(token)
+}
+
+func writeScheduleError(w http.ResponseWriter, err error) {
+	if err == store.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":"No such schedule"}`)
+		return
+	}
+	if err == ErrNotScheduleOwner {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, `{"error":"%s (send its owner token in %s)"}`, err, SCHEDULE_OWNER_HEADER)
+		return
+	}
+	fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+}
+
+// An event as placed in the grid of the widget, in percent of the grid
+type widgetEvent struct {
+	Label, Time, Room string
+	Color, TextColor  string
+	Left, Width       float64
+	Top, Height       float64
+	Parity            string // Empty for every week
+}
+
+type widgetPage struct {
+	Language string
+	Days     []string
+	Hours    []int
+	Events   [][]widgetEvent // By day
+}
+
+var widgetTemplate = template.Must(template.New("widget").Parse(`<!DOCTYPE html>
+<html lang="{{.Language}}"><head><meta charset="utf-8"><title>Rozvrh</title>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>
+body{margin:0;font:12px sans-serif;color:#222}
+.grid{display:flex;min-width:480px}
+.hours{width:40px;padding-top:20px}
+.hours div,.day .slot{height:48px;border-top:1px solid #ddd;box-sizing:border-box}
+.day{flex:1;border-left:1px solid #ddd}
+.day h2{height:20px;margin:0;font-size:12px;text-align:center;line-height:20px}
+.slots{position:relative}
+.ev{position:absolute;box-sizing:border-box;padding:2px 3px;overflow:hidden;border:1px solid #fff;border-radius:2px}
+.ev b{display:block;white-space:nowrap;overflow:hidden;text-overflow:ellipsis}
+</style></head><body><div class="grid">
+<div class="hours">{{range .Hours}}<div>{{.}}:00</div>{{end}}</div>
+{{$hours := .Hours}}{{range $i, $day := .Days}}<div class="day"><h2>{{$day}}</h2><div class="slots">
+{{range $hours}}<div class="slot"></div>{{end}}
+{{range index $.Events $i}}<div class="ev" style="left:{{.Left}}%;width:{{.Width}}%;top:{{.Top}}%;height:{{.Height}}%;background:{{.Color}};color:{{.TextColor}}"><b>{{.Label}}</b>{{.Time}}{{with .Parity}} ({{.}}){{end}}<br>{{.Room}}</div>
+{{end}}</div></div>{{end}}
+</div></body></html>
+`))
+
+// Answers /embed/<token> with a self-contained HTML page of the weekly grid
+// of the saved schedule, which other websites may show in an iframe.
+func embedHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.URL.Path, "/embed/")
+	s, err := db.GetSchedule(token)
+	if err != nil {
+		if err == store.ErrNotFound {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	var req pngRequest
+	if err := json.Unmarshal([]byte(s.Data), &req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	events, err := pngEvents(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Unlike the rest of the site, the widget is meant to be framed
+	w.Header().Del("X-Frame-Options")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := widgetTemplate.Execute(w, newWidgetPage(r.Context(), events)); err != nil {
+		logf(r.Context(), "Embed error: %s", err)
+	}
+}
+
+// Lays out the events as renderTimetable does.
+func newWidgetPage(ctx context.Context, events []pngEvent) widgetPage {
+	days, fromHour, toHour := 5, 8, 18
+	for _, e := range events {
+		if e.Day+1 > days {
+			days = e.Day + 1
+		}
+		if e.TimeFrom.Hour() < fromHour {
+			fromHour = e.TimeFrom.Hour()
+		}
+		if h := (e.TimeTo.Minutes() + 59) / 60; h > toHour {
+			toHour = h
+		}
+	}
+	page := widgetPage{Language: languageOf(ctx), Events: make([][]widgetEvent, days)}
+	for d := 0; d < days; d++ {
+		page.Days = append(page.Days, dayName(ctx, d))
+	}
+	for h := fromHour; h < toHour; h++ {
+		page.Hours = append(page.Hours, h)
+	}
+	total := float64((toHour - fromHour) * 60)
+	for _, e := range events {
+		we := widgetEvent{
+			Label:     e.Label,
+			Time:      e.TimeFrom.String() + "–" + e.TimeTo.String(),
+			Room:      e.Room,
+			Color:     fmt.Sprintf("#%02x%02x%02x", e.Color.R, e.Color.G, e.Color.B),
+			TextColor: "#222",
+			Left:      0,
+			Width:     100,
+			Top:       float64(e.TimeFrom.Minutes()-fromHour*60) / total * 100,
+			Height:    float64(e.TimeTo.Minutes()-e.TimeFrom.Minutes()) / total * 100,
+		}
+		if luminance(e.Color) < 128 {
+			we.TextColor = "#fff"
+		}
+		switch e.WeekParity {
+		case sisparse.OddWeeks:
+			we.Width = 50
+		case sisparse.EvenWeeks:
+			we.Left, we.Width = 50, 50
+		}
+		we.Parity = eventWeeksLabel(ctx, e.Event)
+		page.Events[e.Day] = append(page.Events[e.Day], we)
+	}
+	return page
+}
@@ -0,0 +1,441 @@
+// Full-text search over the cached courses, so that users can find courses
+// by name, teacher or the notes of their events without us having to ask
+// SIS. Sources which can search themselves are asked about courses we
+// haven't cached. The index is kept in memory and brought up to date with
+// the store every -search-reindex, so that courses cached by the crawler
+// and the other instances are found too.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/source"
+	"github.com/iamwave/samorozvrh/store"
+	"golang.org/x/text/unicode/norm"
+)
+
+// The largest number of courses returned by a search
+const MAX_SEARCH_RESULTS = 50
+
+// How often the index is brought up to date with the store by default
+const DEFAULT_SEARCH_REINDEX_INTERVAL = 10 * time.Minute
+
+type courseSummary struct {
+	Code       string   `json:"code"`
+	Name       string   `json:"name"`
+	Teachers   []string `json:"teachers"`
+	TeacherIds []string `json:"teacher_ids"`
+}
+
+type searchIndex struct {
+	mu      sync.RWMutex
+	courses map[string]courseSummary
+	tokens  map[string]map[string]bool // token -> codes of courses containing it
+	// The keys of tokens in order, so that the tokens a word is a prefix
+	// of are next to each other
+	sortedTokens []string
+	// The tokens of each course, so that it can be removed
+	courseTokens map[string][]string
+	// The timetables of the courses (see groupsKey), and the courses by
+	// them, to tell cross-listed ones (see aliases.go)
+	groupKeys map[string]string
+	byGroups  map[string]map[string]bool
+}
+
+var courseIndex = newSearchIndex()
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		courses:      map[string]courseSummary{},
+		tokens:       map[string]map[string]bool{},
+		courseTokens: map[string][]string{},
+		groupKeys:    map[string]string{},
+		byGroups:     map[string]map[string]bool{},
+	}
+}
+
+// Adds a course to the index (replacing its previous version, if any).
+func (idx *searchIndex) add(code string, groups [][]sisparse.Event) {
+	summary := courseSummary{Code: code, Teachers: []string{}, TeacherIds: []string{}}
+	seenTeachers := map[string]bool{}
+	notes := []string{}
+	for _, group := range groups {
+		for _, e := range group {
+			if summary.Name == "" {
+				summary.Name = e.Name
+			}
+			if e.Note != "" {
+				notes = append(notes, e.Note)
+			}
+			if e.Teacher != "" && !seenTeachers[e.Teacher] {
+				seenTeachers[e.Teacher] = true
+				summary.Teachers = append(summary.Teachers, e.Teacher)
+			}
+			if e.TeacherID != "" && !seenTeachers["#"+e.TeacherID] {
+				seenTeachers["#"+e.TeacherID] = true
+				summary.TeacherIds = append(summary.TeacherIds, e.TeacherID)
+			}
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(code)
+	idx.courses[code] = summary
+	text := code + " " + summary.Name + " " + strings.Join(summary.Teachers, " ") + " " + strings.Join(notes, " ")
+	for _, t := range tokenize(text) {
+		if idx.tokens[t] == nil {
+			idx.tokens[t] = map[string]bool{}
+			i := sort.SearchStrings(idx.sortedTokens, t)
+			idx.sortedTokens = append(idx.sortedTokens, "")
+			copy(idx.sortedTokens[i+1:], idx.sortedTokens[i:])
+			idx.sortedTokens[i] = t
+		}
+		if !idx.tokens[t][code] {
+			idx.tokens[t][code] = true
+			idx.courseTokens[code] = append(idx.courseTokens[code], t)
+		}
+	}
+	if key := groupsKey(groups); key != "" {
+		idx.groupKeys[code] = key
+		if idx.byGroups[key] == nil {
+			idx.byGroups[key] = map[string]bool{}
+		}
+		idx.byGroups[key][code] = true
+	}
+}
+
+// Drops a course from the index.
+func (idx *searchIndex) remove(code string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.removeLocked(code)
+}
+
+func (idx *searchIndex) removeLocked(code string) {
+	if _, ok := idx.courses[code]; !ok {
+		return
+	}
+	delete(idx.courses, code)
+	if key, ok := idx.groupKeys[code]; ok {
+		delete(idx.groupKeys, code)
+		delete(idx.byGroups[key], code)
+		if len(idx.byGroups[key]) == 0 {
+			delete(idx.byGroups, key)
+		}
+	}
+	for _, t := range idx.courseTokens[code] {
+		delete(idx.tokens[t], code)
+		if len(idx.tokens[t]) == 0 {
+			delete(idx.tokens, t)
+			i := sort.SearchStrings(idx.sortedTokens, t)
+			idx.sortedTokens = append(idx.sortedTokens[:i], idx.sortedTokens[i+1:]...)
+		}
+	}
+	delete(idx.courseTokens, code)
+}
+
+// Returns the first MAX_SEARCH_RESULTS courses matching the query by their
+// codes, see searchAll.
+func (idx *searchIndex) search(query string) []courseSummary {
+	res := idx.searchAll(query)
+	if len(res) > MAX_SEARCH_RESULTS {
+		res = res[:MAX_SEARCH_RESULTS]
+	}
+	return res
+}
+
+// Returns the courses matching all words of the query; a word matches
+// any indexed word it is a prefix of. Diacritics and case are ignored.
+func (idx *searchIndex) searchAll(query string) []courseSummary {
+	words := tokenize(query)
+	if len(words) == 0 {
+		return []courseSummary{}
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	var matching map[string]bool
+	for _, w := range words {
+		codes := map[string]bool{}
+		for i := sort.SearchStrings(idx.sortedTokens, w); i < len(idx.sortedTokens) && strings.HasPrefix(idx.sortedTokens[i], w); i++ {
+			for c := range idx.tokens[idx.sortedTokens[i]] {
+				codes[c] = true
+			}
+		}
+		if matching == nil {
+			matching = codes
+		} else {
+			for c := range matching {
+				if !codes[c] {
+					delete(matching, c)
+				}
+			}
+		}
+	}
+
+	res := []courseSummary{}
+	for c := range matching {
+		res = append(res, idx.courses[c])
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Code < res[j].Code
+	})
+	return res
+}
+
+// Returns all the indexed courses by their codes.
+func (idx *searchIndex) list() []courseSummary {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	res := make([]courseSummary, 0, len(idx.courses))
+	for _, c := range idx.courses {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Code < res[j].Code
+	})
+	return res
+}
+
+// The sorts of pages of courses (see parsePageRequest)
+var courseSorts = []string{"code", "name"}
+
+// Returns the page of the courses, which are sorted by their codes.
+func pageCourses(courses []courseSummary, p pageRequest) ([]courseSummary, string) {
+	var keys []string
+	if p.Sort == "name" {
+		keys = sortByKeys(len(courses), func(i int) string {
+			return strings.Join(tokenize(courses[i].Name), " ") + "\x00" + courses[i].Code
+		}, func(i, j int) {
+			courses[i], courses[j] = courses[j], courses[i]
+		})
+	} else {
+		keys = make([]string, len(courses))
+		for i, c := range courses {
+			keys[i] = c.Code
+		}
+	}
+	from, to, next := p.page(keys)
+	return courses[from:to], next
+}
+
+// Returns the name of the course, "" if it isn't indexed.
+func (idx *searchIndex) courseName(code string) string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.courses[code].Name
+}
+
+// Returns the codes of the courses taught by the teacher with the given SIS ID.
+func (idx *searchIndex) coursesOfTeacher(id string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	res := []string{}
+	for code, summary := range idx.courses {
+		for _, t := range summary.TeacherIds {
+			if t == id {
+				res = append(res, code)
+				break
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Splits text into lowercase words without diacritics.
+func tokenize(text string) []string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(text) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return strings.FieldsFunc(b.String(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Indexes all courses of the current academic year in the cache, those of
+// tenants in their own indexes, and drops the courses which aren't cached
+// anymore. The entries are read from the store rather than through the
+// memory cache, so that what other instances stored is indexed too.
+func buildSearchIndex() error {
+	names, err := listCache()
+	if err != nil {
+		return err
+	}
+	semester := currentTerm(context.Background()).Semester
+	indexed := map[*searchIndex]map[string]bool{courseIndex: {}}
+	for _, t := range tenants {
+		indexed[t.index] = map[string]bool{}
+	}
+	for _, name := range names {
+		if !isCourseCacheName(name) || strings.Contains(name, "~") {
+			continue
+		}
+		index, courseName := courseIndex, name
+		if i := strings.Index(name, "/"); i >= 0 {
+			t := getTenant(name[:i])
+			if t == nil {
+				continue
+			}
+			index, courseName = t.index, name[i+1:]
+		}
+		code, sem := splitCourseCacheName(courseName)
+		if indexed[index][code] && sem != semester {
+			// Courses of both semesters are found by the current one
+			continue
+		}
+		e, err := db.GetCache(getCacheKey(name))
+		if err == store.ErrNotFound {
+			// Dropped since it was listed
+			continue
+		} else if err != nil {
+			return err
+		}
+		var cached struct {
+			Data [][]sisparse.Event `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(e.Value), &cached); err != nil {
+			log.Printf("Search index: skipping %s: %s", name, err)
+			continue
+		}
+		index.add(code, cached.Data)
+		indexed[index][code] = true
+	}
+	for index, codes := range indexed {
+		for _, c := range index.list() {
+			if !codes[c.Code] {
+				index.remove(c.Code)
+			}
+		}
+	}
+	return nil
+}
+
+// Rebuilds the search index every interval until ctx is done.
+func reindexSearch(ctx context.Context, interval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if err := buildSearchIndex(); err != nil {
+			log.Printf("Could not rebuild the search index: %s", err)
+		}
+	}
+}
+
+// Answers /search?q=... with a page of the matching courses (see
+// paging.go), sorted by code or name.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	p, err := parsePageRequest(r, courseSorts...)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	log.Printf("Search: %s", ellipsis(query, 30))
+	summaries, next := pageCourses(tenantOf(r.Context()).index.searchAll(query), p)
+	var results interface{} = summaries
+	if len(summaries) == 0 && p.After == "" {
+		// We don't know the course yet, the source may
+		found, err := courseSource.SearchCourses(r.Context(), query)
+		if len(found) > p.Limit {
+			found = found[:p.Limit]
+		}
+		if err == nil && len(found) > 0 {
+			results = found
+		} else if err != nil && err != source.ErrNotSupported {
+			log.Printf("Search error: %s", err)
+		}
+	}
+	res, err := pageJSON(results, next)
+	if err != nil {
+		log.Printf("Search error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	fmt.Fprint(w, res)
+}
+
+// Answers GET /api/v1/courses with a page of all the cached courses (see
+// paging.go), sorted by code or name.
+func coursesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use GET"}`)
+		return
+	}
+	p, err := parsePageRequest(r, courseSorts...)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	res, err := pageJSON(pageCourses(tenantOf(r.Context()).index.list(), p))
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	writeConditional(w, r, res, contentETag(res), time.Time{})
+}
+
+// Answers /teacher/<SIS ID> with the events the teacher teaches in the
+// cached courses, i.e. their schedule as far as we know it.
+func teacherHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/teacher/")
+	if id == "" || strings.Contains(id, "/") {
+		fmt.Fprint(w, `{"error":"Expected /teacher/<SIS ID of the teacher>"}`)
+		return
+	}
+	log.Printf("Teacher: %s", ellipsis(id, 20))
+	s, err := json.Marshal(teacherEvents(r.Context(), id))
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	res := fmt.Sprintf(`{"data":%s}`, string(s))
+	writeConditional(w, r, res, contentETag(res), time.Time{})
+}
+
+// Returns the events taught by the teacher with the given SIS ID in the
+// cached courses.
+func teacherEvents(ctx context.Context, id string) []sisparse.Event {
+	events := []sisparse.Event{}
+	for _, code := range tenantOf(ctx).index.coursesOfTeacher(id) {
+		for _, sem := range []int{sisparse.Winter, sisparse.Summer} {
+			res, err := getCache(tenantCacheName(ctx, getCourseCacheName(code, sem)))
+			if err != nil {
+				continue
+			}
+			var cached struct {
+				Data [][]sisparse.Event `json:"data"`
+			}
+			if err := json.Unmarshal([]byte(res), &cached); err != nil {
+				continue
+			}
+			for _, group := range cached.Data {
+				for _, e := range group {
+					if e.TeacherID == id {
+						events = append(events, e)
+					}
+				}
+			}
+		}
+	}
+	return events
+}
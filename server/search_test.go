@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+func searchCourse(code, name string, teachers ...string) [][]sisparse.Event {
+	group := []sisparse.Event{}
+	for i, t := range teachers {
+		group = append(group, sisparse.Event{
+			CourseCode: code,
+			Name:       name,
+			Teacher:    t,
+			TeacherID:  fmt.Sprintf("%s-%d", code, i),
+			Type:       sisparse.Lecture,
+			Day:        i,
+			TimeFrom:   sisparse.NewClockTime(9, 0),
+			TimeTo:     sisparse.NewClockTime(10, 30),
+		})
+	}
+	return [][]sisparse.Event{group}
+}
+
+func searchCodes(res []courseSummary) []string {
+	codes := []string{}
+	for _, c := range res {
+		codes = append(codes, c.Code)
+	}
+	return codes
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"", []string{}},
+		{"Programování I", []string{"programovani", "i"}},
+		{"  Jiří Fiala, RNDr.  ", []string{"jiri", "fiala", "rndr"}},
+		{"NPRG030 (ZS 2024/25)", []string{"nprg030", "zs", "2024", "25"}},
+	}
+	for _, tt := range tests {
+		if got := tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q): got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	idx := newSearchIndex()
+	idx.add("NPRG030", searchCourse("NPRG030", "Programování I", "Martin Mareš", "Tomáš Holan"))
+	idx.add("NPRG031", searchCourse("NPRG031", "Programování II", "Tomáš Holan"))
+	idx.add("NMAI054", searchCourse("NMAI054", "Matematická analýza I", "Martin Klazar"))
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{}},
+		{"programovani", []string{"NPRG030", "NPRG031"}},
+		{"PROGRAM", []string{"NPRG030", "NPRG031"}},
+		{"programování ii", []string{"NPRG031"}},
+		{"nprg03", []string{"NPRG030", "NPRG031"}},
+		{"martin", []string{"NMAI054", "NPRG030"}},
+		{"martin holan", []string{"NPRG030"}},
+		{"mares", []string{"NPRG030"}},
+		{"analyza programovani", []string{}},
+		{"fyzika", []string{}},
+	}
+	for _, tt := range tests {
+		if got := searchCodes(idx.search(tt.query)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search(%q): got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	want := courseSummary{
+		Code:       "NPRG030",
+		Name:       "Programování I",
+		Teachers:   []string{"Martin Mareš", "Tomáš Holan"},
+		TeacherIds: []string{"NPRG030-0", "NPRG030-1"},
+	}
+	if got := idx.search("mares"); len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Errorf("search(mares): got %+v, want %+v", got, want)
+	}
+	if got := idx.courseName("NMAI054"); got != "Matematická analýza I" {
+		t.Errorf("courseName(NMAI054): got %q", got)
+	}
+}
+
+func TestSearchIndexReplacesCourses(t *testing.T) {
+	idx := newSearchIndex()
+	idx.add("NPRG030", searchCourse("NPRG030", "Programování I", "Martin Mareš"))
+	idx.add("NPRG030", searchCourse("NPRG030", "Programování I", "Tomáš Holan"))
+	if got := idx.search("mares"); len(got) != 0 {
+		t.Errorf("The previous teacher is still found: %+v", got)
+	}
+	if got := searchCodes(idx.search("holan")); !reflect.DeepEqual(got, []string{"NPRG030"}) {
+		t.Errorf("search(holan): got %q", got)
+	}
+	if got := searchCodes(idx.list()); !reflect.DeepEqual(got, []string{"NPRG030"}) {
+		t.Errorf("list(): got %q", got)
+	}
+	// The tokens of nobody's courses are dropped
+	for _, tok := range idx.sortedTokens {
+		if tok == "mares" || tok == "martin" {
+			t.Errorf("Token %q of no course is kept", tok)
+		}
+	}
+}
+
+func TestSearchIndexLimitsResults(t *testing.T) {
+	idx := newSearchIndex()
+	for i := 0; i < MAX_SEARCH_RESULTS+10; i++ {
+		code := fmt.Sprintf("NSWI%03d", i)
+		idx.add(code, searchCourse(code, "Seminář", "Jan Novák"))
+	}
+	if n := len(idx.search("seminar")); n != MAX_SEARCH_RESULTS {
+		t.Errorf("search returned %d courses, want %d", n, MAX_SEARCH_RESULTS)
+	}
+	if n := len(idx.searchAll("seminar")); n != MAX_SEARCH_RESULTS+10 {
+		t.Errorf("searchAll returned %d courses, want %d", n, MAX_SEARCH_RESULTS+10)
+	}
+}
+
+func TestCoursesOfTeacher(t *testing.T) {
+	idx := newSearchIndex()
+	idx.add("NPRG030", [][]sisparse.Event{{{Name: "Programování I", Teacher: "Tomáš Holan", TeacherID: "123"}}})
+	idx.add("NPRG031", [][]sisparse.Event{{{Name: "Programování II", Teacher: "Tomáš Holan", TeacherID: "123"}}})
+	idx.add("NMAI054", [][]sisparse.Event{{{Name: "Matematická analýza I", Teacher: "Martin Klazar", TeacherID: "456"}}})
+	if got := idx.coursesOfTeacher("123"); !reflect.DeepEqual(got, []string{"NPRG030", "NPRG031"}) {
+		t.Errorf("coursesOfTeacher(123): got %q", got)
+	}
+	if got := idx.coursesOfTeacher("789"); len(got) != 0 {
+		t.Errorf("coursesOfTeacher(789): got %q", got)
+	}
+}
+
+func TestSearchIndexNotes(t *testing.T) {
+	idx := newSearchIndex()
+	groups := searchCourse("NPRG030", "Programování I", "Tomáš Holan")
+	groups[0][0].Note = "Výuka probíhá v angličtině"
+	idx.add("NPRG030", groups)
+	if got := searchCodes(idx.search("anglictine")); !reflect.DeepEqual(got, []string{"NPRG030"}) {
+		t.Errorf("search(anglictine): got %q", got)
+	}
+}
+
+func TestBuildSearchIndex(t *testing.T) {
+	defer func(old store.Store, oldIndex *searchIndex) { db, courseIndex = old, oldIndex }(db, courseIndex)
+	s := &mapCacheStore{entries: map[string]store.CacheEntry{}}
+	db = s
+	courseIndex = newSearchIndex()
+	cache := func(name string, groups [][]sisparse.Event) {
+		data, err := json.Marshal(groups)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.SetCache(getCacheKey(name), fmt.Sprintf(`{"data":%s}`, data))
+	}
+	cache("NPRG030", searchCourse("NPRG030", "Programování I", "Tomáš Holan"))
+	cache("NPRG031@2", searchCourse("NPRG031", "Programování II", "Tomáš Holan"))
+	cache("NMAI054~2023", searchCourse("NMAI054", "Matematická analýza I", "Martin Klazar"))
+	s.SetCache(NEGATIVE_CACHE_PREFIX+getCacheKey("NXXX001"), "")
+	// Dropped from the cache since it was indexed
+	courseIndex.add("NTIN061", searchCourse("NTIN061", "Algoritmy a datové struktury II", "Martin Mareš"))
+
+	if err := buildSearchIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchCodes(courseIndex.list()); !reflect.DeepEqual(got, []string{"NPRG030", "NPRG031"}) {
+		t.Errorf("Indexed %q, want the courses of the current year", got)
+	}
+
+	// Courses cached meanwhile (e.g. by the crawler) are found after the
+	// next build
+	cache("NMAI054", searchCourse("NMAI054", "Matematická analýza I", "Martin Klazar"))
+	if err := buildSearchIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchCodes(courseIndex.search("klazar")); !reflect.DeepEqual(got, []string{"NMAI054"}) {
+		t.Errorf("search(klazar): got %q", got)
+	}
+}
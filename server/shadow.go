@@ -0,0 +1,118 @@
+// Comparing a candidate parser of schedule pages with ours on live traffic
+// (-parser-shadow): every page fetched from SIS is parsed by both, the
+// differences are logged and counted, and /admin/shadow shows how often
+// the candidate diverges, so that it can replace ours once it doesn't.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// How many of the latest divergences /admin/shadow shows
+const SHADOW_RECENT = 50
+
+type shadowStats struct {
+	Candidate string    `json:"candidate"`
+	Since     time.Time `json:"since"`
+	// Pages parsed by both parsers
+	Pages int `json:"pages"`
+	// Pages the parsers made different groups of, or which only one of
+	// them failed on
+	Diverged     int `json:"diverged"`
+	OursFailed   int `json:"ours_failed"`
+	TheirsFailed int `json:"theirs_failed"`
+	// The fraction of the pages which diverged
+	DivergenceRate float64 `json:"divergence_rate"`
+	// The average time of parsing a page, in milliseconds
+	OursMs   float64            `json:"ours_ms"`
+	TheirsMs float64            `json:"theirs_ms"`
+	Recent   []shadowDivergence `json:"recent"`
+}
+
+type shadowDivergence struct {
+	Url          string    `json:"url"`
+	Time         time.Time `json:"time"`
+	Differences  []string  `json:"differences"`
+	Err          string    `json:"error,omitempty"`
+	CandidateErr string    `json:"candidate_error,omitempty"`
+}
+
+var shadowMu sync.Mutex
+var shadow shadowStats
+var shadowOursTime, shadowTheirsTime time.Duration
+
+// Returns the option comparing the parser of the name with ours.
+func shadowOption(name string) (sisparse.Option, error) {
+	parse, ok := sisparse.ShadowParser(name)
+	if !ok {
+		return nil, fmt.Errorf("Unknown parser %s, expected one of %s", name, strings.Join(sisparse.ShadowParserNames(), ", "))
+	}
+	shadow = shadowStats{Candidate: name, Since: time.Now().UTC(), Recent: []shadowDivergence{}}
+	return sisparse.Shadow(name, parse, recordShadow), nil
+}
+
+func recordShadow(res sisparse.ShadowResult) {
+	shadowMu.Lock()
+	defer shadowMu.Unlock()
+	shadow.Pages++
+	shadowOursTime += res.Duration
+	shadowTheirsTime += res.CandidateDuration
+	if res.Err != "" && res.CandidateErr == "" {
+		shadow.OursFailed++
+	} else if res.Err == "" && res.CandidateErr != "" {
+		shadow.TheirsFailed++
+	}
+	if !res.Diverged() {
+		return
+	}
+	shadow.Diverged++
+	if len(res.Differences) > 0 {
+		log.Printf("Parser %s diverged on %s: %s", res.Candidate, res.Url, strings.Join(res.Differences, "; "))
+	} else {
+		log.Printf("Parser %s diverged on %s: error %q instead of %q", res.Candidate, res.Url, res.CandidateErr, res.Err)
+	}
+	d := shadowDivergence{Url: res.Url, Time: time.Now().UTC(), Differences: res.Differences,
+		Err: res.Err, CandidateErr: res.CandidateErr}
+	shadow.Recent = append([]shadowDivergence{d}, shadow.Recent...)
+	if len(shadow.Recent) > SHADOW_RECENT {
+		shadow.Recent = shadow.Recent[:SHADOW_RECENT]
+	}
+}
+
+func getShadowStats() shadowStats {
+	shadowMu.Lock()
+	defer shadowMu.Unlock()
+	s := shadow
+	if s.Pages > 0 {
+		s.DivergenceRate = float64(s.Diverged) / float64(s.Pages)
+		s.OursMs = float64(shadowOursTime) / float64(time.Millisecond) / float64(s.Pages)
+		s.TheirsMs = float64(shadowTheirsTime) / float64(time.Millisecond) / float64(s.Pages)
+	}
+	s.Recent = append([]shadowDivergence{}, s.Recent...)
+	return s
+}
+
+// Answers /admin/shadow with {"data":shadowStats}, or with an error if no
+// -parser-shadow was given.
+func shadowHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use GET"}`)
+		return
+	}
+	s := getShadowStats()
+	if s.Candidate == "" {
+		fmt.Fprint(w, `{"error":"No parser is compared, see -parser-shadow"}`)
+		return
+	}
+	res, _ := json.Marshal(s)
+	fmt.Fprintf(w, `{"data":%s}`, string(res))
+}
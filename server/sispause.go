@@ -0,0 +1,126 @@
+// Pausing all requests to SIS, e.g. when the university asks the operator
+// to stop scraping or during SIS maintenance. While SIS is paused, cached
+// courses are served (however old) and the others fail at once. The pause
+// is kept in the database, so that it holds for all the instances.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/store"
+)
+
+// The cache key of the pause, apart from the cached answers (see
+// getCacheKey)
+const SIS_PAUSE_KEY = SETTINGS_PREFIX + "sis-pause"
+
+// How often the instances look for a pause set by another instance
+const SIS_PAUSE_CHECK_INTERVAL = 10 * time.Second
+
+type sisPause struct {
+	Paused bool `json:"paused"`
+	// Why, for the other operators
+	Reason string     `json:"reason,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+	// Paused by -sis-paused of this instance, which /admin/sis can't undo
+	Configured bool `json:"configured,omitempty"`
+}
+
+var sisPauseMu sync.Mutex
+
+// Whether -sis-paused was given
+var sisPausedByConfig bool
+
+// Reads the pause from the database and applies it.
+func loadSisPause() (sisPause, error) {
+	var p sisPause
+	e, err := db.GetCache(SIS_PAUSE_KEY)
+	if err != nil && err != store.ErrNotFound {
+		return p, err
+	}
+	if err == nil {
+		if err := json.Unmarshal([]byte(e.Value), &p); err != nil {
+			return p, err
+		}
+	}
+	sisPauseMu.Lock()
+	defer sisPauseMu.Unlock()
+	if sisPausedByConfig {
+		p.Paused, p.Configured = true, true
+	}
+	sisparse.SetPaused(p.Paused)
+	return p, nil
+}
+
+func saveSisPause(p sisPause) error {
+	s, _ := json.Marshal(p)
+	if err := db.SetCache(SIS_PAUSE_KEY, string(s)); err != nil {
+		return err
+	}
+	sisPauseMu.Lock()
+	defer sisPauseMu.Unlock()
+	sisparse.SetPaused(p.Paused || sisPausedByConfig)
+	return nil
+}
+
+// Applies the pauses set by the other instances until ctx is done.
+func watchSisPause(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(SIS_PAUSE_CHECK_INTERVAL):
+		}
+		before := sisparse.IsPaused()
+		p, err := loadSisPause()
+		if err != nil {
+			log.Printf("Could not check whether SIS is paused: %s", err)
+		} else if p.Paused != before {
+			log.Printf("Requests to SIS paused: %t (%s)", p.Paused, p.Reason)
+		}
+	}
+}
+
+// Answers /admin/sis: GET returns the pause, POST with {"paused":true,
+// "reason":"..."} pauses (or with false resumes) the requests to SIS of all
+// the instances.
+func sisPauseHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+	case "POST":
+		var req sisPause
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			fmt.Fprint(w, `{"error":"Expected {\"paused\":true|false,\"reason\":\"...\"}"}`)
+			return
+		}
+		p := sisPause{Paused: req.Paused}
+		if req.Paused {
+			now := time.Now()
+			p.Reason, p.Since = req.Reason, &now
+		}
+		if err := saveSisPause(p); err != nil {
+			fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+			return
+		}
+		log.Printf("Requests to SIS paused: %t (%s)", p.Paused, p.Reason)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Expected GET or POST"}`)
+		return
+	}
+	p, err := loadSisPause()
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	s, _ := json.Marshal(p)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
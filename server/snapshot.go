@@ -0,0 +1,147 @@
+// Raw HTML of the SIS pages we fetched, kept so that parse bugs reported
+// by users can be reproduced even after SIS changes the page.
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// How many snapshots to keep per course
+const MAX_SNAPSHOTS = 10
+
+type snapshot struct {
+	Name string    `json:"name"`
+	Url  string    `json:"url"`
+	Time time.Time `json:"time"`
+}
+
+// Saves a gzipped snapshot of a page. The URL and the time of the fetch
+// are stored in the gzip header.
+func saveSnapshot(courseCode string, url string, body []byte) {
+	if !sisparse.IsCourseCode(courseCode) {
+		return
+	}
+	dir := getSnapshotDir(courseCode)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Snapshot error: %s", err)
+		return
+	}
+
+	now := time.Now()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Comment = url
+	zw.ModTime = now
+	if _, err := zw.Write(body); err != nil {
+		log.Printf("Snapshot error: %s", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Snapshot error: %s", err)
+		return
+	}
+	filename := path.Join(dir, fmt.Sprintf("%d.html.gz", now.UnixNano()))
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		log.Printf("Snapshot error: %s", err)
+		return
+	}
+	pruneSnapshots(courseCode)
+}
+
+// Returns the snapshots of a course, the most recent first.
+func listSnapshots(courseCode string) ([]snapshot, error) {
+	if !sisparse.IsCourseCode(courseCode) {
+		return nil, fmt.Errorf("Invalid course code")
+	}
+	files, err := ioutil.ReadDir(getSnapshotDir(courseCode))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []snapshot{}, nil
+		}
+		return nil, err
+	}
+	res := []snapshot{}
+	for _, f := range files {
+		s, _, err := readSnapshot(courseCode, f.Name())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Time.After(res[j].Time)
+	})
+	return res, nil
+}
+
+// Returns the metadata and the decompressed content of a snapshot.
+func readSnapshot(courseCode string, name string) (snapshot, []byte, error) {
+	if !sisparse.IsCourseCode(courseCode) || strings.Contains(name, "/") {
+		return snapshot{}, nil, fmt.Errorf("Invalid snapshot name")
+	}
+	f, err := os.Open(path.Join(getSnapshotDir(courseCode), name))
+	if err != nil {
+		return snapshot{}, nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return snapshot{}, nil, err
+	}
+	body, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return snapshot{}, nil, err
+	}
+	return snapshot{Name: name, Url: zr.Comment, Time: zr.ModTime}, body, nil
+}
+
+func pruneSnapshots(courseCode string) {
+	snapshots, err := listSnapshots(courseCode)
+	if err != nil {
+		log.Printf("Snapshot error: %s", err)
+		return
+	}
+	for i := MAX_SNAPSHOTS; i < len(snapshots); i++ {
+		os.Remove(path.Join(getSnapshotDir(courseCode), snapshots[i].Name))
+	}
+}
+
+// The code must be checked by sisparse.IsCourseCode, so that it is a single
+// part of the path.
+func getSnapshotDir(courseCode string) string {
+	return path.Join(rootDir, "snapshots", courseCode)
+}
+
+// Writes all snapshots of a course to w, the most recent first.
+func dumpSnapshots(w io.Writer, courseCode string) error {
+	snapshots, err := listSnapshots(courseCode)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("No snapshots of %s", courseCode)
+	}
+	for _, s := range snapshots {
+		_, body, err := readSnapshot(courseCode, s.Name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "<!-- %s fetched at %s -->\n", s.Url, s.Time.Format(time.RFC3339))
+		w.Write(body)
+		fmt.Fprintln(w)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotCourseCodes(t *testing.T) {
+	defer func(old string) { rootDir = old }(rootDir)
+	rootDir = t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(rootDir, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	saveSnapshot("NPRG030", "https://is.cuni.cz/", []byte("<html></html>"))
+	if snapshots, err := listSnapshots("NPRG030"); err != nil || len(snapshots) != 1 {
+		t.Errorf("Snapshots of NPRG030: %v, %v, want one", snapshots, err)
+	}
+	// Codes which would lead out of the directory of snapshots
+	for _, code := range []string{"..", ".", "", "../..", "NPRG030/.."} {
+		saveSnapshot(code, "https://is.cuni.cz/", []byte("<html></html>"))
+		if _, err := listSnapshots(code); err == nil {
+			t.Errorf("listSnapshots(%q) lists the snapshots", code)
+		}
+		if _, _, err := readSnapshot(code, "secret"); err == nil {
+			t.Errorf("readSnapshot(%q, secret) reads the file", code)
+		}
+	}
+	if files, _ := ioutil.ReadDir(rootDir); len(files) != 2 {
+		t.Errorf("%d files in the root directory, want only secret and snapshots", len(files))
+	}
+}
@@ -1,32 +1,219 @@
 // An interface to the Python solver.
-package main
+package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
 )
 
 const SOLVER_COMMAND = "python3 -m pipenv run python solver/main.py"
 
-func Solve(query []byte) ([]byte, error) {
+// The outcome of the recent solver runs, for health checks
+type solverStatus struct {
+	LastSuccess time.Time `json:"last_success"`
+	LastFailure time.Time `json:"last_failure"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+var solverStatusMu sync.Mutex
+var lastSolverStatus solverStatus
+
+func getSolverStatus() solverStatus {
+	solverStatusMu.Lock()
+	defer solverStatusMu.Unlock()
+	return lastSolverStatus
+}
+
+// How many megabytes a solver run may take (0 for no limit) and how many
+// partial schedules it keeps when it has to search by a beam search
+// instead, set by -solver-memory and -solver-beam-width
+var solverMemoryLimit = 0
+var solverBeamWidth = 0
+
+// The size of the slots in which the solver compares the times of options
+// first (0 compares all events), set by -solver-slot-minutes
+var solverSlotMinutes = 0
+
+// Canceled when the server is shutting down and can't wait
+// for the running solvers any longer
+var solverContext, cancelSolvers = context.WithCancel(context.Background())
+
+// Settings of a solver run, nil means the solver's default.
+type solverOptions struct {
+	// With a seed, the solver makes the same choices as in other runs with
+	// it; otherwise it picks a seed at random. Either way, the seed is
+	// returned in "seed" of the answer.
+	Seed *int64
+	// How much courses prefer to keep their "previous_option"
+	Stability *int
+	// Constraints on the "credits" of the selected courses
+	MinCredits         *int
+	MaxCreditImbalance *int
+	// The penalty for each hour between the busiest and the lightest weekday
+	Balance *int
+	// How many minutes per week events of the given types (or marked
+	// "skippable" in the query) may overlap with other events
+	OverlapBudget  *int
+	SkippableTypes []string
+	// Courses of at least the workload are heavy: each two of their events
+	// back to back are penalized by BackToBack, and they may only take
+	// MaxHeavyHours of a day
+	HeavyWorkload *int
+	BackToBack    *int
+	MaxHeavyHours *int
+	// How long the way to school takes, and the penalty for each hour of
+	// leaving home before LeaveAfter or getting home after HomeBy (given
+	// as "07:30")
+	CommuteMinutes *int
+	LeaveAfter     string
+	HomeBy         string
+	CommutePenalty *int
+	// Blocks to keep free each week, as solveReserve.solverArg gives them
+	Reserve []string
+	// How long the solver may run, 0 for as long as it needs
+	Timeout time.Duration
+	// "exact" or "beam" to force the search strategy of the solver, which
+	// otherwise chooses by solverMemoryLimit
+	Strategy string
+	// If set, the solver only scores this selection (as JSON, e.g.
+	// [0,null,1]) instead of finding one
+	Evaluate []byte
+}
+
+func Solve(ctx context.Context, query []byte, opts solverOptions) ([]byte, error) {
+	ctx, span := tracer.Start(ctx, "solver.Solve")
+	defer span.End()
+	if err := solveQuota.use(ctx, 0); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	res, err := runSolver(ctx, query, opts)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && solverMemoryLimit > 0 && opts.Strategy == "" && opts.Evaluate == nil {
+		// Most likely out of memory where the solver couldn't catch it
+		logf(ctx, "The solver failed (%s), retrying with a beam search", err)
+		opts.Strategy = "beam"
+		res, err = runSolver(ctx, query, opts)
+	}
+	countSolveTime(time.Since(start))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	solverStatusMu.Lock()
+	if err != nil {
+		lastSolverStatus.LastFailure = time.Now()
+		lastSolverStatus.LastError = err.Error()
+	} else {
+		lastSolverStatus.LastSuccess = time.Now()
+	}
+	solverStatusMu.Unlock()
+	return res, err
+}
+
+func runSolver(ctx context.Context, query []byte, opts solverOptions) ([]byte, error) {
 	// Create a temporary file with the query, feed it to the solver
 	// and run it
 
+	_, prepareSpan := tracer.Start(ctx, "solver.prepare")
+	query = resolveQueryWeekParities(ctx, query)
 	tempfile, err := createQueryFile(query)
+	prepareSpan.End()
 	if err != nil {
 		return nil, err
 	}
 	defer os.Remove(tempfile.Name()) // clean up
 
-	commandParts := strings.Split(SOLVER_COMMAND+" "+tempfile.Name(), " ")
-	subProcess := exec.Command(commandParts[0], commandParts[1:]...)
+	command := SOLVER_COMMAND
+	if opts.Seed != nil {
+		command += " --seed " + strconv.FormatInt(*opts.Seed, 10)
+	}
+	if opts.Stability != nil {
+		command += " --stability " + strconv.Itoa(*opts.Stability)
+	}
+	if opts.MinCredits != nil {
+		command += " --min-credits " + strconv.Itoa(*opts.MinCredits)
+	}
+	if opts.MaxCreditImbalance != nil {
+		command += " --max-credit-imbalance " + strconv.Itoa(*opts.MaxCreditImbalance)
+	}
+	if opts.Balance != nil {
+		command += " --balance " + strconv.Itoa(*opts.Balance)
+	}
+	if opts.OverlapBudget != nil {
+		command += " --overlap-budget " + strconv.Itoa(*opts.OverlapBudget)
+	}
+	if opts.HeavyWorkload != nil {
+		command += " --heavy-workload " + strconv.Itoa(*opts.HeavyWorkload)
+	}
+	if opts.BackToBack != nil {
+		command += " --back-to-back " + strconv.Itoa(*opts.BackToBack)
+	}
+	if opts.MaxHeavyHours != nil {
+		command += " --max-heavy-hours " + strconv.Itoa(*opts.MaxHeavyHours)
+	}
+	if opts.CommuteMinutes != nil {
+		command += " --commute-minutes " + strconv.Itoa(*opts.CommuteMinutes)
+	}
+	if opts.LeaveAfter != "" {
+		command += " --leave-after " + opts.LeaveAfter
+	}
+	if opts.HomeBy != "" {
+		command += " --home-by " + opts.HomeBy
+	}
+	if opts.CommutePenalty != nil {
+		command += " --commute-penalty " + strconv.Itoa(*opts.CommutePenalty)
+	}
+	for _, r := range opts.Reserve {
+		command += " --reserve " + r
+	}
+	if len(opts.SkippableTypes) > 0 {
+		command += " --skippable " + strings.Join(opts.SkippableTypes, ",")
+	}
+	if solverMemoryLimit > 0 {
+		command += " --memory-limit " + strconv.Itoa(solverMemoryLimit)
+	}
+	if solverBeamWidth > 0 {
+		command += " --beam-width " + strconv.Itoa(solverBeamWidth)
+	}
+	if solverSlotMinutes > 0 {
+		command += " --slot-minutes " + strconv.Itoa(solverSlotMinutes)
+	}
+	if opts.Strategy != "" {
+		command += " --strategy " + opts.Strategy
+	}
+	if opts.Evaluate != nil {
+		command += " --evaluate " + string(opts.Evaluate)
+	}
+	commandParts := strings.Split(command+" "+tempfile.Name(), " ")
+	runContext := solverContext
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		runContext, cancel = context.WithTimeout(solverContext, opts.Timeout)
+		defer cancel()
+	}
+	subProcess := exec.CommandContext(runContext, commandParts[0], commandParts[1:]...)
 	// Run relative to the directory given by -rootdir
 	subProcess.Dir = path.Join(rootDir, "solver")
 
+	_, runSpan := tracer.Start(ctx, "solver.run")
 	res, err := subProcess.CombinedOutput()
+	runSpan.End()
+	chargeSolveQuota(ctx, subProcess.ProcessState)
+	if runContext.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("The solver didn't finish within %s", opts.Timeout)
+	}
 	if err != nil {
 		return nil, err
 	}
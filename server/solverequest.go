@@ -0,0 +1,384 @@
+// The format of solve requests and answers. Requests say which version of
+// it they use, so that the frontend and the server needn't be deployed
+// together: requests of older versions are converted, and the answers only
+// ever get new fields, which older clients ignore.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The newest version of the solve request and answer format. Version 1 is
+// the unversioned format of before, a bare array of courses or an object
+// with loose settings (see legacySolveRequest).
+const SOLVE_SCHEMA_VERSION = 2
+
+// The longest time a request may let the solver run
+const MAX_SOLVE_TIMEOUT = 5 * time.Minute
+
+// A solve request of version 2, e.g.
+//
+//	{"version": 2,
+//	 "courses": [...],
+//	 "rules": ["...", ...],     // To apply to the courses first
+//	 "seed": 42,                // To reproduce an earlier answer (which contains its seed)
+//	 "previous": [1, null],     // The options chosen in the schedule being changed, kept if possible
+//	 "locks": [null, 0],        // Options which must be kept (e.g. groups the student is enrolled in)
+//	 "start": [0, 1],           // A schedule to improve on, which the solver starts from
+//	 "weights": {"stability": 50, "balance": 10},
+//	 "credits": {"min": 30, "max_imbalance": 5},
+//	 "overlap": {"budget": 90, "skippable_types": ["lecture"]},
+//	 "workload": {"heavy": 4, "max_heavy_hours": 4},
+//	 "commute": {"minutes": 40, "leave_after": "07:30", "home_by": "19:00"},
+//	 "languages": {"require": "en"},
+//	 "reserve": [{"name": "Thesis", "count": 2, "minutes": 180, "earliest": "09:00", "latest": "18:00"}],
+//	 "profile": "3f2a...",      // A saved preference profile (see profiles.go)
+//	 "friend": {...},           // Courses to take in a friend's groups (see friends.go)
+//	 "timeout": 30}             // Seconds the solver may run
+//
+// Unknown fields are refused, so that mistakes don't go unnoticed.
+type solveRequest struct {
+	Version   int            `json:"version"`
+	Courses   []solveCourse  `json:"courses"`
+	Rules     []string       `json:"rules,omitempty"`
+	Seed      *int64         `json:"seed,omitempty"`
+	Previous  []*int         `json:"previous,omitempty"`
+	Locks     []*int         `json:"locks,omitempty"`
+	Start     []*int         `json:"start,omitempty"`
+	Weights   solveWeights   `json:"weights"`
+	Credits   solveCredits   `json:"credits"`
+	Overlap   solveOverlap   `json:"overlap"`
+	Workload  solveWorkload  `json:"workload"`
+	Commute   solveCommute   `json:"commute"`
+	Languages solveLanguages `json:"languages"`
+	Reserve   []solveReserve `json:"reserve,omitempty"`
+	Profile   string         `json:"profile,omitempty"`
+	Friend    *solveFriend   `json:"friend,omitempty"`
+	Timeout   *int           `json:"timeout,omitempty"`
+}
+
+// A course of a solve request, as the solver takes it (see solver/README.md).
+type solveCourse struct {
+	Name       string   `json:"name"`
+	Reward     *float64 `json:"reward,omitempty"`
+	Credits    int      `json:"credits,omitempty"`
+	Semester   int      `json:"semester,omitempty"`
+	CourseCode string   `json:"course_code,omitempty"`
+	Component  string   `json:"component,omitempty"`
+	Optional   bool     `json:"optional,omitempty"`
+	// How demanding the course is, from 1 (light) to 5 (heavy), 3 if not given
+	Workload        *int           `json:"workload,omitempty"`
+	OptionPenalties []int          `json:"option_penalties,omitempty"`
+	PreviousOption  *int           `json:"previous_option,omitempty"`
+	StartOption     *int           `json:"start_option,omitempty"`
+	Filters         *courseFilters `json:"filters,omitempty"`
+	// Whether the student skips the lectures, which then needn't fit into
+	// the schedule (see lectures.go)
+	IgnoreLectures bool `json:"ignore_lectures,omitempty"`
+	// The groups of events to choose from, as /sisquery/ returns them
+	// (the events may also be "skippable")
+	Options json.RawMessage `json:"options"`
+}
+
+// How much the solver cares about what, in 1/100 of the reward of a course
+// of the lowest priority; nil means the solver's default
+type solveWeights struct {
+	// For keeping the previous options
+	Stability *int `json:"stability,omitempty"`
+	// For each hour between the busiest and the lightest weekday
+	Balance *int `json:"balance,omitempty"`
+	// For each two events of different heavy courses (see solveWorkload)
+	// with at most a short break between them
+	BackToBack *int `json:"back_to_back,omitempty"`
+	// For each hour of leaving home too early or getting home too late
+	// (see solveCommute)
+	Commute *int `json:"commute,omitempty"`
+	// For options whose group has filled up before, times the share of
+	// the samples in which it was full (see fillrate.go)
+	Fill *int `json:"fill,omitempty"`
+	// Of the objectives configured by -objective, by name, instead of
+	// their configured weights (see objectives.go)
+	Objectives map[string]int `json:"objectives,omitempty"`
+}
+
+type solveCredits struct {
+	// The least total over all semesters
+	Min *int `json:"min,omitempty"`
+	// The largest difference between the semesters
+	MaxImbalance *int `json:"max_imbalance,omitempty"`
+}
+
+type solveOverlap struct {
+	// Minutes per week by which events of the types may overlap others
+	Budget         *int     `json:"budget,omitempty"`
+	SkippableTypes []string `json:"skippable_types,omitempty"`
+}
+
+type solveWorkload struct {
+	// Courses of at least this "workload" are heavy, 4 by default
+	Heavy *int `json:"heavy,omitempty"`
+	// The most hours of events of heavy courses in a day
+	MaxHeavyHours *int `json:"max_heavy_hours,omitempty"`
+}
+
+// The way between home and school of the student
+type solveCommute struct {
+	// How long it takes each way
+	Minutes *int `json:"minutes,omitempty"`
+	// Leaving home before and getting home after these times of day (e.g.
+	// "07:30") is penalized by the commute weight
+	LeaveAfter string `json:"leave_after,omitempty"`
+	HomeBy     string `json:"home_by,omitempty"`
+}
+
+// Checks the commute and writes its times as the solver reads them.
+func (c *solveCommute) normalize() error {
+	if c.Minutes != nil && *c.Minutes < 0 {
+		return fmt.Errorf("The commute can't take %d minutes", *c.Minutes)
+	}
+	for _, t := range []*string{&c.LeaveAfter, &c.HomeBy} {
+		if *t == "" {
+			continue
+		}
+		clock, err := sisparse.ParseClockTime(*t)
+		if err != nil || clock.Minutes() > 24*60 {
+			return fmt.Errorf("Invalid time of the commute %q, expected hh:mm", *t)
+		}
+		*t = clock.String()
+	}
+	return nil
+}
+
+// The most blocks a request may reserve, over all its reservations
+const MAX_RESERVED_BLOCKS = 14
+
+// Blocks of time to keep free of classes each week (e.g. for thesis work),
+// which the solver places once the courses are selected, see "reserved" of
+// solveResponse
+type solveReserve struct {
+	// What the blocks are for, returned with them
+	Name    string `json:"name,omitempty"`
+	Count   int    `json:"count"`
+	Minutes int    `json:"minutes"`
+	// Between these times of day, 08:00 and 20:00 if not given
+	Earliest string `json:"earliest,omitempty"`
+	Latest   string `json:"latest,omitempty"`
+}
+
+// Checks the reservation and returns it as the solver reads it, e.g.
+// "2x180@09:00-18:00".
+func (r *solveReserve) solverArg() (string, error) {
+	if r.Count < 1 || r.Minutes < 15 || r.Minutes > 24*60 {
+		return "", fmt.Errorf("Expected at least one block of 15 minutes to a day")
+	}
+	arg := fmt.Sprintf("%dx%d", r.Count, r.Minutes)
+	if r.Earliest == "" && r.Latest == "" {
+		return arg, nil
+	}
+	times := []string{"08:00", "20:00"}
+	for i, t := range []string{r.Earliest, r.Latest} {
+		if t == "" {
+			continue
+		}
+		clock, err := sisparse.ParseClockTime(t)
+		if err != nil || clock.Minutes() > 24*60 {
+			return "", fmt.Errorf("Invalid time %q, expected hh:mm", t)
+		}
+		times[i] = clock.String()
+	}
+	return arg + "@" + times[0] + "-" + times[1], nil
+}
+
+// Languages of instruction of the groups, just shorthands for rules
+type solveLanguages struct {
+	Require string `json:"require,omitempty"`
+	Prefer  string `json:"prefer,omitempty"`
+}
+
+// The object form of version 1
+type legacySolveRequest struct {
+	Courses   []solveCourse `json:"courses"`
+	Rules     []string      `json:"rules"`
+	Seed      *int64        `json:"seed"`
+	Previous  []*int        `json:"previous"`
+	Stability *int          `json:"stability"`
+
+	MinCredits         *int `json:"min_credits"`
+	MaxCreditImbalance *int `json:"max_credit_imbalance"`
+	Balance            *int `json:"balance"`
+
+	OverlapBudget  *int     `json:"overlap_budget"`
+	SkippableTypes []string `json:"skippable_types"`
+
+	RequireLanguage string `json:"require_language"`
+	PreferLanguage  string `json:"prefer_language"`
+
+	Profile string `json:"profile"`
+}
+
+func (r legacySolveRequest) upgrade() solveRequest {
+	return solveRequest{
+		Version:   SOLVE_SCHEMA_VERSION,
+		Courses:   r.Courses,
+		Rules:     r.Rules,
+		Seed:      r.Seed,
+		Previous:  r.Previous,
+		Weights:   solveWeights{Stability: r.Stability, Balance: r.Balance},
+		Credits:   solveCredits{Min: r.MinCredits, MaxImbalance: r.MaxCreditImbalance},
+		Overlap:   solveOverlap{Budget: r.OverlapBudget, SkippableTypes: r.SkippableTypes},
+		Languages: solveLanguages{Require: r.RequireLanguage, Prefer: r.PreferLanguage},
+		Profile:   r.Profile,
+	}
+}
+
+// Parses a solve request of any version, converting it to the newest one.
+// Requests of version 1 are parsed as leniently as they always were.
+func parseSolveRequest(body []byte) (solveRequest, error) {
+	if !looksLikeObject(body) {
+		var req legacySolveRequest
+		if err := json.Unmarshal(body, &req.Courses); err != nil {
+			return solveRequest{}, err
+		}
+		return req.upgrade(), nil
+	}
+
+	var version struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(body, &version); err != nil {
+		return solveRequest{}, err
+	}
+	switch {
+	case version.Version <= 1:
+		var req legacySolveRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return solveRequest{}, err
+		}
+		return req.upgrade(), nil
+	case version.Version > SOLVE_SCHEMA_VERSION:
+		return solveRequest{}, fmt.Errorf("Unsupported request version %d, the server supports versions up to %d",
+			version.Version, SOLVE_SCHEMA_VERSION)
+	}
+
+	var req solveRequest
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&req); err != nil {
+		return solveRequest{}, err
+	}
+	if req.Timeout != nil && (*req.Timeout <= 0 || time.Duration(*req.Timeout)*time.Second > MAX_SOLVE_TIMEOUT) {
+		return solveRequest{}, fmt.Errorf("The timeout must be between 1 and %d seconds", int(MAX_SOLVE_TIMEOUT/time.Second))
+	}
+	blocks := 0
+	for _, r := range req.Reserve {
+		blocks += r.Count
+	}
+	if blocks > MAX_RESERVED_BLOCKS {
+		return solveRequest{}, fmt.Errorf("At most %d blocks may be reserved", MAX_RESERVED_BLOCKS)
+	}
+	return req, nil
+}
+
+// An answer to a solve request. The fields other than "data" and
+// "fallbacks" are only there when the solver (or the server) says them.
+type solveResponse struct {
+	Version int `json:"version"`
+	// The option selected for each course, null if it isn't selected
+	Data      []*int            `json:"data"`
+	Fallbacks []*solverFallback `json:"fallbacks"`
+	Seed      *int64            `json:"seed,omitempty"`
+	// "exact", or "beam" if the query was too big for the memory limit of
+	// the solver and the schedule may not be the best one
+	Strategy string `json:"strategy,omitempty"`
+	// How the "start" of the request scores, if it was given
+	Start json.RawMessage `json:"start,omitempty"`
+	// How the schedule scores by the criteria of the solver
+	Score map[string]float64 `json:"score,omitempty"`
+	// Diagnostics: why each course is or isn't in the schedule, and
+	// which courses couldn't be scheduled at all
+	Explanation   []string              `json:"explanation,omitempty"`
+	Unschedulable []unschedulableCourse `json:"unschedulable,omitempty"`
+	Semesters     json.RawMessage       `json:"semesters,omitempty"`
+	Pairings      json.RawMessage       `json:"pairings,omitempty"`
+	Overlaps      json.RawMessage       `json:"overlaps,omitempty"`
+	Omitted       json.RawMessage       `json:"omitted,omitempty"`
+	// The options which the solver didn't consider, as another option of
+	// the same course is as good or better, see pruneDominated
+	Pruned []prunedOption `json:"pruned,omitempty"`
+	// For each course, when each event of its option first takes place,
+	// see firstOccurrences
+	FirstOccurrences [][]*firstOccurrence `json:"first_occurrences,omitempty"`
+	// Where the blocks of the "reserve" of the request are placed, and how
+	// many of them didn't fit in each semester
+	Reserved []reservedBlock  `json:"reserved,omitempty"`
+	Unplaced []unplacedBlocks `json:"unplaced,omitempty"`
+	// The lectures of the chosen options of the courses whose lectures
+	// are ignored, see lectures.go
+	IgnoredLectures []ignoredLecture `json:"ignored_lectures,omitempty"`
+	// When no schedule can be found, instead of the rest
+	Error string `json:"error,omitempty"`
+}
+
+// A block of a reservation (by its index in "reserve" of the request),
+// e.g. {"reservation": 0, "name": "Thesis", "semester": 1, "day": 2,
+// "time_from": "09:00", "time_to": "12:00"}; the day is 0 for Monday.
+type reservedBlock struct {
+	Reservation int    `json:"reservation"`
+	Name        string `json:"name,omitempty"`
+	Semester    int    `json:"semester"`
+	Day         int    `json:"day"`
+	TimeFrom    string `json:"time_from"`
+	TimeTo      string `json:"time_to"`
+}
+
+type unplacedBlocks struct {
+	Reservation int    `json:"reservation"`
+	Name        string `json:"name,omitempty"`
+	Semester    int    `json:"semester"`
+	Missing     int    `json:"missing"`
+}
+
+// Brings an answer of the solver (translated by filteredQuery, if the
+// request had rules) for the courses and the reservations to the form of
+// solveResponse.
+func newSolveResponse(ctx context.Context, answer []byte, courses []solveCourse, reserve []solveReserve) ([]byte, error) {
+	var res solveResponse
+	if err := json.Unmarshal(answer, &res); err != nil {
+		return nil, err
+	}
+	res.Version = SOLVE_SCHEMA_VERSION
+	if res.Error != "" {
+		return json.Marshal(map[string]interface{}{"version": res.Version, "error": res.Error})
+	}
+	if res.Data == nil {
+		res.Data = []*int{}
+	}
+	if res.Fallbacks == nil {
+		res.Fallbacks = []*solverFallback{}
+	}
+	for name, score := range objectiveScores(courses, res.Data) {
+		if res.Score == nil {
+			res.Score = map[string]float64{}
+		}
+		res.Score[name] = score
+	}
+	res.FirstOccurrences = firstOccurrences(ctx, courses, res.Data)
+	res.IgnoredLectures = ignoredLectures(courses, res.Data)
+	for i, b := range res.Reserved {
+		if b.Reservation >= 0 && b.Reservation < len(reserve) {
+			res.Reserved[i].Name = reserve[b.Reservation].Name
+		}
+	}
+	for i, b := range res.Unplaced {
+		if b.Reservation >= 0 && b.Reservation < len(reserve) {
+			res.Unplaced[i].Name = reserve[b.Reservation].Name
+		}
+	}
+	return json.Marshal(res)
+}
@@ -0,0 +1,202 @@
+// Serving the webapp, so that small deployments need no other web server.
+// Files are kept in memory with a hash of their content, which is their
+// ETag; HTML pages refer to scripts and stylesheets with "?v=<hash>", so
+// that browsers may cache those for good and still get new versions at
+// once. Files are compressed with gzip, or served as their precompressed
+// "<file>.br" and "<file>.gz" siblings when those exist.
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Files smaller than this aren't worth compressing
+const STATIC_MIN_COMPRESSED_SIZE = 1024
+
+// Cache-Control of files asked for with their current hash
+const STATIC_IMMUTABLE = "public, max-age=31536000, immutable"
+
+type staticFile struct {
+	modTime     time.Time
+	size        int64
+	hash        string
+	contentType string
+	body        []byte
+	gzipped     []byte
+	brotli      []byte
+}
+
+type staticHandler struct {
+	dir string
+	// Whether unknown paths without an extension get index.html, for
+	// webapps routing by the history API
+	spaFallback bool
+
+	mu    sync.Mutex
+	files map[string]*staticFile
+}
+
+func newStaticHandler(dir string, spaFallback bool) *staticHandler {
+	return &staticHandler{dir: dir, spaFallback: spaFallback, files: map[string]*staticFile{}}
+}
+
+// Returns the file (a clean path starting with /), reading it again when
+// it has changed on disk.
+func (h *staticHandler) file(name string) (*staticFile, error) {
+	filename := filepath.Join(h.dir, filepath.FromSlash(name))
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, os.ErrNotExist
+	}
+	h.mu.Lock()
+	f := h.files[name]
+	h.mu.Unlock()
+	if f != nil && f.modTime.Equal(info.ModTime()) && f.size == info.Size() {
+		return f, nil
+	}
+
+	body, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	f = &staticFile{modTime: info.ModTime(), size: info.Size(), body: body}
+	f.contentType = mime.TypeByExtension(path.Ext(name))
+	if f.contentType == "" {
+		f.contentType = http.DetectContentType(body)
+	}
+	sum := sha256.Sum256(body)
+	f.hash = hex.EncodeToString(sum[:8])
+	if br, err := ioutil.ReadFile(filename + ".br"); err == nil {
+		f.brotli = br
+	}
+	if gz, err := ioutil.ReadFile(filename + ".gz"); err == nil {
+		f.gzipped = gz
+	} else if compressible(f.contentType) && len(body) >= STATIC_MIN_COMPRESSED_SIZE {
+		f.gzipped = gzipBytes(body)
+	}
+	h.mu.Lock()
+	h.files[name] = f
+	h.mu.Unlock()
+	return f, nil
+}
+
+func compressible(contentType string) bool {
+	for _, t := range []string{"text/", "application/javascript", "application/json", "image/svg+xml"} {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(b)
+	gz.Close()
+	return buf.Bytes()
+}
+
+// References of pages to local scripts and stylesheets
+var assetReference = regexp.MustCompile(`(src|href)="([^":?#]+\.(?:js|css))"`)
+
+// Returns the page with "?v=<hash>" added to the references to local
+// scripts and stylesheets (relative to the page).
+func (h *staticHandler) withAssetHashes(name string, page []byte) []byte {
+	return assetReference.ReplaceAllFunc(page, func(m []byte) []byte {
+		parts := assetReference.FindSubmatch(m)
+		ref := string(parts[2])
+		asset := path.Join(path.Dir(name), ref)
+		if strings.HasPrefix(ref, "/") {
+			asset = path.Clean(ref)
+		}
+		f, err := h.file(asset)
+		if err != nil {
+			return m
+		}
+		return []byte(string(parts[1]) + `="` + ref + "?v=" + f.hash + `"`)
+	})
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	name := path.Clean("/" + r.URL.Path)
+	if strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	if strings.Contains(name, "/.") {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := h.file(name)
+	if os.IsNotExist(err) && h.spaFallback && path.Ext(name) == "" {
+		name = "/index.html"
+		f, err = h.file(name)
+	}
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, "Could not read the file", http.StatusInternalServerError)
+		return
+	}
+
+	body, gzipped, brotli, hash := f.body, f.gzipped, f.brotli, f.hash
+	if strings.HasPrefix(f.contentType, "text/html") {
+		// The page changes with the assets it refers to
+		body = h.withAssetHashes(name, f.body)
+		sum := sha256.Sum256(body)
+		hash = hex.EncodeToString(sum[:8])
+		gzipped, brotli = nil, nil
+		if len(body) >= STATIC_MIN_COMPRESSED_SIZE {
+			gzipped = gzipBytes(body)
+		}
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", f.contentType)
+	header.Set("ETag", `"`+hash+`"`)
+	header.Add("Vary", "Accept-Encoding")
+	if r.URL.Query().Get("v") == hash {
+		header.Set("Cache-Control", STATIC_IMMUTABLE)
+	} else {
+		header.Set("Cache-Control", "no-cache")
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && strings.Contains(inm, `"`+hash+`"`) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if brotli != nil && acceptsEncoding(r, "br") {
+		body = brotli
+		header.Set("Content-Encoding", "br")
+	} else if gzipped != nil && acceptsEncoding(r, "gzip") {
+		body = gzipped
+		header.Set("Content-Encoding", "gzip")
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "GET" {
+		w.Write(body)
+	}
+}
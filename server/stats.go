@@ -0,0 +1,155 @@
+// Anonymous usage statistics (only with -stats): how often each course is
+// requested and how long the solver runs, counted per day in the database.
+// Nothing about the users is kept, and rarely requested courses aren't
+// shown, so that the statistics can't tell what a single student plans.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// Courses requested fewer times than this are left out of the statistics
+const MIN_STATS_COUNT = 5
+
+// How many of the most requested courses are shown
+const MAX_STATS_COURSES = 100
+
+// Counters are kept for 60 days, see store.IncrementCounter
+const MAX_STATS_DAYS = 60
+
+const (
+	STATS_COURSE_PREFIX = "stats/course/"
+	STATS_SOLVE_PREFIX  = "stats/solve/"
+)
+
+// Upper bounds of the buckets of solver run times
+var solveTimeBuckets = []time.Duration{
+	time.Second, 5 * time.Second, 15 * time.Second, time.Minute, 5 * time.Minute,
+}
+
+var statsEnabled bool
+
+type courseStats struct {
+	Code     string `json:"code"`
+	Requests int    `json:"requests"`
+}
+
+type usageStats struct {
+	Days    int           `json:"days"`
+	Courses []courseStats `json:"courses"`
+	// Solver runs by how long they took, e.g. "5s" for those between 1
+	// and 5 seconds, "more" for the longest ones
+	SolveTimes map[string]int `json:"solve_times"`
+	SolveRuns  int            `json:"solve_runs"`
+}
+
+func statsDay(t time.Time) int64 {
+	return t.Unix() / (24 * 60 * 60)
+}
+
+// Counts the event in the current day, without waiting for the database.
+func countStat(key string) {
+	if !statsEnabled {
+		return
+	}
+	day := statsDay(time.Now())
+	go func() {
+		if _, err := db.IncrementCounter(key, day); err != nil {
+			log.Printf("Could not count %s: %s", key, err)
+		}
+	}()
+}
+
+// Counts a request of the course (by the tenant of ctx).
+func countCourseRequest(ctx context.Context, code string) {
+	if sisparse.IsCourseCode(code) {
+		countStat(STATS_COURSE_PREFIX + tenantCacheName(ctx, code))
+	}
+}
+
+func countSolveTime(d time.Duration) {
+	bucket := "more"
+	for _, b := range solveTimeBuckets {
+		if d <= b {
+			bucket = b.String()
+			break
+		}
+	}
+	countStat(STATS_SOLVE_PREFIX + bucket)
+}
+
+// Returns the statistics of the last days, the courses of the tenant
+// of ctx only.
+func getUsageStats(ctx context.Context, days int) (usageStats, error) {
+	from := statsDay(time.Now()) - int64(days) + 1
+	res := usageStats{Days: days, Courses: []courseStats{}, SolveTimes: map[string]int{}}
+
+	prefix := STATS_COURSE_PREFIX + tenantCacheName(ctx, "")
+	counters, err := db.ListCounters(prefix, from)
+	if err != nil {
+		return res, err
+	}
+	requests := map[string]int{}
+	for _, c := range counters {
+		code := strings.TrimPrefix(c.Key, prefix)
+		if !strings.Contains(code, "/") {
+			requests[code] += c.Count
+		}
+	}
+	for code, n := range requests {
+		if n >= MIN_STATS_COUNT {
+			res.Courses = append(res.Courses, courseStats{code, n})
+		}
+	}
+	sort.Slice(res.Courses, func(i, j int) bool {
+		a, b := res.Courses[i], res.Courses[j]
+		return a.Requests > b.Requests || (a.Requests == b.Requests && a.Code < b.Code)
+	})
+	if len(res.Courses) > MAX_STATS_COURSES {
+		res.Courses = res.Courses[:MAX_STATS_COURSES]
+	}
+
+	if counters, err = db.ListCounters(STATS_SOLVE_PREFIX, from); err != nil {
+		return res, err
+	}
+	for _, c := range counters {
+		res.SolveTimes[strings.TrimPrefix(c.Key, STATS_SOLVE_PREFIX)] += c.Count
+		res.SolveRuns += c.Count
+	}
+	return res, nil
+}
+
+// Answers /admin/stats?days=<n> (30 by default) with usageStats.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if !statsEnabled {
+		fmt.Fprint(w, `{"error":"Statistics are disabled, see -stats"}`)
+		return
+	}
+	days := 30
+	if d := r.URL.Query().Get("days"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 1 || n > MAX_STATS_DAYS {
+			fmt.Fprintf(w, `{"error":"The days must be between 1 and %d"}`, MAX_STATS_DAYS)
+			return
+		}
+		days = n
+	}
+	stats, err := getUsageStats(r.Context(), days)
+	if err != nil {
+		log.Printf("Stats error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	s, _ := json.Marshal(stats)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
@@ -0,0 +1,87 @@
+// Suggesting course codes when the user mistypes one.
+package server
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// How many suggestions to give at most
+const MAX_SUGGESTIONS = 5
+
+// Codes at most this far (in edit distance) from the query are suggested
+const MAX_SUGGESTION_DISTANCE = 2
+
+// Returns codes of cached courses which are similar to the given one:
+// either they start with it, or they differ from it by a typo or two.
+func suggestCourseCodes(ctx context.Context, code string) []string {
+	cached, err := listTenantCache(ctx)
+	if err != nil {
+		return []string{}
+	}
+
+	type candidate struct {
+		code     string
+		distance int
+	}
+	candidates := []candidate{}
+	for _, c := range cached {
+		if c == code || !sisparse.IsCourseCode(c) {
+			continue
+		}
+		d := editDistance(code, c)
+		if strings.HasPrefix(c, code) {
+			d = 0
+		}
+		if d <= MAX_SUGGESTION_DISTANCE {
+			candidates = append(candidates, candidate{c, d})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return candidates[i].code < candidates[j].code
+	})
+
+	res := []string{}
+	for i := 0; i < len(candidates) && i < MAX_SUGGESTIONS; i++ {
+		res = append(res, candidates[i].code)
+	}
+	return res
+}
+
+// The Levenshtein distance of two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
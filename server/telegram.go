@@ -0,0 +1,155 @@
+// The Telegram bot, see bot.go. It asks Telegram for new messages by long
+// polling, so it needs no public URL, but only one instance of the server
+// may run it.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TELEGRAM_API = "https://api.telegram.org/bot"
+	// How long a poll waits for new messages, in seconds
+	TELEGRAM_POLL_TIMEOUT = 50
+	// The longest message Telegram takes, in characters
+	TELEGRAM_MESSAGE_LIMIT = 4096
+	// How long to wait after a failed poll
+	TELEGRAM_RETRY_DELAY = 10 * time.Second
+)
+
+type telegramBot struct {
+	token  string
+	client *http.Client
+}
+
+type telegramUpdate struct {
+	UpdateId int64 `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			Id int64 `json:"id"`
+		} `json:"chat"`
+		From struct {
+			Id           int64  `json:"id"`
+			LanguageCode string `json:"language_code"`
+		} `json:"from"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+func newTelegramBot(token string) *telegramBot {
+	b := &telegramBot{
+		token:  token,
+		client: &http.Client{Timeout: (TELEGRAM_POLL_TIMEOUT + 10) * time.Second},
+	}
+	registerNotifier("telegram", chatNotifier(b.send))
+	return b
+}
+
+// Answers the commands sent to the bot until ctx is done.
+func (b *telegramBot) run(ctx context.Context) {
+	var offset int64
+	for ctx.Err() == nil {
+		updates, err := b.getUpdates(ctx, offset)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Telegram error: %s", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(TELEGRAM_RETRY_DELAY):
+			}
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateId + 1
+			if u.Message == nil {
+				continue
+			}
+			command, args, ok := parseBotCommand(u.Message.Text)
+			if !ok {
+				continue
+			}
+			chat := strconv.FormatInt(u.Message.Chat.Id, 10)
+			cmdCtx := withLanguage(context.Background(), DEFAULT_LANGUAGE)
+			if isLanguage(u.Message.From.LanguageCode) {
+				cmdCtx = withLanguage(cmdCtx, u.Message.From.LanguageCode)
+			}
+			go func() {
+				user := "telegram:" + strconv.FormatInt(u.Message.From.Id, 10)
+				answer := runBotCommand(cmdCtx, "telegram:"+chat, user, command, args)
+				if err := b.send(chat, answer); err != nil {
+					log.Printf("Telegram error: %s", err)
+				}
+			}()
+		}
+	}
+}
+
+func (b *telegramBot) getUpdates(ctx context.Context, offset int64) ([]telegramUpdate, error) {
+	params := url.Values{
+		"offset":          {strconv.FormatInt(offset, 10)},
+		"timeout":         {strconv.Itoa(TELEGRAM_POLL_TIMEOUT)},
+		"allowed_updates": {`["message"]`},
+	}
+	var updates []telegramUpdate
+	err := b.call(ctx, "getUpdates?"+params.Encode(), nil, &updates)
+	return updates, err
+}
+
+func (b *telegramBot) send(chat, text string) error {
+	body, _ := json.Marshal(map[string]string{
+		"chat_id": chat,
+		"text":    truncateMessage(text, TELEGRAM_MESSAGE_LIMIT),
+	})
+	return b.call(context.Background(), "sendMessage", body, nil)
+}
+
+// Calls the method of the Bot API (with a JSON body, if not nil) and
+// decodes its result into res.
+func (b *telegramBot) call(ctx context.Context, method string, body []byte, res interface{}) error {
+	httpMethod := "GET"
+	if body != nil {
+		httpMethod = "POST"
+	}
+	req, err := http.NewRequest(httpMethod, TELEGRAM_API+b.token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		// The error contains the URL, and so the token
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	var answer struct {
+		Ok          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return err
+	}
+	if !answer.Ok {
+		return fmt.Errorf("%s failed: %s", strings.Split(method, "?")[0], answer.Description)
+	}
+	if res != nil {
+		return json.Unmarshal(answer.Result, res)
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+// Serving several faculties from one instance. Each tenant has its own SIS,
+// academic calendar, travel times between buildings and branding, and
+// its courses are cached apart from those of the others. Requests are
+// assigned to tenants by their host name or by a path prefix.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/calendar"
+	"github.com/iamwave/samorozvrh/sisparse"
+	"github.com/iamwave/samorozvrh/travel"
+)
+
+// A tenant as configured in the file given by -tenants, e.g.
+//
+//	{"id": "pedf",
+//	 "hosts": ["rozvrh.pedf.cuni.cz"],
+//	 "path_prefix": "/pedf",
+//	 "sis_urls": ["https://is.cuni.cz/studium"],
+//	 "calendar": "calendars/pedf.json",
+//	 "travel": "travel/pedf.json",
+//	 "bundles": "bundles/pedf.json",
+//	 "branding": {"title": "Samorozvrh PedF", "color": "#0b6e4f"}}
+//
+// Files are relative to rootdir. What isn't given is as for the default
+// tenant, which serves the requests of no other tenant and is configured
+// by the other flags.
+type tenant struct {
+	Id         string   `json:"id"`
+	Hosts      []string `json:"hosts,omitempty"`
+	PathPrefix string   `json:"path_prefix,omitempty"`
+	SisUrls    []string `json:"sis_urls,omitempty"`
+	Calendar   string   `json:"calendar,omitempty"`
+	// Minutes it takes to walk between buildings and where they are, see
+	// travel.Dataset
+	Travel string `json:"travel,omitempty"`
+	// Course bundles, see bundles.go
+	Bundles  string         `json:"bundles,omitempty"`
+	Branding tenantBranding `json:"branding"`
+
+	semester *calendar.Semester
+	travel   map[string]map[string]int
+	// Where the buildings of the travel times are, if known
+	buildings map[string]travel.Building
+	bundles   []courseBundle
+	index     *searchIndex
+}
+
+// How the webapp presents itself to the tenant's students
+type tenantBranding struct {
+	Title   string `json:"title,omitempty"`
+	LogoUrl string `json:"logo_url,omitempty"`
+	Color   string `json:"color,omitempty"`
+}
+
+// The tenant of requests which aren't of any configured one; its calendar
+// is that of -calendar, its travel times those of -travel and its bundles
+// those of -bundles
+var defaultTenant = &tenant{index: courseIndex}
+
+var tenants = []*tenant{}
+
+// How long computing the missing travel times of a dataset may take
+const TRAVEL_ROUTER_TIMEOUT = time.Minute
+
+// Loads the tenants from the file (relative to rootdir).
+func loadTenants(filename string) error {
+	data, err := ioutil.ReadFile(path.Join(rootDir, filename))
+	if err != nil {
+		return err
+	}
+	var loaded []*tenant
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("Invalid tenants file: %s", err)
+	}
+	ids := map[string]bool{}
+	for _, t := range loaded {
+		if t.Id == "" || strings.ContainsAny(t.Id, "/@~") || ids[t.Id] {
+			return fmt.Errorf("Invalid or duplicate tenant id %q", t.Id)
+		}
+		ids[t.Id] = true
+		if len(t.Hosts) == 0 && t.PathPrefix == "" {
+			return fmt.Errorf("Tenant %s has neither hosts nor a path prefix", t.Id)
+		}
+		t.PathPrefix = strings.TrimRight(t.PathPrefix, "/")
+		if t.PathPrefix != "" && !strings.HasPrefix(t.PathPrefix, "/") {
+			return fmt.Errorf("The path prefix of tenant %s must start with /", t.Id)
+		}
+		t.semester = defaultTenant.semester
+		if t.Calendar != "" {
+			if t.semester, err = calendar.LoadSemester(path.Join(rootDir, t.Calendar)); err != nil {
+				return fmt.Errorf("Tenant %s: %s", t.Id, err)
+			}
+		}
+		t.travel, t.buildings = defaultTenant.travel, defaultTenant.buildings
+		if t.Travel != "" {
+			d, err := loadTravelTimes(t.Travel)
+			if err != nil {
+				return fmt.Errorf("Tenant %s: %s", t.Id, err)
+			}
+			t.travel, t.buildings = d.Minutes, d.Buildings
+		}
+		t.bundles = defaultTenant.bundles
+		if t.Bundles != "" {
+			if t.bundles, err = loadBundles(t.Bundles); err != nil {
+				return fmt.Errorf("Tenant %s: %s", t.Id, err)
+			}
+		}
+		t.index = newSearchIndex()
+	}
+	tenants = loaded
+	return nil
+}
+
+// Computes the travel times missing from the datasets, see -travel-router
+var travelRouter travel.Router
+
+// Loads travel times between buildings (relative to rootdir), see
+// travel.Dataset, computing the missing ones by travelRouter if set.
+func loadTravelTimes(filename string) (*travel.Dataset, error) {
+	d, err := travel.Load(path.Join(rootDir, filename))
+	if err != nil {
+		return nil, err
+	}
+	if travelRouter != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), TRAVEL_ROUTER_TIMEOUT)
+		defer cancel()
+		added, err := d.FillMissing(ctx, travelRouter)
+		if err != nil {
+			// The times which are known are still of use
+			log.Printf("Could not compute the travel times missing from %s: %s", filename, err)
+		} else if added > 0 {
+			log.Printf("Computed %d travel times missing from %s", added, filename)
+		}
+	}
+	return d, nil
+}
+
+// Returns the tenant with the given id, nil if there is none.
+func getTenant(id string) *tenant {
+	for _, t := range tenants {
+		if t.Id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+// Returns a context in which requests are handled for the tenant: SIS is
+// queried at its URLs and its courses are cached apart.
+func withTenant(ctx context.Context, t *tenant) context.Context {
+	ctx = context.WithValue(ctx, tenantKey{}, t)
+	if len(t.SisUrls) > 0 {
+		ctx = sisparse.WithBaseUrls(ctx, t.SisUrls)
+	}
+	return ctx
+}
+
+// Returns the tenant set by withTenant, or the default one.
+func tenantOf(ctx context.Context) *tenant {
+	if t, ok := ctx.Value(tenantKey{}).(*tenant); ok {
+		return t
+	}
+	return defaultTenant
+}
+
+// Cache entries of tenants are named "<tenant>/<name>". The names of the
+// default tenant stay as they were.
+func tenantCacheName(ctx context.Context, name string) string {
+	if t := tenantOf(ctx); t != defaultTenant {
+		return t.Id + "/" + name
+	}
+	return name
+}
+
+// Returns the names of the cache entries of the tenant of ctx, without
+// the prefix added by tenantCacheName.
+func listTenantCache(ctx context.Context) ([]string, error) {
+	names, err := listCache()
+	if err != nil {
+		return nil, err
+	}
+	res := []string{}
+	prefix := tenantCacheName(ctx, "")
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		name = strings.TrimPrefix(name, prefix)
+		if !strings.Contains(name, "/") {
+			res = append(res, name)
+		}
+	}
+	return res, nil
+}
+
+// Assigns requests to tenants, by the host name first. Path prefixes are
+// stripped, so that /pedf/sisquery/X is handled as /sisquery/X for "pedf".
+func tenantMiddleware(next http.Handler) http.Handler {
+	if len(tenants) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		var found *tenant
+		for _, t := range tenants {
+			for _, h := range t.Hosts {
+				if strings.EqualFold(h, host) {
+					found = t
+				}
+			}
+		}
+		if found == nil {
+			for _, t := range tenants {
+				if t.PathPrefix != "" && r.URL.Path == t.PathPrefix {
+					// The webapp uses paths relative to its page
+					http.Redirect(w, r, t.PathPrefix+"/", http.StatusMovedPermanently)
+					return
+				}
+				if t.PathPrefix != "" && strings.HasPrefix(r.URL.Path, t.PathPrefix+"/") {
+					found = t
+					r2 := new(http.Request)
+					*r2 = *r
+					u := *r.URL
+					u.Path = strings.TrimPrefix(r.URL.Path, t.PathPrefix)
+					r2.URL = &u
+					r = r2
+					break
+				}
+			}
+		}
+		if found == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), found)))
+	})
+}
+
+// Answers /tenant with what the webapp needs to know about the tenant of
+// the request: its branding and the travel times between its buildings.
+func tenantHandler(w http.ResponseWriter, r *http.Request) {
+	t := tenantOf(r.Context())
+	s, err := json.Marshal(map[string]interface{}{
+		"id":        t.Id,
+		"branding":  t.Branding,
+		"travel":    t.travel,
+		"buildings": t.buildings,
+	})
+	if err != nil {
+		log.Printf("Tenant error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iamwave/samorozvrh/calendar"
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The academic year and the semester a request is about. Courses of other
+// academic years than the current one can be queried with ?year=.
+type term struct {
+	Year     int `json:"academic_year"`
+	Semester int `json:"semester"`
+}
+
+// Returns the current term of the tenant of ctx (see tenantOf).
+func currentTerm(ctx context.Context) term {
+	year, sem := detectTermWith(tenantOf(ctx).semester, time.Now())
+	return term{Year: year, Semester: sem}
+}
+
+// Returns the term of the default tenant at the given time, see
+// detectTermWith. It is also the TermDetector of sisparse.
+func detectTerm(now time.Time) (year int, sem int) {
+	return detectTermWith(defaultTenant.semester, now)
+}
+
+// Returns the term students plan their schedules for at the given time:
+// that of the academic calendar until its teaching ends (so the calendar
+// of an upcoming semester is followed in advance), otherwise judging by
+// the date (see -rollover-dates).
+func detectTermWith(semester *calendar.Semester, now time.Time) (year int, sem int) {
+	if semester != nil && !now.After(semester.End.AddDate(0, 0, 1)) {
+		return semester.Term()
+	}
+	if rollover != nil {
+		return rollover.term(now)
+	}
+	return sisparse.CurrentTerm(now)
+}
+
+// Returns the term given by ?year= and ?semester= of the request; what isn't
+// given is the current one. A year alone means its current semester.
+func requestTerm(r *http.Request) (term, error) {
+	t := currentTerm(r.Context())
+	q := r.URL.Query()
+	if y := q.Get("year"); y != "" {
+		year, err := strconv.Atoi(y)
+		if err != nil || !isValidYear(r.Context(), year) {
+			return t, fmt.Errorf("Invalid year: %s", y)
+		}
+		t.Year = year
+	}
+	switch q.Get("semester") {
+	case strconv.Itoa(sisparse.Winter):
+		t.Semester = sisparse.Winter
+	case strconv.Itoa(sisparse.Summer):
+		t.Semester = sisparse.Summer
+	}
+	return t, nil
+}
+
+// Splits a course given as <code> or <code>@<semester> (as to -diagnose
+// and -doctor-course) into its normalized code and the semester, which is
+// the current one of ctx if not given.
+func parseCourseTerm(ctx context.Context, s string) (string, int, error) {
+	code, sem := s, currentTerm(ctx).Semester
+	if i := strings.Index(s, "@"); i >= 0 {
+		code = s[:i]
+		switch s[i+1:] {
+		case strconv.Itoa(sisparse.Winter):
+			sem = sisparse.Winter
+		case strconv.Itoa(sisparse.Summer):
+			sem = sisparse.Summer
+		default:
+			return "", 0, fmt.Errorf("Invalid semester of %s, expected %d or %d", s, sisparse.Winter, sisparse.Summer)
+		}
+	}
+	return sisparse.NormalizeCourseCode(code), sem, nil
+}
+
+// SIS keeps the schedules of past years; the next one may already be there.
+func isValidYear(ctx context.Context, year int) bool {
+	return year >= 2000 && year <= currentTerm(ctx).Year+1
+}
@@ -0,0 +1,188 @@
+// The schedules of a course over several academic years, to see how its
+// timetable evolved, or what a course looked like when a returning student
+// last took it. Past years are cached under their own names (see
+// courseCacheName) for good, as SIS doesn't change them any more.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The most academic years of a timeline
+const MAX_TIMELINE_YEARS = 6
+
+// How many years a timeline covers when ?from= isn't given
+const DEFAULT_TIMELINE_YEARS = 4
+
+type courseTimeline struct {
+	Code     string         `json:"code"`
+	Semester int            `json:"semester"`
+	Years    []timelineYear `json:"years"`
+	// The differences between each year and the previous one which was found
+	Changes []timelineChange `json:"changes"`
+}
+
+type timelineYear struct {
+	Year int `json:"academic_year"`
+	// "ok", "not_found" (not taught that year), "pending" (being fetched,
+	// see fetchbudget.go) or "error"
+	Status string             `json:"status"`
+	Error  string             `json:"error,omitempty"`
+	Groups [][]sisparse.Event `json:"groups,omitempty"`
+}
+
+// Groups of SIS get new codes every year, so they are compared by when and
+// where their events are.
+type timelineChange struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+	// The groups which are new or gone, each as its events' slots, e.g.
+	// "lecture Monday 09:00-10:30 (every) S5"
+	Added     [][]string `json:"added"`
+	Removed   [][]string `json:"removed"`
+	Unchanged int        `json:"unchanged"`
+}
+
+// Answers GET /timeline/<code>?from=<year>&to=<year>&semester=<semester>
+// with {"data":courseTimeline}. The years default to the last
+// DEFAULT_TIMELINE_YEARS ending with the current one.
+func timelineHandler(w http.ResponseWriter, r *http.Request) {
+	code := sisparse.NormalizeCourseCode(strings.TrimPrefix(r.URL.Path, "/timeline/"))
+	if code == "" || strings.Contains(code, "/") {
+		fmt.Fprint(w, `{"error":"Expected /timeline/<code>"}`)
+		return
+	}
+	t, err := requestTerm(r)
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	to, from := currentTerm(r.Context()).Year, 0
+	q := r.URL.Query()
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"from", &from}, {"to", &to}} {
+		if s := q.Get(p.name); s != "" {
+			year, err := strconv.Atoi(s)
+			if err != nil || !isValidYear(r.Context(), year) {
+				fmt.Fprintf(w, `{"error":"Invalid year: %s"}`, s)
+				return
+			}
+			*p.value = year
+		}
+	}
+	if from == 0 {
+		from = to - DEFAULT_TIMELINE_YEARS + 1
+	}
+	if from > to || to-from+1 > MAX_TIMELINE_YEARS {
+		fmt.Fprintf(w, `{"error":"Expected at most %d years from ?from= to ?to="}`, MAX_TIMELINE_YEARS)
+		return
+	}
+
+	ctx := withFetchBudget(r.Context())
+	logf(ctx, "Timeline: %s %d-%d", ellipsis(code, 10), from, to)
+	res := courseTimeline{Code: code, Semester: t.Semester, Years: queryTimeline(ctx, code, t.Semester, from, to)}
+	res.Changes = diffTimeline(res.Years)
+	s, _ := json.Marshal(res)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+// Queries the course in each of the years, concurrently.
+func queryTimeline(ctx context.Context, code string, semester, from, to int) []timelineYear {
+	years := make([]timelineYear, to-from+1)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, BATCH_CONCURRENCY)
+	for i := range years {
+		wg.Add(1)
+		go func(y *timelineYear) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			res, err := queryCourse(sisparse.WithAcademicYear(ctx, y.Year), code, semester)
+			var cached struct {
+				Data [][]sisparse.Event `json:"data"`
+			}
+			if err == nil {
+				err = json.Unmarshal([]byte(res), &cached)
+			}
+			switch err {
+			case nil:
+				y.Status, y.Groups = "ok", cached.Data
+			case sisparse.ErrScheduleNotFound:
+				y.Status = "not_found"
+			case ErrFetchQueued:
+				y.Status = "pending"
+			default:
+				logf(ctx, "Timeline error for %s in %d: %s", code, y.Year, err)
+				y.Status, y.Error = "error", localizedError(ctx, err)["error"].(string)
+			}
+		}(&years[i])
+		years[i].Year = from + i
+	}
+	wg.Wait()
+	return years
+}
+
+func diffTimeline(years []timelineYear) []timelineChange {
+	changes := []timelineChange{}
+	var previous *timelineYear
+	for i := range years {
+		if years[i].Status != "ok" {
+			continue
+		}
+		if previous != nil {
+			changes = append(changes, diffYears(*previous, years[i]))
+		}
+		previous = &years[i]
+	}
+	return changes
+}
+
+func diffYears(older, newer timelineYear) timelineChange {
+	change := timelineChange{From: older.Year, To: newer.Year, Added: [][]string{}, Removed: [][]string{}}
+	before := map[string]int{}
+	for _, g := range older.Groups {
+		before[strings.Join(groupSlots(g), "; ")]++
+	}
+	for _, g := range newer.Groups {
+		slots := groupSlots(g)
+		key := strings.Join(slots, "; ")
+		if before[key] > 0 {
+			before[key]--
+			change.Unchanged++
+		} else {
+			change.Added = append(change.Added, slots)
+		}
+	}
+	for _, g := range older.Groups {
+		slots := groupSlots(g)
+		key := strings.Join(slots, "; ")
+		if before[key] > 0 {
+			before[key]--
+			change.Removed = append(change.Removed, slots)
+		}
+	}
+	return change
+}
+
+// Returns when and where the events of the group are, in order.
+func groupSlots(group []sisparse.Event) []string {
+	slots := []string{}
+	for _, e := range group {
+		slots = append(slots, strings.TrimSpace(fmt.Sprintf("%s %s %s-%s (%s) %s",
+			e.Type, time.Weekday((e.Day+1)%7), e.TimeFrom, e.TimeTo, e.WeekParity, e.Room)))
+	}
+	sort.Strings(slots)
+	return slots
+}
@@ -0,0 +1,110 @@
+// OpenTelemetry tracing of requests, so that slow requests can be broken
+// down into SIS fetches, parsing and solving.
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/iamwave/samorozvrh/server")
+
+// Sets up the tracer provider. Spans are exported over OTLP/HTTP to the
+// given endpoint (such as "localhost:4318"); without one, traces are
+// recorded only so that their IDs can be logged and returned to clients.
+// Returns a function which flushes the remaining spans.
+func setupTracing(endpoint string) (func(), error) {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "samorozvrh"))),
+	}
+	if endpoint != "" {
+		exporter, err := otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Printf("Could not flush traces: %s", err)
+		}
+	}, nil
+}
+
+// Wraps a handler so that each request gets its own span (continuing
+// the trace of the caller, if given in the traceparent header).
+// The trace ID is returned in the X-Trace-Id header.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+			))
+		defer span.End()
+
+		if id := traceId(ctx); id != "" {
+			w.Header().Set("X-Trace-Id", id)
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Returns the ID of the trace the context belongs to, or "" if there is none.
+func traceId(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Like log.Printf, but prefixes the message with the trace ID and the
+// request ID from ctx so that log lines can be matched with traces and
+// with the "ref" of error answers.
+func logf(ctx context.Context, format string, args ...interface{}) {
+	if id := requestId(ctx); id != "" {
+		format = fmt.Sprintf("[ref %s] %s", id, format)
+	}
+	if id := traceId(ctx); id != "" {
+		format = fmt.Sprintf("[%s] %s", id, format)
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,255 @@
+// Validating a schedule against SIS: the courses of a schedule the solver
+// made earlier are fetched from SIS again (not from the cache), and the
+// chosen events are compared with what SIS says now, so that students
+// learn about groups which moved or were cancelled since.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The most courses of a schedule validated at once, as each is fetched
+// from SIS
+const MAX_VALIDATED_COURSES = 30
+
+// A schedule to validate, e.g.
+//
+//	{"courses": [...],           // As in solve requests
+//	 "selection": [0, null, 1],  // The option chosen for each course, as "data" of solve answers
+//	 "year": 2024}               // The academic year, the current one by default
+type validateRequest struct {
+	Courses   []solveCourse `json:"courses"`
+	Selection []*int        `json:"selection"`
+	Year      int           `json:"year,omitempty"`
+}
+
+type validation struct {
+	// Whether all the chosen events are still as they were
+	Valid   bool               `json:"valid"`
+	Courses []courseValidation `json:"courses"`
+}
+
+// The chosen option of a course of the schedule compared with SIS
+type courseValidation struct {
+	// The index of the course in the request
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	Code  string `json:"code"`
+	// "unchanged", "changed" (see Events), "not_found" (SIS doesn't know
+	// the course any more) or "error" (it couldn't be fetched)
+	Status string           `json:"status"`
+	Error  string           `json:"error,omitempty"`
+	Events []validatedEvent `json:"events,omitempty"`
+}
+
+// An event of the chosen groups which is not as it was
+type validatedEvent struct {
+	// "moved" (to another time or room), "removed" or "added" (to the group)
+	Change string          `json:"change"`
+	Before *sisparse.Event `json:"before,omitempty"`
+	After  *sisparse.Event `json:"after,omitempty"`
+}
+
+// Answers POST /api/v1/validate (see validateRequest) with
+// {"data":validation}.
+func validateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use POST"}`)
+		return
+	}
+	ctx := r.Context()
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		logf(ctx, "Validate error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	var req validateRequest
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&req); err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	if sisparse.IsPaused() {
+		// Only the cache could be compared with
+		w.WriteHeader(http.StatusServiceUnavailable)
+		s, _ := json.Marshal(localizedError(ctx, sisparse.ErrPaused))
+		fmt.Fprint(w, string(s))
+		return
+	}
+	res, err := validateSchedule(ctx, req)
+	if err != nil {
+		logf(ctx, "Validate error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	s, _ := json.Marshal(res)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+func validateSchedule(ctx context.Context, req validateRequest) (validation, error) {
+	res := validation{Valid: true, Courses: []courseValidation{}}
+	if len(req.Selection) != len(req.Courses) {
+		return res, fmt.Errorf("Expected %d options in the selection, got %d", len(req.Courses), len(req.Selection))
+	}
+	if req.Year == 0 {
+		req.Year = currentTerm(ctx).Year
+	} else if !isValidYear(ctx, req.Year) {
+		return res, fmt.Errorf("Invalid year: %d", req.Year)
+	}
+	ctx = sisparse.WithAcademicYear(ctx, req.Year)
+
+	var chosen []courseValidation
+	events := map[int][]sisparse.Event{}
+	for i, c := range req.Courses {
+		if req.Selection[i] == nil {
+			continue
+		}
+		var options [][]sisparse.Event
+		if err := json.Unmarshal(c.Options, &options); err != nil {
+			return res, err
+		}
+		option := *req.Selection[i]
+		if option < 0 || option >= len(options) {
+			return res, fmt.Errorf("Invalid option %d of %s", option, c.Name)
+		}
+		code := c.CourseCode
+		if code == "" && len(options[option]) > 0 {
+			code = options[option][0].CourseCode
+		}
+		if code == "" {
+			return res, fmt.Errorf("The events of %s don't say its course code", c.Name)
+		}
+		chosen = append(chosen, courseValidation{Index: i, Name: c.Name, Code: code})
+		events[i] = options[option]
+	}
+	if len(chosen) > MAX_VALIDATED_COURSES {
+		return res, fmt.Errorf("At most %d courses can be validated at once", MAX_VALIDATED_COURSES)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, BATCH_CONCURRENCY)
+	for i := range chosen {
+		wg.Add(1)
+		go func(v *courseValidation) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			validateCourse(ctx, v, events[v.Index])
+		}(&chosen[i])
+	}
+	wg.Wait()
+	for _, v := range chosen {
+		res.Valid = res.Valid && v.Status == "unchanged"
+	}
+	res.Courses = chosen
+	return res, nil
+}
+
+// Fetches the course of the chosen events from SIS and compares them
+// with the groups they belong to now.
+func validateCourse(ctx context.Context, v *courseValidation, chosen []sisparse.Event) {
+	semester := sisparse.Winter
+	if len(chosen) > 0 && chosen[0].Semester != 0 {
+		semester = chosen[0].Semester
+	}
+	logf(ctx, "  %s (validating)", v.Code)
+	code := sisparse.NormalizeCourseCode(v.Code)
+	answer, err := fetchCourse(ctx, code, semester)
+	if err == nil && sisparse.AcademicYear(ctx) == currentTerm(ctx).Year {
+		// As queryCourse answered when the schedule was made
+		answer = applyOverride(ctx, code, semester, answer)
+	}
+	var fresh struct {
+		Data [][]sisparse.Event `json:"data"`
+	}
+	if err == nil {
+		err = json.Unmarshal([]byte(answer), &fresh)
+	}
+	if err == sisparse.ErrScheduleNotFound {
+		v.Status = "not_found"
+		return
+	} else if err != nil {
+		v.Status = "error"
+		v.Error = localizedError(ctx, err)["error"].(string)
+		return
+	}
+	v.Events = diffChosenEvents(chosen, groupsById(fresh.Data))
+	v.Status = "unchanged"
+	if len(v.Events) > 0 {
+		v.Status = "changed"
+	}
+}
+
+// Compares the chosen events with the groups of the course now. Events
+// are matched within their group by their SIS code (and their order among
+// the events with the same code, or without any).
+func diffChosenEvents(chosen []sisparse.Event, groups map[string][]sisparse.Event) []validatedEvent {
+	changes := []validatedEvent{}
+	seenGroups := map[string]bool{}
+	for _, e := range chosen {
+		if seenGroups[e.GroupID] {
+			continue
+		}
+		seenGroups[e.GroupID] = true
+		var group []sisparse.Event
+		for _, f := range chosen {
+			if f.GroupID == e.GroupID {
+				group = append(group, f)
+			}
+		}
+		now := groups[e.GroupID]
+		after := map[string]int{}
+		for j, key := range eventKeys(now) {
+			after[key] = j
+		}
+		matched := map[int]bool{}
+		for i, key := range eventKeys(group) {
+			old := group[i]
+			j, ok := after[key]
+			if !ok {
+				changes = append(changes, validatedEvent{Change: "removed", Before: &old})
+				continue
+			}
+			matched[j] = true
+			if !sameTimeAndPlace(old, now[j]) {
+				changes = append(changes, validatedEvent{Change: "moved", Before: &old, After: &now[j]})
+			}
+		}
+		for j := range now {
+			if !matched[j] {
+				changes = append(changes, validatedEvent{Change: "added", After: &now[j]})
+			}
+		}
+	}
+	return changes
+}
+
+// Returns the keys by which the events of a group are matched: their SIS
+// codes and their order among the events with the same code.
+func eventKeys(events []sisparse.Event) []string {
+	keys := make([]string, len(events))
+	seen := map[string]int{}
+	for i, e := range events {
+		keys[i] = fmt.Sprintf("%s#%d", e.Code, seen[e.Code])
+		seen[e.Code]++
+	}
+	return keys
+}
+
+func sameTimeAndPlace(a, b sisparse.Event) bool {
+	return a.Semester == b.Semester && a.Day == b.Day && a.TimeFrom == b.TimeFrom && a.TimeTo == b.TimeTo &&
+		a.WeekParity == b.WeekParity && a.WeekList() == b.WeekList() && a.Room == b.Room
+}
@@ -0,0 +1,204 @@
+// What-if analysis: whether a course can be added to (or removed from)
+// a schedule, and which other courses would have to change groups for it.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// The bonus for keeping each course of the schedule in its group (see
+// solveWeights), large enough for the solver to change as few courses as
+// possible and to only then care about the rest of its criteria
+const WHATIF_STABILITY = 100000
+
+// A schedule and the change to try, e.g.
+//
+//	{"courses": [...],           // As in evaluateRequest
+//	 "selection": [0, null, 1],
+//	 "add": {...},               // A course, as in solve requests
+//	 "remove": [2]}              // Indices of the courses to leave out
+//
+// At least one of add and remove has to be given.
+type whatIfRequest struct {
+	Courses   []solveCourse `json:"courses"`
+	Selection []*int        `json:"selection"`
+	Add       *solveCourse  `json:"add,omitempty"`
+	Remove    []int         `json:"remove,omitempty"`
+	Weights   solveWeights  `json:"weights"`
+}
+
+type whatIfAnswer struct {
+	// Whether the added course fits without changing any other course
+	Fits bool `json:"fits"`
+	// The best option of the added course, null if it can't be added at all
+	Option *int `json:"option"`
+	// What has to change in the schedule, the fewest courses possible
+	Changes []whatIfChange `json:"changes"`
+	// The new schedule, as "selection" with the added course last (and the
+	// removed ones null), and its score
+	Selection []*int             `json:"selection"`
+	Score     map[string]float64 `json:"score"`
+}
+
+type whatIfChange struct {
+	Course int    `json:"course"`
+	Name   string `json:"name"`
+	From   *int   `json:"from"`
+	// null if the course has to be left out
+	To *int `json:"to"`
+}
+
+// Answers POST /api/v1/whatif (see whatIfRequest) with {"data":whatIfAnswer}.
+func whatIfHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, `{"error":"Use POST"}`)
+		return
+	}
+	ctx := r.Context()
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		logf(ctx, "What-if error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, err)
+		return
+	}
+	var req whatIfRequest
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&req); err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	res, err := whatIf(r, req)
+	if err == ErrQuotaExceeded {
+		writeQuotaExceeded(w, ctx)
+		return
+	} else if err != nil {
+		logf(ctx, "What-if error: %s", err)
+		fmt.Fprintf(w, `{"error":"%s"}`, strings.Replace(err.Error(), `"`, `'`, -1))
+		return
+	}
+	s, _ := json.Marshal(res)
+	fmt.Fprintf(w, `{"data":%s}`, string(s))
+}
+
+// Re-solves the schedule with the change, the courses of the schedule
+// preferring their groups (by WHATIF_STABILITY) and the added course
+// being worth more than all of them together.
+func whatIf(r *http.Request, req whatIfRequest) (whatIfAnswer, error) {
+	var res whatIfAnswer
+	if len(req.Selection) != len(req.Courses) {
+		return res, fmt.Errorf("Expected %d options in the selection, got %d", len(req.Courses), len(req.Selection))
+	}
+	if req.Add == nil && len(req.Remove) == 0 {
+		return res, fmt.Errorf("Nothing to add or remove")
+	}
+	removed := map[int]bool{}
+	for _, i := range req.Remove {
+		if i < 0 || i >= len(req.Courses) {
+			return res, fmt.Errorf("Invalid course %d to remove", i)
+		}
+		removed[i] = true
+	}
+
+	// The courses left out of the schedule stay out, so that the solver
+	// doesn't add them now that there may be room for them
+	courses := []solveCourse{}
+	indices := []int{}
+	worth := 0.0
+	for i, c := range req.Courses {
+		if removed[i] || req.Selection[i] == nil {
+			continue
+		}
+		c.PreviousOption = req.Selection[i]
+		courses = append(courses, c)
+		indices = append(indices, i)
+		reward := 1.0
+		if c.Reward != nil {
+			reward = *c.Reward
+		}
+		worth += reward + WHATIF_STABILITY/100
+	}
+	if req.Add != nil {
+		add := *req.Add
+		add.PreviousOption = nil
+		add.Optional = false
+		reward := math.Ceil(worth) + 1
+		add.Reward = &reward
+		courses = append(courses, add)
+	}
+
+	if err := addObjectivePenalties(courses, req.Weights); err != nil {
+		return res, err
+	}
+	query, err := json.Marshal(courses)
+	if err != nil {
+		return res, err
+	}
+	stability := WHATIF_STABILITY
+	answer, err := Solve(r.Context(), query, solverOptions{
+		Stability:  &stability,
+		Balance:    req.Weights.Balance,
+		BackToBack: req.Weights.BackToBack,
+	})
+	if err != nil {
+		return res, err
+	}
+	var solved solveResponse
+	if err := json.Unmarshal(answer, &solved); err != nil {
+		return res, err
+	}
+	if solved.Error != "" {
+		return res, fmt.Errorf("%s", solved.Error)
+	}
+	if len(solved.Data) != len(courses) {
+		return res, fmt.Errorf("The solver returned %d options for %d courses", len(solved.Data), len(courses))
+	}
+
+	res = whatIfAnswer{
+		Changes:   []whatIfChange{},
+		Selection: make([]*int, len(req.Courses)),
+		Score:     solved.Score,
+	}
+	for i := range req.Courses {
+		if !removed[i] {
+			res.Selection[i] = req.Selection[i]
+		}
+	}
+	for _, i := range req.Remove {
+		if req.Selection[i] != nil {
+			res.Changes = append(res.Changes, whatIfChange{i, req.Courses[i].Name, req.Selection[i], nil})
+		}
+	}
+	changed := false
+	for j, i := range indices {
+		option := solved.Data[j]
+		if !sameOption(option, req.Selection[i]) {
+			changed = true
+			res.Changes = append(res.Changes, whatIfChange{i, req.Courses[i].Name, req.Selection[i], option})
+		}
+		res.Selection[i] = option
+	}
+	if req.Add != nil {
+		res.Option = solved.Data[len(courses)-1]
+		res.Selection = append(res.Selection, res.Option)
+		res.Fits = res.Option != nil && !changed
+	} else {
+		res.Fits = true
+	}
+	return res, nil
+}
+
+func sameOption(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
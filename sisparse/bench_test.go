@@ -0,0 +1,80 @@
+package sisparse
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// The course sets of the benchmarks, shared with the -benchmark of the server
+const benchDir = "../solver/fixtures/bench"
+
+type benchSet struct {
+	name  string
+	pages [][]byte
+	// The most parsing all pages of the set may take on average, 0 for no
+	// limit
+	budget time.Duration
+}
+
+// Renders the pages of the courses of each set of benchDir.
+func loadBenchSets(b *testing.B) []benchSet {
+	var budgets map[string]struct {
+		Parse int `json:"parse_ms"`
+	}
+	data, err := ioutil.ReadFile(filepath.Join(benchDir, "budgets.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &budgets)
+	}
+	if err != nil {
+		b.Fatalf("Could not read the budgets: %s", err)
+	}
+	files, err := filepath.Glob(filepath.Join(benchDir, "courses*.json"))
+	if err != nil || len(files) == 0 {
+		b.Fatalf("No course sets in %s: %v", benchDir, err)
+	}
+	sort.Strings(files)
+	sets := []benchSet{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			b.Fatal(err)
+		}
+		var courses []struct {
+			Options [][]Event `json:"options"`
+		}
+		if err := json.Unmarshal(data, &courses); err != nil {
+			b.Fatalf("%s: %s", file, err)
+		}
+		name := filepath.Base(file)
+		set := benchSet{name: name, budget: time.Duration(budgets[name].Parse) * time.Millisecond}
+		for _, c := range courses {
+			set.pages = append(set.pages, RenderSchedulePage(c.Options))
+		}
+		sets = append(sets, set)
+	}
+	return sets
+}
+
+// Parses the pages of each course set, failing if it takes longer than
+// the budget of the set.
+func BenchmarkParseSchedule(b *testing.B) {
+	for _, set := range loadBenchSets(b) {
+		b.Run(set.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, page := range set.pages {
+					if _, err := ParseSchedule(bytes.NewReader(page), "", 0); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			if per := b.Elapsed() / time.Duration(b.N); set.budget > 0 && per > set.budget {
+				b.Errorf("%s per run, over the budget of %s", per, set.budget)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package sisparse
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Returned instead of querying SIS while it is considered down.
+var ErrCircuitOpen = errors.New("SIS is unavailable, not querying it for a while")
+
+// The settings of the circuit breaker unless the Breaker option is given
+const (
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = time.Minute
+)
+
+const (
+	BreakerClosed   = "closed"    // SIS is queried as usual
+	BreakerOpen     = "open"      // SIS is not queried
+	BreakerHalfOpen = "half-open" // A request is checking whether SIS is back
+)
+
+type BreakerStatus struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"` // Consecutive
+	OpenedAt time.Time `json:"opened_at,omitempty"`
+	Opens    int64     `json:"opens"`    // How many times the breaker has opened
+	Rejected int64     `json:"rejected"` // Requests not made because it was open
+}
+
+func GetBreakerStatus() BreakerStatus {
+	return Default().GetBreakerStatus()
+}
+
+func (p *Parser) GetBreakerStatus() BreakerStatus {
+	p.breakerMu.Lock()
+	defer p.breakerMu.Unlock()
+	return p.breaker
+}
+
+// Reports whether a request to SIS may be made now. If it may, its result
+// must be reported with breakerReport.
+func (p *Parser) breakerAllow() bool {
+	p.breakerMu.Lock()
+	defer p.breakerMu.Unlock()
+	breaker := &p.breaker
+	switch breaker.State {
+	case BreakerOpen:
+		if time.Since(breaker.OpenedAt) < p.breakerCooldown {
+			breaker.Rejected++
+			return false
+		}
+		breaker.State = BreakerHalfOpen
+		return true
+	case BreakerHalfOpen:
+		// Another request is already checking
+		breaker.Rejected++
+		return false
+	}
+	return true
+}
+
+func (p *Parser) breakerReport(ctx context.Context, err error) {
+	p.breakerMu.Lock()
+	defer p.breakerMu.Unlock()
+	breaker := &p.breaker
+	if err == nil {
+		breaker.State = BreakerClosed
+		breaker.Failures = 0
+		return
+	}
+	if ctx.Err() != nil {
+		// Canceled by the client, which says nothing about SIS
+		if breaker.State == BreakerHalfOpen {
+			breaker.State = BreakerOpen
+		}
+		return
+	}
+	breaker.Failures++
+	if breaker.State == BreakerHalfOpen || (p.breakerThreshold > 0 && breaker.Failures >= p.breakerThreshold) {
+		if breaker.State != BreakerOpen {
+			breaker.Opens++
+		}
+		breaker.State = BreakerOpen
+		breaker.OpenedAt = time.Now()
+	}
+}
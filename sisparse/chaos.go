@@ -0,0 +1,115 @@
+package sisparse
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Faults injected into the requests to SIS, so that retries, failing over
+// to mirrors, the circuit breaker and serving stale cache can be tried out
+// end to end, e.g. in staging. Rates are fractions of the requests, from 0
+// to 1; a request may get several faults.
+type ChaosConfig struct {
+	// Requests delayed by a random time up to MaxDelay
+	DelayRate float64
+	MaxDelay  time.Duration
+	// Requests answered by a 503 without asking SIS
+	ErrorRate float64
+	// Pages cut off at a random point, as when a connection drops
+	TruncateRate float64
+}
+
+// Injects the faults into the requests to SIS. Never use in production.
+func Chaos(config ChaosConfig) Option {
+	return func(p *Parser) error {
+		p.chaos = config
+		return nil
+	}
+}
+
+// Parses faults such as "delay=0.2:3s,error=0.05,truncate=0.02" (each
+// optional): the rate of delays up to 3 seconds, of errors and of
+// truncated pages.
+func ParseChaos(s string) (ChaosConfig, error) {
+	var c ChaosConfig
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return c, fmt.Errorf("Expected <fault>=<rate>, got %q", part)
+		}
+		value := kv[1]
+		if kv[0] == "delay" {
+			rateDelay := strings.SplitN(value, ":", 2)
+			if len(rateDelay) != 2 {
+				return c, fmt.Errorf("Expected delay=<rate>:<longest delay>, got %q", part)
+			}
+			var err error
+			if c.MaxDelay, err = time.ParseDuration(rateDelay[1]); err != nil || c.MaxDelay <= 0 {
+				return c, fmt.Errorf("Invalid delay %q", rateDelay[1])
+			}
+			value = rateDelay[0]
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return c, fmt.Errorf("Invalid rate %q, expected 0 to 1", value)
+		}
+		switch kv[0] {
+		case "delay":
+			c.DelayRate = rate
+		case "error":
+			c.ErrorRate = rate
+		case "truncate":
+			c.TruncateRate = rate
+		default:
+			return c, fmt.Errorf("Unknown fault %q, expected delay, error or truncate", kv[0])
+		}
+	}
+	return c, nil
+}
+
+// Returns the faults injected by the default parser.
+func GetChaos() ChaosConfig {
+	return Default().GetChaos()
+}
+
+func (p *Parser) GetChaos() ChaosConfig {
+	return p.chaos
+}
+
+func (c ChaosConfig) Enabled() bool {
+	return c.DelayRate > 0 || c.ErrorRate > 0 || c.TruncateRate > 0
+}
+
+func (c ChaosConfig) String() string {
+	return fmt.Sprintf("delay=%g:%s,error=%g,truncate=%g", c.DelayRate, c.MaxDelay, c.ErrorRate, c.TruncateRate)
+}
+
+// Delays the request or makes it fail, as configured.
+func (c ChaosConfig) beforeFetch(ctx context.Context) error {
+	if c.DelayRate > 0 && rand.Float64() < c.DelayRate {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(rand.Int63n(int64(c.MaxDelay)) + 1)):
+		}
+	}
+	if c.ErrorRate > 0 && rand.Float64() < c.ErrorRate {
+		return fmt.Errorf("SIS returned 503 Service Unavailable (injected)")
+	}
+	return nil
+}
+
+// Cuts the page off, as configured.
+func (c ChaosConfig) afterFetch(body []byte) []byte {
+	if c.TruncateRate > 0 && len(body) > 0 && rand.Float64() < c.TruncateRate {
+		return body[:rand.Intn(len(body))]
+	}
+	return body
+}
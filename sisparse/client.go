@@ -0,0 +1,73 @@
+package sisparse
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Sent with every request so that the SIS operators know who is calling.
+const UserAgent = "Samorozvrh/1.0 (+https://github.com/iamwave/samorozvrh)"
+
+// The HTTP client shared by all requests of a Parser to SIS, so that
+// connections to it get reused.
+func newClient(proxy func(*http.Request) (*url.URL, error)) *http.Client {
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          16,
+		MaxIdleConnsPerHost:   8,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   60 * time.Second,
+	}
+}
+
+// Limits the rate of requests to SIS, see the RateLimit option.
+type RateLimiter interface {
+	// Blocks until a request may be made, or returns an error when ctx is done first.
+	Wait(ctx context.Context) error
+}
+
+type backgroundKey struct{}
+
+// Marks the requests to SIS made in the returned context as background
+// ones, such as those of crawling or of watching courses for changes,
+// which a RateLimiter may hold back more than those of live queries.
+func WithBackground(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+// Reports whether the requests made in ctx are background ones, see
+// WithBackground.
+func IsBackground(ctx context.Context) bool {
+	background, _ := ctx.Value(backgroundKey{}).(bool)
+	return background
+}
+
+func (p *Parser) get(ctx context.Context, url string) (*http.Response, error) {
+	if IsPaused() {
+		return nil, ErrPaused
+	}
+	if p.limiter != nil {
+		if err := p.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", UserAgent)
+	return p.sessionClient(getFetchSession(ctx)).Do(req)
+}
@@ -0,0 +1,84 @@
+package sisparse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A time of day as the number of minutes since midnight, without a date
+// or a timezone (SIS times are local times of the faculty). Events lasting
+// until midnight end at 24:00; events never go past it (see splitAtMidnight).
+type ClockTime int
+
+// The end of the day, 24:00
+const midnight ClockTime = 24 * 60
+
+// The longest event we believe SIS means (a day-long block of a block
+// course is 480 minutes or so); longer durations are mistakes.
+const maxEventDuration = 16 * 60
+
+func NewClockTime(hour, minute int) ClockTime {
+	return ClockTime(hour*60 + minute)
+}
+
+// Parses a time of day in the form "hh:mm" (or "h:mm").
+func ParseClockTime(s string) (ClockTime, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("Invalid time %q, expected hh:mm", s)
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 {
+		return 0, fmt.Errorf("Invalid time %q, expected hh:mm", s)
+	}
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("Invalid time %q, expected hh:mm", s)
+	}
+	return NewClockTime(hour, minute), nil
+}
+
+func (c ClockTime) Minutes() int {
+	return int(c)
+}
+
+// The hour, which is 24 for midnight at the end of the day.
+func (c ClockTime) Hour() int {
+	return int(c) / 60
+}
+
+func (c ClockTime) Minute() int {
+	return int(c) % 60
+}
+
+func (c ClockTime) Add(minutes int) ClockTime {
+	return c + ClockTime(minutes)
+}
+
+// Formats the time as "hh:mm" (ISO 8601 local time).
+func (c ClockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", c.Hour(), c.Minute())
+}
+
+func (c ClockTime) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *ClockTime) UnmarshalText(text []byte) error {
+	t, err := ParseClockTime(string(text))
+	if err != nil {
+		return err
+	}
+	*c = t
+	return nil
+}
+
+// Returns the time on the given date in the given location. Days on which
+// the clocks change are handled as by time.Date, and 24:00 is the midnight
+// starting the next day.
+func (c ClockTime) On(date time.Time, loc *time.Location) time.Time {
+	y, m, d := date.Date()
+	return time.Date(y, m, d, c.Hour(), c.Minute(), 0, 0, loc)
+}
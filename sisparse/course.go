@@ -0,0 +1,78 @@
+package sisparse
+
+import (
+	"context"
+)
+
+// A course with its groups split by what they are. A student enrolls in
+// exactly one of the LectureGroups and exactly one of the SeminarGroups
+// (if the course has any of either), unless SeminarOptional.
+type Course struct {
+	Code          string    `json:"code"`
+	Name          string    `json:"name"`
+	Semester      int       `json:"semester"`
+	LectureGroups [][]Event `json:"lecture_groups"`
+	SeminarGroups [][]Event `json:"seminar_groups"`
+	// Whether the student may attend just the lectures, see Event.Optional
+	SeminarOptional bool `json:"seminar_optional,omitempty"`
+}
+
+// Sorts the groups returned by GetSemesterCourseEvents into a Course.
+// The type of a group is the type of its first event.
+func NewCourse(code string, semester int, groups [][]Event) Course {
+	c := Course{
+		Code:          code,
+		Semester:      semester,
+		LectureGroups: [][]Event{},
+		SeminarGroups: [][]Event{},
+	}
+	for _, g := range groups {
+		if len(g) == 0 {
+			continue
+		}
+		if c.Name == "" {
+			c.Name = g[0].Name
+		}
+		if g[0].Type == Lecture {
+			c.LectureGroups = append(c.LectureGroups, g)
+		} else {
+			c.SeminarGroups = append(c.SeminarGroups, g)
+			c.SeminarOptional = g[0].Optional
+		}
+	}
+	return c
+}
+
+// Like GetSemesterCourseEvents, but returns the course as a Course.
+func GetCourse(ctx context.Context, courseCode string, semester int) (Course, error) {
+	return Default().GetCourse(ctx, courseCode, semester)
+}
+
+func (p *Parser) GetCourse(ctx context.Context, courseCode string, semester int) (Course, error) {
+	groups, err := p.GetSemesterCourseEvents(ctx, courseCode, semester)
+	if err != nil {
+		return Course{}, err
+	}
+	return NewCourse(courseCode, semester, groups), nil
+}
+
+// Returns all groups of the course, the lecture groups first.
+func (c Course) Groups() [][]Event {
+	res := [][]Event{}
+	res = append(res, c.LectureGroups...)
+	return append(res, c.SeminarGroups...)
+}
+
+// The components of the course, i.e. the kinds of groups it has, of which
+// the student must enroll in one each (save for an optional seminar):
+// Lecture, Seminar or both.
+func (c Course) Components() []EventType {
+	res := []EventType{}
+	if len(c.LectureGroups) > 0 {
+		res = append(res, Lecture)
+	}
+	if len(c.SeminarGroups) > 0 {
+		res = append(res, Seminar)
+	}
+	return res
+}
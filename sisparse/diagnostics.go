@@ -0,0 +1,127 @@
+package sisparse
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// What the parser made of a schedule page, row by row, for finding out why
+// a course shows wrong times.
+type Diagnostics struct {
+	Url    string   `json:"url,omitempty"`
+	Header []string `json:"header"`
+	// The column of each field, see columnLayout
+	Columns map[string]int `json:"columns"`
+	// "header" if the columns were found by the header, "guessed" if the
+	// header changed and they were guessed (see the LayoutFallback option)
+	Layout      string          `json:"layout"`
+	LayoutError string          `json:"layout_error,omitempty"`
+	Rows        []RowDiagnostic `json:"rows"`
+}
+
+type RowDiagnostic struct {
+	Row int `json:"row"`
+	// The raw contents of the cells of the fields, by field
+	Cells map[string]string `json:"cells"`
+	// The rules of the parser which applied to the row, e.g. "new group"
+	Rules []string `json:"rules"`
+	// Why the row was skipped, or what is suspicious about it
+	Warnings []string `json:"warnings,omitempty"`
+	Skipped  bool     `json:"skipped"`
+	// The events made of the row (two if it is split at midnight)
+	Events []Event `json:"events,omitempty"`
+}
+
+func (l columnLayout) columns() map[string]int {
+	res := map[string]int{
+		"type":     l.Type,
+		"name":     l.Name,
+		"teacher":  l.Teacher,
+		"day_time": l.DayTime,
+		"duration": l.Duration,
+		"code":     l.Code,
+		"capacity": l.Capacity,
+		"note":     l.Note,
+		"room":     l.Room,
+	}
+	for name, col := range res {
+		if col < 0 {
+			delete(res, name)
+		}
+	}
+	return res
+}
+
+func (d *Diagnostics) addRow(i int, row tableRow, layout columnLayout) *RowDiagnostic {
+	if d == nil {
+		return nil
+	}
+	rd := RowDiagnostic{Row: i + 1, Cells: map[string]string{}, Rules: []string{}}
+	for name, col := range layout.columns() {
+		if col < len(row.Cells) {
+			rd.Cells[name] = row.Cells[col]
+		}
+	}
+	d.Rows = append(d.Rows, rd)
+	return &d.Rows[len(d.Rows)-1]
+}
+
+func (r *RowDiagnostic) rule(format string, args ...interface{}) {
+	if r != nil {
+		r.Rules = append(r.Rules, fmt.Sprintf(format, args...))
+	}
+}
+
+func (r *RowDiagnostic) warn(format string, args ...interface{}) {
+	if r != nil {
+		r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+	}
+}
+
+// Like ParseSchedule, but also says what the parser did with each row.
+func DiagnoseSchedule(body io.Reader, courseCode string, semester int) ([][]Event, *Diagnostics, error) {
+	return Default().DiagnoseSchedule(body, courseCode, semester)
+}
+
+func (p *Parser) DiagnoseSchedule(body io.Reader, courseCode string, semester int) ([][]Event, *Diagnostics, error) {
+	diag := &Diagnostics{}
+	events, _, err := p.parseCourseEvents(body, Lenient, diag)
+	if err != nil {
+		return nil, diag, err
+	}
+	setCourse(events, courseCode, semester)
+	diag.setEvents(events)
+	return events, diag, nil
+}
+
+// Like GetSemesterCourseEvents, but also says what the parser did with
+// each row of the schedule page.
+func DiagnoseCourseEvents(ctx context.Context, courseCode string, semester int) ([][]Event, *Diagnostics, error) {
+	return Default().DiagnoseCourseEvents(ctx, courseCode, semester)
+}
+
+func (p *Parser) DiagnoseCourseEvents(ctx context.Context, courseCode string, semester int) ([][]Event, *Diagnostics, error) {
+	diag := &Diagnostics{}
+	events, _, err := p.getCourseEvents(ctx, courseCode, semester, diag)
+	return events, diag, err
+}
+
+// Replaces the events of the rows by the same events with their course and
+// group filled in. The groups have the events in the order of the rows.
+func (d *Diagnostics) setEvents(groups [][]Event) {
+	if d == nil {
+		return
+	}
+	var all []Event
+	for _, group := range groups {
+		all = append(all, group...)
+	}
+	for i := range d.Rows {
+		n := len(d.Rows[i].Events)
+		if n > len(all) {
+			return
+		}
+		d.Rows[i].Events, all = all[:n], all[n:]
+	}
+}
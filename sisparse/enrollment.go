@@ -0,0 +1,242 @@
+package sisparse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+
+	"github.com/yhat/scrape"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// The central login of the university. SIS accepts its tickets when we come
+// from the login page with SIS as the "service".
+const casLoginUrl = "https://cas.cuni.cz/cas/login"
+
+// The list of the courses the logged-in student has enrolled in ("Zápis
+// předmětů a rozvrhu" -> "Zapsané"), relative to the SIS base URL.
+const enrolledCoursesPath = "/zapis/index.php?do=zapsane"
+
+// The page of the enrollment module where students enroll in the events
+// of a course, relative to the SIS base URL.
+const enrollCoursePath = "/zapis/index.php?do=zapis&kod=%s"
+
+// Returned by Login when CAS rejects the credentials.
+var ErrLoginFailed = errors.New("Login failed, check the login and password")
+
+// A logged-in session in SIS. It has its own cookies, so that the sessions
+// of different students are kept apart.
+type Session struct {
+	client  *http.Client
+	baseUrl string
+}
+
+// An event of a course the student is enrolled in.
+type EnrolledEvent struct {
+	Type     EventType
+	Day      int
+	TimeFrom ClockTime
+}
+
+// A course the student is enrolled in, with the events they have chosen
+// (empty if they are enrolled in the course but not in any of its events).
+type EnrolledCourse struct {
+	Code   string
+	Name   string
+	Events []EnrolledEvent
+}
+
+// Returns a link to the page where the student enrolls in the events of
+// the course. It points to the primary SIS URL, as that's what students
+// are used to (and logged in to).
+func GetEnrollmentUrl(courseCode string) string {
+	return Default().GetEnrollmentUrl(courseCode)
+}
+
+func (p *Parser) GetEnrollmentUrl(courseCode string) string {
+	p.mirrorsMu.Lock()
+	defer p.mirrorsMu.Unlock()
+	return p.mirrors[0].Url + fmt.Sprintf(enrollCoursePath, url.QueryEscape(courseCode))
+}
+
+// Logs in to SIS through CAS. The credentials are only sent to CAS.
+func Login(ctx context.Context, login, password string) (*Session, error) {
+	return Default().Login(ctx, login, password)
+}
+
+func (p *Parser) Login(ctx context.Context, login, password string) (*Session, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	baseUrl := p.getMirrorsByHealth(ctx)[0].Url
+	s := &Session{
+		client: &http.Client{
+			Transport: p.client.Transport,
+			Timeout:   p.client.Timeout,
+			Jar:       jar,
+		},
+		baseUrl: baseUrl,
+	}
+
+	loginPageUrl := casLoginUrl + "?service=" + url.QueryEscape(baseUrl+"/")
+	body, err := s.do(ctx, "GET", loginPageUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	action, form, err := parseLoginForm(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	form.Set("username", login)
+	form.Set("password", password)
+
+	// CAS redirects us back to SIS with a ticket, which SIS exchanges
+	// for its session cookie. If the login fails, we get the form again.
+	actionUrl, err := getAbsoluteUrl(loginPageUrl, action)
+	if err != nil {
+		return nil, err
+	}
+	body, err = s.do(ctx, "POST", actionUrl, form)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := parseLoginForm(bytes.NewReader(body)); err == nil {
+		return nil, ErrLoginFailed
+	}
+	return s, nil
+}
+
+// Returns the courses the student is enrolled in in the current semester.
+func (s *Session) GetEnrolledCourses(ctx context.Context) ([]EnrolledCourse, error) {
+	body, err := s.do(ctx, "GET", s.baseUrl+enrolledCoursesPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseEnrolledCourses(bytes.NewReader(body))
+}
+
+func (s *Session) do(ctx context.Context, method, url string, form url.Values) ([]byte, error) {
+	if IsPaused() {
+		return nil, ErrPaused
+	}
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	// Including the redirects, which keep the context
+	req = req.WithContext(withPrivate(ctx))
+	req.Header.Set("User-Agent", UserAgent)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("SIS returned %s", resp.Status)
+	}
+	r, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// Finds the form with a password field and returns its action and the
+// values of its fields (including the hidden ones CAS needs back).
+func parseLoginForm(body io.Reader) (string, url.Values, error) {
+	root, err := html.Parse(body)
+	if err != nil {
+		return "", nil, err
+	}
+	isPasswordInput := func(n *html.Node) bool {
+		return n.DataAtom == atom.Input && scrape.Attr(n, "type") == "password"
+	}
+	for _, form := range scrape.FindAll(root, scrape.ByTag(atom.Form)) {
+		if _, ok := scrape.Find(form, isPasswordInput); !ok {
+			continue
+		}
+		values := url.Values{}
+		for _, input := range scrape.FindAll(form, scrape.ByTag(atom.Input)) {
+			if name := scrape.Attr(input, "name"); name != "" {
+				values.Set(name, scrape.Attr(input, "value"))
+			}
+		}
+		return scrape.Attr(form, "action"), values, nil
+	}
+	return "", nil, errors.New("Couldn't find the login form")
+}
+
+func parseEnrolledCourses(body io.Reader) ([]EnrolledCourse, error) {
+	root, err := html.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each row of the table is either a course (with a link to it) or one
+	// of the events the student has chosen, belonging to the course above.
+	res := []EnrolledCourse{}
+	for _, row := range scrape.FindAll(root, scrape.ByTag(atom.Tr)) {
+		link, ok := scrape.Find(row, func(n *html.Node) bool {
+			return n.DataAtom == atom.A && strings.Contains(scrape.Attr(n, "href"), "do=predmet") &&
+				IsCourseCode(normalizeText(scrape.Text(n)))
+		})
+		if ok {
+			res = append(res, EnrolledCourse{
+				Code:   normalizeText(scrape.Text(link)),
+				Name:   getPlanCourseName(link),
+				Events: []EnrolledEvent{},
+			})
+			continue
+		}
+		if len(res) == 0 {
+			continue
+		}
+		if e, ok := parseEnrolledEvent(getRowCells(row)); ok {
+			course := &res[len(res)-1]
+			course.Events = append(course.Events, e)
+		}
+	}
+	if len(res) == 0 {
+		return nil, errors.New("Couldn't find any enrolled courses")
+	}
+	return res, nil
+}
+
+func parseEnrolledEvent(cols []string) (EnrolledEvent, bool) {
+	e := EnrolledEvent{Type: Seminar}
+	found := false
+	for _, col := range cols {
+		switch {
+		case col == "P":
+			e.Type = Lecture
+		case dayTimeRegexp.MatchString(col):
+			var err error
+			daytime := []rune(col)
+			if e.Day, err = parseDay(string(daytime[:2])); err != nil {
+				return e, false
+			}
+			if e.TimeFrom, err = ParseClockTime(string(daytime[3:])); err != nil {
+				return e, false
+			}
+			found = true
+		}
+	}
+	return e, found
+}
@@ -2,39 +2,294 @@ package sisparse
 
 import (
 	"encoding/json"
-	"time"
+	"fmt"
 )
 
+// The version of the JSON representation of Event. Increase it whenever
+// the representation changes in a way clients need to know about, or when
+// events from before lack something new: the server's cache is kept by the
+// version, so courses are fetched again then. Version 2 added the SIS codes
+// of events.
+const EventSchemaVersion = 2
+
+type EventType string
+
+const (
+	Lecture EventType = "lecture" // "přednáška", P in SIS
+	Seminar EventType = "seminar" // "cvičení" or "seminář", X in SIS
+)
+
+// Converts the type of an event as shown in SIS to an EventType.
+func parseEventType(s string) EventType {
+	if s == "P" {
+		return Lecture
+	}
+	return Seminar
+}
+
+// Whether an event takes place every week or every other one. SIS counts
+// odd and even weeks of teaching (the first week of the semester is odd),
+// which needn't be odd and even weeks of the year.
+type WeekParity int
+
+const (
+	EveryWeek WeekParity = 0
+	OddWeeks  WeekParity = 1
+	EvenWeeks WeekParity = 2
+)
+
+var weekParityNames = []string{"every", "odd", "even"}
+
+func (p WeekParity) String() string {
+	if p < 0 || int(p) >= len(weekParityNames) {
+		return fmt.Sprintf("WeekParity(%d)", int(p))
+	}
+	return weekParityNames[p]
+}
+
+func (p WeekParity) MarshalText() ([]byte, error) {
+	if p < 0 || int(p) >= len(weekParityNames) {
+		return nil, fmt.Errorf("Invalid week parity %d", int(p))
+	}
+	return []byte(weekParityNames[p]), nil
+}
+
+func (p *WeekParity) UnmarshalText(text []byte) error {
+	for i, name := range weekParityNames {
+		if name == string(text) {
+			*p = WeekParity(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid week parity %q", string(text))
+}
+
 type Event struct {
-	Type       string
-	Name       string
-	Teacher    string
-	Day        int
-	TimeFrom   time.Time
-	TimeTo     time.Time
-	WeekParity int // Every week = 0; Odd weeks = 1; Even weeks = 2
+	CourseCode string
+	// The code of the event in SIS (such as "18aNPRG030p1"), by which
+	// students enroll in it. Empty if unknown.
+	Code string
+	// Identifies the group (the events which must be enrolled together)
+	// among the groups of the course: the Code of its first event, or an ID
+	// made up from the course code, semester and order of the group
+	GroupID  string
+	Semester int // Winter or Summer, 0 if unknown
+	Type     EventType
+	Name     string
+	Teacher  string
+	// The ID of the teacher in SIS, which (unlike the name) is the same
+	// in all courses; empty if unknown
+	TeacherID string
+	Day       int    // Monday = 0
+	Room      string // As named in SIS (e.g. "S3"), empty if unknown
+	TimeFrom  ClockTime
+	TimeTo    ClockTime
+	// The parity of the teaching weeks the event takes place in
+	WeekParity WeekParity
+	// The parity of the calendar (ISO) weeks the event takes place in, if
+	// SIS says it (as in "Sudé týdny (liché kalendářní)"), else EveryWeek.
+	// It is what the event really follows when the two disagree, see
+	// calendar.Semester.TakesPlace.
+	CalendarParity WeekParity
+	// The teaching weeks (from 1, sorted) the event takes place in, when
+	// SIS lists them (as in "Týdny 2, 4, 8") instead of a parity, see
+	// TakesPlaceInWeek. Lists of all odd or all even weeks are left as
+	// the parity, and other lists set it if their weeks share one.
+	Weeks []int
+	// The maximum number of students, 0 if unlimited or unknown
+	Capacity int
+	// The number of students already enrolled (when Capacity is known)
+	Enrolled int
+	// The note of the event in SIS as it is, and what it says in a structured form
+	Note     string
+	NoteInfo NoteInfo
+	// The language of instruction, "cs" or "en" (empty if unknown), from
+	// the note or else from the course page
+	Language string
+	// Whether students may leave out the group's component of the course,
+	// e.g. the seminar of a course examined just by an exam
+	Optional bool
+	// Whether the event was added or corrected by hand rather than taken
+	// from SIS as it is (see the overrides of the server)
+	Patched bool
+	// Whether SIS marks the event as cancelled (its row struck through, or
+	// by its note), e.g. in a parallel which won't be opened. Cancelled
+	// events don't take place; meetings cancelled on single days are in
+	// NoteInfo.CancelledOn instead.
+	Cancelled bool
+}
+
+// Reports whether the event has no free places left.
+func (e Event) IsFull() bool {
+	return e.Capacity > 0 && e.Enrolled >= e.Capacity
+}
+
+// Reports whether all the events of the group are cancelled, so that the
+// group doesn't take place.
+func GroupCancelled(group []Event) bool {
+	for _, e := range group {
+		if !e.Cancelled {
+			return false
+		}
+	}
+	return len(group) > 0
+}
+
+type eventJSON struct {
+	SchemaVersion int        `json:"schema_version"`
+	CourseCode    string     `json:"course_code,omitempty"`
+	Code          string     `json:"code,omitempty"`
+	GroupID       string     `json:"group_id,omitempty"`
+	Semester      int        `json:"semester,omitempty"`
+	Type          EventType  `json:"type"`
+	Name          string     `json:"name"`
+	Teacher       string     `json:"teacher"`
+	TeacherID     string     `json:"teacher_id,omitempty"`
+	Day           int        `json:"day"`
+	Room          string     `json:"room,omitempty"`
+	TimeFrom      string     `json:"time_from"`
+	TimeTo        string     `json:"time_to"`
+	WeekParity    WeekParity `json:"week_parity"`
+	// Left out when unknown, to keep the schema version
+	CalendarParity WeekParity `json:"calendar_parity,omitempty"`
+	Weeks          []int      `json:"weeks,omitempty"`
+	Capacity       int        `json:"capacity,omitempty"`
+	Enrolled       int        `json:"enrolled,omitempty"`
+	Note           string     `json:"note,omitempty"`
+	NoteInfo       *NoteInfo  `json:"note_info,omitempty"`
+	Language       string     `json:"language,omitempty"`
+	Optional       bool       `json:"optional,omitempty"`
+	Patched        bool       `json:"patched,omitempty"`
+	Cancelled      bool       `json:"cancelled,omitempty"`
+}
+
+// Events from before the schema was versioned had the SIS type
+// and a numeric week parity.
+type eventJSONv0 struct {
+	Type       string `json:"type"`
+	WeekParity int    `json:"week_parity"`
 }
 
 func (e Event) MarshalJSON() ([]byte, error) {
-	return json.Marshal(&struct {
-		Type       string `json:"type"`
-		Name       string `json:"name"`
-		Teacher    string `json:"teacher"`
-		Day        int    `json:"day"`
-		TimeFrom   string `json:"time_from"`
-		TimeTo     string `json:"time_to"`
-		WeekParity int    `json:"week_parity"`
-	}{
-		Type:       e.Type,
-		Name:       e.Name,
-		Teacher:    e.Teacher,
-		Day:        e.Day,
-		TimeFrom:   e.TimeFrom.Format("15:04"),
-		TimeTo:     e.TimeTo.Format("15:04"),
-		WeekParity: e.WeekParity,
+	var noteInfo *NoteInfo
+	if !e.NoteInfo.IsEmpty() {
+		noteInfo = &e.NoteInfo
+	}
+	return json.Marshal(eventJSON{
+		SchemaVersion:  EventSchemaVersion,
+		CourseCode:     e.CourseCode,
+		Code:           e.Code,
+		GroupID:        e.GroupID,
+		Semester:       e.Semester,
+		Type:           e.Type,
+		Name:           e.Name,
+		Teacher:        e.Teacher,
+		TeacherID:      e.TeacherID,
+		Day:            e.Day,
+		Room:           e.Room,
+		TimeFrom:       e.TimeFrom.String(),
+		TimeTo:         e.TimeTo.String(),
+		WeekParity:     e.WeekParity,
+		CalendarParity: e.CalendarParity,
+		Weeks:          e.Weeks,
+		Capacity:       e.Capacity,
+		Enrolled:       e.Enrolled,
+		Note:           e.Note,
+		NoteInfo:       noteInfo,
+		Language:       e.Language,
+		Optional:       e.Optional,
+		Patched:        e.Patched,
+		Cancelled:      e.Cancelled,
 	})
 }
 
-// func JSONDecodeEvent(s *string) Event {
-// 	return {}
-// }
+func (e *Event) UnmarshalJSON(data []byte) error {
+	var version struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &version); err != nil {
+		return err
+	}
+
+	var ej eventJSON
+	switch version.SchemaVersion {
+	case 0:
+		var old eventJSONv0
+		if err := json.Unmarshal(data, &old); err != nil {
+			return err
+		}
+		// Decode the rest, skipping the fields which changed
+		var rest struct {
+			eventJSON
+			Type       json.RawMessage `json:"type"`
+			WeekParity json.RawMessage `json:"week_parity"`
+		}
+		if err := json.Unmarshal(data, &rest); err != nil {
+			return err
+		}
+		ej = rest.eventJSON
+		ej.Type = parseEventType(old.Type)
+		ej.WeekParity = WeekParity(old.WeekParity)
+	case 1, EventSchemaVersion:
+		// Version 1 is the same, only without codes
+		if err := json.Unmarshal(data, &ej); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unsupported event schema version %d", version.SchemaVersion)
+	}
+
+	if ej.Type != Lecture && ej.Type != Seminar {
+		return fmt.Errorf("Invalid event type %q", ej.Type)
+	}
+	if ej.Day < 0 || ej.Day > 6 {
+		return fmt.Errorf("Invalid day %d", ej.Day)
+	}
+	for _, p := range []WeekParity{ej.WeekParity, ej.CalendarParity} {
+		if p < EveryWeek || p > EvenWeeks {
+			return fmt.Errorf("Invalid week parity %d", int(p))
+		}
+	}
+	timeFrom, err := ParseClockTime(ej.TimeFrom)
+	if err != nil {
+		return err
+	}
+	timeTo, err := ParseClockTime(ej.TimeTo)
+	if err != nil {
+		return err
+	}
+	for i, w := range ej.Weeks {
+		if w < 1 || w > TeachingWeeks || (i > 0 && w <= ej.Weeks[i-1]) {
+			return fmt.Errorf("Invalid weeks %v", ej.Weeks)
+		}
+	}
+
+	*e = Event{
+		CourseCode:     ej.CourseCode,
+		Code:           ej.Code,
+		GroupID:        ej.GroupID,
+		Semester:       ej.Semester,
+		Type:           ej.Type,
+		Name:           ej.Name,
+		Teacher:        ej.Teacher,
+		TeacherID:      ej.TeacherID,
+		Day:            ej.Day,
+		Room:           ej.Room,
+		TimeFrom:       timeFrom,
+		TimeTo:         timeTo,
+		WeekParity:     ej.WeekParity,
+		CalendarParity: ej.CalendarParity,
+		Weeks:          ej.Weeks,
+		Capacity:       ej.Capacity,
+		Enrolled:       ej.Enrolled,
+		Note:           ej.Note,
+		Language:       ej.Language,
+		Optional:       ej.Optional,
+		Patched:        ej.Patched,
+		Cancelled:      ej.Cancelled,
+	}
+	if ej.NoteInfo != nil {
+		e.NoteInfo = *ej.NoteInfo
+	}
+	return nil
+}
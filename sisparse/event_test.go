@@ -0,0 +1,147 @@
+package sisparse
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEventJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+	}{
+		{"minimal", Event{Type: Seminar, TimeFrom: NewClockTime(9, 0), TimeTo: NewClockTime(10, 30)}},
+		{"full", Event{
+			CourseCode:     "NPRG030",
+			Code:           "24aNPRG030x01",
+			GroupID:        "24aNPRG030x01",
+			Semester:       Winter,
+			Type:           Lecture,
+			Name:           "Programování I",
+			Teacher:        "Jan Novák",
+			TeacherID:      "12345",
+			Day:            4,
+			Room:           "S3",
+			TimeFrom:       NewClockTime(14, 0),
+			TimeTo:         NewClockTime(15, 30),
+			WeekParity:     OddWeeks,
+			CalendarParity: EvenWeeks,
+			Capacity:       30,
+			Enrolled:       12,
+			Note:           "v angličtině, 12.3. odpadá",
+			NoteInfo:       NoteInfo{Language: "en", CancelledOn: []string{"03-12"}},
+			Language:       "en",
+			Optional:       true,
+			Patched:        true,
+		}},
+		{"weeks", Event{Type: Seminar, Day: 2, TimeFrom: NewClockTime(12, 20), TimeTo: NewClockTime(13, 50), Weeks: []int{2, 4, 8}}},
+		{"cancelled", Event{Type: Seminar, TimeFrom: NewClockTime(8, 0), TimeTo: NewClockTime(24, 0), Cancelled: true,
+			NoteInfo: NoteInfo{Cancelled: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.event)
+			if err != nil {
+				t.Fatalf("Marshal: %s", err)
+			}
+			var got Event
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("Unmarshal of %s: %s", data, err)
+			}
+			if !reflect.DeepEqual(got, tt.event) {
+				t.Errorf("Round trip of %s:\ngot  %+v\nwant %+v", data, got, tt.event)
+			}
+		})
+	}
+}
+
+func TestEventUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want Event
+		// A part of the error, "" if the event is valid
+		err string
+	}{
+		{
+			name: "v0 lecture",
+			json: `{"type":"P","name":"Algebra","day":1,"time_from":"9:00","time_to":"10:30","week_parity":0}`,
+			want: Event{Type: Lecture, Name: "Algebra", Day: 1, TimeFrom: NewClockTime(9, 0), TimeTo: NewClockTime(10, 30)},
+		},
+		{
+			name: "v0 seminar of even weeks",
+			json: `{"type":"X","day":3,"time_from":"15:40","time_to":"17:10","week_parity":2,"room":"S5"}`,
+			want: Event{Type: Seminar, Day: 3, Room: "S5", TimeFrom: NewClockTime(15, 40), TimeTo: NewClockTime(17, 10), WeekParity: EvenWeeks},
+		},
+		{
+			name: "v0 invalid parity",
+			json: `{"type":"X","day":3,"time_from":"15:40","time_to":"17:10","week_parity":3}`,
+			err:  "Invalid week parity",
+		},
+		{
+			name: "v0 invalid time",
+			json: `{"type":"X","day":3,"time_from":"15.40","time_to":"17:10","week_parity":0}`,
+			err:  "Invalid time",
+		},
+		{
+			name: "v1",
+			json: `{"schema_version":1,"type":"seminar","day":0,"time_from":"10:40","time_to":"12:10","week_parity":"odd"}`,
+			want: Event{Type: Seminar, TimeFrom: NewClockTime(10, 40), TimeTo: NewClockTime(12, 10), WeekParity: OddWeeks},
+		},
+		{
+			name: "v2",
+			json: `{"schema_version":2,"code":"24aNPRG030x01","type":"seminar","day":0,"time_from":"10:40","time_to":"12:10","week_parity":"odd"}`,
+			want: Event{Code: "24aNPRG030x01", Type: Seminar, TimeFrom: NewClockTime(10, 40), TimeTo: NewClockTime(12, 10), WeekParity: OddWeeks},
+		},
+		{
+			name: "v1 invalid parity",
+			json: `{"schema_version":1,"type":"seminar","day":0,"time_from":"10:40","time_to":"12:10","week_parity":"weekly"}`,
+			err:  "Invalid week parity",
+		},
+		{
+			name: "v1 numeric parity",
+			json: `{"schema_version":1,"type":"seminar","day":0,"time_from":"10:40","time_to":"12:10","week_parity":1}`,
+			err:  "cannot unmarshal",
+		},
+		{
+			name: "v1 SIS type",
+			json: `{"schema_version":1,"type":"P","day":0,"time_from":"10:40","time_to":"12:10","week_parity":"every"}`,
+			err:  "Invalid event type",
+		},
+		{
+			name: "v1 invalid day",
+			json: `{"schema_version":1,"type":"lecture","day":7,"time_from":"10:40","time_to":"12:10","week_parity":"every"}`,
+			err:  "Invalid day",
+		},
+		{
+			name: "v1 invalid weeks",
+			json: `{"schema_version":1,"type":"lecture","day":0,"time_from":"10:40","time_to":"12:10","week_parity":"every","weeks":[4,2]}`,
+			err:  "Invalid weeks",
+		},
+		{
+			name: "unknown version",
+			json: `{"schema_version":99,"type":"lecture","day":0,"time_from":"10:40","time_to":"12:10","week_parity":"every"}`,
+			err:  "Unsupported event schema version",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Event
+			err := json.Unmarshal([]byte(tt.json), &got)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Expected an error with %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got  %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
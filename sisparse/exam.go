@@ -0,0 +1,148 @@
+package sisparse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The exam dates of a course published in the SIS "Exam dates" module
+// ("Termíny zkoušek - prohlížení"), relative to the SIS base URL.
+const examPath = "/term_st2/index.php?do=predmet&kod=%s&skr=%d&sem=%d"
+
+// The layout of Exam.Date
+const ExamDateLayout = "2006-01-02"
+
+// An exam date ("termín") of a course. Students sign up for one of the
+// dates of each course.
+type Exam struct {
+	CourseCode string `json:"course_code"`
+	// In the form of ExamDateLayout, a local date of the faculty
+	Date     string    `json:"date"`
+	TimeFrom ClockTime `json:"time_from"`
+	Room     string    `json:"room,omitempty"`
+	Examiner string    `json:"examiner,omitempty"`
+	// As in SIS, e.g. "zkouška" or "zápočet"
+	Type string `json:"type,omitempty"`
+	// The maximum number of students (0 if unlimited or unknown) and the
+	// number of students signed up
+	Capacity int `json:"capacity,omitempty"`
+	Enrolled int `json:"enrolled,omitempty"`
+}
+
+// Returns the day of the exam, at midnight UTC.
+func (e Exam) Day() (time.Time, error) {
+	return time.Parse(ExamDateLayout, e.Date)
+}
+
+// The header cells of the exam table: the date (sometimes with the time in
+// the same cell) is required, the rest is optional.
+const (
+	examDateHeader     = "Datum"
+	examTimeHeader     = "Čas"
+	examRoomHeader     = "Místnost"
+	examExaminerHeader = "Zkoušející"
+	examTypeHeader     = "Typ"
+	examCapacityHeader = "Kapacita"
+)
+
+var examDateRegexp = regexp.MustCompile(`(\d{1,2})\.\s*(\d{1,2})\.\s*(\d{4})`)
+var examTimeRegexp = regexp.MustCompile(`\d{1,2}:\d{2}`)
+
+// Returns the exam dates of the course in the semester (Winter or Summer)
+// of the academic year of ctx, sorted by date.
+func GetExams(ctx context.Context, courseCode string, semester int) ([]Exam, error) {
+	return Default().GetExams(ctx, courseCode, semester)
+}
+
+func (p *Parser) GetExams(ctx context.Context, courseCode string, semester int) ([]Exam, error) {
+	ctx, span := tracer.Start(ctx, "sisparse.GetExams")
+	defer span.End()
+	body, url, err := p.fetchSis(withFetchSession(ctx), fmt.Sprintf(examPath, courseCode, p.AcademicYear(ctx), semester))
+	if err != nil {
+		return nil, err
+	}
+	p.takeSnapshot(courseCode, url, body)
+	exams, err := parseExams(bytes.NewReader(body))
+	if layoutErr, ok := err.(*LayoutError); ok {
+		layoutErr.Url = url
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range exams {
+		exams[i].CourseCode = courseCode
+	}
+	return exams, nil
+}
+
+// Parses the table of exam dates, skipping the rows without a valid date.
+// A page without the table means the course has no exam dates.
+func parseExams(body io.Reader) ([]Exam, error) {
+	header, rows, found, err := readTableRows(body, "table1", "head1")
+	if err != nil {
+		return nil, err
+	}
+	res := []Exam{}
+	if !found {
+		return res, nil
+	}
+	column := func(name string) int {
+		for i, h := range header {
+			if strings.HasPrefix(h, name) {
+				return i
+			}
+		}
+		return -1
+	}
+	date := column(examDateHeader)
+	if date < 0 {
+		return nil, &LayoutError{Reason: "the exam table has no date column", Expected: []string{examDateHeader}, Found: header}
+	}
+	clock, room, examiner := column(examTimeHeader), column(examRoomHeader), column(examExaminerHeader)
+	typ, capacity := column(examTypeHeader), column(examCapacityHeader)
+	cell := func(row tableRow, i int) string {
+		if i < 0 || i >= len(row.Cells) {
+			return ""
+		}
+		return row.Cells[i]
+	}
+
+	for _, row := range rows {
+		m := examDateRegexp.FindStringSubmatch(cell(row, date))
+		if m == nil {
+			continue
+		}
+		day, err := time.Parse("2.1.2006", fmt.Sprintf("%s.%s.%s", m[1], m[2], m[3]))
+		if err != nil {
+			continue
+		}
+		e := Exam{
+			Date:     day.Format(ExamDateLayout),
+			Room:     cell(row, room),
+			Examiner: cell(row, examiner),
+			Type:     cell(row, typ),
+		}
+		timeText := examTimeRegexp.FindString(cell(row, clock))
+		if timeText == "" {
+			timeText = examTimeRegexp.FindString(cell(row, date))
+		}
+		if timeText != "" {
+			e.TimeFrom, _ = ParseClockTime(timeText)
+		}
+		e.Enrolled, e.Capacity = parseCapacity(cell(row, capacity))
+		res = append(res, e)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Date != res[j].Date {
+			return res[i].Date < res[j].Date
+		}
+		return res[i].TimeFrom < res[j].TimeFrom
+	})
+	return res, nil
+}
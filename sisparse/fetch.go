@@ -0,0 +1,87 @@
+package sisparse
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Fetches the page at the given URL and returns its content converted
+// to UTF-8. SIS pages are not always UTF-8 (some are served as
+// Windows-1250), so we detect the encoding from the Content-Type header
+// and <meta> tags of the page.
+func (p *Parser) fetch(ctx context.Context, url string) ([]byte, error) {
+	ctx, span := tracer.Start(ctx, "sisparse.fetch", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.url", url)))
+	defer span.End()
+
+	if err := p.chaos.beforeFetch(ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	resp, err := p.get(ctx, url)
+	if err == nil && redirectedElsewhere(resp, url) {
+		// Probably a session bootstrap page, which has set the cookies we
+		// need; going to the page again should get us there this time
+		if s := getFetchSession(ctx); s != nil && s.shouldRetry() {
+			span.AddEvent("retry after redirect", trace.WithAttributes(attribute.String("http.redirected_to", resp.Request.URL.String())))
+			resp.Body.Close()
+			resp, err = p.get(ctx, url)
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+		return nil, fmt.Errorf("SIS returned %s", resp.Status)
+	}
+
+	r, err := charset.NewReader(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(r)
+	return p.chaos.afterFetch(body), err
+}
+
+// Reports whether the request for the URL was redirected to another page
+// (not merely to another scheme or host, such as from http to https).
+func redirectedElsewhere(resp *http.Response, requested string) bool {
+	u, err := url.Parse(requested)
+	if err != nil || resp.Request == nil {
+		return false
+	}
+	final := resp.Request.URL
+	return final.Path != u.Path || final.RawQuery != u.RawQuery
+}
+
+// See the Snapshots option.
+func (p *Parser) takeSnapshot(courseCode string, url string, body []byte) {
+	if p.snapshotHook != nil {
+		p.snapshotHook(courseCode, url, body)
+	}
+}
+
+// Normalizes a string extracted from a page so that it can be compared
+// reliably: the same letter may be encoded as a single precomposed rune
+// ("Ú") or as a letter followed by a combining mark, and names often
+// contain non-breaking spaces.
+func normalizeText(s string) string {
+	s = strings.Replace(s, "\u00a0", " ", -1)
+	return strings.TrimSpace(norm.NFC.String(s))
+}
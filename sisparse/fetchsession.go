@@ -0,0 +1,83 @@
+package sisparse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
+)
+
+// SIS gives up on a chain of redirects longer than this
+const maxRedirects = 10
+
+// Requests to SIS made on behalf of one query (such as the course page and
+// then its schedule) share their cookies, as some views of SIS need
+// a session, which they set up by redirecting through a bootstrap page.
+type fetchSession struct {
+	jar http.CookieJar
+
+	mu sync.Mutex
+	// Whether a request has already been repeated after being redirected
+	// elsewhere, see Parser.fetch
+	retried bool
+}
+
+type fetchSessionKey struct{}
+
+// Returns a context in which the requests to SIS share a fetch session,
+// the one of ctx if it already has one.
+func withFetchSession(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(fetchSessionKey{}).(*fetchSession); ok {
+		return ctx
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		// Only fails with invalid options
+		panic(err)
+	}
+	return context.WithValue(ctx, fetchSessionKey{}, &fetchSession{jar: jar})
+}
+
+func getFetchSession(ctx context.Context) *fetchSession {
+	s, _ := ctx.Value(fetchSessionKey{}).(*fetchSession)
+	return s
+}
+
+// Reports whether a request redirected elsewhere should be repeated, which
+// is only done once per session.
+func (s *fetchSession) shouldRetry() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.retried {
+		return false
+	}
+	s.retried = true
+	return true
+}
+
+// Returns the client for the requests of the session (with no cookies if
+// it is nil), sharing the connections of the parser's client.
+func (p *Parser) sessionClient(s *fetchSession) *http.Client {
+	c := &http.Client{
+		Transport:     p.client.Transport,
+		Timeout:       p.client.Timeout,
+		CheckRedirect: checkRedirect,
+	}
+	if s != nil {
+		c.Jar = s.jar
+	}
+	return c
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		chain := []string{}
+		for _, r := range via {
+			chain = append(chain, r.URL.String())
+		}
+		return fmt.Errorf("SIS redirected too many times: %s -> %s", strings.Join(chain, " -> "), req.URL)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package sisparse
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "write the parsed fixture pages to their golden files")
+
+// Compares what the fixture page parses into (the groups and their times,
+// week parities, cancellations and the rest) with testdata/schedule.golden.json.
+// After a deliberate change of the parser, go test -run Golden -update
+// writes the file anew, to be checked by hand.
+func TestParseCourseEventsGolden(t *testing.T) {
+	groups, _, err := ParseCourseEventsHTML(bytes.NewReader(readTestPage(t, schedulePage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.MarshalIndent(groups, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = append(got, '\n')
+	golden := filepath.Join("testdata", "schedule.golden.json")
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s parses into\n%s\nwant\n%s", schedulePage, got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package sisparse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Events are compared by what they say rather than by their Go values:
+// the occupancy (Capacity, Enrolled), which changes all the time during
+// enrollment, is not a part of what the event is.
+
+// Returns the fields of the event which make up its identity, in a fixed
+// order and format.
+func (e Event) canonical() []string {
+	return []string{
+		e.CourseCode,
+		e.Code,
+		e.GroupID,
+		fmt.Sprint(e.Semester),
+		string(e.Type),
+		e.Name,
+		e.Teacher,
+		e.TeacherID,
+		fmt.Sprint(e.Day),
+		e.TimeFrom.String(),
+		e.TimeTo.String(),
+		weekParityKey(e),
+		e.Room,
+		e.Note,
+		e.Language,
+		statusKey(e),
+	}
+}
+
+// Returns a hash of the content of the event (without its occupancy),
+// which stays the same as long as the event does, e.g. across restarts
+// and JSON round trips. Usable as a key of caches or to detect changes.
+func (e Event) Hash() string {
+	h := sha256.New()
+	for _, f := range e.canonical() {
+		// The separator can't appear in the fields, so they can't run together
+		fmt.Fprintf(h, "%s\x00", f)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// Reports whether the events have the same content (ignoring their occupancy).
+func (e Event) Equal(f Event) bool {
+	a, b := e.canonical(), f.canonical()
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Reports whether the events take place at the same time (in the same
+// semester and weeks) and are of the same type, whatever else has changed.
+func (e Event) SameSlot(f Event) bool {
+	return e.Semester == f.Semester && e.Type == f.Type && e.Day == f.Day &&
+		e.WeekParity == f.WeekParity && e.CalendarParity == f.CalendarParity && e.WeekList() == f.WeekList() &&
+		e.TimeFrom == f.TimeFrom && e.TimeTo == f.TimeTo
+}
+
+// Returns a hash of the content of the group which doesn't depend on the
+// order of its events.
+func GroupHash(group []Event) string {
+	hashes := make([]string, len(group))
+	for i, e := range group {
+		hashes[i] = e.Hash()
+	}
+	sort.Strings(hashes)
+	sum := sha256.Sum256([]byte(strings.Join(hashes, ",")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Reports whether the groups contain the same events, in any order.
+func GroupsEqual(a, b []Event) bool {
+	return len(a) == len(b) && GroupHash(a) == GroupHash(b)
+}
+
+// The calendar parity and the weeks are only added when known, so that the
+// hashes of other events stay as they were before they were.
+// The optional and cancelled flags, written so that events which aren't
+// cancelled keep the keys they had before it was known
+func statusKey(e Event) string {
+	key := fmt.Sprint(e.Optional)
+	if e.Cancelled {
+		key += "/cancelled"
+	}
+	return key
+}
+
+func weekParityKey(e Event) string {
+	key := e.WeekParity.String()
+	if e.CalendarParity != EveryWeek {
+		key += "/" + e.CalendarParity.String() + " calendar"
+	}
+	if len(e.Weeks) > 0 {
+		key += "/weeks " + e.WeekList()
+	}
+	return key
+}
@@ -0,0 +1,184 @@
+package sisparse
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Returned (wrapped in a *LayoutError) when the schedule table on a SIS page
+// doesn't look the way the parser expects, which usually means SIS has
+// changed its HTML and the parser needs updating.
+var ErrLayoutChanged = errors.New("The layout of the SIS schedule page has changed")
+
+type LayoutError struct {
+	Url      string   // The page which failed the check, if known
+	Reason   string   // What exactly didn't match
+	Expected []string // The expected table header
+	Found    []string // The table header actually present on the page
+}
+
+func (e *LayoutError) Error() string {
+	msg := fmt.Sprintf("%s: %s (expected header [%s], found [%s])",
+		ErrLayoutChanged, e.Reason, strings.Join(e.Expected, ", "), strings.Join(e.Found, ", "))
+	if e.Url != "" {
+		msg += " at " + e.Url
+	}
+	return msg
+}
+
+func (e *LayoutError) Unwrap() error {
+	return ErrLayoutChanged
+}
+
+// Positions of the fields we need within a row of the schedule table.
+type columnLayout struct {
+	Code     int // Optional, -1 if missing
+	Capacity int // Optional, -1 if missing
+	Note     int // Optional, -1 if missing
+	Room     int // Optional, -1 if missing
+	Type     int
+	Name     int
+	Teacher  int
+	DayTime  int
+	Duration int
+}
+
+func (l columnLayout) maxIndex() int {
+	max := 0
+	for _, i := range []int{l.Type, l.Name, l.Teacher, l.DayTime, l.Duration} {
+		if i > max {
+			max = i
+		}
+	}
+	return max
+}
+
+// The names of the header cells of the columns we need. SIS sometimes
+// appends details to them ("Den, čas"), so a header only has to start
+// with the name.
+var expectedHeader = []string{"Typ", "Název", "Vyučující", "Den", "Délka"}
+
+// The headers of the columns we can do without: the codes of the events,
+// their capacity, notes and rooms.
+const (
+	codeHeader     = "Kód"
+	capacityHeader = "Kapacita"
+	noteHeader     = "Poznámka"
+	roomHeader     = "Místnost"
+)
+
+var dayTimeRegexp = regexp.MustCompile(`^(Po|Út|St|Čt|Pá|So|Ne) \d{1,2}:\d{2}$`)
+var durationRegexp = regexp.MustCompile(`^\d+( |$)`)
+
+// Finds the columns we need by the names in the table header.
+func checkLayout(header []string) (columnLayout, error) {
+	if len(header) == 0 {
+		return columnLayout{}, &LayoutError{Reason: "the table has no header", Expected: expectedHeader}
+	}
+
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(name)
+		if _, ok := index[name]; !ok {
+			index[name] = i
+		}
+	}
+	lookup := func(name string) (int, bool) {
+		name = strings.ToLower(name)
+		if i, ok := index[name]; ok {
+			return i, true
+		}
+		for i, h := range header {
+			if strings.HasPrefix(strings.ToLower(h), name) {
+				return i, true
+			}
+		}
+		return -1, false
+	}
+
+	layout := columnLayout{Code: -1, Capacity: -1, Note: -1, Room: -1}
+	if col, ok := lookup(codeHeader); ok {
+		layout.Code = col
+	}
+	if col, ok := lookup(capacityHeader); ok {
+		layout.Capacity = col
+	}
+	if col, ok := lookup(noteHeader); ok {
+		layout.Note = col
+	}
+	if col, ok := lookup(roomHeader); ok {
+		layout.Room = col
+	}
+	fields := []*int{&layout.Type, &layout.Name, &layout.Teacher, &layout.DayTime, &layout.Duration}
+	var missing []string
+	for i, name := range expectedHeader {
+		col, ok := lookup(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		*fields[i] = col
+	}
+	if len(missing) > 0 {
+		return layout, &LayoutError{
+			Reason:   "missing columns " + strings.Join(missing, ", "),
+			Expected: expectedHeader,
+			Found:    header,
+		}
+	}
+	return layout, nil
+}
+
+// Tries to find out which columns contain which fields by looking at their
+// contents. The day and time column is easy to recognize; the duration
+// follows it and the type, name and teacher precede it, as they always did.
+func guessLayout(rows [][]string) (columnLayout, bool) {
+	dayTime := findColumn(rows, 0, dayTimeRegexp)
+	if dayTime < 3 {
+		return columnLayout{}, false
+	}
+	duration := findColumn(rows, dayTime+1, durationRegexp)
+	if duration < 0 {
+		return columnLayout{}, false
+	}
+	return columnLayout{
+		Code:     -1,
+		Capacity: -1,
+		Note:     -1,
+		Room:     -1,
+		Type:     dayTime - 3,
+		Name:     dayTime - 2,
+		Teacher:  dayTime - 1,
+		DayTime:  dayTime,
+		Duration: duration,
+	}, true
+}
+
+// Returns the first column (at least from) in which all non-empty cells
+// match the regexp, or -1 if there is none.
+func findColumn(rows [][]string, from int, re *regexp.Regexp) int {
+	for col := from; ; col++ {
+		found, present := false, false
+		for _, row := range rows {
+			if col >= len(row) {
+				continue
+			}
+			present = true
+			if row[col] == "" {
+				continue
+			}
+			if !re.MatchString(row[col]) {
+				found = false
+				break
+			}
+			found = true
+		}
+		if !present {
+			return -1
+		}
+		if found {
+			return col
+		}
+	}
+}
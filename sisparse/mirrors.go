@@ -0,0 +1,168 @@
+package sisparse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// After this long, a mirror which failed is tried again in its usual order.
+const mirrorRetryAfter = 10 * time.Minute
+
+type mirror struct {
+	Url         string
+	Failures    int // Consecutive failures
+	LastFailure time.Time
+	LastSuccess time.Time
+}
+
+// The health of a SIS mirror, as seen by our recent requests to it.
+type MirrorStatus struct {
+	Url         string    `json:"url"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LastSuccess time.Time `json:"last_success"`
+}
+
+type baseUrlsKey struct{}
+
+// Returns a context in which SIS is queried at the given base URLs instead
+// of those of the parser (see the BaseUrls option), e.g. for another
+// faculty with its own SIS. Their health is tracked as that of the others.
+func WithBaseUrls(ctx context.Context, urls []string) context.Context {
+	return context.WithValue(ctx, baseUrlsKey{}, urls)
+}
+
+// Returns the mirrors to use in ctx; the caller must hold p.mirrorsMu.
+func (p *Parser) getMirrors(ctx context.Context) []*mirror {
+	urls, ok := ctx.Value(baseUrlsKey{}).([]string)
+	if !ok {
+		return p.mirrors
+	}
+	res := []*mirror{}
+	for _, u := range urls {
+		u = strings.TrimRight(u, "/")
+		m := p.contextMirrors[u]
+		for _, configured := range p.mirrors {
+			if configured.Url == u {
+				m = configured
+			}
+		}
+		if m == nil {
+			m = &mirror{Url: u}
+			p.contextMirrors[u] = m
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// Returns the health of all configured mirrors, in the configured order.
+func GetMirrorStatus() []MirrorStatus {
+	return Default().GetMirrorStatus()
+}
+
+func (p *Parser) GetMirrorStatus() []MirrorStatus {
+	p.mirrorsMu.Lock()
+	defer p.mirrorsMu.Unlock()
+	res := []MirrorStatus{}
+	for _, m := range p.mirrors {
+		res = append(res, MirrorStatus(*m))
+	}
+	return res
+}
+
+// Returns the time of the last successful request to any of the mirrors
+// (zero if there was none yet).
+func GetLastSuccess() time.Time {
+	return Default().GetLastSuccess()
+}
+
+func (p *Parser) GetLastSuccess() time.Time {
+	p.mirrorsMu.Lock()
+	defer p.mirrorsMu.Unlock()
+	var res time.Time
+	for _, m := range p.mirrors {
+		if m.LastSuccess.After(res) {
+			res = m.LastSuccess
+		}
+	}
+	return res
+}
+
+// Fetches a SIS page given by its path relative to the base URL,
+// trying the mirrors from the healthiest one. Returns the content
+// and the absolute URL it was fetched from. Fails with ErrCircuitOpen
+// without trying when SIS has been failing, see the Breaker option, and
+// with ErrPaused when it is paused.
+func (p *Parser) fetchSis(ctx context.Context, relative string) ([]byte, string, error) {
+	if IsPaused() {
+		return nil, "", ErrPaused
+	}
+	if !p.breakerAllow() {
+		return nil, "", ErrCircuitOpen
+	}
+	body, url, err := p.fetchMirrors(ctx, relative)
+	p.breakerReport(ctx, err)
+	return body, url, err
+}
+
+func (p *Parser) fetchMirrors(ctx context.Context, relative string) ([]byte, string, error) {
+	var errs []string
+	for _, m := range p.getMirrorsByHealth(ctx) {
+		url := m.Url + relative
+		body, err := p.fetch(ctx, url)
+		p.reportMirrorResult(m, err)
+		if err == nil {
+			return body, url, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	if len(errs) == 0 {
+		return nil, "", fmt.Errorf("No SIS base URLs are configured")
+	}
+	return nil, "", fmt.Errorf("All SIS mirrors failed: %s", strings.Join(errs, "; "))
+}
+
+// Returns the path of an absolute URL relative to the mirror (of ctx) it
+// points to, or false if it doesn't point to any of the mirrors.
+func (p *Parser) getMirrorRelativePath(ctx context.Context, url string) (string, bool) {
+	p.mirrorsMu.Lock()
+	defer p.mirrorsMu.Unlock()
+	for _, m := range p.getMirrors(ctx) {
+		if strings.HasPrefix(url, m.Url+"/") {
+			return url[len(m.Url):], true
+		}
+	}
+	return "", false
+}
+
+func (p *Parser) getMirrorsByHealth(ctx context.Context) []*mirror {
+	p.mirrorsMu.Lock()
+	defer p.mirrorsMu.Unlock()
+	now := time.Now()
+	isHealthy := func(m *mirror) bool {
+		return m.Failures == 0 || now.Sub(m.LastFailure) > mirrorRetryAfter
+	}
+	configured := p.getMirrors(ctx)
+	res := make([]*mirror, len(configured))
+	copy(res, configured)
+	sort.SliceStable(res, func(i, j int) bool {
+		return isHealthy(res[i]) && !isHealthy(res[j])
+	})
+	return res
+}
+
+func (p *Parser) reportMirrorResult(m *mirror, err error) {
+	p.mirrorsMu.Lock()
+	defer p.mirrorsMu.Unlock()
+	if err != nil {
+		m.Failures++
+		m.LastFailure = time.Now()
+	} else {
+		m.Failures = 0
+		m.LastSuccess = time.Now()
+	}
+}
@@ -0,0 +1,87 @@
+package sisparse
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// What we could make out of the note of an event ("Poznámka" in SIS),
+// which is free text written by the faculty.
+type NoteInfo struct {
+	// The day teaching starts as "MM-DD" (the note doesn't give the year),
+	// from notes such as "výuka od 15.3."
+	StartsFrom string `json:"starts_from,omitempty"`
+	// "cs" or "en", from notes such as "v angličtině"
+	Language string `json:"language,omitempty"`
+	// Who the event is meant for, from notes such as "pouze pro 1. ročník"
+	Audience string `json:"audience,omitempty"`
+	// Whether the event doesn't take place at all, from notes such as
+	// "zrušeno" or "paralelka se neotevírá"
+	Cancelled bool `json:"cancelled,omitempty"`
+	// The days ("MM-DD") on which the event doesn't take place, from notes
+	// such as "12.3. odpadá" or "zrušeno 5.11. a 12.11."
+	CancelledOn []string `json:"cancelled_on,omitempty"`
+}
+
+func (n NoteInfo) IsEmpty() bool {
+	return n.StartsFrom == "" && n.Language == "" && n.Audience == "" && !n.Cancelled && len(n.CancelledOn) == 0
+}
+
+var noteStartRegexp = regexp.MustCompile(`(?i)(?:výuk[ay]|začíná|zahájení|od)\D{0,12}?(\d{1,2})\.\s*(\d{1,2})\.`)
+
+// Words by which notes cancel the event, or with dates, its meetings on
+// those days
+const noteCancelWords = `(?:zrušen[aáoéý]?|odpadá|odpadne|nekoná|neotevírá|neotevře|neotevřen[aáoý]?|nebude otevřen[aáoý]?|cancell?ed)`
+const noteDates = `((?:\d{1,2}\.\s*\d{1,2}\.[\s,a]*(?:and\s*)?)+)`
+
+var noteCancelRegexp = regexp.MustCompile(`(?i)` + noteCancelWords)
+var noteCancelledOnRegexp = regexp.MustCompile(`(?i)` + noteCancelWords + `\s*(?:se\s*)?(?:dne|on)?\s*` + noteDates + `|` + noteDates + `\s*(?:se\s*)?` + noteCancelWords)
+var noteDateRegexp = regexp.MustCompile(`(\d{1,2})\.\s*(\d{1,2})\.`)
+var noteAudienceRegexp = regexp.MustCompile(`(?i)(?:pouze|jen|určeno|určená|určený) pro ([^,;()]+)`)
+
+// Words by which notes announce the language of instruction
+var noteLanguages = []struct {
+	Language string
+	Words    []string
+}{
+	{"en", []string{"angličtin", "anglick", "english"}},
+	{"cs", []string{"češtin", "česky", "český", "czech"}},
+}
+
+// Recognizes the few structured patterns of notes, leaving out what it
+// doesn't understand.
+func parseNote(note string) NoteInfo {
+	var info NoteInfo
+	if m := noteStartRegexp.FindStringSubmatch(note); m != nil {
+		day, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		if day >= 1 && day <= 31 && month >= 1 && month <= 12 {
+			info.StartsFrom = fmt.Sprintf("%02d-%02d", month, day)
+		}
+	}
+	lower := strings.ToLower(note)
+	for _, l := range noteLanguages {
+		for _, w := range l.Words {
+			if strings.Contains(lower, w) && info.Language == "" {
+				info.Language = l.Language
+			}
+		}
+	}
+	if m := noteAudienceRegexp.FindStringSubmatch(note); m != nil {
+		info.Audience = strings.TrimSpace(m[1])
+	}
+	for _, m := range noteCancelledOnRegexp.FindAllStringSubmatch(note, -1) {
+		for _, d := range noteDateRegexp.FindAllStringSubmatch(m[1]+m[2], -1) {
+			day, _ := strconv.Atoi(d[1])
+			month, _ := strconv.Atoi(d[2])
+			if day >= 1 && day <= 31 && month >= 1 && month <= 12 {
+				info.CancelledOn = append(info.CancelledOn, fmt.Sprintf("%02d-%02d", month, day))
+			}
+		}
+	}
+	// Cancelling words without dates cancel the whole event
+	info.Cancelled = len(info.CancelledOn) == 0 && noteCancelRegexp.MatchString(note)
+	return info
+}
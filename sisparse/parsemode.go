@@ -0,0 +1,64 @@
+package sisparse
+
+import (
+	"context"
+	"fmt"
+)
+
+// What the parser does with a malformed row of a schedule table.
+type ParseMode int
+
+const (
+	// Skip the row, but say why in a Warning
+	Lenient ParseMode = iota
+	// Fail the whole course with a *RowError, e.g. for tests and for
+	// checking that the parser still understands all of SIS
+	Strict
+)
+
+type parseModeKey struct{}
+
+// Returns a context in which schedules are parsed in the given mode.
+func WithParseMode(ctx context.Context, mode ParseMode) context.Context {
+	return context.WithValue(ctx, parseModeKey{}, mode)
+}
+
+// Returns the mode schedules are parsed in within ctx by the default
+// parser, for parsing pages from elsewhere (see ParseScheduleWarnings).
+func ModeOf(ctx context.Context) ParseMode {
+	return Default().parseMode(ctx)
+}
+
+// Returns the mode set by WithParseMode, or the parser's default one.
+func (p *Parser) parseMode(ctx context.Context) ParseMode {
+	if mode, ok := ctx.Value(parseModeKey{}).(ParseMode); ok {
+		return mode
+	}
+	return p.mode
+}
+
+// A malformed row of a schedule table, which was skipped in Lenient mode
+// (or, if Skipped is false, parsed as well as possible).
+type Warning struct {
+	Row     int    `json:"row"` // Numbered from 1, not counting the header
+	Message string `json:"message"`
+	Skipped bool   `json:"skipped"`
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("Row %d: %s", w.Row, w.Message)
+}
+
+// Returned in Strict mode for the first malformed row.
+type RowError struct {
+	Row int
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("Malformed row %d of the schedule: %s", e.Row, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,167 @@
+package sisparse
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Queries and parses SIS with a configuration of its own: its base URLs,
+// proxy, rate limit, circuit breaker and so on. A Parser is safe for
+// concurrent use, and several can be used in one process, each keeping
+// the health of its mirrors apart.
+//
+// The functions of the package use the default parser, see SetDefault.
+type Parser struct {
+	client  *http.Client
+	limiter RateLimiter
+
+	mirrorsMu sync.Mutex
+	mirrors   []*mirror
+	// Mirrors given by contexts (see WithBaseUrls) rather than by the
+	// BaseUrls option, by URL
+	contextMirrors map[string]*mirror
+
+	breakerMu        sync.Mutex
+	breaker          BreakerStatus
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	snapshotHook   func(courseCode string, url string, body []byte)
+	layoutFallback bool
+	mode           ParseMode
+	detectTerm     func(now time.Time) (year int, semester int)
+	chaos          ChaosConfig
+	shadowName     string
+	shadow         ScheduleParser
+	shadowReport   func(ShadowResult)
+}
+
+// Configures a Parser, see NewParser.
+type Option func(p *Parser) error
+
+// Returns a parser of the SIS of Charles University, configured by the
+// options applied in order.
+func NewParser(options ...Option) (*Parser, error) {
+	p := &Parser{
+		client:           newClient(http.ProxyFromEnvironment),
+		mirrors:          []*mirror{{Url: "https://is.cuni.cz/studium"}},
+		contextMirrors:   map[string]*mirror{},
+		breaker:          BreakerStatus{State: BreakerClosed},
+		breakerThreshold: DefaultBreakerThreshold,
+		breakerCooldown:  DefaultBreakerCooldown,
+		mode:             Lenient,
+		detectTerm:       CurrentTerm,
+	}
+	for _, option := range options {
+		if err := option(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+var defaultParserMu sync.RWMutex
+var defaultParser, _ = NewParser()
+
+// Returns the parser used by the functions of the package.
+func Default() *Parser {
+	defaultParserMu.RLock()
+	defer defaultParserMu.RUnlock()
+	return defaultParser
+}
+
+// Makes the functions of the package use the parser.
+func SetDefault(p *Parser) {
+	defaultParserMu.Lock()
+	defer defaultParserMu.Unlock()
+	defaultParser = p
+}
+
+// Sets the base URLs of SIS (such as "https://is.cuni.cz/studium"),
+// the primary one first. When a request to one of them fails, the others
+// are tried, and the ones which work are preferred for a while.
+func BaseUrls(urls ...string) Option {
+	return func(p *Parser) error {
+		p.mirrors = []*mirror{}
+		for _, u := range urls {
+			p.mirrors = append(p.mirrors, &mirror{Url: strings.TrimRight(u, "/")})
+		}
+		return nil
+	}
+}
+
+// Makes requests to SIS go through the given proxy, which may be an
+// http://, https:// or socks5:// URL. An empty string means using the
+// proxy set in the environment (HTTP_PROXY etc.), if any, as by default.
+func Proxy(proxyUrl string) Option {
+	return func(p *Parser) error {
+		if proxyUrl == "" {
+			p.client = newClient(http.ProxyFromEnvironment)
+			return nil
+		}
+		u, err := url.Parse(proxyUrl)
+		if err != nil {
+			return err
+		}
+		p.client = newClient(http.ProxyURL(u))
+		return nil
+	}
+}
+
+// Makes every request to SIS wait for the limiter first.
+func RateLimit(limiter RateLimiter) Option {
+	return func(p *Parser) error {
+		p.limiter = limiter
+		return nil
+	}
+}
+
+// After threshold consecutive failed requests (each having tried all the
+// mirrors), SIS is not queried for the cooldown. Then a single request
+// is let through to see whether SIS is back. A threshold of 0 disables this.
+func Breaker(threshold int, cooldown time.Duration) Option {
+	return func(p *Parser) error {
+		p.breakerThreshold, p.breakerCooldown = threshold, cooldown
+		return nil
+	}
+}
+
+// Calls the hook with the raw (UTF-8) content of every SIS page fetched
+// on behalf of a course, so that the pages can be kept for debugging.
+func Snapshots(hook func(courseCode string, url string, body []byte)) Option {
+	return func(p *Parser) error {
+		p.snapshotHook = hook
+		return nil
+	}
+}
+
+// If enabled, a page with an unexpected layout is not rejected right away;
+// instead we try to guess the meaning of the columns from their contents.
+func LayoutFallback(enabled bool) Option {
+	return func(p *Parser) error {
+		p.layoutFallback = enabled
+		return nil
+	}
+}
+
+// Sets the mode of contexts without one set by WithParseMode (Lenient
+// by default).
+func DefaultMode(mode ParseMode) Option {
+	return func(p *Parser) error {
+		p.mode = mode
+		return nil
+	}
+}
+
+// Decides which term is the current one when the context doesn't say
+// (CurrentTerm by default), e.g. following an academic calendar instead
+// of the date alone.
+func TermDetector(detect func(now time.Time) (year int, semester int)) Option {
+	return func(p *Parser) error {
+		p.detectTerm = detect
+		return nil
+	}
+}
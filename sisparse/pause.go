@@ -0,0 +1,26 @@
+package sisparse
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Returned instead of making any request to SIS while it is paused.
+var ErrPaused = errors.New("Requests to SIS are paused by the operator")
+
+var paused int32
+
+// Stops (or resumes) all requests to SIS, of all the parsers and sessions,
+// e.g. when the university asks for a pause or during SIS maintenance.
+func SetPaused(p bool) {
+	var v int32
+	if p {
+		v = 1
+	}
+	atomic.StoreInt32(&paused, v)
+}
+
+// Reports whether requests to SIS are paused, see SetPaused.
+func IsPaused() bool {
+	return atomic.LoadInt32(&paused) == 1
+}
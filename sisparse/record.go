@@ -0,0 +1,34 @@
+package sisparse
+
+import "context"
+
+// The version of the parser, raised whenever the same pages of SIS start
+// to give different events, so that answers can say which parser made them.
+const ParserVersion = 1
+
+// Where the events of a course came from, filled in by the fetch of the
+// course if the context has it (see WithFetchRecord).
+type FetchRecord struct {
+	// The page the events were parsed from
+	Url string
+	// The rows of the schedule which were skipped or guessed at
+	Warnings []Warning
+}
+
+type fetchRecordKey struct{}
+
+// Returns a context in which the fetch of a course (by GetCourseEvents or
+// a source of course data) is recorded in the returned FetchRecord.
+func WithFetchRecord(ctx context.Context) (context.Context, *FetchRecord) {
+	r := &FetchRecord{}
+	return context.WithValue(ctx, fetchRecordKey{}, r), r
+}
+
+// Records where the events being fetched within ctx came from, if ctx has
+// a FetchRecord.
+func RecordFetch(ctx context.Context, url string, warnings []Warning) {
+	if r, ok := ctx.Value(fetchRecordKey{}).(*FetchRecord); ok {
+		r.Url = url
+		r.Warnings = warnings
+	}
+}
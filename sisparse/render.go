@@ -0,0 +1,47 @@
+package sisparse
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+var renderedDayNames = []string{"Po", "Út", "St", "Čt", "Pá", "So", "Ne"}
+
+// Renders the groups as a schedule page of SIS, as the parser expects it,
+// e.g. for benchmarks of the parser on made-up course sets. Only what the
+// parser reads of the events is kept, and their codes are made up.
+func RenderSchedulePage(groups [][]Event) []byte {
+	var b strings.Builder
+	b.WriteString(`<html><body><table id="table1"><tr class="head1"><th>Kód</th><th>Typ</th><th>Název</th>` +
+		`<th>Vyučující</th><th>Den, čas</th><th>Místnost</th><th>Délka</th><th>Kapacita</th></tr>` + "\n")
+	for gi, group := range groups {
+		for ei, e := range group {
+			name, teacher := "", ""
+			if ei == 0 {
+				// Only the first row of a group has its name
+				name, teacher = e.Name, e.Teacher
+			}
+			typ := "X"
+			if e.Type == Lecture {
+				typ = "P"
+			}
+			duration := fmt.Sprint(e.TimeTo.Minutes() - e.TimeFrom.Minutes())
+			switch e.WeekParity {
+			case OddWeeks:
+				duration += " Liché týdny"
+			case EvenWeeks:
+				duration += " Sudé týdny"
+			}
+			capacity := ""
+			if e.Capacity > 0 {
+				capacity = fmt.Sprintf("%d / %d", e.Enrolled, e.Capacity)
+			}
+			fmt.Fprintf(&b, "<tr><td>bench%s%d</td><td>%s</td><td>%s</td><td>%s</td><td>%s %d:%02d</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+				strings.ToLower(typ), gi+1, typ, html.EscapeString(name), html.EscapeString(teacher),
+				renderedDayNames[e.Day], e.TimeFrom.Hour(), e.TimeFrom.Minute(), html.EscapeString(e.Room), duration, capacity)
+		}
+	}
+	b.WriteString("</table></body></html>\n")
+	return []byte(b.String())
+}
@@ -0,0 +1,140 @@
+package sisparse
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A response of SIS as saved by the Record option, one file per request
+// (each hop of a redirect being a request of its own). The body is kept as
+// SIS sent it, in whatever encoding.
+type Recording struct {
+	Method   string      `json:"method"`
+	Url      string      `json:"url"`
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	Recorded time.Time   `json:"recorded"`
+}
+
+// Saves every public response of SIS to the directory, replacing an
+// earlier recording of the same request, so that the pages can be served
+// later by the Replay option (e.g. as fixtures of tests, or to work
+// offline). Requests of students logged in to SIS (see Session) and to CAS
+// aren't recorded, and neither are the cookies SIS sets. The files are
+// only readable by the owner. Give it after Proxy, which replaces the HTTP
+// client.
+func Record(dir string) Option {
+	return func(p *Parser) error {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		p.client.Transport = &recordingTransport{dir: dir, next: p.client.Transport}
+		return nil
+	}
+}
+
+// Answers the requests to SIS by the recordings in the directory (see
+// Record) instead of asking SIS; requests which weren't recorded fail.
+func Replay(dir string) Option {
+	return func(p *Parser) error {
+		if _, err := os.Stat(dir); err != nil {
+			return err
+		}
+		p.client.Transport = replayTransport{dir: dir}
+		return nil
+	}
+}
+
+// The name of the file of the recording of the request
+func recordingName(method string, url string) string {
+	sum := sha256.Sum256([]byte(method + " " + url))
+	return hex.EncodeToString(sum[:10]) + ".json"
+}
+
+type privateKey struct{}
+
+// Marks the requests made within the context as private to a student, so
+// that they aren't recorded.
+func withPrivate(ctx context.Context) context.Context {
+	return context.WithValue(ctx, privateKey{}, true)
+}
+
+// Reports whether the request is private (see withPrivate) or may carry
+// credentials: those to CAS and those with a CAS ticket, by which SIS logs
+// the student in.
+func isPrivate(req *http.Request) bool {
+	if private, _ := req.Context().Value(privateKey{}).(bool); private {
+		return true
+	}
+	return strings.HasPrefix(casLoginUrl, req.URL.Scheme+"://"+req.URL.Host+"/") ||
+		req.URL.Query().Get("ticket") != ""
+}
+
+type recordingTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || isPrivate(req) {
+		return resp, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	header := resp.Header.Clone()
+	header.Del("Set-Cookie")
+	r := Recording{Method: req.Method, Url: req.URL.String(), Status: resp.StatusCode, Header: header,
+		Body: body, Recorded: time.Now().UTC()}
+	// Not failing the request, which got its answer
+	if s, err := json.Marshal(r); err == nil {
+		name := filepath.Join(t.dir, recordingName(req.Method, r.Url))
+		if ioutil.WriteFile(name+".tmp", s, 0600) == nil {
+			os.Rename(name+".tmp", name)
+		}
+	}
+	return resp, nil
+}
+
+type replayTransport struct {
+	dir string
+}
+
+func (t replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	s, err := ioutil.ReadFile(filepath.Join(t.dir, recordingName(req.Method, req.URL.String())))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("No recording of %s %s", req.Method, req.URL)
+	} else if err != nil {
+		return nil, err
+	}
+	var r Recording
+	if err := json.Unmarshal(s, &r); err != nil {
+		return nil, fmt.Errorf("Invalid recording of %s: %s", req.URL, err)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        r.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package sisparse
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordingTransport(t *testing.T) {
+	sis := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "PHPSESSID", Value: "secret"})
+		w.Write([]byte("<html>" + r.URL.Path + "</html>"))
+	}))
+	defer sis.Close()
+	dir := t.TempDir()
+	client := &http.Client{Transport: &recordingTransport{dir: dir, next: http.DefaultTransport}}
+	get := func(ctx context.Context, url string) {
+		t.Helper()
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.Header.Get("Set-Cookie") == "" {
+			t.Errorf("The cookie isn't passed on to the client of %s", url)
+		}
+	}
+
+	get(context.Background(), sis.URL+"/predmety/")
+	get(withPrivate(context.Background()), sis.URL+"/studium/")
+	get(context.Background(), sis.URL+"/?ticket=ST-1-secret")
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != recordingName("GET", sis.URL+"/predmety/") {
+		t.Fatalf("Recorded %d files, want only the public page", len(files))
+	}
+	if mode := files[0].Mode().Perm(); mode != 0600 {
+		t.Errorf("The recording has mode %o, want 0600", mode)
+	}
+
+	replay := &http.Client{Transport: replayTransport{dir: dir}}
+	resp, err := replay.Get(sis.URL + "/predmety/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "<html>/predmety/</html>" {
+		t.Errorf("Replayed %q", body)
+	}
+	if c := resp.Header.Get("Set-Cookie"); c != "" {
+		t.Errorf("The cookie %q is recorded", c)
+	}
+	if _, err := os.Stat(filepath.Join(dir, recordingName("GET", sis.URL+"/studium/"))); !os.IsNotExist(err) {
+		t.Errorf("The private page is recorded: %v", err)
+	}
+}
+
+func TestIsPrivate(t *testing.T) {
+	for _, tt := range []struct {
+		url  string
+		want bool
+	}{
+		{"https://is.cuni.cz/studium/predmety/index.php?do=predmet&kod=NPRG030", false},
+		{casLoginUrl + "?service=https%3A%2F%2Fis.cuni.cz%2Fstudium%2F", true},
+		{"https://is.cuni.cz/studium/?ticket=ST-12345-abc", true},
+	} {
+		req := httptest.NewRequest("GET", tt.url, nil)
+		if got := isPrivate(req); got != tt.want {
+			t.Errorf("isPrivate(%s): got %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
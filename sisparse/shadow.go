@@ -0,0 +1,170 @@
+package sisparse
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Parses a schedule page into groups of events as ParseCourseEventsHTML
+// does, e.g. a rewrite of the parser to be compared with it by Shadow.
+type ScheduleParser func(body io.Reader) ([]Group, []Warning, error)
+
+// The most differences a comparison lists
+const MaxShadowDifferences = 20
+
+// How a candidate parser did on a page compared with ours, see Shadow
+type ShadowResult struct {
+	Url       string
+	Candidate string
+	// How the groups of the candidate differ from ours, empty if they are
+	// the same
+	Differences []string
+	// The errors of the parsers, empty if they succeeded
+	Err          string
+	CandidateErr string
+	// How long each of the parsers took
+	Duration          time.Duration
+	CandidateDuration time.Duration
+}
+
+// Whether the candidate parsed the page differently from ours.
+func (r ShadowResult) Diverged() bool {
+	return len(r.Differences) > 0 || r.Err != r.CandidateErr
+}
+
+var shadowParsersMu sync.Mutex
+
+// The candidates by name, for choosing one in a configuration
+var shadowParsers = map[string]ScheduleParser{
+	// Our parser without the column guessing of LayoutFallback and
+	// rejecting any row it doesn't understand
+	"strict": func(body io.Reader) ([]Group, []Warning, error) {
+		return (&Parser{}).parseCourseEvents(body, Strict, nil)
+	},
+	// Our parser guessing the columns of pages with an unexpected layout
+	"layout-fallback": func(body io.Reader) ([]Group, []Warning, error) {
+		return (&Parser{layoutFallback: true}).parseCourseEvents(body, Lenient, nil)
+	},
+}
+
+// Makes the parser available to ShadowParser by the name, replacing
+// a parser of the same name.
+func RegisterShadowParser(name string, parse ScheduleParser) {
+	shadowParsersMu.Lock()
+	defer shadowParsersMu.Unlock()
+	shadowParsers[name] = parse
+}
+
+// Returns the parser registered by the name.
+func ShadowParser(name string) (ScheduleParser, bool) {
+	shadowParsersMu.Lock()
+	defer shadowParsersMu.Unlock()
+	parse, ok := shadowParsers[name]
+	return parse, ok
+}
+
+// Returns the names of the registered parsers in order.
+func ShadowParserNames() []string {
+	shadowParsersMu.Lock()
+	defer shadowParsersMu.Unlock()
+	names := []string{}
+	for name := range shadowParsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parses every schedule page fetched by GetCourseEvents by the candidate
+// as well, in the background, and reports how it did compared with our
+// parser, so that a new parser can be tried out on live traffic before it
+// replaces ours. What is returned is always what our parser made of the page.
+func Shadow(name string, candidate ScheduleParser, report func(ShadowResult)) Option {
+	return func(p *Parser) error {
+		if candidate == nil || report == nil {
+			return fmt.Errorf("The shadow parser %s needs a parser and a report", name)
+		}
+		p.shadowName, p.shadow, p.shadowReport = name, candidate, report
+		return nil
+	}
+}
+
+// Compares the groups of the candidate with ours in the background, if
+// a shadow parser is set. The groups are copied, as the caller goes on
+// filling them in.
+func (p *Parser) runShadow(url string, body []byte, groups []Group, err error, took time.Duration) {
+	if p.shadow == nil {
+		return
+	}
+	ours := make([]Group, len(groups))
+	for i, group := range groups {
+		ours[i] = append(Group(nil), group...)
+	}
+	res := ShadowResult{Url: url, Candidate: p.shadowName, Duration: took}
+	if err != nil {
+		res.Err = err.Error()
+	}
+	go func() {
+		start := time.Now()
+		theirs, _, err := p.runCandidate(body)
+		res.CandidateDuration = time.Since(start)
+		if err != nil {
+			res.CandidateErr = err.Error()
+		}
+		if res.Err == "" && res.CandidateErr == "" {
+			res.Differences = CompareGroups(ours, theirs)
+		}
+		p.shadowReport(res)
+	}()
+}
+
+// A panic of the candidate, which is yet to be trusted, is its error.
+func (p *Parser) runCandidate(body []byte) (groups []Group, warnings []Warning, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("The shadow parser panicked: %v", r)
+		}
+	}()
+	return p.shadow(bytes.NewReader(body))
+}
+
+// Lists how the groups b differ from the groups a, field by field, up to
+// MaxShadowDifferences; empty if they are the same.
+func CompareGroups(a, b []Group) []string {
+	diffs := []string{}
+	add := func(format string, args ...interface{}) bool {
+		if len(diffs) == MaxShadowDifferences {
+			return false
+		}
+		diffs = append(diffs, fmt.Sprintf(format, args...))
+		return true
+	}
+	if len(a) != len(b) {
+		add("%d groups instead of %d", len(b), len(a))
+	}
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if len(a[i]) != len(b[i]) {
+			if !add("group %d: %d events instead of %d", i+1, len(b[i]), len(a[i])) {
+				return diffs
+			}
+		}
+		for j := 0; j < len(a[i]) && j < len(b[i]); j++ {
+			x, y := reflect.ValueOf(a[i][j]), reflect.ValueOf(b[i][j])
+			for f := 0; f < x.NumField(); f++ {
+				if reflect.DeepEqual(x.Field(f).Interface(), y.Field(f).Interface()) {
+					continue
+				}
+				if !add("group %d, event %d: %s %v instead of %v", i+1, j+1,
+					x.Type().Field(f).Name, y.Field(f).Interface(), x.Field(f).Interface()) {
+					return diffs
+				}
+			}
+		}
+	}
+	return diffs
+}
@@ -1,194 +1,773 @@
 package sisparse
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/yhat/scrape"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
 )
 
-const sisUrl = "https://is.cuni.cz/studium/predmety/index.php?do=predmet&kod=%s&skr=2018&sem=1"
+var tracer = otel.Tracer("github.com/iamwave/samorozvrh/sisparse")
+
+// Relative to the SIS base URL, see BaseUrls(); the academic year is
+// taken from the context, see WithAcademicYear
+const coursePath = "/predmety/index.php?do=predmet&kod=%s&skr=%d&sem=%d"
+
+// Semesters, numbered as in SIS
+const (
+	Winter = 1
+	Summer = 2
+)
+
+// Returned when the course page has no link to a schedule, which usually
+// means that there is no course with the given code.
+var ErrScheduleNotFound = errors.New("Couldn't find schedule URL")
+
+// Brings a course code to the form used by SIS, e.g. " nprg 030" -> "NPRG030".
+func NormalizeCourseCode(code string) string {
+	return strings.ToUpper(strings.Join(strings.Fields(code), ""))
+}
+
+// Reports whether the string looks like a (normalized) course code.
+func IsCourseCode(code string) bool {
+	return courseCodeRegexp.MatchString(code)
+}
+
+// Events which must be enrolled together, see GetCourseEvents.
+type Group = []Event
 
 // Returns a two-dimensional array containing groups of events.
 // Each group is a slice of events which must be enrolled together,
 // the groups represent different times/teachers of the same course.
-// Also, lectures and seminars/practicals are in separate groups.
+// Also, lectures and seminars/practicals are in separate groups (see Course).
 func GetCourseEvents(courseCode string) ([][]Event, error) {
-	resp, err := http.Get(fmt.Sprintf(sisUrl, courseCode))
+	return GetCourseEventsContext(context.Background(), courseCode)
+}
+
+// Like GetCourseEvents, but the requests to SIS are made within ctx
+// (which is also used to trace them).
+func GetCourseEventsContext(ctx context.Context, courseCode string) ([][]Event, error) {
+	return GetSemesterCourseEvents(ctx, courseCode, Winter)
+}
+
+// Like GetCourseEventsContext, but for the given semester (Winter or Summer).
+func GetSemesterCourseEvents(ctx context.Context, courseCode string, semester int) ([][]Event, error) {
+	return Default().GetSemesterCourseEvents(ctx, courseCode, semester)
+}
+
+func (p *Parser) GetSemesterCourseEvents(ctx context.Context, courseCode string, semester int) ([][]Event, error) {
+	events, _, err := p.GetCourseEventsWarnings(ctx, courseCode, semester)
+	return events, err
+}
+
+// Like GetSemesterCourseEvents, but also returns the warnings about the rows
+// of the schedule which were skipped (see ParseMode).
+func GetCourseEventsWarnings(ctx context.Context, courseCode string, semester int) ([][]Event, []Warning, error) {
+	return Default().GetCourseEventsWarnings(ctx, courseCode, semester)
+}
+
+func (p *Parser) GetCourseEventsWarnings(ctx context.Context, courseCode string, semester int) ([][]Event, []Warning, error) {
+	ctx, span := tracer.Start(ctx, "sisparse.GetCourseEvents",
+		trace.WithAttributes(attribute.String("course.code", courseCode), attribute.Int("semester", semester),
+			attribute.Int("academic_year", p.AcademicYear(ctx))))
+	defer span.End()
+	events, warnings, err := p.getCourseEvents(ctx, courseCode, semester, nil)
+	for _, w := range warnings {
+		span.AddEvent("parse warning", trace.WithAttributes(attribute.String("warning", w.String())))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return events, warnings, err
+}
+
+// Fills in diag unless it is nil, see DiagnoseCourseEvents.
+func (p *Parser) getCourseEvents(ctx context.Context, courseCode string, semester int, diag *Diagnostics) ([][]Event, []Warning, error) {
+	// The session cookies SIS may set on the course page are needed
+	// for the schedule
+	ctx = withFetchSession(ctx)
+	body, courseUrl, err := p.fetchSis(ctx, fmt.Sprintf(coursePath, courseCode, p.AcademicYear(ctx), semester))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	p.takeSnapshot(courseCode, courseUrl, body)
 	// It is difficult to directly convert an event code to a schedule link,
 	// because SIS requires the faculty number. Therefore we first open the course
 	// in the "Subjects" SIS module and then go to a link which takes
 	// us to the schedule.
-	relativeScheduleUrl, err := getRelativeScheduleUrl(resp.Body)
+	coursePage, err := html.Parse(bytes.NewReader(body))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	relativeScheduleUrl, err := getRelativeScheduleUrl(coursePage)
+	if err != nil {
+		return nil, nil, err
+	}
+	courseLanguage := getCourseLanguage(coursePage)
+	seminarOptional := getSeminarOptional(coursePage)
+	scheduleUrl, err := getAbsoluteUrl(courseUrl, relativeScheduleUrl)
+	if err != nil {
+		return nil, nil, err
 	}
-	scheduleUrl := getAbsoluteUrl(sisUrl, relativeScheduleUrl)
 
-	resp, err = http.Get(scheduleUrl)
+	// Prefer going through the mirrors again, in case the one
+	// which served the course page has just gone down
+	if schedulePath, ok := p.getMirrorRelativePath(ctx, scheduleUrl); ok {
+		body, scheduleUrl, err = p.fetchSis(ctx, schedulePath)
+	} else {
+		body, err = p.fetch(ctx, scheduleUrl)
+	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	p.takeSnapshot(courseCode, scheduleUrl, body)
+	_, parseSpan := tracer.Start(ctx, "sisparse.parse")
+	parseStart := time.Now()
+	events, warnings, err := p.parseCourseEvents(bytes.NewReader(body), p.parseMode(ctx), diag)
+	parseSpan.End()
+	p.runShadow(scheduleUrl, body, events, err, time.Since(parseStart))
+	if layoutErr, ok := err.(*LayoutError); ok {
+		layoutErr.Url = scheduleUrl
 	}
-	return parseCourseEvents(resp.Body), nil
+	if diag != nil {
+		diag.Url = scheduleUrl
+	}
+	setCourse(events, courseCode, semester)
+	RecordFetch(ctx, scheduleUrl, warnings)
+	for _, group := range events {
+		for i := range group {
+			if group[i].Language == "" {
+				group[i].Language = courseLanguage
+			}
+			group[i].Optional = seminarOptional && group[i].Type == Seminar
+		}
+	}
+	diag.setEvents(events)
+	return events, warnings, err
 }
 
-func getRelativeScheduleUrl(body io.ReadCloser) (string, error) {
-	const scheduleLinkText = "Rozvrh"
+// Parses a saved schedule page of a course (the second page GetCourseEvents
+// fetches), e.g. for offline use. What only the course page says, such as
+// the language of the course, is left out.
+func ParseSchedule(body io.Reader, courseCode string, semester int) ([][]Event, error) {
+	return Default().ParseSchedule(body, courseCode, semester)
+}
+
+func (p *Parser) ParseSchedule(body io.Reader, courseCode string, semester int) ([][]Event, error) {
+	events, _, err := p.ParseScheduleWarnings(body, courseCode, semester, p.mode)
+	return events, err
+}
+
+// Parses the HTML of a schedule page into groups of events, without
+// fetching anything and without filling in the course of the events, so
+// that pages from elsewhere (the cache, a crawler, test fixtures) can be
+// parsed as well. It must not panic on any input, which
+// FuzzParseCourseEventsHTML checks.
+func ParseCourseEventsHTML(r io.Reader) ([]Group, []Warning, error) {
+	return Default().ParseCourseEventsHTML(r)
+}
+
+func (p *Parser) ParseCourseEventsHTML(r io.Reader) ([]Group, []Warning, error) {
+	return p.parseCourseEvents(r, p.mode, nil)
+}
 
-	root, err := html.Parse(body)
+// Like ParseSchedule, but in the given mode, also returning the warnings
+// about the rows which were skipped.
+func ParseScheduleWarnings(body io.Reader, courseCode string, semester int, mode ParseMode) ([][]Event, []Warning, error) {
+	return Default().ParseScheduleWarnings(body, courseCode, semester, mode)
+}
+
+func (p *Parser) ParseScheduleWarnings(body io.Reader, courseCode string, semester int, mode ParseMode) ([][]Event, []Warning, error) {
+	events, warnings, err := p.parseCourseEvents(body, mode, nil)
 	if err != nil {
-		panic(err)
+		return nil, warnings, err
 	}
+	setCourse(events, courseCode, semester)
+	return events, warnings, nil
+}
+
+// Fills in the course and group of the events.
+func setCourse(events [][]Event, courseCode string, semester int) {
+	for gi, group := range events {
+		// Groups are identified by the SIS code of their events if they have one
+		groupId := group[0].Code
+		if groupId == "" {
+			groupId = fmt.Sprintf("%s-%d-%d", courseCode, semester, gi+1)
+		}
+		for i := range group {
+			group[i].GroupID = groupId
+			group[i].CourseCode = courseCode
+			group[i].Semester = semester
+		}
+	}
+}
+
+func getRelativeScheduleUrl(root *html.Node) (string, error) {
+	const scheduleLinkText = "Rozvrh"
 
 	matcher := func(n *html.Node) bool {
 		if n.DataAtom == atom.A {
-			return scrape.Text(n) == scheduleLinkText
+			return normalizeText(scrape.Text(n)) == scheduleLinkText
 		}
 		return false
 	}
 
 	scheduleLink, ok := scrape.Find(root, matcher)
 	if !ok {
-		return "", errors.New("Couldn't find schedule URL")
+		return "", ErrScheduleNotFound
 	}
 	return scrape.Attr(scheduleLink, "href"), nil
 }
 
-func parseCourseEvents(body io.ReadCloser) [][]Event {
-	root, err := html.Parse(body)
-	if err != nil {
-		panic(err)
-	}
+// Returns the language of instruction given on the course page ("cs" or
+// "en"), or "" if it isn't given or there are several.
+func getCourseLanguage(root *html.Node) string {
+	return parseLanguage(getCourseField(root, "Jazyk výuky"))
+}
 
-	matcher := func(n *html.Node) bool {
-		if n.DataAtom == atom.Tr && n.Parent != nil && n.Parent.Parent != nil {
-			return scrape.Attr(n.Parent.Parent, "id") == "table1" &&
-				scrape.Attr(n, "class") != "head1" // ignore table header
+// Reports whether the course page says the seminars of the course are
+// not mandatory, see parseSeminarOptional.
+func getSeminarOptional(root *html.Node) bool {
+	return parseSeminarOptional(getCourseField(root, "Rozsah, examinace"))
+}
+
+// Returns the text of the field of the course page (a table row) whose
+// header starts with the given text, or "" if there is none.
+func getCourseField(root *html.Node, header string) string {
+	th, ok := scrape.Find(root, func(n *html.Node) bool {
+		return n.DataAtom == atom.Th && strings.HasPrefix(normalizeText(scrape.Text(n)), header)
+	})
+	if !ok {
+		return ""
+	}
+	for td := th.NextSibling; td != nil; td = td.NextSibling {
+		if td.DataAtom == atom.Td {
+			return normalizeText(scrape.Text(td))
 		}
+	}
+	return ""
+}
+
+var examinationRegexp = regexp.MustCompile(`(\d+)/(\d+),\s*([A-Za-z+]+)`)
+
+// Given the extent and examination of a course (e.g. "2/2, Z+Zk"),
+// reports whether its seminars are optional: the course has seminars, but
+// no credit ("Z", "KZ") is required for them, just the exam ("Zk").
+func parseSeminarOptional(s string) bool {
+	m := examinationRegexp.FindStringSubmatch(s)
+	if m == nil || m[2] == "0" {
 		return false
 	}
+	for _, exam := range strings.Split(m[3], "+") {
+		if exam == "Z" || exam == "KZ" {
+			return false
+		}
+	}
+	return true
+}
+
+// Converts a language as named in SIS ("čeština", "angličtina") to its code.
+func parseLanguage(s string) string {
+	switch strings.ToLower(s) {
+	case "čeština", "czech":
+		return "cs"
+	case "angličtina", "english":
+		return "en"
+	}
+	return ""
+}
 
-	eventsTable := scrape.FindAll(root, matcher)
-	if len(eventsTable) == 0 {
+// Parses the schedule table of a course. Malformed rows are skipped with
+// a warning or fail the whole table, depending on the mode. If diag isn't
+// nil, what was done with each row is noted in it.
+func (p *Parser) parseCourseEvents(body io.Reader, mode ParseMode, diag *Diagnostics) ([][]Event, []Warning, error) {
+	header, rows, found, err := readTableRows(body, "table1", "head1")
+	if err != nil {
+		return nil, nil, err
+	}
+	if diag != nil {
+		diag.Header, diag.Rows = header, []RowDiagnostic{}
+	}
+	if !found {
 		// The event table is not present at all (possibly SIS returned an error message)
-		return [][]Event{}
+		if diag != nil {
+			diag.LayoutError = "There is no schedule table on the page"
+		}
+		return [][]Event{}, nil, nil
+	}
+
+	layout, err := checkLayout(header)
+	if diag != nil {
+		diag.Layout = "header"
+		if err != nil {
+			diag.LayoutError = err.Error()
+		}
+	}
+	if err != nil {
+		if !p.layoutFallback {
+			return nil, nil, err
+		}
+		cells := make([][]string, len(rows))
+		for i, row := range rows {
+			cells[i] = row.Cells
+		}
+		var ok bool
+		if layout, ok = guessLayout(cells); !ok {
+			return nil, nil, err
+		}
+		if diag != nil {
+			diag.Layout = "guessed"
+		}
+	}
+	if diag != nil {
+		diag.Columns = layout.columns()
 	}
 
 	res := [][]Event{}
+	warnings := []Warning{}
 	group := []Event{}
-	for _, row := range eventsTable {
-		event, err := parseEvent(row)
-		if (err != nil) {
+	groupRow := 0 // The row where the group starts, for diagnostics
+	for i, row := range rows {
+		rd := diag.addRow(i, row, layout)
+		event, err := parseEvent(row, layout)
+		if err != nil {
+			if mode == Strict {
+				return nil, warnings, &RowError{i + 1, err}
+			}
+			warnings = append(warnings, Warning{Row: i + 1, Message: err.Error(), Skipped: true})
+			rd.warn("Skipped: %s", err)
+			if rd != nil {
+				rd.Skipped = true
+			}
 			continue
 		}
+		if event.TeacherID != "" {
+			rd.rule("teacher id %s from the link of the teacher", event.TeacherID)
+		}
+		if !event.NoteInfo.IsEmpty() {
+			rd.rule("note read as %+v", event.NoteInfo)
+		}
+		if event.WeekParity != EveryWeek {
+			rd.rule("%s weeks only", event.WeekParity)
+		}
+		if event.CalendarParity != EveryWeek {
+			rd.rule("%s calendar weeks only", event.CalendarParity)
+		}
+		if len(event.Weeks) > 0 {
+			rd.rule("teaching weeks %s only", event.WeekList())
+		}
+		if event.Cancelled {
+			rd.rule("cancelled")
+		}
 		// A non-empty name means the start of a new group;
 		// names are omitted in all but the first event of a group.
-		if event.Name != "" {
+		// A group meeting several times a week thus spans several rows.
+		// If the rows have event codes, a different code also starts
+		// a new group (of the same course).
+		newCode := event.Code != "" && len(group) > 0 && group[0].Code != "" && event.Code != group[0].Code
+		if event.Name != "" || newCode {
+			if event.Name == "" {
+				// Another group of the same course
+				rd.rule("new group, as the code %s differs from %s", event.Code, group[0].Code)
+				event.Name = group[0].Name
+				if event.Teacher == "" {
+					event.Teacher, event.TeacherID = group[0].Teacher, group[0].TeacherID
+					rd.rule("teacher taken from the previous group")
+				}
+			} else {
+				rd.rule("new group, as the row has a name")
+			}
 			if len(group) > 0 {
 				res = append(res, group)
 			}
 			group = []Event{}
-		} else {
+			groupRow = i + 1
+		} else if len(group) > 0 {
 			// Add the missing fields based on the group's first event
+			rd.rule("another event of the group starting at row %d, as the row has no name", groupRow)
 			event.Name = group[0].Name
 			event.Teacher = group[0].Teacher
+			event.TeacherID = group[0].TeacherID
+			if event.Code == "" {
+				event.Code = group[0].Code
+			}
+		} else {
+			if mode == Strict {
+				return nil, warnings, &RowError{i + 1, errors.New("The first row has no name")}
+			}
+			warnings = append(warnings, Warning{Row: i + 1, Message: "The first row has no name"})
+			rd.warn("The first row has no name")
+			groupRow = i + 1
+		}
+		split := splitAtMidnight(event)
+		if len(split) > 1 {
+			rd.rule("split at midnight")
+		}
+		if rd != nil {
+			rd.Events = split
 		}
-		group = append(group, event)
+		group = append(group, split...)
 	}
 	if len(group) > 0 {
 		res = append(res, group)
 	}
-	return res
+	return res, warnings, nil
+}
+
+// A row of a table read by readTableRows
+type tableRow struct {
+	Cells []string
+	Links [][]string // The targets of the links in each cell
+	// Whether the row is marked as cancelled: struck through, or of
+	// a class of cancelled rows
+	Struck bool
+}
+
+// Reads the rows of the table with the given id (but not of tables nested
+// in it) as the texts of their cells and their links, without building the
+// DOM of the whole page. The rows of the given class are the header; if there are several,
+// the last one is returned. found is false if there is no such table.
+//
+// Course schedule pages are large, so this keeps the memory used by
+// concurrent queries low.
+func readTableRows(body io.Reader, id, headerClass string) (header []string, rows []tableRow, found bool, err error) {
+	z := html.NewTokenizer(body)
+	depth := 0 // Of tables, 1 means directly in the table with the id
+	var row tableRow
+	inRow, isHeader := false, false
+	var cell []string // The text parts of the current cell
+	var cellLinks []string
+	inCell := false
+
+	endCell := func() {
+		if inCell {
+			row.Cells = append(row.Cells, normalizeText(strings.Join(cell, " ")))
+			row.Links = append(row.Links, cellLinks)
+			cell, cellLinks, inCell = nil, nil, false
+		}
+	}
+	endRow := func() {
+		endCell()
+		if !inRow {
+			return
+		}
+		if isHeader {
+			header = row.Cells
+		} else {
+			rows = append(rows, row)
+		}
+		row, inRow = tableRow{}, false
+	}
+
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				endRow()
+				return header, rows, found, nil
+			}
+			return nil, nil, false, z.Err()
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			a := atom.Lookup(name)
+			if tt == html.EndTagToken {
+				switch {
+				case a == atom.Table && depth > 0:
+					depth--
+					if depth == 0 {
+						endRow()
+					}
+				case depth != 1:
+				case a == atom.Tr:
+					endRow()
+				case a == atom.Td || a == atom.Th:
+					endCell()
+				}
+				continue
+			}
+
+			switch {
+			case a == atom.Table && depth > 0:
+				depth++
+			case a == atom.Table && hasAttr && tagAttr(z, "id") == id:
+				depth, found = 1, true
+			case a == atom.A && inCell && hasAttr:
+				if href := tagAttr(z, "href"); href != "" {
+					cellLinks = append(cellLinks, href)
+				}
+			case depth != 1:
+			case a == atom.Tr:
+				endRow()
+				inRow = true
+				class := ""
+				if hasAttr {
+					class = tagAttr(z, "class")
+				}
+				isHeader = class == headerClass
+				row.Struck = isCancelledClass(class)
+			case (a == atom.Td || a == atom.Th) && inRow:
+				endCell()
+				inCell = true
+			case (a == atom.Del || a == atom.S || a == atom.Strike) && inCell:
+				row.Struck = true
+			}
+		case html.TextToken:
+			if inCell {
+				if text := bytes.TrimSpace(z.Text()); len(text) > 0 {
+					cell = append(cell, string(text))
+				}
+			}
+		}
+	}
+}
+
+// Reports whether rows of the class are cancelled ones, as in
+// class="row1 zruseno".
+func isCancelledClass(class string) bool {
+	for _, c := range strings.Fields(strings.ToLower(class)) {
+		if strings.HasPrefix(c, "zrus") || strings.HasPrefix(c, "cancel") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the value of the attribute of the tag the tokenizer is at. It
+// consumes the attributes, so it can only be called once per tag.
+func tagAttr(z *html.Tokenizer, name string) string {
+	for {
+		key, val, more := z.TagAttr()
+		if string(key) == name {
+			return string(val)
+		}
+		if !more {
+			return ""
+		}
+	}
 }
 
-func parseEvent(event *html.Node) (Event, error) {
+func getRowCells(row *html.Node) []string {
 	var cols []string
-	for col := event.FirstChild; col != nil; col = col.NextSibling {
+	for col := row.FirstChild; col != nil; col = col.NextSibling {
 		// For some reason we also get siblings with no tag and no data?
 		if len(strings.TrimSpace(col.Data)) > 0 {
-			cols = append(cols, scrape.Text(col))
+			cols = append(cols, normalizeText(scrape.Text(col)))
 		}
 	}
+	return cols
+}
+
+func parseEvent(row tableRow, layout columnLayout) (Event, error) {
+	cols := row.Cells
+	if len(cols) <= layout.maxIndex() {
+		return Event{}, fmt.Errorf("Expected at least %d columns, got %d", layout.maxIndex()+1, len(cols))
+	}
 
 	e := Event{
-		Type:    cols[1],
-		Name:    cols[2],
-		Teacher: cols[3],
+		Type:    parseEventType(cols[layout.Type]),
+		Name:    cols[layout.Name],
+		Teacher: cols[layout.Teacher],
+	}
+	for _, link := range row.Links[layout.Teacher] {
+		if id := parseTeacherId(link); id != "" {
+			e.TeacherID = id
+			break
+		}
+	}
+	if layout.Code >= 0 && layout.Code < len(cols) {
+		e.Code = cols[layout.Code]
+	}
+	if layout.Capacity >= 0 && layout.Capacity < len(cols) {
+		e.Enrolled, e.Capacity = parseCapacity(cols[layout.Capacity])
+	}
+	if layout.Note >= 0 && layout.Note < len(cols) {
+		e.Note = cols[layout.Note]
+		e.NoteInfo = parseNote(e.Note)
+		e.Language = e.NoteInfo.Language
+		e.Cancelled = e.NoteInfo.Cancelled
+	}
+	if row.Struck {
+		e.Cancelled = true
+	}
+	if layout.Room >= 0 && layout.Room < len(cols) {
+		e.Room = cols[layout.Room]
 	}
 
-	err := addEventScheduling(&e, cols[4], cols[6])
-	return e, err;
+	err := addEventScheduling(&e, cols[layout.DayTime], cols[layout.Duration])
+	return e, err
+}
+
+// Returns the SIS ID of the teacher a link points to (such as
+// "../ucitel/index.php?do=detail&ucitel=12345" or "...?do=ucitel&kod=12345"),
+// or "" if it isn't a link to a teacher.
+func parseTeacherId(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	if id := q.Get("ucitel"); id != "" {
+		return id
+	}
+	if q.Get("do") == "ucitel" {
+		return q.Get("kod")
+	}
+	return ""
 }
 
 func addEventScheduling(e *Event, daytime string, dur string) error {
 	// For strings such as "Út 12:20"
-	if (len(daytime) == 0) {
+	if len(daytime) == 0 {
 		return errors.New("The daytime field is empty")
 	}
 
 	daytimeRunes := []rune(daytime)
-	e.Day = parseDay(string(daytimeRunes[:2]))
+	if len(daytimeRunes) < 4 {
+		return fmt.Errorf("Unable to parse the daytime %q", daytime)
+	}
+	day, err := parseDay(string(daytimeRunes[:2]))
+	if err != nil {
+		return err
+	}
+	e.Day = day
 
-	timeFrom, err := time.Parse("15:04", string(daytimeRunes[3:]))
+	timeFrom, err := ParseClockTime(string(daytimeRunes[3:]))
 	if err != nil {
-		panic(fmt.Sprintf("Unable to parse time: %s", string(daytimeRunes[3:])))
+		return err
 	}
 
-	d, parity := parseDurationAndWeekParity(dur)
+	d, parity, calendarParity, weeks, err := parseDurationAndWeekParity(dur)
+	if err != nil {
+		return err
+	}
 
 	e.TimeFrom = timeFrom
-	e.TimeTo = timeFrom.Add(time.Minute * time.Duration(d))
+	e.TimeTo = timeFrom.Add(d)
 	e.WeekParity = parity
+	e.CalendarParity = calendarParity
+	e.Weeks = weeks
+	if e.TimeTo > midnight && e.Day >= 6 {
+		// Past the end of the week
+		return fmt.Errorf("The event on day %d at %s lasting %d minutes doesn't fit in the week", e.Day, timeFrom, d)
+	}
 	return nil
 }
 
-func parseDurationAndWeekParity(dur string) (int, int) {
-	// Strings like "90" or "240 Sudé týdny (liché kalendářní)"
+// SIS shows an event lasting past midnight (typically a part of a block
+// course) as one row; it is split into a part ending at midnight and
+// a part on the next day, which are a part of the same group.
+func splitAtMidnight(e Event) []Event {
+	if e.TimeTo <= midnight {
+		return []Event{e}
+	}
+	first, second := e, e
+	first.TimeTo = midnight
+	second.Day = e.Day + 1
+	second.TimeFrom = 0
+	second.TimeTo = e.TimeTo - midnight
+	return []Event{first, second}
+}
+
+// Returns the duration, the parity of the teaching weeks, that of the
+// calendar weeks (EveryWeek if not given) and the teaching weeks when SIS
+// lists them instead of a parity (see Event.Weeks).
+func parseDurationAndWeekParity(dur string) (int, WeekParity, WeekParity, []int, error) {
+	// Strings like "90", "240 Sudé týdny (liché kalendářní)" or "90 Týdny 2, 4, 8"
 	w := strings.Fields(dur)
+	if len(w) == 0 {
+		return 0, EveryWeek, EveryWeek, nil, errors.New("The duration field is empty")
+	}
 	d, err := strconv.Atoi(w[0])
 	if err != nil {
-		panic(fmt.Sprintf("Unable to parse duration: %s", err))
+		return 0, EveryWeek, EveryWeek, nil, fmt.Errorf("Unable to parse duration: %s", err)
+	}
+	if d <= 0 || d > maxEventDuration {
+		return 0, EveryWeek, EveryWeek, nil, fmt.Errorf("Implausible duration %d minutes", d)
+	}
+	parity, calendarParity := EveryWeek, EveryWeek
+	if len(w) > 1 && isWeekListWord(w[1]) {
+		weeks, err := parseWeekList(strings.Join(w[2:], " "))
+		if err != nil {
+			return 0, EveryWeek, EveryWeek, nil, err
+		}
+		parity, weeks = normalizeWeeks(weeks)
+		return d, parity, calendarParity, weeks, nil
 	}
-	parity := 0
 	if len(w) > 1 {
 		if w[1] == "Liché" {
-			parity = 1
+			parity = OddWeeks
 		} else {
-			parity = 2
+			parity = EvenWeeks
+		}
+	}
+	if len(w) > 4 && strings.HasPrefix(w[4], "kalendářní") {
+		switch strings.ToLower(strings.TrimPrefix(w[3], "(")) {
+		case "liché":
+			calendarParity = OddWeeks
+		case "sudé":
+			calendarParity = EvenWeeks
 		}
 	}
-	return d, parity
+	return d, parity, calendarParity, nil, nil
+}
+
+// Reports whether the word introduces a list of weeks, as "Týdny" or
+// "týden" (or "Weeks" in the English version of SIS) do.
+func isWeekListWord(word string) bool {
+	word = strings.ToLower(strings.TrimSuffix(word, ":"))
+	return strings.HasPrefix(word, "týd") || word == "week" || word == "weeks"
+}
+
+// Parses the capacity of an event: either just the capacity ("24"), or also
+// the number of students enrolled ("12 / 24"). Unlimited or unknown
+// capacity ("neomezena", "") is returned as 0.
+func parseCapacity(s string) (enrolled, capacity int) {
+	parts := strings.Split(s, "/")
+	capacity, err := strconv.Atoi(strings.TrimSpace(parts[len(parts)-1]))
+	if err != nil {
+		return 0, 0
+	}
+	if len(parts) == 2 {
+		enrolled, _ = strconv.Atoi(strings.TrimSpace(parts[0]))
+	}
+	return enrolled, capacity
 }
 
-func parseDay(day string) int {
-	days := []string{"Po", "Út", "St", "Čt", "Pá"}
+func parseDay(day string) (int, error) {
+	// Block courses are sometimes taught at weekends
+	days := []string{"Po", "Út", "St", "Čt", "Pá", "So", "Ne"}
 	for i, d := range days {
 		if d == day {
-			return i
+			return i, nil
 		}
 	}
-	panic(fmt.Sprintf("Unknown day \"%s\"", day))
+	return 0, fmt.Errorf("Unknown day \"%s\"", day)
 }
 
-func getAbsoluteUrl(base, relative string) string {
+func getAbsoluteUrl(base, relative string) (string, error) {
 	baseUrl, err := url.Parse(base)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	relativeUrl, err := url.Parse(relative)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return baseUrl.ResolveReference(relativeUrl).String()
+	return baseUrl.ResolveReference(relativeUrl).String(), nil
 }
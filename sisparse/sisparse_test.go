@@ -0,0 +1,58 @@
+package sisparse
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A schedule page as SIS serves it, with lectures, seminars in odd and even
+// weeks and a cancelled one
+const schedulePage = "schedule.html"
+
+func readTestPage(tb testing.TB, name string) []byte {
+	page, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return page
+}
+
+// Parses the fixture page, to see what the tokenizer saves in allocations.
+func BenchmarkParseCourseEvents(b *testing.B) {
+	page := readTestPage(b, schedulePage)
+	groups, _, err := ParseCourseEventsHTML(bytes.NewReader(page))
+	if err != nil || len(groups) == 0 {
+		b.Fatalf("The fixture page parses into %d groups: %v", len(groups), err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(page)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := ParseCourseEventsHTML(bytes.NewReader(page)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Parses mutations of the fixture page and of broken ones, which mustn't
+// panic whatever the page is.
+func FuzzParseCourseEventsHTML(f *testing.F) {
+	page := string(readTestPage(f, schedulePage))
+	f.Add(page)
+	f.Add("")
+	f.Add(`<table id="table1"><tr class="head1"><th>Typ</th></tr><tr><td>P</td></tr></table>`)
+	f.Add(strings.Replace(page, "Út 10:40", "Út", 1))
+	f.Add(strings.Replace(page, "<td>90</td>", "<td></td>", 1))
+	f.Add(strings.Replace(page, "90 Liché týdny", "Týdny 2, 4, 8", 1))
+	f.Add(strings.Replace(page, "<th>Kód</th>", "", 1))
+	f.Add(page[:len(page)/2])
+	f.Fuzz(func(t *testing.T, page string) {
+		groups, _, err := ParseCourseEventsHTML(strings.NewReader(page))
+		if err == nil && groups == nil {
+			t.Error("No groups and no error")
+		}
+	})
+}
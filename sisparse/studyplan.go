@@ -0,0 +1,122 @@
+package sisparse
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/yhat/scrape"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// The recommended study plans ("Karolinka") listed in the SIS "Subjects" module,
+// relative to the SIS base URL. The plans of the current academic year are used.
+const studyPlanPath = "/predmety/index.php?do=stpl&kod=%s&rocnik=%d&skr=%d"
+
+var courseCodeRegexp = regexp.MustCompile(`^[A-Z0-9]{5,10}$`)
+
+type PlanCourse struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+}
+
+// A recommended course list for one year of a study program.
+type StudyPlan struct {
+	Program string       `json:"program"`
+	Year    int          `json:"year"`
+	Courses []PlanCourse `json:"courses"`
+}
+
+// Returns the courses recommended for the given year of a study program,
+// as listed in the faculty's recommended study plans. Each course is marked
+// as either required or elective.
+func GetStudyPlan(program string, year int) (StudyPlan, error) {
+	return GetStudyPlanContext(context.Background(), program, year)
+}
+
+// Like GetStudyPlan, but the requests to SIS are made within ctx.
+func GetStudyPlanContext(ctx context.Context, program string, year int) (StudyPlan, error) {
+	return Default().GetStudyPlan(ctx, program, year)
+}
+
+func (p *Parser) GetStudyPlan(ctx context.Context, program string, year int) (StudyPlan, error) {
+	body, _, err := p.fetchSis(withFetchSession(ctx), fmt.Sprintf(studyPlanPath, program, year, p.AcademicYear(ctx)))
+	if err != nil {
+		return StudyPlan{}, err
+	}
+
+	courses, err := parseStudyPlan(bytes.NewReader(body))
+	if err != nil {
+		return StudyPlan{}, err
+	}
+	return StudyPlan{Program: program, Year: year, Courses: courses}, nil
+}
+
+func parseStudyPlan(body io.Reader) ([]PlanCourse, error) {
+	root, err := html.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The plan is a sequence of sections ("Povinné předměty",
+	// "Povinně volitelné předměty", ...) each followed by a table of courses.
+	// We walk the document in order and remember which section we are in.
+	matcher := func(n *html.Node) bool {
+		switch n.DataAtom {
+		case atom.H2, atom.H3, atom.H4:
+			return true
+		case atom.A:
+			return strings.Contains(scrape.Attr(n, "href"), "do=predmet") &&
+				courseCodeRegexp.MatchString(normalizeText(scrape.Text(n)))
+		}
+		return false
+	}
+
+	res := []PlanCourse{}
+	seen := map[string]bool{}
+	required := false
+	inSection := false
+	for _, n := range scrape.FindAllNested(root, matcher) {
+		if n.DataAtom != atom.A {
+			heading := strings.ToLower(normalizeText(scrape.Text(n)))
+			inSection = strings.Contains(heading, "předměty")
+			required = inSection && !strings.Contains(heading, "volitel")
+			continue
+		}
+		code := normalizeText(scrape.Text(n))
+		if !inSection || seen[code] {
+			continue
+		}
+		seen[code] = true
+		res = append(res, PlanCourse{
+			Code:     code,
+			Name:     getPlanCourseName(n),
+			Required: required,
+		})
+	}
+
+	if len(res) == 0 {
+		return nil, errors.New("Couldn't find any courses in the study plan")
+	}
+	return res, nil
+}
+
+func getPlanCourseName(link *html.Node) string {
+	// The course name is in the cell following the one with the code
+	cell, ok := scrape.FindParent(link, scrape.ByTag(atom.Td))
+	if !ok {
+		return ""
+	}
+	for next := cell.NextSibling; next != nil; next = next.NextSibling {
+		if next.DataAtom == atom.Td {
+			return normalizeText(scrape.Text(next))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package sisparse
+
+import (
+	"context"
+	"time"
+)
+
+// SIS numbers academic years by the calendar year in which they start
+// ("skr" in its URLs), e.g. 2026 for 2026/2027.
+
+// Returns the academic year and the semester students plan their schedules
+// for at the given time. The schedule of the winter semester is published
+// in summer, so it is the winter semester from August to January and the
+// summer semester from February to July.
+func CurrentTerm(now time.Time) (year int, semester int) {
+	switch m := now.Month(); {
+	case m == time.January:
+		return now.Year() - 1, Winter
+	case m < time.August:
+		return now.Year() - 1, Summer
+	default:
+		return now.Year(), Winter
+	}
+}
+
+type academicYearKey struct{}
+
+// Returns a context in which SIS is asked about the given academic year.
+func WithAcademicYear(ctx context.Context, year int) context.Context {
+	return context.WithValue(ctx, academicYearKey{}, year)
+}
+
+// Returns the academic year set by WithAcademicYear, or the current one
+// (see the TermDetector option) if none was set.
+func AcademicYear(ctx context.Context) int {
+	return Default().AcademicYear(ctx)
+}
+
+func (p *Parser) AcademicYear(ctx context.Context) int {
+	if year, ok := ctx.Value(academicYearKey{}).(int); ok {
+		return year
+	}
+	year, _ := p.detectTerm(time.Now())
+	return year
+}
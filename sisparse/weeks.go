@@ -0,0 +1,150 @@
+package sisparse
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The number of teaching weeks of a semester, for telling which weeks a
+// week parity stands for
+const TeachingWeeks = 14
+
+// Parses a list of teaching weeks as SIS writes it in place of a week
+// parity, e.g. "2,4,6", "1-7, 9" or "2,4,6…" (the progression continued to
+// the end of the semester). The weeks are returned sorted, without
+// duplicates.
+func parseWeekList(s string) ([]int, error) {
+	s = strings.TrimSpace(s)
+	continued := false
+	for _, ellipsis := range []string{"…", "..."} {
+		if strings.HasSuffix(s, ellipsis) {
+			s, continued = strings.TrimRight(strings.TrimSuffix(s, ellipsis), ", "), true
+		}
+	}
+	seen := map[int]bool{}
+	var listed []int
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		from, to := part, part
+		if i := strings.IndexAny(part, "-–"); i > 0 {
+			from, to = part[:i], strings.TrimLeft(part[i:], "-–")
+		}
+		a, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse the weeks %q", s)
+		}
+		b, err := strconv.Atoi(to)
+		if err != nil || a < 1 || b < a || b > TeachingWeeks {
+			return nil, fmt.Errorf("Unable to parse the weeks %q", s)
+		}
+		for w := a; w <= b; w++ {
+			listed = append(listed, w)
+		}
+	}
+	if len(listed) == 0 {
+		return nil, fmt.Errorf("No weeks in %q", s)
+	}
+	if continued {
+		step := 1
+		if n := len(listed); n >= 2 && listed[n-1] > listed[n-2] {
+			step = listed[n-1] - listed[n-2]
+		}
+		for w := listed[len(listed)-1] + step; w <= TeachingWeeks; w += step {
+			listed = append(listed, w)
+		}
+	}
+	weeks := []int{}
+	for _, w := range listed {
+		if !seen[w] {
+			seen[w] = true
+			weeks = append(weeks, w)
+		}
+	}
+	sort.Ints(weeks)
+	return weeks, nil
+}
+
+// Brings the teaching weeks of an event to the parity they stand for: all
+// the odd or all the even weeks (or all weeks) become just that parity,
+// with no list. Other lists are kept, with the parity of their weeks if
+// they share one, so that what only knows parities still gets it right.
+func normalizeWeeks(weeks []int) (WeekParity, []int) {
+	var odd, even int
+	for _, w := range weeks {
+		if w%2 == 1 {
+			odd++
+		} else {
+			even++
+		}
+	}
+	allOdd, allEven := (TeachingWeeks+1)/2, TeachingWeeks/2
+	switch {
+	case odd == allOdd && even == allEven:
+		return EveryWeek, nil
+	case odd == allOdd && even == 0:
+		return OddWeeks, nil
+	case even == allEven && odd == 0:
+		return EvenWeeks, nil
+	case even == 0:
+		return OddWeeks, weeks
+	case odd == 0:
+		return EvenWeeks, weeks
+	}
+	return EveryWeek, weeks
+}
+
+// Reports whether the event takes place in the teaching week of the number
+// (from 1), by its weeks if it lists them and else by its parity.
+func (e Event) TakesPlaceInWeek(n int) bool {
+	if len(e.Weeks) > 0 {
+		i := sort.SearchInts(e.Weeks, n)
+		return i < len(e.Weeks) && e.Weeks[i] == n
+	}
+	switch e.WeekParity {
+	case OddWeeks:
+		return n%2 == 1
+	case EvenWeeks:
+		return n%2 == 0
+	}
+	return true
+}
+
+// Reports whether the events take place in a common teaching week (at any
+// time of it).
+func (e Event) SharesWeek(f Event) bool {
+	if len(e.Weeks) == 0 && len(f.Weeks) == 0 {
+		return e.WeekParity == EveryWeek || f.WeekParity == EveryWeek || e.WeekParity == f.WeekParity
+	}
+	for n := 1; n <= TeachingWeeks; n++ {
+		if e.TakesPlaceInWeek(n) && f.TakesPlaceInWeek(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// Writes the weeks as parseWeekList reads them, with runs as ranges, e.g.
+// "1-4, 6".
+func formatWeekList(weeks []int) string {
+	parts := []string{}
+	for i := 0; i < len(weeks); {
+		j := i
+		for j+1 < len(weeks) && weeks[j+1] == weeks[j]+1 {
+			j++
+		}
+		if j > i {
+			parts = append(parts, fmt.Sprintf("%d-%d", weeks[i], weeks[j]))
+		} else {
+			parts = append(parts, strconv.Itoa(weeks[i]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Returns the weeks of the event as a list (e.g. "1-4, 6"), empty if it
+// takes place by its parity.
+func (e Event) WeekList() string {
+	return formatWeekList(e.Weeks)
+}
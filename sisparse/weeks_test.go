@@ -0,0 +1,137 @@
+package sisparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseWeekList(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []int
+	}{
+		{"2,4,6", []int{2, 4, 6}},
+		{"1-7, 9", []int{1, 2, 3, 4, 5, 6, 7, 9}},
+		{"1–3", []int{1, 2, 3}},
+		{" 3, 1 3 ", []int{1, 3}},
+		{"14", []int{14}},
+		{"2,4,6…", []int{2, 4, 6, 8, 10, 12, 14}},
+		{"1, 4...", []int{1, 4, 7, 10, 13}},
+		{"10, ...", []int{10, 11, 12, 13, 14}},
+		{"1-3, 5…", []int{1, 2, 3, 5, 7, 9, 11, 13}},
+	}
+	for _, tt := range tests {
+		got, err := parseWeekList(tt.s)
+		if err != nil {
+			t.Errorf("parseWeekList(%q): %s", tt.s, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseWeekList(%q): got %v, want %v", tt.s, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "…", "x", "0", "15", "5-3", "1-", "1-15", "2,a"} {
+		if got, err := parseWeekList(s); err == nil {
+			t.Errorf("parseWeekList(%q) = %v, want an error", s, got)
+		}
+	}
+}
+
+func TestNormalizeWeeks(t *testing.T) {
+	tests := []struct {
+		weeks  []int
+		parity WeekParity
+		kept   []int
+	}{
+		{[]int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}, EveryWeek, nil},
+		{[]int{1, 3, 5, 7, 9, 11, 13}, OddWeeks, nil},
+		{[]int{2, 4, 6, 8, 10, 12, 14}, EvenWeeks, nil},
+		{[]int{1, 5, 9}, OddWeeks, []int{1, 5, 9}},
+		{[]int{2, 4}, EvenWeeks, []int{2, 4}},
+		{[]int{1, 2, 3}, EveryWeek, []int{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		parity, kept := normalizeWeeks(tt.weeks)
+		if parity != tt.parity || !reflect.DeepEqual(kept, tt.kept) {
+			t.Errorf("normalizeWeeks(%v): got %s, %v, want %s, %v", tt.weeks, parity, kept, tt.parity, tt.kept)
+		}
+	}
+}
+
+func TestTakesPlaceInWeek(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  []int
+	}{
+		{Event{WeekParity: EveryWeek}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}},
+		{Event{WeekParity: OddWeeks}, []int{1, 3, 5, 7, 9, 11, 13}},
+		{Event{WeekParity: EvenWeeks}, []int{2, 4, 6, 8, 10, 12, 14}},
+		// The weeks are what counts, the parity only approximates them
+		{Event{WeekParity: OddWeeks, Weeks: []int{1, 5, 9}}, []int{1, 5, 9}},
+	}
+	for _, tt := range tests {
+		got := []int{}
+		for n := 1; n <= TeachingWeeks; n++ {
+			if tt.event.TakesPlaceInWeek(n) {
+				got = append(got, n)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Weeks of %s %v: got %v, want %v", tt.event.WeekParity, tt.event.Weeks, got, tt.want)
+		}
+	}
+}
+
+func TestSharesWeek(t *testing.T) {
+	every, odd, even := Event{WeekParity: EveryWeek}, Event{WeekParity: OddWeeks}, Event{WeekParity: EvenWeeks}
+	weeks := func(parity WeekParity, w ...int) Event {
+		return Event{WeekParity: parity, Weeks: w}
+	}
+	tests := []struct {
+		e, f Event
+		want bool
+	}{
+		{every, every, true},
+		{every, odd, true},
+		{odd, odd, true},
+		{odd, even, false},
+		{weeks(EvenWeeks, 2, 4), odd, false},
+		{weeks(EvenWeeks, 2, 4), even, true},
+		{weeks(EvenWeeks, 2, 4), weeks(EvenWeeks, 6, 8), false},
+		{weeks(EvenWeeks, 2, 4), weeks(EveryWeek, 3, 4, 5), true},
+		{weeks(OddWeeks, 1, 13), every, true},
+	}
+	for _, tt := range tests {
+		if got := tt.e.SharesWeek(tt.f); got != tt.want {
+			t.Errorf("%s %v shares a week with %s %v: got %v, want %v", tt.e.WeekParity, tt.e.Weeks, tt.f.WeekParity, tt.f.Weeks, got, tt.want)
+		}
+		if got := tt.f.SharesWeek(tt.e); got != tt.want {
+			t.Errorf("%s %v shares a week with %s %v: got %v, want %v", tt.f.WeekParity, tt.f.Weeks, tt.e.WeekParity, tt.e.Weeks, got, tt.want)
+		}
+	}
+}
+
+func TestFormatWeekList(t *testing.T) {
+	tests := []struct {
+		weeks []int
+		want  string
+	}{
+		{nil, ""},
+		{[]int{3}, "3"},
+		{[]int{1, 2, 3, 4, 6}, "1-4, 6"},
+		{[]int{2, 4, 6}, "2, 4, 6"},
+		{[]int{1, 2, 5, 6, 7, 14}, "1-2, 5-7, 14"},
+	}
+	for _, tt := range tests {
+		got := formatWeekList(tt.weeks)
+		if got != tt.want {
+			t.Errorf("formatWeekList(%v): got %q, want %q", tt.weeks, got, tt.want)
+		}
+		if len(tt.weeks) == 0 {
+			continue
+		}
+		// parseWeekList reads it back
+		if parsed, err := parseWeekList(got); err != nil || !reflect.DeepEqual(parsed, tt.weeks) {
+			t.Errorf("parseWeekList(%q): got %v, %v, want %v", got, parsed, err, tt.weeks)
+		}
+	}
+}
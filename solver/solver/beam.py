@@ -0,0 +1,240 @@
+"""
+Solving within a memory limit: the constraint solver's model grows with the
+number of events and, worse, with the pairs of events which may collide, so a
+big pool of elective courses can take more memory than the machine has. Such
+queries are solved by a beam search instead, which keeps only the most
+rewarding partial schedules and thus needs memory linear in the query.
+The schedules it finds are good, but not necessarily the best ones.
+"""
+import logging
+import random
+
+from course import ODD_WEEKS, EVEN_WEEKS
+from conflicts import ConflictMatrix
+from solver import (events_overlap, events_back_to_back, option_reward, overlap_minutes, day_load_spreads,
+                    heavy_day_minutes, commute_minutes, start_selection)
+
+logger = logging.getLogger(__name__)
+
+# Rough sizes of the parts of the constraint solver's model (and its search), in
+# bytes, erring on the high side: the interpreter with OR-tools loaded, each
+# interval variable with its constraints, and each pair of events on the same day
+# which the disjunctive and overlap constraints have to keep apart
+BASE_MEMORY = 80 * 2**20
+EVENT_MEMORY = 4 * 2**10
+PAIR_MEMORY = 512
+
+
+def estimate_memory(courses):
+    """
+    Estimates how many bytes solving the courses with the constraint solver takes.
+    """
+    events = 0
+    events_for_day = {}
+    for course in courses:
+        for opt in course.options:
+            for e in opt:
+                events += 1
+                for parity in (ODD_WEEKS, EVEN_WEEKS):
+                    if e.takes_place_in(parity):
+                        events_for_day.setdefault((e.semester, e.day, parity), []).append(e)
+    pairs = sum(len(day) * (len(day) - 1) // 2 for day in events_for_day.values())
+    return BASE_MEMORY + events * EVENT_MEMORY + pairs * PAIR_MEMORY
+
+
+class _State:
+    """
+    A partial schedule: the options selected so far (None for courses which are
+    not selected or not decided yet), their events and the overlap minutes used.
+    With a conflicts.ConflictMatrix, also the numbers of the selected options as bits.
+    """
+
+    def __init__(self, n_courses):
+        self.selection = [None] * n_courses
+        self.events = []  # (event, course_index, skippable, heavy)
+        self.overlaps = {}  # By (semester, parity) of the weeks
+        self.reward = 0
+        self.selected = 0
+
+    def extend(self, course_index, opt_index, course, settings, matrix=None):
+        """
+        Returns the state with the option added, or None if it collides with the schedule.
+        """
+        overlaps = dict(self.overlaps)
+        heavy = settings.is_heavy(course)
+        back_to_back = 0
+        if matrix is not None:
+            k = matrix.bits[course_index, opt_index]
+            if matrix.collisions[k] & self.selected:
+                return None
+            for l, minutes in matrix.overlap_minutes[k].items():
+                if self.selected >> l & 1:
+                    for key, m in minutes.items():
+                        overlaps[key] = overlaps.get(key, 0) + m
+                        if overlaps[key] > settings.overlap_budget:
+                            return None
+            for l, n in matrix.back_to_back[k].items():
+                if self.selected >> l & 1:
+                    back_to_back += n * settings.back_to_back
+        else:
+            for e in course.options[opt_index]:
+                skippable = settings.is_skippable(e)
+                for f, other, f_skippable, f_heavy in self.events:
+                    if heavy and f_heavy and other != course_index and events_back_to_back(e, f):
+                        back_to_back += settings.back_to_back
+                    if other == course_index or not events_overlap(e, f):
+                        continue
+                    if not (skippable or f_skippable):
+                        return None
+                    for parity in (ODD_WEEKS, EVEN_WEEKS):
+                        if e.takes_place_in(parity) and f.takes_place_in(parity):
+                            key = (e.semester, parity)
+                            overlaps[key] = overlaps.get(key, 0) + overlap_minutes(e, f)
+                            if overlaps[key] > settings.overlap_budget:
+                                return None
+        res = _State.__new__(_State)
+        res.selection = list(self.selection)
+        res.selection[course_index] = opt_index
+        res.events = self.events + [(e, course_index, settings.is_skippable(e), heavy)
+                                    for e in course.options[opt_index]]
+        if heavy and settings.max_heavy_hours is not None:
+            if heavy_day_minutes([(e, h) for e, _, _, h in res.events]) > settings.max_heavy_hours * 60:
+                return None
+        res.overlaps = overlaps
+        res.selected = self.selected
+        if matrix is not None:
+            res.selected |= 1 << k
+        res.reward = self.reward + option_reward(course, opt_index, settings.stability, settings.reward_scale) - back_to_back
+        return res
+
+
+def beam_search(courses, settings, banned=(), matrix=None):
+    """
+    Like solver.solve(), but keeps only the settings.beam_width most rewarding
+    partial schedules while deciding one course (all of its components at once) at
+    a time, the most rewarding courses first. Yields the best schedule found, if any.
+    Credits and balance are only considered among the complete schedules. Options
+    are compared by the conflict matrix, computed here unless given.
+    """
+    if matrix is None:
+        matrix = ConflictMatrix(courses, settings)
+    units = {}
+    for course_index, course in enumerate(courses):
+        key = course.course_code if course.course_code is not None else ("course", course_index)
+        units.setdefault(key, []).append(course_index)
+    units = list(units.values())
+    # Of courses with equal rewards, the seed decides which are tried first
+    random.Random(settings.seed).shuffle(units)
+    units.sort(key=lambda unit: -max(courses[i].reward for i in unit))
+
+    beam = [_State(len(courses))]
+    for unit in units:
+        candidates = []
+        for state in beam:
+            candidates.extend(_extend_by_unit(state, unit, courses, settings, banned, matrix))
+        candidates.sort(key=lambda s: -s.reward)
+        beam = candidates[:settings.beam_width]
+    logger.info("Beam search kept {} schedules".format(len(beam)))
+
+    best = None
+    for state in beam:
+        if not _satisfies_credits(courses, state.selection, settings):
+            continue
+        score = state.reward - _balance_penalty(state, settings) - _commute_penalty(state, settings)
+        if best is None or score > best[0]:
+            best = (score, state.selection)
+    # Never worse than the schedule to start from
+    start = start_selection(courses)
+    if start is not None:
+        score = schedule_objective(courses, start, settings, banned)
+        if score is not None and (best is None or score > best[0]):
+            best = (score, start)
+    if best is not None:
+        yield best[1]
+
+
+def schedule_objective(courses, selection, settings, banned=()):
+    """
+    Returns what the constraint solver maximizes for the complete schedule, or None
+    if the schedule breaks any of the constraints.
+    """
+    state = _State(len(courses))
+    for course_index, opt_index in enumerate(selection):
+        if opt_index is None:
+            continue
+        if (course_index, opt_index) in banned:
+            return None
+        state = state.extend(course_index, opt_index, courses[course_index], settings)
+        if state is None:
+            return None
+    if not _satisfies_components(courses, selection) or not _satisfies_credits(courses, selection, settings):
+        return None
+    return state.reward - _balance_penalty(state, settings) - _commute_penalty(state, settings)
+
+
+def _extend_by_unit(state, unit, courses, settings, banned, matrix):
+    """
+    Returns the states with the components of a course decided: either none of
+    them is selected, or all the mandatory ones are and the optional ones may be.
+    """
+    mandatory = [i for i in unit if not courses[i].optional]
+    res = [state]
+    if not mandatory:
+        # Optional components without a mandatory one are never selected
+        return res
+    partial = [state]
+    for course_index in unit:
+        course = courses[course_index]
+        extended = [] if not course.optional else list(partial)
+        for s in partial:
+            for opt_index in range(len(course.options)):
+                if (course_index, opt_index) in banned:
+                    continue
+                t = s.extend(course_index, opt_index, course, settings, matrix)
+                if t is not None:
+                    extended.append(t)
+        # Keep the unit from multiplying the beam
+        extended.sort(key=lambda s: -s.reward)
+        partial = extended[:settings.beam_width]
+    return res + partial
+
+
+def _satisfies_components(courses, selection):
+    selected_for_code = {}
+    for course, opt_index in zip(courses, selection):
+        if course.course_code is not None:
+            selected_for_code.setdefault(course.course_code, []).append((opt_index is not None, course.optional))
+    for components in selected_for_code.values():
+        mandatory = {selected for selected, optional in components if not optional}
+        if len(mandatory) > 1:
+            return False
+        if any(selected and optional for selected, optional in components) and mandatory != {True}:
+            return False
+    return True
+
+
+def _satisfies_credits(courses, selection, settings):
+    credits = {}
+    for course, opt_index in zip(courses, selection):
+        if opt_index is not None and course.credits:
+            credits[course.semester] = credits.get(course.semester, 0) + course.credits
+    if settings.min_credits is not None and sum(credits.values()) < settings.min_credits:
+        return False
+    if settings.max_credit_imbalance is not None and len(credits) > 1:
+        if max(credits.values()) - min(credits.values()) > settings.max_credit_imbalance:
+            return False
+    return True
+
+
+def _balance_penalty(state, settings):
+    if not settings.balance:
+        return 0
+    spreads = day_load_spreads([e for e, _, _, _ in state.events])
+    return sum(spread * settings.balance // 60 for spread in spreads.values())
+
+
+def _commute_penalty(state, settings):
+    if settings.commute is None or not settings.commute.penalty:
+        return 0
+    minutes, _, _ = commute_minutes([e for e, _, _, _ in state.events], settings.commute)
+    return minutes * settings.commute.penalty // 60
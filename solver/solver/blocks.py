@@ -0,0 +1,119 @@
+"""
+Reserved blocks: time the student wants to keep for something else than
+classes, e.g. "two contiguous 3-hour blocks per week for thesis work". They
+are placed like events once the courses are selected, so they never cost a
+course, at the best times the schedule leaves free.
+"""
+import re
+from datetime import time
+
+from course import DEFAULT_SEMESTER, format_time, parse_time
+from solver import time_to_int
+
+# Where blocks may be placed by default
+DEFAULT_EARLIEST = time(8, 0)
+DEFAULT_LATEST = time(20, 0)
+DEFAULT_DAYS = range(5)
+# The start times tried, in minutes
+STEP_MINUTES = 15
+
+
+class Reservation:
+    """
+    `count` blocks of `minutes` each week, between `earliest` and `latest` on one of
+    `days` (0 is Monday).
+    """
+
+    def __init__(self, count, minutes, earliest=DEFAULT_EARLIEST, latest=DEFAULT_LATEST, days=DEFAULT_DAYS):
+        if count < 1 or minutes < 1:
+            raise ValueError("Expected at least one block of at least a minute")
+        if time_to_int(latest) - time_to_int(earliest) < minutes:
+            raise ValueError("Blocks of {} minutes don't fit between {} and {}".format(
+                minutes, format_time(earliest), format_time(latest)))
+        self.count = count
+        self.minutes = minutes
+        self.earliest = earliest
+        self.latest = latest
+        self.days = list(days)
+
+
+def parse_reservation(s):
+    """
+    Parses a reservation such as "2x180" (two blocks of 180 minutes) or
+    "2x180@09:00-18:00" (between those times).
+
+    >>> r = parse_reservation("2x180@09:00-18:00")
+    >>> r.count, r.minutes, format_time(r.earliest), format_time(r.latest)
+    (2, 180, '09:00', '18:00')
+    """
+    m = re.fullmatch(r"(\d+)x(\d+)(?:@(\d\d:\d\d)-(\d\d:\d\d))?", s)
+    if not m:
+        raise ValueError("Expected a reservation like 2x180 or 2x180@09:00-18:00, got {}".format(s))
+    if m.group(3):
+        return Reservation(int(m.group(1)), int(m.group(2)), parse_time(m.group(3)), parse_time(m.group(4)))
+    return Reservation(int(m.group(1)), int(m.group(2)))
+
+
+def place_reservations(courses, selection, reservations):
+    """
+    Places the blocks of the reservations in each semester of the schedule, in turn,
+    into the time the selected events (and the blocks placed before) leave free.
+    Returns the blocks as dicts with the index of their "reservation", "semester",
+    "day", "time_from" and "time_to", and the blocks which didn't fit as dicts
+    with the "reservation", "semester" and how many are "missing".
+
+    The best time for a block is on a day without another block of the same
+    reservation, on a day with classes (so that it needs no extra way to school),
+    as close to the classes as it can be, and then the earliest.
+    """
+    busy = {}  # (semester, day) -> [(from, to)] in minutes
+    for c, opt_index in zip(courses, selection):
+        if opt_index is None:
+            continue
+        for e in c.options[opt_index]:
+            # A block is every week, so even a biweekly event is in its way
+            busy.setdefault((e.semester, e.day), []).append((time_to_int(e.time_from), time_to_int(e.time_to)))
+    semesters = sorted({semester for semester, _ in busy}) or [DEFAULT_SEMESTER]
+
+    placed, missing = [], []
+    for semester in semesters:
+        has_classes = {day for s, day in busy if s == semester}
+        for r_index, r in enumerate(reservations):
+            days_taken = set()
+            for n in range(r.count):
+                best = None
+                for day in r.days:
+                    taken = busy.get((semester, day), [])
+                    start = time_to_int(r.earliest)
+                    while start + r.minutes <= time_to_int(r.latest):
+                        end = start + r.minutes
+                        if all(end <= f or start >= t for f, t in taken):
+                            gap = min([abs(start - t) for f, t in taken] + [abs(f - end) for f, t in taken],
+                                      default=24 * 60)
+                            key = (day in days_taken, day not in has_classes, gap, day, start)
+                            if best is None or key < best[0]:
+                                best = (key, day, start, end)
+                        start += STEP_MINUTES
+                if best is None:
+                    missing.append({"reservation": r_index, "semester": semester, "missing": r.count - n})
+                    break
+                _, day, start, end = best
+                busy.setdefault((semester, day), []).append((start, end))
+                days_taken.add(day)
+                placed.append({
+                    "reservation": r_index,
+                    "semester": semester,
+                    "day": day,
+                    "time_from": _format_minutes(start),
+                    "time_to": _format_minutes(end),
+                })
+    placed.sort(key=lambda b: (b["semester"], b["day"], b["time_from"]))
+    return placed, missing
+
+
+def _format_minutes(minutes):
+    """
+    >>> _format_minutes(24 * 60)
+    '24:00'
+    """
+    return "{:02d}:{:02d}".format(minutes // 60, minutes % 60)
@@ -0,0 +1,93 @@
+"""
+The conflict matrix: for each two options of different courses, whether they
+collide (events which aren't skippable overlap in a week of the same parity),
+by how many minutes their skippable events overlap in the weeks of each parity of
+each semester, and how
+many of their events are back to back when both courses are heavy. It is
+computed once before the search, so that the beam search, the fallbacks and the
+explanations look pairs of options up instead of comparing their events again
+and again. The options are numbered, so that the options a schedule selects
+can be kept as the bits of an integer, and so are the options each collides with.
+"""
+from course import ODD_WEEKS, EVEN_WEEKS
+from slots import SlotGrid
+from solver import events_overlap, events_back_to_back, overlap_minutes
+
+
+class ConflictMatrix:
+
+    def __init__(self, courses, settings):
+        self.bits = {}  # (course_index, opt_index) -> the number of the option
+        self.options = []  # The number of an option -> (course_index, opt_index)
+        for course_index, course in enumerate(courses):
+            for opt_index in range(len(course.options)):
+                self.bits[course_index, opt_index] = len(self.options)
+                self.options.append((course_index, opt_index))
+        n = len(self.options)
+        # The options each option collides with, as bits
+        self.collisions = [0] * n
+        # The options each option overlaps with at all (colliding or not), as bits
+        self.overlapping = [0] * n
+        # For each option, the options whose events overlap its own although one of them is
+        # skippable, with the minutes of the overlaps by (semester, parity) of the weeks
+        self.overlap_minutes = [{} for _ in range(n)]
+        # For each option of a heavy course, the options of other heavy courses with events
+        # back to back with its own, with how many such pairs of events there are
+        self.back_to_back = [{} for _ in range(n)]
+
+        # With a slot grid, options which share a day but not a slot aren't compared
+        grid = SlotGrid(settings.slot_minutes, courses) if settings.slot_minutes else None
+        options_for_day = {}
+        for k, (course_index, opt_index) in enumerate(self.options):
+            for e in courses[course_index].options[opt_index]:
+                options_for_day.setdefault((e.semester, e.day), set()).add(k)
+        compared = set()
+        for day in options_for_day.values():
+            day = sorted(day)
+            for a, k in enumerate(day):
+                for l in day[a + 1:]:
+                    if (k, l) in compared or self.options[k][0] == self.options[l][0]:
+                        continue
+                    compared.add((k, l))
+                    heavy = (settings.back_to_back and settings.is_heavy(courses[self.options[k][0]])
+                             and settings.is_heavy(courses[self.options[l][0]]))
+                    if (grid is not None and not heavy
+                            and not grid.masks[self.options[k]] & grid.masks[self.options[l]]):
+                        continue
+                    self._compare(courses, settings, k, l, heavy)
+
+    def _compare(self, courses, settings, k, l, heavy):
+        (ci, oi), (cj, oj) = self.options[k], self.options[l]
+        collide = False
+        minutes = {}
+        back_to_back = 0
+        for e in courses[ci].options[oi]:
+            for f in courses[cj].options[oj]:
+                if heavy and events_back_to_back(e, f):
+                    back_to_back += 1
+                if not events_overlap(e, f):
+                    continue
+                if not (settings.is_skippable(e) or settings.is_skippable(f)):
+                    collide = True
+                for parity in (ODD_WEEKS, EVEN_WEEKS):
+                    if e.takes_place_in(parity) and f.takes_place_in(parity):
+                        key = (e.semester, parity)
+                        minutes[key] = minutes.get(key, 0) + overlap_minutes(e, f)
+        if collide:
+            self.collisions[k] |= 1 << l
+            self.collisions[l] |= 1 << k
+        if collide or any(minutes.values()):
+            self.overlapping[k] |= 1 << l
+            self.overlapping[l] |= 1 << k
+        if not collide and any(minutes.values()):
+            self.overlap_minutes[k][l] = self.overlap_minutes[l][k] = minutes
+        if back_to_back:
+            self.back_to_back[k][l] = self.back_to_back[l][k] = back_to_back
+
+    def overlapping_courses(self, course_index, opt_index, selection):
+        """
+        Returns the indices of the courses whose selected options overlap the option.
+        """
+        overlapping = self.overlapping[self.bits[course_index, opt_index]]
+        return {i for i, selected in enumerate(selection)
+                if selected is not None and overlapping >> self.bits[i, selected] & 1}
@@ -5,16 +5,53 @@ import calendar
 from datetime import time, date, datetime, timedelta
 
 DEFAULT_COURSE_REWARD = 1
+# How demanding a course is, from 1 (light) to 5 (heavy), as judged by the student
+DEFAULT_WORKLOAD = 3
+DEFAULT_SEMESTER = 1  # Winter; 2 is summer
+
+# Events lasting until midnight end at "24:00", which datetime.time can't represent.
+# (The server splits events going past midnight into a part on each day.)
+MIDNIGHT = time.max
+
+EVERY_WEEK, ODD_WEEKS, EVEN_WEEKS = 0, 1, 2
+# The week parities as the server sends them
+WEEK_PARITIES = {"every": EVERY_WEEK, "odd": ODD_WEEKS, "even": EVEN_WEEKS}
+# The number of teaching weeks of a semester, for events which list their weeks
+TEACHING_WEEKS = 14
 
 
 class Course:
 
     options = []
 
-    def __init__(self, options=[], name=None, reward=DEFAULT_COURSE_REWARD):
+    def __init__(self, options=[], name=None, reward=DEFAULT_COURSE_REWARD, option_penalties=None,
+                 previous_option=None, credits=0, semester=None, course_code=None, component=None,
+                 optional=False, start_option=None, workload=DEFAULT_WORKLOAD):
         self.options = options
         self.name = name
         self.reward = reward
+        # How much less each option is worth, e.g. for breaking the user's preferences
+        self.option_penalties = option_penalties or [0] * len(options)
+        # The option selected in the schedule being re-solved, if any
+        self.previous_option = previous_option
+        # The option in the schedule the solver starts from (see solver.start_selection()), if any
+        self.start_option = start_option
+        self.credits = credits
+        # The semester the credits count to; by default the first one the course has events in.
+        # (Options of full-year courses contain the events of both semesters.)
+        if semester is None:
+            semesters = [e.semester for opt in options for e in opt]
+            semester = min(semesters) if semesters else DEFAULT_SEMESTER
+        self.semester = semester
+        # The lecture and the seminar of a course are given as separate courses with the same
+        # course_code, and `component` telling which one they are (e.g. "lecture"); such
+        # components are selected either all together or not at all
+        self.course_code = course_code
+        self.component = component
+        # An optional component (e.g. a seminar which needn't be attended) may be left out
+        # while the others are selected, but it is never selected without them
+        self.optional = optional
+        self.workload = workload
 
     def __repr__(self):
         return str(self.options)
@@ -22,7 +59,8 @@ class Course:
 
 class Event:
 
-    def __init__(self, day, time_from, time_to, week_parity=None, name=None):
+    def __init__(self, day, time_from, time_to, week_parity=EVERY_WEEK, name=None, capacity=0, enrolled=0,
+                 semester=DEFAULT_SEMESTER, type=None, skippable=False, weeks=None):
         if int(day) != day or not (0 <= day <= 6):
             raise ValueError("Day must be an integer between 0 and 6 (got {})".format(day))
 
@@ -32,15 +70,60 @@ class Event:
         self.day = day
         self.time_from = time_from
         self.time_to = time_to
-        self.week_parity = week_parity  # 0: both weeks, 1: odd weeks, 2: even weeks
+        self.week_parity = week_parity  # EVERY_WEEK, ODD_WEEKS or EVEN_WEEKS
+        # The teaching weeks (from 1) the event takes place in if it lists them, else None
+        self.weeks = frozenset(weeks) if weeks else None
         self.name = name
+        self.capacity = capacity  # 0: unlimited or unknown
+        self.semester = semester
+        self.enrolled = enrolled
+        self.type = type  # E.g. "lecture" or "seminar"
+        # Whether the student accepts missing (a part of) the event, see Settings.overlap_budget
+        self.skippable = skippable
+
+    def takes_place_in(self, parity):
+        """
+        Whether the event takes place in the weeks of the given parity (ODD_WEEKS or EVEN_WEEKS).
+        """
+        if self.weeks is not None:
+            return any(w % 2 == (1 if parity == ODD_WEEKS else 0) for w in self.weeks)
+        return self.week_parity in (EVERY_WEEK, parity)
+
+    def teaching_weeks(self):
+        """
+        The teaching weeks the event takes place in, by its weeks or its parity.
+
+        >>> sorted(Event(0, time(9), time(10), week_parity=EVEN_WEEKS).teaching_weeks())
+        [2, 4, 6, 8, 10, 12, 14]
+        """
+        if self.weeks is not None:
+            return self.weeks
+        return frozenset(w for w in range(1, TEACHING_WEEKS + 1)
+                         if self.takes_place_in(ODD_WEEKS if w % 2 else EVEN_WEEKS))
+
+    def shares_week_with(self, other):
+        """
+        Whether the events take place in a common week.
+
+        >>> e = Event(0, time(9), time(10), week_parity=EVEN_WEEKS, weeks=[2, 4])
+        >>> e.shares_week_with(Event(0, time(9), time(10), weeks=[6, 8]))
+        False
+        >>> e.shares_week_with(Event(0, time(9), time(10), week_parity=EVEN_WEEKS))
+        True
+        """
+        if self.weeks is None and other.weeks is None:
+            return EVERY_WEEK in (self.week_parity, other.week_parity) or self.week_parity == other.week_parity
+        return bool(self.teaching_weeks() & other.teaching_weeks())
+
+    def is_full(self):
+        return self.capacity > 0 and self.enrolled >= self.capacity
 
     def __repr__(self):
         return "{name}: {day} {time_from}–{time_to}".format(
             name=(self.name if self.name else "Event"),
             day=calendar.day_abbr[self.day],
             time_from=self.time_from.strftime('%H:%M'),
-            time_to=self.time_to.strftime('%H:%M'),
+            time_to=format_time(self.time_to),
         )
 
 
@@ -56,8 +139,25 @@ def load_course(json_obj):
         options = []
         for opt in json_obj["options"]:
             options.append([load_event(e) for e in opt])
-
-        return Course(options, name=name, reward=reward)
+        option_penalties = json_obj.get("option_penalties", None)
+        if option_penalties is not None and len(option_penalties) != len(options):
+            raise ValueError("Expected {} option penalties (got {})".format(
+                len(options), len(option_penalties)))
+
+        previous_option = json_obj.get("previous_option", None)
+        if previous_option is not None and not (0 <= previous_option < len(options)):
+            raise ValueError("Invalid previous option {} of {}".format(previous_option, name))
+        start_option = json_obj.get("start_option", None)
+        if start_option is not None and not (0 <= start_option < len(options)):
+            raise ValueError("Invalid start option {} of {}".format(start_option, name))
+
+        return Course(options, name=name, reward=reward, option_penalties=option_penalties,
+                      previous_option=previous_option, credits=json_obj.get("credits", 0),
+                      semester=json_obj.get("semester", None),
+                      course_code=json_obj.get("course_code", None),
+                      component=json_obj.get("component", None),
+                      optional=json_obj.get("optional", False), start_option=start_option,
+                      workload=json_obj.get("workload", DEFAULT_WORKLOAD))
     except KeyError as e:
         raise ValueError("Missing field in course JSON object: {}".format(e))
 
@@ -66,20 +166,44 @@ def load_event(json_obj):
     # Given an event in a JSON object, return the corresponding Event instance
     try:
         day = json_obj["day"]
-        time_from = _parse_time(json_obj["time_from"])
-        time_to = _parse_time(json_obj["time_to"])
+        time_from = parse_time(json_obj["time_from"])
+        time_to = parse_time(json_obj["time_to"])
         name = json_obj.get("name", None)
-
-        return Event(day, time_from, time_to, name=name)
+        capacity = json_obj.get("capacity", 0)
+        enrolled = json_obj.get("enrolled", 0)
+        semester = json_obj.get("semester", DEFAULT_SEMESTER)
+        week_parity = json_obj.get("week_parity", EVERY_WEEK)
+        week_parity = WEEK_PARITIES.get(week_parity, week_parity)
+        if week_parity not in WEEK_PARITIES.values():
+            raise ValueError("Invalid week parity {}".format(week_parity))
+        weeks = json_obj.get("weeks", None)
+        if weeks is not None and not all(isinstance(w, int) and 1 <= w <= TEACHING_WEEKS for w in weeks):
+            raise ValueError("Invalid weeks {}".format(weeks))
+
+        return Event(day, time_from, time_to, week_parity=week_parity, name=name, capacity=capacity,
+                     enrolled=enrolled, semester=semester, type=json_obj.get("type", None),
+                     skippable=json_obj.get("skippable", False), weeks=weeks)
     except KeyError as e:
         raise ValueError("Missing field in event JSON object: {}".format(e))
 
     return None
 
 
-def _parse_time(s):
+def format_time(t):
+    """
+    >>> format_time(MIDNIGHT)
+    '24:00'
+    """
+    return "24:00" if t == MIDNIGHT else t.strftime("%H:%M")
+
+
+def parse_time(s):
     """
-    >>> _parse_time("21:30")
+    >>> parse_time("21:30")
     datetime.time(21, 30)
+    >>> parse_time("24:00") == MIDNIGHT
+    True
     """
+    if s == "24:00":
+        return MIDNIGHT
     return datetime.strptime(s, "%H:%M").time()
@@ -0,0 +1,154 @@
+"""
+Explaining a schedule found by the solver: how it scores by various criteria
+and why each course got the option it got.
+"""
+from datetime import time
+
+from conflicts import ConflictMatrix
+from solver import (REWARD_SCALE, FULL_OPTION_PENALTY, DEFAULT_STABILITY, HEAVY_WORKLOAD, Settings, day_load_spreads,
+                    events_back_to_back, heavy_day_minutes, commute_minutes, weekly_commutes,
+                    option_reward, time_to_int, components_of)
+
+# Events starting before this count as early starts
+EARLY_START = time(9, 0)
+# Events ending after this count as late ends
+LATE_END = time(17, 20)
+
+
+def score_breakdown(courses, selection, stability=DEFAULT_STABILITY, balance=0, back_to_back=0,
+                    heavy_workload=HEAVY_WORKLOAD, commute=None, reward_scale=REWARD_SCALE):
+    """
+    Returns a dict with the criteria the schedule can be judged by.
+    """
+    selected = _selected_events(courses, selection)
+    events = [e for e, _ in selected]
+    events_for_day = {}
+    for e in events:
+        events_for_day.setdefault(e.day, []).append(e)
+
+    gaps = 0
+    for day_events in events_for_day.values():
+        day_events.sort(key=lambda e: e.time_from)
+        for prev, cur in zip(day_events, day_events[1:]):
+            gaps += max(0, time_to_int(cur.time_from) - time_to_int(prev.time_to))
+
+    objective = reward = preference_penalty = full_penalty = stability_bonus = kept = 0
+    for c, opt_index in zip(courses, selection):
+        if opt_index is None:
+            continue
+        objective += option_reward(c, opt_index, stability, reward_scale)
+        reward += c.reward * reward_scale
+        preference_penalty += c.option_penalties[opt_index]
+        if any(e.is_full() for e in c.options[opt_index]):
+            full_penalty += FULL_OPTION_PENALTY
+        if opt_index == c.previous_option:
+            stability_bonus += stability
+            kept += 1
+
+    spreads = day_load_spreads(events)
+    balance_penalty = sum(spread * balance // 60 for spread in spreads.values())
+    objective -= balance_penalty
+
+    heavy = [(e, i) for e, i in selected if courses[i].workload >= heavy_workload]
+    stacked = sum(1 for k, (e, i) in enumerate(heavy) for f, j in heavy[k + 1:]
+                  if i != j and events_back_to_back(e, f))
+    objective -= stacked * back_to_back
+
+    commute_penalty = early_days = late_days = 0
+    if commute is not None:
+        minutes, early_days, late_days = commute_minutes(events, commute)
+        commute_penalty = minutes * commute.penalty // 60
+        objective -= commute_penalty
+
+    return {
+        # What the solver maximizes:
+        # reward - preference_penalty - full_penalty + stability_bonus - balance_penalty
+        # - back_to_back_penalty - commute_penalty
+        "objective": objective,
+        "reward": reward,
+        "preference_penalty": preference_penalty,
+        "full_penalty": full_penalty,
+        "stability_bonus": stability_bonus,
+        "kept_options": kept,
+        "balance_penalty": balance_penalty,
+        "day_load_spread_minutes": sum(spreads.values()),
+        "back_to_back_penalty": stacked * back_to_back,  # For heavy courses back to back...
+        "heavy_back_to_back": stacked,  # ...this many times
+        "heaviest_day_minutes": heavy_day_minutes((e, True) for e, _ in heavy),
+        "commute_penalty": commute_penalty,  # For days starting or ending at bad times...
+        "early_departures": early_days,  # ...which require leaving home before the time...
+        "late_returns": late_days,  # ...or getting home after the time
+        "weekly_commutes": weekly_commutes(events),  # Days at school in a week, times two...
+        "weekly_commute_minutes": weekly_commutes(events) * (commute.minutes if commute is not None else 0),
+        "courses": sum(1 for s in selection if s is not None),
+        "days_used": len(events_for_day),
+        "gap_minutes": gaps,
+        "early_starts": sum(1 for e in events if e.time_from < EARLY_START),
+        "late_ends": sum(1 for e in events if time_to_int(e.time_to) > time_to_int(LATE_END)),
+    }
+
+
+def explain_choices(courses, selection, matrix=None):
+    """
+    Returns a list parallel to `selection` with a one-line reason why each
+    course got its option (or none at all). Options are compared by the
+    conflict matrix, computed here unless given.
+    """
+    if matrix is None:
+        matrix = ConflictMatrix(courses, Settings())
+    return [_explain(courses, selection, i, matrix) for i in range(len(courses))]
+
+
+def _explain(courses, selection, course_index, matrix):
+    course = courses[course_index]
+    chosen = selection[course_index]
+    alternatives = [i for i in range(len(course.options)) if i != chosen]
+    if chosen is not None and not alternatives:
+        return "The only option"
+
+    overlapping = {}  # Course name -> number of alternatives overlapping with it
+    full = worse = equal = 0
+    for opt_index in alternatives:
+        opt = course.options[opt_index]
+        conflicts = {courses[i].name for i in matrix.overlapping_courses(course_index, opt_index, selection)}
+        if conflicts:
+            for name in conflicts:
+                overlapping[name] = overlapping.get(name, 0) + 1
+        elif any(e.is_full() for e in opt):
+            full += 1
+        elif chosen is not None and course.option_penalties[opt_index] > course.option_penalties[chosen]:
+            worse += 1
+        else:
+            equal += 1
+
+    reasons = []
+    if overlapping:
+        reasons.append("overlap with " + ", ".join(
+            "{} ({})".format(name, n) for name, n in sorted(overlapping.items())))
+    if full:
+        reasons.append("{} full".format(full))
+    if worse:
+        reasons.append("{} against preferences".format(worse))
+    if equal:
+        reasons.append("{} equally good".format(equal))
+
+    if chosen is None:
+        res = "Not selected, its options " + "; ".join(reasons)
+        components = components_of(courses, course_index)
+        if course.optional and any(selection[i] is not None for i in components):
+            res = "Left out as optional, its options " + "; ".join(reasons)
+        elif components:
+            res += "; it is only taken together with " + ", ".join(courses[i].name for i in components)
+        return res
+    return "Chosen over {} other options: {}".format(len(alternatives), "; ".join(reasons))
+
+
+def _selected_events(courses, selection):
+    """
+    Returns the events of the selected options as (event, course_index) pairs.
+    """
+    events = []
+    for i, (c, opt_index) in enumerate(zip(courses, selection)):
+        if opt_index is not None:
+            events.extend((e, i) for e in c.options[opt_index])
+    return events
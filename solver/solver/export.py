@@ -0,0 +1,383 @@
+"""
+Exporting a query as a 0-1 integer program, to look at hard instances with other
+tools and to check that the schedules found are the best ones. The model is the
+one of solver._solve_exact(), built from the conflict matrix (see conflicts.py):
+a binary variable for each option (x<course>_<option>), at most one option of each
+course, no two colliding options, and the objective of the "score" of the answer,
+so an optimum of the exported model equals the best "objective" the solver can find.
+Options banned when finding fallbacks and the schedule to start from are left out.
+What another solver finds can be read back by read_solution().
+
+Formats:
+- "lp": CPLEX LP, for e.g. CBC, GLPK, HiGHS or SCIP
+- "mzn": MiniZinc
+- "wcnf": weighted MaxSAT (DIMACS WCNF), only for queries without credit targets,
+  overlap budgets, heavy day limits, balance and commute penalties, which aren't clauses
+"""
+import re
+
+from course import ODD_WEEKS, EVEN_WEEKS
+from solver import BALANCED_DAYS, REWARD_SCALE, option_reward, time_to_int
+
+FORMATS = ("lp", "mzn", "wcnf")
+
+LE, GE, EQ = "<=", ">=", "="
+
+
+class Model:
+    """
+    A linear model to maximize. Variables are integers within their bounds (binary
+    if 0..1), constraints are (name, terms, sense, rhs) with terms as (coefficient,
+    variable) pairs, and so is the objective.
+    """
+
+    def __init__(self):
+        self.variables = {}  # Name -> (lower bound, upper bound), in the order of creation
+        self.constraints = []
+        self.objective = []
+        # Besides the options' rewards, whether the objective has terms which clauses can't express
+        self.pseudo_boolean = False
+        self.reward_scale = REWARD_SCALE
+
+    def variable(self, name, lower=0, upper=1):
+        self.variables[name] = (lower, upper)
+        return name
+
+    def add(self, name, terms, sense, rhs):
+        self.constraints.append((name, [(c, v) for c, v in terms if c], sense, rhs))
+
+
+def option_var(course_index, opt_index):
+    return "x{}_{}".format(course_index, opt_index)
+
+
+def build_model(courses, settings, matrix):
+    model = Model()
+    model.reward_scale = settings.reward_scale
+    for course_index, course in enumerate(courses):
+        options = [model.variable(option_var(course_index, opt_index)) for opt_index in range(len(course.options))]
+        model.add("one_{}".format(course_index), [(1, x) for x in options], LE, 1)
+        for opt_index, x in enumerate(options):
+            model.objective.append((option_reward(course, opt_index, settings.stability, settings.reward_scale), x))
+
+    for k, collisions in enumerate(matrix.collisions):
+        for l in range(k + 1, len(matrix.options)):
+            if collisions >> l & 1:
+                model.add("collide_{}_{}".format(k, l), [(1, _var(matrix, k)), (1, _var(matrix, l))], LE, 1)
+
+    _add_components(model, courses)
+    _add_credits(model, courses, settings)
+    _add_overlap_budget(model, settings, matrix)
+    _add_back_to_back(model, settings, matrix)
+    _add_heavy_days(model, courses, settings, matrix)
+    _add_balance(model, courses, settings, matrix)
+    _add_commute(model, courses, settings, matrix)
+    return model
+
+
+def _var(matrix, k):
+    return option_var(*matrix.options[k])
+
+
+def _selected(courses, course_index):
+    return [(1, option_var(course_index, opt_index)) for opt_index in range(len(courses[course_index].options))]
+
+
+def _add_components(model, courses):
+    # See solver.create_component_constraints()
+    components_for_code = {}
+    for course_index, course in enumerate(courses):
+        if course.course_code is not None:
+            components_for_code.setdefault(course.course_code, []).append(course_index)
+    for components in components_for_code.values():
+        mandatory = [i for i in components if not courses[i].optional]
+        if not mandatory:
+            continue
+        first = [(-c, x) for c, x in _selected(courses, mandatory[0])]
+        for i in components:
+            if i == mandatory[0]:
+                continue
+            sense = LE if courses[i].optional else EQ
+            model.add("component_{}_{}".format(mandatory[0], i), _selected(courses, i) + first, sense, 0)
+
+
+def _add_credits(model, courses, settings):
+    # See solver.create_credit_constraints()
+    credits = {}
+    for course_index, course in enumerate(courses):
+        if course.credits:
+            credits.setdefault(course.semester, []).extend(
+                (course.credits * c, x) for c, x in _selected(courses, course_index))
+    if settings.min_credits is not None:
+        if not credits:
+            raise ValueError("A credit target is set, but no course has credits")
+        model.add("min_credits", [t for terms in credits.values() for t in terms], GE, settings.min_credits)
+    if settings.max_credit_imbalance is not None and len(credits) > 1:
+        for a in credits:
+            for b in credits:
+                if a != b:
+                    model.add("imbalance_{}_{}".format(a, b),
+                              credits[a] + [(-c, x) for c, x in credits[b]], LE, settings.max_credit_imbalance)
+
+
+def _both(model, matrix, k, l):
+    """
+    Returns a variable which is 1 when the options k and l are both selected. It is
+    only bound from below, so it may only be used where it is better to be 0.
+    """
+    name = model.variable("y{}_{}".format(k, l))
+    model.add("both_{}_{}".format(k, l), [(1, name), (-1, _var(matrix, k)), (-1, _var(matrix, l))], GE, -1)
+    return name
+
+
+def _add_overlap_budget(model, settings, matrix):
+    # See solver.create_overlap_constraints()
+    overlaps = {}
+    for k, minutes_for in enumerate(matrix.overlap_minutes):
+        for l, minutes in sorted(minutes_for.items()):
+            if l < k:
+                continue
+            y = _both(model, matrix, k, l)
+            for key, m in minutes.items():
+                overlaps.setdefault(key, []).append((m, y))
+    for (semester, parity), terms in overlaps.items():
+        if any(c for c, _ in terms):
+            model.add("overlap_budget_{}_{}".format(semester, "odd" if parity == ODD_WEEKS else "even"),
+                      terms, LE, settings.overlap_budget)
+
+
+def _add_back_to_back(model, settings, matrix):
+    # See solver.create_back_to_back_penalty()
+    if not settings.back_to_back:
+        return
+    for k, pairs in enumerate(matrix.back_to_back):
+        for l, count in sorted(pairs.items()):
+            if l > k:
+                model.objective.append((-settings.back_to_back * count, _both(model, matrix, k, l)))
+
+
+def _events(courses, matrix):
+    """
+    Yields the events of all options with the variables of the options.
+    """
+    for k, (course_index, opt_index) in enumerate(matrix.options):
+        for e in courses[course_index].options[opt_index]:
+            yield e, _var(matrix, k), courses[course_index]
+
+
+def _add_heavy_days(model, courses, settings, matrix):
+    # See solver.create_heavy_day_constraints()
+    if settings.max_heavy_hours is None:
+        return
+    load_for_day = {}
+    for e, x, course in _events(courses, matrix):
+        if not settings.is_heavy(course):
+            continue
+        for parity in (ODD_WEEKS, EVEN_WEEKS):
+            if e.takes_place_in(parity):
+                load_for_day.setdefault((e.semester, e.day, parity), []).append((_minutes(e), x))
+    for (semester, day, parity), terms in sorted(load_for_day.items()):
+        model.add("heavy_{}_{}_{}".format(semester, day, parity), terms, LE, settings.max_heavy_hours * 60)
+
+
+def _add_balance(model, courses, settings, matrix):
+    # See solver.create_balance_penalty(): the penalty of each semester is
+    # (longest - shortest) * balance // 60, which the least p with
+    # 60p >= (longest - shortest) * balance - 59 is
+    if not settings.balance:
+        return
+    load_for_day = {}
+    for e, x, _ in _events(courses, matrix):
+        if e.day in BALANCED_DAYS:
+            load_for_day.setdefault((e.semester, e.day), []).append((_minutes(e), x))
+    for semester in sorted({semester for semester, _ in load_for_day}):
+        most = sum(c for (s, _), terms in load_for_day.items() if s == semester for c, _ in terms)
+        longest = model.variable("longest_{}".format(semester), 0, most)
+        shortest = model.variable("shortest_{}".format(semester), 0, most)
+        for day in BALANCED_DAYS:
+            load = load_for_day.get((semester, day), [])
+            model.add("longest_{}_{}".format(semester, day), [(1, longest)] + [(-c, x) for c, x in load], GE, 0)
+            model.add("shortest_{}_{}".format(semester, day), [(1, shortest)] + [(-c, x) for c, x in load], LE, 0)
+        _add_floored_penalty(model, "balance_{}".format(semester),
+                             [(settings.balance, longest), (-settings.balance, shortest)], most * settings.balance)
+
+
+def _add_commute(model, courses, settings, matrix):
+    # See solver.create_commute_penalty(), floored as the balance
+    commute = settings.commute
+    if commute is None or not commute.penalty:
+        return
+    minutes_for_day = {}
+    for e, x, _ in _events(courses, matrix):
+        for kind, minutes in (("early", commute.early_minutes(e)), ("late", commute.late_minutes(e))):
+            if minutes:
+                minutes_for_day.setdefault((kind, e.semester, e.day), []).append((minutes, x))
+    terms = []
+    for (kind, semester, day), options in sorted(minutes_for_day.items()):
+        most = max(c for c, _ in options)
+        m = model.variable("{}_{}_{}".format(kind, semester, day), 0, most)
+        for c, x in options:
+            model.add("{}_{}_{}_{}".format(kind, semester, day, x), [(1, m), (-c, x)], GE, 0)
+        terms.append((commute.penalty, m))
+    if terms:
+        _add_floored_penalty(model, "commute", terms, sum(c * model.variables[m][1] for c, m in terms))
+
+
+def _add_floored_penalty(model, name, terms, most):
+    """
+    Subtracts sum(terms) // 60 from the objective.
+    """
+    p = model.variable(name, 0, max(0, most // 60))
+    model.add(name, [(60, p)] + [(-c, x) for c, x in terms], GE, -59)
+    model.objective.append((-1, p))
+    model.pseudo_boolean = True
+
+
+def _minutes(e):
+    return time_to_int(e.time_to) - time_to_int(e.time_from)
+
+
+def export(courses, settings, matrix, fmt):
+    """
+    Returns the model of the query in the format (one of FORMATS).
+    """
+    model = build_model(courses, settings, matrix)
+    return {"lp": to_lp, "mzn": to_minizinc, "wcnf": to_wcnf}[fmt](model, courses)
+
+
+def _header(model, courses, comment):
+    return ["{} Samorozvrh query: {} courses, rewards scaled by {}".format(comment, len(courses), model.reward_scale)] + [
+        "{} x{}_*: {}".format(comment, i, c.name) for i, c in enumerate(courses)]
+
+
+def _linear(terms):
+    s = " ".join("{} {} {}".format("-" if c < 0 else "+", abs(c), x) for c, x in terms)
+    return s[2:] if s.startswith("+ ") else s or "0"
+
+
+def to_lp(model, courses):
+    lines = _header(model, courses, "\\")
+    lines += ["Maximize", " objective: " + _linear(model.objective), "Subject To"]
+    for name, terms, sense, rhs in model.constraints:
+        if terms:
+            lines.append(" {}: {} {} {}".format(name, _linear(terms), sense, rhs))
+    binary = [v for v, bounds in model.variables.items() if bounds == (0, 1)]
+    general = [v for v, bounds in model.variables.items() if bounds != (0, 1)]
+    if general:
+        lines.append("Bounds")
+        lines += [" {} <= {} <= {}".format(lower, v, upper) for v in general for lower, upper in [model.variables[v]]]
+        lines += ["General", " " + " ".join(general)]
+    lines += ["Binary", " " + " ".join(binary), "End"]
+    return "\n".join(lines) + "\n"
+
+
+def to_minizinc(model, courses):
+    lines = _header(model, courses, "%")
+    for v, (lower, upper) in model.variables.items():
+        lines.append("var {}..{}: {};".format(lower, upper, v))
+    for name, terms, sense, rhs in model.constraints:
+        if terms:
+            lines.append("constraint {} {} {}; % {}".format(
+                _mzn_linear(terms), "==" if sense == EQ else sense, rhs, name))
+    lines.append("var int: objective = {};".format(_mzn_linear(model.objective)))
+    lines.append("solve maximize objective;")
+    lines.append('output ["objective = \\(objective)\\n"] ++ [v ++ "\\n" | v in [{}]];'.format(
+        ", ".join('"{0} = \\({0})"'.format(v) for v in model.variables if v.startswith("x"))))
+    return "\n".join(lines) + "\n"
+
+
+def _mzn_linear(terms):
+    s = " ".join("{} {} * {}".format("-" if c < 0 else "+", abs(c), x) for c, x in terms)
+    return s[2:] if s.startswith("+ ") else s or "0"
+
+
+def to_wcnf(model, courses):
+    """
+    Options are the variables 1 to n in the order of the model; the hard clauses are
+    the constraints, the soft ones the rewards (and penalties) of the objective, so
+    the best schedule's objective is the sum of the positive weights minus the least cost.
+    """
+    if model.pseudo_boolean or any(not _is_clause(name) for name, terms, _, _ in model.constraints if terms):
+        raise ValueError("Credit targets, overlap budgets, heavy day limits, balance and commute "
+                         "penalties can't be exported as clauses, export to lp or mzn instead")
+    numbers = {v: i + 1 for i, v in enumerate(model.variables)}
+    hard, soft = [], []
+    for name, terms, sense, rhs in model.constraints:
+        if name.startswith("one_"):
+            # At most one option
+            options = [x for _, x in terms]
+            hard += [[-numbers[a], -numbers[b]] for i, a in enumerate(options) for b in options[i + 1:]]
+        elif name.startswith("collide_"):
+            hard.append([-numbers[x] for _, x in terms])
+        elif name.startswith("both_"):
+            y, a, b = [x for _, x in terms]
+            hard.append([numbers[y], -numbers[a], -numbers[b]])
+        elif name.startswith("component_"):
+            # sum(component) <= (or =) sum(first): an option of one implies an option of the other
+            component = [x for c, x in terms if c > 0]
+            first = [x for c, x in terms if c < 0]
+            hard += [[-numbers[x]] + [numbers[f] for f in first] for x in component]
+            if sense == EQ:
+                hard += [[-numbers[f]] + [numbers[x] for x in component] for f in first]
+    constant = 0
+    for c, x in model.objective:
+        if c > 0:
+            soft.append((c, [numbers[x]]))
+            constant += c
+        elif c < 0:
+            soft.append((-c, [-numbers[x]]))
+    top = sum(w for w, _ in soft) + 1
+    lines = _header(model, courses, "c")
+    lines.append("c objective = {} - cost".format(constant))
+    lines += ["c {} = {}".format(i, v) for v, i in numbers.items()]
+    lines.append("p wcnf {} {} {}".format(len(numbers), len(hard) + len(soft), top))
+    lines += ["{} {} 0".format(top, " ".join(map(str, clause))) for clause in hard]
+    lines += ["{} {} 0".format(w, " ".join(map(str, clause))) for w, clause in soft]
+    return "\n".join(lines) + "\n"
+
+
+def _is_clause(name):
+    return name.split("_")[0] in ("one", "collide", "both", "component")
+
+
+# A variable of an option followed by its value, as solvers print them, e.g. "x3_1 1"
+# (Gurobi, HiGHS, SCIP), "0 x3_1 1 10300" (CBC), "x3_1 * 1 0 1" (GLPK) or "x3_1 = 1;" (MiniZinc)
+_OPTION_VALUE = re.compile(r"\bx(\d+)_(\d+)\b[^-\d.\n]*(-?[\d.]+(?:[eE][-+]?\d+)?)")
+
+
+def read_solution(text, courses):
+    """
+    Returns the selection (as in the solver's answer) of the solution of an exported
+    model, as printed by another solver: the values of the x<course>_<option>
+    variables, or for WCNF, the "v" lines of a MaxSAT solver (either the literals,
+    "v 1 -2 3 ...", or the values, "v 100..."). Raises ValueError if the solution
+    isn't one of the query or selects more options of a course.
+    """
+    bits = {}  # (course_index, opt_index) -> whether selected
+    literals = [line[1:].split() for line in text.splitlines() if line.startswith("v ")]
+    if literals:
+        options = [(i, j) for i, c in enumerate(courses) for j in range(len(c.options))]
+        values = [token for line in literals for token in line]
+        if len(values) == 1 and set(values[0]) <= {"0", "1"}:
+            values = [str(k + 1) if bit == "1" else str(-(k + 1)) for k, bit in enumerate(values[0])]
+        for literal in map(int, values):
+            if 0 < abs(literal) <= len(options):
+                bits[options[abs(literal) - 1]] = literal > 0
+    else:
+        for match in _OPTION_VALUE.finditer(text):
+            bits[int(match.group(1)), int(match.group(2))] = round(float(match.group(3))) == 1
+    if not bits:
+        raise ValueError("No values of the options (x<course>_<option>) in the solution")
+
+    selection = [None] * len(courses)
+    for (course_index, opt_index), selected in sorted(bits.items()):
+        if not (course_index < len(courses) and opt_index < len(courses[course_index].options)):
+            raise ValueError("The solution has option {} of course {}, which the query doesn't".format(
+                opt_index, course_index))
+        if not selected:
+            continue
+        if selection[course_index] is not None:
+            raise ValueError("The solution selects options {} and {} of {}".format(
+                selection[course_index], opt_index, courses[course_index].name))
+        selection[course_index] = opt_index
+    return selection
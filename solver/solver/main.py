@@ -1,9 +1,17 @@
 import json
 import logging
 import argparse
+import random
+import resource
 
+import beam
+import blocks
+import conflicts
 import course
+import explain
+import export
 from output import schedule_to_string
+import slots
 import solver
 
 
@@ -13,26 +21,217 @@ def main():
         "computes a schedule to maximize weighted sum of selected courses."))
     parser.add_argument("file")
     parser.add_argument("--debug", action="store_true")
+    parser.add_argument("--seed", type=int, default=None,
+                        help="seed for the solver's random choices (random by default)")
+    parser.add_argument("--stability", type=int, default=solver.DEFAULT_STABILITY,
+                        help="bonus for keeping the previous options of courses (given in the input)")
+    parser.add_argument("--min-credits", type=int, default=None,
+                        help="the least number of credits of the selected courses")
+    parser.add_argument("--max-credit-imbalance", type=int, default=None,
+                        help="the largest difference between the credits of the semesters")
+    parser.add_argument("--balance", type=int, default=0,
+                        help="penalty for each hour between the busiest and the lightest weekday")
+    parser.add_argument("--heavy-workload", type=int, default=solver.HEAVY_WORKLOAD,
+                        help="courses with at least this \"workload\" (1 to 5) are heavy")
+    parser.add_argument("--back-to-back", type=int, default=0,
+                        help="penalty for each two events of different heavy courses back to back")
+    parser.add_argument("--max-heavy-hours", type=int, default=None,
+                        help="the most hours of events of heavy courses in a day")
+    parser.add_argument("--commute-minutes", type=int, default=None,
+                        help="how long the way between home and school takes")
+    parser.add_argument("--leave-after", default=None,
+                        help="the time of day (e.g. 07:30) before which leaving home is penalized by --commute-penalty")
+    parser.add_argument("--home-by", default=None,
+                        help="the time of day (e.g. 19:00) after which getting home is penalized by --commute-penalty")
+    parser.add_argument("--commute-penalty", type=int, default=0,
+                        help="penalty for each hour of leaving home before --leave-after or getting home after --home-by")
+    parser.add_argument("--overlap-budget", type=int, default=0,
+                        help="minutes per week by which skippable events may overlap with others")
+    parser.add_argument("--skippable", default="",
+                        help="comma-separated event types (e.g. lecture) which are skippable, "
+                             "besides events with \"skippable\": true")
+    parser.add_argument("--strategy", choices=solver.STRATEGIES, default=solver.AUTO,
+                        help="search by the constraint solver (exact), by a beam search (beam) "
+                             "or by the constraint solver unless it would exceed --memory-limit (auto)")
+    parser.add_argument("--memory-limit", type=int, default=None,
+                        help="megabytes the solver may take; bigger queries are solved by a beam search")
+    parser.add_argument("--beam-width", type=int, default=solver.DEFAULT_BEAM_WIDTH,
+                        help="how many partial schedules the beam search keeps")
+    parser.add_argument("--slot-minutes", type=int, default=0,
+                        help="compare the times of options on a grid of this many minutes first, "
+                             "which is faster for big queries (0 compares all events)")
+    parser.add_argument("--evaluate", default=None,
+                        help="instead of solving, score the given selection (a JSON array like the output's \"data\")")
+    parser.add_argument("--export", choices=export.FORMATS, default=None,
+                        help="instead of solving, print the model of the query as an integer program "
+                             "(lp, mzn) or as weighted MaxSAT (wcnf), for other solvers")
+    parser.add_argument("--import-solution", default=None,
+                        help="instead of solving, score the solution of a model made by --export, "
+                             "as printed by another solver (a file)")
+    parser.add_argument("--reserve", action="append", default=[],
+                        help="reserve blocks of time each week once the courses are selected, e.g. 2x180 "
+                             "(two blocks of 180 minutes) or 2x180@09:00-18:00 (between those times); "
+                             "may be given several times")
     args = parser.parse_args()
+    settings = solver.Settings(
+        seed=args.seed if args.seed is not None else random.randrange(2**31),
+        stability=args.stability,
+        min_credits=args.min_credits,
+        max_credit_imbalance=args.max_credit_imbalance,
+        balance=args.balance,
+        overlap_budget=args.overlap_budget,
+        skippable_types=[t for t in args.skippable.split(",") if t],
+        heavy_workload=args.heavy_workload,
+        back_to_back=args.back_to_back,
+        max_heavy_hours=args.max_heavy_hours,
+        commute=load_commute(args),
+        strategy=args.strategy,
+        memory_limit=args.memory_limit * 2**20 if args.memory_limit is not None else None,
+        beam_width=args.beam_width,
+        slot_minutes=args.slot_minutes,
+    )
+    if settings.memory_limit is not None:
+        limit_memory(settings.memory_limit)
     logging.basicConfig(level=logging.INFO if args.debug else logging.WARN)
 
     courses_json = json.load(open(args.file))
     courses = course.load_course_array(courses_json)
-    logging.info("Solving...")
+    settings.reward_scale = solver.reward_scale(courses, settings)
+    try:
+        reservations = [blocks.parse_reservation(r) for r in args.reserve]
+    except ValueError as e:
+        print(json.dumps({"error": str(e)}))
+        return
+
+    if args.evaluate is not None:
+        evaluate(courses, json.loads(args.evaluate), settings, reservations)
+        return
+    if args.import_solution is not None:
+        try:
+            selection = export.read_solution(open(args.import_solution).read(), courses)
+        except ValueError as e:
+            print(json.dumps({"error": str(e)}))
+            return
+        evaluate(courses, selection, settings, reservations)
+        return
 
-    for selection in solver.solve(courses):
+    # Computed once for the search, the fallbacks and the explanations
+    matrix = conflicts.ConflictMatrix(courses, settings)
+    if args.export is not None:
+        try:
+            print(export.export(courses, settings, matrix, args.export), end="")
+        except ValueError as e:
+            print(json.dumps({"error": str(e)}))
+        return
+
+    logging.info("Solving...")
+    found = False
+    for selection in solver.solve(courses, settings, matrix=matrix):
+        found = True
         events = []
         for c, opt_index in zip(courses, selection):
             if opt_index != None:
                 events.extend(c.options[opt_index])
 
         logging.info(schedule_to_string(events))
+        if settings.slot_minutes:
+            # The slots are a shortcut, the exact times have the last word
+            collisions = slots.validate(courses, selection, settings)
+            if collisions:
+                logging.error("Colliding courses in the schedule: {}".format(collisions))
 
-        print(json.dumps({"data": selection}))
+        answer = {
+            "data": selection,
+            "fallbacks": solver.find_fallbacks(courses, selection, settings, matrix),
+            "seed": settings.seed,
+            "strategy": settings.used_strategy,
+            "memory_estimate": beam.estimate_memory(courses),
+            "score": explain.score_breakdown(courses, selection, stability=settings.stability,
+                                             balance=settings.balance, back_to_back=settings.back_to_back,
+                                             heavy_workload=settings.heavy_workload, commute=settings.commute,
+                                             reward_scale=settings.reward_scale),
+            "explanation": explain.explain_choices(courses, selection, matrix),
+            "semesters": solver.split_by_semester(courses, selection),
+            "pairings": solver.find_parity_pairings(courses, selection),
+            "overlaps": solver.find_overlaps(courses, selection),
+            "omitted": solver.find_omitted(courses, selection),
+        }
+        if solver.start_selection(courses) is not None:
+            answer["start"] = start_report(courses, selection, settings)
+        if reservations:
+            answer["reserved"], answer["unplaced"] = blocks.place_reservations(courses, selection, reservations)
+        print(json.dumps(answer))
 
         if not args.debug:
             # Print all solutions in debug mode, just one normally
             return
 
+    if not found:
+        # E.g. when the credit constraints can't be met
+        print(json.dumps({"error": "No schedule satisfies the constraints"}))
+
+def load_commute(args):
+    if args.commute_minutes is None and args.leave_after is None and args.home_by is None:
+        return None
+    return solver.Commute(
+        minutes=args.commute_minutes or 0,
+        leave_after=course.parse_time(args.leave_after) if args.leave_after else None,
+        home_by=course.parse_time(args.home_by) if args.home_by else None,
+        penalty=args.commute_penalty,
+    )
+
+def start_report(courses, selection, settings):
+    """
+    Returns how the schedule the solver started from scores: whether it is "feasible",
+    its "objective" (None if not feasible) and whether the found one "improved" on it.
+    """
+    objective = beam.schedule_objective(courses, solver.start_selection(courses), settings)
+    return {
+        "feasible": objective is not None,
+        "objective": objective,
+        "improved": objective is None or beam.schedule_objective(courses, selection, settings) > objective,
+    }
+
+def limit_memory(limit):
+    """
+    Makes allocations fail (with MemoryError) once the solver takes `limit` bytes
+    more than it does now, rather than letting it take all memory of the machine.
+    """
+    try:
+        with open("/proc/self/statm") as f:
+            used = int(f.read().split()[0]) * resource.getpagesize()
+    except OSError:
+        used = 0
+    resource.setrlimit(resource.RLIMIT_AS, (used + limit, resource.RLIM_INFINITY))
+
+def evaluate(courses, selection, settings, reservations=()):
+    """
+    Prints how a schedule made elsewhere (e.g. by hand) scores, in the same
+    form as the solver's answer.
+    """
+    if len(selection) != len(courses):
+        print(json.dumps({"error": "Expected {} options in the selection, got {}".format(len(courses), len(selection))}))
+        return
+    for c, opt_index in zip(courses, selection):
+        if opt_index is not None and not 0 <= opt_index < len(c.options):
+            print(json.dumps({"error": "Invalid option {} of {}".format(opt_index, c.name)}))
+            return
+    answer = {
+        "data": selection,
+        "score": explain.score_breakdown(courses, selection, stability=settings.stability,
+                                         balance=settings.balance, back_to_back=settings.back_to_back,
+                                         heavy_workload=settings.heavy_workload, commute=settings.commute,
+                                         reward_scale=settings.reward_scale),
+        "explanation": explain.explain_choices(courses, selection),
+        "semesters": solver.split_by_semester(courses, selection),
+        "pairings": solver.find_parity_pairings(courses, selection),
+        "overlaps": solver.find_overlaps(courses, selection),
+        # Whether the schedule satisfies the constraints the solver would keep
+        "feasible": beam.schedule_objective(courses, selection, settings) is not None,
+    }
+    if reservations:
+        answer["reserved"], answer["unplaced"] = blocks.place_reservations(courses, selection, reservations)
+    print(json.dumps(answer))
+
 if __name__ == '__main__':
     main()
@@ -0,0 +1,67 @@
+"""
+The slot model: the week quantized to a grid of a few minutes, in which the
+events of each option take up a set of slots, kept as the bits of an integer.
+Two options whose bits don't intersect can't collide, which is much faster to
+tell than comparing their events one by one; the conflict matrix (see
+conflicts.py) only compares the events of options whose bits do. The exact
+checks of the time intervals remain the authority: slots are only a shortcut
+to their answer, and validate() checks a schedule by them alone.
+"""
+from course import ODD_WEEKS, EVEN_WEEKS
+from solver import events_overlap, time_to_int
+
+MINUTES_PER_DAY = 24 * 60
+PARITIES = (ODD_WEEKS, EVEN_WEEKS)
+
+
+class SlotGrid:
+    """
+    The slots of the options of the courses in a grid of `minutes` (which must
+    divide a day), computed once for a solver run. An event takes up every slot
+    it reaches into, so the slots of overlapping events always intersect.
+    """
+
+    def __init__(self, minutes, courses):
+        if minutes <= 0 or MINUTES_PER_DAY % minutes:
+            raise ValueError("The slot size must divide a day (got {} minutes)".format(minutes))
+        self.minutes = minutes
+        self.slots_per_day = MINUTES_PER_DAY // minutes
+        # Where the slots of each (semester, day, parity) start, in days
+        self.days = {}
+        # The slots of the events of each option, by (course_index, opt_index)
+        self.masks = {}
+        for course_index, course in enumerate(courses):
+            for opt_index, opt in enumerate(course.options):
+                self.masks[course_index, opt_index] = self.events_mask(opt)
+
+    def events_mask(self, events):
+        mask = 0
+        for e in events:
+            mask |= self.event_mask(e)
+        return mask
+
+    def event_mask(self, e):
+        first = time_to_int(e.time_from) // self.minutes
+        end = -(-time_to_int(e.time_to) // self.minutes)  # Rounded up
+        slots = ((1 << (end - first)) - 1) << first
+        mask = 0
+        for parity in PARITIES:
+            if e.takes_place_in(parity):
+                day_index = self.days.setdefault((e.semester, e.day, parity), len(self.days))
+                mask |= slots << (day_index * self.slots_per_day)
+        return mask
+
+
+def validate(courses, selection, settings):
+    """
+    Checks a schedule by the exact time intervals: returns the pairs of course indices
+    whose events collide although neither is skippable (none for a valid schedule).
+    """
+    events = [(e, i) for i, (c, opt_index) in enumerate(zip(courses, selection)) if opt_index is not None
+              for e in c.options[opt_index] if not settings.is_skippable(e)]
+    collisions = []
+    for k, (e, i) in enumerate(events):
+        for f, j in events[k + 1:]:
+            if i != j and events_overlap(e, f) and [i, j] not in collisions:
+                collisions.append([i, j])
+    return collisions
@@ -1,5 +1,9 @@
 import calendar
+import copy
 import logging
+import math
+
+from course import EVERY_WEEK, ODD_WEEKS, EVEN_WEEKS, MIDNIGHT, format_time
 
 from ortools.constraint_solver import pywrapcp
 # Refer to https://github.com/google/or-tools/issues/62
@@ -9,25 +13,209 @@ logger = logging.getLogger(__name__)
 TIME_LIMIT_MS = 1000
 SOLUTIONS_LIMIT = 5
 
+# Rewards are scaled by this so that options with a full event (or with
+# a penalty given in the query) can be made slightly less attractive (by
+# FULL_OPTION_PENALTY) than the other options of the same course, without
+# ever being preferred to taking fewer courses. When the penalties of a
+# query could add up to more, its rewards are scaled by more, see reward_scale().
+REWARD_SCALE = 100
+FULL_OPTION_PENALTY = 1
+
+# The default bonus (in the same units as FULL_OPTION_PENALTY) for keeping the
+# previous option of a course when re-solving. It is less than any course reward,
+# so that stability never costs a course.
+DEFAULT_STABILITY = 50
+
+# The weekdays whose loads are balanced by Settings.balance, Monday to Friday
+BALANCED_DAYS = range(5)
+
+# Courses of at least this workload (see Course.workload) are heavy
+HEAVY_WORKLOAD = 4
+# Events at most this many minutes apart are back to back
+BACK_TO_BACK_GAP = 15
+
+# When looking for fallbacks, the solver is run once per course, so it gets less time
+FALLBACK_TIME_LIMIT_MS = 200
+
+# How schedules are searched for: by the constraint solver, which finds the best
+# one, or by a beam search (see beam.py), which needs much less memory
+EXACT, BEAM = "exact", "beam"
+# Exact unless the constraint solver would take more than Settings.memory_limit
+AUTO = "auto"
+STRATEGIES = (AUTO, EXACT, BEAM)
+
+DEFAULT_BEAM_WIDTH = 64
+
+
+class Commute:
+    """
+    The way between home and school, which takes `minutes` each way. Days which require
+    leaving home before `leave_after` or getting home after `home_by` (times of day, None
+    for no limit) are penalized by `penalty` for each hour of it.
+    """
+
+    def __init__(self, minutes=0, leave_after=None, home_by=None, penalty=0):
+        self.minutes = minutes
+        self.leave_after = leave_after
+        self.home_by = home_by
+        self.penalty = penalty
+
+    def early_minutes(self, event):
+        """
+        How long before leave_after the event requires leaving home.
+        """
+        if self.leave_after is None:
+            return 0
+        return max(0, time_to_int(self.leave_after) + self.minutes - time_to_int(event.time_from))
+
+    def late_minutes(self, event):
+        """
+        How long after home_by the event lets the student get home.
+        """
+        if self.home_by is None:
+            return 0
+        return max(0, time_to_int(event.time_to) + self.minutes - time_to_int(self.home_by))
+
+
+class Settings:
+    """
+    - seed: all random choices of the solver are made using it
+    - stability: courses keep their previous option (if given) unless it costs more than this
+    - min_credits: the least number of credits of the selected courses (in all semesters)
+    - max_credit_imbalance: the largest allowed difference between the credits of two semesters
+    - balance: the penalty for each hour by which the busiest weekday is longer than the
+      lightest one (0 doesn't care about balance, favouring compressed weeks as before)
+    - overlap_budget: how many minutes per week skippable events may overlap with other events
+    - skippable_types: the types of events (e.g. "lecture") which are skippable besides
+      the events marked so in the input
+    - strategy: one of STRATEGIES; the strategy solve() used is kept in used_strategy
+    - memory_limit: with AUTO, queries which the constraint solver would solve in more
+      bytes than this (by beam.estimate_memory()) are solved by the beam search
+    - beam_width: how many partial schedules the beam search keeps
+    - heavy_workload: courses of at least this workload are heavy
+    - back_to_back: the penalty for each two events of different heavy courses back to back
+    - max_heavy_hours: the most hours of events of heavy courses in a day (None for no limit)
+    - commute: a Commute, or None if the student's commute doesn't matter
+    - slot_minutes: the size of the slots of a slots.SlotGrid, by which the conflict matrix
+      (see conflicts.py) tells apart options which can't collide (0 compares all events)
+    - reward_scale: what course rewards are multiplied by, see reward_scale()
+    """
 
-def solve(courses):
+    def __init__(self, seed=0, stability=DEFAULT_STABILITY, min_credits=None,
+                 max_credit_imbalance=None, balance=0, overlap_budget=0, skippable_types=(),
+                 time_limit=TIME_LIMIT_MS, strategy=AUTO, memory_limit=None,
+                 beam_width=DEFAULT_BEAM_WIDTH, heavy_workload=HEAVY_WORKLOAD, back_to_back=0,
+                 max_heavy_hours=None, commute=None, slot_minutes=0, reward_scale=REWARD_SCALE):
+        self.seed = seed
+        self.stability = stability
+        self.min_credits = min_credits
+        self.max_credit_imbalance = max_credit_imbalance
+        self.balance = balance
+        self.overlap_budget = overlap_budget
+        self.skippable_types = skippable_types
+        self.time_limit = time_limit
+        self.strategy = strategy
+        self.memory_limit = memory_limit
+        self.beam_width = beam_width
+        self.used_strategy = None
+        self.heavy_workload = heavy_workload
+        self.back_to_back = back_to_back
+        self.max_heavy_hours = max_heavy_hours
+        self.commute = commute
+        self.slot_minutes = slot_minutes
+        self.reward_scale = reward_scale
+
+    def is_skippable(self, event):
+        return self.overlap_budget > 0 and (event.skippable or event.type in self.skippable_types)
+
+    def is_heavy(self, course):
+        return course.workload >= self.heavy_workload
+
+
+def solve(courses, settings=Settings(), banned=(), matrix=None):
     """
     Given a list of courses to enroll in (a course may have multiple alternative times),
-    find a valid schedule.
+    find a valid schedule. Options given in `banned` as (course_index, opt_index)
+    pairs are never selected. The beam search uses the conflicts.ConflictMatrix of
+    the courses if given.
     """
+    import beam  # Not at the top, as it imports this module
+
+    strategy = settings.strategy
+    if strategy == AUTO:
+        strategy = EXACT
+        if settings.memory_limit is not None:
+            estimate = beam.estimate_memory(courses)
+            logger.info("Estimated memory: {} MB".format(estimate // 2**20))
+            if estimate > settings.memory_limit:
+                logger.warning("The query would take about {} MB, solving it by a beam search"
+                               .format(estimate // 2**20))
+                strategy = BEAM
+
+    if strategy == EXACT:
+        try:
+            settings.used_strategy = EXACT
+            yield from _solve_exact(courses, settings, banned)
+            return
+        except MemoryError:
+            if settings.strategy == EXACT:
+                raise
+            logger.warning("The constraint solver ran out of memory, solving by a beam search")
+    settings.used_strategy = BEAM
+    yield from beam.beam_search(courses, settings, banned, matrix)
+
+
+def _solve_exact(courses, settings, banned):
+    import beam  # Not at the top, as it imports this module
+
     solver = pywrapcp.Solver("autorozvrh")
+    solver.ReSeed(settings.seed)
 
     flat_vars = []
     flat_vars_inverse = []
     reward_exprs = []  # The solver tries to maximize the sum of the expressions in this array
+    credits_for_semester = {}  # Expressions for the credits of the selected courses
+    selected_for_code = {}  # Expressions for whether the components of each course are selected
 
     for course_index, course in enumerate(courses):
-        course_vars, reward_expr = create_course_variables(solver, course)
+        course_vars, reward_expr, selected_expr = create_course_variables(solver, course, settings)
         reward_exprs.append(reward_expr)
+        if course.credits:
+            credits_for_semester.setdefault(course.semester, []).append(course.credits * selected_expr)
+        if course.course_code is not None:
+            selected_for_code.setdefault(course.course_code, []).append((selected_expr, course.optional))
         flat_vars.extend([v for v, _ in course_vars])
         flat_vars_inverse.extend([(course_index, opt_index) for _, opt_index in course_vars])
-
-    sequences_for_day = create_disjunctive_constraints(solver, flat_vars)
+        for v, _ in course_vars:
+            v.course_index = course_index
+            v.heavy = settings.is_heavy(course)
+        for v, opt_index in course_vars:
+            if (course_index, opt_index) in banned:
+                solver.Add(v.PerformedExpr() == 0)
+
+    sequences_for_day = create_disjunctive_constraints(solver, [v for v in flat_vars if not v.skippable])
+    create_overlap_constraints(solver, flat_vars, settings.overlap_budget)
+    create_component_constraints(solver, selected_for_code)
+    create_credit_constraints(solver, credits_for_semester, settings)
+    if settings.balance:
+        reward_exprs.append(-create_balance_penalty(solver, flat_vars, settings.balance))
+    if settings.back_to_back:
+        reward_exprs.append(-create_back_to_back_penalty(solver, flat_vars, settings.back_to_back))
+    if settings.max_heavy_hours is not None:
+        create_heavy_day_constraints(solver, flat_vars, settings.max_heavy_hours)
+    if settings.commute is not None and settings.commute.penalty:
+        reward_exprs.append(-create_commute_penalty(solver, flat_vars, settings.commute))
+
+    # With a schedule to start from, the solver tries it first, and only looks for
+    # schedules at least as good
+    start = start_selection(courses)
+    start_vars = []
+    if start is not None:
+        start_vars = [v.PerformedExpr().Var() for v, (course_index, opt_index) in zip(flat_vars, flat_vars_inverse)
+                      if start[course_index] == opt_index and (course_index, opt_index) not in banned]
+        start_objective = beam.schedule_objective(courses, start, settings, banned)
+        if start_objective is None:
+            logger.info("The schedule to start from breaks the constraints")
 
     # Note: Use AllSolutionCollector to see all solutions rather than the best
     collector = solver.BestValueSolutionCollector(True)  # True means to choose the maximum, not minimum
@@ -36,6 +224,8 @@ def solve(courses):
     obj_var = solver.Sum(reward_exprs)
     collector.AddObjective(obj_var)  # Make the collector remember the objective value
     objective_monitor = solver.Maximize(obj_var, 1)  # To make the solver care about the objective
+    if start is not None and start_objective is not None:
+        solver.Add(obj_var >= start_objective)
 
     sequence_phase = solver.Phase(sequences_for_day, solver.SEQUENCE_DEFAULT)
     interval_phase = solver.Phase(flat_vars, solver.INTERVAL_DEFAULT)
@@ -47,9 +237,12 @@ def solve(courses):
     main_phase = solver.Compose([sequence_phase,
                                  interval_phase,
                                  minimize_phase])
+    if start_vars:
+        start_phase = solver.Phase(start_vars, solver.CHOOSE_FIRST_UNBOUND, solver.ASSIGN_MAX_VALUE)
+        main_phase = solver.Compose([start_phase, main_phase])
 
     # Stop the solver after a fixed time / number of found solutions
-    time_limit_ms = solver.TimeLimit(TIME_LIMIT_MS)
+    time_limit_ms = solver.TimeLimit(settings.time_limit)
     # solutions_limit = solver.SolutionsLimit(SOLUTIONS_LIMIT)
 
     ok = solver.Solve(
@@ -63,6 +256,10 @@ def solve(courses):
 
     logger.info("Time: {} ms".format(solver.WallTime()))
     if not ok:
+        if start is not None and start_objective is not None:
+            # Nothing better was found in time
+            yield start
+            return
         logger.warning("No solution was found or an error occurred in the solver.")
         return
 
@@ -75,6 +272,116 @@ def solve(courses):
                                      flat_vars, flat_vars_inverse, len(courses))
 
 
+def start_selection(courses):
+    """
+    Returns the schedule to start from (e.g. one the student has made by hand and
+    wants improved), as given by the start_option of the courses, or None if no
+    course has one.
+    """
+    if all(c.start_option is None for c in courses):
+        return None
+    return [c.start_option for c in courses]
+
+
+def find_fallbacks(courses, selection, settings=Settings(), matrix=None):
+    """
+    For each selected course, find what to do if its selected option turns out
+    to be full at enrollment time. Returns a list parallel to `selection` with
+    None for courses which are not selected, and otherwise a dict with the
+    fallback "option" of the course (None if the course has to be dropped) and
+    the "changes" of other courses this requires, as [course_index, opt_index] pairs.
+    Options are compared by the conflict matrix, computed here unless given.
+    """
+    from conflicts import ConflictMatrix  # Not at the top, as it imports this module
+
+    fallback_settings = copy.copy(settings)
+    fallback_settings.time_limit = FALLBACK_TIME_LIMIT_MS
+    if matrix is None:
+        matrix = ConflictMatrix(courses, settings)
+
+    fallbacks = [None] * len(courses)
+    for course_index, opt_index in enumerate(selection):
+        if opt_index is None:
+            continue
+
+        # Preferably switch to another option which fits in the schedule as it is
+        alternative = _find_compatible_option(courses, selection, course_index, matrix)
+        if alternative is not None:
+            fallbacks[course_index] = {"option": alternative, "changes": []}
+            continue
+
+        # Otherwise, find the best schedule without the option
+        fallback_selection = next(solve(courses, fallback_settings,
+                                        banned={(course_index, opt_index)}, matrix=matrix), None)
+        if fallback_selection is None:
+            fallbacks[course_index] = {"option": None, "changes": []}
+            continue
+        fallbacks[course_index] = {
+            "option": fallback_selection[course_index],
+            "changes": [[i, new] for i, (old, new) in enumerate(zip(selection, fallback_selection))
+                        if i != course_index and old != new],
+        }
+    return fallbacks
+
+
+def _find_compatible_option(courses, selection, course_index, matrix):
+    """
+    Returns the index of another option of the course which doesn't overlap
+    with the rest of the selected schedule (preferring options with free places),
+    or None if there is none.
+    """
+    compatible = []
+    for opt_index in range(len(courses[course_index].options)):
+        if opt_index == selection[course_index]:
+            continue
+        if not matrix.overlapping_courses(course_index, opt_index, selection):
+            compatible.append(opt_index)
+
+    for opt_index in compatible:
+        if not any(e.is_full() for e in courses[course_index].options[opt_index]):
+            return opt_index
+    return compatible[0] if compatible else None
+
+
+def events_overlap(e, f):
+    return (e.semester == f.semester and e.day == f.day and e.shares_week_with(f)
+            and e.time_from < f.time_to and f.time_from < e.time_to)
+
+
+def _complementary(e, f):
+    """
+    Whether the events take place in weeks of opposite parities.
+    """
+    return {e.week_parity, f.week_parity} == {ODD_WEEKS, EVEN_WEEKS}
+
+
+def find_parity_pairings(courses, selection):
+    """
+    Returns the selected events of different courses which share a time slot on
+    opposite week parities, as dicts with the indices of the "courses" (the one
+    in odd weeks first), their "day", "time_from" and "time_to" (of the shared part).
+    """
+    events = []
+    for i, (c, opt_index) in enumerate(zip(courses, selection)):
+        if opt_index is not None:
+            events.extend((e, i) for e in c.options[opt_index])
+
+    pairings = []
+    for e, i in events:
+        if e.week_parity != ODD_WEEKS:
+            continue
+        for f, j in events:
+            if (i != j and e.semester == f.semester and e.day == f.day and _complementary(e, f)
+                    and e.time_from < f.time_to and f.time_from < e.time_to):
+                pairings.append({
+                    "courses": [i, j],
+                    "day": e.day,
+                    "time_from": max(e.time_from, f.time_from).strftime("%H:%M"),
+                    "time_to": format_time(min(e.time_to, f.time_to)),
+                })
+    return pairings
+
+
 def _solution_to_selection(collector, solution_index, flat_vars, flat_vars_inverse, n_courses):
     """
     Given a solution found by the solver, extract the list of indices of selected options.
@@ -95,15 +402,30 @@ def _solution_to_selection(collector, solution_index, flat_vars, flat_vars_inver
     return selection
 
 
-def create_course_variables(solver, course):
+def option_reward(course, opt_index, stability, reward_scale=REWARD_SCALE):
+    """
+    The reward for selecting the option of the course, see REWARD_SCALE.
+    """
+    reward = course.reward * reward_scale - course.option_penalties[opt_index]
+    if any(event.is_full() for event in course.options[opt_index]):
+        reward -= FULL_OPTION_PENALTY
+    if opt_index == course.previous_option:
+        reward += stability
+    return reward
+
+
+def create_course_variables(solver, course, settings):
     """
-    Given a course, returns a pair of:
+    Given a course, returns a triple of:
     - a (flat) list of variables corresponding to the course's events.
-    - an expression representing the reward for the course (course.reward if selected, 0 if not)
+    - an expression representing the reward for the course (option_reward() of the selected
+      option, 0 if none is selected)
+    - an expression which is 1 if the course is selected, 0 if not
     Appropriate constraints are added to make the solver pick exactly one option.
     """
     variables = []
     representatives = []
+    option_rewards = []
 
     for opt_index, opt in enumerate(course.options):
 
@@ -120,7 +442,12 @@ def create_course_variables(solver, course):
                 True,  # Is the interval optional?
                 "{} - {}".format(course.name, event.name)
             )
-            var.day = event.day  # This is our custom property
+            var.day = event.day  # These are our custom properties
+            var.semester = event.semester
+            var.week_parity = event.week_parity
+            var.event = event
+            var.skippable = settings.is_skippable(event)
+            var.duration = time_to_int(event.time_to) - time_to_int(event.time_from)
 
             opt_variables.append(var)
 
@@ -131,38 +458,409 @@ def create_course_variables(solver, course):
         variables.extend([(v, opt_index) for v in opt_variables])
         # One variable from each option
         representatives.append(opt_variables[0])
+        option_rewards.append(option_reward(course, opt_index, settings.stability, settings.reward_scale))
 
     # Pick at most one option (exactly one: SumEquality)
     solver.Add(solver.SumLessOrEqual([r.PerformedExpr() for r in representatives], 1))
 
-    return variables, course.reward * solver.Sum([r.PerformedExpr() for r in representatives])
+    reward_expr = solver.Sum([reward * r.PerformedExpr()
+                              for r, reward in zip(representatives, option_rewards)])
+    return variables, reward_expr, solver.Sum([r.PerformedExpr() for r in representatives])
 
 
 def create_disjunctive_constraints(solver, flat_vars):
     """
     Create constrains that forbids multiple events from taking place at the same time.
-    Returns a list of `SequenceVar`s, one for each day. These are then used in the first
-    phase of the solver.
+    Returns a list of `SequenceVar`s, one for each day (of each semester and week parity).
+    These are then used in the first phase of the solver.
+
+    Biweekly events are only constrained in the weeks they take place in, so events
+    in odd and even weeks may share a slot. If some events list their weeks, each
+    week gets its own constraint instead of each parity.
     """
-    events_for_day = [[] for _ in range(5)]
+    if any(v.event.weeks is not None for v in flat_vars):
+        return _create_weekly_disjunctive_constraints(solver, flat_vars)
+    events_for_day = {}
 
     for v in flat_vars:
-        events_for_day[v.day].append(v)
+        for parity in (ODD_WEEKS, EVEN_WEEKS):
+            if v.week_parity in (EVERY_WEEK, parity):
+                events_for_day.setdefault((v.semester, v.day, parity), []).append(v)
 
     sequences_for_day = []
 
-    for day_num, day in enumerate(events_for_day):
-        if not day:
-            # For empty arrays, OR-tools complains:
-            # "operations_research::Solver::MakeMax() was called with an empty list of variables."
+    # Days with no events are left out, as for empty arrays, OR-tools complains:
+    # "operations_research::Solver::MakeMax() was called with an empty list of variables."
+    for (semester, day_num, parity), day in sorted(events_for_day.items()):
+        if parity == EVEN_WEEKS and all(v.week_parity == EVERY_WEEK for v in day):
+            # The same as for odd weeks
             continue
+        disj = solver.DisjunctiveConstraint(day, "{} {} {}".format(
+            calendar.day_abbr[day_num], semester, "odd" if parity == ODD_WEEKS else "even"))
+        solver.Add(disj)
+        sequences_for_day.append(disj.SequenceVar())
+
+    return sequences_for_day
 
-        disj = solver.DisjunctiveConstraint(day, calendar.day_abbr[day_num])
+
+def _create_weekly_disjunctive_constraints(solver, flat_vars):
+    events_for_day = {}
+    for v in flat_vars:
+        for week in v.event.teaching_weeks():
+            events_for_day.setdefault((v.semester, v.day, week), []).append(v)
+
+    sequences_for_day = []
+    constrained = set()
+    for (semester, day_num, week), day in sorted(events_for_day.items()):
+        # Weeks with the same events need the constraint once
+        key = (semester, day_num, tuple(id(v) for v in day))
+        if key in constrained:
+            continue
+        constrained.add(key)
+        disj = solver.DisjunctiveConstraint(day, "{} {} week {}".format(
+            calendar.day_abbr[day_num], semester, week))
         solver.Add(disj)
         sequences_for_day.append(disj.SequenceVar())
 
     return sequences_for_day
 
 
+def create_overlap_constraints(solver, flat_vars, budget):
+    """
+    Limits the overlaps of skippable events (which are left out of the disjunctive
+    constraints) with other events of other courses to `budget` minutes in each week
+    of each semester.
+    """
+    skippable = [v for v in flat_vars if v.skippable]
+    if not skippable:
+        return
+
+    # By (semester, parity); overlapping events are always of the same semester
+    overlaps_for_week = {}
+    for i, v in enumerate(flat_vars):
+        for w in flat_vars[i + 1:]:
+            if not (v.skippable or w.skippable) or v.course_index == w.course_index:
+                continue
+            minutes = overlap_minutes(v.event, w.event)
+            if not minutes:
+                continue
+            both = v.PerformedExpr() * w.PerformedExpr()
+            for parity in (ODD_WEEKS, EVEN_WEEKS):
+                if v.event.takes_place_in(parity) and w.event.takes_place_in(parity):
+                    overlaps_for_week.setdefault((v.semester, parity), []).append(minutes * both)
+
+    for overlaps in overlaps_for_week.values():
+        solver.Add(solver.Sum(overlaps) <= budget)
+
+
+def overlap_minutes(e, f):
+    """
+    How long the events overlap (0 if they don't).
+    """
+    if not events_overlap(e, f):
+        return 0
+    return min(time_to_int(e.time_to), time_to_int(f.time_to)) - max(time_to_int(e.time_from),
+                                                                   time_to_int(f.time_from))
+
+
+def find_overlaps(courses, selection):
+    """
+    Returns the overlapping selected events of different courses (as allowed by
+    Settings.overlap_budget) as dicts with the indices of the "courses", their "day",
+    "time_from" and "time_to" (of the overlap), the overlap "minutes" and the
+    "week_parity" of the weeks it happens in.
+    """
+    events = []
+    for i, (c, opt_index) in enumerate(zip(courses, selection)):
+        if opt_index is not None:
+            events.extend((e, i) for e in c.options[opt_index])
+
+    overlaps = []
+    for k, (e, i) in enumerate(events):
+        for f, j in events[k + 1:]:
+            if i == j or not events_overlap(e, f):
+                continue
+            parity = e.week_parity if e.week_parity != EVERY_WEEK else f.week_parity
+            overlaps.append({
+                "courses": [i, j],
+                "day": e.day,
+                "time_from": max(e.time_from, f.time_from).strftime("%H:%M"),
+                "time_to": format_time(min(e.time_to, f.time_to)),
+                "minutes": overlap_minutes(e, f),
+                "week_parity": {EVERY_WEEK: "every", ODD_WEEKS: "odd", EVEN_WEEKS: "even"}[parity],
+            })
+    return overlaps
+
+
+def create_component_constraints(solver, selected_for_code):
+    """
+    Makes the solver select exactly one option of each component of a course (e.g. one
+    lecture group and one seminar group), or none at all. Optional components may be left
+    out, but are only selected together with the others. `selected_for_code` maps each
+    course code to (expression for whether the component is selected, whether it is optional)
+    pairs.
+    """
+    for components in selected_for_code.values():
+        mandatory = [expr for expr, optional in components if not optional]
+        if not mandatory:
+            continue
+        for expr in mandatory[1:]:
+            solver.Add(mandatory[0] == expr)
+        for expr, optional in components:
+            if optional:
+                solver.Add(expr <= mandatory[0])
+
+
+def find_omitted(courses, selection):
+    """
+    Returns the optional components left out of the selected courses, as dicts with the
+    index of the component in "courses" (a list, as in find_overlaps()), its "course_code"
+    and "component".
+    """
+    omitted = []
+    for i, course in enumerate(courses):
+        if not course.optional or selection[i] is not None:
+            continue
+        if any(selection[j] is not None for j in components_of(courses, i)):
+            omitted.append({"courses": [i], "course_code": course.course_code, "component": course.component})
+    return omitted
+
+
+def components_of(courses, course_index):
+    """
+    Returns the indices of the other components of the same course as the given one.
+    """
+    code = courses[course_index].course_code
+    if code is None:
+        return []
+    return [i for i, c in enumerate(courses) if i != course_index and c.course_code == code]
+
+
+def create_credit_constraints(solver, credits_for_semester, settings):
+    """
+    Adds the constraints on the credits of the selected courses given in `settings`.
+    `credits_for_semester` maps each semester to a list of expressions for the credits
+    of its selected courses.
+    """
+    credits = {semester: solver.Sum(exprs) for semester, exprs in credits_for_semester.items()}
+    if settings.min_credits is not None:
+        if not credits:
+            raise ValueError("A credit target is set, but no course has credits")
+        solver.Add(solver.Sum(list(credits.values())) >= settings.min_credits)
+    if settings.max_credit_imbalance is not None and len(credits) > 1:
+        for a in credits.values():
+            for b in credits.values():
+                if a is not b:
+                    solver.Add(a - b <= settings.max_credit_imbalance)
+
+
+def reward_scale(courses, settings):
+    """
+    Returns what to scale the rewards of the courses by: REWARD_SCALE, or more if all
+    penalties (and the stability bonus) of the query together could outweigh the
+    reward of a course, so that however large their weights are, a schedule is
+    never preferred to one with more courses.
+    """
+    rewards = [c.reward for c in courses if c.reward > 0]
+    if not rewards:
+        return REWARD_SCALE
+    return max(REWARD_SCALE, int(math.ceil((penalty_bound(courses, settings) + 1) / min(rewards))))
+
+
+def penalty_bound(courses, settings):
+    """
+    Returns an upper bound of what the penalties and the stability bonus can change
+    the objective of any schedule of the courses by.
+    """
+    bound = 0
+    for c in courses:
+        bound += max((abs(p) for p in c.option_penalties), default=0) + FULL_OPTION_PENALTY
+        if c.previous_option is not None:
+            bound += abs(settings.stability)
+    events = [(e, i) for i, c in enumerate(courses) for option in c.options for e in option]
+
+    if settings.balance:
+        # The spread of a semester is at most the load of its busiest day
+        loads = {}
+        for e, _ in events:
+            if e.day in BALANCED_DAYS:
+                key = (e.semester, e.day)
+                loads[key] = loads.get(key, 0) + time_to_int(e.time_to) - time_to_int(e.time_from)
+        busiest = {}
+        for (semester, _), load in loads.items():
+            busiest[semester] = max(busiest.get(semester, 0), load)
+        bound += abs(settings.balance) * sum(busiest.values()) // 60 + 1
+
+    if settings.back_to_back:
+        heavy = [(e, i) for e, i in events if settings.is_heavy(courses[i])]
+        pairs = sum(1 for k, (e, i) in enumerate(heavy) for f, j in heavy[k + 1:]
+                    if i != j and events_back_to_back(e, f))
+        bound += abs(settings.back_to_back) * pairs
+
+    commute = settings.commute
+    if commute is not None and commute.penalty:
+        early, late = {}, {}
+        for e, _ in events:
+            key = (e.semester, e.day)
+            early[key] = max(early.get(key, 0), commute.early_minutes(e))
+            late[key] = max(late.get(key, 0), commute.late_minutes(e))
+        bound += abs(commute.penalty) * (sum(early.values()) + sum(late.values())) // 60 + 1
+    return bound
+
+
+def create_balance_penalty(solver, flat_vars, balance):
+    """
+    Returns an expression for the penalty for uneven weekday loads: `balance` for each
+    hour between the longest and the shortest weekday (days with nothing included),
+    summed over the semesters.
+    """
+    load_for_day = {}
+    for v in flat_vars:
+        if v.day in BALANCED_DAYS:
+            load_for_day.setdefault((v.semester, v.day), []).append(v.duration * v.PerformedExpr())
+
+    penalties = []
+    for semester in sorted({semester for semester, _ in load_for_day}):
+        loads = [solver.Sum(load_for_day.get((semester, day), [])) for day in BALANCED_DAYS]
+        penalties.append((solver.Max(loads) - solver.Min(loads)) * balance // 60)
+    return solver.Sum(penalties)
+
+
+def create_back_to_back_penalty(solver, flat_vars, back_to_back):
+    """
+    Returns an expression for the penalty for heavy courses stacked back to back:
+    `back_to_back` for each two events of different heavy courses (see Settings.is_heavy())
+    which are back to back.
+    """
+    heavy = [v for v in flat_vars if v.heavy]
+    penalties = []
+    for i, v in enumerate(heavy):
+        for w in heavy[i + 1:]:
+            if v.course_index != w.course_index and events_back_to_back(v.event, w.event):
+                penalties.append(back_to_back * v.PerformedExpr() * w.PerformedExpr())
+    return solver.Sum(penalties)
+
+
+def create_heavy_day_constraints(solver, flat_vars, max_heavy_hours):
+    """
+    Limits the time spent in events of heavy courses on each day (in the weeks of each parity).
+    """
+    load_for_day = {}
+    for v in flat_vars:
+        if not v.heavy:
+            continue
+        for parity in (ODD_WEEKS, EVEN_WEEKS):
+            if v.event.takes_place_in(parity):
+                load_for_day.setdefault((v.semester, v.day, parity), []).append(v.duration * v.PerformedExpr())
+    for load in load_for_day.values():
+        solver.Add(solver.Sum(load) <= max_heavy_hours * 60)
+
+
+def create_commute_penalty(solver, flat_vars, commute):
+    """
+    Returns an expression for the penalty for the days which require leaving home too early
+    or getting home too late (see Commute), by the earliest and the latest event of each day.
+    """
+    early_for_day, late_for_day = {}, {}
+    for v in flat_vars:
+        early, late = commute.early_minutes(v.event), commute.late_minutes(v.event)
+        if early:
+            early_for_day.setdefault((v.semester, v.day), []).append(early * v.PerformedExpr())
+        if late:
+            late_for_day.setdefault((v.semester, v.day), []).append(late * v.PerformedExpr())
+    minutes = [solver.Max(exprs) for exprs in list(early_for_day.values()) + list(late_for_day.values())]
+    if not minutes:
+        return solver.Sum([])
+    return solver.Sum(minutes) * commute.penalty // 60
+
+
+def commute_minutes(events, commute):
+    """
+    Returns how many minutes before leave_after and after home_by the events make the
+    student leave and get home, summed over the days, and the numbers of such days, as
+    penalized by create_commute_penalty().
+    """
+    early_for_day, late_for_day = {}, {}
+    for e in events:
+        key = (e.semester, e.day)
+        early_for_day[key] = max(early_for_day.get(key, 0), commute.early_minutes(e))
+        late_for_day[key] = max(late_for_day.get(key, 0), commute.late_minutes(e))
+    early, late = list(early_for_day.values()), list(late_for_day.values())
+    return sum(early) + sum(late), sum(1 for m in early if m), sum(1 for m in late if m)
+
+
+def weekly_commutes(events):
+    """
+    Returns how many times a week the student goes to school and back (twice for each day
+    with events), on average over odd and even weeks, in the semester with the most of them.
+    """
+    days = {}
+    for e in events:
+        for parity in (ODD_WEEKS, EVEN_WEEKS):
+            if e.takes_place_in(parity):
+                days.setdefault((e.semester, parity), set()).add(e.day)
+    trips = {}
+    for (semester, _), d in days.items():
+        trips[semester] = trips.get(semester, 0) + len(d)  # Two trips a day, halved for the two parities
+    return max(trips.values(), default=0)
+
+
+def events_back_to_back(e, f):
+    """
+    Whether one of the events starts at most BACK_TO_BACK_GAP minutes after the other one ends,
+    in the same weeks.
+    """
+    if e.semester != f.semester or e.day != f.day or not e.shares_week_with(f):
+        return False
+    gap = max(time_to_int(e.time_from), time_to_int(f.time_from)) - min(time_to_int(e.time_to),
+                                                                       time_to_int(f.time_to))
+    return 0 <= gap <= BACK_TO_BACK_GAP
+
+
+def heavy_day_minutes(events):
+    """
+    Given (event, heavy) pairs, returns the most minutes of heavy events in a day
+    (in the weeks of either parity), as limited by create_heavy_day_constraints().
+    """
+    load_for_day = {}
+    for e, heavy in events:
+        if not heavy:
+            continue
+        for parity in (ODD_WEEKS, EVEN_WEEKS):
+            if e.takes_place_in(parity):
+                key = (e.semester, e.day, parity)
+                load_for_day[key] = load_for_day.get(key, 0) + time_to_int(e.time_to) - time_to_int(e.time_from)
+    return max(load_for_day.values(), default=0)
+
+
+def day_load_spreads(events):
+    """
+    Returns a dict mapping each semester to the difference (in minutes) between
+    its longest and shortest weekday, as penalized by create_balance_penalty().
+    """
+    loads = {}
+    for e in events:
+        if e.day in BALANCED_DAYS:
+            loads.setdefault(e.semester, [0] * len(BALANCED_DAYS))[e.day] += \
+                time_to_int(e.time_to) - time_to_int(e.time_from)
+    return {semester: max(l) - min(l) for semester, l in loads.items()}
+
+
+def split_by_semester(courses, selection):
+    """
+    Returns the selected courses of each semester, with their credits.
+    """
+    semesters = {}
+    for course_index, (course, opt_index) in enumerate(zip(courses, selection)):
+        if opt_index is None:
+            continue
+        s = semesters.setdefault(course.semester, {"semester": course.semester, "courses": [], "credits": 0})
+        s["courses"].append(course_index)
+        s["credits"] += course.credits
+    return [semesters[s] for s in sorted(semesters)]
+
+
 def time_to_int(t):
+    if t == MIDNIGHT:
+        return 24 * 60
     return t.hour * 60 + t.minute
@@ -0,0 +1,38 @@
+package source
+
+import (
+	"context"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The name of the SIS source, the default one
+const SIS = "sis"
+
+func init() {
+	Register(SIS, func(config string) (Source, error) {
+		return sisSource{}, nil
+	})
+}
+
+// Scrapes the SIS of Charles University (see sisparse, which is also
+// configured directly, see sisparse.SetDefault).
+type sisSource struct{}
+
+func (sisSource) GetCourseEvents(ctx context.Context, code string, semester int) ([][]sisparse.Event, error) {
+	return sisparse.GetSemesterCourseEvents(ctx, code, semester)
+}
+
+// SIS can only be searched through a form needing a session, which
+// sisparse doesn't do; the server searches the courses it has cached instead.
+func (sisSource) SearchCourses(ctx context.Context, query string) ([]CourseInfo, error) {
+	return nil, ErrNotSupported
+}
+
+func (sisSource) GetCourseInfo(ctx context.Context, code string, semester int) (CourseInfo, error) {
+	groups, err := sisparse.GetSemesterCourseEvents(ctx, code, semester)
+	if err != nil {
+		return CourseInfo{}, err
+	}
+	return InfoFromGroups(code, semester, groups), nil
+}
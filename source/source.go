@@ -0,0 +1,104 @@
+// Package source abstracts where course data come from, so that the server
+// can get them from systems other than the SIS of Charles University (which
+// sisparse scrapes) without further changes. A source is chosen by name,
+// see Register and Open.
+package source
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// Returned by the methods a source can't provide, such as searching.
+var ErrNotSupported = errors.New("Not supported by this source")
+
+// What is known about a course besides its schedule.
+type CourseInfo struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Semester int    `json:"semester"`
+	// "cs" or "en", empty if unknown or if the groups differ
+	Language string `json:"language,omitempty"`
+	// The components of the course, see sisparse.Course
+	Components      []sisparse.EventType `json:"components"`
+	SeminarOptional bool                 `json:"seminar_optional,omitempty"`
+}
+
+type Source interface {
+	// Returns the groups of events of the course as
+	// sisparse.GetSemesterCourseEvents does.
+	GetCourseEvents(ctx context.Context, code string, semester int) ([][]sisparse.Event, error)
+	// Returns the courses whose code or name matches the query.
+	SearchCourses(ctx context.Context, query string) ([]CourseInfo, error)
+	GetCourseInfo(ctx context.Context, code string, semester int) (CourseInfo, error)
+}
+
+// Creates a source from its configuration (whose meaning is up to the source,
+// e.g. a directory or a URL).
+type Factory func(config string) (Source, error)
+
+var mu sync.Mutex
+var factories = map[string]Factory{}
+
+// Makes a source available under the given name. Sources register
+// themselves from init().
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("source: registered twice: " + name)
+	}
+	factories[name] = f
+}
+
+// Creates the source registered under the given name.
+func Open(name string, config string) (Source, error) {
+	mu.Lock()
+	f, ok := factories[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("Unknown course source %s (known: %v)", name, Names())
+	}
+	return f(config)
+}
+
+// Returns the names of the registered sources.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	res := []string{}
+	for name := range factories {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Describes a course from its groups, for sources which don't know more.
+func InfoFromGroups(code string, semester int, groups [][]sisparse.Event) CourseInfo {
+	course := sisparse.NewCourse(code, semester, groups)
+	info := CourseInfo{
+		Code:            code,
+		Name:            course.Name,
+		Semester:        semester,
+		Components:      course.Components(),
+		SeminarOptional: course.SeminarOptional,
+	}
+	languages := map[string]bool{}
+	for _, group := range groups {
+		for _, e := range group {
+			languages[e.Language] = true
+		}
+	}
+	if len(languages) == 1 {
+		for l := range languages {
+			info.Language = l
+		}
+	}
+	return info
+}
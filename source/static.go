@@ -0,0 +1,181 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/iamwave/samorozvrh/sisparse"
+)
+
+// The name of the static source, configured by the directory with the files
+const STATIC = "static"
+
+func init() {
+	Register(STATIC, func(config string) (Source, error) {
+		if config == "" {
+			return nil, fmt.Errorf("The static source needs a directory (-source-config)")
+		}
+		info, err := os.Stat(config)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", config)
+		}
+		return staticSource{dir: config}, nil
+	})
+}
+
+// Loads courses from files in a directory, for offline demos, tests, or
+// curated data. The courses of the winter semester are in <code>.json or
+// <code>.html, those of the summer semester in <code>@2.json or <code>@2.html:
+//   - a JSON file contains the groups of events as /sisquery/ returns them,
+//     either just the array of groups or the whole {"data": [...]} answer,
+//   - an HTML file is a saved schedule page of SIS (see sisparse.ParseSchedule).
+//
+// The files are read on each request, so they can be changed while the
+// server is running.
+type staticSource struct {
+	dir string
+}
+
+func (s staticSource) GetCourseEvents(ctx context.Context, code string, semester int) ([][]sisparse.Event, error) {
+	base := code
+	if semester != sisparse.Winter {
+		base = fmt.Sprintf("%s@%d", code, semester)
+	}
+	if strings.ContainsAny(base, `/\`) {
+		return nil, sisparse.ErrScheduleNotFound
+	}
+
+	path := filepath.Join(s.dir, base+".json")
+	if data, err := ioutil.ReadFile(path); err == nil {
+		groups, err := parseStaticJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s: %s", path, err)
+		}
+		// Curated files needn't repeat what their name says
+		for _, group := range groups {
+			for i := range group {
+				if group[i].CourseCode == "" {
+					group[i].CourseCode = code
+				}
+				if group[i].Semester == 0 {
+					group[i].Semester = semester
+				}
+			}
+		}
+		sisparse.RecordFetch(ctx, "file:"+base+".json", nil)
+		return groups, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, base+".html"))
+	if os.IsNotExist(err) {
+		return nil, sisparse.ErrScheduleNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	groups, warnings, err := sisparse.ParseScheduleWarnings(f, code, semester, sisparse.ModeOf(ctx))
+	sisparse.RecordFetch(ctx, "file:"+base+".html", warnings)
+	return groups, err
+}
+
+func parseStaticJSON(data []byte) ([][]sisparse.Event, error) {
+	var groups [][]sisparse.Event
+	if err := json.Unmarshal(data, &groups); err == nil {
+		return groups, nil
+	}
+	var answer struct {
+		Data [][]sisparse.Event `json:"data"`
+	}
+	if err := json.Unmarshal(data, &answer); err != nil {
+		return nil, err
+	}
+	return answer.Data, nil
+}
+
+// Matches the query against the codes and names of all courses in the
+// directory; every word of the query must be a part of one of them.
+func (s staticSource) SearchCourses(ctx context.Context, query string) ([]CourseInfo, error) {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return []CourseInfo{}, nil
+	}
+	courses, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	res := []CourseInfo{}
+	for _, c := range courses {
+		info, err := s.GetCourseInfo(ctx, c.code, c.semester)
+		if err != nil {
+			continue
+		}
+		text := strings.ToLower(info.Code + " " + info.Name)
+		matches := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			res = append(res, info)
+		}
+	}
+	return res, nil
+}
+
+func (s staticSource) GetCourseInfo(ctx context.Context, code string, semester int) (CourseInfo, error) {
+	groups, err := s.GetCourseEvents(ctx, code, semester)
+	if err != nil {
+		return CourseInfo{}, err
+	}
+	return InfoFromGroups(code, semester, groups), nil
+}
+
+type staticCourse struct {
+	code     string
+	semester int
+}
+
+// Returns the courses which have a file in the directory, sorted.
+func (s staticSource) list() ([]staticCourse, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[staticCourse]bool{}
+	res := []staticCourse{}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || (ext != ".json" && ext != ".html") {
+			continue
+		}
+		c := staticCourse{code: strings.TrimSuffix(f.Name(), ext), semester: sisparse.Winter}
+		if i := strings.Index(c.code, "@"); i >= 0 {
+			fmt.Sscanf(c.code[i+1:], "%d", &c.semester)
+			c.code = c.code[:i]
+		}
+		if !seen[c] {
+			seen[c] = true
+			res = append(res, c)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].code != res[j].code {
+			return res[i].code < res[j].code
+		}
+		return res[i].semester < res[j].semester
+	})
+	return res, nil
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	// The SQL statements to run. "{{serial}}" is replaced by the type of an
+	// auto-incremented primary key, which differs between the databases.
+	Statements []string
+}
+
+// All migrations, in order. Never change a migration which has been released,
+// add a new one instead.
+var migrations = []Migration{
+	{1, "initial schema", []string{
+		`CREATE TABLE IF NOT EXISTS cache (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id TEXT PRIMARY KEY,
+			status TEXT NOT NULL,
+			request TEXT NOT NULL,
+			result TEXT NOT NULL,
+			created TIMESTAMP NOT NULL,
+			updated TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS schedules (
+			token TEXT PRIMARY KEY,
+			data TEXT NOT NULL,
+			created TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS subscriptions (
+			id {{serial}},
+			course_code TEXT NOT NULL,
+			target TEXT NOT NULL,
+			created TIMESTAMP NOT NULL
+		)`,
+	}},
+	{2, "index subscriptions by course", []string{
+		`CREATE INDEX IF NOT EXISTS subscriptions_course_code ON subscriptions (course_code)`,
+	}},
+	{3, "index jobs by status", []string{
+		`CREATE INDEX IF NOT EXISTS jobs_status ON jobs (status)`,
+	}},
+	{4, "api tokens", []string{
+		`CREATE TABLE IF NOT EXISTS tokens (
+			hash TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			created TIMESTAMP NOT NULL
+		)`,
+	}},
+	{5, "job owners and shared counters", []string{
+		`ALTER TABLE jobs ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+		`CREATE TABLE IF NOT EXISTS counters (
+			key TEXT NOT NULL,
+			slot BIGINT NOT NULL,
+			count INTEGER NOT NULL,
+			PRIMARY KEY (key, slot)
+		)`,
+	}},
+	{6, "preference profiles", []string{
+		`CREATE TABLE IF NOT EXISTS profiles (
+			id TEXT PRIMARY KEY,
+			owner_hash TEXT NOT NULL,
+			name TEXT NOT NULL,
+			data TEXT NOT NULL,
+			created TIMESTAMP NOT NULL,
+			updated TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS profiles_owner_hash ON profiles (owner_hash)`,
+	}},
+	{7, "solve history of users", []string{
+		`ALTER TABLE jobs ADD COLUMN user_hash TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX IF NOT EXISTS jobs_user_hash ON jobs (user_hash, created)`,
+	}},
+	{8, "fill samples of groups", []string{
+		`CREATE TABLE IF NOT EXISTS fill_samples (
+			course TEXT NOT NULL,
+			group_id TEXT NOT NULL,
+			enrolled INTEGER NOT NULL,
+			capacity INTEGER NOT NULL,
+			sampled TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS fill_samples_course ON fill_samples (course, sampled)`,
+	}},
+	{9, "email notifications", []string{
+		`CREATE INDEX IF NOT EXISTS subscriptions_target ON subscriptions (target)`,
+		`CREATE TABLE IF NOT EXISTS course_changes (
+			id {{serial}},
+			course_code TEXT NOT NULL,
+			summary TEXT NOT NULL,
+			changed TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS course_changes_course_code ON course_changes (course_code, changed)`,
+		`CREATE TABLE IF NOT EXISTS notify_settings (
+			user_hash TEXT PRIMARY KEY,
+			email TEXT NOT NULL,
+			language TEXT NOT NULL,
+			code TEXT NOT NULL,
+			confirmed BOOLEAN NOT NULL,
+			course_changes BOOLEAN NOT NULL,
+			job_done BOOLEAN NOT NULL,
+			digest BOOLEAN NOT NULL,
+			last_digest TIMESTAMP NOT NULL,
+			updated TIMESTAMP NOT NULL
+		)`,
+	}},
+	{10, "notification channels", []string{
+		`ALTER TABLE notify_settings ADD COLUMN channels TEXT NOT NULL DEFAULT 'email'`,
+		`CREATE INDEX IF NOT EXISTS notify_settings_code ON notify_settings (code)`,
+	}},
+	{11, "retention of saved schedules", []string{
+		`CREATE INDEX IF NOT EXISTS schedules_created ON schedules (created)`,
+	}},
+	{12, "crawler leases", []string{
+		`CREATE TABLE IF NOT EXISTS leases (
+			name TEXT PRIMARY KEY,
+			owner TEXT NOT NULL,
+			expires TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS crawled (
+			course TEXT PRIMARY KEY,
+			crawled TIMESTAMP NOT NULL
+		)`,
+	}},
+	{13, "owners of saved schedules", []string{
+		`ALTER TABLE schedules ADD COLUMN owner_hash TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE schedules ADD COLUMN user_hash TEXT NOT NULL DEFAULT ''`,
+	}},
+}
+
+// The key of the PostgreSQL advisory lock held while migrating, the same
+// for all instances sharing the database
+const migrationLockKey = 0x73616d6f726f7a // "samoroz"
+
+// Brings the database schema up to date by running the migrations which
+// haven't been applied yet. Returns the migrations which were (or, with
+// dryRun, would be) applied. On PostgreSQL, instances starting at the same
+// time migrate one after another, so that each migration runs once; SQLite
+// databases are only used by a single server.
+func (s *SQLStore) Migrate(dryRun bool) ([]Migration, error) {
+	if s.driver == POSTGRES {
+		unlock, err := s.lockMigrations()
+		if err != nil {
+			return nil, fmt.Errorf("Could not lock the schema for migrations: %s", err)
+		}
+		defer unlock()
+	}
+	_, err := s.exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	var current int
+	err = s.queryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := []Migration{}
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	if dryRun {
+		return pending, nil
+	}
+	for i, m := range pending {
+		if err := s.applyMigration(m); err != nil {
+			return pending[:i], fmt.Errorf("Migration %d (%s) failed: %s", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// Waits for the other instances to finish their migrations and takes the
+// advisory lock, which is held by a connection of its own until unlock is
+// called (or the connection breaks).
+func (s *SQLStore) lockMigrations() (unlock func(), err error) {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey)
+		conn.Close()
+	}, nil
+}
+
+func (s *SQLStore) applyMigration(m Migration) error {
+	serial := "INTEGER PRIMARY KEY AUTOINCREMENT"
+	if s.driver == POSTGRES {
+		serial = "BIGSERIAL PRIMARY KEY"
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range m.Statements {
+		if _, err := tx.Exec(strings.Replace(stmt, "{{serial}}", serial, -1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec(s.rebind(`INSERT INTO schema_migrations (version, name, applied) VALUES (?, ?, ?)`),
+		m.Version, m.Name, time.Now().UTC())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
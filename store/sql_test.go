@@ -0,0 +1,149 @@
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Opens a new SQLite store, closed when the test ends.
+func openTestStore(t *testing.T) *SQLStore {
+	s, err := Open(SQLITE, filepath.Join(t.TempDir(), "samorozvrh.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestIncrementCounter(t *testing.T) {
+	s := openTestStore(t)
+	for i, want := range []int{1, 2, 3} {
+		if got, err := s.IncrementCounter("sis", 100); err != nil || got != want {
+			t.Errorf("Increment %d of a slot: %d, %v, want %d", i+1, got, err, want)
+		}
+	}
+	if got, err := s.IncrementCounter("sis", 101); err != nil || got != 1 {
+		t.Errorf("Increment of a new slot: %d, %v, want 1", got, err)
+	}
+	if got, err := s.IncrementCounter("other", 100); err != nil || got != 1 {
+		t.Errorf("Increment of another key: %d, %v, want 1", got, err)
+	}
+}
+
+func TestAddToCounter(t *testing.T) {
+	s := openTestStore(t)
+	for i, c := range []struct{ n, want int }{{3, 3}, {0, 3}, {4, 7}} {
+		if got, err := s.AddToCounter("quota-solve:addr:10.0.0.1", 100, c.n); err != nil || got != c.want {
+			t.Errorf("Addition %d of %d: %d, %v, want %d", i+1, c.n, got, err, c.want)
+		}
+	}
+	// Checking a new slot with 0 starts it at 0
+	if got, err := s.AddToCounter("quota-solve:addr:10.0.0.1", 101, 0); err != nil || got != 0 {
+		t.Errorf("Addition of 0 to a new slot: %d, %v, want 0", got, err)
+	}
+}
+
+func TestCountersDropOldSlots(t *testing.T) {
+	s := openTestStore(t)
+	for _, slot := range []int64{100, 101, 100 + keptCounterSlots} {
+		if _, err := s.IncrementCounter("sis", slot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first event of a new slot drops the slots keptCounterSlots before it
+	if _, err := s.IncrementCounter("sis", 101+keptCounterSlots); err != nil {
+		t.Fatal(err)
+	}
+	counters, err := s.ListCounters("sis", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Counter{
+		{Key: "sis", Slot: 101, Count: 1},
+		{Key: "sis", Slot: 100 + keptCounterSlots, Count: 1},
+		{Key: "sis", Slot: 101 + keptCounterSlots, Count: 1},
+	}
+	if !reflect.DeepEqual(counters, want) {
+		t.Errorf("Counters %+v, want %+v", counters, want)
+	}
+}
+
+func TestListCounters(t *testing.T) {
+	s := openTestStore(t)
+	for _, c := range []struct {
+		key  string
+		slot int64
+	}{{"requests:NPRG030", 5}, {"requests:NPRG030", 6}, {"requests:NTIN061", 6}, {"requests_x", 6}, {"sis", 6}} {
+		if _, err := s.IncrementCounter(c.key, c.slot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counters, err := s.ListCounters("requests:", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Counter{{Key: "requests:NPRG030", Slot: 6, Count: 1}, {Key: "requests:NTIN061", Slot: 6, Count: 1}}
+	if !reflect.DeepEqual(counters, want) {
+		t.Errorf("Counters %+v, want %+v", counters, want)
+	}
+	// "_" is no wildcard in the prefix
+	if counters, err := s.ListCounters("requests_", 0); err != nil || len(counters) != 1 {
+		t.Errorf("Counters of requests_: %+v, %v", counters, err)
+	}
+}
+
+func TestLeases(t *testing.T) {
+	s := openTestStore(t)
+	acquire := func(owner string, ttl time.Duration, want bool) {
+		t.Helper()
+		if got, err := s.AcquireLease("crawler", owner, ttl); err != nil || got != want {
+			t.Errorf("AcquireLease by %s: %v, %v, want %v", owner, got, err, want)
+		}
+	}
+	acquire("a", time.Hour, true)
+	acquire("a", time.Hour, true) // renewed
+	acquire("b", time.Hour, false)
+	if got, err := s.AcquireLease("other", "b", time.Hour); err != nil || !got {
+		t.Errorf("AcquireLease of another lease: %v, %v", got, err)
+	}
+
+	// Only the owner releases it
+	if err := s.ReleaseLease("crawler", "b"); err != nil {
+		t.Fatal(err)
+	}
+	acquire("b", time.Hour, false)
+	if err := s.ReleaseLease("crawler", "a"); err != nil {
+		t.Fatal(err)
+	}
+	acquire("b", time.Millisecond, true)
+
+	// Anyone takes it over once it expires
+	time.Sleep(20 * time.Millisecond)
+	acquire("a", time.Hour, true)
+	acquire("b", time.Hour, false)
+}
+
+func TestListCrawled(t *testing.T) {
+	s := openTestStore(t)
+	at := time.Date(2024, 10, 7, 3, 0, 0, 0, time.UTC)
+	for i, course := range []string{"NPRG030", "NPRG031", "tenant/NPRG030", "NPRG030"} {
+		if err := s.SetCrawled(course, at.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crawled, err := s.ListCrawled("NPRG")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]time.Time{"NPRG030": at.Add(3 * time.Hour), "NPRG031": at.Add(time.Hour)}
+	if len(crawled) != len(want) {
+		t.Fatalf("Crawled %v, want %v", crawled, want)
+	}
+	for course, w := range want {
+		if !crawled[course].Equal(w) {
+			t.Errorf("%s crawled %s, want %s", course, crawled[course], w)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Package store persists the server's data: cached SIS answers, solve jobs,
+// saved schedules, preference profiles, subscriptions to course changes (and
+// the changes themselves), how users want to be notified of them and how full
+// the groups of courses get.
+package store
+
+import (
+	"errors"
+	"time"
+)
+
+// Returned when the requested item does not exist.
+var ErrNotFound = errors.New("Not found")
+
+type CacheEntry struct {
+	Key     string
+	Value   string
+	Updated time.Time
+}
+
+// A request to the solver and (once it is finished) its result.
+type Job struct {
+	Id      string
+	Status  string
+	Request string
+	Result  string
+	// The instance of the server which runs the job, when several share the store
+	Owner string
+	// A hash of the token of the user who sent the request, if they sent one
+	UserHash string
+	Created  time.Time
+	Updated  time.Time
+}
+
+// A schedule saved by a user, identified by a random token.
+type Schedule struct {
+	Token string
+	Data  string
+	// The hash of the secret token given to whoever saved the schedule,
+	// which is needed to delete it ("" for schedules saved before)
+	OwnerHash string
+	// The user who saved it, "" if unknown
+	UserHash string
+	Created  time.Time
+}
+
+// A request to be notified (at Target) when the schedule of a course changes.
+type Subscription struct {
+	Id         int64
+	CourseCode string
+	Target     string
+	Created    time.Time
+}
+
+// A change of the schedule of a course, kept for the digests of changes.
+type CourseChange struct {
+	Id         int64
+	CourseCode string
+	// What changed, one line per change
+	Summary string
+	Changed time.Time
+}
+
+// How a user (identified like the owner of a Profile) wants to be notified.
+// Nothing is emailed before the address is confirmed.
+type NotifySettings struct {
+	UserHash string
+	Email    string
+	Language string
+	// A random code which the links in the emails carry, so that they can
+	// confirm the address and unsubscribe without logging in
+	Code      string
+	Confirmed bool
+	// Whether to send alerts of changes of the subscribed courses, when
+	// solve requests finish and weekly digests of the changes
+	CourseChanges bool
+	JobDone       bool
+	Digest        bool
+	// Where to send the notifications: "email" (to Email) or the targets of
+	// chats linked to the user, e.g. "telegram:123"
+	Channels   []string
+	LastDigest time.Time
+	Updated    time.Time
+}
+
+// Named solver preferences saved by a user. Users are identified by a random
+// token, of which only a hash is kept, like of API tokens; the profile can be
+// used by anyone who knows its Id.
+type Profile struct {
+	Id        string
+	OwnerHash string
+	Name      string
+	Data      string // JSON
+	Created   time.Time
+	Updated   time.Time
+}
+
+// The count of a counter in a time slot, see Store.IncrementCounter.
+type Counter struct {
+	Key   string
+	Slot  int64
+	Count int
+}
+
+// How full a group of a course was at some moment, see Store.AddFillSamples.
+type FillSample struct {
+	// The course as named in the cache, e.g. "NPRG030" or "NPRG030@2"
+	Course   string
+	GroupID  string
+	Enrolled int
+	Capacity int
+	Sampled  time.Time
+}
+
+// An API token. Only a hash of the token itself is kept.
+type Token struct {
+	Hash    string
+	Name    string
+	Scopes  []string
+	Created time.Time
+}
+
+type Store interface {
+	GetCache(key string) (CacheEntry, error)
+	SetCache(key string, value string) error
+	// Returns the keys of all cache entries starting with the prefix
+	ListCache(prefix string) ([]string, error)
+
+	GetJob(id string) (Job, error)
+	SaveJob(job Job) error
+	// Returns the jobs with the given status, the oldest first
+	ListJobs(status string) ([]Job, error)
+	// Returns at most limit jobs of the user, the newest first
+	ListUserJobs(userHash string, limit int) ([]Job, error)
+	// Drops the jobs with the status last updated before the given time
+	// and returns how many there were
+	DeleteJobs(status string, before time.Time) (int, error)
+	// Drops all jobs of the user and returns how many there were
+	DeleteUserJobs(userHash string) (int, error)
+
+	GetSchedule(token string) (Schedule, error)
+	SaveSchedule(schedule Schedule) error
+	DeleteSchedule(token string) error
+	// Returns all saved schedules, the oldest first
+	ListSchedules() ([]Schedule, error)
+	// Drops the schedules saved before the given time and returns how many
+	// there were
+	DeleteSchedules(before time.Time) (int, error)
+
+	AddSubscription(sub Subscription) (int64, error)
+	ListSubscriptions(courseCode string) ([]Subscription, error)
+	// Returns the subscriptions with the given target
+	ListTargetSubscriptions(target string) ([]Subscription, error)
+	DeleteSubscription(id int64) error
+
+	AddCourseChange(change CourseChange) error
+	// Returns the changes of the course since the given time, the oldest first
+	ListCourseChanges(courseCode string, since time.Time) ([]CourseChange, error)
+	// Drops the changes made before the given time
+	DeleteCourseChanges(before time.Time) error
+
+	GetNotifySettings(userHash string) (NotifySettings, error)
+	// Returns the settings with the given code
+	FindNotifySettings(code string) (NotifySettings, error)
+	// Returns the settings of all users
+	ListNotifySettings() ([]NotifySettings, error)
+	SaveNotifySettings(settings NotifySettings) error
+	DeleteNotifySettings(userHash string) error
+
+	GetProfile(id string) (Profile, error)
+	// Returns the profiles of the owner ordered by name
+	ListProfiles(ownerHash string) ([]Profile, error)
+	// Returns the profiles of all owners
+	ListAllProfiles() ([]Profile, error)
+	SaveProfile(profile Profile) error
+	DeleteProfile(id string) error
+	// Drops all profiles of the owner and returns how many there were
+	DeleteProfiles(ownerHash string) (int, error)
+
+	GetToken(hash string) (Token, error)
+	SaveToken(token Token) error
+	DeleteToken(hash string) error
+
+	// Counts an event (such as a request to SIS) in the given time slot and
+	// returns the count of the slot so far, including other servers sharing
+	// the store. Counts of old slots are dropped.
+	IncrementCounter(key string, slot int64) (int, error)
+	// Like IncrementCounter, but counts n at once (e.g. seconds of work)
+	AddToCounter(key string, slot int64, n int) (int, error)
+	// Returns the slots of the counters whose keys start with the prefix
+	// from the given slot on
+	ListCounters(prefix string, fromSlot int64) ([]Counter, error)
+
+	// Records how full the groups of courses are now (the Sampled time of
+	// the samples is set if it's zero)
+	AddFillSamples(samples []FillSample) error
+	// Returns the samples of the groups of the course, the oldest first
+	ListFillSamples(course string) ([]FillSample, error)
+
+	// Takes the lease of the name for the owner until ttl from now, if
+	// nobody else holds it (an expired lease is free). The holder renews it
+	// by acquiring it again. Reports whether the owner holds the lease.
+	AcquireLease(name, owner string, ttl time.Duration) (bool, error)
+	// Gives up the lease, if the owner holds it
+	ReleaseLease(name, owner string) error
+
+	// Records when the course (as named in the cache) was last crawled
+	SetCrawled(course string, crawled time.Time) error
+	// Returns when the courses whose names start with the prefix were last
+	// crawled, by name
+	ListCrawled(prefix string) (map[string]time.Time, error)
+
+	// Checks that the store is reachable
+	Ping() error
+	Close() error
+}
@@ -0,0 +1,201 @@
+// Package travel loads where the buildings of a faculty are and how many
+// minutes it takes to get between them, for telling which breaks are too
+// short to change buildings. Times missing from a dataset can be computed
+// from the coordinates of the buildings by a routing service, see Router.
+package travel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A building, by the prefix of the names of its rooms (e.g. "S" for "S5")
+type Building struct {
+	Name string  `json:"name,omitempty"`
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+}
+
+// E.g. {"buildings": {"S": {"name": "Malá Strana", "lat": 50.0884,
+// "lon": 14.4040}, ...}, "minutes": {"S": {"K": 25}, ...}}, see travel.json
+// in the repository.
+type Dataset struct {
+	// Those with coordinates, by the prefix of their rooms
+	Buildings map[string]Building `json:"buildings"`
+	// Minutes it takes to get from a building to another, in either
+	// direction unless given both ways
+	Minutes map[string]map[string]int `json:"minutes"`
+}
+
+// Loads a dataset from a JSON file. A file of just the minutes,
+// {"<building>": {"<building>": 10}}, is read as well.
+func Load(filename string) (*Dataset, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("Invalid travel times in %s: %s", filename, err)
+	}
+	d := &Dataset{}
+	_, hasBuildings := fields["buildings"]
+	_, hasMinutes := fields["minutes"]
+	if hasBuildings || hasMinutes {
+		err = json.Unmarshal(data, d)
+	} else {
+		err = json.Unmarshal(data, &d.Minutes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid travel times in %s: %s", filename, err)
+	}
+	if d.Buildings == nil {
+		d.Buildings = map[string]Building{}
+	}
+	if d.Minutes == nil {
+		d.Minutes = map[string]map[string]int{}
+	}
+	for from, to := range d.Minutes {
+		for b, m := range to {
+			if m < 0 {
+				return nil, fmt.Errorf("Negative travel time from %s to %s in %s", from, b, filename)
+			}
+		}
+	}
+	return d, nil
+}
+
+// Returns the minutes it takes to get between the buildings, false if they
+// aren't known.
+func (d *Dataset) Between(from, to string) (int, bool) {
+	if m, ok := d.Minutes[from][to]; ok {
+		return m, true
+	}
+	m, ok := d.Minutes[to][from]
+	return m, ok
+}
+
+func (d *Dataset) set(from, to string, minutes int) {
+	if d.Minutes[from] == nil {
+		d.Minutes[from] = map[string]int{}
+	}
+	d.Minutes[from][to] = minutes
+}
+
+// Computes the times between the buildings with coordinates which the
+// dataset lacks by the router, returning how many it added.
+func (d *Dataset) FillMissing(ctx context.Context, r Router) (int, error) {
+	codes := []string{}
+	for code := range d.Buildings {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	missing := false
+	for i, a := range codes {
+		for _, b := range codes[i+1:] {
+			if _, ok := d.Between(a, b); !ok {
+				missing = true
+			}
+		}
+	}
+	if !missing {
+		return 0, nil
+	}
+	places := make([]Building, len(codes))
+	for i, code := range codes {
+		places[i] = d.Buildings[code]
+	}
+	table, err := r.Table(ctx, places)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for i, a := range codes {
+		for j, b := range codes {
+			if i == j || table[i][j] < 0 {
+				continue
+			}
+			if _, ok := d.Minutes[a][b]; ok {
+				continue
+			}
+			// Given one way, the other way is taken to be the same
+			if _, ok := d.Minutes[b][a]; ok {
+				continue
+			}
+			d.set(a, b, table[i][j])
+			added++
+		}
+	}
+	return added, nil
+}
+
+// Computes how long it takes to get between places.
+type Router interface {
+	// Returns the minutes from each place to each other one, [i][j] from i
+	// to j, -1 where there is no route.
+	Table(ctx context.Context, places []Building) ([][]int, error)
+}
+
+// The table service of OSRM (see http://project-osrm.org/docs/v5.24.0/api/#table-service),
+// e.g. at "https://routing.openstreetmap.de/routed-foot/table/v1/foot" for
+// walking; the coordinates of the places are appended to the URL.
+type OSRM struct {
+	Url    string
+	Client *http.Client
+}
+
+func NewOSRM(url string) *OSRM {
+	return &OSRM{Url: strings.TrimRight(url, "/"), Client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (o *OSRM) Table(ctx context.Context, places []Building) ([][]int, error) {
+	coordinates := make([]string, len(places))
+	for i, p := range places {
+		coordinates[i] = fmt.Sprintf("%f,%f", p.Lon, p.Lat)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", o.Url+"/"+strings.Join(coordinates, ";")+"?annotations=duration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Code    string
+		Message string
+		// Seconds, null where there is no route
+		Durations [][]*float64
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("Invalid answer of the router (%s): %s", resp.Status, err)
+	}
+	if res.Code != "Ok" {
+		return nil, fmt.Errorf("The router failed: %s %s", res.Code, res.Message)
+	}
+	if len(res.Durations) != len(places) {
+		return nil, fmt.Errorf("The router returned %d rows for %d places", len(res.Durations), len(places))
+	}
+	table := make([][]int, len(places))
+	for i, row := range res.Durations {
+		if len(row) != len(places) {
+			return nil, fmt.Errorf("The router returned %d columns for %d places", len(row), len(places))
+		}
+		table[i] = make([]int, len(places))
+		for j, seconds := range row {
+			table[i][j] = -1
+			if seconds != nil {
+				table[i][j] = int(math.Ceil(*seconds / 60))
+			}
+		}
+	}
+	return table, nil
+}